@@ -0,0 +1,152 @@
+package rapi
+
+import (
+	"context"
+
+	"github.com/konidev20/rapi/restic"
+)
+
+// BlobCount is a number of blobs and their size in the repository.
+type BlobCount struct {
+	Blobs int    `json:"blobs"`
+	Bytes uint64 `json:"bytes"`
+}
+
+func (c *BlobCount) add(size uint) {
+	c.Blobs++
+	c.Bytes += uint64(size)
+}
+
+// AnalyzeReport describes how the space in a repository is used.
+type AnalyzeReport struct {
+	Packs int    `json:"packs"`
+	Bytes uint64 `json:"bytes"`
+
+	// Used and Unused count the blobs which are referenced by at least one
+	// snapshot and those which are not, duplicates are counted once.
+	UsedTrees   BlobCount `json:"used_trees"`
+	UsedData    BlobCount `json:"used_data"`
+	UnusedTrees BlobCount `json:"unused_trees"`
+	UnusedData  BlobCount `json:"unused_data"`
+
+	// Duplicates counts the additional copies of blobs which are stored in
+	// several packs.
+	Duplicates BlobCount `json:"duplicates"`
+
+	// UnusedPacks contain no used blob, PartialPacks contain both used and
+	// unused blobs. MixedPacks contain both tree and data blobs.
+	UnusedPacks  int `json:"unused_packs"`
+	PartialPacks int `json:"partial_packs"`
+	MixedPacks   int `json:"mixed_packs"`
+
+	// Uncompressed counts the blobs which are stored without compression.
+	// CompressedSize and CompressedDataSize are the stored and the original
+	// size of all compressed blobs, EstimatedSavings estimates how much
+	// space compressing the uncompressed blobs would save using the ratio
+	// of the compressed blobs. The estimate is zero if nothing is compressed.
+	Uncompressed       BlobCount `json:"uncompressed"`
+	CompressedSize     uint64    `json:"compressed_size"`
+	CompressedDataSize uint64    `json:"compressed_data_size"`
+	EstimatedSavings   uint64    `json:"estimated_savings"`
+}
+
+type analyzePack struct {
+	used, unused bool
+	types        [restic.NumBlobTypes]bool
+}
+
+// Analyze reports unused and duplicate blobs, partly used and mixed packs and
+// the potential savings of compression. Apart from a non-exclusive lock,
+// which is held while the snapshots are traversed, the repository is not
+// modified. A read-only repository is analyzed without a lock.
+func Analyze(ctx context.Context, repo restic.Repository) (AnalyzeReport, error) {
+	var report AnalyzeReport
+
+	unlock, err := lockRepository(ctx, repo, false)
+	if err != nil {
+		return report, err
+	}
+	defer unlock()
+
+	if err := repo.LoadIndex(ctx, nil); err != nil {
+		return report, err
+	}
+	usedBlobs, _, err := pruneUsedBlobs(ctx, repo, 0)
+	if err != nil {
+		return report, err
+	}
+
+	// packs are classified like Prune does, a pack which only contains
+	// copies of used blobs stored elsewhere is unused
+	packs := make(map[restic.ID]*analyzePack)
+	seen := restic.NewBlobSet()
+	_, err = pruneEachBlob(ctx, repo, usedBlobs, func(pb restic.PackedBlob, used bool) {
+		p, ok := packs[pb.PackID]
+		if !ok {
+			p = &analyzePack{}
+			packs[pb.PackID] = p
+		}
+		p.types[pb.Type] = true
+
+		if used {
+			p.used = true
+		} else {
+			p.unused = true
+		}
+
+		if seen.Has(pb.BlobHandle) {
+			report.Duplicates.add(pb.Length)
+			return
+		}
+		seen.Insert(pb.BlobHandle)
+
+		switch {
+		case usedBlobs.Has(pb.BlobHandle) && pb.Type == restic.TreeBlob:
+			report.UsedTrees.add(pb.Length)
+		case usedBlobs.Has(pb.BlobHandle):
+			report.UsedData.add(pb.Length)
+		case pb.Type == restic.TreeBlob:
+			report.UnusedTrees.add(pb.Length)
+		default:
+			report.UnusedData.add(pb.Length)
+		}
+
+		if pb.IsCompressed() {
+			report.CompressedSize += uint64(pb.Length)
+			report.CompressedDataSize += uint64(pb.UncompressedLength)
+		} else {
+			report.Uncompressed.add(pb.Length)
+		}
+	})
+	if err != nil {
+		return report, err
+	}
+
+	for _, p := range packs {
+		switch {
+		case !p.used:
+			report.UnusedPacks++
+		case p.unused:
+			report.PartialPacks++
+		}
+		if p.types[restic.TreeBlob] && p.types[restic.DataBlob] {
+			report.MixedPacks++
+		}
+	}
+
+	err = repo.List(ctx, restic.PackFile, func(_ restic.ID, size int64) error {
+		report.Packs++
+		report.Bytes += uint64(size)
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	if report.CompressedDataSize > 0 {
+		ratio := float64(report.CompressedSize) / float64(report.CompressedDataSize)
+		report.EstimatedSavings = uint64(float64(report.Uncompressed.Bytes) * (1 - ratio))
+	}
+
+	return report, nil
+}
@@ -0,0 +1,48 @@
+package rapi_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/konidev20/rapi"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/restic"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestAnalyze(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.TestRepository(t)
+
+	_, used := savePack(t, repo, 1)
+	savePack(t, repo, 2)
+	saveSnapshotOf(t, repo, time.Now(), used)
+
+	// a second copy of the used blob, the pack containing it is unused
+	var wg errgroup.Group
+	repo.StartPackUploader(ctx, &wg)
+	_, _, _, err := repo.SaveBlob(ctx, restic.DataBlob, rtest.Random(1, 10000), used[0], true)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.Flush(ctx))
+	rtest.OK(t, wg.Wait())
+
+	report, err := rapi.Analyze(ctx, repo)
+	rtest.OK(t, err)
+	rtest.Equals(t, 4, report.Packs)
+	rtest.Equals(t, 1, report.UsedTrees.Blobs)
+	rtest.Equals(t, 1, report.UsedData.Blobs)
+	rtest.Equals(t, 1, report.UnusedData.Blobs)
+	rtest.Equals(t, 1, report.Duplicates.Blobs)
+	rtest.Equals(t, 2, report.UnusedPacks)
+	rtest.Equals(t, 0, report.PartialPacks)
+	rtest.Equals(t, 0, report.MixedPacks)
+
+	// prune removes exactly the packs reported as unused
+	stats, err := rapi.Prune(ctx, repo, rapi.PruneOptions{})
+	rtest.OK(t, err)
+	rtest.Equals(t, report.UnusedPacks, stats.RemovePacks)
+	rtest.Equals(t, report.UnusedData.Blobs+report.UnusedTrees.Blobs+report.Duplicates.Blobs, stats.UnusedBlobs)
+	rtest.Equals(t, 0, stats.RepackPacks)
+}
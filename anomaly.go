@@ -0,0 +1,57 @@
+package rapi
+
+import (
+	"context"
+	"sort"
+
+	"github.com/konidev20/rapi/anomaly"
+	"github.com/konidev20/rapi/restic"
+)
+
+// samplingRepository samples the entropy of the new data blobs saved by the
+// archiver.
+type samplingRepository struct {
+	restic.Repository
+	sampler *anomaly.Sampler
+}
+
+func (r *samplingRepository) SaveBlob(ctx context.Context, t restic.BlobType, buf []byte, id restic.ID, storeDuplicate bool) (restic.ID, bool, int, error) {
+	newID, known, size, err := r.Repository.SaveBlob(ctx, t, buf, id, storeDuplicate)
+	if err == nil && !known && t == restic.DataBlob {
+		r.sampler.Add(buf)
+	}
+	return newID, known, size, err
+}
+
+// detectAnomalies compares the snapshot sn to the previous snapshots of the
+// same host and paths.
+func detectAnomalies(ctx context.Context, repo restic.Repository, id restic.ID, sn *restic.Snapshot, entropy *anomaly.EntropyStats, opts anomaly.Options) (*anomaly.Report, error) {
+	var previous restic.Snapshots
+	f := restic.SnapshotFilter{
+		Hosts:          []string{sn.Hostname},
+		Paths:          sn.Paths,
+		TimestampLimit: sn.Time,
+	}
+	err := f.FindAll(ctx, repo, repo, nil, func(_ string, other *restic.Snapshot, err error) error {
+		if err != nil {
+			return err
+		}
+		if other.Summary != nil && !other.ID().Equal(id) {
+			previous = append(previous, other)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// most recent first
+	sort.Sort(sort.Reverse(previous))
+	history := make([]*restic.SnapshotSummary, 0, len(previous))
+	for _, other := range previous {
+		history = append(history, other.Summary)
+	}
+
+	report := anomaly.Analyze(sn.Summary, history, entropy, opts)
+	return &report, nil
+}
@@ -0,0 +1,175 @@
+// Package anomaly flags suspicious backups, e.g. of files encrypted by
+// ransomware. The statistics of a backup are compared to the previous backups
+// of the same source: many more modified files or added data than usual are
+// suspicious, even more so if the new data is random, as encrypted data is.
+// The outcome is a risk score, which is a hint and not a verdict: a large
+// update of the backed up software can look like an attack, too.
+package anomaly
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/konidev20/rapi/restic"
+)
+
+// Options configure Analyze.
+type Options struct {
+	// History is the number of previous backups the backup is compared to,
+	// it defaults to 10.
+	History int
+
+	// MinHistory is the number of previous backups required to compare
+	// them, it defaults to 3. With fewer backups only the share of modified
+	// files is judged, by fixed limits.
+	MinHistory int
+
+	// Threshold is the score from which a backup is suspicious, it
+	// defaults to 0.5.
+	Threshold float64
+}
+
+// DefaultOptions are the defaults for Options.
+var DefaultOptions = Options{
+	History:    10,
+	MinHistory: 3,
+	Threshold:  0.5,
+}
+
+func (opts Options) withDefaults() Options {
+	if opts.History <= 0 {
+		opts.History = DefaultOptions.History
+	}
+	if opts.MinHistory <= 0 {
+		opts.MinHistory = DefaultOptions.MinHistory
+	}
+	if opts.Threshold <= 0 {
+		opts.Threshold = DefaultOptions.Threshold
+	}
+	return opts
+}
+
+// Kinds of findings.
+const (
+	// ChangedFiles means that unusually many files were modified.
+	ChangedFiles = "changed-files"
+	// DataAdded means that unusually much data was added.
+	DataAdded = "data-added"
+	// RandomData means that most of the added data looks random.
+	RandomData = "random-data"
+)
+
+// Finding is an observation which contributes to the risk score.
+type Finding struct {
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+	// Score is the contribution of the finding, between 0 and 1.
+	Score float64 `json:"score"`
+}
+
+// Report is the outcome of Analyze.
+type Report struct {
+	// Score is the risk that the backup contains damaged or encrypted data,
+	// between 0 and 1.
+	Score      float64 `json:"score"`
+	Suspicious bool    `json:"suspicious"`
+
+	Findings []Finding `json:"findings,omitempty"`
+
+	// History is the number of previous backups the backup was compared to.
+	History int `json:"history"`
+}
+
+// Analyze computes the risk score of the backup with the statistics current.
+// history are the statistics of previous backups of the same source, the
+// most recent first. entropy describes the data added by the backup, it may
+// be nil if it was not sampled.
+func Analyze(current *restic.SnapshotSummary, history []*restic.SnapshotSummary, entropy *EntropyStats, opts Options) Report {
+	opts = opts.withDefaults()
+	var report Report
+	if current == nil {
+		return report
+	}
+	if len(history) > opts.History {
+		history = history[:opts.History]
+	}
+	report.History = len(history)
+
+	changed := changedShare(current)
+	var changeScore, dataScore float64
+	if len(history) >= opts.MinHistory {
+		var shares, added []float64
+		for _, h := range history {
+			shares = append(shares, changedShare(h))
+			added = append(added, float64(h.DataAdded))
+		}
+
+		// the spread is not below a minimum, so that a backup of a source
+		// which never changes is not suspicious for a few changes
+		z, mean := zScore(changed, shares, 0.01)
+		changeScore = clamp((z - 2) / 4)
+		if changeScore > 0 {
+			report.add(ChangedFiles, changeScore, "%.1f%% of the files were modified, usually %.1f%%", changed*100, mean*100)
+		}
+
+		z, mean = zScore(float64(current.DataAdded), added, 1<<20)
+		dataScore = clamp((z-3)/6) * 0.8
+		if dataScore > 0 {
+			report.add(DataAdded, dataScore, "%d bytes were added, usually %.0f", current.DataAdded, mean)
+		}
+	} else {
+		changeScore = clamp((changed - 0.3) / 0.5)
+		if changeScore > 0 {
+			report.add(ChangedFiles, changeScore, "%.1f%% of the files were modified", changed*100)
+		}
+	}
+
+	// random data alone is common, e.g. for photos or compressed archives,
+	// it only raises the score of the other findings
+	factor := 0.75
+	if entropy != nil && entropy.Bytes > 0 {
+		share := entropy.HighEntropyShare()
+		randomScore := clamp((share - 0.5) / 0.4)
+		factor = 0.5 + 0.5*randomScore
+		if randomScore > 0 {
+			report.add(RandomData, randomScore, "%.1f%% of the added data looks random", share*100)
+		}
+	}
+
+	report.Score = math.Max(changeScore, dataScore) * factor
+	report.Suspicious = report.Score >= opts.Threshold
+	return report
+}
+
+func (r *Report) add(kind string, score float64, format string, args ...interface{}) {
+	r.Findings = append(r.Findings, Finding{Kind: kind, Score: score, Message: fmt.Sprintf(format, args...)})
+}
+
+// changedShare returns the share of the processed files which were modified.
+func changedShare(s *restic.SnapshotSummary) float64 {
+	if s.TotalFilesProcessed == 0 {
+		return 0
+	}
+	return float64(s.FilesChanged) / float64(s.TotalFilesProcessed)
+}
+
+// zScore returns the number of standard deviations by which v exceeds the
+// mean of values, the deviation is at least minStdDev. The mean is returned
+// as well.
+func zScore(v float64, values []float64, minStdDev float64) (z, mean float64) {
+	for _, x := range values {
+		mean += x
+	}
+	mean /= float64(len(values))
+
+	var variance float64
+	for _, x := range values {
+		variance += (x - mean) * (x - mean)
+	}
+	stddev := math.Max(math.Sqrt(variance/float64(len(values))), minStdDev)
+	return (v - mean) / stddev, mean
+}
+
+func clamp(v float64) float64 {
+	return math.Min(math.Max(v, 0), 1)
+}
@@ -0,0 +1,89 @@
+package anomaly_test
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/konidev20/rapi/anomaly"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/restic"
+)
+
+func randomData(n int) []byte {
+	buf := make([]byte, n)
+	_, _ = rand.New(rand.NewSource(int64(n))).Read(buf)
+	return buf
+}
+
+func TestEntropy(t *testing.T) {
+	rtest.Equals(t, 0.0, anomaly.Entropy(nil))
+	rtest.Equals(t, 0.0, anomaly.Entropy(bytes.Repeat([]byte{'a'}, 100)))
+	rtest.Equals(t, 1.0, anomaly.Entropy([]byte("abababab")))
+
+	var all []byte
+	for i := 0; i < 256; i++ {
+		all = append(all, byte(i))
+	}
+	rtest.Equals(t, 8.0, anomaly.Entropy(all))
+
+	text := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 1000)
+	rtest.Assert(t, anomaly.Entropy(text) < anomaly.HighEntropy, "text has high entropy")
+	rtest.Assert(t, anomaly.Entropy(randomData(1<<16)) > anomaly.HighEntropy, "random data has low entropy")
+
+	var s anomaly.Sampler
+	s.Add(text)
+	s.Add(randomData(1 << 17))
+	stats := s.Stats()
+	rtest.Equals(t, uint64(len(text)+1<<17), stats.Bytes)
+	rtest.Equals(t, uint64(1<<17), stats.HighEntropyBytes)
+}
+
+func summary(processed, changed uint, added uint64) *restic.SnapshotSummary {
+	return &restic.SnapshotSummary{
+		TotalFilesProcessed: processed,
+		FilesChanged:        changed,
+		DataAdded:           added,
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	var history []*restic.SnapshotSummary
+	for i := 0; i < 10; i++ {
+		history = append(history, summary(10000, uint(80+i*5), uint64(50+i)<<20))
+	}
+	random := &anomaly.EntropyStats{Bytes: 100, HighEntropyBytes: 98}
+	plain := &anomaly.EntropyStats{Bytes: 100, HighEntropyBytes: 2}
+
+	// a usual backup, even of random data such as photos
+	r := anomaly.Analyze(summary(10000, 100, 55<<20), history, random, anomaly.Options{})
+	rtest.Equals(t, 10, r.History)
+	rtest.Equals(t, 0.0, r.Score)
+	rtest.Assert(t, !r.Suspicious, "usual backup is suspicious: %+v", r)
+
+	// files encrypted by ransomware
+	r = anomaly.Analyze(summary(10000, 8000, 5<<30), history, random, anomaly.Options{})
+	rtest.Equals(t, 1.0, r.Score)
+	rtest.Assert(t, r.Suspicious, "encrypted files not detected: %+v", r)
+	kinds := make(map[string]bool)
+	for _, f := range r.Findings {
+		kinds[f.Kind] = true
+	}
+	rtest.Assert(t, kinds[anomaly.ChangedFiles] && kinds[anomaly.DataAdded] && kinds[anomaly.RandomData], "missing findings: %+v", r.Findings)
+
+	// a large update of plain files is less suspicious
+	r = anomaly.Analyze(summary(10000, 8000, 5<<30), history, plain, anomaly.Options{})
+	rtest.Equals(t, 0.5, r.Score)
+	r = anomaly.Analyze(summary(10000, 8000, 5<<30), history, plain, anomaly.Options{Threshold: 0.7})
+	rtest.Assert(t, !r.Suspicious, "score above threshold: %+v", r)
+
+	// without enough history only the share of modified files is judged
+	r = anomaly.Analyze(summary(10000, 8000, 5<<30), history[:2], nil, anomaly.Options{})
+	rtest.Equals(t, 2, r.History)
+	rtest.Equals(t, 0.75, r.Score)
+	r = anomaly.Analyze(summary(10000, 100, 5<<30), nil, random, anomaly.Options{})
+	rtest.Equals(t, 0.0, r.Score)
+
+	r = anomaly.Analyze(nil, history, nil, anomaly.Options{})
+	rtest.Equals(t, 0.0, r.Score)
+}
@@ -0,0 +1,85 @@
+package anomaly
+
+import (
+	"math"
+	"sync"
+)
+
+// sampleSize is the number of bytes of a blob whose entropy is computed.
+const sampleSize = 64 * 1024
+
+// HighEntropy is the entropy in bits per byte above which data is considered
+// random, as compressed or encrypted data is. Text and most binaries are well
+// below it.
+const HighEntropy = 7.5
+
+// Entropy returns the Shannon entropy of buf in bits per byte, between 0 and
+// 8.
+func Entropy(buf []byte) float64 {
+	if len(buf) == 0 {
+		return 0
+	}
+
+	var counts [256]int
+	for _, b := range buf {
+		counts[b]++
+	}
+	n := float64(len(buf))
+	var e float64
+	for _, c := range counts {
+		if c == 0 {
+			continue
+		}
+		p := float64(c) / n
+		e -= p * math.Log2(p)
+	}
+	return e
+}
+
+// EntropyStats describe the entropy of the data added by a backup.
+type EntropyStats struct {
+	// Bytes is the size of the sampled blobs, HighEntropyBytes the size of
+	// those with an entropy above HighEntropy.
+	Bytes            uint64 `json:"bytes"`
+	HighEntropyBytes uint64 `json:"high_entropy_bytes"`
+}
+
+// HighEntropyShare returns the share of the bytes with a high entropy,
+// between 0 and 1.
+func (s EntropyStats) HighEntropyShare() float64 {
+	if s.Bytes == 0 {
+		return 0
+	}
+	return float64(s.HighEntropyBytes) / float64(s.Bytes)
+}
+
+// Sampler computes the entropy of the blobs added by a backup. The entropy
+// of a blob is estimated from its first 64 KiB. It is safe for concurrent
+// use.
+type Sampler struct {
+	m     sync.Mutex
+	stats EntropyStats
+}
+
+// Add samples the plaintext of a blob.
+func (s *Sampler) Add(buf []byte) {
+	sample := buf
+	if len(sample) > sampleSize {
+		sample = sample[:sampleSize]
+	}
+	high := Entropy(sample) > HighEntropy
+
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.stats.Bytes += uint64(len(buf))
+	if high {
+		s.stats.HighEntropyBytes += uint64(len(buf))
+	}
+}
+
+// Stats returns the statistics of the blobs sampled so far.
+func (s *Sampler) Stats() EntropyStats {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.stats
+}
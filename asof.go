@@ -0,0 +1,24 @@
+package rapi
+
+import (
+	"context"
+	"time"
+
+	"github.com/konidev20/rapi/restic"
+)
+
+// AsOf returns a view of repo which shows each backed up path as it was in
+// the latest snapshot matching filter taken at or before at, so that the
+// state at a point in time can be accessed without picking snapshot IDs.
+// The view is a repository, it can be passed to LsAsOf, to a restorer
+// together with view.Snapshot() and to a fuse mount. The index must already
+// be loaded.
+func AsOf(ctx context.Context, repo restic.Repository, at time.Time, filter restic.SnapshotFilter) (*restic.AsOfView, error) {
+	return restic.NewAsOfView(ctx, repo, at, filter)
+}
+
+// LsAsOf calls fn for the entries of the directory opts.Path in the
+// synthetic snapshot of view, see Ls.
+func LsAsOf(ctx context.Context, view *restic.AsOfView, opts LsOptions, fn func(LsEntry) error) error {
+	return lsSnapshot(ctx, view, view.Snapshot(), opts, fn)
+}
@@ -0,0 +1,119 @@
+package rapi_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/konidev20/rapi"
+	"github.com/konidev20/rapi/internal/restorer"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/restic"
+	"golang.org/x/sync/errgroup"
+)
+
+// saveTestSnapshotAt stores dir as a snapshot of paths taken at the time at.
+func saveTestSnapshotAt(t *testing.T, repo restic.Repository, at time.Time, paths []string, dir testDir) restic.ID {
+	ctx := context.Background()
+	var wg errgroup.Group
+	repo.StartPackUploader(ctx, &wg)
+	treeID := saveTestTree(t, repo, dir)
+	rtest.OK(t, repo.Flush(ctx))
+	rtest.OK(t, wg.Wait())
+
+	sn, err := restic.NewSnapshot(paths, nil, "test", at)
+	rtest.OK(t, err)
+	sn.Tree = &treeID
+	id, err := restic.SaveSnapshot(ctx, repo, sn)
+	rtest.OK(t, err)
+	return id
+}
+
+func TestAsOf(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.TestRepository(t)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	home1 := saveTestSnapshotAt(t, repo, start, []string{"/home"}, testDir{
+		"home": testDir{"alice": testDir{"notes": "v1", "old": "deleted later"}},
+	})
+	etc := saveTestSnapshotAt(t, repo, start.Add(time.Hour), []string{"/etc"}, testDir{
+		"etc": testDir{"hosts": "hosts"},
+	})
+	home2 := saveTestSnapshotAt(t, repo, start.Add(2*time.Hour), []string{"/home"}, testDir{
+		"home": testDir{"alice": testDir{"notes": "v2"}},
+	})
+	saveTestSnapshotAt(t, repo, start.Add(3*time.Hour), []string{"/etc"}, testDir{
+		"etc": testDir{"hosts": "changed"},
+	})
+
+	_, err := rapi.AsOf(ctx, repo, start.Add(-time.Hour), restic.SnapshotFilter{})
+	rtest.Assert(t, err != nil, "view before the first snapshot did not fail")
+
+	view, err := rapi.AsOf(ctx, repo, start.Add(90*time.Minute), restic.SnapshotFilter{})
+	rtest.OK(t, err)
+	rtest.Equals(t, map[string]restic.ID{"/home": home1, "/etc": etc}, view.Sources())
+	rtest.Equals(t, []string{"/etc", "/home"}, view.Snapshot().Paths)
+	rtest.Equals(t, start.Add(time.Hour), view.Snapshot().Time)
+
+	var paths []string
+	rtest.OK(t, rapi.LsAsOf(ctx, view, rapi.LsOptions{Recursive: true}, func(entry rapi.LsEntry) error {
+		paths = append(paths, entry.Path)
+		return nil
+	}))
+	rtest.Equals(t, []string{"/etc", "/etc/hosts", "/home", "/home/alice", "/home/alice/notes", "/home/alice/old"}, paths)
+
+	// restore the state after the second backup of /home
+	view, err = rapi.AsOf(ctx, repo, start.Add(2*time.Hour), restic.SnapshotFilter{})
+	rtest.OK(t, err)
+	rtest.Equals(t, map[string]restic.ID{"/home": home2, "/etc": etc}, view.Sources())
+
+	dst := rtest.TempDir(t)
+	res := restorer.NewRestorer(view, view.Snapshot(), false, nil)
+	rtest.OK(t, res.RestoreTo(ctx, dst))
+
+	buf, err := os.ReadFile(filepath.Join(dst, "home", "alice", "notes"))
+	rtest.OK(t, err)
+	rtest.Equals(t, "v2", string(buf))
+	buf, err = os.ReadFile(filepath.Join(dst, "etc", "hosts"))
+	rtest.OK(t, err)
+	rtest.Equals(t, "hosts", string(buf))
+	_, err = os.Stat(filepath.Join(dst, "home", "alice", "old"))
+	rtest.Assert(t, os.IsNotExist(err), "file deleted in the later snapshot was restored")
+
+	// nothing was written to the repository
+	snapshots, err := restic.TestLoadAllSnapshots(ctx, repo, nil)
+	rtest.OK(t, err)
+	rtest.Equals(t, 4, len(snapshots))
+}
+
+func TestAsOfNestedPaths(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.TestRepository(t)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	saveTestSnapshotAt(t, repo, start, []string{"/home/alice"}, testDir{
+		"home": testDir{"alice": testDir{"a": "alice"}},
+	})
+	home := saveTestSnapshotAt(t, repo, start.Add(time.Hour), []string{"/home"}, testDir{
+		"home": testDir{"bob": testDir{"b": "bob"}},
+	})
+	bob := saveTestSnapshotAt(t, repo, start.Add(2*time.Hour), []string{"/home/bob"}, testDir{
+		"home": testDir{"bob": testDir{"c": "new"}},
+	})
+
+	// the later backup of /home replaces the one of /home/alice
+	view, err := rapi.AsOf(ctx, repo, start.Add(2*time.Hour), restic.SnapshotFilter{})
+	rtest.OK(t, err)
+	rtest.Equals(t, map[string]restic.ID{"/home": home, "/home/bob": bob}, view.Sources())
+
+	var paths []string
+	rtest.OK(t, rapi.LsAsOf(ctx, view, rapi.LsOptions{Recursive: true}, func(entry rapi.LsEntry) error {
+		paths = append(paths, entry.Path)
+		return nil
+	}))
+	rtest.Equals(t, []string{"/home", "/home/bob", "/home/bob/c"}, paths)
+}
@@ -0,0 +1,198 @@
+//go:build !js
+// +build !js
+
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/errors"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// sasRefreshWindow is how long before its expiry a SAS token is replaced.
+const sasRefreshWindow = 5 * time.Minute
+
+// sasParameters are the query parameters which make up a SAS token. They are
+// removed from a request before the current token is added.
+var sasParameters = []string{
+	"sv", "ss", "srt", "sp", "se", "st", "spr", "sip", "sr", "si", "sig", "sdd",
+	"skoid", "sktid", "skt", "ske", "sks", "skv", "saoid", "suoid", "scid", "ses",
+}
+
+// sasPolicy adds the SAS token returned by provider to each request.
+type sasPolicy struct {
+	provider SASProvider
+
+	m      sync.Mutex
+	token  url.Values
+	expiry time.Time
+}
+
+func newSASPolicy(provider SASProvider) *sasPolicy {
+	return &sasPolicy{provider: provider}
+}
+
+// get returns the cached token, a new one is requested if there is none, if
+// it expires soon or if it is the rejected one.
+func (p *sasPolicy) get(ctx context.Context, rejected url.Values) (url.Values, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if p.token != nil && (rejected == nil || p.token.Get("sig") != rejected.Get("sig")) &&
+		(p.expiry.IsZero() || time.Until(p.expiry) > sasRefreshWindow) {
+		return p.token, nil
+	}
+
+	sas, expiry, err := p.provider(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "SASProvider")
+	}
+	token, err := url.ParseQuery(strings.TrimPrefix(sas, "?"))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid SAS token")
+	}
+
+	debug.Log("refreshed SAS token, expires at %v", expiry)
+	p.token, p.expiry = token, expiry
+	return token, nil
+}
+
+func (p *sasPolicy) Do(req *policy.Request) (*http.Response, error) {
+	token, err := p.get(req.Raw().Context(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	applySAS(req.Raw().URL, token)
+	resp, err := req.Next()
+	if err != nil || resp.StatusCode != http.StatusForbidden {
+		return resp, err
+	}
+
+	// the token may have been revoked or expired early, retry once with a new one
+	token, err = p.get(req.Raw().Context(), token)
+	if err != nil {
+		return resp, nil
+	}
+	if err := req.RewindBody(); err != nil {
+		return resp, nil
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	applySAS(req.Raw().URL, token)
+	return req.Next()
+}
+
+func applySAS(u *url.URL, token url.Values) {
+	q := u.Query()
+	for _, name := range sasParameters {
+		q.Del(name)
+	}
+	for name, values := range token {
+		q[name] = values
+	}
+	u.RawQuery = q.Encode()
+}
+
+// storageScope is the OAuth scope for Azure Storage.
+const storageScope = "https://storage.azure.com/.default"
+
+// workloadIdentityCredential exchanges a federated token, for example the
+// service account token of a Kubernetes pod, for an Azure AD access token.
+// The file is read for each exchange, as it is rotated by the platform.
+type workloadIdentityCredential struct {
+	client    *http.Client
+	authority string
+	tenantID  string
+	clientID  string
+	tokenFile string
+}
+
+var _ azcore.TokenCredential = &workloadIdentityCredential{}
+
+func newWorkloadIdentityCredential(cfg Config, client *http.Client) (*workloadIdentityCredential, error) {
+	if cfg.TenantID == "" || cfg.ClientID == "" {
+		return nil, errors.Fatal("workload identity requires a tenant ID and a client ID")
+	}
+
+	authority := os.Getenv("AZURE_AUTHORITY_HOST")
+	if authority == "" {
+		authority = "https://login.microsoftonline.com/"
+	}
+
+	return &workloadIdentityCredential{
+		client:    client,
+		authority: strings.TrimSuffix(authority, "/"),
+		tenantID:  cfg.TenantID,
+		clientID:  cfg.ClientID,
+		tokenFile: cfg.FederatedTokenFile,
+	}, nil
+}
+
+// GetToken requests an access token. The result is cached by the bearer
+// token policy of the SDK until shortly before it expires.
+func (c *workloadIdentityCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	assertion, err := os.ReadFile(c.tokenFile)
+	if err != nil {
+		return azcore.AccessToken{}, errors.Wrap(err, "reading federated token")
+	}
+
+	scopes := opts.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{storageScope}
+	}
+
+	form := url.Values{
+		"grant_type":            {"client_credentials"},
+		"client_id":             {c.clientID},
+		"client_assertion_type": {"urn:ietf:params:oauth:client-assertion-type:jwt-bearer"},
+		"client_assertion":      {strings.TrimSpace(string(assertion))},
+		"scope":                 {strings.Join(scopes, " ")},
+	}
+	u := c.authority + "/" + url.PathEscape(c.tenantID) + "/oauth2/v2.0/token"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, strings.NewReader(form.Encode()))
+	if err != nil {
+		return azcore.AccessToken{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return azcore.AccessToken{}, err
+	}
+	defer func() {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}()
+
+	var result struct {
+		AccessToken      string `json:"access_token"`
+		ExpiresIn        int64  `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return azcore.AccessToken{}, errors.Wrapf(err, "decoding token response (status %v)", resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK || result.AccessToken == "" {
+		return azcore.AccessToken{}, errors.Errorf("workload identity token exchange failed: %v %v", result.Error, result.ErrorDescription)
+	}
+
+	return azcore.AccessToken{
+		Token:     result.AccessToken,
+		ExpiresOn: time.Now().Add(time.Duration(result.ExpiresIn) * time.Second),
+	}, nil
+}
@@ -0,0 +1,73 @@
+//go:build !js
+// +build !js
+
+package azure
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	rtest "github.com/konidev20/rapi/internal/test"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	azContainer "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+)
+
+func TestSASPolicyRefresh(t *testing.T) {
+	var valid atomic.Value
+	valid.Store("sig-2")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("sig") != valid.Load().(string) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	var calls int32
+	provider := func(ctx context.Context) (string, time.Time, error) {
+		n := atomic.AddInt32(&calls, 1)
+		expiry := time.Now().Add(time.Hour)
+		if n == 3 {
+			// this token expires within the refresh window
+			expiry = time.Now().Add(time.Minute)
+		}
+		return "?sv=2021-08-06&sp=rwdl&sig=sig-" + string(rune('0'+n)), expiry, nil
+	}
+
+	client, err := azContainer.NewClientWithNoCredential(srv.URL+"/container", &azContainer.ClientOptions{
+		ClientOptions: azcore.ClientOptions{
+			Transport:        srv.Client(),
+			PerRetryPolicies: []policy.Policy{newSASPolicy(provider)},
+		},
+	})
+	rtest.OK(t, err)
+
+	// the first token is rejected and replaced
+	_, err = client.GetProperties(context.TODO(), nil)
+	rtest.OK(t, err)
+	rtest.Equals(t, int32(2), atomic.LoadInt32(&calls))
+
+	// the cached token is used as long as it is valid
+	_, err = client.GetProperties(context.TODO(), nil)
+	rtest.OK(t, err)
+	rtest.Equals(t, int32(2), atomic.LoadInt32(&calls))
+
+	// the token is rejected again and replaced with one which expires soon,
+	// which is then replaced before the next request
+	valid.Store("sig-3")
+	_, err = client.GetProperties(context.TODO(), nil)
+	rtest.OK(t, err)
+	rtest.Equals(t, int32(3), atomic.LoadInt32(&calls))
+
+	valid.Store("sig-4")
+	_, err = client.GetProperties(context.TODO(), nil)
+	rtest.OK(t, err)
+	rtest.Equals(t, int32(4), atomic.LoadInt32(&calls))
+}
@@ -1,3 +1,6 @@
+//go:build !js
+// +build !js
+
 package azure
 
 import (
@@ -46,7 +49,10 @@ const defaultListMaxItems = 5000
 var _ backend.Backend = &Backend{}
 
 func NewFactory() location.Factory {
-	return location.NewHTTPBackendFactory("azure", ParseConfig, location.NoPassword, Create, Open)
+	return location.WithCapabilities(
+		location.NewHTTPBackendFactory("azure", ParseConfig, location.NoPassword, Create, Open),
+		backend.Capabilities{RangedReads: true, AtomicWrites: true, ServerSideChecksum: true, ScalesWithConnections: true, ColdStorage: true, MaxObjectSize: 50000 * 4000 * 1024 * 1024},
+	)
 }
 
 func open(cfg Config, rt http.RoundTripper) (*Backend, error) {
@@ -81,6 +87,14 @@ func open(cfg Config, rt http.RoundTripper) (*Backend, error) {
 		if err != nil {
 			return nil, errors.Wrap(err, "NewClientWithSharedKeyCredential")
 		}
+	} else if cfg.SASProvider != nil {
+		debug.Log(" - using sas provider")
+		opts.PerRetryPolicies = append(opts.PerRetryPolicies, newSASPolicy(*cfg.SASProvider))
+
+		client, err = azContainer.NewClientWithNoCredential(url, opts)
+		if err != nil {
+			return nil, errors.Wrap(err, "NewClientWithNoCredential")
+		}
 	} else if cfg.AccountSAS.String() != "" {
 		// Get the client using the SAS Token as authentication, this
 		// is longer winded than above because the SDK wants a URL for the Account
@@ -102,10 +116,9 @@ func open(cfg Config, rt http.RoundTripper) (*Backend, error) {
 			return nil, errors.Wrap(err, "NewAccountSASClientFromEndpointToken")
 		}
 	} else {
-		debug.Log(" - using DefaultAzureCredential")
-		cred, err := azidentity.NewDefaultAzureCredential(nil)
+		cred, err := newTokenCredential(cfg, rt)
 		if err != nil {
-			return nil, errors.Wrap(err, "NewDefaultAzureCredential")
+			return nil, err
 		}
 
 		client, err = azContainer.NewClient(url, cred, opts)
@@ -128,6 +141,35 @@ func open(cfg Config, rt http.RoundTripper) (*Backend, error) {
 	return be, nil
 }
 
+// newTokenCredential returns the Azure AD credential selected by cfg: workload
+// identity federation if a federated token file is set, the managed identity
+// of the host if requested, and DefaultAzureCredential otherwise.
+func newTokenCredential(cfg Config, rt http.RoundTripper) (azcore.TokenCredential, error) {
+	switch {
+	case cfg.FederatedTokenFile != "":
+		debug.Log(" - using workload identity")
+		return newWorkloadIdentityCredential(cfg, &http.Client{Transport: rt})
+	case cfg.UseManagedIdentity:
+		debug.Log(" - using managed identity")
+		miOpts := &azidentity.ManagedIdentityCredentialOptions{}
+		if cfg.ClientID != "" {
+			miOpts.ID = azidentity.ClientID(cfg.ClientID)
+		}
+		cred, err := azidentity.NewManagedIdentityCredential(miOpts)
+		if err != nil {
+			return nil, errors.Wrap(err, "NewManagedIdentityCredential")
+		}
+		return cred, nil
+	default:
+		debug.Log(" - using DefaultAzureCredential")
+		cred, err := azidentity.NewDefaultAzureCredential(nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "NewDefaultAzureCredential")
+		}
+		return cred, nil
+	}
+}
+
 // Open opens the Azure backend at specified container.
 func Open(_ context.Context, cfg Config, rt http.RoundTripper) (*Backend, error) {
 	return open(cfg, rt)
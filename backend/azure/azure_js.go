@@ -0,0 +1,27 @@
+package azure
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/backend/location"
+	"github.com/konidev20/rapi/internal/errors"
+)
+
+// The Azure SDK does not build for js/wasm, so the backend is unavailable
+// there. The configuration is still parsed so that locations are recognized.
+
+func NewFactory() location.Factory {
+	return location.NewHTTPBackendFactory("azure", ParseConfig, location.NoPassword, Create, Open)
+}
+
+// Open returns an error on js/wasm.
+func Open(_ context.Context, _ Config, _ http.RoundTripper) (backend.Backend, error) {
+	return nil, errors.New("the azure backend is not supported on js/wasm")
+}
+
+// Create returns an error on js/wasm.
+func Create(ctx context.Context, cfg Config, rt http.RoundTripper) (backend.Backend, error) {
+	return Open(ctx, cfg, rt)
+}
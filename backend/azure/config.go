@@ -1,15 +1,24 @@
 package azure
 
 import (
+	"context"
 	"os"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/konidev20/rapi/backend"
 	"github.com/konidev20/rapi/internal/errors"
 	"github.com/konidev20/rapi/internal/options"
 )
 
+// SASProvider returns a SAS token for the container together with the time at
+// which it expires. It is called when the backend is opened, shortly before
+// the token expires and when the service rejects the current token, so that
+// long-running operations can continue with a fresh token. A zero expiry
+// means that the token is only replaced when it is rejected.
+type SASProvider func(ctx context.Context) (sas string, expiry time.Time, err error)
+
 // Config contains all configuration necessary to connect to an azure compatible
 // server.
 type Config struct {
@@ -21,6 +30,16 @@ type Config struct {
 	Prefix         string
 
 	Connections uint `option:"connections" help:"set a limit for the number of concurrent connections (default: 5)"`
+
+	UseManagedIdentity bool   `option:"managed-identity" help:"authenticate using the managed identity of the host"`
+	ClientID           string `option:"client-id" help:"client ID of a user-assigned managed identity or of the workload identity application"`
+	TenantID           string `option:"tenant-id" help:"tenant ID used for workload identity federation"`
+	FederatedTokenFile string `option:"federated-token-file" help:"authenticate using workload identity federation with the token in this file"`
+
+	// SASProvider supplies SAS tokens which are refreshed while the backend
+	// is in use, it takes precedence over AccountSAS. It is a pointer so that
+	// Config stays comparable.
+	SASProvider *SASProvider
 }
 
 // NewConfig returns a new Config with the default values filled in.
@@ -76,4 +95,16 @@ func (cfg *Config) ApplyEnvironment(prefix string) {
 	if cfg.EndpointSuffix == "" {
 		cfg.EndpointSuffix = os.Getenv(prefix + "AZURE_ENDPOINT_SUFFIX")
 	}
+
+	if cfg.ClientID == "" {
+		cfg.ClientID = os.Getenv(prefix + "AZURE_CLIENT_ID")
+	}
+
+	if cfg.TenantID == "" {
+		cfg.TenantID = os.Getenv(prefix + "AZURE_TENANT_ID")
+	}
+
+	if cfg.FederatedTokenFile == "" {
+		cfg.FederatedTokenFile = os.Getenv(prefix + "AZURE_FEDERATED_TOKEN_FILE")
+	}
 }
@@ -38,7 +38,10 @@ const defaultListMaxItems = 10 * 1000
 var _ backend.Backend = &b2Backend{}
 
 func NewFactory() location.Factory {
-	return location.NewHTTPBackendFactory("b2", ParseConfig, location.NoPassword, Create, Open)
+	return location.WithCapabilities(
+		location.NewHTTPBackendFactory("b2", ParseConfig, location.NoPassword, Create, Open),
+		backend.Capabilities{RangedReads: true, AtomicWrites: true, ServerSideChecksum: true, ScalesWithConnections: true, MaxObjectSize: 10 * 1000 * 1000 * 1000 * 1000},
+	)
 }
 
 type sniffingRoundTripper struct {
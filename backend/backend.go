@@ -4,6 +4,7 @@ import (
 	"context"
 	"hash"
 	"io"
+	"reflect"
 )
 
 // Backend is used to store and access data.
@@ -75,20 +76,63 @@ type Unwrapper interface {
 	Unwrap() Backend
 }
 
-func AsBackend[B Backend](b Backend) B {
+// Unwrap returns the backend wrapped by b, or nil if b does not wrap another
+// backend.
+func Unwrap(b Backend) Backend {
+	if be, ok := b.(Unwrapper); ok {
+		return be.Unwrap()
+	}
+	return nil
+}
+
+// As finds the first backend in the chain of wrapped backends starting at b
+// which matches target, and if one is found, sets target to that backend and
+// returns true. The chain is followed through the Unwrap methods of the
+// backends, so it works regardless of which wrappers (cache, retry, sema,
+// logger, limiter and so on) are used.
+//
+// Like errors.As, target must be a non-nil pointer to either a type which
+// implements Backend or to any interface type. This allows probing for
+// optional features, e.g. with a pointer to interface{ Rename(...) error }.
+// A backend matches if it is assignable to the type target points to, or if
+// it has a method As(interface{}) bool which returns true for target.
+//
+// As panics if target is not a non-nil pointer to such a type.
+func As(b Backend, target interface{}) bool {
+	if target == nil {
+		panic("backend: target cannot be nil")
+	}
+	val := reflect.ValueOf(target)
+	typ := val.Type()
+	if typ.Kind() != reflect.Ptr || val.IsNil() {
+		panic("backend: target must be a non-nil pointer")
+	}
+	targetType := typ.Elem()
+	if targetType.Kind() != reflect.Interface && !targetType.Implements(backendType) {
+		panic("backend: *target must be interface or implement Backend")
+	}
+
 	for b != nil {
-		if be, ok := b.(B); ok {
-			return be
+		if reflect.TypeOf(b).AssignableTo(targetType) {
+			val.Elem().Set(reflect.ValueOf(b))
+			return true
 		}
-
-		if be, ok := b.(Unwrapper); ok {
-			b = be.Unwrap()
-		} else {
-			// not the backend we're looking for
-			break
+		if x, ok := b.(interface{ As(interface{}) bool }); ok && x.As(target) {
+			return true
 		}
+		b = Unwrap(b)
 	}
+	return false
+}
+
+var backendType = reflect.TypeOf((*Backend)(nil)).Elem()
+
+// AsBackend returns the first backend of type B in the chain of wrapped
+// backends starting at b, see As. If there is none, the zero value of B is
+// returned.
+func AsBackend[B Backend](b Backend) B {
 	var be B
+	As(b, &be)
 	return be
 }
 
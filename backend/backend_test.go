@@ -36,3 +36,27 @@ func TestAsBackend(t *testing.T) {
 	wrapper.Backend = other
 	test.Assert(t, backend.AsBackend[*testBackend](wrapper) == nil, "a wrapped otherTestBackend is not a testBackend")
 }
+
+type featureBackend struct {
+	backend.Backend
+}
+
+func (f *featureBackend) Feature() string {
+	return "feature"
+}
+
+func TestAs(t *testing.T) {
+	feature := &featureBackend{}
+	wrapper := &otherTestBackend{Backend: &otherTestBackend{Backend: feature}}
+
+	var f interface{ Feature() string }
+	test.Assert(t, backend.As(wrapper, &f), "feature was not found through the wrappers")
+	test.Equals(t, "feature", f.Feature())
+
+	var tb *testBackend
+	test.Assert(t, !backend.As(wrapper, &tb), "found testBackend which is not in the chain")
+	test.Assert(t, tb == nil, "target was modified")
+
+	test.Equals(t, backend.Backend(wrapper.Backend), backend.Unwrap(wrapper))
+	test.Assert(t, backend.Unwrap(feature) == nil, "featureBackend does not wrap a backend")
+}
@@ -0,0 +1,35 @@
+package backend
+
+// Capabilities describes the features of a type of backend. They are static
+// properties of the storage service, the methods of a Backend such as
+// HasAtomicReplace may still report more precise values for a particular
+// connection. The zero value does not promise any feature.
+type Capabilities struct {
+	// RangedReads is true if parts of a file can be loaded without
+	// transferring the whole file.
+	RangedReads bool `json:"ranged_reads"`
+
+	// AtomicWrites is true if a file is either stored completely or not at
+	// all, and an existing file can be replaced atomically.
+	AtomicWrites bool `json:"atomic_writes"`
+
+	// ServerSideChecksum is true if the service verifies the integrity of
+	// uploaded data, for example using a content hash.
+	ServerSideChecksum bool `json:"server_side_checksum"`
+
+	// ColdStorage is true if files may be moved to a storage class which
+	// must be restored before the files can be read.
+	ColdStorage bool `json:"cold_storage"`
+
+	// LocalStorage is true if files are stored on a file system of this
+	// machine, including mounted network file systems.
+	LocalStorage bool `json:"local_storage"`
+
+	// ScalesWithConnections is true if the throughput of the service grows
+	// with the number of concurrent requests, as for cloud object stores.
+	ScalesWithConnections bool `json:"scales_with_connections"`
+
+	// MaxObjectSize is the maximum size of a single file in bytes, zero
+	// means that there is no known limit.
+	MaxObjectSize int64 `json:"max_object_size,omitempty"`
+}
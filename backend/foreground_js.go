@@ -0,0 +1,11 @@
+package backend
+
+import (
+	"os/exec"
+
+	"github.com/konidev20/rapi/internal/errors"
+)
+
+func startForeground(_ *exec.Cmd) (bg func() error, err error) {
+	return nil, errors.New("running commands is not supported on js/wasm")
+}
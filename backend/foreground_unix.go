@@ -1,5 +1,5 @@
-//go:build !aix && !solaris && !windows
-// +build !aix,!solaris,!windows
+//go:build !aix && !solaris && !windows && !js
+// +build !aix,!solaris,!windows,!js
 
 package backend
 
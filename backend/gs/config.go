@@ -20,6 +20,10 @@ type Config struct {
 
 	Connections uint   `option:"connections" help:"set a limit for the number of concurrent connections (default: 5)"`
 	Region      string `option:"region" help:"region to create the bucket in (default: us)"`
+
+	CredentialsFile           string `option:"credentials-file" help:"use this service account key or external account (workload identity federation) file instead of the default credentials"`
+	ImpersonateServiceAccount string `option:"impersonate-service-account" help:"impersonate this service account using the other credentials"`
+	ImpersonateDelegates      string `option:"impersonate-delegates" help:"comma separated chain of service accounts to delegate the impersonation through"`
 }
 
 // NewConfig returns a new Config with the default values filled in.
@@ -66,4 +70,7 @@ func (cfg *Config) ApplyEnvironment(prefix string) {
 	if cfg.ProjectID == "" {
 		cfg.ProjectID = os.Getenv(prefix + "GOOGLE_PROJECT_ID")
 	}
+	if cfg.ImpersonateServiceAccount == "" {
+		cfg.ImpersonateServiceAccount = os.Getenv(prefix + "GOOGLE_IMPERSONATE_SERVICE_ACCOUNT")
+	}
 }
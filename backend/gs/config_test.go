@@ -1,9 +1,15 @@
 package gs
 
 import (
+	"context"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"cloud.google.com/go/storage"
 	"github.com/konidev20/rapi/backend/test"
+	"github.com/konidev20/rapi/internal/options"
+	rtest "github.com/konidev20/rapi/internal/test"
 )
 
 var configTests = []test.ConfigTestData[Config]{
@@ -30,3 +36,69 @@ var configTests = []test.ConfigTestData[Config]{
 func TestParseConfig(t *testing.T) {
 	test.ParseConfigTester(t, ParseConfig, configTests)
 }
+
+func TestConfigOptions(t *testing.T) {
+	cfg := NewConfig()
+	rtest.OK(t, options.Options{
+		"credentials-file":            "/path/to/key.json",
+		"impersonate-service-account": "backup@project.iam.gserviceaccount.com",
+		"impersonate-delegates":       "a@project.iam.gserviceaccount.com,b@project.iam.gserviceaccount.com",
+	}.Apply("gs", &cfg))
+
+	rtest.Equals(t, "/path/to/key.json", cfg.CredentialsFile)
+	rtest.Equals(t, "backup@project.iam.gserviceaccount.com", cfg.ImpersonateServiceAccount)
+	rtest.Equals(t, "a@project.iam.gserviceaccount.com,b@project.iam.gserviceaccount.com", cfg.ImpersonateDelegates)
+}
+
+func TestConfigApplyEnvironment(t *testing.T) {
+	t.Setenv("GOOGLE_PROJECT_ID", "project")
+	t.Setenv("GOOGLE_IMPERSONATE_SERVICE_ACCOUNT", "env@project.iam.gserviceaccount.com")
+
+	cfg := NewConfig()
+	cfg.ApplyEnvironment("")
+	rtest.Equals(t, "project", cfg.ProjectID)
+	rtest.Equals(t, "env@project.iam.gserviceaccount.com", cfg.ImpersonateServiceAccount)
+
+	// options take precedence over the environment
+	cfg = NewConfig()
+	cfg.ImpersonateServiceAccount = "option@project.iam.gserviceaccount.com"
+	cfg.ApplyEnvironment("")
+	rtest.Equals(t, "option@project.iam.gserviceaccount.com", cfg.ImpersonateServiceAccount)
+}
+
+func TestTokenSourceValidation(t *testing.T) {
+	t.Setenv("GOOGLE_ACCESS_TOKEN", "")
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	cfg := NewConfig()
+	cfg.CredentialsFile = filepath.Join(dir, "missing.json")
+	_, err := getTokenSource(ctx, cfg)
+	rtest.Assert(t, err != nil, "missing credentials file accepted")
+
+	invalid := filepath.Join(dir, "invalid.json")
+	rtest.OK(t, os.WriteFile(invalid, []byte("{"), 0600))
+	cfg.CredentialsFile = invalid
+	_, err = getTokenSource(ctx, cfg)
+	rtest.Assert(t, err != nil, "invalid credentials file accepted")
+
+	cfg = NewConfig()
+	cfg.ImpersonateDelegates = "a@project.iam.gserviceaccount.com"
+	_, err = getTokenSource(ctx, cfg)
+	rtest.Assert(t, err != nil, "delegates without service account accepted")
+
+	// tokens are only requested when the first request is sent
+	t.Setenv("GOOGLE_ACCESS_TOKEN", "token")
+	cfg.ImpersonateServiceAccount = "backup@project.iam.gserviceaccount.com"
+	ts, err := getTokenSource(ctx, cfg)
+	rtest.OK(t, err)
+	rtest.Assert(t, ts != nil, "no token source returned")
+}
+
+func TestBaseScopes(t *testing.T) {
+	cfg := NewConfig()
+	rtest.Equals(t, []string{storage.ScopeReadWrite}, baseScopes(cfg))
+
+	cfg.ImpersonateServiceAccount = "backup@project.iam.gserviceaccount.com"
+	rtest.Equals(t, []string{storage.ScopeReadWrite, iamScope}, baseScopes(cfg))
+}
@@ -22,6 +22,7 @@ import (
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/googleapi"
+	"google.golang.org/api/impersonate"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 )
@@ -49,10 +50,13 @@ type Backend struct {
 var _ backend.Backend = &Backend{}
 
 func NewFactory() location.Factory {
-	return location.NewHTTPBackendFactory("gs", ParseConfig, location.NoPassword, Create, Open)
+	return location.WithCapabilities(
+		location.NewHTTPBackendFactory("gs", ParseConfig, location.NoPassword, Create, Open),
+		backend.Capabilities{RangedReads: true, AtomicWrites: true, ServerSideChecksum: true, ScalesWithConnections: true, ColdStorage: true, MaxObjectSize: 5 * 1024 * 1024 * 1024 * 1024},
+	)
 }
 
-func getStorageClient(rt http.RoundTripper) (*storage.Client, error) {
+func getStorageClient(cfg Config, rt http.RoundTripper) (*storage.Client, error) {
 	// create a new HTTP client
 	httpClient := &http.Client{
 		Transport: rt,
@@ -61,28 +65,89 @@ func getStorageClient(rt http.RoundTripper) (*storage.Client, error) {
 	// create a new context with the HTTP client stored at the oauth2.HTTPClient key
 	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, httpClient)
 
+	ts, err := getTokenSource(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	oauthClient := oauth2.NewClient(ctx, ts)
+
+	gcsClient, err := storage.NewClient(ctx, option.WithHTTPClient(oauthClient))
+	if err != nil {
+		return nil, err
+	}
+
+	return gcsClient, nil
+}
+
+// getTokenSource returns the token source selected by cfg. The base
+// credentials are either an access token from $GOOGLE_ACCESS_TOKEN, the
+// credentials file, which may also describe an external account for workload
+// identity federation, or the application default credentials. If a service
+// account to impersonate is configured, the base credentials are used to
+// obtain short-lived tokens for it.
+func getTokenSource(ctx context.Context, cfg Config) (oauth2.TokenSource, error) {
+	if cfg.ImpersonateDelegates != "" && cfg.ImpersonateServiceAccount == "" {
+		return nil, errors.New("gs: impersonate-delegates requires impersonate-service-account")
+	}
+
+	scopes := baseScopes(cfg)
 	var ts oauth2.TokenSource
-	if token := os.Getenv("GOOGLE_ACCESS_TOKEN"); token != "" {
+	switch {
+	case os.Getenv("GOOGLE_ACCESS_TOKEN") != "":
 		ts = oauth2.StaticTokenSource(&oauth2.Token{
-			AccessToken: token,
+			AccessToken: os.Getenv("GOOGLE_ACCESS_TOKEN"),
 			TokenType:   "Bearer",
 		})
-	} else {
+	case cfg.CredentialsFile != "":
+		data, err := os.ReadFile(cfg.CredentialsFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading credentials file")
+		}
+		creds, err := google.CredentialsFromJSON(ctx, data, scopes...)
+		if err != nil {
+			return nil, errors.Wrap(err, "CredentialsFromJSON")
+		}
+		ts = creds.TokenSource
+	default:
 		var err error
-		ts, err = google.DefaultTokenSource(ctx, storage.ScopeReadWrite)
+		ts, err = google.DefaultTokenSource(ctx, scopes...)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	oauthClient := oauth2.NewClient(ctx, ts)
+	if cfg.ImpersonateServiceAccount == "" {
+		return ts, nil
+	}
 
-	gcsClient, err := storage.NewClient(ctx, option.WithHTTPClient(oauthClient))
-	if err != nil {
-		return nil, err
+	debug.Log("impersonating service account %v", cfg.ImpersonateServiceAccount)
+	var delegates []string
+	for _, d := range strings.Split(cfg.ImpersonateDelegates, ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			delegates = append(delegates, d)
+		}
 	}
 
-	return gcsClient, nil
+	// the IAM credentials API is called with the base credentials
+	return impersonate.CredentialsTokenSource(ctx, impersonate.CredentialsConfig{
+		TargetPrincipal: cfg.ImpersonateServiceAccount,
+		Scopes:          []string{storage.ScopeReadWrite},
+		Delegates:       delegates,
+	}, option.WithHTTPClient(oauth2.NewClient(ctx, ts)))
+}
+
+// iamScope is required by the base credentials to impersonate a service
+// account.
+const iamScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// baseScopes returns the scopes requested for the base credentials, iamScope
+// is only added if a service account is impersonated.
+func baseScopes(cfg Config) []string {
+	if cfg.ImpersonateServiceAccount == "" {
+		return []string{storage.ScopeReadWrite}
+	}
+	return []string{storage.ScopeReadWrite, iamScope}
 }
 
 func (be *Backend) bucketExists(ctx context.Context, bucket *storage.BucketHandle) (bool, error) {
@@ -98,7 +163,7 @@ const defaultListMaxItems = 1000
 func open(cfg Config, rt http.RoundTripper) (*Backend, error) {
 	debug.Log("open, config %#v", cfg)
 
-	gcsClient, err := getStorageClient(rt)
+	gcsClient, err := getStorageClient(cfg, rt)
 	if err != nil {
 		return nil, errors.Wrap(err, "getStorageClient")
 	}
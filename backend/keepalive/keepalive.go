@@ -0,0 +1,319 @@
+// Package keepalive implements a backend wrapper for repositories which are
+// kept open for a long time. It probes idle backends periodically and opens
+// the backend again if the connection was lost, for example when the ssh
+// process of the sftp backend or the rclone process exited.
+package keepalive
+
+import (
+	"context"
+	"hash"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/internal/debug"
+)
+
+// DialFunc opens the backend again after the connection was lost.
+type DialFunc func(ctx context.Context) (backend.Backend, error)
+
+// Options configure a Backend.
+type Options struct {
+	// Interval is the time after which an idle backend is probed. If zero,
+	// the backend is not probed, but still opened again if an operation
+	// fails because the connection was lost.
+	Interval time.Duration
+
+	// Timeout bounds a probe, it defaults to 30 seconds.
+	Timeout time.Duration
+
+	// OnReconnect is called with the error which caused the connection to be
+	// opened again, after the new connection was established. May be nil.
+	OnReconnect func(cause error)
+}
+
+// probeHandle is requested to check the connection, the config file exists
+// in every repository.
+var probeHandle = backend.Handle{Type: backend.ConfigFile}
+
+// Backend passes the operations to the current connection to the backend.
+// If an operation fails and a probe shows that the connection is lost, the
+// backend is opened again and the operation is run once more.
+type Backend struct {
+	// accessed atomically, first for alignment on 32 bit platforms
+	last       int64 // time of the last operation, in unix nanoseconds
+	reconnects uint64
+
+	dial DialFunc
+	opts Options
+
+	mu  sync.RWMutex
+	be  backend.Backend
+	gen uint64
+	dmu sync.Mutex // serializes reconnects
+
+	done chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+}
+
+// statically ensure that Backend implements backend.Backend.
+var _ backend.Backend = &Backend{}
+
+// New wraps be, which was opened by dial. If opts.Interval is set, a
+// goroutine probing the backend is started, it is stopped by Close.
+func New(be backend.Backend, dial DialFunc, opts Options) *Backend {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 30 * time.Second
+	}
+
+	b := &Backend{
+		dial: dial,
+		opts: opts,
+		be:   be,
+		last: time.Now().UnixNano(),
+		done: make(chan struct{}),
+	}
+
+	if opts.Interval > 0 {
+		b.wg.Add(1)
+		go b.run()
+	}
+	return b
+}
+
+// Reconnects returns how often the backend was opened again.
+func (b *Backend) Reconnects() uint64 {
+	return atomic.LoadUint64(&b.reconnects)
+}
+
+func (b *Backend) current() (backend.Backend, uint64) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.be, b.gen
+}
+
+func (b *Backend) touch() {
+	atomic.StoreInt64(&b.last, time.Now().UnixNano())
+}
+
+func (b *Backend) idle() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&b.last)))
+}
+
+// run probes the backend when it was idle for the interval.
+func (b *Backend) run() {
+	defer b.wg.Done()
+
+	ticker := time.NewTicker(b.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+		}
+
+		if b.idle() < b.opts.Interval {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), b.opts.Timeout)
+		be, gen := b.current()
+		err := probe(ctx, be)
+		if err != nil {
+			debug.Log("probe of idle backend failed: %v", err)
+			b.reconnect(ctx, gen, err)
+		}
+		cancel()
+		b.touch()
+	}
+}
+
+// probe checks whether the connection to be is usable.
+func probe(ctx context.Context, be backend.Backend) error {
+	_, err := be.Stat(ctx, probeHandle)
+	if err != nil && be.IsNotExist(err) {
+		// the backend answered
+		return nil
+	}
+	return err
+}
+
+// reconnect opens the backend again if the connection of generation gen is
+// lost. It returns true if there is a new connection on which a failed
+// operation can be run again.
+func (b *Backend) reconnect(ctx context.Context, gen uint64, cause error) bool {
+	b.dmu.Lock()
+	defer b.dmu.Unlock()
+
+	be, cur := b.current()
+	if cur != gen {
+		// another operation has already reconnected
+		return true
+	}
+	if probe(ctx, be) == nil {
+		return false
+	}
+
+	debug.Log("connection to %v lost, opening it again: %v", be.Location(), cause)
+	newBe, err := b.dial(ctx)
+	if err != nil {
+		debug.Log("unable to open backend again: %v", err)
+		return false
+	}
+
+	b.mu.Lock()
+	b.be = newBe
+	b.gen++
+	b.mu.Unlock()
+	atomic.AddUint64(&b.reconnects, 1)
+
+	if err := be.Close(); err != nil {
+		debug.Log("closing lost connection returned error: %v", err)
+	}
+
+	if b.opts.OnReconnect != nil {
+		b.opts.OnReconnect(cause)
+	}
+	return true
+}
+
+// do runs fn on the current connection, and once more on a new one if the
+// connection was lost.
+func (b *Backend) do(ctx context.Context, fn func(be backend.Backend) error) error {
+	b.touch()
+	be, gen := b.current()
+	err := fn(be)
+	if err == nil || ctx.Err() != nil || be.IsNotExist(err) {
+		return err
+	}
+
+	if !b.reconnect(ctx, gen, err) {
+		return err
+	}
+	be, _ = b.current()
+	return fn(be)
+}
+
+// Location returns the location of the backend.
+func (b *Backend) Location() string {
+	be, _ := b.current()
+	return be.Location()
+}
+
+// Connections returns the maximum number of concurrent backend operations.
+func (b *Backend) Connections() uint {
+	be, _ := b.current()
+	return be.Connections()
+}
+
+// Hasher may return a hash function for calculating a content hash for the backend.
+func (b *Backend) Hasher() hash.Hash {
+	be, _ := b.current()
+	return be.Hasher()
+}
+
+// HasAtomicReplace returns whether Save() can atomically replace files.
+func (b *Backend) HasAtomicReplace() bool {
+	be, _ := b.current()
+	return be.HasAtomicReplace()
+}
+
+// IsNotExist returns true if the error was caused by a non-existing file.
+func (b *Backend) IsNotExist(err error) bool {
+	be, _ := b.current()
+	return be.IsNotExist(err)
+}
+
+// Save stores the data from rd under the given handle.
+func (b *Backend) Save(ctx context.Context, h backend.Handle, rd backend.RewindReader) error {
+	first := true
+	return b.do(ctx, func(be backend.Backend) error {
+		if !first {
+			if err := rd.Rewind(); err != nil {
+				return err
+			}
+		}
+		first = false
+		return be.Save(ctx, h, rd)
+	})
+}
+
+// Load runs fn with a reader that yields the contents of the file at h.
+func (b *Backend) Load(ctx context.Context, h backend.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+	return b.do(ctx, func(be backend.Backend) error {
+		return be.Load(ctx, h, length, offset, fn)
+	})
+}
+
+// Stat returns information about the file identified by h.
+func (b *Backend) Stat(ctx context.Context, h backend.Handle) (fi backend.FileInfo, err error) {
+	err = b.do(ctx, func(be backend.Backend) error {
+		fi, err = be.Stat(ctx, h)
+		return err
+	})
+	return fi, err
+}
+
+// Remove removes the file described by h.
+func (b *Backend) Remove(ctx context.Context, h backend.Handle) error {
+	return b.do(ctx, func(be backend.Backend) error {
+		return be.Remove(ctx, h)
+	})
+}
+
+// List runs fn for each file of type t. If the listing is run again on a new
+// connection, fn is not called again for the files which were already
+// listed.
+func (b *Backend) List(ctx context.Context, t backend.FileType, fn func(backend.FileInfo) error) error {
+	listed := make(map[string]struct{})
+	var innerErr error
+
+	err := b.do(ctx, func(be backend.Backend) error {
+		err := be.List(ctx, t, func(fi backend.FileInfo) error {
+			if _, ok := listed[fi.Name]; ok {
+				return nil
+			}
+			listed[fi.Name] = struct{}{}
+
+			innerErr = fn(fi)
+			return innerErr
+		})
+		if innerErr != nil {
+			// an error returned by fn must not cause a reconnect
+			return nil
+		}
+		return err
+	})
+
+	if innerErr != nil {
+		return innerErr
+	}
+	return err
+}
+
+// Delete removes all data in the backend.
+func (b *Backend) Delete(ctx context.Context) error {
+	return b.do(ctx, func(be backend.Backend) error {
+		return be.Delete(ctx)
+	})
+}
+
+// Close stops probing the backend and closes the current connection.
+func (b *Backend) Close() error {
+	b.once.Do(func() { close(b.done) })
+	b.wg.Wait()
+
+	be, _ := b.current()
+	return be.Close()
+}
+
+// Unwrap returns the current connection to the backend.
+func (b *Backend) Unwrap() backend.Backend {
+	be, _ := b.current()
+	return be
+}
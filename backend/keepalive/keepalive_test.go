@@ -0,0 +1,97 @@
+package keepalive_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/backend/keepalive"
+	"github.com/konidev20/rapi/backend/mem"
+	"github.com/konidev20/rapi/backend/mock"
+	rtest "github.com/konidev20/rapi/internal/test"
+)
+
+var errLost = errors.New("connection lost")
+
+// lostBackend returns a backend whose connection is lost, and a pointer to
+// a flag which is set when it is closed.
+func lostBackend() (*mock.Backend, *bool) {
+	closed := false
+	be := mock.NewBackend()
+	be.StatFn = func(context.Context, backend.Handle) (backend.FileInfo, error) {
+		return backend.FileInfo{}, errLost
+	}
+	be.CloseFn = func() error {
+		closed = true
+		return nil
+	}
+	return be, &closed
+}
+
+func newRepo(t *testing.T) backend.Backend {
+	be := mem.New()
+	h := backend.Handle{Type: backend.ConfigFile}
+	rtest.OK(t, be.Save(context.TODO(), h, backend.NewByteReader([]byte("config"), be.Hasher())))
+	return be
+}
+
+func TestReconnectOnError(t *testing.T) {
+	ctx := context.TODO()
+	lost, closed := lostBackend()
+	repo := newRepo(t)
+
+	var causes []error
+	be := keepalive.New(lost, func(context.Context) (backend.Backend, error) {
+		return repo, nil
+	}, keepalive.Options{OnReconnect: func(cause error) { causes = append(causes, cause) }})
+	defer func() { rtest.OK(t, be.Close()) }()
+
+	fi, err := be.Stat(ctx, backend.Handle{Type: backend.ConfigFile})
+	rtest.OK(t, err)
+	rtest.Equals(t, int64(6), fi.Size)
+	rtest.Assert(t, *closed, "lost connection was not closed")
+	rtest.Equals(t, uint64(1), be.Reconnects())
+	rtest.Equals(t, []error{errLost}, causes)
+
+	// a missing file does not cause a reconnect
+	_, err = be.Stat(ctx, backend.Handle{Type: backend.PackFile, Name: "missing"})
+	rtest.Assert(t, be.IsNotExist(err), "unexpected error %v", err)
+	rtest.Equals(t, uint64(1), be.Reconnects())
+}
+
+func TestReconnectFailed(t *testing.T) {
+	lost, closed := lostBackend()
+	errDial := errors.New("dial failed")
+	be := keepalive.New(lost, func(context.Context) (backend.Backend, error) {
+		return nil, errDial
+	}, keepalive.Options{})
+
+	_, err := be.Stat(context.TODO(), backend.Handle{Type: backend.ConfigFile})
+	rtest.Assert(t, errors.Is(err, errLost), "unexpected error %v", err)
+	rtest.Assert(t, !*closed, "connection was closed")
+	rtest.Equals(t, uint64(0), be.Reconnects())
+}
+
+func TestProbeIdle(t *testing.T) {
+	lost, _ := lostBackend()
+	repo := newRepo(t)
+
+	reconnected := make(chan error, 1)
+	be := keepalive.New(lost, func(context.Context) (backend.Backend, error) {
+		return repo, nil
+	}, keepalive.Options{
+		Interval:    5 * time.Millisecond,
+		OnReconnect: func(cause error) { reconnected <- cause },
+	})
+	defer func() { rtest.OK(t, be.Close()) }()
+
+	select {
+	case cause := <-reconnected:
+		rtest.Equals(t, errLost, cause)
+	case <-time.After(5 * time.Second):
+		t.Fatal("idle backend was not probed")
+	}
+	rtest.Equals(t, repo, be.Unwrap())
+}
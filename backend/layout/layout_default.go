@@ -1,7 +1,7 @@
 package layout
 
 import (
-	"encoding/hex"
+	"fmt"
 
 	"github.com/konidev20/rapi/backend"
 )
@@ -13,6 +13,23 @@ import (
 type DefaultLayout struct {
 	Path string
 	Join func(...string) string
+
+	// SubdirLen is the number of characters of the file name which are used
+	// for the subdirs of the `data` directory, zero selects two characters.
+	// Repositories with any other length cannot be read by restic or
+	// rest-server.
+	SubdirLen int
+}
+
+// DefaultSubdirLen is the default number of characters of the subdirs of the
+// `data` directory.
+const DefaultSubdirLen = 2
+
+func (l *DefaultLayout) subdirLen() int {
+	if l.SubdirLen == 0 {
+		return DefaultSubdirLen
+	}
+	return l.SubdirLen
 }
 
 var defaultLayoutPaths = map[backend.FileType]string{
@@ -36,8 +53,8 @@ func (l *DefaultLayout) Name() string {
 func (l *DefaultLayout) Dirname(h backend.Handle) string {
 	p := defaultLayoutPaths[h.Type]
 
-	if h.Type == backend.PackFile && len(h.Name) > 2 {
-		p = l.Join(p, h.Name[:2]) + "/"
+	if n := l.subdirLen(); h.Type == backend.PackFile && len(h.Name) > n {
+		p = l.Join(p, h.Name[:n]) + "/"
 	}
 
 	return l.Join(l.Path, p) + "/"
@@ -60,8 +77,9 @@ func (l *DefaultLayout) Paths() (dirs []string) {
 	}
 
 	// also add subdirs
-	for i := 0; i < 256; i++ {
-		subdir := hex.EncodeToString([]byte{byte(i)})
+	n := l.subdirLen()
+	for i := 0; i < 1<<(4*n); i++ {
+		subdir := fmt.Sprintf("%0*x", n, i)
 		dirs = append(dirs, l.Join(l.Path, defaultLayoutPaths[backend.PackFile], subdir))
 	}
 
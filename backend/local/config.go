@@ -13,6 +13,47 @@ type Config struct {
 	Layout string `option:"layout" help:"use this backend directory layout (default: auto-detect)"`
 
 	Connections uint `option:"connections" help:"set a limit for the number of concurrent operations (default: 2)"`
+
+	Sync string `option:"sync" help:"when written files are synced to disk: always, batch or never (default: always)"`
+
+	DirectIO bool `option:"direct-io" help:"write pack files with O_DIRECT, bypassing the page cache (Linux only)"`
+
+	// Fanout and AllowIncompatibleFanout select the number of subdirectories
+	// of the data directory. Repositories with a fan-out other than 256
+	// cannot be read by restic or rest-server.
+	Fanout                  uint `option:"fanout" help:"number of subdirectories for pack files: 16, 256 or 4096 (default: auto-detect, 256 for new repositories)"`
+	AllowIncompatibleFanout bool `option:"allow-incompatible-fanout" help:"allow a fanout of 16 or 4096, restic and rest-server cannot read such repositories"`
+}
+
+// Sync modes for Config.Sync.
+const (
+	// SyncAlways syncs each file and its directory before Save returns.
+	SyncAlways = "always"
+	// SyncBatch syncs each file, but defers syncing the directories of pack
+	// files until other files are saved, the backend is closed or enough
+	// pack files were saved.
+	SyncBatch = "batch"
+	// SyncNever leaves syncing to the operating system.
+	SyncNever = "never"
+)
+
+// fanoutSubdirLen maps the supported fan-outs to the length of the subdir
+// names.
+var fanoutSubdirLen = map[uint]int{
+	16:   1,
+	256:  2,
+	4096: 3,
+}
+
+// syncMode returns the sync mode, an empty value selects SyncAlways.
+func (cfg Config) syncMode() (string, error) {
+	switch cfg.Sync {
+	case "", SyncAlways:
+		return SyncAlways, nil
+	case SyncBatch, SyncNever:
+		return cfg.Sync, nil
+	}
+	return "", errors.Errorf("invalid sync mode %q, must be one of always, batch or never", cfg.Sync)
 }
 
 // NewConfig returns a new config with default options applied.
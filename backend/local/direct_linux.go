@@ -0,0 +1,90 @@
+package local
+
+import (
+	"io"
+	"os"
+	"unsafe"
+
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/errors"
+
+	"golang.org/x/sys/unix"
+)
+
+const directIOSupported = true
+
+// directIOAlignment is the alignment of the buffers, offsets and lengths for
+// writes with O_DIRECT.
+const directIOAlignment = 4096
+
+// directIOBufferSize is the size of the writes with O_DIRECT.
+const directIOBufferSize = 1024 * 1024
+
+// writeDirect copies rd to f with O_DIRECT, bypassing the page cache. Only the
+// unaligned tail of the data is written with buffered I/O. If the filesystem
+// does not support O_DIRECT, the data is copied normally.
+func writeDirect(f *os.File, rd io.Reader) (int64, error) {
+	if err := setDirectIO(f, true); err != nil {
+		debug.Log("enabling O_DIRECT for %v failed: %v", f.Name(), err)
+		return io.Copy(f, rd)
+	}
+
+	buf := alignedBuffer(directIOBufferSize)
+	var written int64
+	for {
+		n, err := io.ReadFull(rd, buf)
+		if err == nil {
+			wn, err := f.Write(buf)
+			written += int64(wn)
+			if err != nil {
+				return written, err
+			}
+			continue
+		}
+		if err != io.EOF && err != io.ErrUnexpectedEOF {
+			return written, err
+		}
+
+		aligned := n &^ (directIOAlignment - 1)
+		wn, err := f.Write(buf[:aligned])
+		written += int64(wn)
+		if err != nil {
+			return written, err
+		}
+
+		if aligned < n {
+			if err := setDirectIO(f, false); err != nil {
+				return written, errors.WithStack(err)
+			}
+			wn, err = f.Write(buf[aligned:n])
+			written += int64(wn)
+		}
+		return written, err
+	}
+}
+
+func setDirectIO(f *os.File, enable bool) error {
+	flags, err := unix.FcntlInt(f.Fd(), unix.F_GETFL, 0)
+	if err != nil {
+		return err
+	}
+
+	if enable {
+		flags |= unix.O_DIRECT
+	} else {
+		flags &^= unix.O_DIRECT
+	}
+	_, err = unix.FcntlInt(f.Fd(), unix.F_SETFL, flags)
+	return err
+}
+
+// alignedBuffer returns a buffer of the given size which starts at a multiple
+// of directIOAlignment.
+func alignedBuffer(size int) []byte {
+	buf := make([]byte, size+directIOAlignment)
+	offset := 0
+	if rem := int(uintptr(unsafe.Pointer(&buf[0])) & (directIOAlignment - 1)); rem != 0 {
+		offset = directIOAlignment - rem
+	}
+	return buf[offset : offset+size]
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package local
+
+import (
+	"io"
+	"os"
+)
+
+const directIOSupported = false
+
+func writeDirect(f *os.File, rd io.Reader) (int64, error) {
+	return io.Copy(f, rd)
+}
@@ -25,23 +25,41 @@ type Local struct {
 	Config
 	layout.Layout
 	util.Modes
+
+	syncer *dirSyncer
 }
 
 // ensure statically that *Local implements backend.Backend.
 var _ backend.Backend = &Local{}
 
 func NewFactory() location.Factory {
-	return location.NewLimitedBackendFactory("local", ParseConfig, location.NoPassword, limiter.WrapBackendConstructor(Create), limiter.WrapBackendConstructor(Open))
+	return location.WithCapabilities(
+		location.NewLimitedBackendFactory("local", ParseConfig, location.NoPassword, limiter.WrapBackendConstructor(Create), limiter.WrapBackendConstructor(Open)),
+		backend.Capabilities{RangedReads: true, AtomicWrites: true, LocalStorage: true},
+	)
 }
 
 const defaultLayout = "default"
 
 func open(ctx context.Context, cfg Config) (*Local, error) {
+	mode, err := cfg.syncMode()
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.DirectIO && !directIOSupported {
+		return nil, errors.New("direct-io is not supported on this platform")
+	}
+
 	l, err := layout.ParseLayout(ctx, &layout.LocalFilesystem{}, cfg.Layout, defaultLayout, cfg.Path)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := setFanout(l, cfg.Fanout, cfg.AllowIncompatibleFanout); err != nil {
+		return nil, err
+	}
+
 	fi, err := fs.Stat(l.Filename(backend.Handle{Type: backend.ConfigFile}))
 	m := util.DeriveModesFromFileInfo(fi, err)
 	debug.Log("using (%03O file, %03O dir) permissions", m.File, m.Dir)
@@ -50,9 +68,79 @@ func open(ctx context.Context, cfg Config) (*Local, error) {
 		Config: cfg,
 		Layout: l,
 		Modes:  m,
+		syncer: newDirSyncer(mode),
 	}, nil
 }
 
+// setFanout configures the number of subdirs for pack files. Without an
+// explicit fan-out, it is detected from the existing subdirs. A fan-out other
+// than 256 is only accepted if allowIncompatible is set.
+func setFanout(l layout.Layout, fanout uint, allowIncompatible bool) error {
+	dl, ok := l.(*layout.DefaultLayout)
+	if !ok {
+		if fanout != 0 {
+			return errors.Errorf("fanout is not supported by layout %v", l.Name())
+		}
+		return nil
+	}
+
+	detected := detectSubdirLen(dl)
+	if fanout == 0 {
+		dl.SubdirLen = detected
+		return nil
+	}
+
+	n, ok := fanoutSubdirLen[fanout]
+	if !ok {
+		return errors.Errorf("invalid fanout %d, must be one of 16, 256 or 4096", fanout)
+	}
+	if n != layout.DefaultSubdirLen && !allowIncompatible {
+		return errors.Errorf("fanout %d is not compatible with restic and rest-server, set allow-incompatible-fanout to use it", fanout)
+	}
+	if detected != 0 && detected != n {
+		return errors.Errorf("fanout %d does not match the existing repository", fanout)
+	}
+	dl.SubdirLen = n
+	return nil
+}
+
+// detectSubdirLen returns the length of the subdirs for pack files. It
+// returns zero if there are no subdirs yet or if they are not all lowercase
+// hex names of the same, supported length.
+func detectSubdirLen(l *layout.DefaultLayout) int {
+	dir, _ := l.Basedir(backend.PackFile)
+	entries, err := fs.ReadDir(dir)
+	if err != nil {
+		return 0
+	}
+
+	n := 0
+	for _, e := range entries {
+		if !isDir(dir, e) {
+			continue
+		}
+		name := e.Name()
+		if _, ok := fanoutSubdirLen[1<<(4*uint(len(name)))]; !ok || !isLowerHex(name) {
+			return 0
+		}
+		if n != 0 && len(name) != n {
+			return 0
+		}
+		n = len(name)
+	}
+	return n
+}
+
+// isLowerHex returns true if s only consists of lowercase hex digits.
+func isLowerHex(s string) bool {
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
 // Open opens the local backend as specified by config.
 func Open(ctx context.Context, cfg Config) (*Local, error) {
 	debug.Log("open local backend at %v (layout %q)", cfg.Path, cfg.Layout)
@@ -111,7 +199,7 @@ func (b *Local) IsNotExist(err error) bool {
 }
 
 // Save stores data in the backend at the handle.
-func (b *Local) Save(_ context.Context, h backend.Handle, rd backend.RewindReader) (err error) {
+func (b *Local) Save(ctx context.Context, h backend.Handle, rd backend.RewindReader) (err error) {
 	finalname := b.Filename(h)
 	dir := filepath.Dir(finalname)
 
@@ -162,7 +250,12 @@ func (b *Local) Save(_ context.Context, h backend.Handle, rd backend.RewindReade
 	}
 
 	// save data, then sync
-	wbytes, err := io.Copy(f, rd)
+	var wbytes int64
+	if b.DirectIO && h.Type == backend.PackFile {
+		wbytes, err = writeDirect(f, rd)
+	} else {
+		wbytes, err = io.Copy(f, rd)
+	}
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -172,23 +265,30 @@ func (b *Local) Save(_ context.Context, h backend.Handle, rd backend.RewindReade
 	}
 
 	// Ignore error if filesystem does not support fsync.
-	err = f.Sync()
-	syncNotSup := err != nil && (errors.Is(err, syscall.ENOTSUP) || isMacENOTTY(err))
-	if err != nil && !syncNotSup {
-		return errors.WithStack(err)
+	syncNotSup := !b.syncer.syncFiles()
+	if !syncNotSup {
+		err = f.Sync()
+		syncNotSup = err != nil && (errors.Is(err, syscall.ENOTSUP) || isMacENOTTY(err))
+		if err != nil && !syncNotSup {
+			return errors.WithStack(err)
+		}
 	}
 
 	// Close, then rename. Windows doesn't like the reverse order.
 	if err = f.Close(); err != nil {
 		return errors.WithStack(err)
 	}
+	// do not commit the file if the operation was cancelled meanwhile
+	if err = ctx.Err(); err != nil {
+		return err
+	}
 	if err = os.Rename(f.Name(), finalname); err != nil {
 		return errors.WithStack(err)
 	}
 
 	// Now sync the directory to commit the Rename.
 	if !syncNotSup {
-		err = fsyncDir(dir)
+		err = b.syncer.syncDir(h, dir)
 		if err != nil {
 			return errors.WithStack(err)
 		}
@@ -264,7 +364,7 @@ func (b *Local) List(ctx context.Context, t backend.FileType, fn func(backend.Fi
 	if subdirs {
 		err = visitDirs(ctx, basedir, fn)
 	} else {
-		err = visitFiles(ctx, basedir, fn, false)
+		err = visitFiles(ctx, basedir, fn)
 	}
 
 	if b.IsNotExist(err) {
@@ -278,27 +378,21 @@ func (b *Local) List(ctx context.Context, t backend.FileType, fn func(backend.Fi
 // The following two functions are like filepath.Walk, but visit only one or
 // two levels of directory structure (including dir itself as the first level).
 // Also, visitDirs assumes it sees a directory full of directories, while
-// visitFiles wants a directory full or regular files.
+// visitFiles wants a directory full or regular files. Both read each
+// directory in a single pass and use the file types it returns, so that only
+// the size of the files requires a system call per entry.
 func visitDirs(ctx context.Context, dir string, fn func(backend.FileInfo) error) error {
-	d, err := fs.Open(dir)
+	sub, err := fs.ReadDir(dir)
 	if err != nil {
 		return err
 	}
 
-	sub, err := d.Readdirnames(-1)
-	if err != nil {
-		// ignore subsequent errors
-		_ = d.Close()
-		return err
-	}
-
-	err = d.Close()
-	if err != nil {
-		return err
-	}
+	for _, e := range sub {
+		if !isDir(dir, e) {
+			continue
+		}
 
-	for _, f := range sub {
-		err = visitFiles(ctx, filepath.Join(dir, f), fn, true)
+		err = visitFiles(ctx, filepath.Join(dir, e.Name()), fn)
 		if err != nil {
 			return err
 		}
@@ -306,41 +400,29 @@ func visitDirs(ctx context.Context, dir string, fn func(backend.FileInfo) error)
 	return ctx.Err()
 }
 
-func visitFiles(ctx context.Context, dir string, fn func(backend.FileInfo) error, ignoreNotADirectory bool) error {
-	d, err := fs.Open(dir)
+func visitFiles(ctx context.Context, dir string, fn func(backend.FileInfo) error) error {
+	sub, err := fs.ReadDir(dir)
 	if err != nil {
 		return err
 	}
 
-	if ignoreNotADirectory {
-		fi, err := d.Stat()
-		if err != nil || !fi.IsDir() {
-			// ignore subsequent errors
-			_ = d.Close()
-			return err
-		}
-	}
-
-	sub, err := d.Readdir(-1)
-	if err != nil {
-		// ignore subsequent errors
-		_ = d.Close()
-		return err
-	}
-
-	err = d.Close()
-	if err != nil {
-		return err
-	}
-
-	for _, fi := range sub {
+	for _, e := range sub {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
 		default:
 		}
 
-		err := fn(backend.FileInfo{
+		fi, err := e.Info()
+		if errors.Is(err, os.ErrNotExist) {
+			// the file was removed after reading the directory
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		err = fn(backend.FileInfo{
 			Name: fi.Name(),
 			Size: fi.Size(),
 		})
@@ -351,6 +433,16 @@ func visitFiles(ctx context.Context, dir string, fn func(backend.FileInfo) error
 	return nil
 }
 
+// isDir returns true if the entry e in dir is a directory, following symlinks.
+func isDir(dir string, e os.DirEntry) bool {
+	if e.Type()&os.ModeSymlink == 0 {
+		return e.IsDir()
+	}
+
+	fi, err := fs.Stat(filepath.Join(dir, e.Name()))
+	return err == nil && fi.IsDir()
+}
+
 // Delete removes the repository and all files.
 func (b *Local) Delete(_ context.Context) error {
 	return fs.RemoveAll(b.Path)
@@ -358,7 +450,7 @@ func (b *Local) Delete(_ context.Context) error {
 
 // Close closes all open files.
 func (b *Local) Close() error {
-	// this does not need to do anything, all open files are closed within the
-	// same function.
-	return nil
+	// all open files are closed within the same function, only the deferred
+	// directory syncs remain.
+	return errors.WithStack(b.syncer.flush())
 }
@@ -5,11 +5,14 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"syscall"
 	"testing"
 
 	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/backend/layout"
 	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/restic"
 
 	"github.com/cenkalti/backoff/v4"
 )
@@ -40,3 +43,79 @@ func TestNoSpacePermanent(t *testing.T) {
 	rtest.Assert(t, errors.Is(err, syscall.ENOSPC),
 		"could not recover original ENOSPC error")
 }
+
+func TestSyncBatch(t *testing.T) {
+	dir := rtest.TempDir(t)
+	be, err := Create(context.Background(), Config{Path: dir, Connections: 2, Sync: SyncBatch})
+	rtest.OK(t, err)
+
+	data := []byte("foobar")
+	id := restic.Hash(data)
+	h := backend.Handle{Type: backend.PackFile, Name: id.String()}
+	rtest.OK(t, be.Save(context.Background(), h, backend.NewByteReader(data, nil)))
+	rtest.Equals(t, 1, len(be.syncer.pending))
+
+	h = backend.Handle{Type: backend.IndexFile, Name: id.String()}
+	rtest.OK(t, be.Save(context.Background(), h, backend.NewByteReader(data, nil)))
+	rtest.Equals(t, 0, len(be.syncer.pending))
+
+	h = backend.Handle{Type: backend.PackFile, Name: restic.Hash([]byte("foo")).String()}
+	rtest.OK(t, be.Save(context.Background(), h, backend.NewByteReader([]byte("foo"), nil)))
+	rtest.Equals(t, 1, len(be.syncer.pending))
+	rtest.OK(t, be.Close())
+	rtest.Equals(t, 0, len(be.syncer.pending))
+}
+
+func TestInvalidSyncMode(t *testing.T) {
+	_, err := Open(context.Background(), Config{Path: rtest.TempDir(t), Connections: 2, Sync: "sometimes"})
+	rtest.Assert(t, err != nil, "invalid sync mode accepted")
+}
+
+func TestFanout(t *testing.T) {
+	dir := rtest.TempDir(t)
+	_, err := Create(context.Background(), Config{Path: dir, Connections: 2, Fanout: 16})
+	rtest.Assert(t, err != nil, "incompatible fanout accepted without opt-in")
+
+	be, err := Create(context.Background(), Config{Path: dir, Connections: 2, Fanout: 16, AllowIncompatibleFanout: true})
+	rtest.OK(t, err)
+	rtest.OK(t, be.Close())
+
+	data := []byte("foobar")
+	id := restic.Hash(data)
+	h := backend.Handle{Type: backend.PackFile, Name: id.String()}
+	rtest.Equals(t, filepath.Join(dir, "data", id.String()[:1], id.String()), be.Filename(h))
+
+	// the fan-out is detected when opening the repository
+	be, err = Open(context.Background(), Config{Path: dir, Connections: 2})
+	rtest.OK(t, err)
+	rtest.Equals(t, be.Filename(h), filepath.Join(dir, "data", id.String()[:1], id.String()))
+	rtest.OK(t, be.Close())
+
+	_, err = Open(context.Background(), Config{Path: dir, Connections: 2, Fanout: 256})
+	rtest.Assert(t, err != nil, "mismatching fanout accepted")
+	_, err = Open(context.Background(), Config{Path: dir, Connections: 2, Fanout: 42})
+	rtest.Assert(t, err != nil, "invalid fanout accepted")
+}
+
+func TestDetectSubdirLen(t *testing.T) {
+	for _, test := range []struct {
+		dirs []string
+		n    int
+	}{
+		{nil, 0},
+		{[]string{"0", "f"}, 1},
+		{[]string{"00", "ab"}, 2},
+		{[]string{"000", "fff"}, 3},
+		{[]string{"00", "abc"}, 0},
+		{[]string{"AB"}, 0},
+		{[]string{"xy"}, 0},
+		{[]string{"0000"}, 0},
+	} {
+		dir := rtest.TempDir(t)
+		l := &layout.DefaultLayout{Path: dir, Join: filepath.Join}
+		for _, d := range test.dirs {
+			rtest.OK(t, os.MkdirAll(filepath.Join(dir, "data", d), 0700))
+		}
+		rtest.Equals(t, test.n, detectSubdirLen(l))
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"os"
 	"path/filepath"
+	"runtime"
 	"testing"
 
 	"github.com/konidev20/rapi/backend/local"
@@ -11,7 +12,7 @@ import (
 	rtest "github.com/konidev20/rapi/internal/test"
 )
 
-func newTestSuite(t testing.TB) *test.Suite[local.Config] {
+func newTestSuite(t testing.TB, opts ...func(*local.Config)) *test.Suite[local.Config] {
 	return &test.Suite[local.Config]{
 		// NewConfig returns a config for a new temporary backend that will be used in tests.
 		NewConfig: func() (*local.Config, error) {
@@ -22,6 +23,9 @@ func newTestSuite(t testing.TB) *test.Suite[local.Config] {
 				Path:        dir,
 				Connections: 2,
 			}
+			for _, opt := range opts {
+				opt(cfg)
+			}
 			return cfg, nil
 		},
 
@@ -33,6 +37,15 @@ func TestBackend(t *testing.T) {
 	newTestSuite(t).RunTests(t)
 }
 
+func TestBackendTuned(t *testing.T) {
+	newTestSuite(t, func(cfg *local.Config) {
+		cfg.Sync = local.SyncNever
+		cfg.Fanout = 16
+		cfg.AllowIncompatibleFanout = true
+		cfg.DirectIO = runtime.GOOS == "linux"
+	}).RunTests(t)
+}
+
 func BenchmarkBackend(t *testing.B) {
 	newTestSuite(t).RunBenchmarks(t)
 }
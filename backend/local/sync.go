@@ -0,0 +1,84 @@
+package local
+
+import (
+	"sync"
+
+	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/internal/debug"
+)
+
+// syncBatchSize is the number of pack files after which deferred directory
+// syncs are flushed in batch mode.
+const syncBatchSize = 64
+
+// dirSyncer syncs the directories which contain newly saved files according
+// to the sync mode.
+type dirSyncer struct {
+	mode string
+
+	m       sync.Mutex
+	pending map[string]struct{}
+	saved   int
+}
+
+func newDirSyncer(mode string) *dirSyncer {
+	return &dirSyncer{
+		mode:    mode,
+		pending: make(map[string]struct{}),
+	}
+}
+
+// syncFiles returns true if written files must be synced.
+func (s *dirSyncer) syncFiles() bool {
+	return s.mode != SyncNever
+}
+
+// syncDir is called after the file h was renamed into dir. In batch mode, the
+// sync of directories containing pack files is deferred. Saving any other
+// file first flushes the deferred syncs, so that files which reference pack
+// files never become durable before them.
+func (s *dirSyncer) syncDir(h backend.Handle, dir string) error {
+	switch s.mode {
+	case SyncNever:
+		return nil
+	case SyncBatch:
+		if h.Type == backend.PackFile {
+			s.m.Lock()
+			s.pending[dir] = struct{}{}
+			s.saved++
+			full := s.saved >= syncBatchSize
+			s.m.Unlock()
+
+			if full {
+				return s.flush()
+			}
+			return nil
+		}
+
+		if err := s.flush(); err != nil {
+			return err
+		}
+	}
+
+	return fsyncDir(dir)
+}
+
+// flush syncs all directories with deferred syncs.
+func (s *dirSyncer) flush() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if len(s.pending) == 0 {
+		return nil
+	}
+
+	debug.Log("syncing %d directories for %d files", len(s.pending), s.saved)
+	for dir := range s.pending {
+		if err := fsyncDir(dir); err != nil {
+			return err
+		}
+		delete(s.pending, dir)
+	}
+	s.saved = 0
+	return nil
+}
@@ -2,6 +2,7 @@
 package location
 
 import (
+	"net/url"
 	"strings"
 
 	"github.com/konidev20/rapi/internal/errors"
@@ -51,9 +52,11 @@ func isPath(s string) bool {
 
 // Parse extracts repository location information from the string s. If s
 // starts with a backend name followed by a colon, that backend's Parse()
-// function is called. Otherwise, the local backend is used which interprets s
-// as the name of a directory.
+// function is called. Plain http and https URLs are converted with Detect.
+// Otherwise, the local backend is used which interprets s as the name of a
+// directory.
 func Parse(registry *Registry, s string) (u Location, err error) {
+	s = detect(registry, s)
 	scheme := extractScheme(s)
 	u.Scheme = scheme
 
@@ -88,6 +91,7 @@ func Parse(registry *Registry, s string) (u Location, err error) {
 
 // StripPassword returns a displayable version of a repository location (with any sensitive information removed)
 func StripPassword(registry *Registry, s string) string {
+	s = detect(registry, s)
 	scheme := extractScheme(s)
 
 	factory := registry.Lookup(scheme)
@@ -101,3 +105,40 @@ func extractScheme(s string) string {
 	scheme, _, _ := strings.Cut(s, ":")
 	return scheme
 }
+
+// Detect returns the repository location for a plain http or https URL. URLs
+// of Amazon S3 and Google Cloud Storage are converted to s3 and gs
+// locations, for example "https://storage.googleapis.com/bucket/dir" to
+// "gs:bucket:/dir". Other URLs are assumed to point to a REST server. All
+// other strings are returned unchanged.
+func Detect(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return s
+	}
+
+	host := strings.ToLower(u.Hostname())
+	switch {
+	case host == "amazonaws.com" || strings.HasSuffix(host, ".amazonaws.com"):
+		return "s3:" + s
+	case host == "storage.googleapis.com":
+		bucket, dir, _ := strings.Cut(strings.TrimPrefix(u.Path, "/"), "/")
+		if bucket == "" {
+			return s
+		}
+		return "gs:" + bucket + ":/" + dir
+	case strings.HasSuffix(host, ".blob.core.windows.net"):
+		// the account name is not part of azure locations
+		return s
+	}
+	return "rest:" + s
+}
+
+// detect applies Detect to s unless its scheme is a registered backend.
+func detect(registry *Registry, s string) string {
+	scheme := extractScheme(s)
+	if (scheme != "http" && scheme != "https") || registry.Lookup(scheme) != nil {
+		return s
+	}
+	return Detect(s)
+}
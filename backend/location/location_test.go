@@ -74,3 +74,33 @@ func TestInvalidScheme(t *testing.T) {
 		})
 	}
 }
+
+func TestFactoryCapabilities(t *testing.T) {
+	test.Equals(t, backend.Capabilities{}, testFactory().Capabilities())
+
+	caps := backend.Capabilities{RangedReads: true, MaxObjectSize: 1024}
+	f := location.WithCapabilities(testFactory(), caps)
+	test.Equals(t, caps, f.Capabilities())
+	test.Equals(t, "local", f.Scheme())
+
+	registry := location.NewRegistry()
+	registry.Register(f)
+	test.Equals(t, caps, registry.Lookup("local").Capabilities())
+}
+
+func TestDetect(t *testing.T) {
+	for _, tc := range []struct {
+		s, loc string
+	}{
+		{"https://s3.amazonaws.com/bucket/dir", "s3:https://s3.amazonaws.com/bucket/dir"},
+		{"https://s3.eu-central-1.amazonaws.com/bucket", "s3:https://s3.eu-central-1.amazonaws.com/bucket"},
+		{"https://storage.googleapis.com/bucket/dir/sub", "gs:bucket:/dir/sub"},
+		{"https://storage.googleapis.com/bucket", "gs:bucket:/"},
+		{"http://backup.example.com:8000/repo", "rest:http://backup.example.com:8000/repo"},
+		{"https://account.blob.core.windows.net/container", "https://account.blob.core.windows.net/container"},
+		{"/srv/repo", "/srv/repo"},
+		{"sftp:host:/repo", "sftp:host:/repo"},
+	} {
+		test.Equals(t, tc.loc, location.Detect(tc.s))
+	}
+}
@@ -3,6 +3,7 @@ package location
 import (
 	"context"
 	"net/http"
+	"sort"
 
 	"github.com/konidev20/rapi/backend"
 	"github.com/konidev20/rapi/backend/limiter"
@@ -29,12 +30,40 @@ func (r *Registry) Lookup(scheme string) Factory {
 	return r.factories[scheme]
 }
 
+// Schemes returns the schemes of the registered backends, sorted by name.
+func (r *Registry) Schemes() []string {
+	schemes := make([]string, 0, len(r.factories))
+	for scheme := range r.factories {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
 type Factory interface {
 	Scheme() string
 	ParseConfig(s string) (interface{}, error)
 	StripPassword(s string) string
 	Create(ctx context.Context, cfg interface{}, rt http.RoundTripper, lim limiter.Limiter) (backend.Backend, error)
 	Open(ctx context.Context, cfg interface{}, rt http.RoundTripper, lim limiter.Limiter) (backend.Backend, error)
+
+	// Capabilities returns the features supported by the backend type.
+	Capabilities() backend.Capabilities
+}
+
+// WithCapabilities returns a factory which reports caps as the capabilities
+// of the backends created by f.
+func WithCapabilities(f Factory, caps backend.Capabilities) Factory {
+	return &capabilitiesFactory{Factory: f, caps: caps}
+}
+
+type capabilitiesFactory struct {
+	Factory
+	caps backend.Capabilities
+}
+
+func (f *capabilitiesFactory) Capabilities() backend.Capabilities {
+	return f.caps
 }
 
 type genericBackendFactory[C any, T backend.Backend] struct {
@@ -58,6 +87,12 @@ func (f *genericBackendFactory[C, T]) StripPassword(s string) string {
 	}
 	return s
 }
+
+// Capabilities returns no capabilities, use WithCapabilities to set them.
+func (f *genericBackendFactory[C, T]) Capabilities() backend.Capabilities {
+	return backend.Capabilities{}
+}
+
 func (f *genericBackendFactory[C, T]) Create(ctx context.Context, cfg interface{}, rt http.RoundTripper, lim limiter.Limiter) (backend.Backend, error) {
 	return f.createFn(ctx, *cfg.(*C), rt, lim)
 }
@@ -2,21 +2,111 @@ package logger
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"math/rand"
+	"sync"
+	"time"
 
 	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/backend/util"
 	"github.com/konidev20/rapi/internal/debug"
 )
 
+// Entry describes a single request to the backend.
+type Entry struct {
+	Method   string
+	Handle   backend.Handle
+	Duration time.Duration
+
+	// Bytes is the number of bytes sent for Save and received for Load.
+	Bytes int64
+
+	// Error is the redacted error message, empty if the request succeeded.
+	Error string
+}
+
+func (e Entry) String() string {
+	s := fmt.Sprintf("%v(%v) took %v", e.Method, e.Handle, e.Duration)
+	if e.Bytes > 0 {
+		s += fmt.Sprintf(", %d bytes", e.Bytes)
+	}
+	if e.Error != "" {
+		s += ", error: " + e.Error
+	}
+	return s
+}
+
+// Options configure how requests are logged.
+type Options struct {
+	// Log is called for each logged request. If nil, the requests are written
+	// to the debug log.
+	Log func(Entry)
+
+	// SampleRate is the fraction of successful requests which are logged, a
+	// value of zero or at least one logs all requests. Failed requests are
+	// always logged.
+	SampleRate float64
+}
+
 type Backend struct {
 	backend.Backend
+
+	opts Options
+
+	mu  sync.Mutex
+	rnd *rand.Rand
 }
 
 // statically ensure that Backend implements backend.Backend.
 var _ backend.Backend = &Backend{}
 
+// New returns a backend which writes all requests to the debug log.
 func New(be backend.Backend) *Backend {
-	return &Backend{Backend: be}
+	return NewWithOptions(be, Options{})
+}
+
+// NewWithOptions returns a backend which logs requests as configured by opts.
+func NewWithOptions(be backend.Backend, opts Options) *Backend {
+	if opts.Log == nil {
+		opts.Log = func(e Entry) {
+			debug.Log("%v", e)
+		}
+	}
+
+	return &Backend{
+		Backend: be,
+		opts:    opts,
+		rnd:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// sampled returns true if a successful request should be logged.
+func (be *Backend) sampled() bool {
+	if be.opts.SampleRate <= 0 || be.opts.SampleRate >= 1 {
+		return true
+	}
+
+	be.mu.Lock()
+	defer be.mu.Unlock()
+	return be.rnd.Float64() < be.opts.SampleRate
+}
+
+func (be *Backend) log(method string, h backend.Handle, start time.Time, bytes int64, err error) {
+	if err == nil && !be.sampled() {
+		return
+	}
+
+	e := Entry{
+		Method:   method,
+		Handle:   h,
+		Duration: time.Since(start),
+		Bytes:    bytes,
+	}
+	if err != nil {
+		e.Error = Redact(err.Error())
+	}
+	be.opts.Log(e)
 }
 
 func (be *Backend) IsNotExist(err error) bool {
@@ -27,52 +117,59 @@ func (be *Backend) IsNotExist(err error) bool {
 
 // Save adds new Data to the backend.
 func (be *Backend) Save(ctx context.Context, h backend.Handle, rd backend.RewindReader) error {
-	debug.Log("Save(%v, %v)", h, rd.Length())
+	start := time.Now()
 	err := be.Backend.Save(ctx, h, rd)
-	debug.Log("  save err %v", err)
+	be.log("Save", h, start, rd.Length(), err)
 	return err
 }
 
 // Remove deletes a file from the backend.
 func (be *Backend) Remove(ctx context.Context, h backend.Handle) error {
-	debug.Log("Remove(%v)", h)
+	start := time.Now()
 	err := be.Backend.Remove(ctx, h)
-	debug.Log("  remove err %v", err)
+	be.log("Remove", h, start, 0, err)
 	return err
 }
 
 func (be *Backend) Load(ctx context.Context, h backend.Handle, length int, offset int64, fn func(io.Reader) error) error {
-	debug.Log("Load(%v, length %v, offset %v)", h, length, offset)
-	err := be.Backend.Load(ctx, h, length, offset, fn)
-	debug.Log("  load err %v", err)
+	start := time.Now()
+	var bytes int64
+	err := be.Backend.Load(ctx, h, length, offset, func(rd io.Reader) error {
+		// count the bytes of the last attempt only
+		cr := &util.CountingReader{Reader: rd}
+		err := fn(cr)
+		bytes = cr.N
+		return err
+	})
+	be.log("Load", h, start, bytes, err)
 	return err
 }
 
 func (be *Backend) Stat(ctx context.Context, h backend.Handle) (backend.FileInfo, error) {
-	debug.Log("Stat(%v)", h)
+	start := time.Now()
 	fi, err := be.Backend.Stat(ctx, h)
-	debug.Log("  stat err %v", err)
+	be.log("Stat", h, start, 0, err)
 	return fi, err
 }
 
 func (be *Backend) List(ctx context.Context, t backend.FileType, fn func(backend.FileInfo) error) error {
-	debug.Log("List(%v)", t)
+	start := time.Now()
 	err := be.Backend.List(ctx, t, fn)
-	debug.Log("  list err %v", err)
+	be.log("List", backend.Handle{Type: t}, start, 0, err)
 	return err
 }
 
 func (be *Backend) Delete(ctx context.Context) error {
-	debug.Log("Delete()")
+	start := time.Now()
 	err := be.Backend.Delete(ctx)
-	debug.Log("  delete err %v", err)
+	be.log("Delete", backend.Handle{}, start, 0, err)
 	return err
 }
 
 func (be *Backend) Close() error {
-	debug.Log("Close()")
+	start := time.Now()
 	err := be.Backend.Close()
-	debug.Log("  close err %v", err)
+	be.log("Close", backend.Handle{}, start, 0, err)
 	return err
 }
 
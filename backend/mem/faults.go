@@ -0,0 +1,115 @@
+package mem
+
+import (
+	"context"
+	"time"
+
+	"github.com/konidev20/rapi/backend"
+)
+
+// Options configures the limits of a MemoryBackend, which allows testing how
+// callers handle slow or full backends.
+type Options struct {
+	// Capacity limits the total size of all files, zero means unlimited. Save
+	// fails with ErrNoSpace if the file does not fit.
+	Capacity int64
+
+	// Latency delays each request.
+	Latency time.Duration
+
+	// Bandwidth limits the bytes per second which are saved or loaded, zero
+	// means unlimited.
+	Bandwidth int64
+}
+
+// Op is the type of a backend request.
+type Op string
+
+// Backend requests which can be delayed or fail.
+const (
+	OpSave   Op = "save"
+	OpLoad   Op = "load"
+	OpStat   Op = "stat"
+	OpRemove Op = "remove"
+	OpList   Op = "list"
+)
+
+// FaultFunc is called before each request. n counts the requests of type op,
+// starting at one. If it returns an error, the request fails with it instead
+// of being executed. For OpList, only the type of h is set.
+type FaultFunc func(op Op, h backend.Handle, n int) error
+
+// CorruptFunc is called with a copy of the data returned for the file h by
+// Load and returns the data which is passed to the caller.
+type CorruptFunc func(h backend.Handle, buf []byte) []byte
+
+// FailNth returns a FaultFunc which fails the nth request of type op with err.
+func FailNth(op Op, n int, err error) FaultFunc {
+	return func(o Op, _ backend.Handle, i int) error {
+		if o == op && i == n {
+			return err
+		}
+		return nil
+	}
+}
+
+// CorruptFile returns a CorruptFunc which flips a bit in the first byte of
+// the data loaded from the file h.
+func CorruptFile(h backend.Handle) CorruptFunc {
+	return func(loaded backend.Handle, buf []byte) []byte {
+		if loaded.Type == h.Type && loaded.Name == h.Name && len(buf) > 0 {
+			buf[0] ^= 0x01
+		}
+		return buf
+	}
+}
+
+// SetFault installs fn, which decides whether requests fail. A nil fn
+// removes it. The request counters are reset.
+func (be *MemoryBackend) SetFault(fn FaultFunc) {
+	be.m.Lock()
+	defer be.m.Unlock()
+
+	be.fault = fn
+	be.requests = make(map[Op]int)
+}
+
+// SetCorruption installs fn, which modifies the data returned by Load. A nil
+// fn removes it.
+func (be *MemoryBackend) SetCorruption(fn CorruptFunc) {
+	be.m.Lock()
+	defer be.m.Unlock()
+
+	be.corrupt = fn
+}
+
+// inject counts the request and returns the error of the fault function. It
+// must be called with be.m held.
+func (be *MemoryBackend) inject(op Op, h backend.Handle) error {
+	be.requests[op]++
+	if be.fault == nil {
+		return nil
+	}
+	return be.fault(op, h, be.requests[op])
+}
+
+// delay waits for the latency and the time needed to transfer size bytes.
+func (be *MemoryBackend) delay(ctx context.Context, size int64) error {
+	d := be.opts.Latency
+	if be.opts.Bandwidth > 0 && size > 0 {
+		d += time.Duration(size * int64(time.Second) / be.opts.Bandwidth)
+	}
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"sync"
 
+	"github.com/cenkalti/backoff/v4"
 	"github.com/cespare/xxhash/v2"
 	"github.com/konidev20/rapi/backend"
 	"github.com/konidev20/rapi/backend/location"
@@ -26,7 +27,7 @@ var _ backend.Backend = &MemoryBackend{}
 func NewFactory() location.Factory {
 	be := New()
 
-	return location.NewHTTPBackendFactory[struct{}, *MemoryBackend](
+	f := location.NewHTTPBackendFactory[struct{}, *MemoryBackend](
 		"mem",
 		func(s string) (*struct{}, error) {
 			return &struct{}{}, nil
@@ -39,26 +40,43 @@ func NewFactory() location.Factory {
 			return be, nil
 		},
 	)
+	return location.WithCapabilities(f, backend.Capabilities{RangedReads: true})
 }
 
 var errNotFound = errors.New("not found")
 
+// ErrNoSpace is returned by Save if the capacity of the backend is exhausted.
+var ErrNoSpace = errors.New("no space left in memory backend")
+
 const connectionCount = 2
 
 // MemoryBackend is a mock backend that uses a map for storing all data in
 // memory. This should only be used for tests.
 type MemoryBackend struct {
 	data memMap
+	used int64
 	m    sync.Mutex
+
+	opts     Options
+	fault    FaultFunc
+	corrupt  CorruptFunc
+	requests map[Op]int
 }
 
 // New returns a new backend that saves all data in a map in memory.
 func New() *MemoryBackend {
+	return NewWithOptions(Options{})
+}
+
+// NewWithOptions returns a new memory backend with the limits in opts.
+func NewWithOptions(opts Options) *MemoryBackend {
 	be := &MemoryBackend{
-		data: make(memMap),
+		data:     make(memMap),
+		opts:     opts,
+		requests: make(map[Op]int),
 	}
 
-	debug.Log("created new memory backend")
+	debug.Log("created new memory backend with options %+v", opts)
 
 	return be
 }
@@ -70,6 +88,10 @@ func (be *MemoryBackend) IsNotExist(err error) bool {
 
 // Save adds new Data to the backend.
 func (be *MemoryBackend) Save(ctx context.Context, h backend.Handle, rd backend.RewindReader) error {
+	if err := be.delay(ctx, rd.Length()); err != nil {
+		return err
+	}
+
 	be.m.Lock()
 	defer be.m.Unlock()
 
@@ -78,6 +100,10 @@ func (be *MemoryBackend) Save(ctx context.Context, h backend.Handle, rd backend.
 		h.Name = ""
 	}
 
+	if err := be.inject(OpSave, h); err != nil {
+		return err
+	}
+
 	if _, ok := be.data[h]; ok {
 		return errors.New("file already exists")
 	}
@@ -105,7 +131,12 @@ func (be *MemoryBackend) Save(ctx context.Context, h backend.Handle, rd backend.
 		)
 	}
 
+	if be.opts.Capacity > 0 && be.used+int64(len(buf)) > be.opts.Capacity {
+		return backoff.Permanent(ErrNoSpace)
+	}
+
 	be.data[h] = buf
+	be.used += int64(len(buf))
 
 	return ctx.Err()
 }
@@ -117,6 +148,19 @@ func (be *MemoryBackend) Load(ctx context.Context, h backend.Handle, length int,
 }
 
 func (be *MemoryBackend) openReader(ctx context.Context, h backend.Handle, length int, offset int64) (io.ReadCloser, error) {
+	buf, err := be.load(h, length, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := be.delay(ctx, int64(len(buf))); err != nil {
+		return nil, err
+	}
+
+	return io.NopCloser(bytes.NewReader(buf)), ctx.Err()
+}
+
+func (be *MemoryBackend) load(h backend.Handle, length int, offset int64) ([]byte, error) {
 	be.m.Lock()
 	defer be.m.Unlock()
 
@@ -125,6 +169,10 @@ func (be *MemoryBackend) openReader(ctx context.Context, h backend.Handle, lengt
 		h.Name = ""
 	}
 
+	if err := be.inject(OpLoad, h); err != nil {
+		return nil, err
+	}
+
 	if _, ok := be.data[h]; !ok {
 		return nil, errNotFound
 	}
@@ -139,11 +187,18 @@ func (be *MemoryBackend) openReader(ctx context.Context, h backend.Handle, lengt
 		buf = buf[:length]
 	}
 
-	return io.NopCloser(bytes.NewReader(buf)), ctx.Err()
+	if be.corrupt != nil {
+		buf = be.corrupt(h, append([]byte(nil), buf...))
+	}
+	return buf, nil
 }
 
 // Stat returns information about a file in the backend.
 func (be *MemoryBackend) Stat(ctx context.Context, h backend.Handle) (backend.FileInfo, error) {
+	if err := be.delay(ctx, 0); err != nil {
+		return backend.FileInfo{}, err
+	}
+
 	be.m.Lock()
 	defer be.m.Unlock()
 
@@ -152,6 +207,10 @@ func (be *MemoryBackend) Stat(ctx context.Context, h backend.Handle) (backend.Fi
 		h.Name = ""
 	}
 
+	if err := be.inject(OpStat, h); err != nil {
+		return backend.FileInfo{}, err
+	}
+
 	e, ok := be.data[h]
 	if !ok {
 		return backend.FileInfo{}, errNotFound
@@ -162,24 +221,42 @@ func (be *MemoryBackend) Stat(ctx context.Context, h backend.Handle) (backend.Fi
 
 // Remove deletes a file from the backend.
 func (be *MemoryBackend) Remove(ctx context.Context, h backend.Handle) error {
+	if err := be.delay(ctx, 0); err != nil {
+		return err
+	}
+
 	be.m.Lock()
 	defer be.m.Unlock()
 
 	h.IsMetadata = false
-	if _, ok := be.data[h]; !ok {
+	if err := be.inject(OpRemove, h); err != nil {
+		return err
+	}
+
+	buf, ok := be.data[h]
+	if !ok {
 		return errNotFound
 	}
 
 	delete(be.data, h)
+	be.used -= int64(len(buf))
 
 	return ctx.Err()
 }
 
 // List returns a channel which yields entries from the backend.
 func (be *MemoryBackend) List(ctx context.Context, t backend.FileType, fn func(backend.FileInfo) error) error {
+	if err := be.delay(ctx, 0); err != nil {
+		return err
+	}
+
 	entries := make(map[string]int64)
 
 	be.m.Lock()
+	if err := be.inject(OpList, backend.Handle{Type: t}); err != nil {
+		be.m.Unlock()
+		return err
+	}
 	for entry, buf := range be.data {
 		if entry.Type != t {
 			continue
@@ -241,6 +318,7 @@ func (be *MemoryBackend) Delete(ctx context.Context) error {
 	}
 
 	be.data = make(memMap)
+	be.used = 0
 	return nil
 }
 
@@ -1,10 +1,15 @@
 package mem_test
 
 import (
+	"context"
+	"errors"
 	"testing"
+	"time"
 
+	"github.com/konidev20/rapi/backend"
 	"github.com/konidev20/rapi/backend/mem"
 	"github.com/konidev20/rapi/backend/test"
+	rtest "github.com/konidev20/rapi/internal/test"
 )
 
 func newTestSuite() *test.Suite[struct{}] {
@@ -19,9 +24,73 @@ func newTestSuite() *test.Suite[struct{}] {
 }
 
 func TestSuiteBackendMem(t *testing.T) {
-	newTestSuite().RunTests(t)
+	suite := newTestSuite()
+	suite.RunTests(t)
+
+	report := suite.Report()
+	rtest.Equals(t, "mem", report.Scheme)
+	rtest.Assert(t, report.Features.ContentHash, "content hash not reported")
+	rtest.Assert(t, len(report.Tests) > 0, "no test results reported")
+	for _, result := range report.Tests {
+		rtest.Assert(t, result.Result != test.ResultFail, "test %v failed", result.Name)
+	}
 }
 
 func BenchmarkSuiteBackendMem(t *testing.B) {
 	newTestSuite().RunBenchmarks(t)
 }
+
+func save(t *testing.T, be *mem.MemoryBackend, h backend.Handle, data []byte) error {
+	t.Helper()
+	return be.Save(context.TODO(), h, backend.NewByteReader(data, be.Hasher()))
+}
+
+func TestCapacity(t *testing.T) {
+	be := mem.NewWithOptions(mem.Options{Capacity: 10})
+
+	h1 := backend.Handle{Type: backend.PackFile, Name: "foo"}
+	rtest.OK(t, save(t, be, h1, []byte("12345678")))
+
+	h2 := backend.Handle{Type: backend.PackFile, Name: "bar"}
+	err := save(t, be, h2, []byte("123"))
+	rtest.Assert(t, errors.Is(err, mem.ErrNoSpace), "wrong error %v", err)
+
+	rtest.OK(t, be.Remove(context.TODO(), h1))
+	rtest.OK(t, save(t, be, h2, []byte("123")))
+}
+
+func TestFailNth(t *testing.T) {
+	be := mem.New()
+	errFault := errors.New("injected fault")
+	be.SetFault(mem.FailNth(mem.OpSave, 2, errFault))
+
+	rtest.OK(t, save(t, be, backend.Handle{Type: backend.PackFile, Name: "1"}, []byte("a")))
+	err := save(t, be, backend.Handle{Type: backend.PackFile, Name: "2"}, []byte("b"))
+	rtest.Assert(t, errors.Is(err, errFault), "wrong error %v", err)
+	rtest.OK(t, save(t, be, backend.Handle{Type: backend.PackFile, Name: "3"}, []byte("c")))
+}
+
+func TestCorruptFile(t *testing.T) {
+	be := mem.New()
+	h := backend.Handle{Type: backend.PackFile, Name: "foo"}
+	rtest.OK(t, save(t, be, h, []byte("foobar")))
+	be.SetCorruption(mem.CorruptFile(h))
+
+	buf, err := backend.LoadAll(context.TODO(), nil, be, h)
+	rtest.OK(t, err)
+	rtest.Equals(t, []byte("goobar"), buf)
+
+	be.SetCorruption(nil)
+	buf, err = backend.LoadAll(context.TODO(), nil, be, h)
+	rtest.OK(t, err)
+	rtest.Equals(t, []byte("foobar"), buf)
+}
+
+func TestLatency(t *testing.T) {
+	be := mem.NewWithOptions(mem.Options{Latency: time.Hour})
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Millisecond)
+	defer cancel()
+	_, err := be.Stat(ctx, backend.Handle{Type: backend.PackFile, Name: "foo"})
+	rtest.Assert(t, errors.Is(err, context.DeadlineExceeded), "wrong error %v", err)
+}
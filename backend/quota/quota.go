@@ -0,0 +1,241 @@
+// Package quota implements a backend wrapper which enforces request and
+// transfer quotas, for example to share a backend fairly between the
+// repositories of many tenants.
+package quota
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/internal/errors"
+	"golang.org/x/time/rate"
+)
+
+// ErrQuotaExceeded is returned for requests which exceed the transfer quota.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// Quota configures the limits enforced by a Tracker. Zero values mean unlimited.
+type Quota struct {
+	// RequestsPerSecond is the sustained rate of requests, Burst the number
+	// of requests which may be sent at once. Requests above the rate are
+	// delayed. If Burst is zero, it is set to the rate rounded up.
+	RequestsPerSecond float64
+	Burst             int
+
+	// BytesPerDay is the number of bytes which may be uploaded and
+	// downloaded per day (UTC). Requests are rejected with ErrQuotaExceeded
+	// once the quota is used up, a request which is already running is
+	// completed.
+	BytesPerDay int64
+}
+
+// Usage contains the counters of a Tracker.
+type Usage struct {
+	Requests        uint64 `json:"requests"`
+	BytesUploaded   uint64 `json:"bytes_uploaded"`
+	BytesDownloaded uint64 `json:"bytes_downloaded"`
+
+	// Rejected is the number of requests rejected because the transfer
+	// quota was exceeded.
+	Rejected uint64 `json:"rejected"`
+
+	// Day is the start of the current day, BytesToday the bytes transferred
+	// since then.
+	Day        time.Time `json:"day"`
+	BytesToday int64     `json:"bytes_today"`
+}
+
+// Tracker enforces a Quota and keeps the counters. It can be shared by
+// several backends, for example to retain the counters when a repository is
+// opened again. It is safe for concurrent use.
+type Tracker struct {
+	requests, uploaded, downloaded, rejected uint64
+
+	mu      sync.Mutex
+	quota   Quota
+	limiter *rate.Limiter
+	day     time.Time
+	today   int64
+
+	now func() time.Time
+}
+
+// NewTracker returns a tracker which enforces q.
+func NewTracker(q Quota) *Tracker {
+	tr := &Tracker{now: time.Now}
+	tr.SetQuota(q)
+	return tr
+}
+
+// SetQuota replaces the quota, the counters are retained.
+func (tr *Tracker) SetQuota(q Quota) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	tr.quota = q
+	tr.limiter = nil
+	if q.RequestsPerSecond > 0 {
+		burst := q.Burst
+		if burst <= 0 {
+			burst = int(q.RequestsPerSecond)
+			if float64(burst) < q.RequestsPerSecond {
+				burst++
+			}
+		}
+		tr.limiter = rate.NewLimiter(rate.Limit(q.RequestsPerSecond), burst)
+	}
+}
+
+// Quota returns the current quota.
+func (tr *Tracker) Quota() Quota {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	return tr.quota
+}
+
+// Usage returns the current values of the counters.
+func (tr *Tracker) Usage() Usage {
+	tr.mu.Lock()
+	tr.rollover()
+	day, today := tr.day, tr.today
+	tr.mu.Unlock()
+
+	return Usage{
+		Requests:        atomic.LoadUint64(&tr.requests),
+		BytesUploaded:   atomic.LoadUint64(&tr.uploaded),
+		BytesDownloaded: atomic.LoadUint64(&tr.downloaded),
+		Rejected:        atomic.LoadUint64(&tr.rejected),
+		Day:             day,
+		BytesToday:      today,
+	}
+}
+
+// rollover resets the daily counter at midnight UTC, tr.mu must be held.
+func (tr *Tracker) rollover() {
+	day := tr.now().UTC().Truncate(24 * time.Hour)
+	if !day.Equal(tr.day) {
+		tr.day = day
+		tr.today = 0
+	}
+}
+
+// admit waits until a request for a file of type t may be sent.
+func (tr *Tracker) admit(ctx context.Context, t backend.FileType) error {
+	if t == backend.LockFile {
+		return nil
+	}
+
+	tr.mu.Lock()
+	tr.rollover()
+	limit := tr.quota.BytesPerDay
+	exceeded := limit > 0 && tr.today >= limit
+	limiter := tr.limiter
+	tr.mu.Unlock()
+
+	if exceeded {
+		atomic.AddUint64(&tr.rejected, 1)
+		return backoff.Permanent(errors.Wrapf(ErrQuotaExceeded, "transfer quota of %d bytes per day", limit))
+	}
+
+	atomic.AddUint64(&tr.requests, 1)
+	if limiter != nil {
+		return limiter.Wait(ctx)
+	}
+	return nil
+}
+
+func (tr *Tracker) transferred(t backend.FileType, n int64, counter *uint64) {
+	atomic.AddUint64(counter, uint64(n))
+	if t == backend.LockFile {
+		return
+	}
+	tr.mu.Lock()
+	tr.rollover()
+	tr.today += n
+	tr.mu.Unlock()
+}
+
+// Backend enforces the quota of a Tracker on the requests to the wrapped
+// backend. Lock files are exempt, so that locks can always be refreshed and
+// removed.
+type Backend struct {
+	backend.Backend
+	*Tracker
+}
+
+// make sure that Backend implements backend.Backend
+var _ backend.Backend = &Backend{}
+
+// New returns a backend which enforces the quota of tr for be.
+func New(be backend.Backend, tr *Tracker) *Backend {
+	return &Backend{Backend: be, Tracker: tr}
+}
+
+// Save stores the data from rd under the given handle.
+func (b *Backend) Save(ctx context.Context, h backend.Handle, rd backend.RewindReader) error {
+	if err := b.admit(ctx, h.Type); err != nil {
+		return err
+	}
+	err := b.Backend.Save(ctx, h, rd)
+	if err == nil {
+		b.transferred(h.Type, rd.Length(), &b.uploaded)
+	}
+	return err
+}
+
+// Load runs fn with a reader that yields the contents of the file at h at the
+// given offset.
+func (b *Backend) Load(ctx context.Context, h backend.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+	if err := b.admit(ctx, h.Type); err != nil {
+		return err
+	}
+	return b.Backend.Load(ctx, h, length, offset, func(rd io.Reader) error {
+		crd := &countingReader{rd: rd}
+		defer func() { b.transferred(h.Type, crd.n, &b.downloaded) }()
+		return fn(crd)
+	})
+}
+
+// Stat returns information about the File identified by h.
+func (b *Backend) Stat(ctx context.Context, h backend.Handle) (backend.FileInfo, error) {
+	if err := b.admit(ctx, h.Type); err != nil {
+		return backend.FileInfo{}, err
+	}
+	return b.Backend.Stat(ctx, h)
+}
+
+// Remove removes a File described by h.
+func (b *Backend) Remove(ctx context.Context, h backend.Handle) error {
+	if err := b.admit(ctx, h.Type); err != nil {
+		return err
+	}
+	return b.Backend.Remove(ctx, h)
+}
+
+// List runs fn for each file in the backend which has the type t.
+func (b *Backend) List(ctx context.Context, t backend.FileType, fn func(backend.FileInfo) error) error {
+	if err := b.admit(ctx, t); err != nil {
+		return err
+	}
+	return b.Backend.List(ctx, t, fn)
+}
+
+func (b *Backend) Unwrap() backend.Backend {
+	return b.Backend
+}
+
+type countingReader struct {
+	rd io.Reader
+	n  int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.rd.Read(p)
+	c.n += int64(n)
+	return n, err
+}
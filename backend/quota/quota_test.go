@@ -0,0 +1,80 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/backend/mem"
+	rtest "github.com/konidev20/rapi/internal/test"
+)
+
+func TestTransferQuota(t *testing.T) {
+	ctx := context.TODO()
+	now := time.Date(2024, 1, 1, 23, 0, 0, 0, time.UTC)
+	be := New(mem.New(), NewTracker(Quota{BytesPerDay: 1500}))
+	be.now = func() time.Time { return now }
+
+	data := make([]byte, 1000)
+	h := backend.Handle{Type: backend.PackFile, Name: "a"}
+	rtest.OK(t, be.Save(ctx, h, backend.NewByteReader(data, be.Hasher())))
+	rtest.OK(t, be.Load(ctx, h, 600, 0, func(rd io.Reader) error {
+		_, err := io.Copy(io.Discard, rd)
+		return err
+	}))
+
+	usage := be.Usage()
+	rtest.Equals(t, uint64(2), usage.Requests)
+	rtest.Equals(t, uint64(1000), usage.BytesUploaded)
+	rtest.Equals(t, uint64(600), usage.BytesDownloaded)
+	rtest.Equals(t, int64(1600), usage.BytesToday)
+
+	_, err := be.Stat(ctx, h)
+	rtest.Assert(t, errors.Is(err, ErrQuotaExceeded), "unexpected error %v", err)
+	rtest.Equals(t, uint64(1), be.Usage().Rejected)
+
+	// lock files are exempt
+	lock := backend.Handle{Type: backend.LockFile, Name: "lock"}
+	rtest.OK(t, be.Save(ctx, lock, backend.NewByteReader(data, be.Hasher())))
+	rtest.OK(t, be.Remove(ctx, lock))
+	rtest.Equals(t, int64(1600), be.Usage().BytesToday)
+
+	// the quota is reset the next day
+	now = now.Add(2 * time.Hour)
+	_, err = be.Stat(ctx, h)
+	rtest.OK(t, err)
+	usage = be.Usage()
+	rtest.Equals(t, int64(0), usage.BytesToday)
+	rtest.Equals(t, time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), usage.Day)
+
+	// raising the quota allows further requests on the same day
+	now = now.Add(time.Hour)
+	rtest.OK(t, be.Save(ctx, backend.Handle{Type: backend.PackFile, Name: "b"}, backend.NewByteReader(make([]byte, 2000), be.Hasher())))
+	_, err = be.Stat(ctx, h)
+	rtest.Assert(t, errors.Is(err, ErrQuotaExceeded), "unexpected error %v", err)
+	be.SetQuota(Quota{BytesPerDay: 10000})
+	_, err = be.Stat(ctx, h)
+	rtest.OK(t, err)
+}
+
+func TestRequestQuota(t *testing.T) {
+	ctx := context.TODO()
+	be := New(mem.New(), NewTracker(Quota{RequestsPerSecond: 20, Burst: 1}))
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		err := be.List(ctx, backend.SnapshotFile, func(backend.FileInfo) error { return nil })
+		rtest.OK(t, err)
+	}
+	// the first request is sent immediately, the others at 50ms intervals
+	rtest.Assert(t, time.Since(start) >= 150*time.Millisecond, "requests were not delayed")
+	rtest.Equals(t, uint64(5), be.Usage().Requests)
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	err := be.List(cctx, backend.SnapshotFile, func(backend.FileInfo) error { return nil })
+	rtest.Assert(t, err != nil, "cancelled request was sent")
+}
@@ -39,7 +39,10 @@ type Backend struct {
 }
 
 func NewFactory() location.Factory {
-	return location.NewLimitedBackendFactory("rclone", ParseConfig, location.NoPassword, Create, Open)
+	return location.WithCapabilities(
+		location.NewLimitedBackendFactory("rclone", ParseConfig, location.NoPassword, Create, Open),
+		backend.Capabilities{RangedReads: true},
+	)
 }
 
 // run starts command with args and initializes the StdioConn.
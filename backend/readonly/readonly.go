@@ -0,0 +1,90 @@
+// Package readonly implements a backend wrapper which rejects all
+// modifications of the repository.
+package readonly
+
+import (
+	"context"
+	"hash"
+	"io"
+
+	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/errors"
+)
+
+// ErrReadOnly is returned for all operations which would modify the
+// repository.
+var ErrReadOnly = errors.New("repository is opened in read-only mode")
+
+// Backend passes reads through to the underlying backend and fails all
+// writes with ErrReadOnly.
+//
+// Backend deliberately does not implement backend.Unwrapper, so the
+// underlying backend cannot be reached by backend.As and modified through it.
+type Backend struct {
+	b backend.Backend
+}
+
+// statically ensure that Backend implements backend.Backend.
+var _ backend.Backend = &Backend{}
+
+// New returns a read-only wrapper for be.
+func New(be backend.Backend) *Backend {
+	debug.Log("created new read-only backend")
+	return &Backend{b: be}
+}
+
+// Save fails with ErrReadOnly.
+func (be *Backend) Save(_ context.Context, h backend.Handle, _ backend.RewindReader) error {
+	debug.Log("rejected Save(%v)", h)
+	return ErrReadOnly
+}
+
+// Remove fails with ErrReadOnly.
+func (be *Backend) Remove(_ context.Context, h backend.Handle) error {
+	debug.Log("rejected Remove(%v)", h)
+	return ErrReadOnly
+}
+
+// Delete fails with ErrReadOnly.
+func (be *Backend) Delete(_ context.Context) error {
+	debug.Log("rejected Delete()")
+	return ErrReadOnly
+}
+
+func (be *Backend) Connections() uint {
+	return be.b.Connections()
+}
+
+// Location returns the location of the backend.
+func (be *Backend) Location() string {
+	return be.b.Location()
+}
+
+func (be *Backend) Close() error {
+	return be.b.Close()
+}
+
+func (be *Backend) Hasher() hash.Hash {
+	return be.b.Hasher()
+}
+
+func (be *Backend) HasAtomicReplace() bool {
+	return be.b.HasAtomicReplace()
+}
+
+func (be *Backend) IsNotExist(err error) bool {
+	return be.b.IsNotExist(err)
+}
+
+func (be *Backend) List(ctx context.Context, t backend.FileType, fn func(backend.FileInfo) error) error {
+	return be.b.List(ctx, t, fn)
+}
+
+func (be *Backend) Load(ctx context.Context, h backend.Handle, length int, offset int64, fn func(io.Reader) error) error {
+	return be.b.Load(ctx, h, length, offset, fn)
+}
+
+func (be *Backend) Stat(ctx context.Context, h backend.Handle) (backend.FileInfo, error) {
+	return be.b.Stat(ctx, h)
+}
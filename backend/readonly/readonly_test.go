@@ -0,0 +1,46 @@
+package readonly_test
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/backend/mem"
+	"github.com/konidev20/rapi/backend/readonly"
+	"github.com/konidev20/rapi/internal/errors"
+	rtest "github.com/konidev20/rapi/internal/test"
+)
+
+func TestReadOnly(t *testing.T) {
+	ctx := context.TODO()
+	m := mem.New()
+	h := backend.Handle{Type: backend.SnapshotFile, Name: "foo"}
+	data := []byte("foobar")
+	rtest.OK(t, m.Save(ctx, h, backend.NewByteReader(data, m.Hasher())))
+
+	be := readonly.New(m)
+
+	var buf []byte
+	rtest.OK(t, be.Load(ctx, h, 0, 0, func(rd io.Reader) (err error) {
+		buf, err = io.ReadAll(rd)
+		return err
+	}))
+	rtest.Equals(t, data, buf)
+
+	other := backend.Handle{Type: backend.LockFile, Name: "bar"}
+	err := be.Save(ctx, other, backend.NewByteReader(data, m.Hasher()))
+	rtest.Assert(t, errors.Is(err, readonly.ErrReadOnly), "Save returned wrong error %v", err)
+	_, err = m.Stat(ctx, other)
+	rtest.Assert(t, m.IsNotExist(err), "file was saved despite read-only mode")
+
+	err = be.Remove(ctx, h)
+	rtest.Assert(t, errors.Is(err, readonly.ErrReadOnly), "Remove returned wrong error %v", err)
+	err = be.Delete(ctx)
+	rtest.Assert(t, errors.Is(err, readonly.ErrReadOnly), "Delete returned wrong error %v", err)
+
+	_, err = m.Stat(ctx, h)
+	rtest.OK(t, err)
+
+	rtest.Assert(t, backend.AsBackend[*mem.MemoryBackend](be) == nil, "underlying backend is reachable")
+}
@@ -31,7 +31,10 @@ type Backend struct {
 }
 
 func NewFactory() location.Factory {
-	return location.NewHTTPBackendFactory("rest", ParseConfig, StripPassword, Create, Open)
+	return location.WithCapabilities(
+		location.NewHTTPBackendFactory("rest", ParseConfig, StripPassword, Create, Open),
+		backend.Capabilities{RangedReads: true},
+	)
 }
 
 // the REST API protocol version is decided by HTTP request headers, these are the constants.
@@ -4,11 +4,13 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"strings"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
 	"github.com/konidev20/rapi/backend"
 	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/metrics"
 )
 
 // Backend retries operations on the backend in case of an error with a
@@ -77,6 +79,7 @@ func (be *Backend) retry(ctx context.Context, msg string, f func() error) error
 	err := retryNotifyErrorWithSuccess(f,
 		backoff.WithContext(backoff.WithMaxRetries(bo, uint64(be.MaxTries)), ctx),
 		func(err error, d time.Duration) {
+			metrics.Default.Retry(operation(msg))
 			if be.Report != nil {
 				be.Report(msg, err, d)
 			}
@@ -91,6 +94,15 @@ func (be *Backend) retry(ctx context.Context, msg string, f func() error) error
 	return err
 }
 
+// operation extracts the name of the operation from a message passed to retry,
+// e.g. "save" for "Save(<data/1234567890>)".
+func operation(msg string) string {
+	if i := strings.IndexByte(msg, '('); i >= 0 {
+		msg = msg[:i]
+	}
+	return strings.ToLower(msg)
+}
+
 // Save stores the data in the backend under the given handle.
 func (be *Backend) Save(ctx context.Context, h backend.Handle, rd backend.RewindReader) error {
 	return be.retry(ctx, fmt.Sprintf("Save(%v)", h), func() error {
@@ -5,6 +5,7 @@ import (
 	"os"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/konidev20/rapi/backend"
 	"github.com/konidev20/rapi/internal/errors"
@@ -28,6 +29,15 @@ type Config struct {
 	Region        string `option:"region" help:"set region"`
 	BucketLookup  string `option:"bucket-lookup" help:"bucket lookup style: 'auto', 'dns', or 'path'"`
 	ListObjectsV1 bool   `option:"list-objects-v1" help:"use deprecated V1 api for ListObjects calls"`
+
+	Profile              string        `option:"profile" help:"use this profile of the AWS credentials and config files, including SSO profiles"`
+	RoleARN              string        `option:"role-arn" help:"assume this IAM role using the other credentials"`
+	RoleSessionName      string        `option:"role-session-name" help:"session name used when assuming the role"`
+	ExternalID           string        `option:"external-id" help:"external ID passed when assuming the role"`
+	SessionTags          string        `option:"session-tags" help:"session tags passed when assuming the role, as key1=value1,key2=value2"`
+	RoleDuration         time.Duration `option:"role-duration" help:"lifetime of the credentials of the assumed role (default: 1h)"`
+	WebIdentityTokenFile string        `option:"web-identity-token-file" help:"assume the role using the web identity token in this file"`
+	STSEndpoint          string        `option:"sts-endpoint" help:"use this STS endpoint to assume roles (default: derived from region)"`
 }
 
 // NewConfig returns a new Config with the default values filled in.
@@ -107,4 +117,16 @@ func (cfg *Config) ApplyEnvironment(prefix string) {
 	if cfg.Region == "" {
 		cfg.Region = os.Getenv(prefix + "AWS_DEFAULT_REGION")
 	}
+	if cfg.Profile == "" {
+		cfg.Profile = os.Getenv(prefix + "AWS_PROFILE")
+	}
+	// the role variables are set for web identity federation, e.g. in EKS
+	// pods, so they must not replace static keys or a configured role
+	if cfg.KeyID == "" && cfg.RoleARN == "" && cfg.WebIdentityTokenFile == "" {
+		cfg.RoleARN = os.Getenv(prefix + "AWS_ROLE_ARN")
+		cfg.WebIdentityTokenFile = os.Getenv(prefix + "AWS_WEB_IDENTITY_TOKEN_FILE")
+		if cfg.RoleSessionName == "" {
+			cfg.RoleSessionName = os.Getenv(prefix + "AWS_ROLE_SESSION_NAME")
+		}
+	}
 }
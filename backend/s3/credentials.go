@@ -0,0 +1,387 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/signer"
+	"gopkg.in/ini.v1"
+)
+
+// newCredentials returns the credentials configured by cfg. Without a role,
+// all credential types are chained in the following order:
+//   - Static credentials provided by user
+//   - AWS env vars (i.e. AWS_ACCESS_KEY_ID)
+//   - Minio env vars (i.e. MINIO_ACCESS_KEY)
+//   - AWS creds file (i.e. AWS_SHARED_CREDENTIALS_FILE or ~/.aws/credentials)
+//   - SSO profile in the AWS config file, using the token of "aws sso login"
+//   - Minio creds file (i.e. MINIO_SHARED_CREDENTIALS_FILE or ~/.mc/config.json)
+//   - IAM profile based credentials. (performs an HTTP call to a pre-defined
+//     endpoint, only valid inside configured ec2 instances, using IMDSv2 if
+//     available, or inside ECS tasks and EKS pods)
+//
+// With a web identity token file and without static credentials the role is
+// assumed using that token only. Otherwise the chain above is used to assume
+// the role if RoleARN is set.
+func newCredentials(cfg Config, rt http.RoundTripper) (*credentials.Credentials, error) {
+	client := &http.Client{Transport: rt}
+
+	if cfg.WebIdentityTokenFile != "" && cfg.KeyID == "" {
+		if cfg.RoleARN == "" {
+			return nil, errors.Fatal("unable to open S3 backend: web identity token file requires a role ARN")
+		}
+		return credentials.New(&credentials.STSWebIdentity{
+			Client:      client,
+			STSEndpoint: stsEndpoint(cfg),
+			RoleARN:     cfg.RoleARN,
+			GetWebIDTokenExpiry: func() (*credentials.WebIdentityToken, error) {
+				token, err := os.ReadFile(cfg.WebIdentityTokenFile)
+				if err != nil {
+					return nil, err
+				}
+				return &credentials.WebIdentityToken{Token: strings.TrimSpace(string(token))}, nil
+			},
+		}), nil
+	}
+
+	creds := credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.Static{
+			Value: credentials.Value{
+				AccessKeyID:     cfg.KeyID,
+				SecretAccessKey: cfg.Secret.Unwrap(),
+			},
+		},
+		&credentials.EnvAWS{},
+		&credentials.EnvMinio{},
+		&credentials.FileAWSCredentials{Profile: cfg.Profile},
+		&ssoProfile{client: client, profile: cfg.Profile},
+		&credentials.FileMinioClient{},
+		&credentials.IAM{
+			Client: &http.Client{
+				Transport: http.DefaultTransport,
+			},
+		},
+	})
+
+	if cfg.RoleARN == "" {
+		return creds, nil
+	}
+
+	tags, err := parseSessionTags(cfg.SessionTags)
+	if err != nil {
+		return nil, errors.Fatalf("unable to open S3 backend: %v", err)
+	}
+
+	return credentials.New(&assumeRole{
+		client:      client,
+		endpoint:    stsEndpoint(cfg),
+		region:      cfg.Region,
+		source:      creds,
+		roleARN:     cfg.RoleARN,
+		externalID:  cfg.ExternalID,
+		sessionName: cfg.RoleSessionName,
+		tags:        tags,
+		duration:    cfg.RoleDuration,
+	}), nil
+}
+
+// parseSessionTags parses tags in the format "key1=value1,key2=value2".
+func parseSessionTags(s string) (map[string]string, error) {
+	tags := make(map[string]string)
+	if s == "" {
+		return tags, nil
+	}
+	for _, kv := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" {
+			return nil, errors.Errorf("invalid session tag %q, must be key=value", kv)
+		}
+		tags[k] = strings.TrimSpace(v)
+	}
+	return tags, nil
+}
+
+// stsEndpoint returns the STS endpoint to use, either the configured one or
+// the regional endpoint of AWS.
+func stsEndpoint(cfg Config) string {
+	if cfg.STSEndpoint != "" {
+		return cfg.STSEndpoint
+	}
+	switch {
+	case strings.HasPrefix(cfg.Region, "cn-"):
+		return "https://sts." + cfg.Region + ".amazonaws.com.cn"
+	case cfg.Region != "":
+		return "https://sts." + cfg.Region + ".amazonaws.com"
+	default:
+		return "https://sts.amazonaws.com"
+	}
+}
+
+// assumeRole obtains temporary credentials for a role from the STS AssumeRole
+// call, using the credentials of source to sign the request. The source
+// credentials are retrieved again for each refresh, so that rotating
+// credentials such as those of an IAM or SSO profile can be used; their session
+// token is sent along with the request. credentials.STSAssumeRole cannot be
+// used, it supports neither an external ID nor session tags.
+type assumeRole struct {
+	credentials.Expiry
+
+	client   *http.Client
+	endpoint string
+	region   string
+	source   *credentials.Credentials
+
+	roleARN     string
+	externalID  string
+	sessionName string
+	tags        map[string]string
+	duration    time.Duration
+}
+
+func (p *assumeRole) Retrieve() (credentials.Value, error) {
+	src, err := p.source.Get()
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	if src.SignerType == credentials.SignatureAnonymous {
+		return credentials.Value{}, errors.New("no credentials found to assume role")
+	}
+
+	v := url.Values{}
+	v.Set("Action", "AssumeRole")
+	v.Set("Version", credentials.STSVersion)
+	v.Set("RoleArn", p.roleARN)
+	sessionName := p.sessionName
+	if sessionName == "" {
+		sessionName = "restic-" + strconv.FormatInt(time.Now().Unix(), 10)
+	}
+	v.Set("RoleSessionName", sessionName)
+	if p.duration > 0 {
+		v.Set("DurationSeconds", strconv.Itoa(int(p.duration/time.Second)))
+	}
+	if p.externalID != "" {
+		v.Set("ExternalId", p.externalID)
+	}
+
+	keys := make([]string, 0, len(p.tags))
+	for k := range p.tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for i, k := range keys {
+		v.Set(fmt.Sprintf("Tags.member.%d.Key", i+1), k)
+		v.Set(fmt.Sprintf("Tags.member.%d.Value", i+1), p.tags[k])
+	}
+
+	u, err := url.Parse(p.endpoint)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+
+	body := v.Encode()
+	req, err := http.NewRequest(http.MethodPost, u.String(), strings.NewReader(body))
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	sum := sha256.Sum256([]byte(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sum[:]))
+	// the token is part of the signed headers, SignV4STS does not add it
+	if src.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", src.SessionToken)
+	}
+	region := p.region
+	if region == "" {
+		region = "us-east-1"
+	}
+	req = signer.SignV4STS(*req, src.AccessKeyID, src.SecretAccessKey, region)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	defer func() {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}()
+
+	buf, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var errResp credentials.ErrorResponse
+		if xml.Unmarshal(buf, &errResp) == nil && errResp.STSError.Code != "" {
+			return credentials.Value{}, errors.Errorf("AssumeRole %v: %v", p.roleARN, errResp.Error())
+		}
+		return credentials.Value{}, errors.Errorf("AssumeRole %v: unexpected status %v", p.roleARN, resp.Status)
+	}
+
+	var a credentials.AssumeRoleResponse
+	if err := xml.Unmarshal(buf, &a); err != nil {
+		return credentials.Value{}, errors.Wrap(err, "decoding AssumeRole response")
+	}
+
+	debug.Log("assumed role %v, credentials expire at %v", p.roleARN, a.Result.Credentials.Expiration)
+	p.SetExpiration(a.Result.Credentials.Expiration, credentials.DefaultExpiryWindow)
+	return credentials.Value{
+		AccessKeyID:     a.Result.Credentials.AccessKey,
+		SecretAccessKey: a.Result.Credentials.SecretKey,
+		SessionToken:    a.Result.Credentials.SessionToken,
+		SignerType:      credentials.SignatureV4,
+	}, nil
+}
+
+// ssoProfile obtains credentials for a profile in the AWS config file which
+// uses IAM Identity Center (SSO). It uses the access token cached by
+// "aws sso login", the login itself must be done with the AWS CLI.
+type ssoProfile struct {
+	credentials.Expiry
+
+	client   *http.Client
+	filename string
+	profile  string
+}
+
+func (p *ssoProfile) Retrieve() (credentials.Value, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	filename := p.filename
+	if filename == "" {
+		filename = os.Getenv("AWS_CONFIG_FILE")
+	}
+	if filename == "" {
+		filename = filepath.Join(home, ".aws", "config")
+	}
+
+	profile := p.profile
+	if profile == "" {
+		profile = os.Getenv("AWS_PROFILE")
+	}
+	section := "default"
+	if profile != "" && profile != "default" {
+		section = "profile " + profile
+	}
+
+	f, err := ini.Load(filename)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	sec, err := f.GetSection(section)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+
+	accountID := sec.Key("sso_account_id").String()
+	roleName := sec.Key("sso_role_name").String()
+	startURL := sec.Key("sso_start_url").String()
+	region := sec.Key("sso_region").String()
+	cacheKey := startURL
+	if name := sec.Key("sso_session").String(); name != "" {
+		s, err := f.GetSection("sso-session " + name)
+		if err != nil {
+			return credentials.Value{}, err
+		}
+		startURL = s.Key("sso_start_url").String()
+		region = s.Key("sso_region").String()
+		cacheKey = name
+	}
+	if accountID == "" || roleName == "" || startURL == "" || region == "" {
+		return credentials.Value{}, errors.Errorf("profile %q is not configured for SSO", section)
+	}
+
+	token, err := loadSSOToken(filepath.Join(home, ".aws", "sso", "cache"), cacheKey)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+
+	u := url.URL{
+		Scheme:   "https",
+		Host:     "portal.sso." + region + ".amazonaws.com",
+		Path:     "/federation/credentials",
+		RawQuery: url.Values{"account_id": {accountID}, "role_name": {roleName}}.Encode(),
+	}
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	req.Header.Set("x-amz-sso_bearer_token", token)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	defer func() {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return credentials.Value{}, errors.Errorf("SSO GetRoleCredentials: unexpected status %v", resp.Status)
+	}
+
+	var result struct {
+		RoleCredentials struct {
+			AccessKeyID     string `json:"accessKeyId"`
+			SecretAccessKey string `json:"secretAccessKey"`
+			SessionToken    string `json:"sessionToken"`
+			Expiration      int64  `json:"expiration"`
+		} `json:"roleCredentials"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return credentials.Value{}, errors.Wrap(err, "decoding SSO credentials")
+	}
+
+	rc := result.RoleCredentials
+	p.SetExpiration(time.UnixMilli(rc.Expiration), credentials.DefaultExpiryWindow)
+	return credentials.Value{
+		AccessKeyID:     rc.AccessKeyID,
+		SecretAccessKey: rc.SecretAccessKey,
+		SessionToken:    rc.SessionToken,
+		SignerType:      credentials.SignatureV4,
+	}, nil
+}
+
+// loadSSOToken returns the access token cached by the AWS CLI for key, which
+// is either the name of the sso-session or the start URL.
+func loadSSOToken(dir, key string) (string, error) {
+	sum := sha1.Sum([]byte(key))
+	buf, err := os.ReadFile(filepath.Join(dir, hex.EncodeToString(sum[:])+".json"))
+	if err != nil {
+		return "", errors.Wrap(err, "no cached SSO token, run \"aws sso login\"")
+	}
+
+	var cached struct {
+		AccessToken string    `json:"accessToken"`
+		ExpiresAt   time.Time `json:"expiresAt"`
+	}
+	if err := json.NewDecoder(bytes.NewReader(buf)).Decode(&cached); err != nil {
+		return "", errors.Wrap(err, "decoding cached SSO token")
+	}
+	if cached.AccessToken == "" || time.Now().After(cached.ExpiresAt) {
+		return "", errors.New("cached SSO token has expired, run \"aws sso login\"")
+	}
+	return cached.AccessToken, nil
+}
@@ -0,0 +1,119 @@
+package s3
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/konidev20/rapi/internal/options"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+const assumeRoleResponse = `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+<AssumeRoleResult>
+<Credentials>
+<AccessKeyId>ASIAROLE</AccessKeyId>
+<SecretAccessKey>rolesecret</SecretAccessKey>
+<SessionToken>roletoken</SessionToken>
+<Expiration>2099-01-01T00:00:00Z</Expiration>
+</Credentials>
+</AssumeRoleResult>
+</AssumeRoleResponse>`
+
+func TestAssumeRole(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rtest.OK(t, r.ParseForm())
+		rtest.Equals(t, "AssumeRole", r.PostForm.Get("Action"))
+		rtest.Equals(t, "arn:aws:iam::123456789012:role/backup", r.PostForm.Get("RoleArn"))
+		rtest.Equals(t, "backup", r.PostForm.Get("RoleSessionName"))
+		rtest.Equals(t, "7200", r.PostForm.Get("DurationSeconds"))
+		rtest.Equals(t, "ext-42", r.PostForm.Get("ExternalId"))
+		rtest.Equals(t, "host", r.PostForm.Get("Tags.member.1.Key"))
+		rtest.Equals(t, "backup01", r.PostForm.Get("Tags.member.1.Value"))
+		rtest.Equals(t, "project", r.PostForm.Get("Tags.member.2.Key"))
+		rtest.Equals(t, "restic", r.PostForm.Get("Tags.member.2.Value"))
+		rtest.Equals(t, "", r.Header.Get("X-Amz-Security-Token"))
+		rtest.Assert(t, strings.Contains(r.Header.Get("Authorization"), "AKIASOURCE"), "request is not signed with the source credentials")
+		_, _ = w.Write([]byte(assumeRoleResponse))
+	}))
+	defer srv.Close()
+
+	creds, err := newCredentials(Config{
+		KeyID:           "AKIASOURCE",
+		Secret:          options.NewSecretString("sourcesecret"),
+		RoleARN:         "arn:aws:iam::123456789012:role/backup",
+		RoleSessionName: "backup",
+		RoleDuration:    2 * time.Hour,
+		ExternalID:      "ext-42",
+		SessionTags:     "project=restic, host=backup01",
+		STSEndpoint:     srv.URL,
+	}, srv.Client().Transport)
+	rtest.OK(t, err)
+
+	v, err := creds.Get()
+	rtest.OK(t, err)
+	rtest.Equals(t, "ASIAROLE", v.AccessKeyID)
+	rtest.Equals(t, "rolesecret", v.SecretAccessKey)
+	rtest.Equals(t, "roletoken", v.SessionToken)
+	rtest.Assert(t, !creds.IsExpired(), "credentials should not be expired")
+}
+
+func TestAssumeRoleTemporarySource(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rtest.Equals(t, "sourcetoken", r.Header.Get("X-Amz-Security-Token"))
+		auth := r.Header.Get("Authorization")
+		rtest.Assert(t, strings.Contains(auth, "ASIASOURCE"), "request is not signed with the source credentials")
+		rtest.Assert(t, strings.Contains(auth, "x-amz-security-token"), "session token is not signed: %v", auth)
+		_, _ = w.Write([]byte(assumeRoleResponse))
+	}))
+	defer srv.Close()
+
+	creds := credentials.New(&assumeRole{
+		client:   srv.Client(),
+		endpoint: srv.URL,
+		source:   credentials.NewStaticV4("ASIASOURCE", "sourcesecret", "sourcetoken"),
+		roleARN:  "arn:aws:iam::123456789012:role/backup",
+	})
+	v, err := creds.Get()
+	rtest.OK(t, err)
+	rtest.Equals(t, "ASIAROLE", v.AccessKeyID)
+	rtest.Equals(t, "roletoken", v.SessionToken)
+}
+
+func TestParseSessionTags(t *testing.T) {
+	tags, err := parseSessionTags("a=1, b = 2,c=")
+	rtest.OK(t, err)
+	rtest.Equals(t, map[string]string{"a": "1", "b": "2", "c": ""}, tags)
+
+	_, err = parseSessionTags("a=1,b")
+	rtest.Assert(t, err != nil, "tag without value separator accepted")
+}
+
+func TestStaticKeysPrecedence(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	t.Setenv("AWS_ROLE_ARN", "arn:aws:iam::123456789012:role/pod")
+	t.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", "/var/run/secrets/token")
+
+	cfg := NewConfig()
+	cfg.KeyID = "AKIASTATIC"
+	cfg.Secret = options.NewSecretString("staticsecret")
+	cfg.ApplyEnvironment("")
+	rtest.Equals(t, "", cfg.RoleARN)
+	rtest.Equals(t, "", cfg.WebIdentityTokenFile)
+
+	cfg.WebIdentityTokenFile = "/var/run/secrets/token"
+	creds, err := newCredentials(cfg, http.DefaultTransport)
+	rtest.OK(t, err)
+	v, err := creds.Get()
+	rtest.OK(t, err)
+	rtest.Equals(t, "AKIASTATIC", v.AccessKeyID)
+
+	cfg = NewConfig()
+	cfg.ApplyEnvironment("")
+	rtest.Equals(t, "arn:aws:iam::123456789012:role/pod", cfg.RoleARN)
+	rtest.Equals(t, "/var/run/secrets/token", cfg.WebIdentityTokenFile)
+}
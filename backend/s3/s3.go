@@ -33,7 +33,10 @@ type Backend struct {
 var _ backend.Backend = &Backend{}
 
 func NewFactory() location.Factory {
-	return location.NewHTTPBackendFactory("s3", ParseConfig, location.NoPassword, Create, Open)
+	return location.WithCapabilities(
+		location.NewHTTPBackendFactory("s3", ParseConfig, location.NoPassword, Create, Open),
+		backend.Capabilities{RangedReads: true, AtomicWrites: true, ServerSideChecksum: true, ScalesWithConnections: true, ColdStorage: true, MaxObjectSize: 5 * 1024 * 1024 * 1024 * 1024},
+	)
 }
 
 const defaultLayout = "default"
@@ -51,32 +54,10 @@ func open(ctx context.Context, cfg Config, rt http.RoundTripper) (*Backend, erro
 		minio.MaxRetry = int(cfg.MaxRetries)
 	}
 
-	// Chains all credential types, in the following order:
-	// 	- Static credentials provided by user
-	//	- AWS env vars (i.e. AWS_ACCESS_KEY_ID)
-	//  - Minio env vars (i.e. MINIO_ACCESS_KEY)
-	//  - AWS creds file (i.e. AWS_SHARED_CREDENTIALS_FILE or ~/.aws/credentials)
-	//  - Minio creds file (i.e. MINIO_SHARED_CREDENTIALS_FILE or ~/.mc/config.json)
-	//  - IAM profile based credentials. (performs an HTTP
-	//    call to a pre-defined endpoint, only valid inside
-	//    configured ec2 instances)
-	creds := credentials.NewChainCredentials([]credentials.Provider{
-		&credentials.EnvAWS{},
-		&credentials.Static{
-			Value: credentials.Value{
-				AccessKeyID:     cfg.KeyID,
-				SecretAccessKey: cfg.Secret.Unwrap(),
-			},
-		},
-		&credentials.EnvMinio{},
-		&credentials.FileAWSCredentials{},
-		&credentials.FileMinioClient{},
-		&credentials.IAM{
-			Client: &http.Client{
-				Transport: http.DefaultTransport,
-			},
-		},
-	})
+	creds, err := newCredentials(cfg, rt)
+	if err != nil {
+		return nil, err
+	}
 
 	c, err := creds.Get()
 	if err != nil {
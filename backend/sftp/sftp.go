@@ -44,7 +44,10 @@ type SFTP struct {
 var _ backend.Backend = &SFTP{}
 
 func NewFactory() location.Factory {
-	return location.NewLimitedBackendFactory("sftp", ParseConfig, location.NoPassword, limiter.WrapBackendConstructor(Create), limiter.WrapBackendConstructor(Open))
+	return location.WithCapabilities(
+		location.NewLimitedBackendFactory("sftp", ParseConfig, location.NoPassword, limiter.WrapBackendConstructor(Create), limiter.WrapBackendConstructor(Open)),
+		backend.Capabilities{RangedReads: true},
+	)
 }
 
 const defaultLayout = "default"
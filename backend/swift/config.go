@@ -31,12 +31,20 @@ type Config struct {
 	ApplicationCredentialID     string
 	ApplicationCredentialName   string
 	ApplicationCredentialSecret options.SecretString
+	// AuthType is the value of $OS_AUTH_TYPE, v3applicationcredential
+	// selects application credentials even if the AuthURL has no version.
+	AuthType string
 
 	Container              string
 	Prefix                 string
 	DefaultContainerPolicy string
 
 	Connections uint `option:"connections" help:"set a limit for the number of concurrent connections (default: 5)"`
+
+	LargeObjectThreshold uint   `option:"large-object-threshold" help:"upload files larger than this many MiB as segmented large objects (default: 5120)"`
+	LargeObjectType      string `option:"large-object-type" help:"type of large objects: 'static' or 'dynamic' (default: static)"`
+	SegmentSize          uint   `option:"segment-size" help:"size of the segments of large objects in MiB (default: 1024)"`
+	SegmentContainer     string `option:"segment-container" help:"container to store the segments of large objects in (default: <container>_segments)"`
 }
 
 func init() {
@@ -109,6 +117,7 @@ func (cfg *Config) ApplyEnvironment(prefix string) {
 		// Application Credential auth
 		{&cfg.ApplicationCredentialID, prefix + "OS_APPLICATION_CREDENTIAL_ID"},
 		{&cfg.ApplicationCredentialName, prefix + "OS_APPLICATION_CREDENTIAL_NAME"},
+		{&cfg.AuthType, prefix + "OS_AUTH_TYPE"},
 
 		// Manual authentication
 		{&cfg.StorageURL, prefix + "OS_STORAGE_URL"},
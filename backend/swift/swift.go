@@ -8,9 +8,11 @@ import (
 	"hash"
 	"io"
 	"net/http"
+	"os"
 	"path"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/konidev20/rapi/backend"
@@ -30,13 +32,33 @@ type beSwift struct {
 	container   string // Container name
 	prefix      string // Prefix of object names in the container
 	layout.Layout
+
+	// files larger than largeObjectThreshold are stored as large objects
+	// with segments of segmentSize bytes in segmentContainer
+	largeObjectThreshold int64
+	largeObjectType      string
+	segmentSize          int64
+	segmentContainer     string
+
+	m                       sync.Mutex
+	segmentContainerChecked bool
+	noSegmentContainer      bool
 }
 
+const (
+	defaultLargeObjectThreshold = 5 * 1024 // MiB
+	defaultSegmentSize          = 1024     // MiB
+)
+
 // ensure statically that *beSwift implements backend.Backend.
 var _ backend.Backend = &beSwift{}
 
 func NewFactory() location.Factory {
-	return location.NewHTTPBackendFactory("swift", ParseConfig, location.NoPassword, Open, Open)
+	return location.WithCapabilities(
+		location.NewHTTPBackendFactory("swift", ParseConfig, location.NoPassword, Open, Open),
+		// large objects lift the limit of 5 GiB for single objects
+		backend.Capabilities{RangedReads: true, AtomicWrites: true, ServerSideChecksum: true, ScalesWithConnections: true},
+	)
 }
 
 // Open opens the swift backend at a container in region. The container is
@@ -44,6 +66,30 @@ func NewFactory() location.Factory {
 func Open(ctx context.Context, cfg Config, rt http.RoundTripper) (backend.Backend, error) {
 	debug.Log("config %#v", cfg)
 
+	authVersion := 0
+	if cfg.AuthType == "v3applicationcredential" || cfg.ApplicationCredentialID != "" || cfg.ApplicationCredentialName != "" {
+		// application credentials are only supported by keystone v3
+		authVersion = 3
+	}
+
+	threshold := cfg.LargeObjectThreshold
+	if threshold == 0 {
+		threshold = defaultLargeObjectThreshold
+	}
+	segmentSize := cfg.SegmentSize
+	if segmentSize == 0 {
+		segmentSize = defaultSegmentSize
+	}
+	switch cfg.LargeObjectType {
+	case "", "static", "dynamic":
+	default:
+		return nil, errors.Fatalf("invalid large object type %q, must be 'static' or 'dynamic'", cfg.LargeObjectType)
+	}
+	segmentContainer := cfg.SegmentContainer
+	if segmentContainer == "" {
+		segmentContainer = cfg.Container + "_segments"
+	}
+
 	be := &beSwift{
 		conn: &swift.Connection{
 			UserName:                    cfg.UserName,
@@ -63,6 +109,7 @@ func Open(ctx context.Context, cfg Config, rt http.RoundTripper) (backend.Backen
 			ApplicationCredentialId:     cfg.ApplicationCredentialID,
 			ApplicationCredentialName:   cfg.ApplicationCredentialName,
 			ApplicationCredentialSecret: cfg.ApplicationCredentialSecret.Unwrap(),
+			AuthVersion:                 authVersion,
 			ConnectTimeout:              time.Minute,
 			Timeout:                     time.Minute,
 
@@ -75,6 +122,10 @@ func Open(ctx context.Context, cfg Config, rt http.RoundTripper) (backend.Backen
 			Path: cfg.Prefix,
 			Join: path.Join,
 		},
+		largeObjectThreshold: int64(threshold) * 1024 * 1024,
+		largeObjectType:      cfg.LargeObjectType,
+		segmentSize:          int64(segmentSize) * 1024 * 1024,
+		segmentContainer:     segmentContainer,
 	}
 
 	// Authenticate if needed
@@ -164,6 +215,10 @@ func (be *beSwift) Save(ctx context.Context, h backend.Handle, rd backend.Rewind
 	objName := be.Filename(h)
 	encoding := "binary/octet-stream"
 
+	if rd.Length() > be.largeObjectThreshold {
+		return be.saveLarge(ctx, objName, encoding, rd)
+	}
+
 	hdr := swift.Headers{"Content-Length": strconv.FormatInt(rd.Length(), 10)}
 	_, err := be.conn.ObjectPut(ctx,
 		be.container, objName, rd, true, hex.EncodeToString(rd.Hash()),
@@ -173,6 +228,132 @@ func (be *beSwift) Save(ctx context.Context, h backend.Handle, rd backend.Rewind
 	return errors.Wrap(err, "client.PutObject")
 }
 
+// saveLarge uploads rd as a large object, which consists of a manifest and
+// segments stored in the segment container.
+func (be *beSwift) saveLarge(ctx context.Context, objName, encoding string, rd backend.RewindReader) error {
+	if err := be.ensureSegmentContainer(ctx); err != nil {
+		return err
+	}
+
+	debug.Log("saving %v as %v large object", objName, be.largeObjectType)
+	opts := &swift.LargeObjectOpts{
+		Container:        be.container,
+		ObjectName:       objName,
+		Flags:            os.O_TRUNC,
+		ContentType:      encoding,
+		ChunkSize:        be.segmentSize,
+		SegmentContainer: be.segmentContainer,
+		SegmentPrefix:    segmentPrefix(objName),
+	}
+
+	var f swift.LargeObjectFile
+	var err error
+	if be.largeObjectType == "dynamic" {
+		f, err = be.conn.DynamicLargeObjectCreateFile(ctx, opts)
+	} else {
+		f, err = be.conn.StaticLargeObjectCreateFile(ctx, opts)
+	}
+	if err != nil {
+		return errors.Wrap(err, "conn.LargeObjectCreateFile")
+	}
+
+	if _, err := io.Copy(f, rd); err != nil {
+		_ = f.Close()
+		be.removeSegments(objName)
+		return errors.Wrap(err, "write large object")
+	}
+	if err := f.CloseWithContext(ctx); err != nil {
+		be.removeSegments(objName)
+		return errors.Wrap(err, "close large object")
+	}
+
+	if f.Size() != rd.Length() {
+		if err := be.conn.LargeObjectDelete(context.Background(), be.container, objName); err != nil {
+			debug.Log("unable to remove incomplete large object %v: %v", objName, err)
+		}
+		return errors.Errorf("wrote %d bytes instead of the expected %d bytes", f.Size(), rd.Length())
+	}
+	return nil
+}
+
+// segmentPrefix returns the prefix of the segments of the large object
+// objName. It only depends on the object name, so that a retried upload
+// overwrites the segments of the failed attempt.
+func segmentPrefix(objName string) string {
+	return path.Join("segments", objName)
+}
+
+// removeSegments deletes the segments of a large object whose upload failed.
+// The context of the upload may already be cancelled, so a new one is used.
+func (be *beSwift) removeSegments(objName string) {
+	ctx := context.Background()
+	names, err := be.conn.ObjectNamesAll(ctx, be.segmentContainer, &swift.ObjectsOpts{
+		Prefix: segmentPrefix(objName) + "/",
+	})
+	if err != nil {
+		debug.Log("unable to list segments of %v: %v", objName, err)
+		return
+	}
+
+	for _, name := range names {
+		err := be.conn.ObjectDelete(ctx, be.segmentContainer, name)
+		if err != nil && err != swift.ObjectNotFound {
+			debug.Log("unable to remove segment %v: %v", name, err)
+		}
+	}
+}
+
+// ensureSegmentContainer creates the segment container when the first large
+// object is saved.
+func (be *beSwift) ensureSegmentContainer(ctx context.Context) error {
+	be.m.Lock()
+	defer be.m.Unlock()
+
+	if be.segmentContainerChecked || be.segmentContainer == be.container {
+		return nil
+	}
+
+	switch _, _, err := be.conn.Container(ctx, be.segmentContainer); err {
+	case nil:
+	case swift.ContainerNotFound:
+		if err := be.conn.ContainerCreate(ctx, be.segmentContainer, nil); err != nil {
+			return errors.Wrap(err, "conn.ContainerCreate")
+		}
+	default:
+		return errors.Wrap(err, "conn.Container")
+	}
+
+	be.segmentContainerChecked = true
+	be.noSegmentContainer = false
+	return nil
+}
+
+// mayHaveLargeObjects returns false if no large objects can exist because the
+// segment container has not been created. The result is remembered, so that
+// removing files only costs a single request.
+func (be *beSwift) mayHaveLargeObjects(ctx context.Context) (bool, error) {
+	be.m.Lock()
+	defer be.m.Unlock()
+
+	if be.segmentContainerChecked || be.segmentContainer == be.container {
+		return true, nil
+	}
+	if be.noSegmentContainer {
+		return false, nil
+	}
+
+	switch _, _, err := be.conn.Container(ctx, be.segmentContainer); err {
+	case nil:
+		be.segmentContainerChecked = true
+		return true, nil
+	case swift.ContainerNotFound:
+		be.noSegmentContainer = true
+		return false, nil
+	default:
+		return false, errors.Wrap(err, "conn.Container")
+	}
+}
+
 // Stat returns information about a blob.
 func (be *beSwift) Stat(ctx context.Context, h backend.Handle) (bi backend.FileInfo, err error) {
 	objName := be.Filename(h)
@@ -189,6 +370,21 @@ func (be *beSwift) Stat(ctx context.Context, h backend.Handle) (bi backend.FileI
 func (be *beSwift) Remove(ctx context.Context, h backend.Handle) error {
 	objName := be.Filename(h)
 
+	if h.Type == backend.PackFile {
+		// only pack files can be large enough to be stored as large
+		// objects, their segments must be removed as well. LargeObjectDelete
+		// checks whether the object is a manifest and otherwise deletes it
+		// like ObjectDelete.
+		large, err := be.mayHaveLargeObjects(ctx)
+		if err != nil {
+			return err
+		}
+		if large {
+			err := be.conn.LargeObjectDelete(ctx, be.container, objName)
+			return errors.Wrap(err, "conn.LargeObjectDelete")
+		}
+	}
+
 	err := be.conn.ObjectDelete(ctx, be.container, objName)
 	return errors.Wrap(err, "conn.ObjectDelete")
 }
@@ -0,0 +1,154 @@
+package swift
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/konidev20/rapi/backend"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/ncw/swift/v2/swifttest"
+)
+
+func newTestServer(t *testing.T) *swifttest.SwiftServer {
+	srv, err := swifttest.NewSwiftServer("localhost")
+	rtest.OK(t, err)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newTestConfig(srv *swifttest.SwiftServer) Config {
+	cfg := NewConfig()
+	cfg.AuthURL = srv.AuthURL
+	cfg.UserName = swifttest.TEST_ACCOUNT
+	cfg.APIKey = swifttest.TEST_ACCOUNT
+	cfg.Container = "restic"
+	return cfg
+}
+
+func TestOpenInvalidLargeObjectType(t *testing.T) {
+	cfg := NewConfig()
+	cfg.LargeObjectType = "huge"
+	_, err := Open(context.TODO(), cfg, nil)
+	rtest.Assert(t, err != nil, "invalid large object type accepted")
+}
+
+func TestOpenDefaults(t *testing.T) {
+	srv := newTestServer(t)
+	be, err := Open(context.TODO(), newTestConfig(srv), nil)
+	rtest.OK(t, err)
+
+	sbe := be.(*beSwift)
+	rtest.Equals(t, int64(defaultLargeObjectThreshold)*1024*1024, sbe.largeObjectThreshold)
+	rtest.Equals(t, int64(defaultSegmentSize)*1024*1024, sbe.segmentSize)
+	rtest.Equals(t, "restic_segments", sbe.segmentContainer)
+}
+
+func TestSaveLarge(t *testing.T) {
+	for _, tpe := range []string{"static", "dynamic"} {
+		t.Run(tpe, func(t *testing.T) {
+			srv := newTestServer(t)
+			cfg := newTestConfig(srv)
+			cfg.LargeObjectThreshold = 1
+			cfg.LargeObjectType = tpe
+			cfg.SegmentSize = 1
+
+			be, err := Open(context.TODO(), cfg, nil)
+			rtest.OK(t, err)
+			sbe := be.(*beSwift)
+
+			data := rtest.Random(23, 2*1024*1024+1234)
+			large := backend.Handle{Type: backend.PackFile, Name: "large"}
+			rtest.OK(t, be.Save(context.TODO(), large, backend.NewByteReader(data, be.Hasher())))
+
+			small := backend.Handle{Type: backend.PackFile, Name: "small"}
+			rtest.OK(t, be.Save(context.TODO(), small, backend.NewByteReader(data[:1000], be.Hasher())))
+
+			_, headers, err := sbe.conn.Object(context.TODO(), sbe.container, sbe.Filename(large))
+			rtest.OK(t, err)
+			rtest.Assert(t, headers.IsLargeObject(), "object was not stored as large object")
+
+			fi, err := be.Stat(context.TODO(), large)
+			rtest.OK(t, err)
+			rtest.Equals(t, int64(len(data)), fi.Size)
+
+			var buf []byte
+			rtest.OK(t, be.Load(context.TODO(), large, 0, 0, func(rd io.Reader) (err error) {
+				buf, err = io.ReadAll(rd)
+				return err
+			}))
+			rtest.Assert(t, bytes.Equal(data, buf), "data of large object differs")
+
+			segments, err := sbe.conn.ObjectNamesAll(context.TODO(), sbe.segmentContainer, nil)
+			rtest.OK(t, err)
+			rtest.Equals(t, 3, len(segments))
+
+			rtest.OK(t, be.Remove(context.TODO(), small))
+			rtest.OK(t, be.Remove(context.TODO(), large))
+
+			segments, err = sbe.conn.ObjectNamesAll(context.TODO(), sbe.segmentContainer, nil)
+			rtest.OK(t, err)
+			rtest.Equals(t, 0, len(segments))
+
+			_, err = be.Stat(context.TODO(), large)
+			rtest.Assert(t, be.IsNotExist(err), "large object still exists: %v", err)
+		})
+	}
+}
+
+// failingReader returns an error after limit bytes have been read.
+type failingReader struct {
+	backend.RewindReader
+	limit int
+}
+
+func (rd *failingReader) Read(p []byte) (int, error) {
+	if rd.limit <= 0 {
+		return 0, errors.New("read failed")
+	}
+	if len(p) > rd.limit {
+		p = p[:rd.limit]
+	}
+	n, err := rd.RewindReader.Read(p)
+	rd.limit -= n
+	return n, err
+}
+
+func TestSaveLargeFailedRemovesSegments(t *testing.T) {
+	srv := newTestServer(t)
+	cfg := newTestConfig(srv)
+	cfg.LargeObjectThreshold = 1
+	cfg.SegmentSize = 1
+
+	be, err := Open(context.TODO(), cfg, nil)
+	rtest.OK(t, err)
+	sbe := be.(*beSwift)
+
+	data := rtest.Random(23, 3*1024*1024)
+	rd := &failingReader{RewindReader: backend.NewByteReader(data, be.Hasher()), limit: 2*1024*1024 + 1000}
+	h := backend.Handle{Type: backend.PackFile, Name: "large"}
+	err = be.Save(context.TODO(), h, rd)
+	rtest.Assert(t, err != nil, "failed upload did not return an error")
+
+	segments, err := sbe.conn.ObjectNamesAll(context.TODO(), sbe.segmentContainer, nil)
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, len(segments))
+}
+
+func TestRemoveWithoutSegmentContainer(t *testing.T) {
+	srv := newTestServer(t)
+	be, err := Open(context.TODO(), newTestConfig(srv), nil)
+	rtest.OK(t, err)
+	sbe := be.(*beSwift)
+
+	h := backend.Handle{Type: backend.PackFile, Name: "small"}
+	data := rtest.Random(23, 1000)
+	rtest.OK(t, be.Save(context.TODO(), h, backend.NewByteReader(data, be.Hasher())))
+	rtest.OK(t, be.Remove(context.TODO(), h))
+	rtest.Assert(t, sbe.noSegmentContainer, "missing segment container was not detected")
+
+	_, err = be.Stat(context.TODO(), h)
+	rtest.Assert(t, be.IsNotExist(err), "object still exists: %v", err)
+}
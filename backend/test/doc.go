@@ -10,30 +10,42 @@
 // then the methods RunTests() and RunBenchmarks() can be used to run the
 // individual tests and benchmarks as subtests/subbenchmarks.
 //
+// The suite is also meant for authors of third-party backends: it checks the
+// behavior the library relies upon, for example ranged reads, the handling of
+// cancelled contexts, retrying a failed Save for the same file and List
+// pagination without duplicates.
+//
 // # Example
 //
 // Assuming a *Suite is returned by newTestSuite(), the tests and benchmarks
 // can be run like this:
 //
-//	func newTestSuite(t testing.TB) *test.Suite {
-//		return &test.Suite{
-//			Create: func(cfg interface{}) (backend.Backend, error) {
+//	func newTestSuite(t testing.TB) *test.Suite[mybackend.Config] {
+//		return &test.Suite[mybackend.Config]{
+//			NewConfig: func() (*mybackend.Config, error) {
 //				[...]
 //			},
-//			[...]
+//			Factory: mybackend.NewFactory(),
 //		}
 //	}
 //
-//	func TestSuiteBackendMem(t *testing.T) {
+//	func TestSuiteBackend(t *testing.T) {
 //		newTestSuite(t).RunTests(t)
 //	}
 //
-//	func BenchmarkSuiteBackendMem(b *testing.B) {
+//	func BenchmarkSuiteBackend(b *testing.B) {
 //		newTestSuite(b).RunBenchmarks(b)
 //	}
 //
 // The functions are run in alphabetical order.
 //
+// # Results
+//
+// After RunTests, Report returns the capabilities declared by the factory,
+// the features observed on the backend and the result of each test. If
+// ReportFile is set, the report is also written to that file as JSON, so that
+// the results for several backends can be combined into a feature matrix.
+//
 // # Add new tests
 //
 // A new test or benchmark can be added by implementing a method on *Suite
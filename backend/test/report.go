@@ -0,0 +1,107 @@
+package test
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/konidev20/rapi/backend"
+)
+
+// Test results in a Report.
+const (
+	ResultPass = "pass"
+	ResultFail = "fail"
+	ResultSkip = "skip"
+)
+
+// Report is the machine-readable result of running the test suite against a
+// backend. It lists the capabilities which the backend declares, the features
+// observed on an opened backend and the result of each test.
+type Report struct {
+	Scheme       string               `json:"scheme"`
+	Capabilities backend.Capabilities `json:"capabilities"`
+	Features     Features             `json:"features"`
+	Tests        []TestResult         `json:"tests"`
+}
+
+// Features are the optional behaviors of an opened backend.
+type Features struct {
+	// ContentHash is true if the backend verifies uploads using Hasher.
+	ContentHash bool `json:"content_hash"`
+	// AtomicReplace is true if Save can atomically replace files.
+	AtomicReplace bool `json:"atomic_replace"`
+	// ListPagination is true if the number of items per List request can be
+	// configured.
+	ListPagination bool `json:"list_pagination"`
+	Connections    uint `json:"connections"`
+}
+
+// TestResult is the result of a single test.
+type TestResult struct {
+	Name     string        `json:"name"`
+	Result   string        `json:"result"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Report returns the results of the last call to RunTests.
+func (s *Suite[C]) Report() Report {
+	return s.report
+}
+
+func (s *Suite[C]) startReport(be backend.Backend) {
+	_, pagination := be.(setter)
+	s.report = Report{
+		Scheme:       s.Factory.Scheme(),
+		Capabilities: s.Factory.Capabilities(),
+		Features: Features{
+			ContentHash:    be.Hasher() != nil,
+			AtomicReplace:  be.HasAtomicReplace(),
+			ListPagination: pagination,
+			Connections:    be.Connections(),
+		},
+	}
+}
+
+// runTest runs fn as a subtest of t and records its result.
+func (s *Suite[C]) runTest(t *testing.T, name string, fn func(*testing.T)) {
+	start := time.Now()
+	skipped := false
+	ok := t.Run(name, func(t *testing.T) {
+		defer func() {
+			skipped = t.Skipped()
+		}()
+		fn(t)
+	})
+
+	result := ResultPass
+	if skipped {
+		result = ResultSkip
+	} else if !ok {
+		result = ResultFail
+	}
+
+	s.report.Tests = append(s.report.Tests, TestResult{
+		Name:     name,
+		Result:   result,
+		Duration: time.Since(start),
+	})
+}
+
+// writeReport writes the report as JSON to ReportFile, if it is set.
+func (s *Suite[C]) writeReport(t testing.TB) {
+	if s.ReportFile == "" {
+		return
+	}
+
+	buf, err := json.MarshalIndent(s.report, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(s.ReportFile, append(buf, '\n'), 0644)
+	if err != nil {
+		t.Fatalf("writing report failed: %v", err)
+	}
+}
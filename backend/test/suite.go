@@ -35,9 +35,16 @@ type Suite[C any] struct {
 
 	// ErrorHandler allows ignoring certain errors.
 	ErrorHandler func(testing.TB, backend.Backend, error) error
+
+	// ReportFile is the name of a file which receives the Report as JSON
+	// after RunTests, it is not written if empty.
+	ReportFile string
+
+	report Report
 }
 
-// RunTests executes all defined tests as subtests of t.
+// RunTests executes all defined tests as subtests of t. Afterwards, the
+// results are available from Report.
 func (s *Suite[C]) RunTests(t *testing.T) {
 	var err error
 	s.Config, err = s.NewConfig()
@@ -47,11 +54,13 @@ func (s *Suite[C]) RunTests(t *testing.T) {
 
 	// test create/open functions first
 	be := s.create(t)
+	s.startReport(be)
 	s.close(t, be)
 
 	for _, test := range s.testFuncs(t) {
-		t.Run(test.Name, test.Fn)
+		s.runTest(t, test.Name, test.Fn)
 	}
+	s.writeReport(t)
 
 	if !test.TestCleanupTempDirs {
 		t.Logf("not cleaning up backend")
@@ -877,6 +877,186 @@ func (s *Suite[C]) TestBackend(t *testing.T) {
 	}
 }
 
+// TestLoadRanges tests that ranged reads return exactly the requested part
+// of a file, including reads at the boundaries of the file.
+func (s *Suite[C]) TestLoadRanges(t *testing.T) {
+	b := s.open(t)
+	defer s.close(t, b)
+
+	data := test.Random(26, 10000)
+	id := restic.Hash(data)
+	h := backend.Handle{Type: backend.PackFile, Name: id.String()}
+	test.OK(t, b.Save(context.TODO(), h, backend.NewByteReader(data, b.Hasher())))
+
+	var tests = []struct {
+		length int
+		offset int64
+		want   []byte
+	}{
+		{0, 0, data},
+		{1, 0, data[:1]},
+		{0, 1, data[1:]},
+		{100, 5000, data[5000:5100]},
+		{0, int64(len(data)) - 1, data[len(data)-1:]},
+		{1, int64(len(data)) - 1, data[len(data)-1:]},
+		{len(data), 0, data},
+		{len(data) + 100, 9000, data[9000:]},
+	}
+
+	for _, tc := range tests {
+		t.Run(fmt.Sprintf("%d-%d", tc.offset, tc.length), func(t *testing.T) {
+			var buf []byte
+			err := b.Load(context.TODO(), h, tc.length, tc.offset, func(rd io.Reader) (ierr error) {
+				buf, ierr = io.ReadAll(rd)
+				return ierr
+			})
+			test.OK(t, err)
+			if !bytes.Equal(buf, tc.want) {
+				t.Errorf("wrong data returned, want %d bytes, got %d bytes", len(tc.want), len(buf))
+			}
+		})
+	}
+
+	test.OK(t, s.delayedRemove(t, b, h))
+}
+
+// TestLoadCancel tests that Load respects a cancelled context.
+func (s *Suite[C]) TestLoadCancel(t *testing.T) {
+	b := s.open(t)
+	defer s.close(t, b)
+
+	data := test.Random(27, 1000)
+	id := restic.Hash(data)
+	h := backend.Handle{Type: backend.PackFile, Name: id.String()}
+	test.OK(t, b.Save(context.TODO(), h, backend.NewByteReader(data, b.Hasher())))
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+
+	err := b.Load(ctx, h, 0, 0, func(rd io.Reader) error {
+		_, err := io.Copy(io.Discard, rd)
+		return err
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected error not found, want %v, got %v", context.Canceled, err)
+	}
+
+	test.OK(t, s.delayedRemove(t, b, h))
+}
+
+// TestSaveCancel tests that Save respects a cancelled context. Afterwards,
+// the file must either be missing or complete.
+func (s *Suite[C]) TestSaveCancel(t *testing.T) {
+	b := s.open(t)
+	defer s.close(t, b)
+
+	data := test.Random(28, 100000)
+	id := restic.Hash(data)
+	h := backend.Handle{Type: backend.PackFile, Name: id.String()}
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+
+	err := b.Save(ctx, h, backend.NewByteReader(data, b.Hasher()))
+	if err == nil {
+		t.Fatal("Save with cancelled context did not fail")
+	}
+
+	exists, err := beTest(context.TODO(), b, h)
+	test.OK(t, err)
+	if exists {
+		buf, err := backend.LoadAll(context.TODO(), nil, b, h)
+		test.OK(t, err)
+		test.Assert(t, bytes.Equal(buf, data), "incomplete file left behind by cancelled Save")
+		test.OK(t, s.delayedRemove(t, b, h))
+	}
+}
+
+// failingReader returns an error after half of the data was read.
+type failingReader struct {
+	backend.RewindReader
+	read int64
+}
+
+var errFailingReader = errors.New("deliberate read error")
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.read >= r.Length()/2 {
+		return 0, errFailingReader
+	}
+	if rest := r.Length()/2 - r.read; int64(len(p)) > rest {
+		p = p[:rest]
+	}
+	n, err := r.RewindReader.Read(p)
+	r.read += int64(n)
+	return n, err
+}
+
+// TestSaveRetry tests that a Save which failed while reading the data can be
+// retried for the same file, as done by the retry backend.
+func (s *Suite[C]) TestSaveRetry(t *testing.T) {
+	b := s.open(t)
+	defer s.close(t, b)
+
+	data := test.Random(29, 300000)
+	id := restic.Hash(data)
+	h := backend.Handle{Type: backend.PackFile, Name: id.String()}
+
+	err := b.Save(context.TODO(), h, &failingReader{RewindReader: backend.NewByteReader(data, b.Hasher())})
+	if err == nil {
+		t.Fatal("Save with failing reader did not fail")
+	}
+
+	err = b.Save(context.TODO(), h, backend.NewByteReader(data, b.Hasher()))
+	if err != nil {
+		t.Fatalf("retried Save failed: %+v", err)
+	}
+
+	buf, err := backend.LoadAll(context.TODO(), nil, b, h)
+	test.OK(t, err)
+	test.Assert(t, bytes.Equal(buf, data), "retried Save stored wrong data")
+
+	test.OK(t, s.delayedRemove(t, b, h))
+}
+
+// TestListPagination tests that List returns each file exactly once, also if
+// the backend needs several requests to list all files.
+func (s *Suite[C]) TestListPagination(t *testing.T) {
+	b := s.open(t)
+	defer s.close(t, b)
+
+	const numTestFiles = 7
+	handles := make([]backend.Handle, 0, numTestFiles)
+	for i := 0; i < numTestFiles; i++ {
+		data := []byte(fmt.Sprintf("pagination test blob %v", i))
+		h := backend.Handle{Type: backend.PackFile, Name: restic.Hash(data).String()}
+		test.OK(t, b.Save(context.TODO(), h, backend.NewByteReader(data, b.Hasher())))
+		handles = append(handles, h)
+	}
+
+	for _, maxItems := range []int{1, 2, numTestFiles - 1, numTestFiles} {
+		t.Run(fmt.Sprintf("max-%v", maxItems), func(t *testing.T) {
+			if s, ok := b.(setter); ok {
+				s.SetListMaxItems(maxItems)
+			}
+
+			seen := make(map[string]int)
+			test.OK(t, b.List(context.TODO(), backend.PackFile, func(fi backend.FileInfo) error {
+				seen[fi.Name]++
+				return nil
+			}))
+
+			for _, h := range handles {
+				if seen[h.Name] != 1 {
+					t.Errorf("file %v listed %d times", h.Name, seen[h.Name])
+				}
+			}
+		})
+	}
+
+	test.OK(t, s.delayedRemove(t, b, handles...))
+}
+
 // TestZZZDelete tests the Delete function. The name ensures that this test is executed last.
 func (s *Suite[C]) TestZZZDelete(t *testing.T) {
 	if !test.TestCleanupTempDirs {
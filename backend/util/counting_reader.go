@@ -0,0 +1,15 @@
+package util
+
+import "io"
+
+// CountingReader counts the bytes read from the underlying reader.
+type CountingReader struct {
+	io.Reader
+	N int64
+}
+
+func (rd *CountingReader) Read(p []byte) (int, error) {
+	n, err := rd.Reader.Read(p)
+	rd.N += int64(n)
+	return n, err
+}
@@ -12,6 +12,10 @@ func DefaultLoad(ctx context.Context, h backend.Handle, length int, offset int64
 	openReader func(ctx context.Context, h backend.Handle, length int, offset int64) (io.ReadCloser, error),
 	fn func(rd io.Reader) error) error {
 
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	rd, err := openReader(ctx, h, length, offset)
 	if err != nil {
 		return err
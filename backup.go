@@ -0,0 +1,429 @@
+package rapi
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/konidev20/rapi/anomaly"
+	"github.com/konidev20/rapi/hooks"
+	"github.com/konidev20/rapi/internal/archiver"
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/internal/filter"
+	"github.com/konidev20/rapi/internal/fs"
+	"github.com/konidev20/rapi/restic"
+)
+
+// BackupOptions configure Backup.
+type BackupOptions struct {
+	// Source is the tree of files which is backed up, the targets are
+	// absolute paths in it. If Source is nil, the local file system is
+	// used.
+	Source Source
+
+	// Hostname and Tags of the new snapshot. The hostname of the machine
+	// is used if Hostname is empty.
+	Hostname string
+	Tags     []string
+
+	// Time of the new snapshot. If it is zero, the current time of the clock
+	// of the context is used, see restic.WithClock, or the newest
+	// modification time of the saved items for a Deterministic backup.
+	Time time.Time
+
+	// Parent is the snapshot used to detect unchanged files, which are not
+	// read again. May be nil.
+	Parent *restic.Snapshot
+
+	// Normalization is applied to the paths and the hostname stored in the
+	// snapshot, so that snapshots of different platforms can be matched.
+	// Use the same normalization in restic.SnapshotFilter to find them.
+	Normalization restic.PathNormalization
+
+	// Excludes are patterns of files which are not saved, see package
+	// filter for the syntax.
+	Excludes []string
+
+	// Preset adds the exclude patterns of maintained lists of operating
+	// system and application caches, see filter.Presets for the names.
+	Preset []string
+
+	// ExcludeCaches excludes the contents of directories tagged with a
+	// CACHEDIR.TAG file. ExcludeIfPresent excludes the contents of
+	// directories containing one of the files, given as "name" or
+	// "name:header" if the file must start with header. The directories
+	// and the marker files are kept.
+	ExcludeCaches    bool
+	ExcludeIfPresent []string
+
+	// ExcludeNoDump excludes files and directories with the nodump flag.
+	ExcludeNoDump bool
+
+	// OneFileSystem excludes the contents of file systems mounted below the
+	// targets, except below the mountpoints listed in AllowedMountpoints.
+	// The mountpoints themselves are kept.
+	OneFileSystem      bool
+	AllowedMountpoints []string
+
+	// SpecialFiles configures whether devices, FIFOs and sockets are saved,
+	// skipped or reported as failed. By default, sockets are skipped.
+	SpecialFiles restic.SpecialFilePolicy
+
+	// FollowTargetSymlinks saves the contents of targets which are symlinks,
+	// for example /data -> /mnt/vol1/data, under the path of the link
+	// instead of the link itself. Symlinks below the targets are not
+	// followed. Targets pointing to one of their parent directories are
+	// reported as failed and saved as links.
+	FollowTargetSymlinks bool
+
+	// MaxFailedFiles fails the backup once more files could not be read,
+	// zero means no limit.
+	MaxFailedFiles int
+
+	// MaxFailedPercent fails the backup if the size of the files which could
+	// not be read exceeds this percentage of the size of all files, zero
+	// means no limit. It is checked before the snapshot is saved.
+	MaxFailedPercent float64
+
+	// ContentScanner returns the scanner for each file which is read, may
+	// be nil. Files flagged or skipped by a scanner are listed in
+	// BackupResult.Flagged, skipped files are not stored in the snapshot.
+	// Files which are unchanged since Parent are not read and not scanned.
+	ContentScanner ContentScanFunc
+
+	// Deterministic reads and saves the files one at a time, so that
+	// backing up the same data to a deterministic repository produces
+	// byte-identical files, see RepositoryOptions.Deterministic. The
+	// snapshot contains no summary, since it includes the current time.
+	Deterministic bool
+
+	// Warnings collects the warnings of the backup in addition to the
+	// result, may be nil.
+	Warnings *restic.Warnings
+
+	// Anomalies enables the detection of suspicious backups with the given
+	// options, see BackupResult.Risk. The share of modified files is only
+	// known if Parent is set.
+	Anomalies *anomaly.Options
+
+	// Hooks are run before and after the backup, may be nil. If a PreBackup
+	// hook fails, the backup is not started. Errors of the other hooks are
+	// reported as warnings.
+	Hooks *hooks.Hooks
+}
+
+// ContentScanner inspects the contents of a file while it is backed up, for
+// example to detect personal data, see archiver.ContentScanner.
+type ContentScanner = archiver.ContentScanner
+
+// ContentScanFunc returns the scanner for a file which is read during a
+// backup, or nil if the file is not scanned. It is called concurrently for
+// several files.
+type ContentScanFunc = archiver.ContentScanFunc
+
+// ScanVerdict is the result of scanning a file.
+type ScanVerdict = archiver.ScanVerdict
+
+// ScanAction is the decision of a ContentScanner about a file.
+type ScanAction = archiver.ScanAction
+
+const (
+	// ScanAllow saves the file.
+	ScanAllow = archiver.ScanAllow
+	// ScanFlag saves the file and lists it in BackupResult.Flagged.
+	ScanFlag = archiver.ScanFlag
+	// ScanSkip excludes the file from the snapshot and lists it in
+	// BackupResult.Flagged. Its data is already stored in the repository
+	// and removed by the next prune unless other files reference it.
+	ScanSkip = archiver.ScanSkip
+)
+
+// FlaggedPath is a file which a ContentScanner flagged or skipped.
+type FlaggedPath struct {
+	Path    string
+	Verdict ScanVerdict
+}
+
+// FailedPath is a file or directory which could not be read by Backup.
+type FailedPath struct {
+	Path string
+	Err  error
+	// Size is the size of the file if it could be determined.
+	Size uint64
+}
+
+// PartialResult lists the files which could not be read by Backup.
+type PartialResult struct {
+	Failed []FailedPath
+	// FailedBytes is the size of the failed files, TotalBytes the size of
+	// the files which were read plus FailedBytes.
+	FailedBytes uint64
+	TotalBytes  uint64
+}
+
+// FailedPercent returns the size of the failed files as a percentage of the
+// size of all files.
+func (r *PartialResult) FailedPercent() float64 {
+	if r.TotalBytes == 0 {
+		if len(r.Failed) > 0 {
+			return 100
+		}
+		return 0
+	}
+	return float64(r.FailedBytes) / float64(r.TotalBytes) * 100
+}
+
+// BackupResult is the outcome of Backup.
+type BackupResult struct {
+	// Snapshot and ID are only set if a snapshot was saved.
+	Snapshot *restic.Snapshot
+	ID       restic.ID
+
+	// Partial lists the files which could not be read, it is nil if all
+	// files were saved.
+	Partial *PartialResult
+
+	// Flagged lists the files flagged or skipped by the ContentScanner, in
+	// the order they were scanned.
+	Flagged []FlaggedPath
+
+	// Warnings are the problems which did not stop the backup, including
+	// the failed files and extended attributes which could not be read.
+	Warnings []restic.Warning
+
+	// Risk is the outcome of the anomaly detection, it is nil if it was not
+	// enabled or no snapshot was saved.
+	Risk *anomaly.Report
+}
+
+// Backup saves targets of the local file system, or of opts.Source, in a new
+// snapshot. Files which cannot be read are skipped: the snapshot is saved
+// nonetheless and the returned error satisfies errors.Is(err,
+// ErrPartialBackup), the result lists the files. If the thresholds in opts
+// are exceeded, the snapshot is not saved and the error satisfies
+// errors.Is(err, ErrSourceUnreadable). The index must already be loaded.
+//
+// The PostBackup hooks are run if a snapshot was saved, with Info.Err set
+// for a partial backup, otherwise the OnError hooks are run if Backup failed.
+func Backup(ctx context.Context, repo restic.Repository, targets []string, opts BackupOptions) (BackupResult, error) {
+	if opts.Warnings == nil {
+		opts.Warnings = restic.NewWarnings(nil)
+	}
+	info := hookInfo(repo, "backup", restic.Now(ctx))
+
+	res, err := backup(ctx, repo, targets, opts, info)
+	info.Snapshot, info.Err = res.ID, err
+	switch {
+	case !res.ID.IsNull():
+		runHooks(ctx, opts.Hooks, info, hooks.PostBackup, opts.Warnings)
+	case err != nil:
+		runHooks(ctx, opts.Hooks, info, hooks.OnError, opts.Warnings)
+	}
+	res.Warnings = opts.Warnings.List()
+	return res, err
+}
+
+func backup(ctx context.Context, repo restic.Repository, targets []string, opts BackupOptions, info hooks.Info) (_ BackupResult, err error) {
+	if len(targets) == 0 {
+		return BackupResult{}, errors.Fatal("no targets given")
+	}
+
+	if opts.Hostname == "" {
+		opts.Hostname, err = os.Hostname()
+		if err != nil {
+			return BackupResult{}, errors.Wrap(err, "Hostname")
+		}
+	}
+	if opts.Time.IsZero() && !opts.Deterministic {
+		opts.Time = restic.Now(ctx)
+	}
+	if err := opts.Normalization.Validate(); err != nil {
+		return BackupResult{}, errors.Fatal(err.Error())
+	}
+
+	excludes, err := filter.PresetPatterns(opts.Preset)
+	if err != nil {
+		return BackupResult{}, errors.Fatal(err.Error())
+	}
+	excludes = append(excludes, opts.Excludes...)
+	if err := filter.ValidatePatterns(excludes); err != nil {
+		return BackupResult{}, errors.Fatal(err.Error())
+	}
+	patterns := filter.ParsePatterns(excludes)
+
+	var markers []archiver.ExcludeMarker
+	for _, spec := range opts.ExcludeIfPresent {
+		name, header, _ := strings.Cut(spec, ":")
+		if name == "" {
+			return BackupResult{}, errors.Fatalf("invalid marker file %q", spec)
+		}
+		markers = append(markers, archiver.ExcludeMarker{Name: name, Header: header})
+	}
+
+	info.Event = hooks.PreBackup
+	if err := opts.Hooks.Run(ctx, info); err != nil {
+		return BackupResult{}, err
+	}
+
+	op, err := beginOperation(ctx, repo, "backup", false)
+	if err != nil {
+		return BackupResult{}, err
+	}
+	defer func() { op.end(ctx, err != nil && !errors.Is(err, ErrPartialBackup)) }()
+
+	var sampler *anomaly.Sampler
+	archRepo := repo
+	if opts.Anomalies != nil {
+		sampler = &anomaly.Sampler{}
+		archRepo = &samplingRepository{Repository: repo, sampler: sampler}
+	}
+
+	var filesystem fs.FS = fs.Track{FS: fs.Local{}}
+	if opts.Source != nil {
+		filesystem = fs.NewSourceFS(opts.Source)
+	}
+
+	t := &failureTracker{opts: opts, fs: filesystem}
+	arch := archiver.New(archRepo, filesystem, archiver.Options{Deterministic: opts.Deterministic})
+	arch.Error = t.fail
+	arch.CompleteItem = t.complete
+	arch.Warnings = opts.Warnings
+	arch.SpecialFiles = opts.SpecialFiles
+
+	var flaggedMu sync.Mutex
+	var flagged []FlaggedPath
+	if opts.ContentScanner != nil {
+		arch.ScanContent = opts.ContentScanner
+		arch.Flagged = func(item string, v ScanVerdict) {
+			flaggedMu.Lock()
+			flagged = append(flagged, FlaggedPath{Path: item, Verdict: v})
+			flaggedMu.Unlock()
+		}
+	}
+	if len(patterns) > 0 {
+		arch.SelectByName = func(item string) bool {
+			matched, err := filter.List(patterns, item)
+			if err != nil {
+				debug.Log("unable to match %v: %v", item, err)
+			}
+			return !matched
+		}
+	}
+
+	sn, id, err := arch.Snapshot(ctx, targets, archiver.SnapshotOptions{
+		Tags:             opts.Tags,
+		Hostname:         opts.Hostname,
+		Excludes:         excludes,
+		Time:             opts.Time,
+		ParentSnapshot:   opts.Parent,
+		Normalization:    opts.Normalization,
+		ExcludeCaches:    opts.ExcludeCaches,
+		ExcludeIfPresent: markers,
+		ExcludeNoDump:    opts.ExcludeNoDump,
+
+		OneFileSystem:        opts.OneFileSystem,
+		AllowedMountpoints:   opts.AllowedMountpoints,
+		FollowTargetSymlinks: opts.FollowTargetSymlinks,
+		BeforeSave: func(*restic.Snapshot) error {
+			return t.check(true)
+		},
+	})
+
+	res := BackupResult{
+		Partial:  t.result(),
+		Flagged:  flagged,
+		Warnings: opts.Warnings.List(),
+	}
+	if err != nil {
+		return res, err
+	}
+
+	res.Snapshot, res.ID = sn, id
+	if opts.Anomalies != nil {
+		entropy := sampler.Stats()
+		risk, derr := detectAnomalies(ctx, repo, id, sn, &entropy, *opts.Anomalies)
+		// the snapshot was saved nonetheless
+		opts.Warnings.Add("anomaly detection", derr)
+		res.Risk = risk
+		res.Warnings = opts.Warnings.List()
+	}
+	if res.Partial != nil {
+		return res, classify(ErrPartialBackup, errors.Errorf("%d files could not be read", len(res.Partial.Failed)))
+	}
+	return res, nil
+}
+
+// failureTracker records the files which could not be read during a backup
+// and checks the thresholds.
+type failureTracker struct {
+	opts BackupOptions
+	fs   fs.FS
+
+	m         sync.Mutex
+	failed    []FailedPath
+	failedSum uint64
+	processed uint64
+}
+
+// fail is the error callback of the archiver.
+func (t *failureTracker) fail(item string, err error) error {
+	f := FailedPath{Path: item, Err: err}
+	if fi, serr := t.fs.Lstat(item); serr == nil && fi.Mode().IsRegular() {
+		f.Size = uint64(fi.Size())
+	}
+	debug.Log("unable to read %v: %v", item, err)
+
+	t.m.Lock()
+	t.failed = append(t.failed, f)
+	t.failedSum += f.Size
+	t.m.Unlock()
+
+	return t.check(false)
+}
+
+// complete is the CompleteItem callback of the archiver.
+func (t *failureTracker) complete(_ string, _, current *restic.Node, _ archiver.ItemStats, _ time.Duration) {
+	if current == nil || current.Type != "file" {
+		return
+	}
+	t.m.Lock()
+	t.processed += current.Size
+	t.m.Unlock()
+}
+
+// check returns an error if a threshold is exceeded. The size is only checked
+// if final is set, while the backup runs the share of failed bytes is not
+// known yet.
+func (t *failureTracker) check(final bool) error {
+	res := t.result()
+	if res == nil {
+		return nil
+	}
+	if t.opts.MaxFailedFiles > 0 && len(res.Failed) > t.opts.MaxFailedFiles {
+		return classify(ErrSourceUnreadable, errors.Errorf("%d files could not be read, more than the limit of %d",
+			len(res.Failed), t.opts.MaxFailedFiles))
+	}
+	if final && t.opts.MaxFailedPercent > 0 && res.FailedPercent() > t.opts.MaxFailedPercent {
+		return classify(ErrSourceUnreadable, fmt.Errorf("%.1f%% of the data could not be read, more than the limit of %.1f%%",
+			res.FailedPercent(), t.opts.MaxFailedPercent))
+	}
+	return nil
+}
+
+// result returns the failed files, or nil if there are none.
+func (t *failureTracker) result() *PartialResult {
+	t.m.Lock()
+	defer t.m.Unlock()
+	if len(t.failed) == 0 {
+		return nil
+	}
+	return &PartialResult{
+		Failed:      append([]FailedPath(nil), t.failed...),
+		FailedBytes: t.failedSum,
+		TotalBytes:  t.processed + t.failedSum,
+	}
+}
@@ -0,0 +1,319 @@
+package rapi_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/konidev20/rapi"
+	"github.com/konidev20/rapi/anomaly"
+	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/hooks"
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/internal/filter"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/restic"
+)
+
+func TestBackup(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.TestRepository(t)
+
+	src := rtest.TempDir(t)
+	rtest.OK(t, os.WriteFile(filepath.Join(src, "a"), rtest.Random(1, 1000), 0600))
+	rtest.OK(t, os.WriteFile(filepath.Join(src, "b"), rtest.Random(2, 3000), 0600))
+
+	res, err := rapi.Backup(ctx, repo, []string{src}, rapi.BackupOptions{Hostname: "test"})
+	rtest.OK(t, err)
+	rtest.Assert(t, res.Snapshot != nil, "no snapshot returned")
+	rtest.Assert(t, res.Partial == nil, "unexpected failures %v", res.Partial)
+	rtest.Equals(t, "test", res.Snapshot.Hostname)
+
+	rtest.OK(t, os.WriteFile(filepath.Join(src, ".DS_Store"), []byte("x"), 0600))
+	res, err = rapi.Backup(ctx, repo, []string{src}, rapi.BackupOptions{
+		Hostname: "test",
+		Excludes: []string{"b"},
+		Preset:   []string{filter.PresetMacOS},
+	})
+	rtest.OK(t, err)
+	rtest.Equals(t, uint(1), res.Snapshot.Summary.TotalFilesProcessed)
+	rtest.Assert(t, len(res.Snapshot.Excludes) > 1, "excludes not stored in snapshot")
+
+	// all files are on the file system of the target
+	res, err = rapi.Backup(ctx, repo, []string{src}, rapi.BackupOptions{Hostname: "test", OneFileSystem: true})
+	rtest.OK(t, err)
+	rtest.Equals(t, uint(3), res.Snapshot.Summary.TotalFilesProcessed)
+
+	norm := restic.PathNormalization{FoldCase: true, Prefix: "/machines/Host"}
+	res, err = rapi.Backup(ctx, repo, []string{src}, rapi.BackupOptions{Hostname: "TEST", Normalization: norm})
+	rtest.OK(t, err)
+	rtest.Equals(t, "test", res.Snapshot.Hostname)
+	rtest.Equals(t, norm.Paths([]string{src}), res.Snapshot.Paths)
+	rtest.Assert(t, strings.HasPrefix(res.Snapshot.Paths[0], "/machines/host"), "paths not normalized: %v", res.Snapshot.Paths)
+
+	_, err = rapi.Backup(ctx, repo, []string{src}, rapi.BackupOptions{Normalization: restic.PathNormalization{Separator: ':'}})
+	rtest.Assert(t, err != nil, "invalid normalization accepted")
+
+	_, err = rapi.Backup(ctx, repo, []string{src}, rapi.BackupOptions{Preset: []string{"unknown"}})
+	rtest.Assert(t, err != nil, "unknown preset accepted")
+}
+
+func TestBackupSource(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.TestRepository(t)
+
+	src := rapi.NewIOFSSource(fstest.MapFS{
+		"data/a":     {Data: []byte("content of a"), Mode: 0644},
+		"data/sub/b": {Data: []byte("content of b"), Mode: 0600},
+		"data/link":  {Data: []byte("a"), Mode: os.ModeSymlink},
+	})
+	res, err := rapi.Backup(ctx, repo, []string{"/data"}, rapi.BackupOptions{Hostname: "test", Source: src})
+	rtest.OK(t, err)
+	rtest.Equals(t, []string{"/data"}, res.Snapshot.Paths)
+	rtest.Equals(t, uint(2), res.Snapshot.Summary.TotalFilesProcessed)
+	rtest.Equals(t, "content of a", loadFileContent(t, repo, res.ID, "/data/a"))
+	rtest.Equals(t, "content of b", loadFileContent(t, repo, res.ID, "/data/sub/b"))
+
+	var target string
+	rtest.OK(t, rapi.Ls(ctx, repo, res.ID, rapi.LsOptions{Recursive: true}, func(entry rapi.LsEntry) error {
+		if entry.Path == "/data/link" {
+			target = entry.Node.LinkTarget
+		}
+		return nil
+	}))
+	rtest.Equals(t, "a", target)
+}
+
+type keywordScanner struct {
+	buf strings.Builder
+}
+
+func (s *keywordScanner) Write(p []byte) (int, error) {
+	return s.buf.Write(p)
+}
+
+func (s *keywordScanner) Verdict() (rapi.ScanVerdict, error) {
+	switch {
+	case strings.Contains(s.buf.String(), "secret"):
+		return rapi.ScanVerdict{Action: rapi.ScanSkip, Reason: "secret"}, nil
+	case strings.Contains(s.buf.String(), "personal"):
+		return rapi.ScanVerdict{Action: rapi.ScanFlag, Reason: "personal"}, nil
+	}
+	return rapi.ScanVerdict{Action: rapi.ScanAllow}, nil
+}
+
+func TestBackupContentScanner(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.TestRepository(t)
+
+	src := rapi.NewIOFSSource(fstest.MapFS{
+		"data/plain":    {Data: []byte("nothing to see"), Mode: 0644},
+		"data/personal": {Data: []byte("personal data"), Mode: 0644},
+		"data/secret":   {Data: []byte("a secret"), Mode: 0644},
+	})
+	res, err := rapi.Backup(ctx, repo, []string{"/data"}, rapi.BackupOptions{
+		Hostname: "test",
+		Source:   src,
+		ContentScanner: func(snPath string, fi os.FileInfo) rapi.ContentScanner {
+			return &keywordScanner{}
+		},
+	})
+	rtest.OK(t, err)
+
+	flagged := make(map[string]rapi.ScanVerdict)
+	for _, f := range res.Flagged {
+		flagged[f.Path] = f.Verdict
+	}
+	rtest.Equals(t, map[string]rapi.ScanVerdict{
+		"/data/personal": {Action: rapi.ScanFlag, Reason: "personal"},
+		"/data/secret":   {Action: rapi.ScanSkip, Reason: "secret"},
+	}, flagged)
+
+	rtest.Equals(t, "nothing to see", loadFileContent(t, repo, res.ID, "/data/plain"))
+	rtest.Equals(t, "personal data", loadFileContent(t, repo, res.ID, "/data/personal"))
+
+	var names []string
+	rtest.OK(t, rapi.Ls(ctx, repo, res.ID, rapi.LsOptions{Recursive: true}, func(entry rapi.LsEntry) error {
+		names = append(names, entry.Path)
+		return nil
+	}))
+	for _, name := range names {
+		rtest.Assert(t, name != "/data/secret", "skipped file %v is in the snapshot", name)
+	}
+}
+
+func TestBackupDeterministic(t *testing.T) {
+	ctx := context.Background()
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	src := rapi.NewIOFSSource(fstest.MapFS{
+		"data/a":     {Data: rtest.Random(1, 3*1024*1024), Mode: 0644, ModTime: modTime},
+		"data/sub/b": {Data: rtest.Random(2, 5000), Mode: 0644, ModTime: modTime.Add(-time.Hour)},
+		"data/c":     {Data: rtest.Random(3, 1024*1024), Mode: 0644, ModTime: modTime.Add(-2 * time.Hour)},
+	})
+
+	// both backups are saved to copies of the same repository
+	template := repository.TestBackend(t)
+	repository.TestRepositoryWithBackend(t, template, 0)
+	templateFiles := repositoryFiles(t, template)
+
+	var results []map[backend.Handle][]byte
+	for i := 0; i < 2; i++ {
+		be := repository.TestBackend(t)
+		for h, buf := range templateFiles {
+			rtest.OK(t, be.Save(ctx, h, backend.NewByteReader(buf, be.Hasher())))
+		}
+
+		repo, err := repository.New(be, repository.Options{Deterministic: true})
+		rtest.OK(t, err)
+		rtest.OK(t, repo.SearchKey(ctx, rtest.TestPassword, 1, ""))
+
+		res, err := rapi.Backup(ctx, repo, []string{"/data"}, rapi.BackupOptions{
+			Hostname:      "test",
+			Source:        src,
+			Deterministic: true,
+		})
+		rtest.OK(t, err)
+		rtest.Assert(t, res.Snapshot.Time.Equal(modTime), "wrong snapshot time %v, want %v", res.Snapshot.Time, modTime)
+
+		results = append(results, repositoryFiles(t, be))
+	}
+
+	rtest.Equals(t, len(results[0]), len(results[1]))
+	for h, buf := range results[0] {
+		rtest.Assert(t, bytes.Equal(buf, results[1][h]), "file %v differs between the backups", h)
+	}
+}
+
+// repositoryFiles returns the contents of all files in be except the locks.
+func repositoryFiles(t *testing.T, be backend.Backend) map[backend.Handle][]byte {
+	files := make(map[backend.Handle][]byte)
+	for _, tpe := range []backend.FileType{backend.ConfigFile, backend.KeyFile, backend.PackFile, backend.IndexFile, backend.SnapshotFile} {
+		err := be.List(context.TODO(), tpe, func(fi backend.FileInfo) error {
+			h := backend.Handle{Type: tpe, Name: fi.Name}
+			buf, err := backend.LoadAll(context.TODO(), nil, be, h)
+			files[h] = buf
+			return err
+		})
+		rtest.OK(t, err)
+	}
+	return files
+}
+
+func TestBackupPartial(t *testing.T) {
+	if runtime.GOOS == "windows" || os.Getuid() == 0 {
+		t.Skip("file permissions are not enforced")
+	}
+
+	ctx := context.Background()
+	repo := repository.TestRepository(t)
+
+	src := rtest.TempDir(t)
+	rtest.OK(t, os.WriteFile(filepath.Join(src, "a"), rtest.Random(1, 1000), 0600))
+	rtest.OK(t, os.WriteFile(filepath.Join(src, "b"), rtest.Random(2, 3000), 0000))
+
+	res, err := rapi.Backup(ctx, repo, []string{src}, rapi.BackupOptions{Hostname: "test"})
+	rtest.Assert(t, errors.Is(err, rapi.ErrPartialBackup), "wrong error %v", err)
+	rtest.Equals(t, rapi.ExitPartial, rapi.ExitCode(err))
+	rtest.Assert(t, res.Snapshot != nil, "no snapshot saved")
+	rtest.Equals(t, 1, len(res.Partial.Failed))
+	rtest.Equals(t, filepath.Join(src, "b"), res.Partial.Failed[0].Path)
+	rtest.Equals(t, uint64(3000), res.Partial.FailedBytes)
+	rtest.Equals(t, uint64(4000), res.Partial.TotalBytes)
+	rtest.Equals(t, 1, len(res.Warnings))
+
+	// too much data is missing
+	res, err = rapi.Backup(ctx, repo, []string{src}, rapi.BackupOptions{Hostname: "test", MaxFailedPercent: 50})
+	rtest.Assert(t, errors.Is(err, rapi.ErrSourceUnreadable), "wrong error %v", err)
+	rtest.Assert(t, res.Snapshot == nil, "snapshot saved")
+
+	_, err = rapi.Backup(ctx, repo, []string{src}, rapi.BackupOptions{Hostname: "test", MaxFailedPercent: 80})
+	rtest.Assert(t, errors.Is(err, rapi.ErrPartialBackup), "wrong error %v", err)
+}
+
+func TestBackupHooks(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.TestRepository(t)
+	src := rtest.TempDir(t)
+	rtest.OK(t, os.WriteFile(filepath.Join(src, "a"), rtest.Random(1, 1000), 0600))
+
+	var events []hooks.Info
+	record := hooks.Func(func(_ context.Context, info hooks.Info) error {
+		events = append(events, info)
+		return nil
+	})
+	h := hooks.New()
+	for _, ev := range hooks.Events {
+		rtest.OK(t, h.Register(ev, record))
+	}
+	rtest.OK(t, h.Register(hooks.PostBackup, hooks.Func(func(context.Context, hooks.Info) error {
+		return errors.New("ping failed")
+	})))
+
+	res, err := rapi.Backup(ctx, repo, []string{src}, rapi.BackupOptions{Hostname: "test", Hooks: h})
+	rtest.OK(t, err)
+	rtest.Equals(t, 2, len(events))
+	rtest.Equals(t, hooks.PreBackup, events[0].Event)
+	rtest.Equals(t, hooks.PostBackup, events[1].Event)
+	rtest.Equals(t, res.ID, events[1].Snapshot)
+	rtest.Equals(t, repo.Config().ID, events[1].Repository)
+	// errors of the post hooks do not fail the backup
+	rtest.Equals(t, 1, len(res.Warnings))
+
+	// a failed pre hook stops the backup
+	events = nil
+	rtest.OK(t, h.Register(hooks.PreBackup, hooks.Func(func(context.Context, hooks.Info) error {
+		return errors.New("dump failed")
+	})))
+	res, err = rapi.Backup(ctx, repo, []string{src}, rapi.BackupOptions{Hostname: "test", Hooks: h})
+	rtest.Assert(t, err != nil, "backup did not fail")
+	rtest.Assert(t, res.Snapshot == nil, "snapshot saved")
+	rtest.Equals(t, 2, len(events))
+	rtest.Equals(t, hooks.OnError, events[1].Event)
+	rtest.Equals(t, err, events[1].Err)
+}
+
+func TestBackupAnomalies(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.TestRepository(t)
+	src := rtest.TempDir(t)
+	text := []byte(strings.Repeat("some text which compresses well\n", 100))
+	for i := 0; i < 20; i++ {
+		rtest.OK(t, os.WriteFile(filepath.Join(src, fmt.Sprintf("file%02d", i)), text, 0600))
+	}
+
+	opts := rapi.BackupOptions{Hostname: "test", Anomalies: &anomaly.Options{}}
+	var parent *restic.Snapshot
+	backup := func() rapi.BackupResult {
+		opts.Parent = parent
+		res, err := rapi.Backup(ctx, repo, []string{src}, opts)
+		rtest.OK(t, err)
+		rtest.Assert(t, res.Risk != nil, "no anomaly report")
+		parent = res.Snapshot
+		return res
+	}
+
+	res := backup()
+	rtest.Equals(t, 0, res.Risk.History)
+	for i := 0; i < 3; i++ {
+		rtest.OK(t, os.WriteFile(filepath.Join(src, "file00"), append(text, byte(i)), 0600))
+		res = backup()
+		rtest.Assert(t, !res.Risk.Suspicious, "usual backup is suspicious: %+v", res.Risk)
+	}
+	rtest.Equals(t, 3, res.Risk.History)
+
+	// all files are replaced by random data
+	for i := 0; i < 20; i++ {
+		rtest.OK(t, os.WriteFile(filepath.Join(src, fmt.Sprintf("file%02d", i)), rtest.Random(i, 4096), 0600))
+	}
+	res = backup()
+	rtest.Assert(t, res.Risk.Suspicious, "encrypted files not detected: %+v", res.Risk)
+	rtest.Equals(t, 4, res.Risk.History)
+}
@@ -0,0 +1,52 @@
+//go:build !windows
+// +build !windows
+
+package rapi_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/konidev20/rapi"
+	"github.com/konidev20/rapi/internal/errors"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/restic"
+)
+
+func TestBackupSpecialFiles(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.TestRepository(t)
+
+	src := rtest.TempDir(t)
+	rtest.OK(t, os.WriteFile(filepath.Join(src, "file"), []byte("content"), 0600))
+	rtest.OK(t, syscall.Mkfifo(filepath.Join(src, "fifo"), 0600))
+
+	// backup returns the names of the saved items
+	backup := func(policy restic.SpecialFilePolicy) (rapi.BackupResult, map[string]bool, error) {
+		res, err := rapi.Backup(ctx, repo, []string{src}, rapi.BackupOptions{Hostname: "test", SpecialFiles: policy})
+		names := make(map[string]bool)
+		if res.Snapshot != nil {
+			rtest.OK(t, rapi.Ls(ctx, repo, res.ID, rapi.LsOptions{Recursive: true}, func(entry rapi.LsEntry) error {
+				names[entry.Node.Name] = true
+				return nil
+			}))
+		}
+		return res, names, err
+	}
+
+	_, names, err := backup(restic.SpecialFilePolicy{})
+	rtest.OK(t, err)
+	rtest.Assert(t, names["file"] && names["fifo"], "fifo not saved: %v", names)
+
+	_, names, err = backup(restic.SpecialFilePolicy{FIFOs: restic.SpecialFileSkip})
+	rtest.OK(t, err)
+	rtest.Assert(t, names["file"] && !names["fifo"], "skipped fifo saved: %v", names)
+
+	res, _, err := backup(restic.SpecialFilePolicy{FIFOs: restic.SpecialFileError})
+	rtest.Assert(t, errors.Is(err, rapi.ErrPartialBackup), "wrong error %v", err)
+	rtest.Equals(t, filepath.Join(src, "fifo"), res.Partial.Failed[0].Path)
+}
@@ -0,0 +1,314 @@
+package rapi
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+
+	"github.com/konidev20/rapi/crypto"
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/restic"
+	"golang.org/x/sync/errgroup"
+)
+
+// A bundle consists of a plaintext header line in JSON, followed by records.
+// Each record is a 4 byte big endian length, followed by the encrypted record.
+// The first byte of the decrypted record is its type, the remaining bytes are
+// its payload. Blob records contain the plaintext of a blob, the manifest
+// record is always the last record.
+
+const bundleMagic = "rapi-bundle"
+
+// maxBundleRecordSize limits the size of a single record, to detect corrupted
+// length fields before allocating memory for them.
+const maxBundleRecordSize = 512 * 1024 * 1024
+
+const (
+	bundleRecordTree     byte = 't'
+	bundleRecordData     byte = 'd'
+	bundleRecordManifest byte = 'm'
+)
+
+type bundleHeader struct {
+	Magic   string `json:"magic"`
+	Version int    `json:"version"`
+	KDF     string `json:"kdf"`
+	N       int    `json:"N"`
+	R       int    `json:"r"`
+	P       int    `json:"p"`
+	Salt    []byte `json:"salt"`
+}
+
+type bundleManifest struct {
+	SnapshotID restic.ID        `json:"snapshot_id"`
+	Snapshot   *restic.Snapshot `json:"snapshot"`
+	TreeBlobs  int              `json:"tree_blobs"`
+	DataBlobs  int              `json:"data_blobs"`
+}
+
+// Export writes the snapshot id together with all trees and data blobs it
+// references to w, so that it can be transferred to another repository with
+// Import, for example on removable media. The bundle is encrypted with a key
+// derived from password, which is independent of the keys of repo.
+func Export(ctx context.Context, repo restic.Repository, id restic.ID, w io.Writer, password string) error {
+	unlock, err := lockRepository(ctx, repo, false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	sn, err := restic.LoadSnapshot(ctx, repo, id)
+	if err != nil {
+		return err
+	}
+	if sn.Tree == nil {
+		return errors.Errorf("snapshot %v has no tree", id.Str())
+	}
+
+	blobs := restic.NewBlobSet()
+	if err := restic.FindUsedBlobs(ctx, repo, restic.IDs{*sn.Tree}, blobs, nil); err != nil {
+		return err
+	}
+
+	key, header, err := newBundleKey(password)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	if err := json.NewEncoder(bw).Encode(header); err != nil {
+		return err
+	}
+
+	manifest := bundleManifest{SnapshotID: id, Snapshot: sn}
+	var buf []byte
+	for _, h := range blobs.List() {
+		buf, err = repo.LoadBlob(ctx, h.Type, h.ID, buf)
+		if err != nil {
+			return err
+		}
+
+		tpe := bundleRecordData
+		if h.Type == restic.TreeBlob {
+			tpe = bundleRecordTree
+			manifest.TreeBlobs++
+		} else {
+			manifest.DataBlobs++
+		}
+
+		if err := writeBundleRecord(bw, key, tpe, buf); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	if err := writeBundleRecord(bw, key, bundleRecordManifest, data); err != nil {
+		return err
+	}
+
+	debug.Log("exported snapshot %v with %d trees and %d data blobs", id.Str(), manifest.TreeBlobs, manifest.DataBlobs)
+	return bw.Flush()
+}
+
+// Import reads a bundle written by Export from rd and stores the snapshot and
+// all blobs it contains in repo. The records are authenticated with the key
+// derived from password, so a modified bundle is rejected. The bundle does not
+// store blob IDs, they are computed from the blob contents. Blobs which already
+// exist in repo are skipped, so the index of repo must be loaded. The snapshot
+// is only saved once all blobs it references are present in repo. Returned is
+// the ID of the new snapshot. If ctx is cancelled, the index of
+// the blobs which were already stored is saved, the snapshot is not.
+func Import(ctx context.Context, repo restic.Repository, rd io.Reader, password string) (_ restic.ID, err error) {
+	br := bufio.NewReader(rd)
+	line, err := br.ReadBytes('\n')
+	if err != nil {
+		return restic.ID{}, errors.Wrap(err, "reading bundle header")
+	}
+
+	var header bundleHeader
+	if err := json.Unmarshal(line, &header); err != nil || header.Magic != bundleMagic {
+		return restic.ID{}, errors.Fatal("input is not a bundle")
+	}
+	if header.Version != 1 || header.KDF != "scrypt" {
+		return restic.ID{}, errors.Fatalf("unsupported bundle version %d", header.Version)
+	}
+
+	key, err := crypto.KDF(crypto.Params{N: header.N, R: header.R, P: header.P}, header.Salt, password)
+	if err != nil {
+		return restic.ID{}, err
+	}
+
+	// a wrong password is detected by the first record, before the
+	// repository is touched
+	tpe, payload, err := readBundleRecord(br, key)
+	if err == io.EOF {
+		return restic.ID{}, errors.Fatal("bundle is truncated")
+	}
+	if err != nil {
+		return restic.ID{}, err
+	}
+
+	op, err := beginOperation(ctx, repo, "import", false)
+	if err != nil {
+		return restic.ID{}, err
+	}
+	defer func() { op.end(ctx, err != nil) }()
+
+	var manifest bundleManifest
+	var trees, data int
+
+	wg, wgCtx := errgroup.WithContext(ctx)
+	repo.StartPackUploader(wgCtx, wg)
+	wg.Go(func() error {
+		for {
+			switch tpe {
+			case bundleRecordTree, bundleRecordData:
+				t := restic.DataBlob
+				if tpe == bundleRecordTree {
+					t = restic.TreeBlob
+					trees++
+				} else {
+					data++
+				}
+				if _, _, _, err := repo.SaveBlob(wgCtx, t, payload, restic.ID{}, false); err != nil {
+					return err
+				}
+			case bundleRecordManifest:
+				if err := json.Unmarshal(payload, &manifest); err != nil {
+					return errors.Wrap(err, "decoding bundle manifest")
+				}
+				return repo.Flush(wgCtx)
+			default:
+				return errors.Errorf("unknown bundle record type %q", tpe)
+			}
+
+			tpe, payload, err = readBundleRecord(br, key)
+			if err == io.EOF {
+				return errors.Fatal("bundle is truncated")
+			}
+			if err != nil {
+				return err
+			}
+		}
+	})
+	if err := wg.Wait(); err != nil {
+		return restic.ID{}, err
+	}
+
+	if manifest.Snapshot == nil || manifest.Snapshot.Tree == nil {
+		return restic.ID{}, errors.Fatal("bundle manifest contains no snapshot")
+	}
+	if trees != manifest.TreeBlobs || data != manifest.DataBlobs {
+		return restic.ID{}, errors.Fatalf("bundle is incomplete: expected %d trees and %d data blobs, got %d and %d",
+			manifest.TreeBlobs, manifest.DataBlobs, trees, data)
+	}
+
+	sn := manifest.Snapshot
+	if !repo.Index().Has(restic.BlobHandle{ID: *sn.Tree, Type: restic.TreeBlob}) {
+		return restic.ID{}, errors.Fatalf("tree %v of snapshot is missing in bundle", sn.Tree.Str())
+	}
+
+	// records cannot be modified, but they could be dropped or repeated
+	// without changing the counts in the manifest
+	used := restic.NewBlobSet()
+	if err := restic.FindUsedBlobs(ctx, repo, restic.IDs{*sn.Tree}, used, nil); err != nil {
+		return restic.ID{}, errors.Fatalf("bundle is incomplete: %v", err)
+	}
+	for h := range used {
+		if !repo.Index().Has(h) {
+			return restic.ID{}, errors.Fatalf("bundle is incomplete: %v is missing", h)
+		}
+	}
+	if sn.Original == nil {
+		original := manifest.SnapshotID
+		sn.Original = &original
+	}
+
+	id, err := restic.SaveSnapshot(ctx, repo, sn)
+	if err != nil {
+		return restic.ID{}, err
+	}
+
+	debug.Log("imported snapshot %v as %v", manifest.SnapshotID.Str(), id.Str())
+	return id, nil
+}
+
+func newBundleKey(password string) (*crypto.Key, bundleHeader, error) {
+	if repository.Params == nil {
+		p, err := crypto.Calibrate(repository.KDFTimeout, repository.KDFMemory)
+		if err != nil {
+			return nil, bundleHeader{}, errors.Wrap(err, "Calibrate")
+		}
+		repository.Params = &p
+	}
+
+	salt, err := crypto.NewSalt()
+	if err != nil {
+		return nil, bundleHeader{}, err
+	}
+
+	key, err := crypto.KDF(*repository.Params, salt, password)
+	if err != nil {
+		return nil, bundleHeader{}, err
+	}
+
+	return key, bundleHeader{
+		Magic:   bundleMagic,
+		Version: 1,
+		KDF:     "scrypt",
+		N:       repository.Params.N,
+		R:       repository.Params.R,
+		P:       repository.Params.P,
+		Salt:    salt,
+	}, nil
+}
+
+func writeBundleRecord(w io.Writer, key *crypto.Key, tpe byte, payload []byte) error {
+	plaintext := make([]byte, 0, 1+len(payload))
+	plaintext = append(plaintext, tpe)
+	plaintext = append(plaintext, payload...)
+
+	nonce := crypto.NewRandomNonce()
+	record := make([]byte, 4, 4+crypto.CiphertextLength(len(plaintext)))
+	record = append(record, nonce...)
+	record = key.Seal(record, nonce, plaintext, nil)
+	binary.BigEndian.PutUint32(record[:4], uint32(len(record)-4))
+
+	_, err := w.Write(record)
+	return err
+}
+
+func readBundleRecord(rd io.Reader, key *crypto.Key) (byte, []byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(rd, length[:]); err != nil {
+		return 0, nil, err
+	}
+
+	n := binary.BigEndian.Uint32(length[:])
+	if n > maxBundleRecordSize || int(n) < key.NonceSize()+key.Overhead()+1 {
+		return 0, nil, errors.Errorf("invalid bundle record length %d", n)
+	}
+
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(rd, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, errors.Wrap(err, "reading bundle record")
+	}
+
+	nonce, ciphertext := buf[:key.NonceSize()], buf[key.NonceSize():]
+	plaintext, err := key.Open(ciphertext[:0], nonce, ciphertext, nil)
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "wrong password or corrupted bundle")
+	}
+
+	return plaintext[0], plaintext[1:], nil
+}
@@ -0,0 +1,103 @@
+package rapi_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/konidev20/rapi"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/restic"
+)
+
+func TestBundleRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	src := repository.TestRepository(t)
+	dst := repository.TestRepository(t)
+	_, ids := savePack(t, src, 1, 2, 3)
+	sn, err := restic.LoadSnapshot(ctx, src, saveSnapshotOf(t, src, time.Now(), ids))
+	rtest.OK(t, err)
+
+	var buf bytes.Buffer
+	rtest.OK(t, rapi.Export(ctx, src, *sn.ID(), &buf, "secret"))
+
+	_, err = rapi.Import(ctx, dst, bytes.NewReader(buf.Bytes()), "wrong")
+	rtest.Assert(t, err != nil, "bundle imported with wrong password")
+
+	rtest.OK(t, dst.LoadIndex(ctx, nil))
+	id, err := rapi.Import(ctx, dst, bytes.NewReader(buf.Bytes()), "secret")
+	rtest.OK(t, err)
+
+	imported, err := restic.LoadSnapshot(ctx, dst, id)
+	rtest.OK(t, err)
+	rtest.Equals(t, *sn.Tree, *imported.Tree)
+	rtest.Equals(t, sn.Paths, imported.Paths)
+	rtest.Equals(t, sn.Hostname, imported.Hostname)
+
+	rtest.OK(t, src.LoadIndex(ctx, nil))
+	blobs := restic.NewBlobSet()
+	rtest.OK(t, restic.FindUsedBlobs(ctx, src, restic.IDs{*sn.Tree}, blobs, nil))
+
+	rtest.OK(t, dst.LoadIndex(ctx, nil))
+	types := map[restic.BlobType]int{}
+	for bh := range blobs {
+		types[bh.Type]++
+		expected, err := src.LoadBlob(ctx, bh.Type, bh.ID, nil)
+		rtest.OK(t, err)
+		data, err := dst.LoadBlob(ctx, bh.Type, bh.ID, nil)
+		rtest.OK(t, err)
+		rtest.Assert(t, bytes.Equal(expected, data), "blob %v differs", bh)
+	}
+	rtest.Assert(t, types[restic.TreeBlob] > 0 && types[restic.DataBlob] > 0, "unexpected blobs %v", types)
+}
+
+func splitBundle(t *testing.T, bundle []byte) (header []byte, records [][]byte) {
+	n := bytes.IndexByte(bundle, '\n') + 1
+	header, bundle = bundle[:n], bundle[n:]
+	for len(bundle) > 0 {
+		n := 4 + int(binary.BigEndian.Uint32(bundle[:4]))
+		rtest.Assert(t, n <= len(bundle), "record exceeds bundle")
+		records = append(records, bundle[:n])
+		bundle = bundle[n:]
+	}
+	return header, records
+}
+
+func TestBundleRepeatedRecord(t *testing.T) {
+	ctx := context.Background()
+	src := repository.TestRepository(t)
+	_, ids := savePack(t, src, 1, 2, 3)
+	id := saveSnapshotOf(t, src, time.Now(), ids)
+
+	var buf bytes.Buffer
+	rtest.OK(t, rapi.Export(ctx, src, id, &buf, "secret"))
+	header, records := splitBundle(t, buf.Bytes())
+	rtest.Equals(t, 5, len(records))
+
+	// two of the first three records have the same type, so at least one of
+	// the bundles keeps the counts of the manifest
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			if i == j {
+				continue
+			}
+
+			var modified bytes.Buffer
+			modified.Write(header)
+			for k, record := range records {
+				if k == j {
+					record = records[i]
+				}
+				modified.Write(record)
+			}
+
+			dst := repository.TestRepository(t)
+			rtest.OK(t, dst.LoadIndex(ctx, nil))
+			_, err := rapi.Import(ctx, dst, &modified, "secret")
+			rtest.Assert(t, err != nil, "bundle with record %d repeated instead of %d was imported", i, j)
+		}
+	}
+}
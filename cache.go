@@ -0,0 +1,151 @@
+package rapi
+
+import (
+	"context"
+	"io"
+
+	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/restic"
+	"golang.org/x/sync/errgroup"
+)
+
+// WarmCache downloads all files of the given types into the local cache of
+// repo, using as many parallel connections as the backend allows. Index and
+// snapshot files are fetched completely. For restic.PackFile only the packs
+// containing tree blobs are fetched, this requires that the index has already
+// been loaded. Files which are already cached are skipped.
+//
+// Warming the cache before a large restore or mount avoids the latency of
+// fetching the metadata on demand.
+func WarmCache(ctx context.Context, repo *repository.Repository, types []restic.FileType) error {
+	if repo.Cache == nil {
+		return errors.New("repository does not use a cache")
+	}
+
+	for _, t := range types {
+		var err error
+		switch t {
+		case restic.IndexFile, restic.SnapshotFile:
+			err = warmFiles(ctx, repo, t)
+		case restic.PackFile:
+			err = warmTreePacks(ctx, repo)
+		default:
+			err = errors.Errorf("files of type %v cannot be cached", t)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CacheStats describes the size of the local cache of a repository.
+type CacheStats struct {
+	// Bytes is the size of all files in the cache.
+	Bytes int64 `json:"bytes"`
+	// MaxBytes is the quota of the cache, zero means that the size is not
+	// limited. It is set by RepositoryOptions.CacheMaxSize or SetCacheMaxSize.
+	MaxBytes int64 `json:"max_bytes"`
+}
+
+// CacheUsage returns the size and the quota of the local cache of repo.
+func CacheUsage(repo *repository.Repository) (CacheStats, error) {
+	if repo.Cache == nil {
+		return CacheStats{}, errors.New("repository does not use a cache")
+	}
+
+	usage, err := repo.Cache.Usage()
+	if err != nil {
+		return CacheStats{}, err
+	}
+	return CacheStats{Bytes: usage, MaxBytes: repo.Cache.MaxSize()}, nil
+}
+
+// SetCacheMaxSize changes the quota of the local cache of repo to max bytes,
+// zero removes the quota. When the quota is exceeded, the least recently used
+// pack and index files are removed. A cache which is already larger than max
+// is trimmed immediately.
+func SetCacheMaxSize(repo *repository.Repository, max int64) error {
+	if repo.Cache == nil {
+		return errors.New("repository does not use a cache")
+	}
+	return repo.Cache.SetMaxSize(max)
+}
+
+// TrimCache removes the least recently used pack and index files from the
+// local cache of repo until at most max bytes are in use, independent of the
+// quota. It returns the number of files removed.
+func TrimCache(repo *repository.Repository, max int64) (int, error) {
+	if repo.Cache == nil {
+		return 0, errors.New("repository does not use a cache")
+	}
+	return repo.Cache.Trim(max)
+}
+
+// warmFiles fetches all files of type t into the cache.
+func warmFiles(ctx context.Context, repo *repository.Repository, t restic.FileType) error {
+	return restic.ParallelList(ctx, repo, t, repo.Connections(), func(ctx context.Context, id restic.ID, _ int64) error {
+		return warmFile(ctx, repo, backend.Handle{Type: t, Name: id.String()})
+	})
+}
+
+// warmTreePacks fetches all pack files containing tree blobs into the cache.
+func warmTreePacks(ctx context.Context, repo *repository.Repository) error {
+	packs := restic.NewIDSet()
+	repo.Index().Each(ctx, func(pb restic.PackedBlob) {
+		if pb.Type == restic.TreeBlob {
+			packs.Insert(pb.PackID)
+		}
+	})
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	debug.Log("warming cache with %d tree packs", len(packs))
+
+	wg, ctx := errgroup.WithContext(ctx)
+	ch := make(chan restic.ID)
+
+	wg.Go(func() error {
+		defer close(ch)
+		for id := range packs {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case ch <- id:
+			}
+		}
+		return nil
+	})
+
+	for i := uint(0); i < repo.Connections(); i++ {
+		wg.Go(func() error {
+			for id := range ch {
+				h := backend.Handle{Type: restic.PackFile, Name: id.String(), IsMetadata: true}
+				if err := warmFile(ctx, repo, h); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	return wg.Wait()
+}
+
+// warmFile loads the file h through the caching backend of repo, which stores
+// it in the cache as a side effect.
+func warmFile(ctx context.Context, repo *repository.Repository, h backend.Handle) error {
+	if repo.Cache.Has(h) {
+		return nil
+	}
+
+	return repo.Backend().Load(ctx, h, 0, 0, func(rd io.Reader) error {
+		_, err := io.Copy(io.Discard, rd)
+		return err
+	})
+}
@@ -0,0 +1,77 @@
+package rapi_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/konidev20/rapi"
+	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/internal/cache"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/restic"
+)
+
+func TestWarmCache(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.TestRepository(t).(*repository.Repository)
+	restic.TestCreateSnapshot(t, repo, time.Now(), 2)
+
+	c, err := cache.New(repo.Config().ID, rtest.TempDir(t))
+	rtest.OK(t, err)
+	repo.UseCache(c)
+	rtest.OK(t, repo.LoadIndex(ctx, nil))
+
+	types := []restic.FileType{restic.IndexFile, restic.SnapshotFile, restic.PackFile}
+	rtest.OK(t, rapi.WarmCache(ctx, repo, types))
+
+	for _, tpe := range types[:2] {
+		n := 0
+		rtest.OK(t, repo.List(ctx, tpe, func(id restic.ID, _ int64) error {
+			n++
+			h := backend.Handle{Type: tpe, Name: id.String()}
+			rtest.Assert(t, c.Has(h), "%v not cached", h)
+			return nil
+		}))
+		rtest.Assert(t, n > 0, "no files of type %v", tpe)
+	}
+
+	repo.Index().Each(ctx, func(pb restic.PackedBlob) {
+		h := backend.Handle{Type: restic.PackFile, Name: pb.PackID.String()}
+		rtest.Assert(t, c.Has(h) == (pb.Type == restic.TreeBlob), "unexpected cache state for pack %v of %v", pb.PackID.Str(), pb.BlobHandle)
+	})
+}
+
+func TestCacheQuota(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.TestRepository(t).(*repository.Repository)
+	restic.TestCreateSnapshot(t, repo, time.Now(), 2)
+
+	_, err := rapi.CacheUsage(repo)
+	rtest.Assert(t, err != nil, "usage of a repository without cache")
+
+	c, err := cache.New(repo.Config().ID, rtest.TempDir(t))
+	rtest.OK(t, err)
+	repo.UseCache(c)
+	rtest.OK(t, repo.LoadIndex(ctx, nil))
+	rtest.OK(t, rapi.WarmCache(ctx, repo, []restic.FileType{restic.IndexFile, restic.SnapshotFile, restic.PackFile}))
+
+	stats, err := rapi.CacheUsage(repo)
+	rtest.OK(t, err)
+	rtest.Assert(t, stats.Bytes > 0, "cache is empty")
+	rtest.Equals(t, int64(0), stats.MaxBytes)
+
+	// snapshot files are never evicted
+	removed, err := rapi.TrimCache(repo, 0)
+	rtest.OK(t, err)
+	rtest.Assert(t, removed > 0, "no files removed")
+	trimmed, err := rapi.CacheUsage(repo)
+	rtest.OK(t, err)
+	rtest.Assert(t, trimmed.Bytes < stats.Bytes, "cache was not trimmed")
+
+	rtest.OK(t, rapi.SetCacheMaxSize(repo, 1024*1024))
+	stats, err = rapi.CacheUsage(repo)
+	rtest.OK(t, err)
+	rtest.Equals(t, int64(1024*1024), stats.MaxBytes)
+}
@@ -0,0 +1,146 @@
+package rapi
+
+import (
+	"context"
+
+	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/internal/checker"
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/internal/index"
+	"github.com/konidev20/rapi/restic"
+	"github.com/konidev20/rapi/ui/progress"
+)
+
+// CheckOptions configure which checks are run by Check.
+type CheckOptions struct {
+	// PackHeaders downloads the header of every pack and compares it to
+	// the index. This detects a drift between the index and the packs
+	// without downloading the pack contents.
+	PackHeaders bool
+
+	// RepairIndex replaces the index entries of packs whose header differs
+	// from the index with the entries from the header. It requires
+	// PackHeaders and locks the repository exclusively.
+	RepairIndex bool
+
+	// Progress receives the number of processed packs while checking the
+	// pack headers. It is not stopped by Check.
+	Progress *progress.Counter
+}
+
+// CheckResult lists the problems found by Check. Hints are not errors but
+// point out inefficiencies, for example mixed packs.
+type CheckResult struct {
+	Hints  []error
+	Errors []error
+
+	// RepairedPacks are the packs whose index entries were replaced.
+	RepairedPacks restic.IDs
+}
+
+// Check verifies the structure of the repository like `restic check` without
+// reading the data of the packs. The returned error is only set if the check
+// could not be run, the problems found are reported in the result.
+func Check(ctx context.Context, repo restic.Repository, opts CheckOptions) (CheckResult, error) {
+	var result CheckResult
+	if opts.RepairIndex && !opts.PackHeaders {
+		return result, errors.Fatal("RepairIndex requires PackHeaders")
+	}
+
+	unlock, err := lockRepository(ctx, repo, opts.RepairIndex)
+	if err != nil {
+		return result, err
+	}
+	defer unlock()
+
+	chkr := checker.New(repo, false)
+	hints, errs := chkr.LoadIndex(ctx, nil)
+	result.Hints = hints
+	result.Errors = errs
+	if len(errs) > 0 {
+		return result, nil
+	}
+
+	collect := func(run func(chan<- error)) []error {
+		var errs []error
+		errChan := make(chan error)
+		go run(errChan)
+		for err := range errChan {
+			errs = append(errs, err)
+		}
+		return errs
+	}
+
+	result.Errors = append(result.Errors, collect(func(ch chan<- error) { chkr.Packs(ctx, ch) })...)
+	if err := chkr.LoadSnapshots(ctx); err != nil {
+		return result, err
+	}
+	result.Errors = append(result.Errors, collect(func(ch chan<- error) { chkr.Structure(ctx, nil, ch) })...)
+	// the checks report blobs as missing if the index could not be read
+	if err := restic.IndexErr(repo.Index()); err != nil {
+		return result, err
+	}
+
+	if !opts.PackHeaders {
+		return result, ctx.Err()
+	}
+
+	mismatched := make(map[restic.ID][]restic.Blob)
+	for _, err := range collect(func(ch chan<- error) { chkr.PackHeaders(ctx, opts.Progress, ch) }) {
+		var e *checker.PackHeaderError
+		if opts.RepairIndex && errors.As(err, &e) {
+			mismatched[e.ID] = e.Blobs
+		}
+		result.Errors = append(result.Errors, err)
+	}
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	if err := restic.IndexErr(repo.Index()); err != nil {
+		return result, err
+	}
+
+	if len(mismatched) > 0 {
+		if err := repairIndexFromHeaders(ctx, repo, mismatched); err != nil {
+			return result, err
+		}
+		for id := range mismatched {
+			result.RepairedPacks = append(result.RepairedPacks, id)
+		}
+	}
+
+	return result, nil
+}
+
+// repairIndexFromHeaders replaces the index entries of the given packs with
+// the blobs from their headers. Only the affected index files are rewritten.
+func repairIndexFromHeaders(ctx context.Context, repo restic.Repository, packs map[restic.ID][]restic.Blob) error {
+	ids := restic.NewIDSet()
+	for id := range packs {
+		ids.Insert(id)
+	}
+
+	obsolete, err := index.RewriteAffected(ctx, repo, ids, nil)
+	if err != nil {
+		return err
+	}
+
+	idx := index.NewIndex()
+	for id, blobs := range packs {
+		idx.StorePack(id, blobs)
+	}
+	idx.Finalize()
+	if _, err := index.SaveIndex(ctx, repo, idx); err != nil {
+		return err
+	}
+
+	for id := range obsolete {
+		h := backend.Handle{Type: restic.IndexFile, Name: id.String()}
+		if err := repo.Backend().Remove(ctx, h); err != nil {
+			return err
+		}
+	}
+	debug.Log("repaired the index entries of %d packs", len(packs))
+	return nil
+}
@@ -0,0 +1,141 @@
+package rapi
+
+import (
+	"context"
+
+	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/internal/index"
+	"github.com/konidev20/rapi/pack"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/restic"
+	"github.com/konidev20/rapi/ui/progress"
+)
+
+// CoalesceOptions configure which pack files CoalescePacks combines.
+type CoalesceOptions struct {
+	// SmallPackSize is the size in bytes below which a pack file is
+	// considered small. If it is zero, an eighth of the pack size of the
+	// repository is used.
+	SmallPackSize uint64
+
+	// MinPacks is the minimal number of small pack files required before
+	// they are combined, it defaults to 2.
+	MinPacks int
+
+	// DryRun only reports which pack files would be combined.
+	DryRun bool
+
+	// Progress receives the number of repacked pack files. It is not
+	// stopped by CoalescePacks.
+	Progress *progress.Counter
+}
+
+// CoalesceResult describes the pack files combined by CoalescePacks.
+type CoalesceResult struct {
+	// Packs are the small pack files which were (or would be) replaced.
+	Packs restic.IDSet `json:"packs"`
+	// Size is the total size of these pack files.
+	Size uint64 `json:"size"`
+}
+
+// CoalescePacks repacks the contents of small pack files, for example those
+// left behind by interrupted backups, into pack files of the regular size,
+// and removes the small ones. This reduces the number of files stored in the
+// backend, which is often billed per object. No data is removed from the
+// repository. The repository is locked exclusively unless opts.DryRun is set.
+// Only the index files which reference the small pack files are rewritten,
+// and the index of repo is loaded again afterwards.
+func CoalescePacks(ctx context.Context, repo restic.Repository, opts CoalesceOptions) (_ CoalesceResult, err error) {
+	if opts.SmallPackSize == 0 {
+		opts.SmallPackSize = uint64(repo.PackSize()) / 8
+	}
+	if opts.MinPacks < 2 {
+		opts.MinPacks = 2
+	}
+
+	if !opts.DryRun {
+		unlock, err := lockRepository(ctx, repo, true)
+		if err != nil {
+			return CoalesceResult{}, err
+		}
+		defer unlock()
+	}
+
+	if err := repo.LoadIndex(ctx, nil); err != nil {
+		return CoalesceResult{}, err
+	}
+
+	result := CoalesceResult{Packs: restic.NewIDSet()}
+	for id, size := range pack.Size(ctx, repo.Index(), false) {
+		if uint64(size) < opts.SmallPackSize {
+			result.Packs.Insert(id)
+			result.Size += uint64(size)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return CoalesceResult{}, err
+	}
+
+	debug.Log("found %d small packs (%d bytes)", len(result.Packs), result.Size)
+	if len(result.Packs) < opts.MinPacks {
+		return CoalesceResult{Packs: restic.NewIDSet()}, nil
+	}
+	if opts.DryRun {
+		return result, nil
+	}
+
+	keepBlobs := restic.NewBlobSet()
+	repo.Index().Each(ctx, func(pb restic.PackedBlob) {
+		if result.Packs.Has(pb.PackID) {
+			keepBlobs.Insert(pb.BlobHandle)
+		}
+	})
+	if err := ctx.Err(); err != nil {
+		return CoalesceResult{}, err
+	}
+	if err := restic.IndexErr(repo.Index()); err != nil {
+		return CoalesceResult{}, err
+	}
+
+	opts.Progress.SetMax(uint64(len(result.Packs)))
+	_, err = repository.Repack(ctx, repo, repo, result.Packs, keepBlobs, opts.Progress)
+	if err != nil {
+		return CoalesceResult{}, err
+	}
+	if len(keepBlobs) != 0 {
+		return CoalesceResult{}, errors.Fatalf("%d blobs could not be repacked", len(keepBlobs))
+	}
+
+	// the index of repo still references the removed packs, reload it even
+	// if removing fails
+	defer func() {
+		if lerr := repo.LoadIndex(ctx, nil); lerr != nil && err == nil {
+			err = lerr
+		}
+	}()
+
+	// only the index files which reference the small packs are rewritten,
+	// the index of the repacked blobs was already saved by Repack
+	obsoleteIndexes, err := index.RewriteAffected(ctx, repo, result.Packs, nil)
+	if err != nil {
+		return result, err
+	}
+	for id := range obsoleteIndexes {
+		h := backend.Handle{Type: restic.IndexFile, Name: id.String()}
+		if err := repo.Backend().Remove(ctx, h); err != nil {
+			return result, err
+		}
+	}
+
+	for id := range result.Packs {
+		h := backend.Handle{Type: restic.PackFile, Name: id.String()}
+		if err := repo.Backend().Remove(ctx, h); err != nil {
+			return result, err
+		}
+		debug.Log("removed pack %v", id.Str())
+	}
+
+	return result, nil
+}
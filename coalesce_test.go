@@ -0,0 +1,62 @@
+package rapi_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/konidev20/rapi"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/restic"
+)
+
+func TestCoalescePacks(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.TestRepository(t)
+
+	var ids restic.IDs
+	small := restic.NewIDSet()
+	for _, seeds := range [][]int{{1, 2}, {3}, {4, 5}} {
+		id, blobs := savePack(t, repo, seeds...)
+		small.Insert(id)
+		ids = append(ids, blobs...)
+	}
+
+	// the index file of the large pack is not rewritten
+	before := listIndexes(t, repo)
+	large, blobs := savePack(t, repo, 6, 7, 8, 9, 10)
+	ids = append(ids, blobs...)
+	largeIndexes := listIndexes(t, repo).Sub(before)
+	rtest.Equals(t, 1, len(largeIndexes))
+
+	opts := rapi.CoalesceOptions{SmallPackSize: 30000, DryRun: true}
+	res, err := rapi.CoalescePacks(ctx, repo, opts)
+	rtest.OK(t, err)
+	rtest.Equals(t, small, res.Packs)
+	rtest.Equals(t, 4, len(listIndexes(t, repo)))
+
+	opts.DryRun = false
+	res, err = rapi.CoalescePacks(ctx, repo, opts)
+	rtest.OK(t, err)
+	rtest.Equals(t, small, res.Packs)
+
+	packs := listPacks(t, repo)
+	rtest.Assert(t, packs.Has(large), "large pack %v was removed", large.Str())
+	for id := range small {
+		rtest.Assert(t, !packs.Has(id), "small pack %v was not removed", id.Str())
+	}
+	rtest.Equals(t, 2, len(packs))
+	rtest.Assert(t, listIndexes(t, repo).Has(largeIndexes.List()[0]), "unaffected index file was rewritten")
+
+	// the index of repo was loaded again
+	for _, id := range ids {
+		for _, pb := range repo.Index().Lookup(restic.BlobHandle{Type: restic.DataBlob, ID: id}) {
+			rtest.Assert(t, !small.Has(pb.PackID), "index still references removed pack %v", pb.PackID.Str())
+		}
+	}
+	checkBlobsLoadable(t, repo, ids)
+
+	check, err := rapi.Check(ctx, repo, rapi.CheckOptions{})
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, len(check.Errors))
+}
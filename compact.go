@@ -0,0 +1,177 @@
+package rapi
+
+import (
+	"context"
+
+	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/index"
+	"github.com/konidev20/rapi/restic"
+)
+
+// CompactIndexOptions configure which index files CompactIndex merges.
+type CompactIndexOptions struct {
+	// SmallIndexBlobs is the number of blobs below which an index file is
+	// considered small. If it is zero, 10000 is used.
+	SmallIndexBlobs int
+
+	// MinFiles is the minimal number of small index files required before
+	// they are merged, it defaults to 2.
+	MinFiles int
+
+	// DryRun only reports which index files would be merged and removed.
+	DryRun bool
+}
+
+// CompactIndexResult describes the index files handled by CompactIndex.
+type CompactIndexResult struct {
+	// Merged are the small index files which were (or would be) replaced.
+	Merged restic.IDSet `json:"merged"`
+	// Obsolete are index files which are superseded by another index file
+	// but were not removed, for example by an interrupted prune.
+	Obsolete restic.IDSet `json:"obsolete"`
+	// Created are the new index files.
+	Created restic.IDs `json:"created"`
+}
+
+// CompactIndex merges small index files, as written by many short backups,
+// into as few index files as possible and removes index files which are
+// superseded by others. The index content is not changed, but listing and
+// loading the index needs fewer requests afterwards. The repository is locked
+// exclusively unless opts.DryRun is set.
+func CompactIndex(ctx context.Context, repo restic.Repository, opts CompactIndexOptions) (CompactIndexResult, error) {
+	if opts.SmallIndexBlobs == 0 {
+		opts.SmallIndexBlobs = 10000
+	}
+	if opts.MinFiles < 2 {
+		opts.MinFiles = 2
+	}
+
+	if !opts.DryRun {
+		unlock, err := lockRepository(ctx, repo, true)
+		if err != nil {
+			return CompactIndexResult{}, err
+		}
+		defer unlock()
+	}
+
+	result := CompactIndexResult{
+		Merged:   restic.NewIDSet(),
+		Obsolete: restic.NewIDSet(),
+	}
+	present := restic.NewIDSet()
+	superseded := restic.NewIDSet()
+	small := make(map[restic.ID]*index.Index)
+	err := index.ForAllIndexes(ctx, repo, repo, func(id restic.ID, idx *index.Index, _ bool, err error) error {
+		if err != nil {
+			return err
+		}
+		present.Insert(id)
+		for _, s := range idx.Supersedes() {
+			superseded.Insert(s)
+		}
+
+		blobs := 0
+		idx.Each(ctx, func(restic.PackedBlob) { blobs++ })
+		if blobs < opts.SmallIndexBlobs {
+			small[id] = idx
+		}
+		return nil
+	})
+	if err != nil {
+		return CompactIndexResult{}, err
+	}
+
+	for id := range superseded {
+		if present.Has(id) {
+			result.Obsolete.Insert(id)
+			delete(small, id)
+		}
+	}
+	if len(small) >= opts.MinFiles {
+		for id := range small {
+			result.Merged.Insert(id)
+		}
+	}
+
+	debug.Log("merging %d small index files, removing %d obsolete ones", len(result.Merged), len(result.Obsolete))
+	if opts.DryRun {
+		return result, nil
+	}
+
+	if len(result.Merged) > 0 {
+		created, err := mergeIndexes(ctx, repo, small, result.Merged)
+		if err != nil {
+			return result, err
+		}
+		result.Created = created
+	}
+
+	for _, ids := range []restic.IDSet{result.Merged, result.Obsolete} {
+		for id := range ids {
+			h := backend.Handle{Type: restic.IndexFile, Name: id.String()}
+			if err := repo.Backend().Remove(ctx, h); err != nil {
+				return result, err
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// mergeIndexes saves the contents of indexes in new index files, the last
+// one supersedes the index files in merged.
+func mergeIndexes(ctx context.Context, repo restic.Repository, indexes map[restic.ID]*index.Index, merged restic.IDSet) (restic.IDs, error) {
+	var created restic.IDs
+	save := func(idx *index.Index) error {
+		idx.Finalize()
+		id, err := index.SaveIndex(ctx, repo, idx)
+		created = append(created, id)
+		return err
+	}
+
+	// a pack can be listed by several index files, each with a part of its
+	// blobs, so the blob lists of all entries are merged
+	type packBlob struct {
+		restic.BlobHandle
+		pack   restic.ID
+		offset uint
+	}
+	packs := make(map[restic.ID][]restic.Blob)
+	seen := make(map[packBlob]struct{})
+	for _, idx := range indexes {
+		for pbs := range idx.EachByPack(ctx, restic.NewIDSet()) {
+			for _, blob := range pbs.Blobs {
+				k := packBlob{blob.BlobHandle, pbs.PackID, blob.Offset}
+				if _, ok := seen[k]; ok {
+					continue
+				}
+				seen[k] = struct{}{}
+				packs[pbs.PackID] = append(packs[pbs.PackID], blob)
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	compress := repo.Config().Version >= 2
+	newIndex := index.NewIndex()
+	for id, blobs := range packs {
+		newIndex.StorePack(id, blobs)
+		if index.IndexFull(newIndex, compress) {
+			if err := save(newIndex); err != nil {
+				return nil, err
+			}
+			newIndex = index.NewIndex()
+		}
+	}
+
+	if err := newIndex.AddToSupersedes(merged.List()...); err != nil {
+		return nil, err
+	}
+	if err := save(newIndex); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
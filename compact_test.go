@@ -0,0 +1,132 @@
+package rapi_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/konidev20/rapi"
+	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/internal/index"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/restic"
+)
+
+func listIndexes(t *testing.T, repo restic.Repository) restic.IDSet {
+	ids := restic.NewIDSet()
+	rtest.OK(t, repo.List(context.TODO(), restic.IndexFile, func(id restic.ID, _ int64) error {
+		ids.Insert(id)
+		return nil
+	}))
+	return ids
+}
+
+func TestCompactIndex(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.TestRepository(t)
+
+	var blobs restic.IDs
+	var packs restic.IDs
+	for i := 0; i < 3; i++ {
+		packID, ids := savePack(t, repo, 2*i, 2*i+1)
+		packs = append(packs, packID)
+		blobs = append(blobs, ids...)
+	}
+	oldIndexes := listIndexes(t, repo)
+	rtest.Equals(t, 3, len(oldIndexes))
+
+	// an index file which lists the first pack again and supersedes the
+	// index file written by savePack, as left behind by an interrupted prune
+	var first restic.ID
+	var packBlobs []restic.Blob
+	for id := range oldIndexes {
+		buf, err := repo.LoadUnpacked(ctx, restic.IndexFile, id)
+		rtest.OK(t, err)
+		idx, _, err := index.DecodeIndex(buf, id)
+		rtest.OK(t, err)
+		if idx.Packs().Has(packs[0]) {
+			first = id
+			idx.Each(ctx, func(pb restic.PackedBlob) {
+				packBlobs = append(packBlobs, pb.Blob)
+			})
+		}
+	}
+	rtest.Equals(t, 2, len(packBlobs))
+
+	idx := index.NewIndex()
+	idx.StorePack(packs[0], packBlobs)
+	rtest.OK(t, idx.AddToSupersedes(first))
+	idx.Finalize()
+	replacement, err := index.SaveIndex(ctx, repo, idx)
+	rtest.OK(t, err)
+
+	before := listIndexes(t, repo)
+	res, err := rapi.CompactIndex(ctx, repo, rapi.CompactIndexOptions{DryRun: true})
+	rtest.OK(t, err)
+	rtest.Equals(t, restic.NewIDSet(first), res.Obsolete)
+	rtest.Equals(t, 3, len(res.Merged))
+	rtest.Assert(t, res.Merged.Has(replacement), "replacement index was not merged")
+	rtest.Equals(t, 0, len(res.Created))
+	rtest.Equals(t, before, listIndexes(t, repo))
+
+	res, err = rapi.CompactIndex(ctx, repo, rapi.CompactIndexOptions{})
+	rtest.OK(t, err)
+	rtest.Equals(t, restic.NewIDSet(first), res.Obsolete)
+	rtest.Equals(t, 3, len(res.Merged))
+	rtest.Equals(t, 1, len(res.Created))
+	rtest.Equals(t, restic.NewIDSet(res.Created...), listIndexes(t, repo))
+
+	checkBlobsLoadable(t, repo, blobs)
+	for _, id := range blobs {
+		pbs := repo.Index().Lookup(restic.BlobHandle{Type: restic.DataBlob, ID: id})
+		rtest.Equals(t, 1, len(pbs))
+	}
+
+	check, err := rapi.Check(ctx, repo, rapi.CheckOptions{})
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, len(check.Errors))
+
+	// a single index file is not merged again
+	res, err = rapi.CompactIndex(ctx, repo, rapi.CompactIndexOptions{})
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, len(res.Merged))
+	rtest.Equals(t, 0, len(res.Obsolete))
+}
+
+func TestCompactIndexSplitPack(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.TestRepository(t)
+
+	packID, blobs := savePack(t, repo, 1, 2)
+	oldIndexes := listIndexes(t, repo)
+	rtest.Equals(t, 1, len(oldIndexes))
+
+	// two index files which each list one blob of the same pack
+	for id := range oldIndexes {
+		buf, err := repo.LoadUnpacked(ctx, restic.IndexFile, id)
+		rtest.OK(t, err)
+		idx, _, err := index.DecodeIndex(buf, id)
+		rtest.OK(t, err)
+		idx.Each(ctx, func(pb restic.PackedBlob) {
+			part := index.NewIndex()
+			part.StorePack(packID, []restic.Blob{pb.Blob})
+			part.Finalize()
+			_, err := index.SaveIndex(ctx, repo, part)
+			rtest.OK(t, err)
+		})
+		rtest.OK(t, repo.Backend().Remove(ctx, backend.Handle{Type: restic.IndexFile, Name: id.String()}))
+	}
+
+	res, err := rapi.CompactIndex(ctx, repo, rapi.CompactIndexOptions{})
+	rtest.OK(t, err)
+	rtest.Equals(t, 2, len(res.Merged))
+	rtest.Equals(t, 1, len(res.Created))
+
+	rtest.OK(t, repo.LoadIndex(ctx, nil))
+	for _, id := range blobs {
+		pbs := repo.Index().Lookup(restic.BlobHandle{Type: restic.DataBlob, ID: id})
+		rtest.Equals(t, 1, len(pbs))
+		rtest.Equals(t, packID, pbs[0].PackID)
+	}
+	checkBlobsLoadable(t, repo, blobs)
+}
@@ -0,0 +1,418 @@
+// Package config stores named repository definitions in a file in the
+// configuration directory of the user, so that applications embedding the
+// library can share them. Passwords are never stored, the file can be
+// encrypted with a passphrase instead.
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/konidev20/rapi"
+	"github.com/konidev20/rapi/crypto"
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/internal/options"
+)
+
+// FileName is the name of the file in the configuration directory which
+// contains the repository definitions.
+const FileName = "repositories.json"
+
+// formatVersion is the version of the file format.
+const formatVersion = 1
+
+var (
+	// ErrNotFound is returned for repository definitions which do not exist.
+	ErrNotFound = errors.New("repository definition not found")
+
+	// ErrExists is returned by Add if a definition with the name exists.
+	ErrExists = errors.New("repository definition already exists")
+
+	// ErrWrongPassphrase is returned by Open if the file cannot be decrypted
+	// with the passphrase.
+	ErrWrongPassphrase = errors.New("wrong passphrase for repository definitions")
+
+	// ErrEncrypted is returned by Open if the file is encrypted, but no
+	// passphrase was given.
+	ErrEncrypted = errors.New("repository definitions are encrypted, a passphrase is required")
+)
+
+// KDFParams are the parameters of the key derivation for new encrypted
+// files, the parameters of existing files are stored in them.
+var KDFParams = crypto.DefaultKDFParams
+
+// Repository is a saved repository definition.
+type Repository struct {
+	// Name identifies the definition, it consists of letters, digits, "-",
+	// "_" and ".".
+	Name string `json:"name"`
+
+	// Repo is the location of the repository, see RepositoryOptions.Repo.
+	Repo string `json:"repository"`
+
+	// Options are the extended options, e.g. "s3.region=eu-west-1".
+	Options []string `json:"options,omitempty"`
+
+	// KeyHint is the ID of the key which is tried first.
+	KeyHint string `json:"key_hint,omitempty"`
+
+	Description string `json:"description,omitempty"`
+
+	Created  time.Time `json:"created"`
+	Modified time.Time `json:"modified"`
+}
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+func (r Repository) validate() error {
+	if !validName.MatchString(r.Name) {
+		return errors.Fatalf("invalid name %q for a repository definition", r.Name)
+	}
+	if r.Repo == "" {
+		return errors.Fatalf("no repository location in definition %q", r.Name)
+	}
+	if _, err := options.Parse(r.Options); err != nil {
+		return errors.Fatalf("invalid options in definition %q: %v", r.Name, err)
+	}
+	return nil
+}
+
+// Apply returns opts configured for the repository. The location, the key
+// hint and the extended options of r are only used if they are not set in
+// opts already.
+func (r Repository) Apply(opts rapi.RepositoryOptions) (rapi.RepositoryOptions, error) {
+	if opts.Repo == "" && opts.RepositoryFile == "" {
+		opts.Repo = r.Repo
+	}
+	if opts.KeyHint == "" {
+		opts.KeyHint = r.KeyHint
+	}
+
+	extended, err := options.Parse(r.Options)
+	if err != nil {
+		return opts, errors.Fatalf("invalid options in definition %q: %v", r.Name, err)
+	}
+	merged := make(options.Options, len(opts.Extended)+len(extended))
+	for k, v := range extended {
+		merged[k] = v
+	}
+	for k, v := range opts.Extended {
+		merged[k] = v
+	}
+	opts.Extended = merged
+	return opts, nil
+}
+
+// EnvDir returns $RESTIC_CONFIG_DIR.
+func EnvDir() string {
+	return os.Getenv("RESTIC_CONFIG_DIR")
+}
+
+// DefaultDir returns $RESTIC_CONFIG_DIR, or the default configuration
+// directory for the current OS if that variable is not set.
+func DefaultDir() (string, error) {
+	dir := EnvDir()
+	if dir != "" {
+		return dir, nil
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", errors.Errorf("unable to locate config directory: %v", err)
+	}
+	return filepath.Join(dir, "restic"), nil
+}
+
+// file is the format of the file.
+type file struct {
+	Version int `json:"version"`
+
+	// Repositories is set if the file is not encrypted.
+	Repositories []Repository `json:"repositories,omitempty"`
+
+	// KDF, Salt and Data are set if the file is encrypted, Data contains
+	// the encrypted list of repositories.
+	KDF  *crypto.Params `json:"kdf,omitempty"`
+	Salt []byte         `json:"salt,omitempty"`
+	Data []byte         `json:"data,omitempty"`
+}
+
+// Store manages the repository definitions in a directory. Each change is
+// written to the file immediately, after reading it again, so that several
+// programs can use the same directory. A Store is safe for concurrent use.
+type Store struct {
+	path       string
+	passphrase string
+
+	mu sync.Mutex
+	// key and salt are derived from the passphrase once they are needed
+	key    *crypto.Key
+	salt   []byte
+	params crypto.Params
+}
+
+// Open returns the store of the repository definitions in dir, which is
+// created when the first definition is saved. If passphrase is not empty,
+// the file is encrypted with it, an unencrypted file is encrypted on the
+// next change.
+func Open(dir string, passphrase string) (*Store, error) {
+	s := &Store{
+		path:       filepath.Join(dir, FileName),
+		passphrase: passphrase,
+	}
+
+	// check that the file can be read
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Path returns the path of the file.
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Encrypted returns true if the definitions are stored encrypted.
+func (s *Store) Encrypted() bool {
+	return s.passphrase != ""
+}
+
+// load reads the definitions from the file, s.mu must be held.
+func (s *Store) load() ([]Repository, error) {
+	buf, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var f file
+	if err := json.Unmarshal(buf, &f); err != nil {
+		return nil, errors.Wrapf(err, "invalid file %v", s.path)
+	}
+	if f.Version != formatVersion {
+		return nil, errors.Errorf("unsupported version %d of file %v", f.Version, s.path)
+	}
+
+	if f.Data == nil {
+		return f.Repositories, nil
+	}
+	if s.passphrase == "" {
+		return nil, ErrEncrypted
+	}
+	if f.KDF == nil {
+		return nil, errors.Errorf("invalid file %v: no KDF parameters", s.path)
+	}
+
+	key := s.key
+	if key == nil || *f.KDF != s.params || string(f.Salt) != string(s.salt) {
+		key, err = crypto.KDF(*f.KDF, f.Salt, s.passphrase)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(f.Data) < key.NonceSize() {
+		return nil, errors.Errorf("invalid file %v: data too short", s.path)
+	}
+	nonce, ciphertext := f.Data[:key.NonceSize()], f.Data[key.NonceSize():]
+	plaintext, err := key.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	s.key, s.salt, s.params = key, f.Salt, *f.KDF
+
+	var repos []Repository
+	if err := json.Unmarshal(plaintext, &repos); err != nil {
+		return nil, errors.Wrapf(err, "invalid file %v", s.path)
+	}
+	return repos, nil
+}
+
+// save writes the definitions to the file, s.mu must be held.
+func (s *Store) save(repos []Repository) error {
+	sort.Slice(repos, func(i, j int) bool { return repos[i].Name < repos[j].Name })
+	f := file{Version: formatVersion}
+
+	if s.passphrase == "" {
+		f.Repositories = repos
+	} else {
+		if s.key == nil {
+			salt, err := crypto.NewSalt()
+			if err != nil {
+				return err
+			}
+			key, err := crypto.KDF(KDFParams, salt, s.passphrase)
+			if err != nil {
+				return err
+			}
+			s.key, s.salt, s.params = key, salt, KDFParams
+		}
+
+		plaintext, err := json.Marshal(repos)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		nonce := crypto.NewRandomNonce()
+		f.KDF = &s.params
+		f.Salt = s.salt
+		f.Data = s.key.Seal(nonce, nonce, plaintext, nil)
+	}
+
+	buf, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return errors.WithStack(err)
+	}
+	tmp, err := os.CreateTemp(dir, FileName+".tmp-")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = tmp.Write(append(buf, '\n'))
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// update reads the definitions, calls fn with them and writes the result.
+func (s *Store) update(fn func([]Repository) ([]Repository, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	repos, err := s.load()
+	if err != nil {
+		return err
+	}
+	repos, err = fn(repos)
+	if err != nil {
+		return err
+	}
+	return s.save(repos)
+}
+
+func find(repos []Repository, name string) int {
+	for i, r := range repos {
+		if r.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// List returns all definitions, sorted by name.
+func (s *Store) List() ([]Repository, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	repos, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(repos, func(i, j int) bool { return repos[i].Name < repos[j].Name })
+	return repos, nil
+}
+
+// Get returns the definition name.
+func (s *Store) Get(name string) (Repository, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	repos, err := s.load()
+	if err != nil {
+		return Repository{}, err
+	}
+	i := find(repos, name)
+	if i < 0 {
+		return Repository{}, errors.Wrap(ErrNotFound, name)
+	}
+	return repos[i], nil
+}
+
+// Add saves the new definition r.
+func (s *Store) Add(r Repository) error {
+	if err := r.validate(); err != nil {
+		return err
+	}
+
+	return s.update(func(repos []Repository) ([]Repository, error) {
+		if find(repos, r.Name) >= 0 {
+			return nil, errors.Wrap(ErrExists, r.Name)
+		}
+		r.Created = time.Now()
+		r.Modified = r.Created
+		return append(repos, r), nil
+	})
+}
+
+// Update replaces the definition with the name of r.
+func (s *Store) Update(r Repository) error {
+	if err := r.validate(); err != nil {
+		return err
+	}
+
+	return s.update(func(repos []Repository) ([]Repository, error) {
+		i := find(repos, r.Name)
+		if i < 0 {
+			return nil, errors.Wrap(ErrNotFound, r.Name)
+		}
+		r.Created = repos[i].Created
+		r.Modified = time.Now()
+		repos[i] = r
+		return repos, nil
+	})
+}
+
+// Rename changes the name of the definition oldName to newName.
+func (s *Store) Rename(oldName, newName string) error {
+	if !validName.MatchString(newName) {
+		return errors.Fatalf("invalid name %q for a repository definition", newName)
+	}
+
+	return s.update(func(repos []Repository) ([]Repository, error) {
+		i := find(repos, oldName)
+		if i < 0 {
+			return nil, errors.Wrap(ErrNotFound, oldName)
+		}
+		if find(repos, newName) >= 0 {
+			return nil, errors.Wrap(ErrExists, newName)
+		}
+		repos[i].Name = newName
+		repos[i].Modified = time.Now()
+		return repos, nil
+	})
+}
+
+// Remove deletes the definition name.
+func (s *Store) Remove(name string) error {
+	return s.update(func(repos []Repository) ([]Repository, error) {
+		i := find(repos, name)
+		if i < 0 {
+			return nil, errors.Wrap(ErrNotFound, name)
+		}
+		return append(repos[:i], repos[i+1:]...), nil
+	})
+}
@@ -0,0 +1,112 @@
+package config_test
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/konidev20/rapi"
+	"github.com/konidev20/rapi/config"
+	"github.com/konidev20/rapi/crypto"
+	"github.com/konidev20/rapi/internal/options"
+	rtest "github.com/konidev20/rapi/internal/test"
+)
+
+func init() {
+	// speed up the tests
+	config.KDFParams = crypto.Params{N: 1024, R: 1, P: 1}
+}
+
+func TestStore(t *testing.T) {
+	dir := rtest.TempDir(t)
+	s, err := config.Open(dir, "")
+	rtest.OK(t, err)
+
+	repos, err := s.List()
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, len(repos))
+
+	rtest.OK(t, s.Add(config.Repository{Name: "offsite", Repo: "s3:host/bucket", Options: []string{"s3.region=eu-west-1"}}))
+	rtest.OK(t, s.Add(config.Repository{Name: "local", Repo: "/srv/backup", KeyHint: "abcd1234"}))
+	err = s.Add(config.Repository{Name: "local", Repo: "/other"})
+	rtest.Assert(t, errors.Is(err, config.ErrExists), "unexpected error %v", err)
+	rtest.Assert(t, s.Add(config.Repository{Name: "../x", Repo: "/x"}) != nil, "invalid name accepted")
+	rtest.Assert(t, s.Add(config.Repository{Name: "x", Repo: "/x", Options: []string{"=value"}}) != nil, "invalid options accepted")
+
+	// the definitions are read by another store
+	other, err := config.Open(dir, "")
+	rtest.OK(t, err)
+	repos, err = other.List()
+	rtest.OK(t, err)
+	rtest.Equals(t, 2, len(repos))
+	rtest.Equals(t, "local", repos[0].Name)
+	rtest.Equals(t, "offsite", repos[1].Name)
+
+	r, err := s.Get("offsite")
+	rtest.OK(t, err)
+	r.Description = "off-site copy"
+	rtest.OK(t, s.Update(r))
+	rtest.OK(t, s.Rename("offsite", "remote"))
+	_, err = other.Get("offsite")
+	rtest.Assert(t, errors.Is(err, config.ErrNotFound), "unexpected error %v", err)
+	r, err = other.Get("remote")
+	rtest.OK(t, err)
+	rtest.Equals(t, "off-site copy", r.Description)
+	rtest.Assert(t, !r.Modified.Before(r.Created), "modification time %v before creation %v", r.Modified, r.Created)
+
+	rtest.OK(t, other.Remove("local"))
+	err = s.Remove("local")
+	rtest.Assert(t, errors.Is(err, config.ErrNotFound), "unexpected error %v", err)
+
+	fi, err := os.Stat(s.Path())
+	rtest.OK(t, err)
+	rtest.Equals(t, os.FileMode(0600), fi.Mode().Perm())
+}
+
+func TestStoreEncrypted(t *testing.T) {
+	dir := rtest.TempDir(t)
+	plain, err := config.Open(dir, "")
+	rtest.OK(t, err)
+	rtest.OK(t, plain.Add(config.Repository{Name: "secret-location", Repo: "sftp:user@host:/srv"}))
+
+	// an unencrypted file is encrypted on the next change
+	s, err := config.Open(dir, "passphrase")
+	rtest.OK(t, err)
+	rtest.OK(t, s.Add(config.Repository{Name: "other", Repo: "/srv"}))
+
+	buf, err := os.ReadFile(s.Path())
+	rtest.OK(t, err)
+	rtest.Assert(t, !strings.Contains(string(buf), "secret-location"), "file is not encrypted:\n%s", buf)
+
+	_, err = config.Open(dir, "")
+	rtest.Assert(t, errors.Is(err, config.ErrEncrypted), "unexpected error %v", err)
+	_, err = config.Open(dir, "wrong")
+	rtest.Assert(t, errors.Is(err, config.ErrWrongPassphrase), "unexpected error %v", err)
+
+	s, err = config.Open(dir, "passphrase")
+	rtest.OK(t, err)
+	repos, err := s.List()
+	rtest.OK(t, err)
+	rtest.Equals(t, 2, len(repos))
+	rtest.Equals(t, "sftp:user@host:/srv", repos[1].Repo)
+}
+
+func TestRepositoryApply(t *testing.T) {
+	r := config.Repository{
+		Name:    "offsite",
+		Repo:    "s3:host/bucket",
+		Options: []string{"s3.region=eu-west-1", "s3.storage-class=STANDARD_IA"},
+		KeyHint: "abcd1234",
+	}
+
+	opts, err := r.Apply(rapi.RepositoryOptions{Extended: options.Options{"s3.region": "us-east-1"}})
+	rtest.OK(t, err)
+	rtest.Equals(t, "s3:host/bucket", opts.Repo)
+	rtest.Equals(t, "abcd1234", opts.KeyHint)
+	rtest.Equals(t, options.Options{"s3.region": "us-east-1", "s3.storage-class": "STANDARD_IA"}, opts.Extended)
+
+	opts, err = r.Apply(rapi.RepositoryOptions{Repo: "/other"})
+	rtest.OK(t, err)
+	rtest.Equals(t, "/other", opts.Repo)
+}
@@ -3,7 +3,9 @@ package crypto
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 
@@ -147,6 +149,24 @@ func NewRandomKey() *Key {
 	return k
 }
 
+// NewSyntheticNonce returns a nonce derived from the plaintext with a keyed
+// hash, so that the same plaintext is always encrypted to the same
+// ciphertext. Different plaintexts get different nonces unless the hash
+// collides. Encrypting with synthetic nonces reveals which ciphertexts have
+// the same plaintext, use it only when reproducible output is required.
+func (k *Key) NewSyntheticNonce(plaintext []byte) []byte {
+	sub := hmac.New(sha256.New, k.EncryptionKey[:])
+	_, _ = sub.Write([]byte("rapi synthetic nonce"))
+
+	mac := hmac.New(sha256.New, sub.Sum(nil))
+	_, _ = mac.Write(plaintext)
+	iv := mac.Sum(nil)[:ivSize]
+	if !validNonce(iv) {
+		iv[0] = 1
+	}
+	return iv
+}
+
 // NewRandomNonce returns a new random nonce. It panics on error so that the
 // program is safely terminated.
 func NewRandomNonce() []byte {
@@ -43,6 +43,23 @@ func TestEncryptDecrypt(t *testing.T) {
 	}
 }
 
+func TestSyntheticNonce(t *testing.T) {
+	k := crypto.NewRandomKey()
+	data := rtest.Random(23, 1000)
+
+	nonce := k.NewSyntheticNonce(data)
+	rtest.Equals(t, nonce, k.NewSyntheticNonce(data))
+	rtest.Assert(t, !bytes.Equal(nonce, k.NewSyntheticNonce(data[1:])),
+		"different plaintexts got the same nonce")
+	rtest.Assert(t, !bytes.Equal(nonce, crypto.NewRandomKey().NewSyntheticNonce(data)),
+		"different keys derived the same nonce")
+
+	ciphertext := k.Seal(nil, nonce, data, nil)
+	plaintext, err := k.Open(nil, nonce, ciphertext, nil)
+	rtest.OK(t, err)
+	rtest.Equals(t, data, plaintext)
+}
+
 func TestSmallBuffer(t *testing.T) {
 	k := crypto.NewRandomKey()
 
@@ -0,0 +1,90 @@
+package rapi
+
+import (
+	"context"
+
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/restic"
+)
+
+// The errors returned by the functions of this package can be classified
+// with errors.Is against the following errors, so that callers can decide
+// how to react without parsing the messages. The original error is still
+// part of the chain and can be inspected with errors.As.
+var (
+	// ErrRepoNotFound means that there is no repository at the location.
+	ErrRepoNotFound = errors.New("repository does not exist")
+	// ErrWrongPassword means that no key of the repository could be
+	// decrypted with the password.
+	ErrWrongPassword = errors.New("wrong password or no key found")
+	// ErrLocked means that the repository is locked by another process.
+	ErrLocked = errors.New("repository is already locked")
+	// ErrPartialBackup means that a snapshot was saved, but some source
+	// files could not be read.
+	ErrPartialBackup = errors.New("incomplete snapshot, some source files could not be read")
+	// ErrSourceUnreadable means that too many source files could not be
+	// read and no snapshot was saved, see BackupOptions.
+	ErrSourceUnreadable = errors.New("source files could not be read")
+)
+
+// Exit codes used by the restic command line client.
+const (
+	ExitSuccess      = 0
+	ExitFailure      = 1
+	ExitPartial      = 3
+	ExitRepoNotFound = 10
+	ExitLocked       = 11
+	ExitWrongPass    = 12
+	ExitInterrupted  = 130
+)
+
+// classifiedError adds one of the errors above to the chain of err.
+type classifiedError struct {
+	class error
+	err   error
+}
+
+func (e *classifiedError) Error() string { return e.err.Error() }
+
+func (e *classifiedError) Unwrap() error { return e.err }
+
+func (e *classifiedError) Is(target error) bool { return target == e.class }
+
+// classify marks err as an error of class. It returns nil if err is nil.
+func classify(class, err error) error {
+	if err == nil || errors.Is(err, class) {
+		return err
+	}
+	return &classifiedError{class: class, err: err}
+}
+
+// classifyLockError marks err as ErrLocked if the repository is locked by
+// another process.
+func classifyLockError(err error) error {
+	if restic.IsAlreadyLocked(err) {
+		return classify(ErrLocked, err)
+	}
+	return err
+}
+
+// ExitCode returns the exit code the restic command line client uses for
+// err, see the Exit constants. Errors which are not classified result in
+// ExitFailure, as does ErrSourceUnreadable because no snapshot was saved.
+func ExitCode(err error) int {
+	switch {
+	case err == nil:
+		return ExitSuccess
+	case errors.Is(err, context.Canceled):
+		return ExitInterrupted
+	case errors.Is(err, ErrPartialBackup):
+		return ExitPartial
+	case errors.Is(err, ErrRepoNotFound):
+		return ExitRepoNotFound
+	case errors.Is(err, ErrLocked), restic.IsAlreadyLocked(err):
+		return ExitLocked
+	case errors.Is(err, ErrWrongPassword), errors.Is(err, repository.ErrNoKeyFound):
+		return ExitWrongPass
+	}
+	return ExitFailure
+}
@@ -0,0 +1,65 @@
+package rapi_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/konidev20/rapi"
+	"github.com/konidev20/rapi/backend/local"
+	"github.com/konidev20/rapi/internal/errors"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/restic"
+)
+
+func TestErrorClassification(t *testing.T) {
+	ctx := context.Background()
+	repository.TestUseLowSecurityKDFParameters(t)
+	base := rtest.TempDir(t)
+
+	opts := rapi.DefaultOptions
+	opts.Repo = filepath.Join(base, "missing")
+	opts.Password = rtest.TestPassword
+	opts.NoCache = true
+	_, err := rapi.OpenRepository(ctx, opts)
+	rtest.Assert(t, errors.Is(err, rapi.ErrRepoNotFound), "wrong error %v", err)
+	rtest.Assert(t, errors.IsFatal(err), "error %v is no longer fatal", err)
+	rtest.Equals(t, rapi.ExitRepoNotFound, rapi.ExitCode(err))
+
+	be, err := local.Create(ctx, local.Config{Path: filepath.Join(base, "repo"), Connections: 2})
+	rtest.OK(t, err)
+	repo := repository.TestRepositoryWithBackend(t, be, 0)
+	rtest.OK(t, be.Close())
+
+	opts.Repo = filepath.Join(base, "repo")
+	opts.Password = "wrong"
+	_, err = rapi.OpenRepository(ctx, opts)
+	rtest.Assert(t, errors.Is(err, rapi.ErrWrongPassword), "wrong error %v", err)
+	rtest.Assert(t, errors.Is(err, repository.ErrNoKeyFound), "original error %v not kept", err)
+	rtest.Equals(t, rapi.ExitWrongPass, rapi.ExitCode(err))
+
+	lock, err := restic.NewExclusiveLock(ctx, repo)
+	rtest.OK(t, err)
+	_, err = rapi.CompactIndex(ctx, repo, rapi.CompactIndexOptions{})
+	rtest.Assert(t, errors.Is(err, rapi.ErrLocked), "wrong error %v", err)
+	rtest.Equals(t, rapi.ExitLocked, rapi.ExitCode(err))
+	rtest.OK(t, lock.Unlock())
+}
+
+func TestExitCode(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		code int
+	}{
+		{nil, rapi.ExitSuccess},
+		{errors.New("other"), rapi.ExitFailure},
+		{context.Canceled, rapi.ExitInterrupted},
+		{fmt.Errorf("backup: %w", rapi.ErrPartialBackup), rapi.ExitPartial},
+		{rapi.ErrSourceUnreadable, rapi.ExitFailure},
+		{errors.Wrap(repository.ErrNoKeyFound, "open"), rapi.ExitWrongPass},
+	} {
+		rtest.Equals(t, test.code, rapi.ExitCode(test.err))
+	}
+}
@@ -1,4 +1,4 @@
-package dump
+package export
 
 import (
 	"encoding/binary"
@@ -0,0 +1,177 @@
+// Package export encodes the contents of snapshots as archives or manifests,
+// for example to implement dump or a download service.
+package export
+
+import (
+	"context"
+	"io"
+	"path"
+
+	"github.com/konidev20/rapi/internal/bloblru"
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/restic"
+	"github.com/konidev20/rapi/walker"
+)
+
+// An Encoder writes the nodes of a snapshot to a Writer in an archive format.
+type Encoder interface {
+	// Encode writes the entry for node. The contents of a file must be
+	// written to the returned Writer before Encode is called again. The
+	// Writer is nil if the format does not store file contents or node is
+	// no regular file.
+	Encode(node *restic.Node) (io.Writer, error)
+	// Close finishes the archive, the underlying Writer is not closed.
+	Close() error
+}
+
+// Formats lists the formats supported by NewEncoder.
+var Formats = []string{"tar", "zip", "ndjson"}
+
+// NewEncoder returns an Encoder for format which writes to w.
+func NewEncoder(format string, w io.Writer) (Encoder, error) {
+	switch format {
+	case "tar":
+		return NewTarEncoder(w), nil
+	case "zip":
+		return NewZipEncoder(w), nil
+	case "ndjson":
+		return NewNDJSONEncoder(w), nil
+	default:
+		return nil, errors.Errorf("unknown export format %q", format)
+	}
+}
+
+// An Exporter reads trees and file contents from a repository and passes
+// them to an Encoder.
+type Exporter struct {
+	cache *bloblru.Cache
+	repo  restic.BlobLoader
+}
+
+// New returns an Exporter for repo.
+func New(repo restic.BlobLoader) *Exporter {
+	return &Exporter{
+		cache: bloblru.New(64 << 20),
+		repo:  repo,
+	}
+}
+
+// Tree encodes all nodes in tree and the subtrees below it. The nodes are
+// stored below rootPath. Only files, directories and symlinks are exported.
+// The encoder is closed if all nodes were written successfully.
+func (e *Exporter) Tree(ctx context.Context, tree *restic.Tree, rootPath string, enc Encoder) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// ch is buffered to deal with variable download/write speeds.
+	ch := make(chan *restic.Node, 10)
+	var sendErr error
+	go func() {
+		defer close(ch)
+		sendErr = sendTrees(ctx, e.repo, tree, rootPath, ch)
+	}()
+
+	for node := range ch {
+		w, err := enc.Encode(node)
+		if err != nil {
+			return err
+		}
+		if w != nil && IsFile(node) {
+			if err := e.WriteContent(ctx, w, node); err != nil {
+				return err
+			}
+		}
+	}
+	if sendErr != nil {
+		return sendErr
+	}
+	return errors.Wrap(enc.Close(), "Close")
+}
+
+func sendTrees(ctx context.Context, repo restic.BlobLoader, tree *restic.Tree, rootPath string, ch chan *restic.Node) error {
+	for _, root := range tree.Nodes {
+		root.Path = path.Join(rootPath, root.Name)
+		if err := sendNodes(ctx, repo, root, ch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sendNodes(ctx context.Context, repo restic.BlobLoader, root *restic.Node, ch chan *restic.Node) error {
+	select {
+	case ch <- root:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	// If this is no directory we are finished
+	if !IsDir(root) {
+		return nil
+	}
+
+	err := walker.Walk(ctx, repo, *root.Subtree, nil, func(_ restic.ID, nodepath string, node *restic.Node, err error) (bool, error) {
+		if err != nil {
+			return false, err
+		}
+		if node == nil {
+			return false, nil
+		}
+
+		node.Path = path.Join(root.Path, nodepath)
+
+		if !IsFile(node) && !IsDir(node) && !IsLink(node) {
+			return false, nil
+		}
+
+		select {
+		case ch <- node:
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+
+		return false, nil
+	})
+
+	return err
+}
+
+// WriteContent writes the contents of the file node to w.
+func (e *Exporter) WriteContent(ctx context.Context, w io.Writer, node *restic.Node) error {
+	var (
+		buf []byte
+		err error
+	)
+	for _, id := range node.Content {
+		blob, ok := e.cache.Get(id)
+		if !ok {
+			blob, err = e.repo.LoadBlob(ctx, restic.DataBlob, id, buf)
+			if err != nil {
+				return err
+			}
+
+			buf = e.cache.Add(id, blob) // Reuse evicted buffer.
+		}
+
+		if _, err := w.Write(blob); err != nil {
+			return errors.Wrap(err, "Write")
+		}
+	}
+
+	return nil
+}
+
+// IsDir checks if the given node is a directory.
+func IsDir(node *restic.Node) bool {
+	return node.Type == "dir"
+}
+
+// IsLink checks if the given node as a link.
+func IsLink(node *restic.Node) bool {
+	return node.Type == "symlink"
+}
+
+// IsFile checks if the given node is a file.
+func IsFile(node *restic.Node) bool {
+	return node.Type == "file"
+}
@@ -0,0 +1,61 @@
+package export
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/restic"
+)
+
+// Entry is a line of the NDJSON manifest.
+type Entry struct {
+	Path       string      `json:"path"`
+	Type       string      `json:"type"`
+	Size       uint64      `json:"size,omitempty"`
+	Mode       os.FileMode `json:"mode"`
+	ModTime    time.Time   `json:"mtime"`
+	UID        uint32      `json:"uid"`
+	GID        uint32      `json:"gid"`
+	User       string      `json:"user,omitempty"`
+	Group      string      `json:"group,omitempty"`
+	LinkTarget string      `json:"link_target,omitempty"`
+	Content    restic.IDs  `json:"content,omitempty"`
+}
+
+// NDJSONEncoder writes a manifest with one JSON object per node, see Entry.
+// File contents are not written, the manifest lists the IDs of the data blobs
+// instead.
+type NDJSONEncoder struct {
+	enc *json.Encoder
+}
+
+// NewNDJSONEncoder returns an Encoder which writes a manifest to w.
+func NewNDJSONEncoder(w io.Writer) *NDJSONEncoder {
+	return &NDJSONEncoder{enc: json.NewEncoder(w)}
+}
+
+// Encode writes the entry for node.
+func (e *NDJSONEncoder) Encode(node *restic.Node) (io.Writer, error) {
+	err := e.enc.Encode(Entry{
+		Path:       node.Path,
+		Type:       node.Type,
+		Size:       node.Size,
+		Mode:       node.Mode,
+		ModTime:    node.ModTime,
+		UID:        node.UID,
+		GID:        node.GID,
+		User:       node.User,
+		Group:      node.Group,
+		LinkTarget: node.LinkTarget,
+		Content:    node.Content,
+	})
+	return nil, errors.Wrap(err, "Encode")
+}
+
+// Close does nothing, every line is complete after Encode returned.
+func (e *NDJSONEncoder) Close() error {
+	return nil
+}
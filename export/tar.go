@@ -1,33 +1,47 @@
-package dump
+package export
 
 import (
 	"archive/tar"
-	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 
 	"github.com/konidev20/rapi/internal/debug"
-	"github.com/konidev20/rapi/internal/errors"
 	"github.com/konidev20/rapi/restic"
 )
 
-func (d *Dumper) dumpTar(ctx context.Context, ch <-chan *restic.Node) (err error) {
-	w := tar.NewWriter(d.w)
+// TarEncoder writes a tar archive. Extended attributes are stored in PAX
+// records, Linux POSIX ACLs in the format used by GNU and star.
+type TarEncoder struct {
+	w *tar.Writer
+}
 
-	defer func() {
-		if err == nil {
-			err = w.Close()
-			err = errors.Wrap(err, "Close")
-		}
-	}()
+// NewTarEncoder returns an Encoder which writes a tar archive to w.
+func NewTarEncoder(w io.Writer) *TarEncoder {
+	return &TarEncoder{w: tar.NewWriter(w)}
+}
 
-	for node := range ch {
-		if err := d.dumpNodeTar(ctx, node, w); err != nil {
-			return err
-		}
+// Encode writes the header for node.
+func (e *TarEncoder) Encode(node *restic.Node) (io.Writer, error) {
+	header, err := TarHeader(node)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+
+	err = e.w.WriteHeader(header)
+	if err != nil {
+		return nil, fmt.Errorf("writing header for %q: %w", node.Path, err)
+	}
+	if !IsFile(node) {
+		return nil, nil
+	}
+	return e.w, nil
+}
+
+// Close writes the end of the archive.
+func (e *TarEncoder) Close() error {
+	return e.w.Close()
 }
 
 // copied from archive/tar.FileInfoHeader
@@ -48,10 +62,11 @@ func tarIdentifier(id uint32) int {
 	return int(id)
 }
 
-func (d *Dumper) dumpNodeTar(ctx context.Context, node *restic.Node, w *tar.Writer) error {
+// TarHeader returns the header for node, the name is relative to the root.
+func TarHeader(node *restic.Node) (*tar.Header, error) {
 	relPath, err := filepath.Rel("/", node.Path)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	header := &tar.Header{
@@ -93,11 +108,7 @@ func (d *Dumper) dumpNodeTar(ctx context.Context, node *restic.Node, w *tar.Writ
 		header.Name += "/"
 	}
 
-	err = w.WriteHeader(header)
-	if err != nil {
-		return fmt.Errorf("writing header for %q: %w", node.Path, err)
-	}
-	return d.writeNode(ctx, w, node)
+	return header, nil
 }
 
 func parseXattrs(xattrs []restic.ExtendedAttribute) map[string]string {
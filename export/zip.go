@@ -0,0 +1,71 @@
+package export
+
+import (
+	"archive/zip"
+	"io"
+	"path/filepath"
+
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/restic"
+)
+
+// ZipEncoder writes a zip archive. Symlinks are stored as files which
+// contain the link target and have the mode os.ModeSymlink.
+type ZipEncoder struct {
+	w *zip.Writer
+}
+
+// NewZipEncoder returns an Encoder which writes a zip archive to w.
+func NewZipEncoder(w io.Writer) *ZipEncoder {
+	return &ZipEncoder{w: zip.NewWriter(w)}
+}
+
+// ZipHeader returns the header for node, the name is relative to the root.
+func ZipHeader(node *restic.Node) (*zip.FileHeader, error) {
+	relPath, err := filepath.Rel("/", node.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	header := &zip.FileHeader{
+		Name:               filepath.ToSlash(relPath),
+		UncompressedSize64: node.Size,
+		Modified:           node.ModTime,
+	}
+	header.SetMode(node.Mode)
+
+	if IsDir(node) {
+		header.Name += "/"
+	}
+	return header, nil
+}
+
+// Encode writes the header for node.
+func (e *ZipEncoder) Encode(node *restic.Node) (io.Writer, error) {
+	header, err := ZipHeader(node)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := e.w.CreateHeader(header)
+	if err != nil {
+		return nil, errors.Wrap(err, "ZipHeader")
+	}
+
+	if IsLink(node) {
+		if _, err = w.Write([]byte(node.LinkTarget)); err != nil {
+			return nil, errors.Wrap(err, "Write")
+		}
+
+		return nil, nil
+	}
+	if !IsFile(node) {
+		return nil, nil
+	}
+	return w, nil
+}
+
+// Close writes the central directory of the archive.
+func (e *ZipEncoder) Close() error {
+	return e.w.Close()
+}
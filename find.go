@@ -0,0 +1,218 @@
+package rapi
+
+import (
+	"context"
+	"crypto/sha256"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/restic"
+	"github.com/konidev20/rapi/walker"
+	"github.com/restic/chunker"
+)
+
+// HashMatch describes a file found by FindByHash or FindByBlob.
+type HashMatch struct {
+	SnapshotID restic.ID
+	Path       string
+	Node       *restic.Node
+
+	// ID is the file digest or the blob ID which matched.
+	ID restic.ID
+}
+
+// FileHash identifies a file by the SHA-256 digest and the size of its
+// contents.
+type FileHash struct {
+	Digest restic.ID `json:"digest"`
+	Size   uint64    `json:"size"`
+}
+
+// FindByHash reports all files in all snapshots which match one of files.
+// Files which are stored as a single blob are matched using the blob ID,
+// which is equal to the digest of the file. Only the files of the right size
+// which consist of several blobs are read to compute their digest, identical
+// files are only read once. fn is called for each match, snapshots are
+// processed from oldest to newest.
+//
+// The index must already be loaded. Files smaller than the minimal chunk
+// size (512 KiB) are always stored as a single blob, if that blob is not in
+// the index they are not searched for. If none of the files can be in the
+// repository, the snapshots are not read at all.
+func FindByHash(ctx context.Context, repo restic.Repository, files []FileHash, fn func(HashMatch) error) error {
+	// digests of the wanted files, by size
+	wanted := make(map[uint64]restic.IDSet)
+	for _, f := range files {
+		if f.Size > 0 && f.Size < chunker.MinSize && !repo.Index().Has(restic.BlobHandle{ID: f.Digest, Type: restic.DataBlob}) {
+			continue
+		}
+		if wanted[f.Size] == nil {
+			wanted[f.Size] = restic.NewIDSet()
+		}
+		wanted[f.Size].Insert(f.Digest)
+	}
+
+	if len(wanted) == 0 {
+		debug.Log("none of the %d files is in the index", len(files))
+		return nil
+	}
+
+	// digests of files with more than one blob, by content
+	known := make(map[string]restic.ID)
+	var buf []byte
+
+	return findFiles(ctx, repo, func(node *restic.Node) (restic.IDs, error) {
+		digests, ok := wanted[node.Size]
+		if !ok {
+			return nil, nil
+		}
+
+		if len(node.Content) == 1 {
+			if digests.Has(node.Content[0]) {
+				return restic.IDs{node.Content[0]}, nil
+			}
+			return nil, nil
+		}
+
+		key := contentKey(node.Content)
+		digest, ok := known[key]
+		if !ok {
+			h := sha256.New()
+			for _, id := range node.Content {
+				var err error
+				buf, err = repo.LoadBlob(ctx, restic.DataBlob, id, buf)
+				if err != nil {
+					return nil, err
+				}
+				_, _ = h.Write(buf)
+			}
+			digest = restic.IDFromHash(h.Sum(nil))
+			known[key] = digest
+		}
+
+		if digests.Has(digest) {
+			return restic.IDs{digest}, nil
+		}
+		return nil, nil
+	}, fn)
+}
+
+// FindByBlob reports all files in all snapshots which contain one of the data
+// blobs in ids, see FindByHash.
+//
+// The index must already be loaded. Blobs which are not in the index are not
+// searched for, and if none of the blobs is contained in the repository, the
+// snapshots are not read at all.
+func FindByBlob(ctx context.Context, repo restic.Repository, ids restic.IDs, fn func(HashMatch) error) error {
+	blobs := restic.NewIDSet()
+	for _, id := range ids {
+		if repo.Index().Has(restic.BlobHandle{ID: id, Type: restic.DataBlob}) {
+			blobs.Insert(id)
+		}
+	}
+
+	if len(blobs) == 0 {
+		debug.Log("none of the %d blobs is in the index", len(ids))
+		return nil
+	}
+
+	return findFiles(ctx, repo, func(node *restic.Node) (restic.IDs, error) {
+		var found restic.IDs
+		for _, id := range node.Content {
+			if blobs.Has(id) {
+				found = append(found, id)
+			}
+		}
+		return found, nil
+	}, fn)
+}
+
+func contentKey(ids restic.IDs) string {
+	var sb strings.Builder
+	for _, id := range ids {
+		_, _ = sb.Write(id[:])
+	}
+	return sb.String()
+}
+
+// findFiles calls match for all files in all snapshots and fn for each ID
+// returned by match.
+func findFiles(ctx context.Context, repo restic.Repository, match func(*restic.Node) (restic.IDs, error), fn func(HashMatch) error) error {
+	var snapshots restic.Snapshots
+	err := restic.ForAllSnapshots(ctx, repo, repo, nil, func(_ restic.ID, sn *restic.Snapshot, err error) error {
+		if err != nil {
+			return err
+		}
+		snapshots = append(snapshots, sn)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Time.Before(snapshots[j].Time)
+	})
+
+	// Trees which don't contain any match are not walked again. The walker
+	// marks a directory as ignored before walking it and only learns
+	// afterwards whether all nodes in the subtree were ignored, so the
+	// directories containing a match are removed from the set again.
+	ignoreTrees := restic.NewIDSet()
+	for _, sn := range snapshots {
+		if sn.Tree == nil {
+			continue
+		}
+
+		snID := *sn.ID()
+		dirs := make(map[string]restic.ID)
+		err := walker.Walk(ctx, repo, *sn.Tree, ignoreTrees, func(_ restic.ID, p string, node *restic.Node, err error) (bool, error) {
+			if err != nil {
+				return false, err
+			}
+			if node == nil {
+				return false, nil
+			}
+			if node.Type == "dir" {
+				dirs[p] = *node.Subtree
+				return true, nil
+			}
+			if node.Type != "file" {
+				return true, nil
+			}
+
+			ids, err := match(node)
+			if err != nil {
+				return false, err
+			}
+			if len(ids) == 0 {
+				return true, nil
+			}
+
+			for dir := path.Dir(p); dir != "/"; dir = path.Dir(dir) {
+				ignoreTrees.Delete(dirs[dir])
+			}
+
+			for _, id := range ids {
+				err := fn(HashMatch{
+					SnapshotID: snID,
+					Path:       p,
+					Node:       node,
+					ID:         id,
+				})
+				if err != nil {
+					return false, err
+				}
+			}
+
+			return false, nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
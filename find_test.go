@@ -0,0 +1,92 @@
+package rapi_test
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/konidev20/rapi"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/restic"
+	"github.com/restic/chunker"
+)
+
+// countingRepository counts the data blobs which are loaded.
+type countingRepository struct {
+	restic.Repository
+	dataBlobs int
+}
+
+func (r *countingRepository) LoadBlob(ctx context.Context, t restic.BlobType, id restic.ID, buf []byte) ([]byte, error) {
+	if t == restic.DataBlob {
+		r.dataBlobs++
+	}
+	return r.Repository.LoadBlob(ctx, t, id, buf)
+}
+
+func TestFindByHash(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.TestRepository(t)
+
+	// files below the minimal chunk size are always a single blob
+	large := []string{string(rtest.Random(1, chunker.MinSize)), "part2"}
+	dir := testDir{
+		"a": testDir{"single": "hello"},
+		"b": testDir{
+			"multi": large,
+			"other": "unrelated",
+		},
+		"c": testDir{"d": testDir{"part": large[0]}},
+	}
+	sn1 := saveTestSnapshot(t, repo, dir)
+	dir["e"] = "hello"
+	sn2 := saveTestSnapshot(t, repo, dir)
+	rtest.OK(t, repo.LoadIndex(ctx, nil))
+
+	counting := &countingRepository{Repository: repo}
+	collect := func(matches *[]string) func(rapi.HashMatch) error {
+		return func(m rapi.HashMatch) error {
+			prefix := "1:"
+			if m.SnapshotID == sn2 {
+				prefix = "2:"
+			} else {
+				rtest.Equals(t, sn1, m.SnapshotID)
+			}
+			*matches = append(*matches, prefix+m.Path)
+			return nil
+		}
+	}
+	findHash := func(files ...rapi.FileHash) []string {
+		var matches []string
+		rtest.OK(t, rapi.FindByHash(ctx, counting, files, collect(&matches)))
+		sort.Strings(matches)
+		return matches
+	}
+	findBlob := func(ids ...restic.ID) []string {
+		var matches []string
+		rtest.OK(t, rapi.FindByBlob(ctx, counting, ids, collect(&matches)))
+		sort.Strings(matches)
+		return matches
+	}
+	file := func(data string) rapi.FileHash {
+		return rapi.FileHash{Digest: restic.Hash([]byte(data)), Size: uint64(len(data))}
+	}
+	whole := file(large[0] + large[1])
+
+	// the digest of a file split into several blobs only matches the whole
+	// file, which is read once for both snapshots
+	rtest.Equals(t, []string{"1:/a/single", "1:/b/multi", "2:/a/single", "2:/b/multi", "2:/e"},
+		findHash(file("hello"), file("nothing"), whole))
+	rtest.Equals(t, 2, counting.dataBlobs)
+	rtest.Equals(t, []string{"1:/c/d/part", "2:/c/d/part"}, findHash(file(large[0])))
+	rtest.Equals(t, []string(nil), findHash(file("part2")))
+
+	// files of a different size are not read
+	counting.dataBlobs = 0
+	rtest.Equals(t, []string(nil), findHash(rapi.FileHash{Digest: whole.Digest, Size: whole.Size + 1}))
+	rtest.Equals(t, 0, counting.dataBlobs)
+
+	rtest.Equals(t, []string{"1:/b/multi", "1:/c/d/part", "2:/b/multi", "2:/c/d/part"}, findBlob(restic.Hash([]byte(large[0]))))
+	rtest.Equals(t, []string(nil), findBlob(restic.Hash([]byte("missing"))))
+}
@@ -0,0 +1,28 @@
+package rapi
+
+import (
+	"context"
+	"time"
+
+	"github.com/konidev20/rapi/hooks"
+	"github.com/konidev20/rapi/restic"
+)
+
+// hookInfo returns the information passed to the hooks of the operation op.
+func hookInfo(repo restic.Repository, op string, start time.Time) hooks.Info {
+	return hooks.Info{
+		Operation:  op,
+		Repository: repo.Config().ID,
+		Start:      start,
+	}
+}
+
+// runHooks runs the hooks of ev after an operation. The hooks are run with a
+// context which is not cancelled, so that a cancelled operation is reported,
+// too. Errors of the hooks are added to warnings.
+func runHooks(ctx context.Context, h *hooks.Hooks, info hooks.Info, ev hooks.Event, warnings *restic.Warnings) {
+	info.Event = ev
+	info.End = restic.Now(ctx)
+	hctx := restic.WithClock(context.Background(), restic.ClockFromContext(ctx))
+	warnings.Add("", h.Run(hctx, info))
+}
@@ -0,0 +1,145 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxOutput is the amount of output of a failed command included in the
+// error.
+const maxOutput = 4096
+
+// Command is a hook which runs a shell command, "sh -c" or "cmd /C" on
+// Windows. The command can use the environment variables RESTIC_HOOK_EVENT,
+// RESTIC_HOOK_OPERATION, RESTIC_HOOK_REPOSITORY, RESTIC_HOOK_SNAPSHOT,
+// RESTIC_HOOK_DURATION (in seconds) and RESTIC_HOOK_ERROR.
+type Command struct {
+	Command string
+
+	// Dir is the working directory, the current directory is used if it is
+	// empty.
+	Dir string
+
+	// Env are additional environment variables as "key=value".
+	Env []string
+
+	// Timeout kills the command after this duration, zero means no limit.
+	Timeout time.Duration
+
+	// Stdout and Stderr receive the output of the command. If they are nil,
+	// the output is discarded, the end of it is included in the error if the
+	// command fails.
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Run runs the command.
+func (c *Command) Run(ctx context.Context, info Info) error {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", c.Command)
+	} else {
+		cmd = exec.Command("sh", "-c", c.Command)
+	}
+	cmd.Dir = c.Dir
+	cmd.Env = append(append(os.Environ(), c.Env...), environment(info)...)
+	prepareCommand(cmd)
+
+	output := &tailBuffer{max: maxOutput}
+	cmd.Stdout, cmd.Stderr = output, output
+	if c.Stdout != nil {
+		cmd.Stdout = io.MultiWriter(c.Stdout, output)
+	}
+	if c.Stderr != nil {
+		cmd.Stderr = io.MultiWriter(c.Stderr, output)
+	}
+
+	err := cmd.Start()
+	if err == nil {
+		// the processes started by the shell are killed as well, they
+		// would keep the output open otherwise
+		done := make(chan struct{})
+		go func() {
+			select {
+			case <-ctx.Done():
+				killCommand(cmd)
+			case <-done:
+			}
+		}()
+		err = cmd.Wait()
+		close(done)
+	}
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	out := strings.TrimSpace(output.String())
+	if out == "" {
+		return fmt.Errorf("command %q failed: %w", c.Command, err)
+	}
+	return fmt.Errorf("command %q failed: %w, output:\n%s", c.Command, err, out)
+}
+
+func environment(info Info) []string {
+	env := []string{
+		"RESTIC_HOOK_EVENT=" + string(info.Event),
+		"RESTIC_HOOK_OPERATION=" + info.Operation,
+		"RESTIC_HOOK_REPOSITORY=" + info.Repository,
+	}
+	if !info.Snapshot.IsNull() {
+		env = append(env, "RESTIC_HOOK_SNAPSHOT="+info.Snapshot.String())
+	}
+	if !info.End.IsZero() {
+		env = append(env, fmt.Sprintf("RESTIC_HOOK_DURATION=%d", int64(info.End.Sub(info.Start).Seconds())))
+	}
+	if info.Err != nil {
+		env = append(env, "RESTIC_HOOK_ERROR="+info.Err.Error())
+	}
+	return env
+}
+
+// tailBuffer keeps the last max bytes written to it, it is written to by
+// the goroutines copying stdout and stderr.
+type tailBuffer struct {
+	max int
+
+	m   sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	n := len(p)
+	if len(p) > b.max {
+		p = p[len(p)-b.max:]
+	}
+	if drop := b.buf.Len() + len(p) - b.max; drop > 0 {
+		b.buf.Next(drop)
+	}
+	b.buf.Write(p)
+	return n, nil
+}
+
+func (b *tailBuffer) String() string {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return b.buf.String()
+}
@@ -0,0 +1,14 @@
+package hooks
+
+import (
+	"os/exec"
+	"strconv"
+)
+
+func prepareCommand(_ *exec.Cmd) {}
+
+// killCommand kills cmd and the processes started by it.
+func killCommand(cmd *exec.Cmd) {
+	_ = exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run()
+	_ = cmd.Process.Kill()
+}
@@ -0,0 +1,144 @@
+// Package hooks runs actions before and after operations on a repository,
+// for example a shell command which creates a database dump before a backup,
+// or a request to a monitoring service when a backup failed. Hooks are
+// registered for events in a Hooks, which is passed to the operations.
+package hooks
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/restic"
+)
+
+// Event is the point of an operation at which hooks are run.
+type Event string
+
+// Events at which hooks are run.
+const (
+	// PreBackup is run before a backup starts. If a hook fails, the backup
+	// is not started and the OnError hooks are run.
+	PreBackup Event = "pre-backup"
+	// PostBackup is run after a snapshot was saved.
+	PostBackup Event = "post-backup"
+	// OnError is run after an operation failed, including failed PreBackup
+	// hooks.
+	OnError Event = "on-error"
+	// PostPrune is run after a prune completed.
+	PostPrune Event = "post-prune"
+)
+
+// Events lists all events.
+var Events = []Event{PreBackup, PostBackup, OnError, PostPrune}
+
+func (ev Event) valid() bool {
+	for _, e := range Events {
+		if ev == e {
+			return true
+		}
+	}
+	return false
+}
+
+// Info describes the operation for which a hook is run.
+type Info struct {
+	Event Event
+
+	// Operation is the name of the operation, e.g. "backup" or "prune".
+	Operation string
+
+	// Repository is the ID of the repository.
+	Repository string
+
+	// Snapshot is the ID of the saved snapshot for PostBackup, it is the
+	// zero ID otherwise.
+	Snapshot restic.ID
+
+	// Start is the time the operation started, End is zero for PreBackup.
+	Start time.Time
+	End   time.Time
+
+	// Err is the error of the failed operation for OnError.
+	Err error
+}
+
+// Hook is an action run for an event.
+type Hook interface {
+	Run(ctx context.Context, info Info) error
+}
+
+// Func is a function which implements Hook.
+type Func func(ctx context.Context, info Info) error
+
+// Run calls f.
+func (f Func) Run(ctx context.Context, info Info) error {
+	return f(ctx, info)
+}
+
+// Hooks are the hooks registered for the events. It is safe for concurrent
+// use, a nil Hooks runs no hooks.
+type Hooks struct {
+	mu    sync.Mutex
+	hooks map[Event][]Hook
+}
+
+// New returns a Hooks without hooks.
+func New() *Hooks {
+	return &Hooks{hooks: make(map[Event][]Hook)}
+}
+
+// Register adds hook for ev, hooks are run in the order of registration.
+func (h *Hooks) Register(ev Event, hook Hook) error {
+	if !ev.valid() {
+		return errors.Errorf("unknown event %q", ev)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.hooks[ev] = append(h.hooks[ev], hook)
+	return nil
+}
+
+// Len returns the number of hooks registered for ev.
+func (h *Hooks) Len(ev Event) int {
+	if h == nil {
+		return 0
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.hooks[ev])
+}
+
+// Run runs the hooks registered for info.Event. The hooks of PreBackup stop
+// at the first error, which is returned. For the other events all hooks are
+// run and the first error is returned.
+func (h *Hooks) Run(ctx context.Context, info Info) error {
+	if h == nil {
+		return nil
+	}
+
+	h.mu.Lock()
+	hooks := append([]Hook(nil), h.hooks[info.Event]...)
+	h.mu.Unlock()
+
+	var firstErr error
+	for i, hook := range hooks {
+		debug.Log("running hook %d of %v for %v", i, info.Event, info.Operation)
+		err := hook.Run(ctx, info)
+		if err == nil {
+			continue
+		}
+
+		err = errors.Wrapf(err, "%v hook", info.Event)
+		if info.Event == PreBackup {
+			return err
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,199 @@
+package hooks_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/konidev20/rapi/hooks"
+	"github.com/konidev20/rapi/internal/options"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/restic"
+)
+
+func TestHooksRun(t *testing.T) {
+	ctx := context.Background()
+	var calls []string
+	hook := func(name string, err error) hooks.Hook {
+		return hooks.Func(func(context.Context, hooks.Info) error {
+			calls = append(calls, name)
+			return err
+		})
+	}
+
+	h := hooks.New()
+	rtest.OK(t, h.Register(hooks.PreBackup, hook("pre1", errors.New("failed"))))
+	rtest.OK(t, h.Register(hooks.PreBackup, hook("pre2", nil)))
+	rtest.OK(t, h.Register(hooks.OnError, hook("err1", errors.New("failed"))))
+	rtest.OK(t, h.Register(hooks.OnError, hook("err2", nil)))
+	rtest.Assert(t, h.Register("pre-restore", hook("x", nil)) != nil, "unknown event accepted")
+	rtest.Equals(t, 2, h.Len(hooks.PreBackup))
+
+	// pre hooks stop at the first error, the others run all hooks
+	err := h.Run(ctx, hooks.Info{Event: hooks.PreBackup})
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "pre-backup hook"), "unexpected error %v", err)
+	err = h.Run(ctx, hooks.Info{Event: hooks.OnError})
+	rtest.Assert(t, err != nil, "error not returned")
+	rtest.OK(t, h.Run(ctx, hooks.Info{Event: hooks.PostPrune}))
+	rtest.Equals(t, []string{"pre1", "err1", "err2"}, calls)
+
+	var nilHooks *hooks.Hooks
+	rtest.OK(t, nilHooks.Run(ctx, hooks.Info{Event: hooks.PreBackup}))
+}
+
+func testInfo() hooks.Info {
+	start := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	return hooks.Info{
+		Event:      hooks.OnError,
+		Operation:  "backup",
+		Repository: "5f3c2a",
+		Snapshot:   restic.NewRandomID(),
+		Start:      start,
+		End:        start.Add(90 * time.Second),
+		Err:        errors.New("disk full"),
+	}
+}
+
+func TestCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses sh")
+	}
+
+	ctx := context.Background()
+	dir := rtest.TempDir(t)
+	info := testInfo()
+
+	c := &hooks.Command{
+		Command: `echo "$RESTIC_HOOK_EVENT $RESTIC_HOOK_OPERATION $RESTIC_HOOK_REPOSITORY $RESTIC_HOOK_SNAPSHOT $RESTIC_HOOK_DURATION $RESTIC_HOOK_ERROR $EXTRA" > out`,
+		Dir:     dir,
+		Env:     []string{"EXTRA=x"},
+	}
+	rtest.OK(t, c.Run(ctx, info))
+	buf, err := os.ReadFile(filepath.Join(dir, "out"))
+	rtest.OK(t, err)
+	rtest.Equals(t, "on-error backup 5f3c2a "+info.Snapshot.String()+" 90 disk full x\n", string(buf))
+
+	c = &hooks.Command{Command: "echo dump failed >&2; exit 3"}
+	err = c.Run(ctx, info)
+	rtest.Assert(t, err != nil && strings.Contains(err.Error(), "dump failed"), "unexpected error %v", err)
+
+	c = &hooks.Command{Command: "sleep 10", Timeout: 10 * time.Millisecond}
+	err = c.Run(ctx, info)
+	rtest.Assert(t, errors.Is(err, context.DeadlineExceeded), "unexpected error %v", err)
+}
+
+type request struct {
+	method string
+	path   string
+	body   string
+}
+
+func testServer(t *testing.T, status int) (*httptest.Server, func() []request) {
+	var mu sync.Mutex
+	var requests []request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		requests = append(requests, request{r.Method, r.URL.Path, string(body)})
+		mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, func() []request {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	ctx := context.Background()
+	srv, requests := testServer(t, http.StatusOK)
+	info := testInfo()
+
+	w := &hooks.Webhook{URL: srv.URL + "/hook"}
+	rtest.OK(t, w.Run(ctx, info))
+	rtest.Equals(t, 1, len(requests()))
+	req := requests()[0]
+	rtest.Equals(t, http.MethodPost, req.method)
+	rtest.Equals(t, "/hook", req.path)
+
+	var p hooks.Payload
+	rtest.OK(t, json.Unmarshal([]byte(req.body), &p))
+	rtest.Equals(t, hooks.NewPayload(info), p)
+	rtest.Equals(t, "disk full", p.Error)
+	rtest.Equals(t, 90.0, p.Duration)
+
+	srv, _ = testServer(t, http.StatusServiceUnavailable)
+	w = &hooks.Webhook{URL: srv.URL + "/hook?token=secret"}
+	err := w.Run(ctx, info)
+	rtest.Assert(t, err != nil, "error status not detected")
+	rtest.Assert(t, !strings.Contains(err.Error(), "secret"), "token in error %v", err)
+}
+
+func TestHealthchecks(t *testing.T) {
+	ctx := context.Background()
+	srv, requests := testServer(t, http.StatusOK)
+	h := &hooks.Healthchecks{URL: srv.URL + "/ping/uuid/"}
+
+	info := testInfo()
+	for _, ev := range []hooks.Event{hooks.PreBackup, hooks.PostBackup, hooks.OnError} {
+		info.Event = ev
+		rtest.OK(t, h.Run(ctx, info))
+	}
+	rtest.Equals(t, []request{
+		{http.MethodPost, "/ping/uuid/start", "disk full"},
+		{http.MethodPost, "/ping/uuid", "disk full"},
+		{http.MethodPost, "/ping/uuid/fail", "disk full"},
+	}, requests())
+}
+
+func TestFromOptions(t *testing.T) {
+	opts, err := options.Parse([]string{
+		"hooks.pre-backup=make-dump.sh",
+		"hooks.post-prune=echo done",
+		"hooks.webhook=https://example.com/hook",
+		"hooks.healthchecks=https://hc-ping.com/uuid",
+		"hooks.timeout=5m",
+		"s3.region=eu-west-1",
+	})
+	rtest.OK(t, err)
+
+	cfg, err := hooks.ParseOptions(opts)
+	rtest.OK(t, err)
+	rtest.Equals(t, "make-dump.sh", cfg.PreBackup)
+	rtest.Equals(t, 5*time.Minute, cfg.Timeout)
+
+	h, err := cfg.Hooks()
+	rtest.OK(t, err)
+	rtest.Equals(t, 3, h.Len(hooks.PreBackup))
+	rtest.Equals(t, 2, h.Len(hooks.PostBackup))
+	rtest.Equals(t, 2, h.Len(hooks.OnError))
+	rtest.Equals(t, 2, h.Len(hooks.PostPrune))
+
+	opts, err = options.Parse([]string{"hooks.pre-restore=x"})
+	rtest.OK(t, err)
+	_, err = hooks.FromOptions(opts)
+	rtest.Assert(t, err != nil, "unknown option accepted")
+}
+
+func TestWebhookUnreachable(t *testing.T) {
+	srv, _ := testServer(t, http.StatusOK)
+	srv.Close()
+
+	w := &hooks.Webhook{URL: srv.URL + "/hook?token=secret"}
+	err := w.Run(context.Background(), testInfo())
+	rtest.Assert(t, err != nil, "unreachable server not detected")
+	rtest.Assert(t, !strings.Contains(err.Error(), "secret"), "token in error %v", err)
+}
@@ -0,0 +1,86 @@
+package hooks
+
+import (
+	"time"
+
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/internal/options"
+)
+
+// Config contains the hooks which can be configured with the extended
+// options in the namespace "hooks", e.g. "hooks.pre-backup=make-dump.sh".
+type Config struct {
+	PreBackup  string `option:"pre-backup" help:"run this shell command before a backup, the backup is not started if it fails"`
+	PostBackup string `option:"post-backup" help:"run this shell command after a snapshot was saved"`
+	OnError    string `option:"on-error" help:"run this shell command after an operation failed"`
+	PostPrune  string `option:"post-prune" help:"run this shell command after a prune"`
+
+	Webhook      string `option:"webhook" help:"send all events as JSON to this URL"`
+	Healthchecks string `option:"healthchecks" help:"ping this healthchecks.io check URL when a backup starts or succeeds and when an operation fails"`
+
+	Timeout time.Duration `option:"timeout" help:"stop commands and requests after this duration (default: commands are not stopped, requests after 30s)"`
+}
+
+func init() {
+	options.Register("hooks", Config{})
+}
+
+// ParseOptions returns the configuration of the extended options in the
+// namespace "hooks", other options are ignored.
+func ParseOptions(opts options.Options) (Config, error) {
+	var cfg Config
+	if err := opts.Extract("hooks.").Apply("hooks", &cfg); err != nil {
+		return Config{}, errors.Fatal(err.Error())
+	}
+	return cfg, nil
+}
+
+// FromOptions returns the hooks configured by the extended options in the
+// namespace "hooks", see Config.
+func FromOptions(opts options.Options) (*Hooks, error) {
+	cfg, err := ParseOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.Hooks()
+}
+
+// Hooks returns the hooks of the configuration.
+func (cfg Config) Hooks() (*Hooks, error) {
+	h := New()
+	for _, c := range []struct {
+		ev      Event
+		command string
+	}{
+		{PreBackup, cfg.PreBackup},
+		{PostBackup, cfg.PostBackup},
+		{OnError, cfg.OnError},
+		{PostPrune, cfg.PostPrune},
+	} {
+		if c.command == "" {
+			continue
+		}
+		if err := h.Register(c.ev, &Command{Command: c.command, Timeout: cfg.Timeout}); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Webhook != "" {
+		hook := &Webhook{URL: cfg.Webhook, Timeout: cfg.Timeout}
+		for _, ev := range Events {
+			if err := h.Register(ev, hook); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if cfg.Healthchecks != "" {
+		hook := &Healthchecks{URL: cfg.Healthchecks, Timeout: cfg.Timeout}
+		for _, ev := range []Event{PreBackup, PostBackup, OnError} {
+			if err := h.Register(ev, hook); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return h, nil
+}
@@ -0,0 +1,162 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"time"
+
+	"github.com/konidev20/rapi/internal/errors"
+)
+
+// defaultWebhookTimeout is the timeout of requests without a Timeout.
+const defaultWebhookTimeout = 30 * time.Second
+
+// Payload is the JSON body sent by a Webhook.
+type Payload struct {
+	Event      Event     `json:"event"`
+	Operation  string    `json:"operation"`
+	Repository string    `json:"repository"`
+	Snapshot   string    `json:"snapshot,omitempty"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end,omitempty"`
+	Duration   float64   `json:"duration_seconds,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// NewPayload returns the payload for info.
+func NewPayload(info Info) Payload {
+	p := Payload{
+		Event:      info.Event,
+		Operation:  info.Operation,
+		Repository: info.Repository,
+		Start:      info.Start,
+		End:        info.End,
+	}
+	if !info.Snapshot.IsNull() {
+		p.Snapshot = info.Snapshot.String()
+	}
+	if !info.End.IsZero() {
+		p.Duration = info.End.Sub(info.Start).Seconds()
+	}
+	if info.Err != nil {
+		p.Error = info.Err.Error()
+	}
+	return p
+}
+
+// Webhook is a hook which sends the Payload of the event as JSON to a URL.
+type Webhook struct {
+	URL string
+
+	// Method is the HTTP method, it defaults to POST.
+	Method string
+
+	// Header is added to the request, e.g. for an Authorization header.
+	Header http.Header
+
+	// Client sends the request, it defaults to http.DefaultClient.
+	Client *http.Client
+
+	// Timeout of the request, it defaults to 30 seconds.
+	Timeout time.Duration
+}
+
+// Run sends the request, a response with a status other than 2xx is an
+// error.
+func (w *Webhook) Run(ctx context.Context, info Info) error {
+	body, err := json.Marshal(NewPayload(info))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	method := w.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	header := w.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	header.Set("Content-Type", "application/json")
+	return send(ctx, w.Client, w.Timeout, method, w.URL, header, body)
+}
+
+// Healthchecks is a hook which pings a check of healthchecks.io or a
+// compatible service: the start of a backup is signalled to URL + "/start",
+// errors to URL + "/fail" and the successful end of an operation to URL.
+// The error message is sent as the body, so that it is shown in the log of
+// the check.
+type Healthchecks struct {
+	URL string
+
+	// Client sends the request, it defaults to http.DefaultClient.
+	Client *http.Client
+
+	// Timeout of the request, it defaults to 30 seconds.
+	Timeout time.Duration
+}
+
+// Run pings the check.
+func (h *Healthchecks) Run(ctx context.Context, info Info) error {
+	url := strings.TrimSuffix(h.URL, "/")
+	switch info.Event {
+	case PreBackup:
+		url += "/start"
+	case OnError:
+		url += "/fail"
+	}
+
+	var body []byte
+	if info.Err != nil {
+		body = []byte(info.Err.Error())
+	}
+	header := http.Header{"Content-Type": []string{"text/plain; charset=utf-8"}}
+	return send(ctx, h.Client, h.Timeout, http.MethodPost, url, header, body)
+}
+
+func send(ctx context.Context, client *http.Client, timeout time.Duration, method, url string, header http.Header, body []byte) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if timeout <= 0 {
+		timeout = defaultWebhookTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	req.Header = header
+
+	resp, err := client.Do(req)
+	if err != nil {
+		// the error of the client contains the URL including the query
+		var uerr *neturl.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return errors.Wrapf(err, "%v %v", method, stripQuery(url))
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf("%v %v: unexpected status %v: %s", method, stripQuery(url), resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// stripQuery removes the query from url, which may contain tokens.
+func stripQuery(url string) string {
+	url, _, _ = strings.Cut(url, "?")
+	return url
+}
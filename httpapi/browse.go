@@ -0,0 +1,154 @@
+package httpapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+
+	"github.com/konidev20/rapi"
+	"github.com/konidev20/rapi/export"
+	"github.com/konidev20/rapi/restic"
+	"github.com/konidev20/rapi/ui/jsonout"
+)
+
+// findSnapshot returns the snapshot with the ID, ID prefix or "latest".
+func (h *Handler) findSnapshot(ctx context.Context, s string) (*restic.Snapshot, error) {
+	var filter restic.SnapshotFilter
+	sn, subfolder, err := filter.FindLatest(ctx, h.repo, h.repo, s)
+	if err != nil {
+		return nil, newHTTPError(http.StatusNotFound, "snapshot %v: %v", s, err)
+	}
+	if subfolder != "" {
+		return nil, newHTTPError(http.StatusBadRequest, "use the path parameter instead of %q", s)
+	}
+	return sn, nil
+}
+
+// findNode returns the node at p in the snapshot and the ID of the tree
+// which contains it. For the root directory, a node is returned whose
+// subtree is the tree of the snapshot and the ID is nil.
+func (h *Handler) findNode(ctx context.Context, sn *restic.Snapshot, p string) (*restic.Node, *restic.ID, error) {
+	p = path.Clean("/" + p)
+	if p == "/" {
+		return &restic.Node{Name: "/", Type: "dir", Subtree: sn.Tree}, nil, nil
+	}
+
+	dir, name := path.Split(p)
+	treeID, err := restic.FindTreeDirectory(ctx, h.repo, sn.Tree, dir)
+	if err != nil {
+		return nil, nil, newHTTPError(http.StatusNotFound, "%v", err)
+	}
+	tree, err := restic.LoadTree(ctx, h.repo, *treeID)
+	if err != nil {
+		return nil, nil, err
+	}
+	node := tree.Find(name)
+	if node == nil {
+		return nil, nil, newHTTPError(http.StatusNotFound, "path %v: not found", p)
+	}
+	return node, treeID, nil
+}
+
+func (h *Handler) listSnapshots(w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+	filter := restic.SnapshotFilter{
+		Hosts: query["host"],
+		Paths: query["path"],
+	}
+	for _, tags := range query["tag"] {
+		var l restic.TagList
+		if err := l.Set(tags); err != nil {
+			return newHTTPError(http.StatusBadRequest, "invalid tag %q: %v", tags, err)
+		}
+		filter.Tags = append(filter.Tags, l)
+	}
+
+	groups, err := rapi.Snapshots(r.Context(), h.repo, filter, restic.SnapshotGroupByOptions{})
+	if err != nil {
+		return err
+	}
+	snapshots := []jsonout.Snapshot{}
+	for _, g := range groups {
+		snapshots = append(snapshots, jsonout.NewSnapshots(g.Snapshots)...)
+	}
+	writeJSON(w, http.StatusOK, snapshots)
+	return nil
+}
+
+func (h *Handler) ls(w http.ResponseWriter, r *http.Request, snapshot string) error {
+	ctx := r.Context()
+	sn, err := h.findSnapshot(ctx, snapshot)
+	if err != nil {
+		return err
+	}
+
+	query := r.URL.Query()
+	opts := rapi.LsOptions{Path: query.Get("path")}
+	if s := query.Get("recursive"); s != "" {
+		opts.Recursive, err = strconv.ParseBool(s)
+		if err != nil {
+			return newHTTPError(http.StatusBadRequest, "invalid recursive parameter %q", s)
+		}
+	}
+	node, _, err := h.findNode(ctx, sn, opts.Path)
+	if err != nil {
+		return err
+	}
+	if node.Type != "dir" {
+		return newHTTPError(http.StatusBadRequest, "path %v: not a directory", opts.Path)
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	return rapi.Ls(ctx, h.repo, *sn.ID(), opts, rapi.NewLsEncoder(w).Encode)
+}
+
+func (h *Handler) dump(w http.ResponseWriter, r *http.Request, snapshot string) error {
+	ctx := r.Context()
+	sn, err := h.findSnapshot(ctx, snapshot)
+	if err != nil {
+		return err
+	}
+	query := r.URL.Query()
+	node, _, err := h.findNode(ctx, sn, query.Get("path"))
+	if err != nil {
+		return err
+	}
+
+	name := node.Name
+	if name == "/" {
+		name = sn.ID().Str()
+	}
+	exp := export.New(h.repo)
+	switch {
+	case export.IsFile(node):
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.FormatUint(node.Size, 10))
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+		return exp.WriteContent(ctx, w, node)
+
+	case export.IsDir(node):
+		format := query.Get("format")
+		if format == "" {
+			format = "tar"
+		}
+		if format != "tar" && format != "zip" {
+			return newHTTPError(http.StatusBadRequest, "unsupported format %q", format)
+		}
+		enc, err := export.NewEncoder(format, w)
+		if err != nil {
+			return err
+		}
+		tree, err := restic.LoadTree(ctx, h.repo, *node.Subtree)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "application/"+format)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+"."+format))
+		return exp.Tree(ctx, tree, "/", enc)
+
+	default:
+		return newHTTPError(http.StatusBadRequest, "cannot download %v of type %v", node.Name, node.Type)
+	}
+}
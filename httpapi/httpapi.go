@@ -0,0 +1,216 @@
+// Package httpapi provides an http.Handler to browse the snapshots of a
+// repository, download files and run restores, for example to add a backup
+// browser to an existing service. All responses except downloads are JSON.
+//
+// The handler serves the following endpoints, snapshot is a snapshot ID, an
+// unambiguous prefix of it or "latest":
+//
+//	GET    /snapshots                         list snapshots, filtered by ?host=, ?tag= and ?path=
+//	GET    /snapshots/<snapshot>/ls?path=     list a directory as newline-delimited JSON, ?recursive=true
+//	GET    /snapshots/<snapshot>/dump?path=   download a file, or a directory as ?format=tar or zip
+//	POST   /restores                          start a restore job, see RestoreRequest
+//	GET    /restores                          list the restore jobs
+//	GET    /restores/<job>                    get a restore job
+//	DELETE /restores/<job>                    cancel a restore job
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/restic"
+)
+
+// Action is the kind of access a request needs.
+type Action string
+
+// Actions passed to Options.Authorize.
+const (
+	ActionList     Action = "list"
+	ActionBrowse   Action = "browse"
+	ActionDownload Action = "download"
+	ActionRestore  Action = "restore"
+)
+
+// ErrUnauthenticated is returned by Options.Authorize if the request does
+// not carry valid credentials, the handler then responds with 401 instead
+// of 403.
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// Options configure a Handler.
+type Options struct {
+	// Authorize is called for each request before it is served. If it
+	// returns an error, the request is rejected with 403 Forbidden, or with
+	// 401 Unauthorized for ErrUnauthenticated. If it is nil, all requests
+	// are allowed.
+	Authorize func(r *http.Request, action Action) error
+
+	// RestoreTarget returns the local directory to restore to for the
+	// target requested by the client, so that clients cannot write to
+	// arbitrary paths. If it is nil, restores are rejected.
+	RestoreTarget func(r *http.Request, target string) (string, error)
+}
+
+// Handler serves the API for a single repository. The index of the
+// repository must be loaded.
+type Handler struct {
+	repo restic.Repository
+	opts Options
+
+	// ctx is the parent context of the restore jobs, it is cancelled by
+	// Close
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu   sync.Mutex
+	jobs map[string]*restoreJob
+}
+
+var _ http.Handler = &Handler{}
+
+// New returns a Handler for repo.
+func New(repo restic.Repository, opts Options) *Handler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Handler{
+		repo:   repo,
+		opts:   opts,
+		ctx:    ctx,
+		cancel: cancel,
+		jobs:   make(map[string]*restoreJob),
+	}
+}
+
+// Close cancels the running restore jobs and waits for them to finish.
+func (h *Handler) Close() {
+	h.cancel()
+	h.wg.Wait()
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "snapshots":
+		h.serve(w, r, http.MethodGet, ActionList, h.listSnapshots)
+	case len(parts) == 3 && parts[0] == "snapshots" && parts[2] == "ls":
+		h.serve(w, r, http.MethodGet, ActionBrowse, func(w http.ResponseWriter, r *http.Request) error {
+			return h.ls(w, r, parts[1])
+		})
+	case len(parts) == 3 && parts[0] == "snapshots" && parts[2] == "dump":
+		h.serve(w, r, http.MethodGet, ActionDownload, func(w http.ResponseWriter, r *http.Request) error {
+			return h.dump(w, r, parts[1])
+		})
+	case len(parts) == 1 && parts[0] == "restores" && r.Method == http.MethodPost:
+		h.serve(w, r, http.MethodPost, ActionRestore, h.startRestore)
+	case len(parts) == 1 && parts[0] == "restores":
+		h.serve(w, r, http.MethodGet, ActionRestore, h.listRestores)
+	case len(parts) == 2 && parts[0] == "restores" && r.Method == http.MethodDelete:
+		h.serve(w, r, http.MethodDelete, ActionRestore, func(w http.ResponseWriter, r *http.Request) error {
+			return h.cancelRestore(w, parts[1])
+		})
+	case len(parts) == 2 && parts[0] == "restores":
+		h.serve(w, r, http.MethodGet, ActionRestore, func(w http.ResponseWriter, r *http.Request) error {
+			return h.getRestore(w, parts[1])
+		})
+	default:
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
+
+// httpError is an error with the status code of the response.
+type httpError struct {
+	code int
+	err  error
+}
+
+func (e *httpError) Error() string {
+	return e.err.Error()
+}
+
+func newHTTPError(code int, format string, args ...interface{}) error {
+	return &httpError{code: code, err: errors.Errorf(format, args...)}
+}
+
+// serve checks the method and authorization of r and calls fn. Errors
+// returned by fn are sent as JSON unless the response was started.
+func (h *Handler) serve(w http.ResponseWriter, r *http.Request, method string, action Action, fn func(http.ResponseWriter, *http.Request) error) {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeError(w, http.StatusMethodNotAllowed, errors.Errorf("method %v not allowed", r.Method))
+		return
+	}
+	if h.opts.Authorize != nil {
+		if err := h.opts.Authorize(r, action); err != nil {
+			code := http.StatusForbidden
+			if errors.Is(err, ErrUnauthenticated) {
+				code = http.StatusUnauthorized
+			}
+			writeError(w, code, err)
+			return
+		}
+	}
+
+	rw := &responseWriter{ResponseWriter: w}
+	err := fn(rw, r)
+	if err == nil {
+		return
+	}
+	debug.Log("%v %v failed: %v", r.Method, r.URL, err)
+	if rw.started {
+		// the status was already sent, the client sees a truncated response
+		return
+	}
+
+	code := http.StatusInternalServerError
+	var he *httpError
+	switch {
+	case errors.As(err, &he):
+		code = he.code
+	case errors.Is(err, restic.ErrNoSnapshotFound):
+		code = http.StatusNotFound
+	case errors.IsFatal(err):
+		code = http.StatusBadRequest
+	}
+	writeError(w, code, err)
+}
+
+// responseWriter records whether the response was started.
+type responseWriter struct {
+	http.ResponseWriter
+	started bool
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	w.started = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	w.started = true
+	return w.ResponseWriter.Write(p)
+}
+
+type errorResponse struct {
+	Message string `json:"message"`
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	// drop the headers of a download which failed before it started
+	w.Header().Del("Content-Length")
+	w.Header().Del("Content-Disposition")
+	writeJSON(w, code, errorResponse{Message: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		debug.Log("unable to write response: %v", err)
+	}
+}
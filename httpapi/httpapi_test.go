@@ -0,0 +1,160 @@
+package httpapi_test
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/konidev20/rapi"
+	"github.com/konidev20/rapi/httpapi"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/restic"
+	"github.com/konidev20/rapi/ui/jsonout"
+)
+
+func get(t *testing.T, h http.Handler, method, url string, body io.Reader) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, body)
+	req.Header.Set("Authorization", "secret")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestHandler(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.TestRepository(t)
+
+	b, err := rapi.NewSnapshotBuilder(ctx, repo)
+	rtest.OK(t, err)
+	rtest.OK(t, b.AddFile("/dir/foo", nil, strings.NewReader("foo data")))
+	rtest.OK(t, b.AddFile("/dir/sub/bar", nil, strings.NewReader("bar data")))
+	sn, err := restic.NewSnapshot(nil, nil, "host", time.Now())
+	rtest.OK(t, err)
+	id, err := b.Finish(sn)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.LoadIndex(ctx, nil))
+
+	restoreDir := rtest.TempDir(t)
+	h := httpapi.New(repo, httpapi.Options{
+		Authorize: func(r *http.Request, action httpapi.Action) error {
+			if r.Header.Get("Authorization") != "secret" {
+				return httpapi.ErrUnauthenticated
+			}
+			if action == httpapi.ActionRestore && r.Method == http.MethodDelete {
+				return errors.New("cancelling restores is not allowed")
+			}
+			return nil
+		},
+		RestoreTarget: func(_ *http.Request, target string) (string, error) {
+			return filepath.Join(restoreDir, filepath.Base(target)), nil
+		},
+	})
+	defer h.Close()
+
+	// authentication
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/snapshots", nil))
+	rtest.Equals(t, http.StatusUnauthorized, w.Code)
+
+	w = get(t, h, http.MethodGet, "/snapshots?host=host", nil)
+	rtest.Equals(t, http.StatusOK, w.Code)
+	var snapshots []jsonout.Snapshot
+	rtest.OK(t, json.Unmarshal(w.Body.Bytes(), &snapshots))
+	rtest.Equals(t, 1, len(snapshots))
+	rtest.Equals(t, id, *snapshots[0].ID)
+
+	w = get(t, h, http.MethodGet, "/snapshots?host=other", nil)
+	rtest.Equals(t, "[]\n", w.Body.String())
+
+	// browse
+	w = get(t, h, http.MethodGet, "/snapshots/latest/ls?path=/dir&recursive=true", nil)
+	rtest.Equals(t, http.StatusOK, w.Code)
+	var paths []string
+	sc := bufio.NewScanner(w.Body)
+	for sc.Scan() {
+		var entry struct{ Path string }
+		rtest.OK(t, json.Unmarshal(sc.Bytes(), &entry))
+		paths = append(paths, entry.Path)
+	}
+	rtest.Equals(t, []string{"/dir/foo", "/dir/sub", "/dir/sub/bar"}, paths)
+
+	w = get(t, h, http.MethodGet, "/snapshots/latest/ls?path=/missing", nil)
+	rtest.Equals(t, http.StatusNotFound, w.Code)
+	w = get(t, h, http.MethodGet, "/snapshots/"+restic.NewRandomID().String()+"/ls", nil)
+	rtest.Equals(t, http.StatusNotFound, w.Code)
+	w = get(t, h, http.MethodPost, "/snapshots", nil)
+	rtest.Equals(t, http.StatusMethodNotAllowed, w.Code)
+
+	// download
+	w = get(t, h, http.MethodGet, "/snapshots/"+id.Str()+"/dump?path=/dir/sub/bar", nil)
+	rtest.Equals(t, http.StatusOK, w.Code)
+	rtest.Equals(t, "bar data", w.Body.String())
+	rtest.Equals(t, `attachment; filename="bar"`, w.Header().Get("Content-Disposition"))
+
+	w = get(t, h, http.MethodGet, "/snapshots/latest/dump?path=/dir", nil)
+	rtest.Equals(t, http.StatusOK, w.Code)
+	var names []string
+	tr := tar.NewReader(w.Body)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		rtest.OK(t, err)
+		names = append(names, hdr.Name)
+	}
+	rtest.Equals(t, []string{"foo", "sub/", "sub/bar"}, names)
+
+	// restore
+	body, err := json.Marshal(httpapi.RestoreRequest{Snapshot: "latest", Path: "/dir/sub/bar", Target: "job"})
+	rtest.OK(t, err)
+	w = get(t, h, http.MethodPost, "/restores", bytes.NewReader(body))
+	rtest.Equals(t, http.StatusAccepted, w.Code)
+	var job httpapi.RestoreJob
+	rtest.OK(t, json.Unmarshal(w.Body.Bytes(), &job))
+
+	for job.State == httpapi.RestoreRunning {
+		time.Sleep(10 * time.Millisecond)
+		w = get(t, h, http.MethodGet, "/restores/"+job.ID, nil)
+		rtest.Equals(t, http.StatusOK, w.Code)
+		job = httpapi.RestoreJob{}
+		rtest.OK(t, json.Unmarshal(w.Body.Bytes(), &job))
+	}
+	rtest.Equals(t, httpapi.RestoreDone, job.State)
+	buf, err := os.ReadFile(filepath.Join(restoreDir, "job", "bar"))
+	rtest.OK(t, err)
+	rtest.Equals(t, "bar data", string(buf))
+	entries, err := os.ReadDir(filepath.Join(restoreDir, "job"))
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, len(entries))
+
+	w = get(t, h, http.MethodGet, "/restores", nil)
+	var jobs []httpapi.RestoreJob
+	rtest.OK(t, json.Unmarshal(w.Body.Bytes(), &jobs))
+	rtest.Equals(t, 1, len(jobs))
+
+	w = get(t, h, http.MethodDelete, "/restores/"+job.ID, nil)
+	rtest.Equals(t, http.StatusForbidden, w.Code)
+	w = get(t, h, http.MethodGet, "/restores/unknown", nil)
+	rtest.Equals(t, http.StatusNotFound, w.Code)
+}
+
+func TestHandlerRestoreDisabled(t *testing.T) {
+	h := httpapi.New(repository.TestRepository(t), httpapi.Options{})
+	defer h.Close()
+
+	w := get(t, h, http.MethodPost, "/restores", strings.NewReader(`{"snapshot": "latest", "target": "/tmp"}`))
+	rtest.Equals(t, http.StatusForbidden, w.Code)
+}
@@ -0,0 +1,217 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/konidev20/rapi"
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/restorer"
+	"github.com/konidev20/rapi/restic"
+	"github.com/konidev20/rapi/ui/jsonout"
+	restoreui "github.com/konidev20/rapi/ui/restore"
+)
+
+// RestoreRequest is the body of a request to start a restore job. Path is
+// the directory or file within the snapshot which is restored, by default
+// the whole snapshot. Target is passed to Options.RestoreTarget.
+type RestoreRequest struct {
+	Snapshot string `json:"snapshot"`
+	Path     string `json:"path,omitempty"`
+	Target   string `json:"target"`
+}
+
+// Restore job states.
+const (
+	RestoreRunning   = "running"
+	RestoreDone      = "done"
+	RestoreFailed    = "failed"
+	RestoreCancelled = "cancelled"
+)
+
+// RestoreJob describes a restore job.
+type RestoreJob struct {
+	ID       string                `json:"id"`
+	Snapshot string                `json:"snapshot"`
+	Path     string                `json:"path,omitempty"`
+	Target   string                `json:"target"`
+	State    string                `json:"state"`
+	Error    string                `json:"error,omitempty"`
+	Progress jsonout.RestoreStatus `json:"progress"`
+	Started  time.Time             `json:"started"`
+	Finished *time.Time            `json:"finished,omitempty"`
+}
+
+type restoreJob struct {
+	cancel context.CancelFunc
+
+	// mu protects job
+	mu  sync.Mutex
+	job RestoreJob
+}
+
+func (j *restoreJob) get() RestoreJob {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.job
+}
+
+// Update implements restoreui.ProgressPrinter.
+func (j *restoreJob) Update(filesFinished, filesTotal, allBytesWritten, allBytesTotal uint64, duration time.Duration) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.job.Progress = restoreui.NewJSONStatus(filesFinished, filesTotal, allBytesWritten, allBytesTotal, duration)
+}
+
+// Finish implements restoreui.ProgressPrinter.
+func (j *restoreJob) Finish(filesFinished, filesTotal, allBytesWritten, allBytesTotal uint64, duration time.Duration) {
+	j.Update(filesFinished, filesTotal, allBytesWritten, allBytesTotal, duration)
+}
+
+func (j *restoreJob) finish(ctx context.Context, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	j.job.Finished = &now
+	switch {
+	case err == nil:
+		j.job.State = RestoreDone
+	case ctx.Err() != nil:
+		j.job.State = RestoreCancelled
+	default:
+		j.job.State = RestoreFailed
+		j.job.Error = err.Error()
+	}
+}
+
+func (h *Handler) startRestore(w http.ResponseWriter, r *http.Request) error {
+	if h.opts.RestoreTarget == nil {
+		return newHTTPError(http.StatusForbidden, "restores are disabled")
+	}
+	var req RestoreRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return newHTTPError(http.StatusBadRequest, "invalid request: %v", err)
+	}
+	target, err := h.opts.RestoreTarget(r, req.Target)
+	if err != nil {
+		return newHTTPError(http.StatusForbidden, "%v", err)
+	}
+
+	sn, err := h.findSnapshot(r.Context(), req.Snapshot)
+	if err != nil {
+		return err
+	}
+	node, parent, err := h.findNode(r.Context(), sn, req.Path)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(h.ctx)
+	job := &restoreJob{
+		cancel: cancel,
+		job: RestoreJob{
+			ID:       restic.NewRandomID().String(),
+			Snapshot: sn.ID().String(),
+			Path:     req.Path,
+			Target:   target,
+			State:    RestoreRunning,
+			Started:  time.Now(),
+		},
+	}
+
+	h.mu.Lock()
+	h.jobs[job.job.ID] = job
+	h.mu.Unlock()
+
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		defer cancel()
+		err := h.restore(ctx, job, sn, node, parent, target)
+		if err != nil {
+			debug.Log("restore job %v failed: %v", job.job.ID, err)
+		}
+		job.finish(ctx, err)
+	}()
+
+	writeJSON(w, http.StatusAccepted, job.get())
+	return nil
+}
+
+// restore restores node of the snapshot sn, which is stored in the tree
+// parent, to target. The contents of a directory are placed directly in
+// target, a file is restored into target.
+func (h *Handler) restore(ctx context.Context, job *restoreJob, sn *restic.Snapshot, node *restic.Node, parent *restic.ID, target string) error {
+	unlock, err := rapi.LockRepository(ctx, h.repo, false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	restored := *sn
+	if node.Type == "dir" {
+		restored.Tree = node.Subtree
+	} else {
+		restored.Tree = parent
+	}
+
+	progress := restoreui.NewProgress(job, time.Second)
+	res := restorer.NewRestorer(h.repo, &restored, false, progress)
+	if node.Type != "dir" {
+		// only restore the node from the tree which contains it
+		res.SelectFilter = func(item string, _ string, _ *restic.Node) (bool, bool) {
+			return item == "/"+node.Name, false
+		}
+	}
+	err = res.RestoreTo(ctx, target)
+	progress.Finish()
+	return err
+}
+
+func (h *Handler) listRestores(w http.ResponseWriter, _ *http.Request) error {
+	h.mu.Lock()
+	jobs := make([]RestoreJob, 0, len(h.jobs))
+	for _, job := range h.jobs {
+		jobs = append(jobs, job.get())
+	}
+	h.mu.Unlock()
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Started.Before(jobs[j].Started)
+	})
+	writeJSON(w, http.StatusOK, jobs)
+	return nil
+}
+
+func (h *Handler) lookupRestore(id string) (*restoreJob, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	job, ok := h.jobs[id]
+	if !ok {
+		return nil, newHTTPError(http.StatusNotFound, "unknown restore job %q", id)
+	}
+	return job, nil
+}
+
+func (h *Handler) getRestore(w http.ResponseWriter, id string) error {
+	job, err := h.lookupRestore(id)
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, job.get())
+	return nil
+}
+
+func (h *Handler) cancelRestore(w http.ResponseWriter, id string) error {
+	job, err := h.lookupRestore(id)
+	if err != nil {
+		return err
+	}
+	job.cancel()
+	writeJSON(w, http.StatusOK, job.get())
+	return nil
+}
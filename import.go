@@ -0,0 +1,129 @@
+package rapi
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/internal/fs"
+)
+
+// ImportSource is a Source for a tree exported from another backup program.
+// It is backed up by passing it as BackupOptions.Source, the targets are
+// absolute paths in the exported tree, for example "/" for all files. The
+// export is stored in a temporary tar file which is removed by Close. Files
+// with identical contents are deduplicated when they are backed up, hard
+// links in the export are read from the same data.
+type ImportSource struct {
+	*fs.TarSource
+	f      *os.File
+	remove string
+}
+
+// statically ensure that ImportSource implements Source.
+var _ Source = &ImportSource{}
+
+// NewTarImportSource returns a Source for an uncompressed tar archive which is
+// read from rd. The archive is copied to a temporary file in tempDir, or the
+// default directory for temporary files if tempDir is empty.
+func NewTarImportSource(rd io.Reader, tempDir string) (*ImportSource, error) {
+	f, err := os.CreateTemp(tempDir, "rapi-import-*.tar")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	src := &ImportSource{f: f, remove: f.Name()}
+
+	size, err := io.Copy(f, rd)
+	if err != nil {
+		_ = src.Close()
+		return nil, errors.Wrap(err, "Copy")
+	}
+
+	src.TarSource, err = fs.NewTarSource(f, size)
+	if err != nil {
+		_ = src.Close()
+		return nil, err
+	}
+	return src, nil
+}
+
+// NewBorgSource exports the archive of the Borg repository with `borg
+// export-tar` and returns a Source for its contents. The borg binary must be
+// in the search path, passphrases are passed through the environment as
+// usual, for example BORG_PASSPHRASE. Output of borg is written to logOutput.
+func NewBorgSource(ctx context.Context, repository, archive string, logOutput io.Writer) (*ImportSource, error) {
+	args := []string{"borg", "export-tar", repository + "::" + archive, "-"}
+	rd, err := fs.NewCommandReader(ctx, args, logOutput)
+	if err != nil {
+		return nil, err
+	}
+
+	src, err := NewTarImportSource(rd, "")
+	if cerr := rd.Close(); err == nil && cerr != nil {
+		_ = src.Close()
+		return nil, cerr
+	}
+	return src, err
+}
+
+// NewKopiaSource restores the Kopia snapshot or object to a temporary tar
+// file with `kopia restore` and returns a Source for its contents. The kopia
+// binary must be in the search path and connected to the repository. Output
+// of kopia is written to logOutput.
+func NewKopiaSource(ctx context.Context, snapshot string, logOutput io.Writer) (*ImportSource, error) {
+	dir, err := os.MkdirTemp("", "rapi-import-")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	src := &ImportSource{remove: dir}
+
+	// kopia writes a tar archive if the target ends with .tar
+	target := filepath.Join(dir, "export.tar")
+	args := []string{"kopia", "restore", snapshot, target}
+	rd, err := fs.NewCommandReader(ctx, args, logOutput)
+	if err != nil {
+		_ = src.Close()
+		return nil, err
+	}
+	_, err = io.Copy(logOutput, rd)
+	if cerr := rd.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = src.Close()
+		return nil, err
+	}
+
+	src.f, err = os.Open(target)
+	if err != nil {
+		_ = src.Close()
+		return nil, errors.WithStack(err)
+	}
+	fi, err := src.f.Stat()
+	if err != nil {
+		_ = src.Close()
+		return nil, errors.WithStack(err)
+	}
+
+	src.TarSource, err = fs.NewTarSource(src.f, fi.Size())
+	if err != nil {
+		_ = src.Close()
+		return nil, err
+	}
+	return src, nil
+}
+
+// Close removes the temporary file.
+func (s *ImportSource) Close() error {
+	var err error
+	if s.f != nil {
+		err = s.f.Close()
+	}
+	if rerr := os.RemoveAll(s.remove); rerr != nil {
+		debug.Log("unable to remove %v: %v", s.remove, rerr)
+	}
+	return err
+}
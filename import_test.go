@@ -0,0 +1,78 @@
+package rapi_test
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/konidev20/rapi"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/repository"
+)
+
+// writeTestTar writes a tar archive with the given files to a file in dir.
+func writeTestTar(t *testing.T, dir string, files map[string]string) string {
+	name := filepath.Join(dir, "export.tar")
+	f, err := os.Create(name)
+	rtest.OK(t, err)
+	tw := tar.NewWriter(f)
+	for path, content := range files {
+		rtest.OK(t, tw.WriteHeader(&tar.Header{Name: path, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))}))
+		_, err := io.WriteString(tw, content)
+		rtest.OK(t, err)
+	}
+	rtest.OK(t, tw.Close())
+	rtest.OK(t, f.Close())
+	return name
+}
+
+// installFakeCommand puts a shell script with the given name in front of the
+// search path.
+func installFakeCommand(t *testing.T, name, script string) {
+	dir := rtest.TempDir(t)
+	rtest.OK(t, os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script+"\n"), 0755))
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestImportSources(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake commands are shell scripts")
+	}
+
+	archive := writeTestTar(t, rtest.TempDir(t), map[string]string{
+		"home/user/a":     "content of a",
+		"home/user/sub/b": "content of b",
+	})
+	installFakeCommand(t, "borg", `test "$1 $2 $3" = "export-tar /repo::archive -" && exec cat '`+archive+`'`)
+	installFakeCommand(t, "kopia", `test "$1 $2" = "restore k123" && exec cp '`+archive+`' "$3"`)
+
+	for name, open := range map[string]func(ctx context.Context) (*rapi.ImportSource, error){
+		"borg": func(ctx context.Context) (*rapi.ImportSource, error) {
+			return rapi.NewBorgSource(ctx, "/repo", "archive", io.Discard)
+		},
+		"kopia": func(ctx context.Context) (*rapi.ImportSource, error) {
+			return rapi.NewKopiaSource(ctx, "k123", io.Discard)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			repo := repository.TestRepository(t)
+
+			src, err := open(ctx)
+			rtest.OK(t, err)
+			defer func() {
+				rtest.OK(t, src.Close())
+			}()
+
+			res, err := rapi.Backup(ctx, repo, []string{"/home"}, rapi.BackupOptions{Hostname: "test", Source: src})
+			rtest.OK(t, err)
+			rtest.Equals(t, []string{"/home"}, res.Snapshot.Paths)
+			rtest.Equals(t, "content of a", loadFileContent(t, repo, res.ID, "/home/user/a"))
+			rtest.Equals(t, "content of b", loadFileContent(t, repo, res.ID, "/home/user/sub/b"))
+		})
+	}
+}
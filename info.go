@@ -0,0 +1,112 @@
+package rapi
+
+import (
+	"runtime"
+	"runtime/debug"
+
+	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/internal/options"
+	"github.com/konidev20/rapi/restic"
+)
+
+// modulePath is the import path of this library.
+const modulePath = "github.com/konidev20/rapi"
+
+// LibraryInfo describes the build of the library linked into the program, so
+// that applications can adapt to it at runtime.
+type LibraryInfo struct {
+	// Version is the module version of the library, "(devel)" if it is
+	// not known, for example in tests or builds of a local checkout.
+	Version   string `json:"version"`
+	GoVersion string `json:"go_version"`
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+
+	Repository RepositoryVersions `json:"repository"`
+	Backends   []BackendInfo      `json:"backends"`
+}
+
+// RepositoryVersions lists the repository format versions and features
+// which can be used with the library.
+type RepositoryVersions struct {
+	// MinVersion and MaxVersion are the repository versions which can be
+	// opened, DefaultVersion is used by Init if no version is given.
+	MinVersion     uint `json:"min_version"`
+	MaxVersion     uint `json:"max_version"`
+	DefaultVersion uint `json:"default_version"`
+
+	// Features are the optional repository features which are supported.
+	Features []restic.Feature `json:"features"`
+}
+
+// BackendInfo describes a supported type of backend.
+type BackendInfo struct {
+	// Scheme is the prefix of repository locations, e.g. "s3" for
+	// "s3:host/bucket". Local paths use the scheme "local".
+	Scheme       string               `json:"scheme"`
+	Capabilities backend.Capabilities `json:"capabilities"`
+	// Options are the extended options of the backend, they are passed as
+	// "scheme.name=value" in RepositoryOptions.Options.
+	Options []BackendOption `json:"options"`
+}
+
+// BackendOption is an extended option of a backend.
+type BackendOption struct {
+	Name string `json:"name"`
+	Help string `json:"help"`
+}
+
+// Info returns the version and the capabilities of the library.
+func Info() LibraryInfo {
+	info := LibraryInfo{
+		Version:   libraryVersion(),
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		Repository: RepositoryVersions{
+			MinVersion:     restic.MinRepoVersion,
+			MaxVersion:     restic.MaxRepoVersion,
+			DefaultVersion: restic.StableRepoVersion,
+			Features:       restic.SupportedFeatures(),
+		},
+	}
+
+	opts := options.List()
+	for _, scheme := range DefaultOptions.backends.Schemes() {
+		b := BackendInfo{
+			Scheme:       scheme,
+			Capabilities: DefaultOptions.backends.Lookup(scheme).Capabilities(),
+			Options:      []BackendOption{},
+		}
+		for _, opt := range opts {
+			if opt.Namespace == scheme {
+				b.Options = append(b.Options, BackendOption{Name: opt.Name, Help: opt.Text})
+			}
+		}
+		info.Backends = append(info.Backends, b)
+	}
+
+	return info
+}
+
+// libraryVersion returns the version of this module from the build
+// information of the program.
+func libraryVersion() string {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "(devel)"
+	}
+	if bi.Main.Path == modulePath && bi.Main.Version != "" {
+		return bi.Main.Version
+	}
+	for _, dep := range bi.Deps {
+		if dep.Path != modulePath {
+			continue
+		}
+		if dep.Replace != nil && dep.Replace.Version != "" {
+			return dep.Replace.Version
+		}
+		return dep.Version
+	}
+	return "(devel)"
+}
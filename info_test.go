@@ -0,0 +1,54 @@
+package rapi_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/konidev20/rapi"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/restic"
+	"github.com/konidev20/rapi/tuning"
+)
+
+func TestInfo(t *testing.T) {
+	info := rapi.Info()
+	rtest.Assert(t, info.Version != "", "version is empty")
+	rtest.Equals(t, uint(restic.MaxRepoVersion), info.Repository.MaxVersion)
+	rtest.Equals(t, restic.SupportedFeatures(), info.Repository.Features)
+
+	backends := make(map[string]rapi.BackendInfo)
+	for _, b := range info.Backends {
+		backends[b.Scheme] = b
+	}
+	for _, scheme := range []string{"local", "rest", "s3", "sftp"} {
+		_, ok := backends[scheme]
+		rtest.Assert(t, ok, "backend %v is missing", scheme)
+	}
+
+	// the tuning classes are derived from the capabilities
+	for scheme, want := range map[string]tuning.BackendClass{
+		"local":  tuning.BackendLocal,
+		"sftp":   tuning.BackendServer,
+		"rest":   tuning.BackendServer,
+		"rclone": tuning.BackendServer,
+		"s3":     tuning.BackendObjectStore,
+		"gs":     tuning.BackendObjectStore,
+		"azure":  tuning.BackendObjectStore,
+		"b2":     tuning.BackendObjectStore,
+		"swift":  tuning.BackendObjectStore,
+	} {
+		rtest.Equals(t, want, tuning.Classify(backends[scheme].Capabilities))
+	}
+
+	var found bool
+	for _, opt := range backends["s3"].Options {
+		if opt.Name == "connections" {
+			found = true
+			rtest.Assert(t, opt.Help != "", "no help for s3.connections")
+		}
+	}
+	rtest.Assert(t, found, "option s3.connections is missing")
+
+	_, err := json.Marshal(info)
+	rtest.OK(t, err)
+}
@@ -6,17 +6,22 @@ import (
 	"path"
 	"runtime"
 	"sort"
+	"sync"
 	"time"
 
 	"github.com/konidev20/rapi/internal/debug"
 	"github.com/konidev20/rapi/internal/errors"
 	"github.com/konidev20/rapi/internal/fs"
+	"github.com/konidev20/rapi/internal/tracing"
 	"github.com/konidev20/rapi/restic"
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/sync/errgroup"
 )
 
 // SelectByNameFunc returns true for all items that should be included (files and
 // dirs). If false is returned, files are ignored and dirs are not even walked.
+// It is called concurrently while directories are listed ahead of the
+// archiver, see Options.ScanConcurrency.
 type SelectByNameFunc func(item string) bool
 
 // SelectFunc returns true for all items that should be included (files and
@@ -56,13 +61,40 @@ type Archiver struct {
 	FS           fs.FS
 	Options      Options
 
-	blobSaver *BlobSaver
-	fileSaver *FileSaver
-	treeSaver *TreeSaver
+	blobSaver  *BlobSaver
+	fileSaver  *FileSaver
+	treeSaver  *TreeSaver
+	dirScanner *dirScanner
+
+	// deviceFilter is set while a snapshot with OneFileSystem is running.
+	deviceFilter *DeviceFilter
+
+	// markerFilter and noDump are set while a snapshot excluding marked
+	// directories or files with the nodump flag is running.
+	markerFilter *MarkerFilter
+	noDump       bool
+
+	// targetLinks is set while a snapshot following symlinked targets is
+	// running.
+	targetLinks *targetLinks
+
+	// newest is the newest modification time of the saved items, it is
+	// only tracked for deterministic snapshots.
+	newestMu sync.Mutex
+	newest   time.Time
+
+	// summary collects the statistics of the running snapshot, they are
+	// stored in the snapshot.
+	summaryMu sync.Mutex
+	summary   *restic.SnapshotSummary
 
 	// Error is called for all errors that occur during backup.
 	Error ErrorFunc
 
+	// Warnings collects the errors for which Error returned nil and the
+	// extended attributes which could not be read, may be nil.
+	Warnings *restic.Warnings
+
 	// CompleteItem is called for all files and dirs once they have been
 	// processed successfully. The parameter item contains the path as it will
 	// be in the snapshot after saving. s contains some statistics about this
@@ -81,11 +113,33 @@ type Archiver struct {
 	// CompleteBlob is called for all saved blobs for files.
 	CompleteBlob func(bytes uint64)
 
+	// ScanContent returns a scanner which receives the contents of each
+	// file while it is read, see ContentScanFunc. Files which are unchanged
+	// since the parent snapshot are not read and therefore not scanned.
+	ScanContent ContentScanFunc
+
+	// Flagged is called for files which a scanner flagged or skipped. It
+	// may be called asynchronously from several different goroutines.
+	Flagged func(item string, v ScanVerdict)
+
+	// Stages records the time spent in each stage of a snapshot if it is not
+	// nil, see StageReport.
+	Stages *restic.StageTimes
+
 	// WithAtime configures if the access time for files and directories should
 	// be saved. Enabling it may result in much metadata, so it's off by
 	// default.
 	WithAtime bool
 
+	// WithAlternateDataStreams configures if the alternate data streams of
+	// files and directories on Windows are saved as extended attributes
+	// with the prefix fs.AlternateDataStreamPrefix.
+	WithAlternateDataStreams bool
+
+	// SpecialFiles configures whether devices, FIFOs and sockets are saved,
+	// skipped or reported as errors. By default, sockets are skipped.
+	SpecialFiles restic.SpecialFilePolicy
+
 	// Flags controlling change detection. See doc/040_backup.rst for details.
 	ChangeIgnoreFlags uint
 }
@@ -98,24 +152,57 @@ const (
 
 // Options is used to configure the archiver.
 type Options struct {
-	// ReadConcurrency sets how many files are read in concurrently. If
-	// it's set to zero, at most two files are read in concurrently (which
-	// turned out to be a good default for most situations).
+	// ReadConcurrency sets how many files are read in concurrently, each
+	// file is split into chunks by its own goroutine. If it's set to zero,
+	// at most two files are read in concurrently (which turned out to be a
+	// good default for most situations).
 	ReadConcurrency uint
 
-	// SaveBlobConcurrency sets how many blobs are hashed and saved
-	// concurrently. If it's set to zero, the default is the number of CPUs
-	// available in the system.
+	// SaveBlobConcurrency sets how many blobs are hashed, compressed and
+	// saved concurrently. If it's set to zero, the default is the number of
+	// CPUs available in the system. Lower it to limit the CPU usage of a
+	// backup.
 	SaveBlobConcurrency uint
 
 	// SaveTreeConcurrency sets how many trees are marshalled and saved to the
 	// repo concurrently.
 	SaveTreeConcurrency uint
+
+	// ReadAheadSize is the size of the buffer each file is read into before
+	// it is split into chunks. Larger reads can reduce the number of IO
+	// operations on slow devices. Zero means files are read directly, other
+	// values are raised to at least MinReadAheadSize.
+	ReadAheadSize uint
+
+	// ScanConcurrency sets how many directories are listed concurrently
+	// ahead of the archiver, including running Lstat for their items. If
+	// it's set to zero, the default is four. SelectByName and the file
+	// system must be safe for concurrent use.
+	ScanConcurrency uint
+
+	// Deterministic reads files, saves blobs and saves trees one at a time,
+	// so that blobs are added to the pack files in the same order for the
+	// same input, and takes the time of the snapshot from the newest
+	// modification time of the saved items unless SnapshotOptions.Time is
+	// set. It should be combined with a deterministic repository, see
+	// repository.Options. The concurrency options are ignored.
+	Deterministic bool
 }
 
+// MinReadAheadSize is the smallest useful read-ahead buffer. The chunker
+// reads 512 KiB at a time, reads of at least the buffer size bypass the
+// buffer.
+const MinReadAheadSize = 1 << 20
+
 // ApplyDefaults returns a copy of o with the default options set for all unset
 // fields.
 func (o Options) ApplyDefaults() Options {
+	if o.Deterministic {
+		o.ReadConcurrency = 1
+		o.SaveBlobConcurrency = 1
+		o.SaveTreeConcurrency = 1
+	}
+
 	if o.ReadConcurrency == 0 {
 		// two is a sweet spot for almost all situations. We've done some
 		// experiments documented here:
@@ -138,6 +225,16 @@ func (o Options) ApplyDefaults() Options {
 		o.SaveTreeConcurrency = uint(runtime.GOMAXPROCS(0)) + o.ReadConcurrency
 	}
 
+	if o.ScanConcurrency == 0 {
+		// listing directories is bound by the latency of the file system,
+		// a few concurrent requests already hide most of it
+		o.ScanConcurrency = 4
+	}
+
+	if o.ReadAheadSize > 0 && o.ReadAheadSize < MinReadAheadSize {
+		o.ReadAheadSize = MinReadAheadSize
+	}
+
 	return o
 }
 
@@ -172,20 +269,107 @@ func (arch *Archiver) error(item string, err error) error {
 	if err != errf {
 		debug.Log("item %v: error was filtered by handler, before: %q, after: %v", item, err, errf)
 	}
+	if errf == nil {
+		arch.Warnings.Add(item, err)
+	}
 	return errf
 }
 
+// trackItem adds the item to the summary of the running snapshot and calls
+// CompleteItem.
+func (arch *Archiver) trackItem(item string, previous, current *restic.Node, s ItemStats, d time.Duration) {
+	arch.CompleteItem(item, previous, current, s, d)
+
+	arch.summaryMu.Lock()
+	defer arch.summaryMu.Unlock()
+	if arch.summary == nil {
+		return
+	}
+
+	sum := arch.summary
+	sum.DataBlobs += s.DataBlobs
+	sum.TreeBlobs += s.TreeBlobs
+	sum.DataAdded += s.DataSize + s.TreeSize
+	sum.DataAddedPacked += s.DataSizeInRepo + s.TreeSizeInRepo
+	if current == nil {
+		// the file could not be read or this is the root of the snapshot
+		return
+	}
+
+	switch current.Type {
+	case "dir":
+		switch {
+		case previous == nil:
+			sum.DirsNew++
+		case previous.Equals(*current):
+			sum.DirsUnmodified++
+		default:
+			sum.DirsChanged++
+		}
+
+	case "file":
+		sum.TotalFilesProcessed++
+		sum.TotalBytesProcessed += current.Size
+		switch {
+		case previous == nil:
+			sum.FilesNew++
+		case previous.Equals(*current):
+			sum.FilesUnmodified++
+		default:
+			sum.FilesChanged++
+		}
+	}
+}
+
 // nodeFromFileInfo returns the restic node from an os.FileInfo.
 func (arch *Archiver) nodeFromFileInfo(snPath, filename string, fi os.FileInfo) (*restic.Node, error) {
 	node, err := restic.NodeFromFileInfo(filename, fi)
+	var xattrErr *restic.XattrError
+	if errors.As(err, &xattrErr) {
+		// the node is usable without the extended attributes
+		arch.Warnings.Add(filename, err)
+		err = nil
+	}
+	if err == nil && node.Type == "symlink" && node.LinkTarget == "" {
+		// file systems without syscall information resolve symlinks themselves
+		if rl, ok := arch.FS.(fs.Readlinker); ok {
+			node.LinkTarget, err = rl.Readlink(filename)
+		}
+	}
+	if err == nil && arch.WithAlternateDataStreams && (node.Type == "file" || node.Type == "dir") {
+		err = arch.fillAlternateDataStreams(node, filename)
+	}
 	if !arch.WithAtime {
 		node.AccessTime = node.ModTime
 	}
+	if err == nil && arch.Options.Deterministic {
+		arch.newestMu.Lock()
+		if node.ModTime.After(arch.newest) {
+			arch.newest = node.ModTime
+		}
+		arch.newestMu.Unlock()
+	}
 	// overwrite name to match that within the snapshot
 	node.Name = path.Base(snPath)
 	return node, errors.WithStack(err)
 }
 
+// fillAlternateDataStreams adds the alternate data streams of filename to the
+// extended attributes of node.
+func (arch *Archiver) fillAlternateDataStreams(node *restic.Node, filename string) error {
+	streams, err := fs.ReadAlternateDataStreams(filename)
+	if err != nil {
+		return err
+	}
+	for _, stream := range streams {
+		node.ExtendedAttributes = append(node.ExtendedAttributes, restic.ExtendedAttribute{
+			Name:  fs.AlternateDataStreamPrefix + stream.Name,
+			Value: stream.Data,
+		})
+	}
+	return nil
+}
+
 // loadSubtree tries to load the subtree referenced by node. In case of an error, nil is returned.
 // If there is no node to load, then nil is returned without an error.
 func (arch *Archiver) loadSubtree(ctx context.Context, node *restic.Node) (*restic.Tree, error) {
@@ -215,6 +399,9 @@ func (arch *Archiver) wrapLoadTreeError(id restic.ID, err error) error {
 // SaveDir stores a directory in the repo and returns the node. snPath is the
 // path within the current snapshot.
 func (arch *Archiver) SaveDir(ctx context.Context, snPath string, dir string, fi os.FileInfo, previous *restic.Tree, complete CompleteFunc) (d FutureNode, err error) {
+	ctx, span := tracing.Start(ctx, "archiver.SaveDir", attribute.String("restic.path", snPath))
+	defer func() { tracing.End(span, err) }()
+
 	debug.Log("%v %v", snPath, dir)
 
 	treeNode, err := arch.nodeFromFileInfo(snPath, dir, fi)
@@ -222,25 +409,27 @@ func (arch *Archiver) SaveDir(ctx context.Context, snPath string, dir string, fi
 		return FutureNode{}, err
 	}
 
-	names, err := readdirnames(arch.FS, dir, fs.O_NOFOLLOW)
+	entries, err := arch.dirScanner.Lookup(ctx, dir)
 	if err != nil {
 		return FutureNode{}, err
 	}
-	sort.Strings(names)
+	// drop the subdirectories which were listed ahead but are not saved
+	defer arch.dirScanner.Forget(dir, entries)
 
-	nodes := make([]FutureNode, 0, len(names))
+	nodes := make([]FutureNode, 0, len(entries))
 
-	for _, name := range names {
+	for _, entry := range entries {
 		// test if context has been cancelled
 		if ctx.Err() != nil {
 			debug.Log("context has been cancelled, aborting")
 			return FutureNode{}, ctx.Err()
 		}
 
+		name := entry.name
 		pathname := arch.FS.Join(dir, name)
 		oldNode := previous.Find(name)
 		snItem := join(snPath, name)
-		fn, excluded, err := arch.Save(ctx, snItem, pathname, oldNode)
+		fn, excluded, err := arch.save(ctx, snItem, pathname, oldNode, entry.fi)
 
 		// return error early if possible
 		if err != nil {
@@ -260,6 +449,9 @@ func (arch *Archiver) SaveDir(ctx context.Context, snPath string, dir string, fi
 		nodes = append(nodes, fn)
 	}
 
+	// read the small files of the directory before the tree saver waits for
+	// them
+	arch.fileSaver.Flush(ctx)
 	fn := arch.treeSaver.Save(ctx, snPath, dir, treeNode, nodes, complete)
 
 	return fn, nil
@@ -272,6 +464,10 @@ func (arch *Archiver) SaveDir(ctx context.Context, snPath string, dir string, fi
 type FutureNode struct {
 	ch  <-chan futureNodeResult
 	res *futureNodeResult
+
+	// flush is called before waiting for the result, it makes sure that a
+	// file waiting in a batch is passed on to a worker.
+	flush func(ctx context.Context)
 }
 
 type futureNodeResult struct {
@@ -300,6 +496,10 @@ func (fn *FutureNode) take(ctx context.Context) futureNodeResult {
 		fn.res = nil
 		return *res
 	}
+	if fn.flush != nil {
+		fn.flush(ctx)
+		fn.flush = nil
+	}
 	select {
 	case res, ok := <-fn.ch:
 		if ok {
@@ -332,6 +532,11 @@ func (arch *Archiver) allBlobsPresent(previous *restic.Node) bool {
 //
 // snPath is the path within the current snapshot.
 func (arch *Archiver) Save(ctx context.Context, snPath, target string, previous *restic.Node) (fn FutureNode, excluded bool, err error) {
+	return arch.save(ctx, snPath, target, previous, nil)
+}
+
+// save is like Save, but uses the file info fi of target if it is not nil.
+func (arch *Archiver) save(ctx context.Context, snPath, target string, previous *restic.Node, fi os.FileInfo) (fn FutureNode, excluded bool, err error) {
 	start := time.Now()
 
 	debug.Log("%v target %q, previous %v", snPath, target, previous)
@@ -345,21 +550,49 @@ func (arch *Archiver) Save(ctx context.Context, snPath, target string, previous
 		debug.Log("%v is excluded by path", target)
 		return FutureNode{}, true, nil
 	}
+	if arch.markerFilter != nil && !arch.markerFilter.SelectByName(abstarget) {
+		return FutureNode{}, true, nil
+	}
 
 	// get file info and run remaining select functions that require file information
-	fi, err := arch.FS.Lstat(target)
-	if err != nil {
-		debug.Log("lstat() for %v returned error: %v", target, err)
-		err = arch.error(abstarget, err)
+	if fi == nil {
+		fi, err = arch.FS.Lstat(target)
 		if err != nil {
-			return FutureNode{}, false, errors.WithStack(err)
+			debug.Log("lstat() for %v returned error: %v", target, err)
+			err = arch.error(abstarget, err)
+			if err != nil {
+				return FutureNode{}, false, errors.WithStack(err)
+			}
+			return FutureNode{}, true, nil
+		}
+	}
+	if arch.targetLinks != nil && fi.Mode()&os.ModeSymlink != 0 {
+		fi, err = arch.targetLinks.stat(abstarget, fi)
+		if err != nil {
+			// save the symlink itself if the error is ignored
+			err = arch.error(abstarget, err)
+			if err != nil {
+				return FutureNode{}, false, errors.WithStack(err)
+			}
 		}
-		return FutureNode{}, true, nil
 	}
 	if !arch.Select(abstarget, fi) {
 		debug.Log("%v is excluded", target)
 		return FutureNode{}, true, nil
 	}
+	if arch.deviceFilter != nil && !arch.deviceFilter.Select(abstarget, fi) {
+		return FutureNode{}, true, nil
+	}
+	if arch.noDump {
+		noDump, err := fs.IsNoDump(target, fi)
+		if err != nil {
+			debug.Log("unable to read the flags of %v: %v", target, err)
+		}
+		if noDump {
+			debug.Log("%v has the nodump flag, rejecting", target)
+			return FutureNode{}, true, nil
+		}
+	}
 
 	switch {
 	case fs.IsRegularFile(fi):
@@ -370,7 +603,7 @@ func (arch *Archiver) Save(ctx context.Context, snPath, target string, previous
 		if previous != nil && !fileChanged(fi, previous, arch.ChangeIgnoreFlags) {
 			if arch.allBlobsPresent(previous) {
 				debug.Log("%v hasn't changed, using old list of blobs", target)
-				arch.CompleteItem(snPath, previous, previous, ItemStats{}, time.Since(start))
+				arch.trackItem(snPath, previous, previous, ItemStats{}, time.Since(start))
 				arch.CompleteBlob(previous.Size)
 				node, err := arch.nodeFromFileInfo(snPath, target, fi)
 				if err != nil {
@@ -399,7 +632,7 @@ func (arch *Archiver) Save(ctx context.Context, snPath, target string, previous
 
 		// reopen file and do an fstat() on the open file to check it is still
 		// a file (and has not been exchanged for e.g. a symlink)
-		file, err := arch.FS.OpenFile(target, fs.O_RDONLY|fs.O_NOFOLLOW, 0)
+		file, err := arch.FS.OpenFile(target, fs.O_RDONLY|arch.targetLinks.openFlags(abstarget), 0)
 		if err != nil {
 			debug.Log("Openfile() for %v returned error: %v", target, err)
 			err = arch.error(abstarget, err)
@@ -435,9 +668,9 @@ func (arch *Archiver) Save(ctx context.Context, snPath, target string, previous
 		fn = arch.fileSaver.Save(ctx, snPath, target, file, fi, func() {
 			arch.StartFile(snPath)
 		}, func() {
-			arch.CompleteItem(snPath, nil, nil, ItemStats{}, 0)
+			arch.trackItem(snPath, nil, nil, ItemStats{}, 0)
 		}, func(node *restic.Node, stats ItemStats) {
-			arch.CompleteItem(snPath, previous, node, stats, time.Since(start))
+			arch.trackItem(snPath, previous, node, stats, time.Since(start))
 		})
 
 	case fi.IsDir():
@@ -454,16 +687,45 @@ func (arch *Archiver) Save(ctx context.Context, snPath, target string, previous
 
 		fn, err = arch.SaveDir(ctx, snPath, target, fi, oldSubtree,
 			func(node *restic.Node, stats ItemStats) {
-				arch.CompleteItem(snItem, previous, node, stats, time.Since(start))
+				arch.trackItem(snItem, previous, node, stats, time.Since(start))
 			})
 		if err != nil {
 			debug.Log("SaveDir for %v returned error: %v", snPath, err)
 			return FutureNode{}, false, err
 		}
 
-	case fi.Mode()&os.ModeSocket > 0:
-		debug.Log("  %v is a socket, ignoring", target)
-		return FutureNode{}, true, nil
+	case restic.SpecialFileType(fi) != "":
+		nodeType := restic.SpecialFileType(fi)
+		action := arch.SpecialFiles.Action(nodeType)
+		if action == restic.SpecialFileDefault {
+			action = restic.SpecialFileRecord
+			if nodeType == "socket" {
+				action = restic.SpecialFileSkip
+			}
+		}
+
+		switch action {
+		case restic.SpecialFileSkip:
+			debug.Log("  %v is a %v, ignoring", target, nodeType)
+			return FutureNode{}, true, nil
+		case restic.SpecialFileError:
+			err := arch.error(abstarget, errors.Errorf("%v is a %v, refusing to archive", target, nodeType))
+			if err != nil {
+				return FutureNode{}, false, err
+			}
+			return FutureNode{}, true, nil
+		}
+
+		debug.Log("  %v %v", target, nodeType)
+		node, err := arch.nodeFromFileInfo(snPath, target, fi)
+		if err != nil {
+			return FutureNode{}, false, err
+		}
+		fn = newFutureNodeWithResult(futureNodeResult{
+			snPath: snPath,
+			target: target,
+			node:   node,
+		})
 
 	default:
 		debug.Log("  %v other", target)
@@ -610,7 +872,7 @@ func (arch *Archiver) SaveTree(ctx context.Context, snPath string, atree *Tree,
 
 		// not a leaf node, archive subtree
 		fn, _, err := arch.SaveTree(ctx, join(snPath, name), &subatree, oldSubtree, func(n *restic.Node, is ItemStats) {
-			arch.CompleteItem(snItem, oldNode, n, is, time.Since(start))
+			arch.trackItem(snItem, oldNode, n, is, time.Since(start))
 		})
 		if err != nil {
 			return FutureNode{}, 0, err
@@ -618,6 +880,7 @@ func (arch *Archiver) SaveTree(ctx context.Context, snPath string, atree *Tree,
 		nodes = append(nodes, fn)
 	}
 
+	arch.fileSaver.Flush(ctx)
 	fn := arch.treeSaver.Save(ctx, snPath, atree.FileInfoPath, node, nodes, complete)
 	return fn, len(nodes), nil
 }
@@ -681,6 +944,30 @@ type SnapshotOptions struct {
 	Time           time.Time
 	ParentSnapshot *restic.Snapshot
 	ProgramVersion string
+	// Normalization is applied to the paths and the hostname stored in the
+	// snapshot.
+	Normalization restic.PathNormalization
+	// OneFileSystem excludes items on other file systems than the targets,
+	// except below the mountpoints listed in AllowedMountpoints.
+	OneFileSystem      bool
+	AllowedMountpoints []string
+	// ExcludeCaches excludes the contents of directories tagged with a
+	// CACHEDIR.TAG file, ExcludeIfPresent those of directories containing
+	// one of the markers. The directories and the markers are kept.
+	ExcludeCaches    bool
+	ExcludeIfPresent []ExcludeMarker
+	// ExcludeNoDump excludes files and directories with the nodump flag.
+	// On Linux, this opens every file and directory to read the flag.
+	ExcludeNoDump bool
+	// FollowTargetSymlinks dereferences targets which are symlinks, the
+	// item they point to is saved under the path of the link. Symlinks
+	// below the targets are still saved as links. Targets pointing to one
+	// of their parent directories are reported as errors.
+	FollowTargetSymlinks bool
+	// BeforeSave is called with the complete snapshot before it is saved,
+	// if it returns an error the snapshot is not saved and the error is
+	// returned. The data of the snapshot is kept in the repository.
+	BeforeSave func(sn *restic.Snapshot) error
 }
 
 // loadParentTree loads a tree referenced by snapshot id. If id is null, nil is returned.
@@ -704,6 +991,17 @@ func (arch *Archiver) loadParentTree(ctx context.Context, sn *restic.Snapshot) *
 	return tree
 }
 
+// StageReport returns the time spent in each stage of the snapshots taken
+// since Stages was set, and the resource which limited them.
+func (arch *Archiver) StageReport() restic.StageReport {
+	return arch.Stages.Report(restic.Parallelism{
+		Disk: arch.Options.ReadConcurrency + arch.Options.ScanConcurrency,
+		// files are chunked by the readers
+		CPU:     arch.Options.SaveBlobConcurrency + arch.Options.ReadConcurrency,
+		Network: arch.Repo.Connections(),
+	})
+}
+
 // runWorkers starts the worker pools, which are stopped when the context is cancelled.
 func (arch *Archiver) runWorkers(ctx context.Context, wg *errgroup.Group) {
 	arch.blobSaver = NewBlobSaver(ctx, wg, arch.Repo, arch.Options.SaveBlobConcurrency)
@@ -713,22 +1011,34 @@ func (arch *Archiver) runWorkers(ctx context.Context, wg *errgroup.Group) {
 		arch.Repo.Config().ChunkerPolynomial,
 		arch.Options.ReadConcurrency, arch.Options.SaveBlobConcurrency)
 	arch.fileSaver.CompleteBlob = arch.CompleteBlob
+	arch.fileSaver.ReadAhead = int(arch.Options.ReadAheadSize)
 	arch.fileSaver.NodeFromFileInfo = arch.nodeFromFileInfo
+	arch.fileSaver.ScanContent = arch.ScanContent
+	arch.fileSaver.Flagged = arch.Flagged
+	arch.fileSaver.stages = arch.Stages
 
 	arch.treeSaver = NewTreeSaver(ctx, wg, arch.Options.SaveTreeConcurrency, arch.blobSaver.Save, arch.Error)
+	arch.dirScanner = newDirScanner(ctx, wg, arch.FS, arch.SelectByName, arch.deviceFilter, arch.Options.ScanConcurrency)
+	arch.dirScanner.stages = arch.Stages
+	arch.dirScanner.links = arch.targetLinks
 }
 
 func (arch *Archiver) stopWorkers() {
 	arch.blobSaver.TriggerShutdown()
 	arch.fileSaver.TriggerShutdown()
 	arch.treeSaver.TriggerShutdown()
+	arch.dirScanner.TriggerShutdown()
 	arch.blobSaver = nil
 	arch.fileSaver = nil
 	arch.treeSaver = nil
+	arch.dirScanner = nil
 }
 
 // Snapshot saves several targets and returns a snapshot.
-func (arch *Archiver) Snapshot(ctx context.Context, targets []string, opts SnapshotOptions) (*restic.Snapshot, restic.ID, error) {
+func (arch *Archiver) Snapshot(ctx context.Context, targets []string, opts SnapshotOptions) (_ *restic.Snapshot, _ restic.ID, err error) {
+	ctx, span := tracing.Start(ctx, "archiver.Snapshot", attribute.StringSlice("restic.targets", targets))
+	defer func() { tracing.End(span, err) }()
+
 	cleanTargets, err := resolveRelativeTargets(arch.FS, targets)
 	if err != nil {
 		return nil, restic.ID{}, err
@@ -739,8 +1049,61 @@ func (arch *Archiver) Snapshot(ctx context.Context, targets []string, opts Snaps
 		return nil, restic.ID{}, err
 	}
 
+	if opts.FollowTargetSymlinks {
+		arch.targetLinks, err = newTargetLinks(arch.FS, cleanTargets)
+		if err != nil {
+			return nil, restic.ID{}, err
+		}
+		defer func() {
+			arch.targetLinks = nil
+		}()
+	}
+
+	if opts.OneFileSystem {
+		arch.deviceFilter, err = NewDeviceFilter(arch.FS, cleanTargets, opts.AllowedMountpoints)
+		if err != nil {
+			return nil, restic.ID{}, err
+		}
+		defer func() {
+			arch.deviceFilter = nil
+		}()
+		if arch.targetLinks != nil {
+			arch.deviceFilter.follow(arch.targetLinks)
+		}
+	}
+
+	markers := opts.ExcludeIfPresent
+	if opts.ExcludeCaches {
+		markers = append([]ExcludeMarker{CacheDirTag}, markers...)
+	}
+	if len(markers) > 0 {
+		arch.markerFilter = NewMarkerFilter(arch.FS, markers)
+		defer func() {
+			arch.markerFilter = nil
+		}()
+	}
+	arch.noDump = opts.ExcludeNoDump
+
+	arch.newestMu.Lock()
+	arch.newest = time.Time{}
+	arch.newestMu.Unlock()
+
+	summary := &restic.SnapshotSummary{BackupStart: restic.Now(ctx)}
+	arch.summaryMu.Lock()
+	arch.summary = summary
+	arch.summaryMu.Unlock()
+	defer func() {
+		arch.summaryMu.Lock()
+		arch.summary = nil
+		arch.summaryMu.Unlock()
+	}()
+
 	var rootTreeID restic.ID
 
+	if arch.Stages != nil {
+		ctx = restic.WithStageTimes(ctx, arch.Stages)
+	}
+
 	wgUp, wgUpCtx := errgroup.WithContext(ctx)
 	arch.Repo.StartPackUploader(wgUpCtx, wgUp)
 
@@ -753,7 +1116,7 @@ func (arch *Archiver) Snapshot(ctx context.Context, targets []string, opts Snaps
 
 			debug.Log("starting snapshot")
 			fn, nodeCount, err := arch.SaveTree(wgCtx, "/", atree, arch.loadParentTree(wgCtx, opts.ParentSnapshot), func(n *restic.Node, is ItemStats) {
-				arch.CompleteItem("/", nil, nil, is, time.Since(start))
+				arch.trackItem("/", nil, nil, is, time.Since(start))
 			})
 			if err != nil {
 				return err
@@ -792,17 +1155,35 @@ func (arch *Archiver) Snapshot(ctx context.Context, targets []string, opts Snaps
 		return nil, restic.ID{}, err
 	}
 
-	sn, err := restic.NewSnapshot(targets, opts.Tags, opts.Hostname, opts.Time)
+	summary.BackupEnd = restic.Now(ctx)
+
+	snTime := opts.Time
+	if snTime.IsZero() && arch.Options.Deterministic {
+		snTime = arch.newest
+	}
+
+	sn, err := restic.NewSnapshot(targets, opts.Tags, opts.Hostname, snTime)
 	if err != nil {
 		return nil, restic.ID{}, err
 	}
 
+	opts.Normalization.Apply(sn)
 	sn.ProgramVersion = opts.ProgramVersion
 	sn.Excludes = opts.Excludes
 	if opts.ParentSnapshot != nil {
 		sn.Parent = opts.ParentSnapshot.ID()
 	}
 	sn.Tree = &rootTreeID
+	if !arch.Options.Deterministic {
+		// the summary contains the current time
+		sn.Summary = summary
+	}
+
+	if opts.BeforeSave != nil {
+		if err := opts.BeforeSave(sn); err != nil {
+			return nil, restic.ID{}, err
+		}
+	}
 
 	id, err := restic.SaveSnapshot(ctx, arch.Repo, sn)
 	if err != nil {
@@ -21,6 +21,7 @@ import (
 	"github.com/konidev20/rapi/internal/errors"
 	"github.com/konidev20/rapi/internal/fs"
 	"github.com/konidev20/rapi/repository"
+	"github.com/restic/chunker"
 	"github.com/konidev20/rapi/restic"
 	restictest "github.com/konidev20/rapi/internal/test"
 	"golang.org/x/sync/errgroup"
@@ -1573,6 +1574,183 @@ func TestArchiverSnapshotSelect(t *testing.T) {
 	}
 }
 
+func TestArchiverExcludeMarkers(t *testing.T) {
+	src := TestDir{
+		"cache": TestDir{
+			"CACHEDIR.TAG": TestFile{Content: CacheDirTag.Header + "\n# created by test\n"},
+			"data":         TestFile{Content: "cached data"},
+			"sub": TestDir{
+				"more": TestFile{Content: "more cached data"},
+			},
+		},
+		"fake-cache": TestDir{
+			"CACHEDIR.TAG": TestFile{Content: "no signature"},
+			"data":         TestFile{Content: "data"},
+		},
+		"private": TestDir{
+			".nobackup": TestFile{Content: ""},
+			"secret":    TestFile{Content: "secret"},
+		},
+		"other": TestFile{Content: "another file"},
+	}
+
+	tempdir, repo := prepareTempdirRepoSrc(t, src)
+	back := restictest.Chdir(t, tempdir)
+	defer back()
+
+	arch := New(repo, fs.Track{FS: fs.Local{}}, Options{})
+	_, id, err := arch.Snapshot(context.TODO(), []string{"."}, SnapshotOptions{
+		Time:             time.Now(),
+		ExcludeCaches:    true,
+		ExcludeIfPresent: []ExcludeMarker{{Name: ".nobackup"}},
+	})
+	restictest.OK(t, err)
+
+	TestEnsureSnapshot(t, repo, id, TestDir{
+		"cache": TestDir{
+			"CACHEDIR.TAG": TestFile{Content: CacheDirTag.Header + "\n# created by test\n"},
+		},
+		"fake-cache": TestDir{
+			"CACHEDIR.TAG": TestFile{Content: "no signature"},
+			"data":         TestFile{Content: "data"},
+		},
+		"private": TestDir{
+			".nobackup": TestFile{Content: ""},
+		},
+		"other": TestFile{Content: "another file"},
+	})
+}
+
+func TestArchiverFollowTargetSymlinks(t *testing.T) {
+	src := TestDir{
+		"vol": TestDir{
+			"data": TestDir{
+				"file": TestFile{Content: "data on the volume"},
+				"link": TestSymlink{Target: "file"},
+			},
+			"single": TestFile{Content: "single file"},
+		},
+		"data":   TestSymlink{Target: filepath.FromSlash("vol/data")},
+		"single": TestSymlink{Target: filepath.FromSlash("vol/single")},
+		"loop":   TestSymlink{Target: "."},
+	}
+
+	tempdir, repo := prepareTempdirRepoSrc(t, src)
+	back := restictest.Chdir(t, tempdir)
+	defer back()
+
+	var errs []string
+	arch := New(repo, fs.Track{FS: fs.Local{}}, Options{})
+	arch.Error = func(item string, err error) error {
+		errs = append(errs, filepath.Base(item))
+		return nil
+	}
+
+	_, id, err := arch.Snapshot(context.TODO(), []string{"data", "single", "loop"}, SnapshotOptions{
+		Time:                 time.Now(),
+		FollowTargetSymlinks: true,
+	})
+	restictest.OK(t, err)
+	restictest.Equals(t, []string{"loop"}, errs)
+
+	TestEnsureSnapshot(t, repo, id, TestDir{
+		"data": TestDir{
+			"file": TestFile{Content: "data on the volume"},
+			"link": TestSymlink{Target: "file"},
+		},
+		"single": TestFile{Content: "single file"},
+		"loop":   TestSymlink{Target: "."},
+	})
+}
+
+// keywordScanner collects the contents of a file and flags or skips it if
+// it contains a keyword.
+type keywordScanner struct {
+	buf  bytes.Buffer
+	seen func(data string)
+}
+
+func (s *keywordScanner) Write(p []byte) (int, error) {
+	return s.buf.Write(p)
+}
+
+func (s *keywordScanner) Verdict() (ScanVerdict, error) {
+	s.seen(s.buf.String())
+	switch {
+	case strings.Contains(s.buf.String(), "secret"):
+		return ScanVerdict{Action: ScanSkip, Reason: "secret"}, nil
+	case strings.Contains(s.buf.String(), "personal"):
+		return ScanVerdict{Action: ScanFlag, Reason: "personal"}, nil
+	case strings.Contains(s.buf.String(), "broken"):
+		return ScanVerdict{}, errors.New("scanner failed")
+	}
+	return ScanVerdict{}, nil
+}
+
+func TestArchiverScanContent(t *testing.T) {
+	src := TestDir{
+		"work": TestDir{
+			"public":   TestFile{Content: "public data"},
+			"secret":   TestFile{Content: "a secret"},
+			"personal": TestFile{Content: "personal data"},
+			"broken":   TestFile{Content: "broken"},
+		},
+	}
+	ctx := context.Background()
+	tempdir, repo := prepareTempdirRepoSrc(t, src)
+
+	var m sync.Mutex
+	scanned := make(map[string]string)
+	flagged := make(map[string]ScanVerdict)
+	var errs []string
+
+	arch := New(repo, fs.Track{FS: fs.Local{}}, Options{})
+	arch.ScanContent = func(snPath string, fi os.FileInfo) ContentScanner {
+		return &keywordScanner{seen: func(data string) {
+			m.Lock()
+			scanned[snPath] = data
+			m.Unlock()
+		}}
+	}
+	arch.Flagged = func(item string, v ScanVerdict) {
+		m.Lock()
+		flagged[item] = v
+		m.Unlock()
+	}
+	arch.Error = func(item string, err error) error {
+		m.Lock()
+		errs = append(errs, item)
+		m.Unlock()
+		return nil
+	}
+
+	back := restictest.Chdir(t, tempdir)
+	defer back()
+
+	_, snapshotID, err := arch.Snapshot(ctx, []string{"."}, SnapshotOptions{Time: time.Now()})
+	restictest.OK(t, err)
+
+	restictest.Equals(t, map[string]string{
+		"/work/public":   "public data",
+		"/work/secret":   "a secret",
+		"/work/personal": "personal data",
+		"/work/broken":   "broken",
+	}, scanned)
+	restictest.Equals(t, map[string]ScanVerdict{
+		"/work/secret":   {Action: ScanSkip, Reason: "secret"},
+		"/work/personal": {Action: ScanFlag, Reason: "personal"},
+	}, flagged)
+	restictest.Equals(t, 1, len(errs))
+	restictest.Assert(t, strings.HasSuffix(errs[0], "broken"), "unexpected error for %v", errs[0])
+
+	TestEnsureSnapshot(t, repo, snapshotID, TestDir{
+		"work": TestDir{
+			"public":   TestFile{Content: "public data"},
+			"personal": TestFile{Content: "personal data"},
+		},
+	})
+}
+
 // MockFS keeps track which files are read.
 type MockFS struct {
 	fs.FS
@@ -1709,6 +1887,53 @@ func TestArchiverParent(t *testing.T) {
 	}
 }
 
+func TestArchiverSummary(t *testing.T) {
+	src := TestDir{
+		"dir": TestDir{
+			"file1": TestFile{Content: "foo"},
+			"file2": TestFile{Content: "barbaz"},
+		},
+		"file3": TestFile{Content: "x"},
+	}
+
+	tempdir, repo := prepareTempdirRepoSrc(t, src)
+	back := restictest.Chdir(t, tempdir)
+	defer back()
+
+	arch := New(repo, fs.Track{FS: fs.Local{}}, Options{})
+	first, id, err := arch.Snapshot(context.TODO(), []string{"."}, SnapshotOptions{Time: time.Now()})
+	restictest.OK(t, err)
+
+	sum := first.Summary
+	restictest.Assert(t, sum != nil, "summary not set")
+	restictest.Equals(t, uint(3), sum.FilesNew)
+	// the root of the snapshot has no node and is not counted
+	restictest.Equals(t, uint(1), sum.DirsNew)
+	restictest.Equals(t, uint(3), sum.TotalFilesProcessed)
+	restictest.Equals(t, uint64(10), sum.TotalBytesProcessed)
+	restictest.Equals(t, 3, sum.DataBlobs)
+	restictest.Assert(t, sum.TreeBlobs > 0, "no tree blobs counted")
+	restictest.Assert(t, sum.DataAddedPacked > 0, "no added data counted")
+	restictest.Assert(t, sum.Duration() >= 0, "negative duration %v", sum.Duration())
+
+	// the summary is stored in the snapshot
+	loaded, err := restic.LoadSnapshot(context.TODO(), repo, id)
+	restictest.OK(t, err)
+	restictest.Equals(t, sum.FilesNew, loaded.Summary.FilesNew)
+
+	TestCreateFiles(t, tempdir, TestDir{"file3": TestFile{Content: "changed"}})
+	second, _, err := arch.Snapshot(context.TODO(), []string{"."}, SnapshotOptions{Time: time.Now(), ParentSnapshot: first})
+	restictest.OK(t, err)
+
+	sum = second.Summary
+	restictest.Equals(t, uint(0), sum.FilesNew)
+	restictest.Equals(t, uint(1), sum.FilesChanged)
+	restictest.Equals(t, uint(2), sum.FilesUnmodified)
+	restictest.Equals(t, uint(0), sum.DirsNew)
+	restictest.Equals(t, uint(1), sum.DirsUnmodified)
+	restictest.Equals(t, 1, sum.DataBlobs)
+}
+
 func TestArchiverErrorReporting(t *testing.T) {
 	ignoreErrorForBasename := func(basename string) ErrorFunc {
 		return func(item string, err error) error {
@@ -1968,16 +2193,16 @@ func TestArchiverAbortEarlyOnError(t *testing.T) {
 		{
 			src: TestDir{
 				"dir": TestDir{
-					"file0": TestFile{Content: string(restictest.Random(0, 1024))},
-					"file1": TestFile{Content: string(restictest.Random(1, 1024))},
-					"file2": TestFile{Content: string(restictest.Random(2, 1024))},
-					"file3": TestFile{Content: string(restictest.Random(3, 1024))},
-					"file4": TestFile{Content: string(restictest.Random(4, 1024))},
-					"file5": TestFile{Content: string(restictest.Random(5, 1024))},
-					"file6": TestFile{Content: string(restictest.Random(6, 1024))},
-					"file7": TestFile{Content: string(restictest.Random(7, 1024))},
-					"file8": TestFile{Content: string(restictest.Random(8, 1024))},
-					"file9": TestFile{Content: string(restictest.Random(9, 1024))},
+					"file0": TestFile{Content: string(restictest.Random(0, chunker.MinSize))},
+					"file1": TestFile{Content: string(restictest.Random(1, chunker.MinSize))},
+					"file2": TestFile{Content: string(restictest.Random(2, chunker.MinSize))},
+					"file3": TestFile{Content: string(restictest.Random(3, chunker.MinSize))},
+					"file4": TestFile{Content: string(restictest.Random(4, chunker.MinSize))},
+					"file5": TestFile{Content: string(restictest.Random(5, chunker.MinSize))},
+					"file6": TestFile{Content: string(restictest.Random(6, chunker.MinSize))},
+					"file7": TestFile{Content: string(restictest.Random(7, chunker.MinSize))},
+					"file8": TestFile{Content: string(restictest.Random(8, chunker.MinSize))},
+					"file9": TestFile{Content: string(restictest.Random(9, chunker.MinSize))},
 				},
 			},
 			wantOpen: map[string]uint{
@@ -1989,7 +2214,9 @@ func TestArchiverAbortEarlyOnError(t *testing.T) {
 				filepath.FromSlash("dir/file9"): 0,
 			},
 			// fails after four to seven files were opened, as the ReadConcurrency allows for
-			// two queued files and SaveBlobConcurrency for one blob queued for saving.
+			// two queued files and SaveBlobConcurrency for one blob queued for saving. The
+			// files are not small enough to be batched, all small files of a directory are
+			// opened before they are read.
 			failAfter: 4,
 			err:       testErr,
 		},
@@ -2245,3 +2472,68 @@ func TestRacyFileSwap(t *testing.T) {
 		t.Errorf("Save() excluded the node, that's unexpected")
 	}
 }
+
+// backendFiles returns the contents of all files in be except for the locks.
+func backendFiles(t *testing.T, be backend.Backend) map[backend.Handle][]byte {
+	files := make(map[backend.Handle][]byte)
+	for _, tpe := range []backend.FileType{backend.ConfigFile, backend.KeyFile, backend.PackFile, backend.IndexFile, backend.SnapshotFile} {
+		err := be.List(context.TODO(), tpe, func(fi backend.FileInfo) error {
+			h := backend.Handle{Type: tpe, Name: fi.Name}
+			buf, err := backend.LoadAll(context.TODO(), nil, be, h)
+			files[h] = buf
+			return err
+		})
+		restictest.OK(t, err)
+	}
+	return files
+}
+
+func TestArchiverDeterministic(t *testing.T) {
+	src := TestDir{
+		"dir": TestDir{
+			"file1": TestFile{Content: string(restictest.Random(1, 3*1024*1024))},
+			"file2": TestFile{Content: string(restictest.Random(2, 5000))},
+			"sub": TestDir{
+				"file3": TestFile{Content: string(restictest.Random(3, 2*1024*1024))},
+			},
+		},
+		"file4": TestFile{Content: string(restictest.Random(4, 1*1024*1024))},
+	}
+
+	tempdir := restictest.TempDir(t)
+	TestCreateFiles(t, tempdir, src)
+	back := restictest.Chdir(t, tempdir)
+	defer back()
+
+	// both backups are saved to copies of the same repository
+	template := mem.New()
+	repository.TestRepositoryWithBackend(t, template, 0)
+	templateFiles := backendFiles(t, template)
+
+	var results []map[backend.Handle][]byte
+	var times []time.Time
+	for i := 0; i < 2; i++ {
+		be := mem.New()
+		for h, buf := range templateFiles {
+			restictest.OK(t, be.Save(context.TODO(), h, backend.NewByteReader(buf, be.Hasher())))
+		}
+
+		repo, err := repository.New(be, repository.Options{Deterministic: true})
+		restictest.OK(t, err)
+		restictest.OK(t, repo.SearchKey(context.TODO(), restictest.TestPassword, 1, ""))
+
+		arch := New(repo, fs.Track{FS: fs.Local{}}, Options{Deterministic: true})
+		sn, _, err := arch.Snapshot(context.TODO(), []string{"."}, SnapshotOptions{Hostname: "host"})
+		restictest.OK(t, err)
+
+		results = append(results, backendFiles(t, be))
+		times = append(times, sn.Time)
+	}
+
+	restictest.Assert(t, !times[0].IsZero(), "snapshot time was not set")
+	restictest.Equals(t, times[0], times[1])
+	restictest.Equals(t, len(results[0]), len(results[1]))
+	for h, buf := range results[0] {
+		restictest.Assert(t, bytes.Equal(buf, results[1][h]), "file %v differs between the backups", h)
+	}
+}
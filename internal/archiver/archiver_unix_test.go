@@ -5,7 +5,12 @@ package archiver
 
 import (
 	"os"
+	"path/filepath"
 	"syscall"
+	"testing"
+
+	"github.com/konidev20/rapi/internal/fs"
+	rtest "github.com/konidev20/rapi/internal/test"
 )
 
 type wrappedFileInfo struct {
@@ -39,3 +44,46 @@ func wrapFileInfo(fi os.FileInfo) os.FileInfo {
 
 	return res
 }
+
+// withDevice returns a copy of fi which is located on the device dev.
+func withDevice(fi os.FileInfo, dev uint64) os.FileInfo {
+	stat := *fi.Sys().(*syscall.Stat_t)
+	stat.Dev = dev
+
+	return wrappedFileInfo{
+		FileInfo: fi,
+		sys:      &stat,
+		mode:     fi.Mode(),
+	}
+}
+
+func TestDeviceFilter(t *testing.T) {
+	tempdir := rtest.TempDir(t)
+	TestCreateFiles(t, tempdir, TestDir{
+		"target": TestDir{
+			"file":  TestFile{Content: "foo"},
+			"mount": TestDir{"file": TestFile{Content: "bar"}},
+		},
+	})
+
+	target := filepath.Join(tempdir, "target")
+	filter, err := NewDeviceFilter(fs.Local{}, []string{target}, nil)
+	rtest.OK(t, err)
+
+	lstat := func(name string) os.FileInfo {
+		fi, err := os.Lstat(filepath.Join(target, name))
+		rtest.OK(t, err)
+		return fi
+	}
+	otherDevice := func(fi os.FileInfo) os.FileInfo {
+		stat := fi.Sys().(*syscall.Stat_t)
+		return withDevice(fi, uint64(stat.Dev)+1)
+	}
+
+	rtest.Assert(t, filter.Select(filepath.Join(target, "file"), lstat("file")), "file on the same device rejected")
+	// the mountpoint is kept, but not the files on the mounted file system
+	rtest.Assert(t, filter.Select(filepath.Join(target, "mount"), otherDevice(lstat("mount"))), "mountpoint rejected")
+	rtest.Assert(t, !filter.Select(filepath.Join(target, "file"), otherDevice(lstat("file"))), "file on other device selected")
+	// items outside of the targets are not filtered
+	rtest.Assert(t, filter.Select(filepath.Join(tempdir, "other"), otherDevice(lstat("file"))), "item outside of targets rejected")
+}
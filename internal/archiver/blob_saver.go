@@ -4,7 +4,9 @@ import (
 	"context"
 
 	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/tracing"
 	"github.com/konidev20/rapi/restic"
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -64,7 +66,10 @@ type SaveBlobResponse struct {
 	known      bool
 }
 
-func (s *BlobSaver) saveBlob(ctx context.Context, t restic.BlobType, buf []byte) (SaveBlobResponse, error) {
+func (s *BlobSaver) saveBlob(ctx context.Context, t restic.BlobType, buf []byte) (_ SaveBlobResponse, err error) {
+	ctx, span := tracing.Start(ctx, "archiver.SaveBlob", attribute.String("restic.blob.type", t.String()))
+	defer func() { tracing.End(span, err) }()
+
 	id, known, sizeInRepo, err := s.repo.SaveBlob(ctx, t, buf, restic.ID{}, false)
 
 	if err != nil {
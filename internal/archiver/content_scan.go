@@ -0,0 +1,43 @@
+package archiver
+
+import (
+	"io"
+	"os"
+)
+
+// ScanAction is the decision of a ContentScanner about a file.
+type ScanAction int
+
+const (
+	// ScanAllow saves the file.
+	ScanAllow ScanAction = iota
+	// ScanFlag saves the file and reports it to Archiver.Flagged.
+	ScanFlag
+	// ScanSkip excludes the file from the snapshot and reports it to
+	// Archiver.Flagged. The data of the file has already been saved to the
+	// repository, it is removed by the next prune unless other files
+	// reference it.
+	ScanSkip
+)
+
+// ScanVerdict is the result of scanning a file.
+type ScanVerdict struct {
+	Action ScanAction
+	// Reason describes why the file was flagged or skipped.
+	Reason string
+}
+
+// ContentScanner inspects the contents of a file while it is backed up, for
+// example to detect personal data. Write is called with the chunks of the
+// file in order, Verdict once the whole file was read. If reading the file
+// fails, Verdict is not called. An error returned by Write or Verdict is
+// handled like an error reading the file.
+type ContentScanner interface {
+	io.Writer
+	Verdict() (ScanVerdict, error)
+}
+
+// ContentScanFunc returns the scanner for a file which is read during a
+// backup, or nil if the file is not scanned. It is called concurrently for
+// several files.
+type ContentScanFunc func(snPath string, fi os.FileInfo) ContentScanner
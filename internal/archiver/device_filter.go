@@ -0,0 +1,106 @@
+package archiver
+
+import (
+	"os"
+	"strings"
+
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/internal/fs"
+)
+
+// DeviceFilter restricts a backup to the file systems of the backup targets.
+// Items on other file systems, for example bind-mounted volumes, are
+// rejected, the mountpoint itself is kept as an empty directory. Mountpoints
+// in the allowlist are crossed, the file system mounted there is included.
+type DeviceFilter struct {
+	fs fs.FS
+	// roots maps the absolute paths of the targets and the allowed
+	// mountpoints to their device ID.
+	roots map[string]uint64
+}
+
+// NewDeviceFilter returns a DeviceFilter for targets which crosses the
+// mountpoints in allowed.
+func NewDeviceFilter(filesys fs.FS, targets, allowed []string) (*DeviceFilter, error) {
+	f := &DeviceFilter{
+		fs:    filesys,
+		roots: make(map[string]uint64),
+	}
+
+	for _, item := range append(append([]string{}, targets...), allowed...) {
+		abs, err := filesys.Abs(item)
+		if err != nil {
+			return nil, err
+		}
+		fi, err := filesys.Lstat(abs)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		id, err := fs.DeviceID(fi)
+		if err != nil {
+			return nil, err
+		}
+		f.roots[filesys.Clean(abs)] = id
+	}
+
+	return f, nil
+}
+
+// follow uses the device of the items the followed symlinked targets point
+// to instead of the device of the links.
+func (f *DeviceFilter) follow(links *targetLinks) {
+	for target, fi := range links.resolved {
+		id, err := fs.DeviceID(fi)
+		if err != nil {
+			continue
+		}
+		f.roots[target] = id
+	}
+}
+
+// root returns the longest path in f.roots which contains item.
+func (f *DeviceFilter) root(item string) (string, bool) {
+	sep := f.fs.Separator()
+	var found string
+	for root := range f.roots {
+		if len(root) <= len(found) {
+			continue
+		}
+		if item == root || strings.HasPrefix(item, strings.TrimSuffix(root, sep)+sep) {
+			found = root
+		}
+	}
+	return found, found != ""
+}
+
+// Select returns false for items which are located on another file system
+// than the target or allowed mountpoint they belong to. It can be used as a
+// SelectFunc for the archiver and the scanner.
+func (f *DeviceFilter) Select(item string, fi os.FileInfo) bool {
+	id, err := fs.DeviceID(fi)
+	if err != nil {
+		// device IDs are not supported
+		return true
+	}
+
+	root, ok := f.root(item)
+	if !ok || id == f.roots[root] {
+		return true
+	}
+
+	// keep mountpoints as empty directories, so that restoring the
+	// snapshot recreates them
+	if fi.IsDir() {
+		parent, err := f.fs.Lstat(f.fs.Dir(item))
+		if err == nil {
+			parentID, err := fs.DeviceID(parent)
+			if err == nil && parentID == f.roots[root] {
+				return true
+			}
+		}
+	}
+
+	debug.Log("%v is on another file system, rejecting", item)
+	return false
+}
@@ -0,0 +1,326 @@
+package archiver
+
+import (
+	"context"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/fs"
+	"github.com/konidev20/rapi/restic"
+	"golang.org/x/sync/errgroup"
+)
+
+// dirEntry is an item of a directory listed by the dirScanner. The file info
+// is nil if the item is excluded by name or Lstat failed, the archiver then
+// runs Lstat itself so that errors are reported as usual.
+type dirEntry struct {
+	name string
+	fi   os.FileInfo
+}
+
+type scanState int
+
+const (
+	scanQueued scanState = iota
+	scanRunning
+	scanDone
+)
+
+// scannedDir is a directory which is scheduled to be listed, or which has
+// been listed but not taken by the archiver yet.
+type scannedDir struct {
+	state   scanState
+	dropped bool
+	done    chan struct{}
+
+	entries []dirEntry
+	err     error
+}
+
+// dirScanner lists directories and runs Lstat for their items ahead of the
+// archiver, which walks the tree serially in lexicographic order. Each listed
+// directory schedules its subdirectories. Every worker has its own queue, it
+// takes the directory scheduled last from it, so that it descends into the
+// tree depth-first like the archiver does. Idle workers steal the directory
+// scheduled first from the queues of other workers.
+//
+// The number of directories which have been listed but not taken is limited
+// to maxPending. A directory which is needed by the archiver before a worker
+// listed it is listed by the archiver itself.
+type dirScanner struct {
+	fs           fs.FS
+	selectByName SelectByNameFunc
+	deviceFilter *DeviceFilter
+	maxPending   int
+
+	// stages records the time spent listing directories, may be nil.
+	stages *restic.StageTimes
+	// links are the followed symlinked targets, may be nil.
+	links *targetLinks
+
+	m       sync.Mutex
+	wakeup  *sync.Cond
+	dirs    map[string]*scannedDir
+	queues  [][]string
+	next    int
+	pending int
+	closed  bool
+}
+
+// dirsPendingPerWorker limits the number of directories each worker lists
+// ahead of the archiver.
+const dirsPendingPerWorker = 64
+
+// newDirScanner starts workers which list directories, they are stopped when
+// ctx is cancelled or TriggerShutdown is called.
+func newDirScanner(ctx context.Context, wg *errgroup.Group, filesystem fs.FS, selectByName SelectByNameFunc, deviceFilter *DeviceFilter, workers uint) *dirScanner {
+	s := &dirScanner{
+		fs:           filesystem,
+		selectByName: selectByName,
+		deviceFilter: deviceFilter,
+		maxPending:   int(workers) * dirsPendingPerWorker,
+		dirs:         make(map[string]*scannedDir),
+		queues:       make([][]string, workers),
+	}
+	s.wakeup = sync.NewCond(&s.m)
+
+	var workerWg sync.WaitGroup
+	stopped := make(chan struct{})
+	for i := 0; i < int(workers); i++ {
+		i := i
+		workerWg.Add(1)
+		wg.Go(func() error {
+			defer workerWg.Done()
+			s.worker(i)
+			return nil
+		})
+	}
+
+	wg.Go(func() error {
+		workerWg.Wait()
+		close(stopped)
+		return nil
+	})
+	wg.Go(func() error {
+		select {
+		case <-ctx.Done():
+			s.TriggerShutdown()
+		case <-stopped:
+		}
+		return nil
+	})
+
+	return s
+}
+
+// TriggerShutdown stops the workers once they have listed the current
+// directory.
+func (s *dirScanner) TriggerShutdown() {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.closed = true
+	s.wakeup.Broadcast()
+}
+
+func (s *dirScanner) worker(id int) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for {
+		if s.closed {
+			return
+		}
+
+		dir, d := s.take(id)
+		if d == nil {
+			s.wakeup.Wait()
+			continue
+		}
+
+		d.state = scanRunning
+		s.pending++
+		s.m.Unlock()
+
+		entries, err := s.list(dir)
+
+		s.m.Lock()
+		d.entries, d.err = entries, err
+		d.state = scanDone
+		close(d.done)
+
+		if d.dropped {
+			// the archiver does not need the directory anymore
+			delete(s.dirs, dir)
+			s.pending--
+			s.wakeup.Broadcast()
+			continue
+		}
+		s.schedule(id, dir, entries)
+	}
+}
+
+// take returns the next directory to be listed by worker id, or nil if there
+// is none or too many directories are pending. s.m must be held.
+func (s *dirScanner) take(id int) (string, *scannedDir) {
+	if s.pending >= s.maxPending {
+		return "", nil
+	}
+
+	// the own queue is used as a stack
+	for q := s.queues[id]; len(q) > 0; q = s.queues[id] {
+		dir := q[len(q)-1]
+		s.queues[id] = q[:len(q)-1]
+		if d, ok := s.dirs[dir]; ok && d.state == scanQueued {
+			return dir, d
+		}
+	}
+
+	// steal the oldest directory from another queue
+	for i := 1; i < len(s.queues); i++ {
+		victim := (id + i) % len(s.queues)
+		for q := s.queues[victim]; len(q) > 0; q = s.queues[victim] {
+			dir := q[0]
+			s.queues[victim] = q[1:]
+			if d, ok := s.dirs[dir]; ok && d.state == scanQueued {
+				debug.Log("worker %d stole %v from worker %d", id, dir, victim)
+				return dir, d
+			}
+		}
+	}
+
+	return "", nil
+}
+
+// schedule adds the subdirectories of dir to the queue of worker id. s.m must
+// be held.
+func (s *dirScanner) schedule(id int, dir string, entries []dirEntry) {
+	// push in reverse order, so that the first subdirectory is taken first
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if e.fi == nil || !e.fi.IsDir() {
+			continue
+		}
+		sub := s.fs.Join(dir, e.name)
+		if _, ok := s.dirs[sub]; ok {
+			continue
+		}
+		s.dirs[sub] = &scannedDir{state: scanQueued, done: make(chan struct{})}
+		s.queues[id] = append(s.queues[id], sub)
+	}
+	s.wakeup.Broadcast()
+}
+
+// list returns the sorted items of dir. Lstat is only run for items which are
+// not excluded by name, and subdirectories on other file systems are not
+// descended into.
+func (s *dirScanner) list(dir string) ([]dirEntry, error) {
+	start := time.Now()
+	defer s.stages.Since(restic.StageScan, start)
+
+	flags := fs.O_NOFOLLOW
+	if s.links != nil {
+		if abs, err := s.fs.Abs(dir); err == nil {
+			flags = s.links.openFlags(abs)
+		}
+	}
+	names, err := readdirnames(s.fs, dir, flags)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	entries := make([]dirEntry, 0, len(names))
+	for _, name := range names {
+		e := dirEntry{name: name}
+		pathname := s.fs.Join(dir, name)
+		abs, err := s.fs.Abs(pathname)
+		if err == nil && s.selectByName(abs) {
+			fi, err := s.fs.Lstat(pathname)
+			if err == nil && !(fi.IsDir() && s.deviceFilter != nil && !s.deviceFilter.Select(abs, fi)) {
+				e.fi = fi
+			}
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+// Lookup returns the items of dir. If a worker has not started listing the
+// directory yet, it is listed by the caller.
+func (s *dirScanner) Lookup(ctx context.Context, dir string) ([]dirEntry, error) {
+	s.m.Lock()
+	d, ok := s.dirs[dir]
+	if ok && d.state != scanQueued {
+		s.m.Unlock()
+
+		select {
+		case <-d.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		s.m.Lock()
+		delete(s.dirs, dir)
+		s.pending--
+		s.wakeup.Broadcast()
+		s.m.Unlock()
+		return d.entries, d.err
+	}
+
+	// workers skip directories which are not in the map anymore
+	delete(s.dirs, dir)
+	s.m.Unlock()
+
+	entries, err := s.list(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	s.m.Lock()
+	s.next = (s.next + 1) % len(s.queues)
+	s.schedule(s.next, dir, entries)
+	s.m.Unlock()
+
+	return entries, nil
+}
+
+// Forget drops the subdirectories of dir which were not looked up, for
+// example because they were excluded by the archiver. This is called once
+// the archiver is done with dir.
+func (s *dirScanner) Forget(dir string, entries []dirEntry) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.forget(dir, entries)
+}
+
+// forget drops the subdirectories in entries. s.m must be held.
+func (s *dirScanner) forget(dir string, entries []dirEntry) {
+	for _, e := range entries {
+		if e.fi == nil || !e.fi.IsDir() {
+			continue
+		}
+		sub := s.fs.Join(dir, e.name)
+		d, ok := s.dirs[sub]
+		if !ok {
+			continue
+		}
+
+		switch d.state {
+		case scanQueued:
+			delete(s.dirs, sub)
+		case scanRunning:
+			d.dropped = true
+		case scanDone:
+			delete(s.dirs, sub)
+			s.pending--
+			s.forget(sub, d.entries)
+		}
+	}
+	s.wakeup.Broadcast()
+}
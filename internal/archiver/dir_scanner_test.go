@@ -0,0 +1,88 @@
+package archiver
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/konidev20/rapi/internal/fs"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"golang.org/x/sync/errgroup"
+)
+
+// testScanDir returns a tree with depth levels of width subdirectories, each
+// containing some files.
+func testScanDir(depth, width int) TestDir {
+	dir := TestDir{}
+	for i := 0; i < 3; i++ {
+		dir[fmt.Sprintf("file%d", i)] = TestFile{Content: "foo"}
+	}
+	if depth == 0 {
+		return dir
+	}
+	for i := 0; i < width; i++ {
+		dir[fmt.Sprintf("dir%d", i)] = testScanDir(depth-1, width)
+	}
+	return dir
+}
+
+func TestDirScanner(t *testing.T) {
+	for _, workers := range []uint{1, 4} {
+		t.Run(fmt.Sprintf("workers-%d", workers), func(t *testing.T) {
+			tempdir := rtest.TempDir(t)
+			TestCreateFiles(t, tempdir, testScanDir(3, 4))
+
+			wg, ctx := errgroup.WithContext(context.Background())
+			s := newDirScanner(ctx, wg, fs.Local{}, func(string) bool { return true }, nil, workers)
+
+			var walk func(dir string) []string
+			walk = func(dir string) []string {
+				entries, err := s.Lookup(ctx, dir)
+				rtest.OK(t, err)
+				defer s.Forget(dir, entries)
+
+				var items []string
+				for _, e := range entries {
+					rtest.Assert(t, e.fi != nil, "no file info for %v", e.name)
+					pathname := filepath.Join(dir, e.name)
+					items = append(items, pathname)
+					// skip the subdirectories named dir3 to test that they are dropped
+					if e.fi.IsDir() && e.name != "dir3" {
+						items = append(items, walk(pathname)...)
+					}
+				}
+				return items
+			}
+
+			items := walk(tempdir)
+
+			var want []string
+			err := filepath.Walk(tempdir, func(p string, fi os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if fi.IsDir() && fi.Name() == "dir3" {
+					want = append(want, p)
+					return filepath.SkipDir
+				}
+				if p != tempdir {
+					want = append(want, p)
+				}
+				return nil
+			})
+			rtest.OK(t, err)
+
+			// filepath.Walk sorts the names of each directory, the scanner
+			// returns items in the same order
+			rtest.Equals(t, want, items)
+
+			s.TriggerShutdown()
+			rtest.OK(t, wg.Wait())
+
+			rtest.Equals(t, 0, len(s.dirs))
+			rtest.Equals(t, 0, s.pending)
+		})
+	}
+}
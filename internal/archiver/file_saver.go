@@ -1,11 +1,13 @@
 package archiver
 
 import (
+	"bufio"
 	"context"
 	"fmt"
 	"io"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/restic/chunker"
 	"github.com/konidev20/rapi/internal/debug"
@@ -25,17 +27,48 @@ type FileSaver struct {
 
 	pol chunker.Pol
 
-	ch chan<- saveFileJob
+	ch chan<- []saveFileJob
+
+	// batch collects small files which are sent to a worker together, it
+	// is protected by batchMu. The lock is held while sending to ch, so
+	// that the files are read in the order they were saved.
+	batchMu    sync.Mutex
+	batch      []saveFileJob
+	batchBytes int64
 
 	CompleteBlob func(bytes uint64)
 
 	NodeFromFileInfo func(snPath, filename string, fi os.FileInfo) (*restic.Node, error)
+
+	// ScanContent returns the scanner for the contents of a file, may be nil.
+	// Flagged is called for files which a scanner flagged or skipped.
+	ScanContent ContentScanFunc
+	Flagged     func(snPath string, v ScanVerdict)
+
+	// ReadAhead is the size of the buffer used to read files, zero means
+	// that the chunker reads from the file directly. Buffers not larger than
+	// the read size of the chunker have no effect, see MinReadAheadSize. It
+	// must be set before the first file is saved.
+	ReadAhead int
+
+	// stages records the time spent reading and chunking files, may be nil.
+	stages *restic.StageTimes
 }
 
+// Files smaller than the minimum chunk size are always stored in a single
+// blob. Instead of handing each of them to a worker on its own, up to
+// smallFileBatch files or smallFileBatchBytes are sent to a worker at once,
+// which reads them back-to-back with the same chunker. This reduces the
+// overhead per file for directories with many tiny files.
+const (
+	smallFileBatch      = 32
+	smallFileBatchBytes = 4 * 1024 * 1024
+)
+
 // NewFileSaver returns a new file saver. A worker pool with fileWorkers is
 // started, it is stopped when ctx is cancelled.
 func NewFileSaver(ctx context.Context, wg *errgroup.Group, save SaveBlobFn, pol chunker.Pol, fileWorkers, blobWorkers uint) *FileSaver {
-	ch := make(chan saveFileJob)
+	ch := make(chan []saveFileJob)
 
 	debug.Log("new file saver with %v file workers and %v blob workers", fileWorkers, blobWorkers)
 
@@ -61,6 +94,13 @@ func NewFileSaver(ctx context.Context, wg *errgroup.Group, save SaveBlobFn, pol
 }
 
 func (s *FileSaver) TriggerShutdown() {
+	s.batchMu.Lock()
+	defer s.batchMu.Unlock()
+
+	// all futures have been taken before, so the batch is empty unless the
+	// backup was aborted
+	cancelJobs(s.batch)
+	s.batch = nil
 	close(s.ch)
 }
 
@@ -85,15 +125,64 @@ func (s *FileSaver) Save(ctx context.Context, snPath string, target string, file
 		complete:        complete,
 	}
 
+	if fi.Size() >= chunker.MinSize {
+		s.batchMu.Lock()
+		defer s.batchMu.Unlock()
+
+		s.send(ctx, []saveFileJob{job})
+		return fn
+	}
+
+	s.batchMu.Lock()
+	defer s.batchMu.Unlock()
+
+	s.batch = append(s.batch, job)
+	s.batchBytes += fi.Size()
+	if len(s.batch) >= smallFileBatch || s.batchBytes >= smallFileBatchBytes {
+		s.sendBatch(ctx)
+	}
+
+	// the batch is sent at the latest when the result is needed
+	fn.flush = s.Flush
+	return fn
+}
+
+// Flush sends the files which are waiting to be batched to a worker.
+func (s *FileSaver) Flush(ctx context.Context) {
+	s.batchMu.Lock()
+	defer s.batchMu.Unlock()
+
+	s.sendBatch(ctx)
+}
+
+// sendBatch sends the pending batch to a worker, s.batchMu must be held.
+func (s *FileSaver) sendBatch(ctx context.Context) {
+	if len(s.batch) == 0 {
+		return
+	}
+
+	debug.Log("sending batch of %d small files (%d bytes)", len(s.batch), s.batchBytes)
+	s.send(ctx, s.batch)
+	s.batch = nil
+	s.batchBytes = 0
+}
+
+// send passes jobs to a worker, s.batchMu must be held.
+func (s *FileSaver) send(ctx context.Context, jobs []saveFileJob) {
 	select {
-	case s.ch <- job:
+	case s.ch <- jobs:
 	case <-ctx.Done():
-		debug.Log("not sending job, context is cancelled: %v", ctx.Err())
-		_ = file.Close()
-		close(ch)
+		debug.Log("not sending jobs, context is cancelled: %v", ctx.Err())
+		cancelJobs(jobs)
 	}
+}
 
-	return fn
+// cancelJobs closes the files of jobs which are not saved.
+func cancelJobs(jobs []saveFileJob) {
+	for _, job := range jobs {
+		_ = job.file.Close()
+		close(job.ch)
+	}
 }
 
 type saveFileJob struct {
@@ -109,7 +198,7 @@ type saveFileJob struct {
 }
 
 // saveFile stores the file f in the repo, then closes it.
-func (s *FileSaver) saveFile(ctx context.Context, chnker *chunker.Chunker, snPath string, target string, f fs.File, fi os.FileInfo, start func(), finishReading func(), finish func(res futureNodeResult)) {
+func (s *FileSaver) saveFile(ctx context.Context, chnker *chunker.Chunker, rd io.Reader, snPath string, target string, f fs.File, fi os.FileInfo, start func(), finishReading func(), finish func(res futureNodeResult)) {
 	start()
 
 	fnr := futureNodeResult{
@@ -129,9 +218,11 @@ func (s *FileSaver) saveFile(ctx context.Context, chnker *chunker.Chunker, snPat
 			if isCompleted {
 				panic("completed twice")
 			}
-			for _, id := range fnr.node.Content {
-				if id.IsNull() {
-					panic("completed file with null ID")
+			if fnr.node != nil {
+				for _, id := range fnr.node.Content {
+					if id.IsNull() {
+						panic("completed file with null ID")
+					}
 				}
 			}
 			isCompleted = true
@@ -169,15 +260,39 @@ func (s *FileSaver) saveFile(ctx context.Context, chnker *chunker.Chunker, snPat
 		return
 	}
 
+	var scanner ContentScanner
+	if s.ScanContent != nil {
+		scanner = s.ScanContent(snPath, fi)
+	}
+
+	// the chunker reads the file, the time spent reading is subtracted from
+	// the time spent in chunker.Next
+	var timed *timedReader
+	if s.stages != nil {
+		timed = &timedReader{rd: rd}
+		rd = timed
+		defer func() {
+			s.stages.Add(restic.StageRead, timed.d)
+		}()
+	}
+
 	// reuse the chunker
-	chnker.Reset(f, s.pol)
+	chnker.Reset(rd, s.pol)
 
 	node.Content = []restic.ID{}
 	node.Size = 0
 	var idx int
 	for {
 		buf := s.saveFilePool.Get()
+		var start time.Time
+		var readBefore time.Duration
+		if timed != nil {
+			start, readBefore = time.Now(), timed.d
+		}
 		chunk, err := chnker.Next(buf.Data)
+		if timed != nil {
+			s.stages.Add(restic.StageChunk, time.Since(start)-(timed.d-readBefore))
+		}
 		if err == io.EOF {
 			buf.Release()
 			break
@@ -198,6 +313,16 @@ func (s *FileSaver) saveFile(ctx context.Context, chnker *chunker.Chunker, snPat
 			return
 		}
 
+		// the buffer is passed on to saveBlob, so the scanner must not keep it
+		if scanner != nil {
+			if _, err := scanner.Write(chunk.Data); err != nil {
+				buf.Release()
+				_ = f.Close()
+				completeError(errors.Wrap(err, "scan"))
+				return
+			}
+		}
+
 		// add a place to store the saveBlob result
 		pos := idx
 
@@ -236,7 +361,25 @@ func (s *FileSaver) saveFile(ctx context.Context, chnker *chunker.Chunker, snPat
 		return
 	}
 
-	fnr.node = node
+	skip := false
+	if scanner != nil {
+		v, err := scanner.Verdict()
+		if err != nil {
+			completeError(errors.Wrap(err, "scan"))
+			return
+		}
+		if v.Action != ScanAllow && s.Flagged != nil {
+			s.Flagged(snPath, v)
+		}
+		skip = v.Action == ScanSkip
+	}
+
+	if skip {
+		// a nil node excludes the file from the tree
+		debug.Log("%v skipped by scanner", snPath)
+	} else {
+		fnr.node = node
+	}
 	lock.Lock()
 	// require one additional completeFuture() call to ensure that the future only completes
 	// after reaching the end of this method
@@ -246,32 +389,58 @@ func (s *FileSaver) saveFile(ctx context.Context, chnker *chunker.Chunker, snPat
 	completeBlob()
 }
 
-func (s *FileSaver) worker(ctx context.Context, jobs <-chan saveFileJob) {
+// timedReader records the time spent in Read.
+type timedReader struct {
+	rd io.Reader
+	d  time.Duration
+}
+
+func (r *timedReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := r.rd.Read(p)
+	r.d += time.Since(start)
+	return n, err
+}
+
+func (s *FileSaver) worker(ctx context.Context, batches <-chan []saveFileJob) {
 	// a worker has one chunker which is reused for each file (because it contains a rather large buffer)
 	chnker := chunker.New(nil, s.pol)
+	var readAhead *bufio.Reader
 
 	for {
-		var job saveFileJob
+		var jobs []saveFileJob
 		var ok bool
 		select {
 		case <-ctx.Done():
 			return
-		case job, ok = <-jobs:
+		case jobs, ok = <-batches:
 			if !ok {
 				return
 			}
 		}
 
-		s.saveFile(ctx, chnker, job.snPath, job.target, job.file, job.fi, job.start, func() {
-			if job.completeReading != nil {
-				job.completeReading()
-			}
-		}, func(res futureNodeResult) {
-			if job.complete != nil {
-				job.complete(res.node, res.stats)
+		for _, job := range jobs {
+			job := job
+			var rd io.Reader = job.file
+			if s.ReadAhead > 0 {
+				if readAhead == nil {
+					readAhead = bufio.NewReaderSize(nil, s.ReadAhead)
+				}
+				readAhead.Reset(job.file)
+				rd = readAhead
 			}
-			job.ch <- res
-			close(job.ch)
-		})
+
+			s.saveFile(ctx, chnker, rd, job.snPath, job.target, job.file, job.fi, job.start, func() {
+				if job.completeReading != nil {
+					job.completeReading()
+				}
+			}, func(res futureNodeResult) {
+				if job.complete != nil {
+					job.complete(res.node, res.stats)
+				}
+				job.ch <- res
+				close(job.ch)
+			})
+		}
 	}
 }
@@ -57,6 +57,14 @@ func startFileSaver(ctx context.Context, t testing.TB) (*FileSaver, context.Cont
 }
 
 func TestFileSaver(t *testing.T) {
+	for _, readAhead := range []int{0, MinReadAheadSize} {
+		t.Run(fmt.Sprintf("read-ahead-%d", readAhead), func(t *testing.T) {
+			testFileSaver(t, readAhead)
+		})
+	}
+}
+
+func testFileSaver(t *testing.T, readAhead int) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -68,6 +76,7 @@ func TestFileSaver(t *testing.T) {
 
 	testFs := fs.Local{}
 	s, ctx, wg := startFileSaver(ctx, t)
+	s.ReadAhead = readAhead
 
 	var results []FutureNode
 
@@ -86,10 +95,14 @@ func TestFileSaver(t *testing.T) {
 		results = append(results, ff)
 	}
 
-	for _, file := range results {
+	for i, file := range results {
 		fnr := file.take(ctx)
 		if fnr.err != nil {
 			t.Errorf("unable to save file: %v", fnr.err)
+			continue
+		}
+		if want := uint64(len(filepath.Base(files[i]))); fnr.node.Size != want {
+			t.Errorf("wrong size for %v: want %d, got %d", files[i], want, fnr.node.Size)
 		}
 	}
 
@@ -100,3 +113,61 @@ func TestFileSaver(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestFileSaverBatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	files := createTestFiles(t, smallFileBatch+5)
+	s, ctx, wg := startFileSaver(ctx, t)
+
+	var results []FutureNode
+	for i, filename := range files {
+		f, err := fs.Local{}.Open(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		results = append(results, s.Save(ctx, filename, filename, f, fi, func() {}, func() {}, func(*restic.Node, ItemStats) {}))
+
+		s.batchMu.Lock()
+		pending := len(s.batch)
+		s.batchMu.Unlock()
+		if want := (i + 1) % smallFileBatch; pending != want {
+			t.Fatalf("after saving %d files: want %d files in the batch, got %d", i+1, want, pending)
+		}
+	}
+
+	// taking a result sends the remaining files to a worker
+	for i := range results {
+		fnr := results[len(results)-1-i].take(ctx)
+		if fnr.err != nil {
+			t.Fatalf("unable to save file: %v", fnr.err)
+		}
+	}
+
+	s.TriggerShutdown()
+	if err := wg.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOptionsReadAheadSize(t *testing.T) {
+	for _, test := range []struct {
+		size, want uint
+	}{
+		{0, 0},
+		{4096, MinReadAheadSize},
+		{MinReadAheadSize, MinReadAheadSize},
+		{8 << 20, 8 << 20},
+	} {
+		opts := Options{ReadAheadSize: test.size}.ApplyDefaults()
+		if opts.ReadAheadSize != test.want {
+			t.Errorf("ReadAheadSize %d: want %d, got %d", test.size, test.want, opts.ReadAheadSize)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package archiver
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/internal/fs"
+)
+
+// ExcludeMarker is a file which marks the directory it is located in as
+// excluded. If Header is not empty, the file must start with it.
+type ExcludeMarker struct {
+	Name   string
+	Header string
+}
+
+// CacheDirTag marks cache directories, see https://bford.info/cachedir/.
+var CacheDirTag = ExcludeMarker{
+	Name:   "CACHEDIR.TAG",
+	Header: "Signature: 8a477f597d28d172789f06886806bc55",
+}
+
+// MarkerFilter rejects the contents of directories which contain one of the
+// marker files. Like with restic's --exclude-caches, the directory itself and
+// the marker files are kept, so that the directory is recreated as marked on
+// restore.
+type MarkerFilter struct {
+	fs      fs.FS
+	markers []ExcludeMarker
+
+	m sync.Mutex
+	// marked caches whether a directory contains a marker.
+	marked map[string]bool
+}
+
+// NewMarkerFilter returns a MarkerFilter for markers.
+func NewMarkerFilter(filesys fs.FS, markers []ExcludeMarker) *MarkerFilter {
+	return &MarkerFilter{
+		fs:      filesys,
+		markers: markers,
+		marked:  make(map[string]bool),
+	}
+}
+
+// SelectByName returns false for items in marked directories, except for the
+// markers. It can be used as a SelectByNameFunc and is safe for concurrent
+// use.
+func (f *MarkerFilter) SelectByName(item string) bool {
+	name := f.fs.Base(item)
+	for _, m := range f.markers {
+		if name == m.Name {
+			return true
+		}
+	}
+
+	dir := f.fs.Dir(item)
+	f.m.Lock()
+	marked, ok := f.marked[dir]
+	f.m.Unlock()
+	if !ok {
+		marked = f.isMarked(dir)
+		f.m.Lock()
+		f.marked[dir] = marked
+		f.m.Unlock()
+	}
+
+	if marked {
+		debug.Log("%v is in a marked directory, rejecting", item)
+	}
+	return !marked
+}
+
+// isMarked returns true if dir contains one of the markers.
+func (f *MarkerFilter) isMarked(dir string) bool {
+	for _, m := range f.markers {
+		ok, err := f.hasMarker(dir, m)
+		if err != nil {
+			debug.Log("unable to check marker %v in %v: %v", m.Name, dir, err)
+			continue
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *MarkerFilter) hasMarker(dir string, m ExcludeMarker) (bool, error) {
+	filename := f.fs.Join(dir, m.Name)
+	fi, err := f.fs.Lstat(filename)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	if m.Header == "" {
+		return true, nil
+	}
+	if !fi.Mode().IsRegular() {
+		return false, nil
+	}
+
+	file, err := f.fs.Open(filename)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	buf := make([]byte, len(m.Header))
+	_, err = io.ReadFull(file, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		// too short for the header
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(buf, []byte(m.Header)), nil
+}
@@ -0,0 +1,102 @@
+package archiver
+
+import (
+	"os"
+
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/internal/fs"
+)
+
+// targetLinks dereferences backup targets which are symlinks, so that the
+// file or directory the link points to is saved under the path of the link.
+// Only the targets are dereferenced, symlinks below them are saved as links.
+type targetLinks struct {
+	// resolved maps the absolute paths of the symlinked targets to the file
+	// info of the item they point to, or to the error which prevented
+	// following them.
+	resolved map[string]os.FileInfo
+	errs     map[string]error
+}
+
+// newTargetLinks resolves the symlinks among targets.
+func newTargetLinks(filesys fs.FS, targets []string) (*targetLinks, error) {
+	t := &targetLinks{
+		resolved: make(map[string]os.FileInfo),
+		errs:     make(map[string]error),
+	}
+
+	for _, target := range targets {
+		abs, err := filesys.Abs(target)
+		if err != nil {
+			return nil, err
+		}
+		abs = filesys.Clean(abs)
+
+		fi, err := filesys.Lstat(abs)
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			// errors are reported when the target is saved
+			continue
+		}
+
+		fi, err = followTarget(filesys, abs)
+		if err != nil {
+			debug.Log("not following target %v: %v", abs, err)
+			t.errs[abs] = err
+			continue
+		}
+		debug.Log("following target %v", abs)
+		t.resolved[abs] = fi
+	}
+
+	return t, nil
+}
+
+// followTarget returns the file info of the item the symlink target points
+// to. It returns an error if the links form a loop or if target points to
+// one of its parent directories, which would contain the link itself.
+func followTarget(filesys fs.FS, target string) (os.FileInfo, error) {
+	fi, err := filesys.Stat(target)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to follow symlink")
+	}
+	if !fi.IsDir() {
+		return fi, nil
+	}
+
+	for dir := filesys.Dir(target); ; dir = filesys.Dir(dir) {
+		parent, err := filesys.Lstat(dir)
+		if err == nil && os.SameFile(fi, parent) {
+			return nil, errors.Errorf("symlink cycle: %v points to its parent directory %v", target, dir)
+		}
+		if filesys.Dir(dir) == dir {
+			break
+		}
+	}
+
+	return fi, nil
+}
+
+// stat returns the file info of the item target points to if target is a
+// followed symlink, and fi otherwise. An error is returned for symlinked
+// targets which cannot be followed.
+func (t *targetLinks) stat(target string, fi os.FileInfo) (os.FileInfo, error) {
+	if err, ok := t.errs[target]; ok {
+		return fi, err
+	}
+	if resolved, ok := t.resolved[target]; ok {
+		return resolved, nil
+	}
+	return fi, nil
+}
+
+// openFlags returns the flags for opening target, symlinks are not followed
+// unless target is a followed symlink. It can be called on a nil targetLinks.
+func (t *targetLinks) openFlags(target string) int {
+	if t != nil {
+		if _, ok := t.resolved[target]; ok {
+			return 0
+		}
+	}
+	return fs.O_NOFOLLOW
+}
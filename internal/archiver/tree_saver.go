@@ -5,7 +5,9 @@ import (
 	"errors"
 
 	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/tracing"
 	"github.com/konidev20/rapi/restic"
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -72,7 +74,10 @@ type saveTreeJob struct {
 }
 
 // save stores the nodes as a tree in the repo.
-func (s *TreeSaver) save(ctx context.Context, job *saveTreeJob) (*restic.Node, ItemStats, error) {
+func (s *TreeSaver) save(ctx context.Context, job *saveTreeJob) (_ *restic.Node, _ ItemStats, err error) {
+	ctx, span := tracing.Start(ctx, "archiver.SaveTree", attribute.String("restic.path", job.snPath))
+	defer func() { tracing.End(span, err) }()
+
 	var stats ItemStats
 	node := job.node
 	nodes := job.nodes
@@ -7,6 +7,7 @@ import (
 
 	"github.com/konidev20/rapi/backend"
 	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/metrics"
 )
 
 // Backend wraps a restic.Backend and adds a cache.
@@ -163,6 +164,7 @@ func (b *Backend) Load(ctx context.Context, h backend.Handle, length int, offset
 	inCache, err := b.loadFromCache(h, length, offset, consumer)
 	if inCache {
 		if err == nil {
+			metrics.Default.CacheHit(h.Type)
 			return nil
 		}
 
@@ -170,6 +172,7 @@ func (b *Backend) Load(ctx context.Context, h backend.Handle, length int, offset
 		_ = b.Cache.remove(h)
 	}
 	debug.Log("error loading %v from cache: %v", h, err)
+	metrics.Default.CacheMiss(h.Type)
 
 	// if we don't automatically cache this file type, fall back to the backend
 	if !autoCacheTypes(h) {
@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -20,11 +21,35 @@ type Cache struct {
 	path    string
 	Base    string
 	Created bool
+
+	// shared is set when the cache is used by several OS users, see shared.go.
+	shared bool
+
+	// mem holds all files if the cache is kept in memory, see memory.go.
+	mem *memStore
+
+	// quotaMu protects maxSize and usage, see quota.go, and spotCheckRate
+	// and hash, see verify.go.
+	quotaMu       sync.Mutex
+	maxSize       int64
+	usage         int64
+	usageKnown    bool
+	spotCheckRate float64
+	hash          restic.HashAlgorithm
 }
 
 const dirMode = 0700
 const fileMode = 0644
 
+// cacheModes returns the permissions for new directories and files in the
+// cache.
+func cacheModes(shared bool) (dir, file os.FileMode) {
+	if shared {
+		return sharedDirMode, sharedFileMode
+	}
+	return dirMode, fileMode
+}
+
 func readVersion(dir string) (v uint, err error) {
 	buf, err := os.ReadFile(filepath.Join(dir, "version"))
 	if err != nil {
@@ -49,9 +74,9 @@ var cacheLayoutPaths = map[restic.FileType]string{
 
 const cachedirTagSignature = "Signature: 8a477f597d28d172789f06886806bc55\n"
 
-func writeCachedirTag(dir string) error {
+func writeCachedirTag(dir string, perm os.FileMode) error {
 	tagfile := filepath.Join(dir, "CACHEDIR.TAG")
-	f, err := fs.OpenFile(tagfile, os.O_CREATE|os.O_EXCL|os.O_WRONLY, fileMode)
+	f, err := fs.OpenFile(tagfile, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
 	if err != nil {
 		if errors.Is(err, os.ErrExist) {
 			return nil
@@ -82,13 +107,19 @@ func New(id string, basedir string) (c *Cache, err error) {
 		}
 	}
 
-	err = fs.MkdirAll(basedir, dirMode)
+	return newCache(id, basedir, false)
+}
+
+func newCache(id string, basedir string, shared bool) (c *Cache, err error) {
+	dirMode, fileMode := cacheModes(shared)
+
+	err = mkdirAll(basedir, dirMode, shared)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
 	// create base dir and tag it as a cache directory
-	if err = writeCachedirTag(basedir); err != nil {
+	if err = writeCachedirTag(basedir, fileMode); err != nil {
 		return nil, err
 	}
 
@@ -110,7 +141,7 @@ func New(id string, basedir string) (c *Cache, err error) {
 
 	case errors.Is(err, os.ErrNotExist):
 		// Create the repo cache dir. The parent exists, so Mkdir suffices.
-		err := fs.Mkdir(cachedir, dirMode)
+		err := mkdir(cachedir, dirMode, shared)
 		switch {
 		case err == nil:
 			created = true
@@ -128,10 +159,16 @@ func New(id string, basedir string) (c *Cache, err error) {
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
+		if shared {
+			err = fs.Chmod(filepath.Join(cachedir, "version"), fileMode)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+		}
 	}
 
 	for _, p := range cacheLayoutPaths {
-		if err = fs.MkdirAll(filepath.Join(cachedir, p), dirMode); err != nil {
+		if err = mkdirAll(filepath.Join(cachedir, p), dirMode, shared); err != nil {
 			return nil, errors.WithStack(err)
 		}
 	}
@@ -140,6 +177,9 @@ func New(id string, basedir string) (c *Cache, err error) {
 		path:    cachedir,
 		Base:    basedir,
 		Created: created,
+		shared:  shared,
+
+		spotCheckRate: DefaultSpotCheckRate,
 	}
 
 	return c, nil
@@ -243,3 +283,9 @@ func (c *Cache) Wrap(be backend.Backend) backend.Backend {
 func (c *Cache) BaseDir() string {
 	return c.Base
 }
+
+// Dir returns the directory of the cache for the repository. It is empty if
+// the cache is kept in memory.
+func (c *Cache) Dir() string {
+	return c.path
+}
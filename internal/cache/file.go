@@ -40,7 +40,18 @@ func (c *Cache) load(h backend.Handle, length int, offset int64) (io.ReadCloser,
 		return nil, errors.New("cannot be cached")
 	}
 
+	if c.mem != nil {
+		return c.mem.load(h, length, offset)
+	}
+
+	// the lock is only held while the file is opened, an open file can still
+	// be read after it has been removed
+	unlock, err := c.lock(false)
+	if err != nil {
+		return nil, err
+	}
 	f, err := fs.Open(c.filename(h))
+	unlock()
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -64,6 +75,17 @@ func (c *Cache) load(h backend.Handle, length int, offset int64) (io.ReadCloser,
 		return nil, errors.Errorf("cached file %v is too small, removing", h)
 	}
 
+	// complete reads are verified while the data is read, for partial reads
+	// the whole file is checked once in a while
+	partial := offset > 0 || (length > 0 && int64(length) < size)
+	if partial && c.spotCheck() {
+		if err = c.verifyFile(h); err != nil {
+			_ = f.Close()
+			_ = c.remove(h)
+			return nil, err
+		}
+	}
+
 	if offset > 0 {
 		if _, err = f.Seek(offset, io.SeekStart); err != nil {
 			_ = f.Close()
@@ -71,6 +93,11 @@ func (c *Cache) load(h backend.Handle, length int, offset int64) (io.ReadCloser,
 		}
 	}
 
+	c.touch(h)
+
+	if !partial {
+		return newVerifyingReader(f, c, h), nil
+	}
 	if length <= 0 {
 		return f, nil
 	}
@@ -87,38 +114,72 @@ func (c *Cache) Save(h backend.Handle, rd io.Reader) error {
 		return errors.New("cannot be cached")
 	}
 
+	var n int64
+	var err error
+	if c.mem != nil {
+		n, err = c.mem.save(h, rd)
+	} else {
+		var unlock func()
+		unlock, err = c.lock(false)
+		if err != nil {
+			return err
+		}
+		n, err = c.save(h, rd)
+		unlock()
+	}
+
+	if err == nil {
+		c.addUsage(n)
+	}
+	return err
+}
+
+// save stores the data from rd in the cache and returns the number of bytes
+// written.
+func (c *Cache) save(h backend.Handle, rd io.Reader) (int64, error) {
 	finalname := c.filename(h)
 	dir := filepath.Dir(finalname)
-	err := fs.Mkdir(dir, 0700)
+	dirMode, fileMode := cacheModes(c.shared)
+	err := mkdir(dir, dirMode, c.shared)
 	if err != nil && !errors.Is(err, os.ErrExist) {
-		return err
+		return 0, err
 	}
 
 	// First save to a temporary location. This allows multiple concurrent
 	// restics to use a single cache dir.
 	f, err := os.CreateTemp(dir, "tmp-")
 	if err != nil {
-		return err
+		return 0, err
+	}
+
+	// CreateTemp always uses mode 0600, which other users of a shared cache
+	// cannot read.
+	if c.shared {
+		if err = f.Chmod(fileMode); err != nil {
+			_ = f.Close()
+			_ = fs.Remove(f.Name())
+			return 0, errors.WithStack(err)
+		}
 	}
 
 	n, err := io.Copy(f, rd)
 	if err != nil {
 		_ = f.Close()
 		_ = fs.Remove(f.Name())
-		return errors.Wrap(err, "Copy")
+		return 0, errors.Wrap(err, "Copy")
 	}
 
 	if n <= int64(crypto.CiphertextLength(0)) {
 		_ = f.Close()
 		_ = fs.Remove(f.Name())
 		debug.Log("trying to cache truncated file %v, removing", h)
-		return nil
+		return 0, nil
 	}
 
 	// Close, then rename. Windows doesn't like the reverse order.
 	if err = f.Close(); err != nil {
 		_ = fs.Remove(f.Name())
-		return errors.WithStack(err)
+		return 0, errors.WithStack(err)
 	}
 
 	err = fs.Rename(f.Name(), finalname)
@@ -135,7 +196,7 @@ func (c *Cache) Save(h backend.Handle, rd io.Reader) error {
 		err = nil
 	}
 
-	return errors.WithStack(err)
+	return n, errors.WithStack(err)
 }
 
 // Remove deletes a file. When the file is not cache, no error is returned.
@@ -144,7 +205,31 @@ func (c *Cache) remove(h backend.Handle) error {
 		return nil
 	}
 
-	return fs.Remove(c.filename(h))
+	unlock, err := c.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	size, err := c.removeFile(h)
+	c.subUsage(size)
+	return err
+}
+
+// removeFile deletes the file h from the cache and returns its size.
+func (c *Cache) removeFile(h backend.Handle) (int64, error) {
+	if c.mem != nil {
+		return c.mem.remove(h), nil
+	}
+
+	fi, err := fs.Lstat(c.filename(h))
+	if err != nil {
+		return 0, err
+	}
+	if err := fs.Remove(c.filename(h)); err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
 }
 
 // Clear removes all files of type t from the cache that are not contained in
@@ -155,6 +240,12 @@ func (c *Cache) Clear(t restic.FileType, valid restic.IDSet) error {
 		return nil
 	}
 
+	unlock, err := c.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	list, err := c.list(t)
 	if err != nil {
 		return err
@@ -165,7 +256,9 @@ func (c *Cache) Clear(t restic.FileType, valid restic.IDSet) error {
 			continue
 		}
 
-		if err = fs.Remove(c.filename(backend.Handle{Type: t, Name: id.String()})); err != nil {
+		size, err := c.removeFile(backend.Handle{Type: t, Name: id.String()})
+		c.subUsage(size)
+		if err != nil {
 			return err
 		}
 	}
@@ -184,6 +277,17 @@ func (c *Cache) list(t restic.FileType) (restic.IDSet, error) {
 	}
 
 	list := restic.NewIDSet()
+	if c.mem != nil {
+		for _, e := range c.mem.entries([]restic.FileType{t}) {
+			id, err := restic.ParseID(e.h.Name)
+			if err != nil {
+				continue
+			}
+			list.Insert(id)
+		}
+		return list, nil
+	}
+
 	dir := filepath.Join(c.path, cacheLayoutPaths[t])
 	err := filepath.Walk(dir, func(name string, fi os.FileInfo, err error) error {
 		if err != nil {
@@ -212,6 +316,10 @@ func (c *Cache) Has(h backend.Handle) bool {
 		return false
 	}
 
+	if c.mem != nil {
+		return c.mem.has(h)
+	}
+
 	_, err := fs.Stat(c.filename(h))
 	return err == nil
 }
@@ -145,8 +145,7 @@ func TestFileLoad(t *testing.T) {
 
 	// save about 5 MiB of data in the cache
 	data := test.Random(rand.Int(), 5234142)
-	id := restic.ID{}
-	copy(id[:], data)
+	id := restic.Hash(data)
 	h := backend.Handle{
 		Type: restic.PackFile,
 		Name: id.String(),
@@ -227,9 +226,8 @@ func TestFileSaveConcurrent(t *testing.T) {
 		c    = TestNewCache(t)
 		data = test.Random(1, 10000)
 		g    errgroup.Group
-		id   restic.ID
+		id   = restic.Hash(data)
 	)
-	rand.Read(id[:])
 
 	h := backend.Handle{
 		Type: restic.PackFile,
@@ -0,0 +1,155 @@
+package cache
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/fs"
+	"github.com/konidev20/rapi/restic"
+	"github.com/pkg/errors"
+)
+
+// The headers of pack files are cached separately from the packs, so that
+// maintenance operations which only need the list of blobs in a pack don't
+// download the headers again. An entry contains the size of the pack and the
+// encrypted header as stored at the end of the pack, including the length
+// field. Entries are removed together with the packs, see ClearPackHeaders.
+
+const headersDir = "headers"
+
+func (c *Cache) headerFilename(id restic.ID) string {
+	name := id.String()
+	return filepath.Join(c.path, headersDir, name[:2], name)
+}
+
+// LoadPackHeader returns the cached header of the pack id, if the pack had
+// the given size when the header was cached.
+func (c *Cache) LoadPackHeader(id restic.ID, size int64) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	var buf []byte
+	if c.mem != nil {
+		c.mem.mu.Lock()
+		buf = c.mem.headers[id]
+		c.mem.mu.Unlock()
+	} else {
+		var err error
+		buf, err = os.ReadFile(c.headerFilename(id))
+		if err != nil {
+			return nil, false
+		}
+	}
+
+	if len(buf) < 8 || int64(binary.LittleEndian.Uint64(buf)) != size {
+		return nil, false
+	}
+	return buf[8:], true
+}
+
+// SavePackHeader stores the header of the pack id with the given size.
+func (c *Cache) SavePackHeader(id restic.ID, size int64, header []byte) error {
+	buf := make([]byte, 8+len(header))
+	binary.LittleEndian.PutUint64(buf, uint64(size))
+	copy(buf[8:], header)
+
+	if c.mem != nil {
+		c.mem.mu.Lock()
+		if c.mem.headers == nil {
+			c.mem.headers = make(map[restic.ID][]byte)
+		}
+		c.mem.headers[id] = buf
+		c.mem.mu.Unlock()
+		return nil
+	}
+
+	unlock, err := c.lock(false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return c.writeFile(c.headerFilename(id), buf)
+}
+
+// writeFile atomically replaces the file finalname with buf, creating the
+// directory if necessary.
+func (c *Cache) writeFile(finalname string, buf []byte) error {
+	dir := filepath.Dir(finalname)
+	dirMode, fileMode := cacheModes(c.shared)
+	if err := mkdirAll(dir, dirMode, c.shared); err != nil && !errors.Is(err, os.ErrExist) {
+		return err
+	}
+
+	f, err := os.CreateTemp(dir, "tmp-")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if c.shared {
+		if err = f.Chmod(fileMode); err != nil {
+			_ = f.Close()
+			_ = fs.Remove(f.Name())
+			return errors.WithStack(err)
+		}
+	}
+	if _, err = f.Write(buf); err != nil {
+		_ = f.Close()
+		_ = fs.Remove(f.Name())
+		return errors.WithStack(err)
+	}
+	if err = f.Close(); err != nil {
+		_ = fs.Remove(f.Name())
+		return errors.WithStack(err)
+	}
+
+	err = fs.Rename(f.Name(), finalname)
+	if err != nil {
+		_ = fs.Remove(f.Name())
+	}
+	return errors.WithStack(err)
+}
+
+// ClearPackHeaders removes the cached headers of all packs not contained in
+// the set valid.
+func (c *Cache) ClearPackHeaders(valid restic.IDSet) error {
+	if c.mem != nil {
+		c.mem.mu.Lock()
+		for id := range c.mem.headers {
+			if !valid.Has(id) {
+				delete(c.mem.headers, id)
+			}
+		}
+		c.mem.mu.Unlock()
+		return nil
+	}
+
+	unlock, err := c.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	dir := filepath.Join(c.path, headersDir)
+	err = filepath.Walk(dir, func(name string, fi os.FileInfo, err error) error {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "Walk")
+		}
+		if !isFile(fi) {
+			return nil
+		}
+
+		id, err := restic.ParseID(filepath.Base(name))
+		if err != nil || valid.Has(id) {
+			return nil
+		}
+		debug.Log("removing cached header of pack %v", id.Str())
+		return fs.Remove(name)
+	})
+	return err
+}
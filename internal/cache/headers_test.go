@@ -0,0 +1,38 @@
+package cache
+
+import (
+	"testing"
+
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/restic"
+)
+
+func TestPackHeaders(t *testing.T) {
+	for _, c := range []*Cache{
+		TestNewCache(t),
+		NewMemory(restic.NewRandomID().String(), 0),
+	} {
+		keep, drop := restic.NewRandomID(), restic.NewRandomID()
+		header := rtest.Random(23, 500)
+
+		_, ok := c.LoadPackHeader(keep, 1000)
+		rtest.Assert(t, !ok, "header of unknown pack found")
+
+		rtest.OK(t, c.SavePackHeader(keep, 1000, header))
+		rtest.OK(t, c.SavePackHeader(drop, 2000, header))
+
+		buf, ok := c.LoadPackHeader(keep, 1000)
+		rtest.Assert(t, ok, "cached header not found")
+		rtest.Equals(t, header, buf)
+
+		// the header is not used for a pack of a different size
+		_, ok = c.LoadPackHeader(keep, 1001)
+		rtest.Assert(t, !ok, "header found for wrong pack size")
+
+		rtest.OK(t, c.ClearPackHeaders(restic.NewIDSet(keep)))
+		_, ok = c.LoadPackHeader(keep, 1000)
+		rtest.Assert(t, ok, "header of valid pack was removed")
+		_, ok = c.LoadPackHeader(drop, 2000)
+		rtest.Assert(t, !ok, "header of removed pack was kept")
+	}
+}
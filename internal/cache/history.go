@@ -0,0 +1,61 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+)
+
+// The cache keeps statistics of earlier backups, they are used to predict the
+// duration and the upload size of the next backup. The statistics are stored
+// as opaque data per key, which usually identifies the backed up paths. They
+// are only kept locally and are lost when the cache is removed.
+
+const historyDir = "history"
+
+func (c *Cache) historyFilename(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.path, historyDir, hex.EncodeToString(sum[:]))
+}
+
+// LoadHistory returns the statistics stored for key.
+func (c *Cache) LoadHistory(key string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	if c.mem != nil {
+		c.mem.mu.Lock()
+		defer c.mem.mu.Unlock()
+		buf, ok := c.mem.history[key]
+		return buf, ok
+	}
+
+	buf, err := os.ReadFile(c.historyFilename(key))
+	if err != nil {
+		return nil, false
+	}
+	return buf, true
+}
+
+// SaveHistory replaces the statistics stored for key with buf.
+func (c *Cache) SaveHistory(key string, buf []byte) error {
+	if c.mem != nil {
+		c.mem.mu.Lock()
+		if c.mem.history == nil {
+			c.mem.history = make(map[string][]byte)
+		}
+		c.mem.history[key] = append([]byte(nil), buf...)
+		c.mem.mu.Unlock()
+		return nil
+	}
+
+	unlock, err := c.lock(false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return c.writeFile(c.historyFilename(key), buf)
+}
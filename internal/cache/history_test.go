@@ -0,0 +1,34 @@
+package cache
+
+import (
+	"testing"
+
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/restic"
+)
+
+func TestHistory(t *testing.T) {
+	for _, c := range []*Cache{
+		TestNewCache(t),
+		NewMemory(restic.NewRandomID().String(), 0),
+	} {
+		_, ok := c.LoadHistory("host:/home")
+		rtest.Assert(t, !ok, "history of unknown key found")
+
+		rtest.OK(t, c.SaveHistory("host:/home", []byte("first")))
+		rtest.OK(t, c.SaveHistory("host:/home", []byte("second")))
+		rtest.OK(t, c.SaveHistory("host:/srv", []byte("other")))
+
+		buf, ok := c.LoadHistory("host:/home")
+		rtest.Assert(t, ok, "history not found")
+		rtest.Equals(t, []byte("second"), buf)
+
+		buf, ok = c.LoadHistory("host:/srv")
+		rtest.Assert(t, ok, "history not found")
+		rtest.Equals(t, []byte("other"), buf)
+	}
+
+	var c *Cache
+	_, ok := c.LoadHistory("host:/home")
+	rtest.Assert(t, !ok, "history found in nil cache")
+}
@@ -0,0 +1,16 @@
+//go:build solaris || aix || js || wasip1
+// +build solaris aix js wasip1
+
+package cache
+
+import "os"
+
+// lockFile is a no-op, flock is not available on this platform.
+func lockFile(f *os.File, exclusive bool) error {
+	return nil
+}
+
+// unlockFile is a no-op, flock is not available on this platform.
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,29 @@
+//go:build !windows && !solaris && !aix && !js && !wasip1
+// +build !windows,!solaris,!aix,!js,!wasip1
+
+package cache
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile places an advisory lock on f, blocking until it is available.
+func lockFile(f *os.File, exclusive bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+
+	for {
+		err := syscall.Flock(int(f.Fd()), how)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+// unlockFile releases a lock placed by lockFile.
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,164 @@
+package cache
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/crypto"
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/restic"
+	"github.com/pkg/errors"
+)
+
+// DefaultMemorySize is the default size limit of an in-memory cache.
+const DefaultMemorySize = 256 * 1024 * 1024
+
+// NewMemory returns a cache for the repo ID which keeps all files in memory
+// instead of storing them on disk, for environments without persistent
+// storage. At most maxSize bytes are kept, when the limit is exceeded the
+// least recently used pack and index files are dropped. If maxSize is zero or
+// less, DefaultMemorySize is used.
+func NewMemory(id string, maxSize int64) *Cache {
+	if maxSize <= 0 {
+		maxSize = DefaultMemorySize
+	}
+
+	debug.Log("using in-memory cache for %v with %d bytes", id, maxSize)
+
+	return &Cache{
+		Created: true,
+		mem:     &memStore{files: make(map[backend.Handle]*memFile)},
+		maxSize: maxSize,
+	}
+}
+
+// InMemory returns true if the cache keeps all files in memory.
+func (c *Cache) InMemory() bool {
+	return c.mem != nil
+}
+
+// memFile is a single file held in a memStore.
+type memFile struct {
+	data    []byte
+	lastUse time.Time
+}
+
+// memStore holds the files of an in-memory cache.
+type memStore struct {
+	mu    sync.Mutex
+	files map[backend.Handle]*memFile
+
+	// headers holds the cached pack headers, see headers.go.
+	headers map[restic.ID][]byte
+
+	// history holds the statistics of earlier backups, see history.go.
+	history map[string][]byte
+}
+
+// memKey normalizes h so that it can be used as a key for the files map.
+func memKey(h backend.Handle) backend.Handle {
+	h.IsMetadata = false
+	return h
+}
+
+func (m *memStore) load(h backend.Handle, length int, offset int64) (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, ok := m.files[memKey(h)]
+	if !ok {
+		return nil, errors.Errorf("%v is not cached", h)
+	}
+
+	size := int64(len(f.data))
+	if size < offset+int64(length) {
+		return nil, errors.Errorf("cached file %v is too small", h)
+	}
+
+	buf := f.data[offset:]
+	if length > 0 {
+		buf = buf[:length]
+	}
+	f.lastUse = time.Now()
+
+	return io.NopCloser(bytes.NewReader(buf)), nil
+}
+
+func (m *memStore) save(h backend.Handle, rd io.Reader) (int64, error) {
+	data, err := io.ReadAll(rd)
+	if err != nil {
+		return 0, errors.Wrap(err, "ReadAll")
+	}
+
+	if len(data) <= crypto.CiphertextLength(0) {
+		debug.Log("trying to cache truncated file %v, ignoring", h)
+		return 0, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.files[memKey(h)] = &memFile{data: data, lastUse: time.Now()}
+	return int64(len(data)), nil
+}
+
+func (m *memStore) remove(h backend.Handle) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, ok := m.files[memKey(h)]
+	if !ok {
+		return 0
+	}
+	delete(m.files, memKey(h))
+	return int64(len(f.data))
+}
+
+func (m *memStore) has(h backend.Handle) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.files[memKey(h)]
+	return ok
+}
+
+func (m *memStore) touch(h backend.Handle) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if f, ok := m.files[memKey(h)]; ok {
+		f.lastUse = time.Now()
+	}
+}
+
+// data returns the content of the file h.
+func (m *memStore) data(h backend.Handle) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, ok := m.files[memKey(h)]
+	if !ok {
+		return nil, false
+	}
+	return f.data, true
+}
+
+// entries returns all files of the given types.
+func (m *memStore) entries(types []restic.FileType) []cacheEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var entries []cacheEntry
+	for h, f := range m.files {
+		for _, t := range types {
+			if h.Type == t {
+				entries = append(entries, cacheEntry{h: h, size: int64(len(f.data)), lastUse: f.lastUse})
+				break
+			}
+		}
+	}
+	return entries
+}
@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/backend/mem"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/restic"
+)
+
+func TestMemoryCache(t *testing.T) {
+	c := NewMemory(restic.NewRandomID().String(), 0)
+	rtest.Assert(t, c.InMemory(), "cache is not kept in memory")
+
+	ids := generateRandomFiles(t, restic.IndexFile, c)
+	rtest.Equals(t, ids, listFiles(t, c, restic.IndexFile))
+
+	id := randomID(ids)
+	h := backend.Handle{Type: restic.IndexFile, Name: id.String()}
+	rtest.Equals(t, id, restic.Hash(load(t, c, h)))
+
+	rd, err := c.load(h, 100, 50)
+	rtest.OK(t, err)
+	buf, err := io.ReadAll(rd)
+	rtest.OK(t, err)
+	rtest.Equals(t, 100, len(buf))
+
+	clearFiles(t, c, restic.IndexFile, restic.NewIDSet(id))
+	rtest.Equals(t, restic.NewIDSet(id), listFiles(t, c, restic.IndexFile))
+}
+
+func TestMemoryCacheLimit(t *testing.T) {
+	c := NewMemory(restic.NewRandomID().String(), 2500)
+
+	var handles []backend.Handle
+	for i := 0; i < 3; i++ {
+		data := rtest.Random(i, 1000)
+		h := backend.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+		rtest.OK(t, c.Save(h, bytes.NewReader(data)))
+		handles = append(handles, h)
+
+		// make sure the first file is always the most recently used one
+		rd, err := c.load(handles[0], 0, 0)
+		rtest.OK(t, err)
+		rtest.OK(t, rd.Close())
+	}
+
+	rtest.Assert(t, c.Has(handles[0]), "recently used file was evicted")
+	rtest.Assert(t, !c.Has(handles[1]), "least recently used file was not evicted")
+
+	usage, err := c.Usage()
+	rtest.OK(t, err)
+	rtest.Assert(t, usage <= 2500, "usage %d exceeds limit", usage)
+}
+
+func TestMemoryCacheBackend(t *testing.T) {
+	be := mem.New()
+	c := NewMemory(restic.NewRandomID().String(), 0)
+	wbe := c.Wrap(be)
+
+	h, data := randomData(5000)
+	save(t, wbe, h, data)
+	rtest.Assert(t, c.Has(h), "file was not cached")
+
+	loadAndCompare(t, wbe, h, data)
+}
@@ -0,0 +1,250 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/fs"
+	"github.com/konidev20/rapi/restic"
+	"github.com/pkg/errors"
+)
+
+// evictableTypes lists the file types which may be removed from the cache
+// when the quota is exceeded. Snapshot files are small and needed for almost
+// all operations, so they are always kept.
+var evictableTypes = []restic.FileType{restic.PackFile, restic.IndexFile}
+
+// trimWatermark is the fraction of the quota the cache is trimmed down to once
+// the quota is exceeded, so that not every subsequent Save triggers another
+// scan of the cache directory.
+const trimWatermark = 0.9
+
+// cacheEntry describes a single file in the cache.
+type cacheEntry struct {
+	h       backend.Handle
+	size    int64
+	lastUse time.Time
+}
+
+// SetMaxSize configures the maximum size of the cache in bytes. When the
+// quota is exceeded, the least recently used pack and index files are
+// removed. A value of zero or less disables the quota. If the cache is already
+// larger than max, it is trimmed immediately.
+func (c *Cache) SetMaxSize(max int64) error {
+	if max < 0 {
+		max = 0
+	}
+
+	c.quotaMu.Lock()
+	c.maxSize = max
+	c.quotaMu.Unlock()
+
+	if max == 0 {
+		return nil
+	}
+
+	usage, err := c.Usage()
+	if err != nil {
+		return err
+	}
+	if usage > max {
+		_, err = c.Trim(int64(float64(max) * trimWatermark))
+	}
+	return err
+}
+
+// MaxSize returns the configured maximum size of the cache in bytes, zero
+// means unlimited.
+func (c *Cache) MaxSize() int64 {
+	c.quotaMu.Lock()
+	defer c.quotaMu.Unlock()
+	return c.maxSize
+}
+
+// Usage returns the number of bytes used by files in the cache.
+func (c *Cache) Usage() (int64, error) {
+	entries, err := c.entries(cacheLayoutTypes())
+	if err != nil {
+		return 0, err
+	}
+
+	var usage int64
+	for _, e := range entries {
+		usage += e.size
+	}
+
+	c.quotaMu.Lock()
+	c.usage = usage
+	c.usageKnown = true
+	c.quotaMu.Unlock()
+
+	return usage, nil
+}
+
+// Trim removes the least recently used pack and index files from the cache
+// until at most max bytes are in use. It returns the number of files removed.
+func (c *Cache) Trim(max int64) (removed int, err error) {
+	unlock, err := c.lock(true)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	usage, err := c.Usage()
+	if err != nil {
+		return 0, err
+	}
+	if usage <= max {
+		return 0, nil
+	}
+
+	entries, err := c.entries(evictableTypes)
+	if err != nil {
+		return 0, err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].lastUse.Before(entries[j].lastUse)
+	})
+
+	for _, e := range entries {
+		if usage <= max {
+			break
+		}
+
+		_, err := c.removeFile(e.h)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return removed, errors.WithStack(err)
+		}
+
+		debug.Log("evicted %v (%d bytes) from cache", e.h, e.size)
+		usage -= e.size
+		removed++
+	}
+
+	c.quotaMu.Lock()
+	c.usage = usage
+	c.quotaMu.Unlock()
+
+	return removed, nil
+}
+
+// entries returns all files of the given types in the cache.
+func (c *Cache) entries(types []restic.FileType) ([]cacheEntry, error) {
+	if c.mem != nil {
+		return c.mem.entries(types), nil
+	}
+
+	var entries []cacheEntry
+	for _, t := range types {
+		dir := filepath.Join(c.path, cacheLayoutPaths[t])
+		err := filepath.Walk(dir, func(name string, fi os.FileInfo, err error) error {
+			if err != nil {
+				if errors.Is(err, os.ErrNotExist) {
+					return nil
+				}
+				return errors.Wrap(err, "Walk")
+			}
+
+			if !isFile(fi) {
+				return nil
+			}
+
+			id, err := restic.ParseID(filepath.Base(name))
+			if err != nil {
+				return nil
+			}
+
+			entries = append(entries, cacheEntry{
+				h:       backend.Handle{Type: t, Name: id.String()},
+				size:    fi.Size(),
+				lastUse: fi.ModTime(),
+			})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return entries, nil
+}
+
+// cacheLayoutTypes returns all file types which can be stored in the cache.
+func cacheLayoutTypes() []restic.FileType {
+	types := make([]restic.FileType, 0, len(cacheLayoutPaths))
+	for t := range cacheLayoutPaths {
+		types = append(types, t)
+	}
+	return types
+}
+
+// quotaEnabled returns true if a maximum cache size is configured.
+func (c *Cache) quotaEnabled() bool {
+	return c.MaxSize() > 0
+}
+
+// touch records that the file for h has been used, the modification time
+// is used as the access time for the LRU eviction.
+func (c *Cache) touch(h backend.Handle) {
+	if c.mem != nil {
+		c.mem.touch(h)
+		return
+	}
+
+	if !c.quotaEnabled() {
+		return
+	}
+
+	now := time.Now()
+	err := fs.Chtimes(c.filename(h), now, now)
+	if err != nil && c.shared && os.IsPermission(err) {
+		// the file belongs to another user of a shared cache, it keeps the
+		// time it was stored
+		return
+	}
+	if err != nil {
+		debug.Log("unable to update timestamp of %v: %v", h, err)
+	}
+}
+
+// addUsage accounts for n bytes newly stored in the cache and trims the
+// cache if the quota is exceeded.
+func (c *Cache) addUsage(n int64) {
+	c.quotaMu.Lock()
+	max := c.maxSize
+	if max == 0 {
+		c.quotaMu.Unlock()
+		return
+	}
+	c.usage += n
+	exceeded := !c.usageKnown || c.usage > max
+	c.quotaMu.Unlock()
+
+	if !exceeded {
+		return
+	}
+
+	usage, err := c.Usage()
+	if err != nil || usage <= max {
+		return
+	}
+
+	if _, err := c.Trim(int64(float64(max) * trimWatermark)); err != nil {
+		debug.Log("unable to trim cache: %v", err)
+	}
+}
+
+// subUsage accounts for n bytes removed from the cache.
+func (c *Cache) subUsage(n int64) {
+	c.quotaMu.Lock()
+	c.usage -= n
+	if c.usage < 0 {
+		c.usage = 0
+	}
+	c.quotaMu.Unlock()
+}
@@ -0,0 +1,89 @@
+package cache
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/internal/fs"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/restic"
+)
+
+func saveAged(t testing.TB, c *Cache, tpe restic.FileType, size int, age time.Duration) backend.Handle {
+	buf := rtest.Random(int(age), size)
+	h := backend.Handle{Type: tpe, Name: restic.Hash(buf).String()}
+	rtest.OK(t, c.Save(h, bytes.NewReader(buf)))
+
+	ts := time.Now().Add(-age)
+	rtest.OK(t, fs.Chtimes(c.filename(h), ts, ts))
+	return h
+}
+
+func TestCacheTrim(t *testing.T) {
+	c := TestNewCache(t)
+
+	oldest := saveAged(t, c, restic.PackFile, 1000, 3*time.Hour)
+	older := saveAged(t, c, restic.IndexFile, 1000, 2*time.Hour)
+	newest := saveAged(t, c, restic.PackFile, 1000, time.Hour)
+	snapshot := saveAged(t, c, restic.SnapshotFile, 1000, 4*time.Hour)
+
+	usage, err := c.Usage()
+	rtest.OK(t, err)
+	rtest.Equals(t, int64(4000), usage)
+
+	removed, err := c.Trim(2500)
+	rtest.OK(t, err)
+	rtest.Equals(t, 2, removed)
+
+	rtest.Assert(t, !c.Has(oldest), "least recently used file was not evicted")
+	rtest.Assert(t, !c.Has(older), "second least recently used file was not evicted")
+	rtest.Assert(t, c.Has(newest), "most recently used file was evicted")
+	rtest.Assert(t, c.Has(snapshot), "snapshot file was evicted")
+
+	usage, err = c.Usage()
+	rtest.OK(t, err)
+	rtest.Equals(t, int64(2000), usage)
+}
+
+func TestCacheQuotaOnSave(t *testing.T) {
+	c := TestNewCache(t)
+	rtest.OK(t, c.SetMaxSize(2500))
+
+	first := saveAged(t, c, restic.PackFile, 1000, 2*time.Hour)
+	second := saveAged(t, c, restic.PackFile, 1000, time.Hour)
+
+	// loading a file marks it as recently used
+	rd, err := c.load(first, 0, 0)
+	rtest.OK(t, err)
+	rtest.OK(t, rd.Close())
+
+	third := saveAged(t, c, restic.PackFile, 1000, 0)
+
+	rtest.Assert(t, c.Has(first), "recently loaded file was evicted")
+	rtest.Assert(t, !c.Has(second), "least recently used file was not evicted")
+	rtest.Assert(t, c.Has(third), "new file was evicted")
+
+	usage, err := c.Usage()
+	rtest.OK(t, err)
+	rtest.Assert(t, usage <= c.MaxSize(), "cache usage %d exceeds quota %d", usage, c.MaxSize())
+}
+
+func TestCacheRemoveKeepsUsage(t *testing.T) {
+	c := TestNewCache(t)
+	rtest.OK(t, c.SetMaxSize(10000))
+
+	h := saveAged(t, c, restic.PackFile, 1000, time.Hour)
+	saveAged(t, c, restic.PackFile, 1000, 0)
+
+	_, err := c.Usage()
+	rtest.OK(t, err)
+	rtest.OK(t, c.remove(h))
+
+	c.quotaMu.Lock()
+	usage, known := c.usage, c.usageKnown
+	c.quotaMu.Unlock()
+	rtest.Assert(t, known, "usage was discarded on remove")
+	rtest.Equals(t, int64(1000), usage)
+}
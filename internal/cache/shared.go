@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/fs"
+	"github.com/pkg/errors"
+)
+
+// Permissions used for a cache shared between several OS users. Access is
+// granted to all members of the group owning the base directory, the setgid
+// bit makes sure new directories and files inherit that group.
+const sharedDirMode = 0770 | os.ModeSetgid
+const sharedFileMode = 0660
+
+// lockFilename is the name of the file in each repository cache directory
+// which is used to coordinate access between processes.
+const lockFilename = "lock"
+
+// EnvSharedDir returns $RESTIC_SHARED_CACHE_DIR env
+func EnvSharedDir() string {
+	return os.Getenv("RESTIC_SHARED_CACHE_DIR")
+}
+
+// DefaultSharedDir returns $RESTIC_SHARED_CACHE_DIR, or the default system-wide
+// cache directory for the current OS if that variable is not set.
+func DefaultSharedDir() string {
+	if dir := EnvSharedDir(); dir != "" {
+		return dir
+	}
+
+	if runtime.GOOS == "windows" {
+		programData := os.Getenv("ProgramData")
+		if programData == "" {
+			programData = `C:\ProgramData`
+		}
+		return filepath.Join(programData, "restic", "cache")
+	}
+
+	return "/var/cache/restic"
+}
+
+// NewShared returns a new cache for the repo ID at basedir which can be used
+// by several OS users at the same time. If basedir is the empty string,
+// DefaultSharedDir is used. Each repository is stored in its own subdirectory,
+// all users must be members of the group owning basedir.
+//
+// Files are written with group read and write permissions, and operations
+// which remove files from the cache are serialized using a file lock. Only the
+// owner of a file can set its modification time, so files written by other
+// users are evicted in the order they were stored instead of the order they
+// were last used.
+func NewShared(id string, basedir string) (*Cache, error) {
+	if basedir == "" {
+		basedir = DefaultSharedDir()
+	}
+
+	return newCache(id, basedir, true)
+}
+
+// Shared returns true if the cache is shared between several OS users.
+func (c *Cache) Shared() bool {
+	return c.shared
+}
+
+// mkdir creates the directory name. For a shared cache, the permissions are
+// set explicitly afterwards, so that they are not restricted by the umask.
+func mkdir(name string, perm os.FileMode, shared bool) error {
+	err := fs.Mkdir(name, perm)
+	if err != nil || !shared {
+		return err
+	}
+
+	return fs.Chmod(name, perm)
+}
+
+// mkdirAll is like mkdir, but also creates all missing parent directories.
+// For a shared cache only the permissions of name itself are adjusted.
+func mkdirAll(name string, perm os.FileMode, shared bool) error {
+	err := fs.MkdirAll(name, perm)
+	if err != nil || !shared {
+		return err
+	}
+
+	return fs.Chmod(name, perm)
+}
+
+// lock acquires the cache lock of the repository, which is only used for a
+// shared cache. Readers take a shared lock while opening a file and writers
+// while storing a file, operations removing files from the cache take an
+// exclusive lock. The returned function releases the lock.
+func (c *Cache) lock(exclusive bool) (unlock func(), err error) {
+	if !c.shared {
+		return func() {}, nil
+	}
+
+	_, fileMode := cacheModes(c.shared)
+	f, err := fs.OpenFile(filepath.Join(c.path, lockFilename), os.O_CREATE|os.O_RDWR, fileMode)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if err = lockFile(f, exclusive); err != nil {
+		_ = f.Close()
+		return nil, errors.Wrap(err, "lock")
+	}
+
+	return func() {
+		if err := unlockFile(f); err != nil {
+			debug.Log("unable to unlock cache: %v", err)
+		}
+		_ = f.Close()
+	}, nil
+}
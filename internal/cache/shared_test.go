@@ -0,0 +1,63 @@
+//go:build !windows
+// +build !windows
+
+package cache
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/internal/fs"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/restic"
+)
+
+func TestSharedCachePermissions(t *testing.T) {
+	oldMask := syscall.Umask(0077)
+	defer syscall.Umask(oldMask)
+
+	c, err := NewShared(restic.NewRandomID().String(), rtest.TempDir(t))
+	rtest.OK(t, err)
+	rtest.Assert(t, c.Shared(), "cache is not marked as shared")
+
+	fi, err := fs.Stat(filepath.Join(c.path, cacheLayoutPaths[restic.IndexFile]))
+	rtest.OK(t, err)
+	rtest.Equals(t, os.FileMode(sharedDirMode), fi.Mode()&(os.ModePerm|os.ModeSetgid))
+
+	buf := rtest.Random(23, 1000)
+	h := backend.Handle{Type: restic.IndexFile, Name: restic.Hash(buf).String()}
+	rtest.OK(t, c.Save(h, bytes.NewReader(buf)))
+
+	fi, err = fs.Stat(c.filename(h))
+	rtest.OK(t, err)
+	rtest.Equals(t, os.FileMode(sharedFileMode), fi.Mode().Perm())
+
+	rtest.OK(t, c.Clear(restic.IndexFile, restic.NewIDSet()))
+	rtest.Assert(t, !c.Has(h), "file was not removed from shared cache")
+}
+
+func TestSharedCacheLoadRemove(t *testing.T) {
+	c, err := NewShared(restic.NewRandomID().String(), rtest.TempDir(t))
+	rtest.OK(t, err)
+
+	buf := rtest.Random(42, 1000)
+	h := backend.Handle{Type: restic.IndexFile, Name: restic.Hash(buf).String()}
+	rtest.OK(t, c.Save(h, bytes.NewReader(buf)))
+
+	rd, err := c.load(h, 0, 0)
+	rtest.OK(t, err)
+
+	// removing the file must not wait for the reader
+	rtest.OK(t, c.remove(h))
+	rtest.Assert(t, !c.Has(h), "file was not removed from shared cache")
+
+	data, err := io.ReadAll(rd)
+	rtest.OK(t, err)
+	rtest.OK(t, rd.Close())
+	rtest.Equals(t, buf, data)
+}
@@ -0,0 +1,156 @@
+package cache
+
+import (
+	"context"
+	"io"
+	"math/rand"
+
+	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/fs"
+	"github.com/konidev20/rapi/internal/hashing"
+	"github.com/konidev20/rapi/restic"
+	"github.com/pkg/errors"
+)
+
+// DefaultSpotCheckRate is the default fraction of partial reads from the
+// cache for which the complete file is verified first.
+const DefaultSpotCheckRate = 0.01
+
+// SetSpotCheckRate configures the fraction (between 0 and 1) of partial reads
+// for which the complete cached file is hashed and compared to its ID before
+// returning data. Complete reads are always verified while they are read.
+func (c *Cache) SetSpotCheckRate(rate float64) {
+	c.quotaMu.Lock()
+	c.spotCheckRate = rate
+	c.quotaMu.Unlock()
+}
+
+// SetHashAlgorithm sets the algorithm which computes the IDs of the cached
+// files, it defaults to SHA-256.
+func (c *Cache) SetHashAlgorithm(alg restic.HashAlgorithm) {
+	c.quotaMu.Lock()
+	c.hash = alg
+	c.quotaMu.Unlock()
+}
+
+func (c *Cache) hashAlgorithm() restic.HashAlgorithm {
+	c.quotaMu.Lock()
+	defer c.quotaMu.Unlock()
+
+	if c.hash == nil {
+		return restic.SHA256
+	}
+	return c.hash
+}
+
+func (c *Cache) spotCheck() bool {
+	c.quotaMu.Lock()
+	rate := c.spotCheckRate
+	c.quotaMu.Unlock()
+
+	return rate > 0 && rand.Float64() < rate
+}
+
+// verifyFile returns an error if the content of the cached file for h does
+// not match its name.
+func (c *Cache) verifyFile(h backend.Handle) error {
+	id, err := restic.ParseID(h.Name)
+	if err != nil {
+		return err
+	}
+
+	if c.mem != nil {
+		data, ok := c.mem.data(h)
+		if ok && !c.hashAlgorithm().Sum(data).Equal(id) {
+			return errors.Errorf("cached file %v is corrupted", h)
+		}
+		return nil
+	}
+
+	f, err := fs.Open(c.filename(h))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	hrd := hashing.NewReader(f, c.hashAlgorithm().New())
+	_, err = io.Copy(io.Discard, hrd)
+	_ = f.Close()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if !restic.IDFromHash(hrd.Sum(nil)).Equal(id) {
+		return errors.Errorf("cached file %v is corrupted", h)
+	}
+	return nil
+}
+
+// Verify checks the content of all files in the cache and removes the files
+// which are corrupted, so they are fetched from the backend again on the next
+// access. The handles of the removed files are returned.
+func (c *Cache) Verify(ctx context.Context) (corrupted []backend.Handle, err error) {
+	entries, err := c.entries(cacheLayoutTypes())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		if ctx.Err() != nil {
+			return corrupted, ctx.Err()
+		}
+
+		err := c.verifyFile(e.h)
+		if err == nil {
+			continue
+		}
+
+		debug.Log("verify: %v", err)
+		corrupted = append(corrupted, e.h)
+		if err := c.remove(e.h); err != nil {
+			return corrupted, err
+		}
+	}
+
+	return corrupted, nil
+}
+
+// verifyingReader checks that the hash of the data read from a cached file
+// matches its ID. If that is not the case, the file is removed from the cache
+// and an error is returned instead of io.EOF.
+//
+// The hash can only be checked once the whole file has been read. Callers
+// must read until io.EOF before using the data, a reader which stops early
+// may return corrupted data without an error.
+type verifyingReader struct {
+	io.ReadCloser
+	hrd *hashing.Reader
+	id  restic.ID
+	h   backend.Handle
+	c   *Cache
+}
+
+func newVerifyingReader(rd io.ReadCloser, c *Cache, h backend.Handle) io.ReadCloser {
+	id, err := restic.ParseID(h.Name)
+	if err != nil {
+		return rd
+	}
+
+	return &verifyingReader{
+		ReadCloser: rd,
+		hrd:        hashing.NewReader(rd, c.hashAlgorithm().New()),
+		id:         id,
+		h:          h,
+		c:          c,
+	}
+}
+
+func (rd *verifyingReader) Read(p []byte) (int, error) {
+	n, err := rd.hrd.Read(p)
+	if err == io.EOF && !restic.IDFromHash(rd.hrd.Sum(nil)).Equal(rd.id) {
+		debug.Log("cached file %v is corrupted, removing", rd.h)
+		_ = rd.c.remove(rd.h)
+		return n, errors.Errorf("cached file %v is corrupted, removing", rd.h)
+	}
+	return n, err
+}
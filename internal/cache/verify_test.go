@@ -0,0 +1,106 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha512"
+	"hash"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/backend/mem"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/restic"
+)
+
+func corrupt(t testing.TB, c *Cache, h backend.Handle) {
+	f, err := os.OpenFile(c.filename(h), os.O_WRONLY, 0)
+	rtest.OK(t, err)
+	_, err = f.WriteAt([]byte{0xde, 0xad, 0xbe, 0xef}, 50)
+	rtest.OK(t, err)
+	rtest.OK(t, f.Close())
+}
+
+func TestLoadCorrupted(t *testing.T) {
+	c := TestNewCache(t)
+
+	data := rtest.Random(42, 1000)
+	h := backend.Handle{Type: restic.IndexFile, Name: restic.Hash(data).String()}
+	rtest.OK(t, c.Save(h, bytes.NewReader(data)))
+	corrupt(t, c, h)
+
+	rd, err := c.load(h, 0, 0)
+	rtest.OK(t, err)
+	_, err = io.ReadAll(rd)
+	rtest.Assert(t, err != nil, "reading corrupted file did not return an error")
+	_ = rd.Close()
+
+	rtest.Assert(t, !c.Has(h), "corrupted file was not removed from the cache")
+}
+
+func TestLoadCorruptedRefetch(t *testing.T) {
+	be := mem.New()
+	c := TestNewCache(t)
+	wbe := c.Wrap(be)
+
+	h, data := randomData(5000)
+	save(t, wbe, h, data)
+	rtest.Assert(t, c.Has(h), "file was not cached")
+	corrupt(t, c, h)
+
+	// the corrupted file is detected and loaded from the backend instead
+	loadAndCompare(t, wbe, h, data)
+	rtest.Assert(t, c.Has(h), "file was not cached again")
+}
+
+func TestVerify(t *testing.T) {
+	c := TestNewCache(t)
+
+	var handles []backend.Handle
+	for i := 0; i < 5; i++ {
+		data := rtest.Random(i, 1000)
+		h := backend.Handle{Type: restic.PackFile, Name: restic.Hash(data).String()}
+		rtest.OK(t, c.Save(h, bytes.NewReader(data)))
+		handles = append(handles, h)
+	}
+	corrupt(t, c, handles[2])
+
+	corrupted, err := c.Verify(context.TODO())
+	rtest.OK(t, err)
+	rtest.Equals(t, []backend.Handle{handles[2]}, corrupted)
+
+	for i, h := range handles {
+		rtest.Equals(t, i != 2, c.Has(h))
+	}
+}
+
+type sha512Hash struct{}
+
+func (sha512Hash) Name() string              { return "test-sha512-256" }
+func (sha512Hash) New() hash.Hash            { return sha512.New512_256() }
+func (sha512Hash) Sum(data []byte) restic.ID { return sha512.Sum512_256(data) }
+
+func TestVerifyHashAlgorithm(t *testing.T) {
+	c := TestNewCache(t)
+	c.SetHashAlgorithm(sha512Hash{})
+
+	data := rtest.Random(23, 1000)
+	h := backend.Handle{Type: restic.IndexFile, Name: sha512Hash{}.Sum(data).String()}
+	rtest.OK(t, c.Save(h, bytes.NewReader(data)))
+	other := backend.Handle{Type: restic.IndexFile, Name: restic.Hash(data).String()}
+	rtest.OK(t, c.Save(other, bytes.NewReader(data)))
+
+	rd, err := c.load(h, 0, 0)
+	rtest.OK(t, err)
+	buf, err := io.ReadAll(rd)
+	rtest.OK(t, err)
+	rtest.OK(t, rd.Close())
+	rtest.Equals(t, data, buf)
+
+	// the file named after its SHA-256 hash does not match its ID
+	corrupted, err := c.Verify(context.TODO())
+	rtest.OK(t, err)
+	rtest.Equals(t, []backend.Handle{other}, corrupted)
+}
@@ -12,7 +12,6 @@ import (
 
 	"github.com/konidev20/rapi/backend"
 	"github.com/konidev20/rapi/backend/s3"
-	"github.com/konidev20/rapi/internal/cache"
 	"github.com/konidev20/rapi/internal/debug"
 	"github.com/konidev20/rapi/internal/errors"
 	"github.com/konidev20/rapi/internal/hashing"
@@ -21,7 +20,6 @@ import (
 	"github.com/konidev20/rapi/repository"
 	"github.com/konidev20/rapi/restic"
 	"github.com/konidev20/rapi/ui/progress"
-	"github.com/minio/sha256-simd"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -240,13 +238,8 @@ func IsOrphanedPack(err error) bool {
 }
 
 func isS3Legacy(b backend.Backend) bool {
-	// unwrap cache
-	if be, ok := b.(*cache.Backend); ok {
-		b = be.Backend
-	}
-
-	be, ok := b.(*s3.Backend)
-	if !ok {
+	be := backend.AsBackend[*s3.Backend](b)
+	if be == nil {
 		return false
 	}
 
@@ -525,6 +518,13 @@ func (c *Checker) GetPacks() map[restic.ID]int64 {
 	return c.packs
 }
 
+// CheckPack reads the pack id, which has the given size and contains blobs
+// according to the index, and checks the integrity of all blobs.
+func CheckPack(ctx context.Context, r restic.Repository, id restic.ID, blobs []restic.Blob, size int64) error {
+	bufRd := bufio.NewReaderSize(nil, repository.MaxStreamBufferSize)
+	return checkPack(ctx, r, id, blobs, size, bufRd)
+}
+
 // checkPack reads a pack and checks the integrity of all blobs.
 func checkPack(ctx context.Context, r restic.Repository, id restic.ID, blobs []restic.Blob, size int64, bufRd *bufio.Reader) error {
 	debug.Log("checking pack %v", id.String())
@@ -555,11 +555,12 @@ func checkPack(ctx context.Context, r restic.Repository, id restic.ID, blobs []r
 	}
 
 	// calculate hash on-the-fly while reading the pack and capture pack header
+	alg := restic.RepositoryHashAlgorithm(r)
 	var hash restic.ID
 	var hdrBuf []byte
 	hashingLoader := func(ctx context.Context, h backend.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
 		return r.Backend().Load(ctx, h, int(size), 0, func(rd io.Reader) error {
-			hrd := hashing.NewReader(rd, sha256.New())
+			hrd := hashing.NewReader(rd, alg.New())
 			bufRd.Reset(hrd)
 
 			// skip to start of first blob, offset == 0 for correct pack files
@@ -594,7 +595,7 @@ func checkPack(ctx context.Context, r restic.Repository, id restic.ID, blobs []r
 		})
 	}
 
-	err := repository.StreamPack(ctx, hashingLoader, r.Key(), id, blobs, func(blob restic.BlobHandle, buf []byte, err error) error {
+	err := repository.StreamPackWithHash(ctx, hashingLoader, r.Key(), alg, id, blobs, func(blob restic.BlobHandle, buf []byte, err error) error {
 		debug.Log("  check blob %v: %v", blob.ID, blob)
 		if err != nil {
 			debug.Log("  error verifying blob %v: %v", blob.ID, err)
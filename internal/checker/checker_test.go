@@ -17,6 +17,7 @@ import (
 	"github.com/konidev20/rapi/internal/checker"
 	"github.com/konidev20/rapi/internal/errors"
 	"github.com/konidev20/rapi/internal/hashing"
+	"github.com/konidev20/rapi/internal/index"
 	"github.com/konidev20/rapi/repository"
 	"github.com/konidev20/rapi/restic"
 	"github.com/konidev20/rapi/internal/test"
@@ -194,6 +195,59 @@ func TestUnreferencedBlobs(t *testing.T) {
 	test.Equals(t, unusedBlobsBySnapshot, blobs)
 }
 
+func TestCheckPackHeaders(t *testing.T) {
+	repodir, cleanup := test.Env(t, checkerTestData)
+	defer cleanup()
+
+	repo := repository.TestOpenLocal(t, repodir)
+
+	chkr := checker.New(repo, false)
+	_, errs := chkr.LoadIndex(context.TODO(), nil)
+	test.OKs(t, errs)
+	test.OKs(t, collectErrors(context.TODO(), func(ctx context.Context, errChan chan<- error) {
+		chkr.PackHeaders(ctx, nil, errChan)
+	}))
+}
+
+func TestCheckPackHeadersDrift(t *testing.T) {
+	repo := repository.TestRepository(t)
+
+	var wg errgroup.Group
+	repo.StartPackUploader(context.TODO(), &wg)
+	_, _, _, err := repo.SaveBlob(context.TODO(), restic.DataBlob, []byte("foo"), restic.ID{}, false)
+	test.OK(t, err)
+	test.OK(t, repo.Flush(context.TODO()))
+
+	var packID restic.ID
+	var blob restic.Blob
+	repo.Index().Each(context.TODO(), func(pb restic.PackedBlob) {
+		packID, blob = pb.PackID, pb.Blob
+	})
+
+	// add an index file which claims that the pack contains another blob
+	bogus := blob
+	bogus.ID = restic.NewRandomID()
+	bogus.Offset += blob.Length
+	idx := index.NewIndex()
+	idx.StorePack(packID, []restic.Blob{bogus})
+	idx.Finalize()
+	_, err = index.SaveIndex(context.TODO(), repo, idx)
+	test.OK(t, err)
+
+	chkr := checker.New(repo, false)
+	_, errs := chkr.LoadIndex(context.TODO(), nil)
+	test.OKs(t, errs)
+	errs = collectErrors(context.TODO(), func(ctx context.Context, errChan chan<- error) {
+		chkr.PackHeaders(ctx, nil, errChan)
+	})
+	test.Equals(t, 1, len(errs))
+
+	var e *checker.PackHeaderError
+	test.Assert(t, errors.As(errs[0], &e), "unexpected error %v", errs[0])
+	test.Equals(t, packID, e.ID)
+	test.Equals(t, []restic.Blob{blob}, e.Blobs)
+}
+
 func TestModifiedIndex(t *testing.T) {
 	repodir, cleanup := test.Env(t, checkerTestData)
 	defer cleanup()
@@ -0,0 +1,118 @@
+package checker
+
+import (
+	"context"
+	"sort"
+
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/restic"
+	"github.com/konidev20/rapi/ui/progress"
+	"golang.org/x/sync/errgroup"
+)
+
+// PackHeaderError is reported by PackHeaders if the index entries of a pack
+// differ from its header. Blobs contains the blobs listed in the header.
+type PackHeaderError struct {
+	ID    restic.ID
+	Blobs []restic.Blob
+	Err   error
+}
+
+func (e *PackHeaderError) Error() string {
+	return "pack " + e.ID.String() + ": " + e.Err.Error()
+}
+
+// PackHeaders downloads only the header of each pack referenced by the index
+// and compares it to the index entries, which detects a drift between the
+// index and the packs without reading the pack contents. Packs which cannot
+// be read are reported as PackError, mismatches as PackHeaderError. errChan
+// is closed after all packs have been checked.
+func (c *Checker) PackHeaders(ctx context.Context, p *progress.Counter, errChan chan<- error) {
+	defer close(errChan)
+
+	// the header is located using the actual size, which may differ from
+	// the size expected by the index
+	sizes := make(map[restic.ID]int64)
+	err := c.repo.List(ctx, restic.PackFile, func(id restic.ID, size int64) error {
+		if _, ok := c.packs[id]; ok {
+			sizes[id] = size
+		}
+		return nil
+	})
+	if err != nil {
+		select {
+		case <-ctx.Done():
+		case errChan <- err:
+		}
+		return
+	}
+	p.SetMax(uint64(len(sizes)))
+
+	g, ctx := errgroup.WithContext(ctx)
+	ch := make(chan restic.PackBlobs)
+
+	// only the headers are read, thus the concurrency is limited by IO
+	for i := 0; i < int(c.repo.Connections()); i++ {
+		g.Go(func() error {
+			for pbs := range ch {
+				err := c.checkPackHeader(ctx, pbs, sizes[pbs.PackID])
+				p.Add(1)
+				if err == nil {
+					continue
+				}
+
+				select {
+				case <-ctx.Done():
+					return nil
+				case errChan <- err:
+				}
+			}
+			return nil
+		})
+	}
+
+	// missing packs are reported by Packs
+	packSet := restic.NewIDSet()
+	for id := range sizes {
+		packSet.Insert(id)
+	}
+	for pbs := range c.repo.Index().ListPacks(ctx, packSet) {
+		select {
+		case ch <- pbs:
+		case <-ctx.Done():
+		}
+	}
+	close(ch)
+
+	if err := g.Wait(); err != nil {
+		select {
+		case <-ctx.Done():
+		case errChan <- err:
+		}
+	}
+}
+
+func (c *Checker) checkPackHeader(ctx context.Context, pbs restic.PackBlobs, size int64) error {
+	debug.Log("checking header of pack %v", pbs.PackID)
+	header, _, err := c.repo.ListPack(ctx, pbs.PackID, size)
+	if err != nil {
+		return &PackError{ID: pbs.PackID, Err: errors.Wrap(err, "ListPack")}
+	}
+
+	indexed := append([]restic.Blob(nil), pbs.Blobs...)
+	sort.Slice(indexed, func(i, j int) bool { return indexed[i].Offset < indexed[j].Offset })
+	sort.Slice(header, func(i, j int) bool { return header[i].Offset < header[j].Offset })
+
+	if len(indexed) != len(header) {
+		return &PackHeaderError{ID: pbs.PackID, Blobs: header,
+			Err: errors.Errorf("index lists %d blobs, pack header %d", len(indexed), len(header))}
+	}
+	for i := range header {
+		if indexed[i] != header[i] {
+			return &PackHeaderError{ID: pbs.PackID, Blobs: header,
+				Err: errors.Errorf("index entry %v does not match pack header entry %v", indexed[i], header[i])}
+		}
+	}
+	return nil
+}
@@ -2,21 +2,28 @@ package debug
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/konidev20/rapi/internal/fs"
 )
 
 var opts struct {
-	isEnabled bool
-	logger    *log.Logger
-	funcs     map[string]bool
-	files     map[string]bool
+	isEnabled atomic.Bool
+
+	// mu protects the fields below, they can be changed at runtime
+	mu     sync.RWMutex
+	logger *log.Logger
+	output io.Closer
+	funcs  map[string]bool
+	files  map[string]bool
 }
 
 // make sure that all the initialization happens before the init() functions
@@ -28,11 +35,11 @@ func initDebug() bool {
 	initDebugTags()
 
 	if opts.logger == nil && len(opts.funcs) == 0 && len(opts.files) == 0 {
-		opts.isEnabled = false
+		opts.isEnabled.Store(false)
 		return false
 	}
 
-	opts.isEnabled = true
+	opts.isEnabled.Store(true)
 	fmt.Fprintf(os.Stderr, "debug enabled\n")
 
 	return true
@@ -53,35 +60,14 @@ func initDebugLogger() {
 	}
 
 	opts.logger = log.New(f, "", log.LstdFlags)
+	opts.output = f
 }
 
 func parseFilter(envname string, pad func(string) string) map[string]bool {
-	filter := make(map[string]bool)
-
-	env := os.Getenv(envname)
-	if env == "" {
-		return filter
-	}
-
-	for _, fn := range strings.Split(env, ",") {
-		t := pad(strings.TrimSpace(fn))
-		val := true
-		if t[0] == '-' {
-			val = false
-			t = t[1:]
-		} else if t[0] == '+' {
-			val = true
-			t = t[1:]
-		}
-
-		// test pattern
-		_, err := path.Match(t, "")
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "error: invalid pattern %q: %v\n", t, err)
-			os.Exit(5)
-		}
-
-		filter[t] = val
+	filter, err := newFilter(os.Getenv(envname), pad)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(5)
 	}
 
 	return filter
@@ -163,7 +149,7 @@ func checkFilter(filter map[string]bool, key string) bool {
 
 // Log prints a message to the debug log (if debug is enabled).
 func Log(f string, args ...interface{}) {
-	if !opts.isEnabled {
+	if !opts.isEnabled.Load() {
 		return
 	}
 
@@ -192,6 +178,9 @@ func Log(f string, args ...interface{}) {
 		fmt.Fprintf(os.Stderr, formatString, args...)
 	}
 
+	opts.mu.RLock()
+	defer opts.mu.RUnlock()
+
 	if opts.logger != nil {
 		opts.logger.Printf(formatString, args...)
 	}
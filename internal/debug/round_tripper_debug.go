@@ -9,7 +9,7 @@ import "net/http"
 // debug is enabled). When debug is not enabled, upstream is returned.
 func RoundTripper(upstream http.RoundTripper) http.RoundTripper {
 	eofRoundTripper := eofDetectRoundTripper{upstream}
-	if opts.isEnabled {
+	if opts.isEnabled.Load() {
 		// only use loggingRoundTripper if the debug log is configured
 		return loggingRoundTripper{eofRoundTripper}
 	}
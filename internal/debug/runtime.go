@@ -0,0 +1,207 @@
+package debug
+
+import (
+	"io"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/internal/fs"
+)
+
+// SetOutput enables the debug log and writes all messages to w, replacing the
+// previous target. If the previous target was opened by OpenFile or from the
+// DEBUG_LOG environment variable, it is closed.
+func SetOutput(w io.Writer) error {
+	return setOutput(log.New(w, "", log.LstdFlags), nil)
+}
+
+// OpenFile enables the debug log and appends all messages to the file
+// filename, replacing the previous target. If maxSize is larger than zero,
+// the file is rotated once it exceeds maxSize bytes, keeping at most backups
+// old files named filename.1, filename.2 and so on.
+func OpenFile(filename string, maxSize int64, backups int) error {
+	f, err := newRotatingFile(filename, maxSize, backups)
+	if err != nil {
+		return err
+	}
+
+	return setOutput(log.New(f, "", log.LstdFlags), f)
+}
+
+func setOutput(logger *log.Logger, output io.Closer) error {
+	opts.mu.Lock()
+	defer opts.mu.Unlock()
+
+	err := closeOutput()
+	opts.logger = logger
+	opts.output = output
+	opts.isEnabled.Store(true)
+	return err
+}
+
+// Disable stops writing to the debug log and closes the file opened by
+// OpenFile, if any. Messages matching the filters set by SetFilter are still
+// printed to stderr.
+func Disable() error {
+	opts.mu.Lock()
+	defer opts.mu.Unlock()
+
+	err := closeOutput()
+	opts.logger = nil
+	opts.isEnabled.Store(len(opts.funcs) > 0 || len(opts.files) > 0)
+	return err
+}
+
+// closeOutput closes the current target, opts.mu must be held.
+func closeOutput() error {
+	if opts.output == nil {
+		return nil
+	}
+
+	err := opts.output.Close()
+	opts.output = nil
+	return errors.WithStack(err)
+}
+
+// Enabled returns true if debug messages are currently written anywhere.
+func Enabled() bool {
+	return opts.isEnabled.Load()
+}
+
+// SetFilter configures which messages are additionally printed to stderr,
+// using the same syntax as the DEBUG_FUNCS and DEBUG_FILES environment
+// variables: a comma-separated list of patterns, prefixed by "-" to exclude
+// matches. Empty strings remove the filter.
+func SetFilter(funcs, files string) error {
+	fn, err := newFilter(funcs, padFunc)
+	if err != nil {
+		return err
+	}
+	fl, err := newFilter(files, padFile)
+	if err != nil {
+		return err
+	}
+
+	opts.mu.Lock()
+	defer opts.mu.Unlock()
+
+	opts.funcs = fn
+	opts.files = fl
+	opts.isEnabled.Store(opts.logger != nil || len(fn) > 0 || len(fl) > 0)
+	return nil
+}
+
+func newFilter(list string, pad func(string) string) (map[string]bool, error) {
+	filter := make(map[string]bool)
+	for _, fn := range strings.Split(list, ",") {
+		fn = strings.TrimSpace(fn)
+		if fn == "" {
+			continue
+		}
+
+		t := pad(fn)
+
+		val := true
+		if t[0] == '-' {
+			val = false
+			t = t[1:]
+		} else if t[0] == '+' {
+			t = t[1:]
+		}
+
+		if _, err := path.Match(t, ""); err != nil {
+			return nil, errors.Errorf("invalid pattern %q: %v", t, err)
+		}
+
+		filter[t] = val
+	}
+
+	return filter, nil
+}
+
+// rotatingFile is an append-only file which is rotated once it exceeds
+// maxSize bytes.
+type rotatingFile struct {
+	filename string
+	maxSize  int64
+	backups  int
+
+	f    *os.File
+	size int64
+}
+
+func newRotatingFile(filename string, maxSize int64, backups int) (*rotatingFile, error) {
+	r := &rotatingFile{
+		filename: filename,
+		maxSize:  maxSize,
+		backups:  backups,
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := fs.OpenFile(r.filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return errors.WithStack(err)
+	}
+
+	r.f = f
+	r.size = fi.Size()
+	return nil
+}
+
+// Write appends p to the file. It is called by log.Logger, which serializes
+// all calls.
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if r.backups <= 0 {
+		if err := fs.Remove(r.filename); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return errors.WithStack(err)
+		}
+	} else {
+		for i := r.backups - 1; i > 0; i-- {
+			_ = fs.Rename(backupName(r.filename, i), backupName(r.filename, i+1))
+		}
+		if err := fs.Rename(r.filename, backupName(r.filename, 1)); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return r.open()
+}
+
+func (r *rotatingFile) Close() error {
+	return r.f.Close()
+}
+
+func backupName(filename string, i int) string {
+	return filename + "." + strconv.Itoa(i)
+}
@@ -0,0 +1,80 @@
+package debug
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSetOutput(t *testing.T) {
+	defer func() { _ = Disable() }()
+
+	var buf bytes.Buffer
+	if err := SetOutput(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !Enabled() {
+		t.Fatal("debug log not enabled")
+	}
+
+	Log("first message")
+	if !strings.Contains(buf.String(), "first message") {
+		t.Fatalf("message not found in output %q", buf.String())
+	}
+
+	if err := Disable(); err != nil {
+		t.Fatal(err)
+	}
+	Log("second message")
+	if strings.Contains(buf.String(), "second message") {
+		t.Fatalf("message logged after Disable: %q", buf.String())
+	}
+}
+
+func TestOpenFileRotate(t *testing.T) {
+	defer func() { _ = Disable() }()
+
+	filename := filepath.Join(t.TempDir(), "debug.log")
+	if err := OpenFile(filename, 200, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 20; i++ {
+		Log("message %d", i)
+	}
+
+	if err := Disable(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{filename, filename + ".1", filename + ".2"} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Size() > 200 {
+			t.Errorf("file %v is larger than the limit: %d bytes", name, fi.Size())
+		}
+	}
+
+	if _, err := os.Stat(filename + ".3"); !os.IsNotExist(err) {
+		t.Errorf("too many backups kept: %v", err)
+	}
+
+	// the most recent message is in the current file
+	buf, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(buf), "message 19") {
+		t.Errorf("last message not found in %q", buf)
+	}
+}
+
+func TestSetFilterInvalid(t *testing.T) {
+	if err := SetFilter("[", ""); err == nil {
+		t.Fatal("invalid pattern was accepted")
+	}
+}
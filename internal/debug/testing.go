@@ -9,15 +9,19 @@ import (
 // TestLogToStderr configures debug to log to stderr if not the debug log is
 // not already configured and returns whether logging was enabled.
 func TestLogToStderr(_ testing.TB) bool {
-	if opts.isEnabled {
+	if opts.isEnabled.Load() {
 		return false
 	}
+	opts.mu.Lock()
 	opts.logger = log.New(os.Stderr, "", log.LstdFlags)
-	opts.isEnabled = true
+	opts.mu.Unlock()
+	opts.isEnabled.Store(true)
 	return true
 }
 
 func TestDisableLog(_ testing.TB) {
+	opts.mu.Lock()
 	opts.logger = nil
-	opts.isEnabled = false
+	opts.mu.Unlock()
+	opts.isEnabled.Store(false)
 }
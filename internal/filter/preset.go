@@ -0,0 +1,100 @@
+package filter
+
+import (
+	"sort"
+
+	"github.com/konidev20/rapi/internal/errors"
+)
+
+// Names of the exclusion presets.
+const (
+	PresetWindows       = "windows"
+	PresetMacOS         = "macos"
+	PresetLinux         = "linux"
+	PresetBrowserCaches = "browser-caches"
+)
+
+// presets maps the name of a preset to its exclude patterns. The patterns
+// list files which are recreated by the operating system or the
+// applications and are useless or harmful to restore. Patterns are matched
+// case-sensitively, so they use the spelling the systems create.
+var presets = map[string][]string{
+	PresetWindows: {
+		"**/pagefile.sys",
+		"**/hiberfil.sys",
+		"**/swapfile.sys",
+		"**/$Recycle.Bin",
+		"**/System Volume Information",
+		"**/Windows/Temp",
+		"**/Windows/SoftwareDistribution/Download",
+		"**/AppData/Local/Temp",
+		"**/AppData/Local/Microsoft/Windows/INetCache",
+		"**/AppData/Local/Microsoft/Windows/Explorer/thumbcache_*.db",
+		"**/Thumbs.db",
+	},
+	PresetMacOS: {
+		"**/.DS_Store",
+		"**/.Spotlight-V100",
+		"**/.fseventsd",
+		"**/.Trashes",
+		"**/.TemporaryItems",
+		"**/.DocumentRevisions-V100",
+		"**/.MobileBackups",
+		"**/Library/Caches",
+		"/private/var/vm",
+		"/System/Volumes/Data/private/var/vm",
+	},
+	PresetLinux: {
+		"/proc",
+		"/sys",
+		"/run",
+		"/dev",
+		"/var/run",
+		"/var/lock",
+		"/lost+found",
+		"**/.cache/thumbnails",
+		"**/.local/share/Trash",
+	},
+	PresetBrowserCaches: {
+		// Linux
+		"**/.cache/mozilla/firefox/*/cache2",
+		"**/.cache/google-chrome",
+		"**/.cache/chromium",
+		"**/.cache/BraveSoftware",
+		// macOS
+		"**/Library/Caches/Firefox",
+		"**/Library/Caches/Google/Chrome",
+		"**/Library/Caches/com.apple.Safari",
+		"**/Library/Caches/Microsoft Edge",
+		// Windows
+		"**/AppData/Local/Mozilla/Firefox/Profiles/*/cache2",
+		"**/AppData/Local/Google/Chrome/User Data/*/Cache",
+		"**/AppData/Local/Google/Chrome/User Data/*/Code Cache",
+		"**/AppData/Local/Microsoft/Edge/User Data/*/Cache",
+		"**/AppData/Local/Microsoft/Edge/User Data/*/Code Cache",
+	},
+}
+
+// Presets returns the names of all exclusion presets.
+func Presets() []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PresetPatterns returns the exclude patterns of the presets names, in the
+// order of names. An error is returned for unknown names.
+func PresetPatterns(names []string) ([]string, error) {
+	var patterns []string
+	for _, name := range names {
+		p, ok := presets[name]
+		if !ok {
+			return nil, errors.Errorf("unknown exclusion preset %q", name)
+		}
+		patterns = append(patterns, p...)
+	}
+	return patterns, nil
+}
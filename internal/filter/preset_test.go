@@ -0,0 +1,59 @@
+package filter_test
+
+import (
+	"testing"
+
+	"github.com/konidev20/rapi/internal/filter"
+)
+
+func TestPresetPatterns(t *testing.T) {
+	for _, name := range filter.Presets() {
+		patterns, err := filter.PresetPatterns([]string{name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(patterns) == 0 {
+			t.Errorf("preset %v is empty", name)
+		}
+		if err := filter.ValidatePatterns(patterns); err != nil {
+			t.Errorf("preset %v: %v", name, err)
+		}
+	}
+
+	if _, err := filter.PresetPatterns([]string{"unknown"}); err == nil {
+		t.Error("no error for unknown preset")
+	}
+}
+
+func TestPresetMatch(t *testing.T) {
+	patterns, err := filter.PresetPatterns([]string{filter.PresetWindows, filter.PresetMacOS, filter.PresetLinux, filter.PresetBrowserCaches})
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := filter.ParsePatterns(patterns)
+
+	for _, test := range []struct {
+		path  string
+		match bool
+	}{
+		{"/proc", true},
+		{"/proc/1/status", true},
+		{"/home/user/proc", false},
+		{"/Users/user/Documents/.DS_Store", true},
+		{"/Users/user/Library/Caches/com.example", true},
+		{"C:/pagefile.sys", true},
+		{"C:/Users/user/AppData/Local/Temp/foo.tmp", true},
+		{"/home/user/.cache/google-chrome/Default/Cache", true},
+		{"/home/user/.cache/mozilla/firefox/abc.default/cache2/entries", true},
+		{"/home/user/.mozilla/firefox/abc.default/places.sqlite", false},
+		{"/home/user/Documents/report.txt", false},
+	} {
+		m, err := filter.List(list, test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m != test.match {
+			t.Errorf("%v: want match %v, got %v", test.path, test.match, m)
+		}
+	}
+}
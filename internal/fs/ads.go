@@ -0,0 +1,12 @@
+package fs
+
+// AlternateDataStreamPrefix is the prefix of the extended attribute names
+// which store the alternate data streams of files on Windows (NTFS). The
+// stream name follows the prefix.
+const AlternateDataStreamPrefix = "windows.ads:"
+
+// DataStream is an alternate data stream of a file.
+type DataStream struct {
+	Name string
+	Data []byte
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package fs
+
+// ReadAlternateDataStreams returns the alternate data streams of name, they
+// only exist on Windows.
+func ReadAlternateDataStreams(_ string) ([]DataStream, error) {
+	return nil, nil
+}
+
+// WriteAlternateDataStream is not supported on this platform, the stream is
+// ignored.
+func WriteAlternateDataStream(_, _ string, _ []byte) error {
+	return nil
+}
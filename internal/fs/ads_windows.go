@@ -0,0 +1,90 @@
+//go:build windows
+// +build windows
+
+package fs
+
+import (
+	"os"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	modkernel32          = windows.NewLazySystemDLL("kernel32.dll")
+	procFindFirstStreamW = modkernel32.NewProc("FindFirstStreamW")
+	procFindNextStreamW  = modkernel32.NewProc("FindNextStreamW")
+)
+
+// win32FindStreamData is WIN32_FIND_STREAM_DATA.
+type win32FindStreamData struct {
+	StreamSize int64
+	StreamName [windows.MAX_PATH + 36]uint16
+}
+
+// listStreams returns the names of the alternate data streams of name, the
+// unnamed default stream is skipped.
+func listStreams(name string) ([]string, error) {
+	ptr, err := windows.UTF16PtrFromString(fixpath(name))
+	if err != nil {
+		return nil, err
+	}
+
+	var data win32FindStreamData
+	// FindStreamInfoStandard = 0
+	h, _, err := procFindFirstStreamW.Call(uintptr(unsafe.Pointer(ptr)), 0, uintptr(unsafe.Pointer(&data)), 0)
+	if windows.Handle(h) == windows.InvalidHandle {
+		if err == windows.ERROR_HANDLE_EOF {
+			// no streams at all, for example for most directories
+			return nil, nil
+		}
+		return nil, &os.PathError{Op: "FindFirstStream", Path: name, Err: err}
+	}
+	defer func() {
+		_ = windows.FindClose(windows.Handle(h))
+	}()
+
+	var streams []string
+	for {
+		// names have the form ":name:$DATA", the default stream is "::$DATA"
+		stream := strings.TrimSuffix(windows.UTF16ToString(data.StreamName[:]), ":$DATA")
+		stream = strings.TrimPrefix(stream, ":")
+		if stream != "" {
+			streams = append(streams, stream)
+		}
+
+		ok, _, err := procFindNextStreamW.Call(h, uintptr(unsafe.Pointer(&data)))
+		if ok == 0 {
+			if err == windows.ERROR_HANDLE_EOF {
+				return streams, nil
+			}
+			return nil, &os.PathError{Op: "FindNextStream", Path: name, Err: err}
+		}
+	}
+}
+
+// ReadAlternateDataStreams returns the names and contents of the alternate
+// data streams of name.
+func ReadAlternateDataStreams(name string) ([]DataStream, error) {
+	streams, err := listStreams(name)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]DataStream, 0, len(streams))
+	for _, stream := range streams {
+		data, err := os.ReadFile(fixpath(name) + ":" + stream)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, DataStream{Name: stream, Data: data})
+	}
+	return list, nil
+}
+
+// WriteAlternateDataStream replaces the contents of the alternate data stream
+// of name.
+func WriteAlternateDataStream(name, stream string, data []byte) error {
+	return os.WriteFile(fixpath(name)+":"+stream, data, 0600)
+}
@@ -0,0 +1,7 @@
+package fs
+
+// O_NOFOLLOW is a noop on js/wasm.
+const O_NOFOLLOW int = 0
+
+// O_NONBLOCK is a noop on js/wasm.
+const O_NONBLOCK int = 0
@@ -3,5 +3,10 @@
 
 package fs
 
+import "syscall"
+
 // O_NOFOLLOW is a noop on Windows.
 const O_NOFOLLOW int = 0
+
+// O_NONBLOCK does not block open on fifos etc.
+const O_NONBLOCK int = syscall.O_NONBLOCK
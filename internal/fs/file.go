@@ -69,9 +69,10 @@ func Stat(name string) (os.FileInfo, error) {
 // Lstat returns the FileInfo structure describing the named file.
 // If the file is a symbolic link, the returned FileInfo
 // describes the symbolic link.  Lstat makes no attempt to follow the link.
-// If there is an error, it will be of type *PathError.
+// If there is an error, it will be of type *PathError. On Windows, directory
+// junctions are reported as symbolic links.
 func Lstat(name string) (os.FileInfo, error) {
-	return os.Lstat(fixpath(name))
+	return lstat(name)
 }
 
 // Create creates the named file with mode 0666 (before umask), truncating
@@ -88,6 +89,12 @@ func Open(name string) (File, error) {
 	return os.Open(fixpath(name))
 }
 
+// ReadDir reads the directory name and returns its entries sorted by
+// filename. The entries contain the file types, but no further information.
+func ReadDir(name string) ([]os.DirEntry, error) {
+	return os.ReadDir(fixpath(name))
+}
+
 // OpenFile is the generalized open call; most users will use Open
 // or Create instead.  It opens the named file with specified flag
 // (O_RDONLY etc.) and perm, (0666 etc.) if applicable.  If successful,
@@ -109,7 +116,7 @@ func Walk(root string, walkFn filepath.WalkFunc) error {
 
 // RemoveIfExists removes a file, returning no error if it does not exist.
 func RemoveIfExists(filename string) error {
-	err := os.Remove(filename)
+	err := os.Remove(fixpath(filename))
 	if err != nil && os.IsNotExist(err) {
 		err = nil
 	}
@@ -14,6 +14,23 @@ func fixpath(name string) string {
 	return name
 }
 
+// lstat is os.Lstat, junctions only exist on Windows.
+func lstat(name string) (os.FileInfo, error) {
+	return os.Lstat(name)
+}
+
+// IsJunction returns true if name is a directory junction, they only exist on
+// Windows.
+func IsJunction(_ string) (bool, error) {
+	return false, nil
+}
+
+// CanCreateDevices reports whether the process is expected to be allowed to
+// create device nodes, this requires root privileges.
+func CanCreateDevices() bool {
+	return os.Geteuid() == 0
+}
+
 // TempFile creates a temporary file which has already been deleted (on
 // supported platforms)
 func TempFile(dir, prefix string) (f *os.File, err error) {
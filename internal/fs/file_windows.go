@@ -14,26 +14,39 @@ import (
 // fixpath returns an absolute path on windows, so restic can open long file
 // names.
 func fixpath(name string) string {
+	// paths in the Win32 file or device namespace must not be modified,
+	// filepath.Abs would remove the prefix or the trailing dots
+	if strings.HasPrefix(name, `\\?\`) || strings.HasPrefix(name, `\\.\`) {
+		return name
+	}
 	abspath, err := filepath.Abs(name)
 	if err == nil {
-		// Check if \\?\UNC\ already exist
-		if strings.HasPrefix(abspath, `\\?\UNC\`) {
-			return abspath
-		}
-		// Check if \\?\ already exist
-		if strings.HasPrefix(abspath, `\\?\`) {
-			return abspath
-		}
-		// Check if path starts with \\
-		if strings.HasPrefix(abspath, `\\`) {
-			return strings.Replace(abspath, `\\`, `\\?\UNC\`, 1)
-		}
-		// Normal path
-		return `\\?\` + abspath
+		return longPath(abspath)
 	}
 	return name
 }
 
+// longPath converts the absolute path abspath to an extended-length path,
+// "C:\foo" becomes "\\?\C:\foo" and the UNC path "\\host\share\foo" becomes
+// "\\?\UNC\host\share\foo". Such paths are not limited to MAX_PATH characters.
+func longPath(abspath string) string {
+	switch {
+	case strings.HasPrefix(abspath, `\\?\`), strings.HasPrefix(abspath, `\\.\`):
+		// already extended-length or a device path
+		return abspath
+	case strings.HasPrefix(abspath, `\\`):
+		return `\\?\UNC\` + abspath[2:]
+	default:
+		return `\\?\` + abspath
+	}
+}
+
+// CanCreateDevices reports whether the process is expected to be allowed to
+// create device nodes, this is never possible on Windows.
+func CanCreateDevices() bool {
+	return false
+}
+
 // TempFile creates a temporary file which is marked as delete-on-close
 func TempFile(dir, prefix string) (f *os.File, err error) {
 	// slightly modified implementation of os.CreateTemp(dir, prefix) to allow us to add
@@ -77,3 +90,58 @@ func TempFile(dir, prefix string) (f *os.File, err error) {
 func Chmod(name string, mode os.FileMode) error {
 	return os.Chmod(fixpath(name), mode)
 }
+
+// lstat is os.Lstat, except that directory junctions are reported as
+// symlinks. Starting with Go 1.23 junctions have the mode os.ModeIrregular,
+// the archiver would not save them at all.
+func lstat(name string) (os.FileInfo, error) {
+	fi, err := os.Lstat(fixpath(name))
+	if err != nil || fi.Mode()&os.ModeIrregular == 0 {
+		return fi, err
+	}
+
+	if ok, err := IsJunction(name); err != nil || !ok {
+		return fi, nil
+	}
+	return junctionFileInfo{fi}, nil
+}
+
+type junctionFileInfo struct {
+	os.FileInfo
+}
+
+func (fi junctionFileInfo) Mode() os.FileMode {
+	return fi.FileInfo.Mode()&^(os.ModeIrregular|os.ModeDir) | os.ModeSymlink
+}
+
+func (fi junctionFileInfo) IsDir() bool {
+	return false
+}
+
+// ReparseTag returns the reparse tag of name, it is zero if name is no
+// reparse point.
+func ReparseTag(name string) (uint32, error) {
+	ptr, err := windows.UTF16PtrFromString(fixpath(name))
+	if err != nil {
+		return 0, err
+	}
+
+	var data windows.Win32finddata
+	h, err := windows.FindFirstFile(ptr, &data)
+	if err != nil {
+		return 0, &os.PathError{Op: "FindFirstFile", Path: name, Err: err}
+	}
+	_ = windows.FindClose(h)
+
+	if data.FileAttributes&windows.FILE_ATTRIBUTE_REPARSE_POINT == 0 {
+		return 0, nil
+	}
+	return data.Reserved0, nil
+}
+
+// IsJunction returns true if name is a directory junction (mount point)
+// instead of a symlink.
+func IsJunction(name string) (bool, error) {
+	tag, err := ReparseTag(name)
+	return tag == windows.IO_REPARSE_TAG_MOUNT_POINT, err
+}
@@ -0,0 +1,21 @@
+package fs
+
+import (
+	"testing"
+
+	rtest "github.com/konidev20/rapi/internal/test"
+)
+
+func TestLongPath(t *testing.T) {
+	for _, test := range []struct {
+		in, want string
+	}{
+		{`C:\foo\bar`, `\\?\C:\foo\bar`},
+		{`\\host\share\foo`, `\\?\UNC\host\share\foo`},
+		{`\\?\C:\foo`, `\\?\C:\foo`},
+		{`\\?\UNC\host\share`, `\\?\UNC\host\share`},
+		{`\\.\pipe\foo`, `\\.\pipe\foo`},
+	} {
+		rtest.Equals(t, test.want, longPath(test.in))
+	}
+}
@@ -51,9 +51,10 @@ func (fs Local) Stat(name string) (os.FileInfo, error) {
 // Lstat returns the FileInfo structure describing the named file.
 // If the file is a symbolic link, the returned FileInfo
 // describes the symbolic link.  Lstat makes no attempt to follow the link.
-// If there is an error, it will be of type *PathError.
+// If there is an error, it will be of type *PathError. On Windows, directory
+// junctions are reported as symbolic links.
 func (fs Local) Lstat(name string) (os.FileInfo, error) {
-	return os.Lstat(fixpath(name))
+	return lstat(name)
 }
 
 // Join joins any number of path elements into a single path, adding a
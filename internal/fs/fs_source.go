@@ -0,0 +1,264 @@
+package fs
+
+import (
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+)
+
+// Source is a tree of files which is not necessarily stored in a local file
+// system, for example the objects in a bucket or data generated by an
+// application. All names are absolute and use slashes as separator.
+type Source interface {
+	// Open returns the contents of the regular file name.
+	Open(name string) (io.ReadCloser, error)
+	// Readdir returns the entries of the directory name.
+	Readdir(name string) ([]os.FileInfo, error)
+	// Lstat describes name without following symlinks.
+	Lstat(name string) (os.FileInfo, error)
+	// Readlink returns the target of the symlink name.
+	Readlink(name string) (string, error)
+}
+
+// Readlinker is implemented by file systems which resolve symlinks
+// themselves. The archiver uses it for symlinks without system specific
+// information in os.FileInfo.Sys().
+type Readlinker interface {
+	Readlink(name string) (string, error)
+}
+
+// SourceFS is a read-only file system which provides the files of a Source.
+type SourceFS struct {
+	Source Source
+}
+
+// statically ensure that SourceFS implements FS and Readlinker.
+var _ FS = &SourceFS{}
+var _ Readlinker = &SourceFS{}
+
+// NewSourceFS returns a file system for src.
+func NewSourceFS(src Source) *SourceFS {
+	return &SourceFS{Source: src}
+}
+
+// Open opens a file or directory for reading.
+func (fs *SourceFS) Open(name string) (File, error) {
+	fi, err := fs.Source.Lstat(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if fi.IsDir() {
+		entries, err := fs.Source.Readdir(name)
+		if err != nil {
+			return nil, err
+		}
+		return fakeDir{entries: entries, fakeFile: fakeFile{name: name, FileInfo: fi}}, nil
+	}
+
+	if !fi.Mode().IsRegular() {
+		return fakeFile{name: name, FileInfo: fi}, nil
+	}
+
+	rd, err := fs.Source.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	f := newReaderFile(rd, fi, true)
+	f.fakeFile.name = name
+	return f, nil
+}
+
+// OpenFile opens a file for reading, only O_RDONLY and O_NOFOLLOW are
+// supported.
+func (fs *SourceFS) OpenFile(name string, flag int, _ os.FileMode) (File, error) {
+	if flag & ^(O_RDONLY|O_NOFOLLOW) != 0 {
+		return nil, pathError("open", name,
+			fmt.Errorf("invalid combination of flags 0x%x", flag))
+	}
+	return fs.Open(name)
+}
+
+// Stat returns a FileInfo describing the named file. Symlinks are not
+// followed.
+func (fs *SourceFS) Stat(name string) (os.FileInfo, error) {
+	return fs.Source.Lstat(name)
+}
+
+// Lstat returns a FileInfo describing the named file.
+func (fs *SourceFS) Lstat(name string) (os.FileInfo, error) {
+	return fs.Source.Lstat(name)
+}
+
+// Readlink returns the target of the symlink name.
+func (fs *SourceFS) Readlink(name string) (string, error) {
+	return fs.Source.Readlink(name)
+}
+
+// VolumeName returns leading volume name, it is always empty.
+func (fs *SourceFS) VolumeName(_ string) string {
+	return ""
+}
+
+// Join joins any number of path elements into a single path.
+func (fs *SourceFS) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+// Separator returns the separator for dirs/subdirs/files, always "/".
+func (fs *SourceFS) Separator() string {
+	return "/"
+}
+
+// IsAbs reports whether the path is absolute.
+func (fs *SourceFS) IsAbs(p string) bool {
+	return path.IsAbs(p)
+}
+
+// Abs returns an absolute representation of path, relative paths are
+// relative to the root of the source.
+func (fs *SourceFS) Abs(p string) (string, error) {
+	return path.Join("/", p), nil
+}
+
+// Clean returns the cleaned path.
+func (fs *SourceFS) Clean(p string) string {
+	return path.Clean(p)
+}
+
+// Base returns the last element of p.
+func (fs *SourceFS) Base(p string) string {
+	return path.Base(p)
+}
+
+// Dir returns p without the last element.
+func (fs *SourceFS) Dir(p string) string {
+	return path.Dir(p)
+}
+
+// LocalSource is a Source for the local file system.
+type LocalSource struct{}
+
+// statically ensure that LocalSource implements Source.
+var _ Source = LocalSource{}
+
+// Open opens the file name for reading.
+func (LocalSource) Open(name string) (io.ReadCloser, error) {
+	return os.Open(fixpath(name))
+}
+
+// Readdir returns the entries of the directory name.
+func (LocalSource) Readdir(name string) ([]os.FileInfo, error) {
+	f, err := os.Open(fixpath(name))
+	if err != nil {
+		return nil, err
+	}
+	entries, err := f.Readdir(-1)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return entries, err
+}
+
+// Lstat describes name without following symlinks.
+func (LocalSource) Lstat(name string) (os.FileInfo, error) {
+	return os.Lstat(fixpath(name))
+}
+
+// Readlink returns the target of the symlink name.
+func (LocalSource) Readlink(name string) (string, error) {
+	return os.Readlink(fixpath(name))
+}
+
+// IOFSSource is a Source for a file system from the io/fs package, for
+// example fstest.MapFS or an embed.FS. Absolute names are mapped to the root
+// of the file system. Symlinks are supported if the file system implements
+// a ReadLink method, otherwise the contents of a file with the mode
+// os.ModeSymlink are used as its target, like fstest.MapFS stores them.
+type IOFSSource struct {
+	FS iofs.FS
+}
+
+// statically ensure that IOFSSource implements Source.
+var _ Source = IOFSSource{}
+
+func iofsName(name string) string {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+// Open opens the file name for reading.
+func (s IOFSSource) Open(name string) (io.ReadCloser, error) {
+	return s.FS.Open(iofsName(name))
+}
+
+// Readdir returns the entries of the directory name.
+func (s IOFSSource) Readdir(name string) ([]os.FileInfo, error) {
+	entries, err := iofs.ReadDir(s.FS, iofsName(name))
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		fi, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, fi)
+	}
+	return list, nil
+}
+
+// Lstat describes name without following symlinks. Unless the file system
+// implements a Lstat method, the entry is looked up in its parent directory,
+// since Stat may follow symlinks.
+func (s IOFSSource) Lstat(name string) (os.FileInfo, error) {
+	name = iofsName(name)
+	if lst, ok := s.FS.(interface {
+		Lstat(name string) (iofs.FileInfo, error)
+	}); ok {
+		return lst.Lstat(name)
+	}
+	if name == "." {
+		return iofs.Stat(s.FS, name)
+	}
+
+	entries, err := iofs.ReadDir(s.FS, path.Dir(name))
+	if err != nil {
+		return nil, err
+	}
+	base := path.Base(name)
+	for _, entry := range entries {
+		if entry.Name() == base {
+			return entry.Info()
+		}
+	}
+	return nil, pathError("lstat", name, os.ErrNotExist)
+}
+
+// Readlink returns the target of the symlink name.
+func (s IOFSSource) Readlink(name string) (string, error) {
+	if rl, ok := s.FS.(interface {
+		ReadLink(name string) (string, error)
+	}); ok {
+		return rl.ReadLink(iofsName(name))
+	}
+
+	fi, err := s.Lstat(name)
+	if err != nil {
+		return "", err
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		return "", pathError("readlink", name, syscall.EINVAL)
+	}
+	buf, err := iofs.ReadFile(s.FS, iofsName(name))
+	return string(buf), err
+}
@@ -0,0 +1,204 @@
+package fs
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"syscall"
+
+	"github.com/konidev20/rapi/internal/errors"
+)
+
+// TarSource is a Source for the contents of an uncompressed tar archive, as
+// written by `borg export-tar` or `kopia restore` with a .tar target. The
+// archive is indexed once, afterwards files are read directly from their
+// offset in the archive. Directories missing in the archive are created
+// implicitly, hard links share the contents of their target.
+type TarSource struct {
+	rd      io.ReaderAt
+	entries map[string]*tarEntry
+}
+
+type tarEntry struct {
+	fi       os.FileInfo
+	offset   int64
+	size     int64
+	link     string
+	children []string
+}
+
+// statically ensure that TarSource implements Source.
+var _ Source = &TarSource{}
+
+// countingReader counts the bytes read from the archive, after tar.Reader.Next
+// returned this is the offset of the data of the current entry.
+type countingReader struct {
+	rd  io.Reader
+	pos int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.rd.Read(p)
+	r.pos += int64(n)
+	return n, err
+}
+
+func tarName(name string) string {
+	return path.Clean("/" + name)
+}
+
+// NewTarSource indexes the tar archive of the given size read from rd.
+func NewTarSource(rd io.ReaderAt, size int64) (*TarSource, error) {
+	s := &TarSource{
+		rd:      rd,
+		entries: make(map[string]*tarEntry),
+	}
+	s.entries["/"] = &tarEntry{fi: fakeFileInfo{name: "/", mode: os.ModeDir | 0755}}
+
+	cr := &countingReader{rd: io.NewSectionReader(rd, 0, size)}
+	tr := tar.NewReader(cr)
+	var links []*tar.Header
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "tar.Next")
+		}
+
+		name := tarName(hdr.Name)
+		if name == "/" {
+			continue
+		}
+		switch hdr.Typeflag {
+		case tar.TypeGNUSparse:
+			return nil, errors.Errorf("%v: sparse files are not supported", hdr.Name)
+		case tar.TypeLink:
+			// resolved after all entries are known
+			links = append(links, hdr)
+			continue
+		case tar.TypeXGlobalHeader:
+			continue
+		}
+		if _, ok := hdr.PAXRecords["GNU.sparse.map"]; ok {
+			return nil, errors.Errorf("%v: sparse files are not supported", hdr.Name)
+		}
+
+		s.add(name, &tarEntry{
+			fi:     hdr.FileInfo(),
+			offset: cr.pos,
+			size:   hdr.Size,
+			link:   hdr.Linkname,
+		})
+	}
+
+	for _, hdr := range links {
+		target, ok := s.entries[tarName(hdr.Linkname)]
+		if !ok || !target.fi.Mode().IsRegular() {
+			return nil, errors.Errorf("%v: invalid hard link to %v", hdr.Name, hdr.Linkname)
+		}
+		fi := hdr.FileInfo()
+		s.add(tarName(hdr.Name), &tarEntry{
+			fi: fakeFileInfo{
+				name:    fi.Name(),
+				size:    target.size,
+				mode:    target.fi.Mode(),
+				modtime: fi.ModTime(),
+			},
+			offset: target.offset,
+			size:   target.size,
+		})
+	}
+
+	for _, e := range s.entries {
+		sort.Strings(e.children)
+	}
+	return s, nil
+}
+
+// add inserts the entry name and creates missing parent directories.
+func (s *TarSource) add(name string, e *tarEntry) {
+	if old, ok := s.entries[name]; ok {
+		// a later entry replaces an earlier one, like tar does on extraction
+		e.children = old.children
+		s.entries[name] = e
+		return
+	}
+	s.entries[name] = e
+
+	for name != "/" {
+		dir := path.Dir(name)
+		parent, ok := s.entries[dir]
+		if !ok {
+			parent = &tarEntry{fi: fakeFileInfo{name: path.Base(dir), mode: os.ModeDir | 0755}}
+			s.entries[dir] = parent
+		}
+		parent.children = append(parent.children, path.Base(name))
+		if ok {
+			break
+		}
+		name = dir
+	}
+}
+
+func (s *TarSource) lookup(op, name string) (*tarEntry, error) {
+	e, ok := s.entries[tarName(name)]
+	if !ok {
+		return nil, pathError(op, name, syscall.ENOENT)
+	}
+	return e, nil
+}
+
+// Open returns the contents of the regular file name.
+func (s *TarSource) Open(name string) (io.ReadCloser, error) {
+	e, err := s.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if !e.fi.Mode().IsRegular() {
+		return nil, pathError("open", name, syscall.EINVAL)
+	}
+	return io.NopCloser(io.NewSectionReader(s.rd, e.offset, e.size)), nil
+}
+
+// Readdir returns the entries of the directory name.
+func (s *TarSource) Readdir(name string) ([]os.FileInfo, error) {
+	e, err := s.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !e.fi.IsDir() {
+		return nil, pathError("readdir", name, syscall.ENOTDIR)
+	}
+
+	dir := tarName(name)
+	list := make([]os.FileInfo, 0, len(e.children))
+	for _, child := range e.children {
+		list = append(list, s.entries[path.Join(dir, child)].fi)
+	}
+	return list, nil
+}
+
+// Lstat describes name without following symlinks.
+func (s *TarSource) Lstat(name string) (os.FileInfo, error) {
+	e, err := s.lookup("lstat", name)
+	if err != nil {
+		return nil, err
+	}
+	return e.fi, nil
+}
+
+// Readlink returns the target of the symlink name.
+func (s *TarSource) Readlink(name string) (string, error) {
+	e, err := s.lookup("readlink", name)
+	if err != nil {
+		return "", err
+	}
+	if e.fi.Mode()&os.ModeSymlink == 0 {
+		return "", pathError("readlink", name, syscall.EINVAL)
+	}
+	return e.link, nil
+}
@@ -0,0 +1,86 @@
+package fs
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"testing"
+	"testing/fstest"
+
+	"github.com/konidev20/rapi/internal/test"
+)
+
+func TestSourceFS(t *testing.T) {
+	fs := NewSourceFS(IOFSSource{FS: fstest.MapFS{
+		"dir/foo":     {Data: []byte("foo content")},
+		"dir/sub/bar": {Data: []byte("bar")},
+		"link":        {Data: []byte("dir/foo"), Mode: os.ModeSymlink},
+	}})
+
+	verifyDirectoryContents(t, fs, "/", []string{"dir", "link"})
+	verifyDirectoryContents(t, fs, "/dir", []string{"foo", "sub"})
+	verifyFileContentOpen(t, fs, "/dir/foo", []byte("foo content"))
+	verifyFileContentOpenFile(t, fs, "/dir/sub/bar", []byte("bar"))
+
+	fi, err := fs.Lstat("/dir/foo")
+	test.OK(t, err)
+	test.Equals(t, int64(len("foo content")), fi.Size())
+
+	fi, err = fs.Lstat("/link")
+	test.OK(t, err)
+	test.Assert(t, fi.Mode()&os.ModeSymlink != 0, "expected symlink, got mode %v", fi.Mode())
+	target, err := fs.Readlink("/link")
+	test.OK(t, err)
+	test.Equals(t, "dir/foo", target)
+
+	_, err = fs.Readlink("/dir/foo")
+	test.Assert(t, err != nil, "Readlink of regular file did not fail")
+
+	_, err = fs.Lstat("/missing")
+	test.Assert(t, os.IsNotExist(err), "unexpected error for missing file: %v", err)
+
+	_, err = fs.OpenFile("/dir/foo", os.O_WRONLY, 0)
+	test.Assert(t, err != nil, "opening file for writing did not fail")
+}
+
+func TestTarSource(t *testing.T) {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, e := range []struct {
+		hdr  tar.Header
+		data string
+	}{
+		{hdr: tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0700}},
+		{hdr: tar.Header{Name: "dir/foo", Typeflag: tar.TypeReg, Mode: 0644}, data: "foo content"},
+		{hdr: tar.Header{Name: "implicit/sub/bar", Typeflag: tar.TypeReg, Mode: 0600}, data: "bar"},
+		{hdr: tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "dir/foo"}},
+		{hdr: tar.Header{Name: "hardlink", Typeflag: tar.TypeLink, Linkname: "dir/foo"}},
+	} {
+		e.hdr.Size = int64(len(e.data))
+		test.OK(t, tw.WriteHeader(&e.hdr))
+		_, err := tw.Write([]byte(e.data))
+		test.OK(t, err)
+	}
+	test.OK(t, tw.Close())
+
+	src, err := NewTarSource(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	test.OK(t, err)
+	fs := NewSourceFS(src)
+
+	verifyDirectoryContents(t, fs, "/", []string{"dir", "hardlink", "implicit", "link"})
+	verifyDirectoryContents(t, fs, "/implicit", []string{"sub"})
+	verifyFileContentOpen(t, fs, "/dir/foo", []byte("foo content"))
+	verifyFileContentOpen(t, fs, "/implicit/sub/bar", []byte("bar"))
+	verifyFileContentOpen(t, fs, "/hardlink", []byte("foo content"))
+
+	fi, err := fs.Lstat("/dir")
+	test.OK(t, err)
+	test.Equals(t, os.ModeDir|0700, fi.Mode())
+
+	target, err := fs.Readlink("/link")
+	test.OK(t, err)
+	test.Equals(t, "dir/foo", target)
+
+	_, err = fs.Lstat("/dir/missing")
+	test.Assert(t, os.IsNotExist(err), "unexpected error for missing file: %v", err)
+}
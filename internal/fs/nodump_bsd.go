@@ -0,0 +1,22 @@
+//go:build freebsd || darwin || netbsd
+// +build freebsd darwin netbsd
+
+package fs
+
+import (
+	"os"
+	"syscall"
+)
+
+// ufNoDump is UF_NODUMP from sys/stat.h, it is set by `chflags nodump`.
+const ufNoDump = 0x1
+
+// IsNoDump returns true if the nodump flag is set for path, fi must be its
+// file info.
+func IsNoDump(_ string, fi os.FileInfo) (bool, error) {
+	s, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false, nil
+	}
+	return s.Flags&ufNoDump != 0, nil
+}
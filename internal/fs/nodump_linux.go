@@ -0,0 +1,41 @@
+package fs
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// fsNoDumpFl is FS_NODUMP_FL from linux/fs.h, it is set by `chattr +d`.
+const fsNoDumpFl = 0x40
+
+// IsNoDump returns true if the nodump flag is set for path, fi must be its
+// file info. Only regular files and directories on the local file system are
+// checked, the file is opened to read the flags. File systems which do not
+// support flags report false.
+func IsNoDump(path string, fi os.FileInfo) (bool, error) {
+	if _, ok := fi.Sys().(*syscall.Stat_t); !ok {
+		return false, nil
+	}
+	if !fi.Mode().IsRegular() && !fi.IsDir() {
+		return false, nil
+	}
+
+	fd, err := unix.Open(path, unix.O_RDONLY|unix.O_NONBLOCK|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return false, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	defer func() {
+		_ = unix.Close(fd)
+	}()
+
+	flags, err := unix.IoctlGetUint32(fd, unix.FS_IOC_GETFLAGS)
+	switch err {
+	case nil:
+		return flags&fsNoDumpFl != 0, nil
+	case unix.ENOTTY, unix.ENOTSUP, unix.EINVAL:
+		return false, nil
+	}
+	return false, &os.PathError{Op: "ioctl", Path: path, Err: err}
+}
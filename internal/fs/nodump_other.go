@@ -0,0 +1,11 @@
+//go:build !linux && !freebsd && !darwin && !netbsd
+// +build !linux,!freebsd,!darwin,!netbsd
+
+package fs
+
+import "os"
+
+// IsNoDump returns false, the nodump flag is not supported on this platform.
+func IsNoDump(string, os.FileInfo) (bool, error) {
+	return false, nil
+}
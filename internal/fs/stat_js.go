@@ -0,0 +1,31 @@
+package fs
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// extendedStat extracts info into an ExtendedFileInfo for js/wasm.
+func extendedStat(fi os.FileInfo) ExtendedFileInfo {
+	s := fi.Sys().(*syscall.Stat_t)
+
+	extFI := ExtendedFileInfo{
+		FileInfo:  fi,
+		DeviceID:  uint64(s.Dev),
+		Inode:     s.Ino,
+		Links:     uint64(s.Nlink),
+		UID:       s.Uid,
+		GID:       s.Gid,
+		Device:    uint64(s.Rdev),
+		BlockSize: int64(s.Blksize),
+		Blocks:    int64(s.Blocks),
+		Size:      s.Size,
+
+		AccessTime: time.Unix(s.Atime, s.AtimeNsec),
+		ModTime:    time.Unix(s.Mtime, s.MtimeNsec),
+		ChangeTime: time.Unix(s.Ctime, s.CtimeNsec),
+	}
+
+	return extFI
+}
@@ -1,5 +1,5 @@
-//go:build !windows && !darwin && !freebsd && !netbsd
-// +build !windows,!darwin,!freebsd,!netbsd
+//go:build !windows && !darwin && !freebsd && !netbsd && !js
+// +build !windows,!darwin,!freebsd,!netbsd,!js
 
 package fs
 
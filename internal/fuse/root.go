@@ -19,6 +19,10 @@ type Config struct {
 	Filter        restic.SnapshotFilter
 	TimeTemplate  string
 	PathTemplates []string
+
+	// AsOf adds the directory "as-of" which contains the synthetic snapshot
+	// of the view. If it is set, all trees are loaded through the view.
+	AsOf *restic.AsOfView
 }
 
 // Root is the root node of the fuse mount of a repository.
@@ -51,6 +55,10 @@ func NewRoot(repo restic.Repository, cfg Config) *Root {
 		blobCache: bloblru.New(blobCacheSize),
 	}
 
+	if cfg.AsOf != nil {
+		root.repo = cfg.AsOf
+	}
+
 	if !cfg.OwnerIsRoot {
 		root.uid = uint32(os.Getuid())
 		root.gid = uint32(os.Getgid())
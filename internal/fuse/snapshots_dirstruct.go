@@ -281,6 +281,10 @@ func (d *SnapshotsDirStructure) makeDirs(snapshots restic.Snapshots) {
 		}
 	}
 
+	if d.root != nil && d.root.cfg.AsOf != nil {
+		mount("/as-of", mountData{sn: d.root.cfg.AsOf.Snapshot()})
+	}
+
 	d.entries = entries
 }
 
@@ -0,0 +1,462 @@
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/konidev20/rapi/crypto"
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/restic"
+)
+
+// A DiskIndex is a read-only index which is stored in a local file instead
+// of in memory. It is used for repositories whose index does not fit into
+// the memory of small devices. Lookups are a binary search in the file, only
+// a fan-out table of 512 KiB is kept in memory.
+//
+// The file contains a table of fixed-size entries sorted by blob ID and
+// type, followed by the IDs of all packs. An entry refers to its pack by
+// the position in the pack table.
+//
+// The lookup methods cannot return errors. If the file cannot be read, they
+// report the blob as missing and the error is returned by Err.
+type DiskIndex struct {
+	f *os.File
+
+	errMu sync.Mutex
+	err   error
+
+	// fanout[i] is the position of the first entry whose ID starts with the
+	// two byte prefix i.
+	fanout  []uint64
+	entries uint64
+	packs   uint64
+	ids     restic.IDs
+}
+
+const (
+	diskEntrySize = 32 + 1 + 4 + 4 + 4 + 4
+	diskKeySize   = 32 + 1
+	diskFanout    = 1 << 16
+)
+
+func encodeDiskEntry(buf []byte, pb restic.PackedBlob, packIndex uint32) {
+	copy(buf, pb.ID[:])
+	buf[32] = byte(pb.Type)
+	binary.LittleEndian.PutUint32(buf[33:], packIndex)
+	binary.LittleEndian.PutUint32(buf[37:], uint32(pb.Offset))
+	binary.LittleEndian.PutUint32(buf[41:], uint32(pb.Length))
+	binary.LittleEndian.PutUint32(buf[45:], uint32(pb.UncompressedLength))
+}
+
+func decodeDiskEntry(buf []byte) (blob restic.Blob, packIndex uint32) {
+	copy(blob.ID[:], buf)
+	blob.Type = restic.BlobType(buf[32])
+	packIndex = binary.LittleEndian.Uint32(buf[33:])
+	blob.Offset = uint(binary.LittleEndian.Uint32(buf[37:]))
+	blob.Length = uint(binary.LittleEndian.Uint32(buf[41:]))
+	blob.UncompressedLength = uint(binary.LittleEndian.Uint32(buf[45:]))
+	return blob, packIndex
+}
+
+func diskKey(bh restic.BlobHandle) []byte {
+	key := make([]byte, diskKeySize)
+	copy(key, bh.ID[:])
+	key[32] = byte(bh.Type)
+	return key
+}
+
+// DiskIndexBuilder collects the entries of final indexes in temporary files
+// in a directory and writes them to a DiskIndex. The entries are split into
+// 256 buckets by the first byte of the blob ID, such that only one bucket
+// needs to be sorted in memory at a time.
+type DiskIndexBuilder struct {
+	dir     string
+	buckets [256]*os.File
+	wrs     [256]*bufio.Writer
+
+	packFile *os.File
+	packWr   *bufio.Writer
+	packs    map[restic.ID]uint32
+	ids      restic.IDs
+}
+
+// NewDiskIndexBuilder returns a builder which stores its files in dir.
+func NewDiskIndexBuilder(dir string) (*DiskIndexBuilder, error) {
+	b := &DiskIndexBuilder{dir: dir, packs: make(map[restic.ID]uint32)}
+
+	var err error
+	b.packFile, err = os.CreateTemp(dir, "rapi-index-packs-")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	b.packWr = bufio.NewWriter(b.packFile)
+
+	for i := range b.buckets {
+		b.buckets[i], err = os.CreateTemp(dir, "rapi-index-bucket-")
+		if err != nil {
+			b.Discard()
+			return nil, errors.WithStack(err)
+		}
+		b.wrs[i] = bufio.NewWriterSize(b.buckets[i], 16*1024)
+	}
+	return b, nil
+}
+
+// Add adds all entries of the final index idx.
+func (b *DiskIndexBuilder) Add(ctx context.Context, idx *Index) error {
+	ids, err := idx.IDs()
+	if err != nil {
+		return err
+	}
+	b.ids = append(b.ids, ids...)
+
+	buf := make([]byte, diskEntrySize)
+	idx.Each(ctx, func(pb restic.PackedBlob) {
+		if err != nil {
+			return
+		}
+
+		packIndex, ok := b.packs[pb.PackID]
+		if !ok {
+			packIndex = uint32(len(b.packs))
+			b.packs[pb.PackID] = packIndex
+			if _, err = b.packWr.Write(pb.PackID[:]); err != nil {
+				return
+			}
+		}
+
+		encodeDiskEntry(buf, pb, packIndex)
+		_, err = b.wrs[pb.ID[0]].Write(buf)
+	})
+	if err != nil {
+		return errors.Wrap(err, "Write")
+	}
+	return ctx.Err()
+}
+
+// Finish sorts the entries and writes the DiskIndex. The temporary files of
+// the builder are removed, the builder must not be used afterwards.
+func (b *DiskIndexBuilder) Finish() (*DiskIndex, error) {
+	defer b.Discard()
+
+	f, err := os.CreateTemp(b.dir, "rapi-index-")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	d := &DiskIndex{
+		f:      f,
+		fanout: make([]uint64, diskFanout+1),
+		packs:  uint64(len(b.packs)),
+		ids:    b.ids,
+	}
+
+	wr := bufio.NewWriter(f)
+	for i := range b.buckets {
+		n, err := b.writeBucket(i, wr, d.fanout)
+		if err != nil {
+			_ = d.Close()
+			return nil, err
+		}
+		d.entries += n
+	}
+
+	// turn the number of entries per prefix into start positions
+	var sum uint64
+	for i, n := range d.fanout {
+		d.fanout[i] = sum
+		sum += n
+	}
+
+	err = b.packWr.Flush()
+	if err == nil {
+		_, err = b.packFile.Seek(0, io.SeekStart)
+	}
+	if err == nil {
+		_, err = io.Copy(wr, b.packFile)
+	}
+	if err == nil {
+		err = wr.Flush()
+	}
+	if err != nil {
+		_ = d.Close()
+		return nil, errors.Wrap(err, "Write")
+	}
+
+	debug.Log("wrote on-disk index with %d entries and %d packs to %v", d.entries, d.packs, f.Name())
+	return d, nil
+}
+
+// writeBucket sorts the entries of bucket i, removes duplicates and writes
+// them to wr. The number of entries per two byte prefix is added to counts.
+func (b *DiskIndexBuilder) writeBucket(i int, wr io.Writer, counts []uint64) (uint64, error) {
+	if err := b.wrs[i].Flush(); err != nil {
+		return 0, errors.Wrap(err, "Flush")
+	}
+	buf, err := os.ReadFile(b.buckets[i].Name())
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	entries := make([][]byte, 0, len(buf)/diskEntrySize)
+	for len(buf) >= diskEntrySize {
+		entries = append(entries, buf[:diskEntrySize])
+		buf = buf[diskEntrySize:]
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i], entries[j]) < 0
+	})
+
+	var n uint64
+	for j, e := range entries {
+		// an entry is contained in several index files if they overlap
+		if j > 0 && bytes.Equal(e, entries[j-1]) {
+			continue
+		}
+		if _, err := wr.Write(e); err != nil {
+			return 0, errors.Wrap(err, "Write")
+		}
+		counts[int(e[0])<<8|int(e[1])]++
+		n++
+	}
+	return n, nil
+}
+
+// Discard removes the temporary files of the builder.
+func (b *DiskIndexBuilder) Discard() {
+	files := append([]*os.File{b.packFile}, b.buckets[:]...)
+	for _, f := range files {
+		if f == nil {
+			continue
+		}
+		_ = f.Close()
+		if err := os.Remove(f.Name()); err != nil && !os.IsNotExist(err) {
+			debug.Log("unable to remove %v: %v", f.Name(), err)
+		}
+	}
+	b.packFile = nil
+	b.buckets = [256]*os.File{}
+}
+
+// Close closes and removes the index file.
+func (d *DiskIndex) Close() error {
+	err := d.f.Close()
+	if rerr := os.Remove(d.f.Name()); rerr != nil {
+		debug.Log("unable to remove %v: %v", d.f.Name(), rerr)
+	}
+	return errors.WithStack(err)
+}
+
+func (d *DiskIndex) readEntry(i uint64, buf []byte) error {
+	_, err := d.f.ReadAt(buf, int64(i)*diskEntrySize)
+	return err
+}
+
+func (d *DiskIndex) packID(packIndex uint32) (id restic.ID, err error) {
+	off := int64(d.entries)*diskEntrySize + int64(packIndex)*int64(len(id))
+	_, err = d.f.ReadAt(id[:], off)
+	return id, err
+}
+
+// search returns the position of the first entry for bh, or the position of
+// the next larger entry if there is none.
+func (d *DiskIndex) search(bh restic.BlobHandle, buf []byte) (uint64, error) {
+	key := diskKey(bh)
+	prefix := int(bh.ID[0])<<8 | int(bh.ID[1])
+	lo, hi := d.fanout[prefix], d.fanout[prefix+1]
+
+	var err error
+	n := sort.Search(int(hi-lo), func(i int) bool {
+		if err != nil {
+			return true
+		}
+		err = d.readEntry(lo+uint64(i), buf)
+		return bytes.Compare(buf[:diskKeySize], key) >= 0
+	})
+	return lo + uint64(n), err
+}
+
+// each calls fn for all entries for bh.
+func (d *DiskIndex) each(bh restic.BlobHandle, fn func(blob restic.Blob, packIndex uint32) bool) error {
+	buf := make([]byte, diskEntrySize)
+	i, err := d.search(bh, buf)
+	if err != nil {
+		return err
+	}
+
+	key := diskKey(bh)
+	for ; i < d.entries; i++ {
+		if err := d.readEntry(i, buf); err != nil {
+			return err
+		}
+		if !bytes.Equal(buf[:diskKeySize], key) {
+			return nil
+		}
+		if !fn(decodeDiskEntry(buf)) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// failed records the first error of reading the index file.
+func (d *DiskIndex) failed(err error) {
+	if err == nil {
+		return
+	}
+	debug.Log("reading on-disk index %v failed: %v", d.f.Name(), err)
+
+	d.errMu.Lock()
+	defer d.errMu.Unlock()
+	if d.err == nil {
+		d.err = errors.Wrapf(err, "read on-disk index %v", d.f.Name())
+	}
+}
+
+// Err returns the first error of reading the index file. The results of
+// lookups are incomplete once it is set.
+func (d *DiskIndex) Err() error {
+	d.errMu.Lock()
+	defer d.errMu.Unlock()
+	return d.err
+}
+
+// Lookup returns all entries for the blob. Adds found entries to pbs and
+// returns the result.
+func (d *DiskIndex) Lookup(bh restic.BlobHandle, pbs []restic.PackedBlob) []restic.PackedBlob {
+	var err error
+	lerr := d.each(bh, func(blob restic.Blob, packIndex uint32) bool {
+		var packID restic.ID
+		packID, err = d.packID(packIndex)
+		if err != nil {
+			return false
+		}
+		pbs = append(pbs, restic.PackedBlob{Blob: blob, PackID: packID})
+		return true
+	})
+	if lerr == nil {
+		lerr = err
+	}
+	d.failed(lerr)
+	return pbs
+}
+
+// Has returns true iff the blob is listed in the index.
+func (d *DiskIndex) Has(bh restic.BlobHandle) bool {
+	found := false
+	err := d.each(bh, func(restic.Blob, uint32) bool {
+		found = true
+		return false
+	})
+	d.failed(err)
+	return found
+}
+
+// LookupSize returns the length of the plaintext content of the blob.
+func (d *DiskIndex) LookupSize(bh restic.BlobHandle) (plaintextLength uint, found bool) {
+	err := d.each(bh, func(blob restic.Blob, _ uint32) bool {
+		found = true
+		if blob.UncompressedLength != 0 {
+			plaintextLength = blob.UncompressedLength
+		} else {
+			plaintextLength = uint(crypto.PlaintextLength(int(blob.Length)))
+		}
+		return false
+	})
+	d.failed(err)
+	return plaintextLength, found
+}
+
+// IDs returns the IDs of the index files the index was built from.
+func (d *DiskIndex) IDs() restic.IDs {
+	return d.ids
+}
+
+// Packs returns all packs in the index.
+func (d *DiskIndex) Packs() restic.IDSet {
+	packs, err := d.loadPacks()
+	d.failed(err)
+	return restic.NewIDSet(packs...)
+}
+
+func (d *DiskIndex) loadPacks() (restic.IDs, error) {
+	buf := make([]byte, d.packs*uint64(len(restic.ID{})))
+	_, err := d.f.ReadAt(buf, int64(d.entries)*diskEntrySize)
+	if err != nil {
+		return nil, err
+	}
+
+	packs := make(restic.IDs, d.packs)
+	for i := range packs {
+		copy(packs[i][:], buf[i*len(packs[i]):])
+	}
+	return packs, nil
+}
+
+// Each passes all blobs known to the index to the callback fn. The entries
+// are read sequentially, the pack IDs are kept in memory meanwhile.
+func (d *DiskIndex) Each(ctx context.Context, fn func(restic.PackedBlob)) {
+	d.failed(d.eachEntry(ctx, fn))
+}
+
+func (d *DiskIndex) eachEntry(ctx context.Context, fn func(restic.PackedBlob)) error {
+	packs, err := d.loadPacks()
+	if err != nil {
+		return err
+	}
+
+	rd := bufio.NewReader(io.NewSectionReader(d.f, 0, int64(d.entries)*diskEntrySize))
+	buf := make([]byte, diskEntrySize)
+	for i := uint64(0); i < d.entries; i++ {
+		if ctx.Err() != nil {
+			return nil
+		}
+		if _, err := io.ReadFull(rd, buf); err != nil {
+			return err
+		}
+		blob, packIndex := decodeDiskEntry(buf)
+		fn(restic.PackedBlob{Blob: blob, PackID: packs[packIndex]})
+	}
+	return nil
+}
+
+// EachByPack returns a channel that yields all blobs known to the index
+// grouped by packID but ignoring blobs with a packID in packBlacklist. The
+// index is read once for each of 16 groups of packs to keep the memory
+// overhead bounded. When the context is cancelled, the background goroutine
+// terminates.
+func (d *DiskIndex) EachByPack(ctx context.Context, packBlacklist restic.IDSet) <-chan EachByPackResult {
+	ch := make(chan EachByPackResult)
+
+	go func() {
+		defer close(ch)
+
+		for i := byte(0); i < 16; i++ {
+			byPack := make(map[restic.ID][]restic.Blob)
+			d.Each(ctx, func(pb restic.PackedBlob) {
+				if pb.PackID[0]&0xf == i && !packBlacklist.Has(pb.PackID) {
+					byPack[pb.PackID] = append(byPack[pb.PackID], pb.Blob)
+				}
+			})
+
+			for packID, blobs := range byPack {
+				// allow GC once entry is no longer necessary
+				delete(byPack, packID)
+				select {
+				case <-ctx.Done():
+					return
+				case ch <- EachByPackResult{PackID: packID, Blobs: blobs}:
+				}
+			}
+		}
+	}()
+
+	return ch
+}
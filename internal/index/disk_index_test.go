@@ -0,0 +1,122 @@
+package index_test
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+
+	"github.com/konidev20/rapi/internal/index"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/restic"
+)
+
+func TestDiskIndex(t *testing.T) {
+	ctx := context.Background()
+	rng := rand.New(rand.NewSource(42))
+
+	idx1, bh := createRandomIndex(rng, 20)
+	idx2, _ := createRandomIndex(rng, 20)
+
+	// a pack which is contained in both index files
+	shared := restic.Blob{
+		BlobHandle: restic.BlobHandle{Type: restic.TreeBlob, ID: NewRandomTestID(rng)},
+		Length:     100,
+	}
+	sharedPack := NewRandomTestID(rng)
+	idx1.StorePack(sharedPack, []restic.Blob{shared})
+	idx2.StorePack(sharedPack, []restic.Blob{shared})
+
+	var ids restic.IDs
+	for _, idx := range []*index.Index{idx1, idx2} {
+		idx.Finalize()
+		id := NewRandomTestID(rng)
+		rtest.OK(t, idx.SetID(id))
+		ids = append(ids, id)
+	}
+
+	b, err := index.NewDiskIndexBuilder(t.TempDir())
+	rtest.OK(t, err)
+	rtest.OK(t, b.Add(ctx, idx1))
+	rtest.OK(t, b.Add(ctx, idx2))
+	d, err := b.Finish()
+	rtest.OK(t, err)
+
+	rtest.Equals(t, ids, d.IDs())
+	allPacks := idx1.Packs()
+	allPacks.Merge(idx2.Packs())
+	rtest.Equals(t, allPacks, d.Packs())
+
+	count := 0
+	for _, idx := range []*index.Index{idx1, idx2} {
+		idx.Each(ctx, func(pb restic.PackedBlob) {
+			count++
+			rtest.Equals(t, []restic.PackedBlob{pb}, d.Lookup(pb.BlobHandle, nil))
+		})
+	}
+
+	n := 0
+	d.Each(ctx, func(pb restic.PackedBlob) {
+		n++
+	})
+	rtest.Equals(t, count-1, n)
+
+	size, found := d.LookupSize(bh)
+	rtest.Assert(t, found, "blob %v not found", bh)
+	expected, _ := idx1.LookupSize(bh)
+	rtest.Equals(t, expected, size)
+
+	rtest.Assert(t, d.Has(shared.BlobHandle), "shared blob not found")
+	unknown := restic.BlobHandle{Type: restic.DataBlob, ID: NewRandomTestID(rng)}
+	rtest.Assert(t, !d.Has(unknown), "unknown blob found")
+	rtest.Assert(t, !d.Has(restic.BlobHandle{Type: restic.TreeBlob, ID: bh.ID}), "blob with wrong type found")
+
+	packs := restic.NewIDSet()
+	for res := range d.EachByPack(ctx, restic.NewIDSet(sharedPack)) {
+		rtest.Assert(t, !packs.Has(res.PackID), "pack %v returned twice", res.PackID)
+		packs.Insert(res.PackID)
+	}
+	rtest.Equals(t, d.Packs().Sub(restic.NewIDSet(sharedPack)), packs)
+
+	mi := index.NewMasterIndex()
+	rtest.OK(t, mi.SetDiskIndex(d))
+	rtest.Assert(t, mi.Has(bh), "blob %v not found in master index", bh)
+	rtest.Equals(t, restic.NewIDSet(ids...), mi.IDs())
+	rtest.Assert(t, mi.AddPending(unknown), "unknown blob is not new")
+	rtest.Assert(t, !mi.AddPending(bh), "known blob is new")
+	rtest.OK(t, mi.Close())
+}
+
+func TestDiskIndexReadError(t *testing.T) {
+	rng := rand.New(rand.NewSource(42))
+	idx, bh := createRandomIndex(rng, 5)
+	idx.Finalize()
+	rtest.OK(t, idx.SetID(NewRandomTestID(rng)))
+
+	b, err := index.NewDiskIndexBuilder(t.TempDir())
+	rtest.OK(t, err)
+	rtest.OK(t, b.Add(context.TODO(), idx))
+	d, err := b.Finish()
+	rtest.OK(t, err)
+	rtest.Assert(t, d.Has(bh), "blob %v not found", bh)
+
+	// reads from the closed file fail, the error is recorded instead of
+	// only reporting the blob as missing
+	rtest.OK(t, d.Close())
+	rtest.OK(t, d.Err())
+	rtest.Assert(t, !d.Has(bh), "blob found in closed index")
+	rtest.Assert(t, d.Err() != nil, "read error was not recorded")
+	d.Lookup(bh, nil)
+	d.LookupSize(bh)
+	d.Packs()
+	d.Each(context.TODO(), func(restic.PackedBlob) {})
+
+	mi := index.NewMasterIndex()
+	rtest.OK(t, mi.SetDiskIndex(d))
+	rtest.Assert(t, mi.Err() != nil, "read error is not reported by the master index")
+	rtest.Assert(t, restic.IndexErr(mi) != nil, "read error is not reported by IndexErr")
+
+	// a blob which might be in the failed index is not added as pending, so
+	// that it is not uploaded again
+	other := restic.NewRandomBlobHandle()
+	rtest.Assert(t, !mi.AddPending(other), "blob added after the index failed")
+}
@@ -0,0 +1,32 @@
+package index_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/konidev20/rapi/internal/index"
+	"github.com/konidev20/rapi/restic"
+)
+
+func FuzzDecodeIndex(f *testing.F) {
+	f.Add(docExampleV1)
+	f.Add(docExampleV2)
+	f.Add(docOldExample)
+	f.Add([]byte(`{"packs":[{"id":"73d04e6125cf3c28a299cc2f3cca3b78ceac396e4fcf9575e34536b26782413c","blobs":[{"id":"3ec79977ef0cf5de7b08cd12b874cd0f62bbaf7f07f3497a5b1bbcc8cb39b1ce","type":"data","offset":0,"length":4294967296}]}]}`))
+	f.Add([]byte(`[null]`))
+
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		idx, _, err := index.DecodeIndex(buf, restic.NewRandomID())
+		if err != nil {
+			return
+		}
+
+		var out bytes.Buffer
+		if err := idx.Encode(&out); err != nil {
+			t.Fatalf("decoded index cannot be encoded: %v", err)
+		}
+		if _, _, err := index.DecodeIndex(out.Bytes(), restic.NewRandomID()); err != nil {
+			t.Fatalf("encoded index cannot be decoded: %v", err)
+		}
+	})
+}
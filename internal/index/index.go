@@ -1,9 +1,11 @@
 package index
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"io"
+	"sort"
 	"sync"
 	"time"
 
@@ -316,7 +318,19 @@ type blobJSON struct {
 	UncompressedLength uint            `json:"uncompressed_length,omitempty"`
 }
 
-// generatePackList returns a list of packs.
+// check returns an error if the blob cannot be stored in an index, so that
+// malformed index files are rejected instead of causing a panic.
+func (b blobJSON) check() error {
+	if b.Type != restic.DataBlob && b.Type != restic.TreeBlob {
+		return errors.Errorf("blob %v has invalid type %v", b.ID.Str(), b.Type)
+	}
+	if b.Offset > maxuint32 || b.Length > maxuint32 || b.UncompressedLength > maxuint32 {
+		return errors.Errorf("blob %v has invalid offset or length", b.ID.Str())
+	}
+	return nil
+}
+
+// generatePackList returns a list of packs sorted by ID.
 func (idx *Index) generatePackList() ([]packJSON, error) {
 	list := make([]packJSON, 0, len(idx.packs))
 	packs := make(map[restic.ID]int, len(list)) // Maps to index in list.
@@ -350,6 +364,13 @@ func (idx *Index) generatePackList() ([]packJSON, error) {
 		})
 	}
 
+	// the order the packs were added depends on the order the uploads
+	// finished, sort them so that the encoded index only depends on its
+	// contents
+	sort.Slice(list, func(i, j int) bool {
+		return bytes.Compare(list[i].ID[:], list[j].ID[:]) < 0
+	})
+
 	return list, nil
 }
 
@@ -505,7 +526,8 @@ func isErrOldIndex(err error) bool {
 	return ok && e.Value == "array"
 }
 
-// DecodeIndex unserializes an index from buf.
+// DecodeIndex unserializes an index from buf. buf may contain arbitrary data,
+// an error is returned if it is not a valid index.
 func DecodeIndex(buf []byte, id restic.ID) (idx *Index, oldFormat bool, err error) {
 	debug.Log("Start decoding index")
 	idxJSON := &jsonIndex{}
@@ -528,6 +550,9 @@ func DecodeIndex(buf []byte, id restic.ID) (idx *Index, oldFormat bool, err erro
 		packID := idx.addToPacks(pack.ID)
 
 		for _, blob := range pack.Blobs {
+			if err := blob.check(); err != nil {
+				return nil, false, errors.Wrap(err, "DecodeIndex")
+			}
 			idx.store(packID, restic.Blob{
 				BlobHandle: restic.BlobHandle{
 					Type: blob.Type,
@@ -559,9 +584,15 @@ func decodeOldIndex(buf []byte) (idx *Index, err error) {
 
 	idx = NewIndex()
 	for _, pack := range list {
+		if pack == nil {
+			return nil, errors.New("Decode: pack is null")
+		}
 		packID := idx.addToPacks(pack.ID)
 
 		for _, blob := range pack.Blobs {
+			if err := blob.check(); err != nil {
+				return nil, errors.Wrap(err, "Decode")
+			}
 			idx.store(packID, restic.Blob{
 				BlobHandle: restic.BlobHandle{
 					Type: blob.Type,
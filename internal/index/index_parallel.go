@@ -18,9 +18,19 @@ func ForAllIndexes(ctx context.Context, lister restic.Lister, repo restic.Reposi
 	// as the whole index is kept in memory anyways, a few workers too much don't matter
 	workerCount := repo.Connections() + uint(runtime.GOMAXPROCS(0))
 
+	// with a memory budget, index files are only loaded while the
+	// encrypted file, the plaintext and the decoded index fit into half of
+	// the budget, the rest is left for the merged index
+	limiter := restic.NewMemoryLimiter(restic.MemoryBudget(repo) / 2)
+
 	var m sync.Mutex
 	return restic.ParallelList(ctx, lister, restic.IndexFile, workerCount, func(ctx context.Context, id restic.ID, size int64) error {
-		var err error
+		release, err := limiter.Acquire(ctx, 3*size)
+		if err != nil {
+			return err
+		}
+		defer release()
+
 		var idx *Index
 		oldFormat := false
 
@@ -16,6 +16,7 @@ import (
 // MasterIndex is a collection of indexes and IDs of chunks that are in the process of being saved.
 type MasterIndex struct {
 	idx          []*Index
+	disk         *DiskIndex
 	pendingBlobs restic.BlobSet
 	idxMutex     sync.RWMutex
 	compress     bool
@@ -35,11 +36,71 @@ func (mi *MasterIndex) MarkCompressed() {
 	mi.compress = true
 }
 
+// SetDiskIndex replaces the on-disk index which contains the entries of the
+// index files loaded from the repository. A previous on-disk index is closed.
+func (mi *MasterIndex) SetDiskIndex(d *DiskIndex) error {
+	mi.idxMutex.Lock()
+	defer mi.idxMutex.Unlock()
+
+	var err error
+	if mi.disk != nil {
+		err = mi.disk.Close()
+	}
+	mi.disk = d
+	return err
+}
+
+// Err returns the first error of reading the on-disk index. Blobs which
+// could not be looked up because of the error are reported as missing.
+func (mi *MasterIndex) Err() error {
+	mi.idxMutex.RLock()
+	defer mi.idxMutex.RUnlock()
+
+	if mi.disk == nil {
+		return nil
+	}
+	return mi.disk.Err()
+}
+
+// Close closes the on-disk index, if any.
+func (mi *MasterIndex) Close() error {
+	return mi.SetDiskIndex(nil)
+}
+
+// Reset drops the indexes loaded from the repository and closes the on-disk
+// index. Pending blobs and the indexes which were not saved yet are kept, so
+// that the index can be reloaded while blobs are saved.
+func (mi *MasterIndex) Reset() error {
+	mi.idxMutex.Lock()
+	defer mi.idxMutex.Unlock()
+
+	// the first index is the final one to merge into, see NewMasterIndex
+	first := NewIndex()
+	first.Finalize()
+	kept := []*Index{first}
+	for _, idx := range mi.idx[1:] {
+		if ids, _ := idx.IDs(); !idx.Final() || len(ids) == 0 {
+			kept = append(kept, idx)
+		}
+	}
+	mi.idx = kept
+
+	var err error
+	if mi.disk != nil {
+		err = mi.disk.Close()
+		mi.disk = nil
+	}
+	return err
+}
+
 // Lookup queries all known Indexes for the ID and returns all matches.
 func (mi *MasterIndex) Lookup(bh restic.BlobHandle) (pbs []restic.PackedBlob) {
 	mi.idxMutex.RLock()
 	defer mi.idxMutex.RUnlock()
 
+	if mi.disk != nil {
+		pbs = mi.disk.Lookup(bh, pbs)
+	}
 	for _, idx := range mi.idx {
 		pbs = idx.Lookup(bh, pbs)
 	}
@@ -57,6 +118,9 @@ func (mi *MasterIndex) LookupSize(bh restic.BlobHandle) (uint, bool) {
 			return size, found
 		}
 	}
+	if mi.disk != nil {
+		return mi.disk.LookupSize(bh)
+	}
 
 	return 0, false
 }
@@ -64,7 +128,8 @@ func (mi *MasterIndex) LookupSize(bh restic.BlobHandle) (uint, bool) {
 // AddPending adds a given blob to list of pending Blobs
 // Before doing so it checks if this blob is already known.
 // Returns true if adding was successful and false if the blob
-// was already known
+// was already known. Once reading the on-disk index failed, no blob is
+// added, as it might be known, and Err returns the error.
 func (mi *MasterIndex) AddPending(bh restic.BlobHandle) bool {
 
 	mi.idxMutex.Lock()
@@ -80,6 +145,9 @@ func (mi *MasterIndex) AddPending(bh restic.BlobHandle) bool {
 			return false
 		}
 	}
+	if mi.disk != nil && (mi.disk.Has(bh) || mi.disk.Err() != nil) {
+		return false
+	}
 
 	// really not known -> insert
 	mi.pendingBlobs.Insert(bh)
@@ -103,7 +171,7 @@ func (mi *MasterIndex) Has(bh restic.BlobHandle) bool {
 		}
 	}
 
-	return false
+	return mi.disk != nil && mi.disk.Has(bh)
 }
 
 // IDs returns the IDs of all indexes contained in the index.
@@ -112,6 +180,9 @@ func (mi *MasterIndex) IDs() restic.IDSet {
 	defer mi.idxMutex.RUnlock()
 
 	ids := restic.NewIDSet()
+	if mi.disk != nil {
+		ids.Merge(restic.NewIDSet(mi.disk.IDs()...))
+	}
 	for _, idx := range mi.idx {
 		if !idx.Final() {
 			continue
@@ -136,6 +207,9 @@ func (mi *MasterIndex) Packs(packBlacklist restic.IDSet) restic.IDSet {
 	defer mi.idxMutex.RUnlock()
 
 	packs := restic.NewIDSet()
+	if mi.disk != nil {
+		packs.Merge(mi.disk.Packs().Sub(packBlacklist))
+	}
 	for _, idx := range mi.idx {
 		idxPacks := idx.Packs()
 		if idx.final && len(packBlacklist) > 0 {
@@ -155,6 +229,14 @@ func (mi *MasterIndex) Insert(idx *Index) {
 	mi.idx = append(mi.idx, idx)
 }
 
+// DiscardPending forgets the blobs which were added to packs that will not be
+// stored, so that they can be saved again.
+func (mi *MasterIndex) DiscardPending() {
+	mi.idxMutex.Lock()
+	defer mi.idxMutex.Unlock()
+	mi.pendingBlobs = restic.NewBlobSet()
+}
+
 // StorePack remembers the id and pack in the index.
 func (mi *MasterIndex) StorePack(id restic.ID, blobs []restic.Blob) {
 	mi.idxMutex.Lock()
@@ -228,6 +310,9 @@ func (mi *MasterIndex) Each(ctx context.Context, fn func(restic.PackedBlob)) {
 	mi.idxMutex.RLock()
 	defer mi.idxMutex.RUnlock()
 
+	if mi.disk != nil {
+		mi.disk.Each(ctx, fn)
+	}
 	for _, idx := range mi.idx {
 		idx.Each(ctx, fn)
 	}
@@ -287,8 +372,39 @@ func (mi *MasterIndex) Save(ctx context.Context, repo restic.SaverUnpacked, pack
 
 	ch := make(chan *Index)
 
+	// storePacks adds the packs from results to newIndex and passes full
+	// indexes on to the workers
+	storePacks := func(results <-chan EachByPackResult) error {
+		for pbs := range results {
+			newIndex.StorePack(pbs.PackID, pbs.Blobs)
+			p.Add(1)
+			if IndexFull(newIndex, mi.compress) {
+				select {
+				case ch <- newIndex:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+				newIndex = NewIndex()
+			}
+		}
+		return ctx.Err()
+	}
+
 	wg.Go(func() error {
 		defer close(ch)
+		if mi.disk != nil {
+			ids := mi.disk.IDs()
+			debug.Log("adding ids %v of the on-disk index to supersedes field", ids)
+			if err := newIndex.AddToSupersedes(ids...); err != nil {
+				return err
+			}
+			obsolete.Merge(restic.NewIDSet(ids...))
+
+			if err := storePacks(mi.disk.EachByPack(ctx, packBlacklist)); err != nil {
+				return err
+			}
+		}
+
 		for i, idx := range mi.idx {
 			if idx.Final() {
 				ids, err := idx.IDs()
@@ -310,17 +426,8 @@ func (mi *MasterIndex) Save(ctx context.Context, repo restic.SaverUnpacked, pack
 
 			debug.Log("adding index %d", i)
 
-			for pbs := range idx.EachByPack(ctx, packBlacklist) {
-				newIndex.StorePack(pbs.PackID, pbs.Blobs)
-				p.Add(1)
-				if IndexFull(newIndex, mi.compress) {
-					select {
-					case ch <- newIndex:
-					case <-ctx.Done():
-						return ctx.Err()
-					}
-					newIndex = NewIndex()
-				}
+			if err := storePacks(idx.EachByPack(ctx, packBlacklist)); err != nil {
+				return err
 			}
 		}
 
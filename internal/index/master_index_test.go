@@ -408,3 +408,68 @@ func testIndexSave(t *testing.T, version uint) {
 		}
 	}
 }
+
+func TestRewriteAffected(t *testing.T) {
+	repo := createFilledRepo(t, 3, restic.StableRepoVersion)
+
+	indexes := restic.NewIDSet()
+	packs := restic.NewIDSet()
+	var removed restic.ID
+	rtest.OK(t, index.ForAllIndexes(context.TODO(), repo, repo, func(id restic.ID, idx *index.Index, _ bool, err error) error {
+		rtest.OK(t, err)
+		indexes.Insert(id)
+		for packID := range idx.Packs() {
+			packs.Insert(packID)
+			removed = packID
+		}
+		return nil
+	}))
+	rtest.Assert(t, len(indexes) > 1, "expected several index files, got %d", len(indexes))
+
+	obsolete, err := index.RewriteAffected(context.TODO(), repo, restic.NewIDSet(removed), nil)
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, len(obsolete))
+	for id := range obsolete {
+		rtest.OK(t, repo.Backend().Remove(context.TODO(), backend.Handle{Type: restic.IndexFile, Name: id.String()}))
+	}
+
+	// only the affected index file was replaced
+	after := restic.NewIDSet()
+	rtest.OK(t, repo.List(context.TODO(), restic.IndexFile, func(id restic.ID, _ int64) error {
+		after.Insert(id)
+		return nil
+	}))
+	rtest.Equals(t, len(indexes), len(after))
+	rtest.Equals(t, len(indexes)-1, len(indexes.Intersect(after)))
+
+	remaining := restic.NewIDSet()
+	rtest.OK(t, index.ForAllIndexes(context.TODO(), repo, repo, func(id restic.ID, idx *index.Index, _ bool, err error) error {
+		rtest.OK(t, err)
+		remaining.Merge(idx.Packs())
+		return nil
+	}))
+	packs.Delete(removed)
+	rtest.Equals(t, packs, remaining)
+}
+
+func TestMasterIndexReset(t *testing.T) {
+	loaded := restic.NewRandomBlobHandle()
+	stored := restic.NewRandomBlobHandle()
+	pending := restic.NewRandomBlobHandle()
+
+	idx := index.NewIndex()
+	idx.StorePack(restic.NewRandomID(), []restic.Blob{{BlobHandle: loaded, Length: 10}})
+	idx.Finalize()
+	rtest.OK(t, idx.SetID(restic.NewRandomID()))
+
+	mIdx := index.NewMasterIndex()
+	mIdx.Insert(idx)
+	rtest.OK(t, mIdx.MergeFinalIndexes())
+	mIdx.StorePack(restic.NewRandomID(), []restic.Blob{{BlobHandle: stored, Length: 10}})
+	rtest.Assert(t, mIdx.AddPending(pending), "blob was already known")
+
+	rtest.OK(t, mIdx.Reset())
+	rtest.Assert(t, !mIdx.Has(loaded), "loaded blob is still known after reset")
+	rtest.Assert(t, mIdx.Has(stored), "unsaved blob was dropped by reset")
+	rtest.Assert(t, mIdx.Has(pending), "pending blob was dropped by reset")
+}
@@ -0,0 +1,70 @@
+package index
+
+import (
+	"context"
+
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/restic"
+	"github.com/konidev20/rapi/ui/progress"
+)
+
+// RewriteAffected rewrites only the index files which reference a pack in
+// removePacks, leaving out these packs. All other index files are kept as is,
+// which is much faster than MasterIndex.Save for repositories with many index
+// files. The rewritten index files are returned in obsolete and must be
+// removed by the caller. The progress p counts the processed index files.
+func RewriteAffected(ctx context.Context, repo restic.Repository, removePacks restic.IDSet, p *progress.Counter) (obsolete restic.IDSet, err error) {
+	obsolete = restic.NewIDSet()
+	var affected []*Index
+
+	err = ForAllIndexes(ctx, repo, repo, func(id restic.ID, idx *Index, _ bool, err error) error {
+		p.Add(1)
+		if err != nil {
+			return err
+		}
+		for packID := range idx.Packs() {
+			if removePacks.Has(packID) {
+				affected = append(affected, idx)
+				obsolete.Insert(id)
+				return nil
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	debug.Log("rewriting %d index files", len(affected))
+	if len(affected) == 0 {
+		return obsolete, nil
+	}
+
+	compress := repo.Config().Version >= 2
+	newIndex := NewIndex()
+	for _, idx := range affected {
+		for pbs := range idx.EachByPack(ctx, removePacks) {
+			newIndex.StorePack(pbs.PackID, pbs.Blobs)
+			if IndexFull(newIndex, compress) {
+				newIndex.Finalize()
+				if _, err := SaveIndex(ctx, repo, newIndex); err != nil {
+					return nil, err
+				}
+				newIndex = NewIndex()
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	// the last index supersedes all rewritten index files
+	if err := newIndex.AddToSupersedes(obsolete.List()...); err != nil {
+		return nil, err
+	}
+	newIndex.Finalize()
+	if _, err := SaveIndex(ctx, repo, newIndex); err != nil {
+		return nil, err
+	}
+	return obsolete, nil
+}
@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"context"
+	"io"
+
+	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/backend/util"
+)
+
+// Backend records the requests sent to the wrapped backend and the number of
+// bytes transferred.
+type Backend struct {
+	backend.Backend
+	m *Metrics
+}
+
+// statically ensure that Backend implements backend.Backend.
+var _ backend.Backend = &Backend{}
+
+// NewBackend wraps be so that all requests are recorded in m.
+func NewBackend(be backend.Backend, m *Metrics) *Backend {
+	return &Backend{Backend: be, m: m}
+}
+
+// Save adds new Data to the backend.
+func (be *Backend) Save(ctx context.Context, h backend.Handle, rd backend.RewindReader) error {
+	err := be.Backend.Save(ctx, h, rd)
+	be.m.BackendRequest("save", h.Type, err)
+	if err == nil {
+		be.m.BytesSent(h.Type, rd.Length())
+	}
+	return err
+}
+
+// Remove deletes a file from the backend.
+func (be *Backend) Remove(ctx context.Context, h backend.Handle) error {
+	err := be.Backend.Remove(ctx, h)
+	be.m.BackendRequest("remove", h.Type, err)
+	return err
+}
+
+// Load runs fn with a reader that yields the contents of the file at h.
+func (be *Backend) Load(ctx context.Context, h backend.Handle, length int, offset int64, fn func(io.Reader) error) error {
+	err := be.Backend.Load(ctx, h, length, offset, func(rd io.Reader) error {
+		crd := &util.CountingReader{Reader: rd}
+		err := fn(crd)
+		be.m.BytesReceived(h.Type, crd.N)
+		return err
+	})
+	be.m.BackendRequest("load", h.Type, err)
+	return err
+}
+
+// Stat returns information about a file in the backend.
+func (be *Backend) Stat(ctx context.Context, h backend.Handle) (backend.FileInfo, error) {
+	fi, err := be.Backend.Stat(ctx, h)
+	be.m.BackendRequest("stat", h.Type, err)
+	return fi, err
+}
+
+// List runs fn for each file in the backend which has the type t.
+func (be *Backend) List(ctx context.Context, t backend.FileType, fn func(backend.FileInfo) error) error {
+	err := be.Backend.List(ctx, t, fn)
+	be.m.BackendRequest("list", t, err)
+	return err
+}
+
+func (be *Backend) Unwrap() backend.Backend { return be.Backend }
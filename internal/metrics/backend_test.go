@@ -0,0 +1,60 @@
+package metrics_test
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/backend/mem"
+	"github.com/konidev20/rapi/internal/metrics"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestBackend(t *testing.T) {
+	m := metrics.New()
+	be := metrics.NewBackend(mem.New(), m)
+
+	data := rtest.Random(23, 1234)
+	h := backend.Handle{Type: backend.PackFile, Name: "foo"}
+	rtest.OK(t, be.Save(context.TODO(), h, backend.NewByteReader(data, be.Hasher())))
+
+	err := be.Load(context.TODO(), h, 0, 0, func(rd io.Reader) error {
+		_, err := io.Copy(io.Discard, rd)
+		return err
+	})
+	rtest.OK(t, err)
+
+	_, err = be.Stat(context.TODO(), backend.Handle{Type: backend.PackFile, Name: "missing"})
+	rtest.Assert(t, err != nil, "Stat of missing file did not return an error")
+
+	reg := prometheus.NewPedanticRegistry()
+	rtest.OK(t, reg.Register(m))
+
+	families, err := reg.Gather()
+	rtest.OK(t, err)
+
+	values := make(map[string]float64)
+	for _, f := range families {
+		var sum float64
+		for _, metric := range f.GetMetric() {
+			sum += metric.GetCounter().GetValue()
+		}
+		values[f.GetName()] = sum
+	}
+
+	rtest.Equals(t, float64(3), values["restic_backend_requests_total"])
+	rtest.Equals(t, float64(1), values["restic_backend_errors_total"])
+	rtest.Equals(t, float64(2*len(data)), values["restic_backend_bytes_total"])
+}
+
+func TestRetry(t *testing.T) {
+	m := metrics.New()
+	m.Retry("save")
+	m.Retry("save")
+	m.Retry("load")
+
+	rtest.Equals(t, 2, testutil.CollectAndCount(m, "restic_backend_retries_total"))
+}
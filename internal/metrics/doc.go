@@ -0,0 +1,3 @@
+// Package metrics collects statistics about backend, cache and repository
+// operations and exposes them as Prometheus metrics.
+package metrics
@@ -0,0 +1,146 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/konidev20/rapi/backend"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "restic"
+
+// Metrics holds the counters for backend, cache and repository operations.
+// It implements prometheus.Collector, so it can be registered with the
+// registry of the embedding application.
+type Metrics struct {
+	backendRequests   *prometheus.CounterVec
+	backendErrors     *prometheus.CounterVec
+	backendBytes      *prometheus.CounterVec
+	backendRetries    *prometheus.CounterVec
+	cacheRequests     *prometheus.CounterVec
+	packWriteDuration prometheus.Histogram
+	httpConns         *prometheus.Desc
+}
+
+// statically ensure that Metrics implements prometheus.Collector.
+var _ prometheus.Collector = &Metrics{}
+
+// Default is updated by all instrumented code in this module.
+var Default = New()
+
+// New returns a new set of metrics.
+func New() *Metrics {
+	return &Metrics{
+		backendRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "backend",
+			Name:      "requests_total",
+			Help:      "Number of requests sent to the backend.",
+		}, []string{"operation", "type"}),
+		backendErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "backend",
+			Name:      "errors_total",
+			Help:      "Number of backend requests which returned an error.",
+		}, []string{"operation", "type"}),
+		backendBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "backend",
+			Name:      "bytes_total",
+			Help:      "Number of bytes transferred to (up) and from (down) the backend.",
+		}, []string{"direction", "type"}),
+		backendRetries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "backend",
+			Name:      "retries_total",
+			Help:      "Number of backend requests which were retried.",
+		}, []string{"operation"}),
+		cacheRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "cache",
+			Name:      "requests_total",
+			Help:      "Number of files requested from the local cache, by result (hit or miss).",
+		}, []string{"result", "type"}),
+		packWriteDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "repository",
+			Name:      "pack_write_duration_seconds",
+			Help:      "Time needed to upload a pack file to the backend.",
+			Buckets:   prometheus.ExponentialBuckets(0.05, 2, 12),
+		}),
+		httpConns: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "http", "connections_total"),
+			"Number of HTTP requests sent to the backend, by whether they used a new or a reused connection.",
+			[]string{"state"}, nil),
+	}
+}
+
+func (m *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.backendRequests,
+		m.backendErrors,
+		m.backendBytes,
+		m.backendRetries,
+		m.cacheRequests,
+		m.packWriteDuration,
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range m.collectors() {
+		c.Describe(ch)
+	}
+	ch <- m.httpConns
+}
+
+// Collect implements prometheus.Collector.
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+
+	// the connection counters are maintained by the HTTP transport
+	stats := backend.DefaultConnStats
+	ch <- prometheus.MustNewConstMetric(m.httpConns, prometheus.CounterValue, float64(stats.New()), "new")
+	ch <- prometheus.MustNewConstMetric(m.httpConns, prometheus.CounterValue, float64(stats.Reused()), "reused")
+}
+
+// BackendRequest records a request of type op for a file of type t, err is
+// the error returned by the backend.
+func (m *Metrics) BackendRequest(op string, t backend.FileType, err error) {
+	m.backendRequests.WithLabelValues(op, t.String()).Inc()
+	if err != nil {
+		m.backendErrors.WithLabelValues(op, t.String()).Inc()
+	}
+}
+
+// BytesSent records n bytes uploaded to the backend for a file of type t.
+func (m *Metrics) BytesSent(t backend.FileType, n int64) {
+	m.backendBytes.WithLabelValues("up", t.String()).Add(float64(n))
+}
+
+// BytesReceived records n bytes downloaded from the backend for a file of type t.
+func (m *Metrics) BytesReceived(t backend.FileType, n int64) {
+	m.backendBytes.WithLabelValues("down", t.String()).Add(float64(n))
+}
+
+// Retry records that a backend request of type op is retried.
+func (m *Metrics) Retry(op string) {
+	m.backendRetries.WithLabelValues(op).Inc()
+}
+
+// CacheHit records that a file of type t was served from the local cache.
+func (m *Metrics) CacheHit(t backend.FileType) {
+	m.cacheRequests.WithLabelValues("hit", t.String()).Inc()
+}
+
+// CacheMiss records that a file of type t had to be loaded from the backend.
+func (m *Metrics) CacheMiss(t backend.FileType) {
+	m.cacheRequests.WithLabelValues("miss", t.String()).Inc()
+}
+
+// PackWritten records the time needed to upload a pack file.
+func (m *Metrics) PackWritten(d time.Duration) {
+	m.packWriteDuration.Observe(d.Seconds())
+}
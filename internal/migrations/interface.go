@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/konidev20/rapi/restic"
+	"github.com/konidev20/rapi/ui/progress"
 )
 
 // Migration implements a data migration.
@@ -22,3 +23,13 @@ type Migration interface {
 	// Descr returns a description what the migration does.
 	Desc() string
 }
+
+// ProgressMigration is implemented by migrations which can report their
+// progress while they are applied.
+type ProgressMigration interface {
+	Migration
+
+	// ApplyWithProgress runs the migration and adds the number of processed
+	// items to p.
+	ApplyWithProgress(context.Context, restic.Repository, *progress.Counter) error
+}
@@ -1,8 +1,30 @@
 package migrations
 
+import "fmt"
+
 // All contains all migrations.
 var All []Migration
 
 func register(m Migration) {
 	All = append(All, m)
 }
+
+// Register adds a custom migration. The name of the migration must be unique.
+func Register(m Migration) error {
+	if Find(m.Name()) != nil {
+		return fmt.Errorf("migration %q is already registered", m.Name())
+	}
+
+	register(m)
+	return nil
+}
+
+// Find returns the migration with the given name, or nil if there is none.
+func Find(name string) Migration {
+	for _, m := range All {
+		if m.Name() == name {
+			return m
+		}
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+package migrations
+
+import "testing"
+
+func TestRegister(t *testing.T) {
+	if Find("upgrade_repo_v2") == nil {
+		t.Fatal("built-in migration not found")
+	}
+
+	if err := Register(&UpgradeRepoV2{}); err == nil {
+		t.Fatal("registering a duplicate migration did not fail")
+	}
+
+	if Find("does_not_exist") != nil {
+		t.Fatal("unknown migration found")
+	}
+}
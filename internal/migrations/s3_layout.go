@@ -2,8 +2,6 @@ package migrations
 
 import (
 	"context"
-	"fmt"
-	"os"
 	"path"
 
 	"github.com/konidev20/rapi/backend"
@@ -12,6 +10,7 @@ import (
 	"github.com/konidev20/rapi/internal/debug"
 	"github.com/konidev20/rapi/internal/errors"
 	"github.com/konidev20/rapi/restic"
+	"github.com/konidev20/rapi/ui/progress"
 )
 
 func init() {
@@ -59,23 +58,33 @@ func retry(max int, fail func(err error), f func() error) error {
 // maxErrors for retrying renames on s3.
 const maxErrors = 20
 
-func (m *S3Layout) moveFiles(ctx context.Context, be *s3.Backend, l layout.Layout, t restic.FileType) error {
-	printErr := func(err error) {
-		fmt.Fprintf(os.Stderr, "renaming file returned error: %v\n", err)
-	}
-
+func (m *S3Layout) moveFiles(ctx context.Context, be *s3.Backend, l layout.Layout, t restic.FileType, p *progress.Counter) error {
 	return be.List(ctx, t, func(fi backend.FileInfo) error {
 		h := backend.Handle{Type: t, Name: fi.Name}
 		debug.Log("move %v", h)
 
-		return retry(maxErrors, printErr, func() error {
+		printErr := func(err error) {
+			restic.Warn(ctx, h.Name, errors.Wrap(err, "rename"))
+		}
+
+		err := retry(maxErrors, printErr, func() error {
 			return be.Rename(ctx, h, l)
 		})
+		if err == nil {
+			p.Add(1)
+		}
+		return err
 	})
 }
 
 // Apply runs the migration.
 func (m *S3Layout) Apply(ctx context.Context, repo restic.Repository) error {
+	return m.ApplyWithProgress(ctx, repo, nil)
+}
+
+// ApplyWithProgress runs the migration and reports the number of moved files
+// to p.
+func (m *S3Layout) ApplyWithProgress(ctx context.Context, repo restic.Repository, p *progress.Counter) error {
 	be := backend.AsBackend[*s3.Backend](repo.Backend())
 	if be == nil {
 		debug.Log("backend is not s3")
@@ -100,7 +109,7 @@ func (m *S3Layout) Apply(ctx context.Context, repo restic.Repository) error {
 		restic.KeyFile,
 		restic.LockFile,
 	} {
-		err := m.moveFiles(ctx, be, newLayout, t)
+		err := m.moveFiles(ctx, be, newLayout, t, p)
 		if err != nil {
 			return err
 		}
@@ -13,6 +13,7 @@ import (
 	"github.com/konidev20/rapi/repository"
 	"github.com/konidev20/rapi/restic"
 	"github.com/konidev20/rapi/ui/restore"
+	"github.com/restic/chunker"
 )
 
 // TODO if a blob is corrupt, there may be good blob copies in other packs
@@ -22,6 +23,11 @@ import (
 
 const (
 	largeFileBlobCount = 25
+
+	// restoreWorkerMemory is the memory used by a worker for the blob it
+	// currently processes, the encrypted and the decrypted data of a blob
+	// of maximal size.
+	restoreWorkerMemory = 2 * chunker.MaxSize
 )
 
 // information about regular file being restored
@@ -48,6 +54,7 @@ type packInfo struct {
 // fileRestorer restores set of files
 type fileRestorer struct {
 	key        *crypto.Key
+	hash       restic.HashAlgorithm
 	idx        func(restic.BlobHandle) []restic.PackedBlob
 	packLoader repository.BackendLoadFn
 
@@ -65,20 +72,30 @@ type fileRestorer struct {
 func newFileRestorer(dst string,
 	packLoader repository.BackendLoadFn,
 	key *crypto.Key,
+	hash restic.HashAlgorithm,
 	idx func(restic.BlobHandle) []restic.PackedBlob,
 	connections uint,
+	memoryBudget uint64,
 	sparse bool,
 	progress *restore.Progress) *fileRestorer {
 
 	// as packs are streamed the concurrency is limited by IO
-	workerCount := int(connections)
+	// with a memory budget, half of it is used by the workers, the rest is
+	// left for the index
+	workerCount := restic.NewMemoryLimiter(memoryBudget/2).Workers(restoreWorkerMemory, int(connections))
+
+	zeroChunk := repository.ZeroChunk()
+	if hash != restic.SHA256 {
+		zeroChunk = hash.Sum(make([]byte, chunker.MinSize))
+	}
 
 	return &fileRestorer{
 		key:         key,
+		hash:        hash,
 		idx:         idx,
 		packLoader:  packLoader,
 		filesWriter: newFilesWriter(workerCount),
-		zeroChunk:   repository.ZeroChunk(),
+		zeroChunk:   zeroChunk,
 		sparse:      sparse,
 		progress:    progress,
 		workerCount: workerCount,
@@ -246,7 +263,7 @@ func (r *fileRestorer) downloadPack(ctx context.Context, pack *packInfo) error {
 		return err
 	}
 
-	err := repository.StreamPack(ctx, r.packLoader, r.key, pack.id, blobList, func(h restic.BlobHandle, blobData []byte, err error) error {
+	err := repository.StreamPackWithHash(ctx, r.packLoader, r.key, r.hash, pack.id, blobList, func(h restic.BlobHandle, blobData []byte, err error) error {
 		blob := blobs[h.ID]
 		if err != nil {
 			for file := range blob.files {
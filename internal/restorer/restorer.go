@@ -25,6 +25,11 @@ type Restorer struct {
 
 	Error        func(location string, err error) error
 	SelectFilter func(item string, dstpath string, node *restic.Node) (selectedForRestore bool, childMayBeSelected bool)
+
+	// SpecialFiles configures whether devices, FIFOs and sockets are
+	// created, skipped or reported as errors. By default, device nodes are
+	// only created if the process has the required privileges.
+	SpecialFiles restic.SpecialFilePolicy
 }
 
 var restorerAbortOnAllErrors = func(location string, err error) error { return err }
@@ -163,6 +168,10 @@ func (res *Restorer) traverseTree(ctx context.Context, target, location string,
 func (res *Restorer) restoreNodeTo(ctx context.Context, node *restic.Node, target, location string) error {
 	debug.Log("restoreNode %v %v %v", node.Name, target, location)
 
+	if restic.IsSpecialFile(node.Type) {
+		return res.restoreSpecialFileTo(ctx, node, target, location)
+	}
+
 	err := node.CreateAt(ctx, target, res.repo)
 	if err != nil {
 		debug.Log("node.CreateAt(%s) error %v", target, err)
@@ -176,6 +185,50 @@ func (res *Restorer) restoreNodeTo(ctx context.Context, node *restic.Node, targe
 	return res.restoreNodeMetadataTo(node, target, location)
 }
 
+// restoreSpecialFileTo creates a device, FIFO or socket according to
+// res.SpecialFiles.
+func (res *Restorer) restoreSpecialFileTo(ctx context.Context, node *restic.Node, target, location string) error {
+	isDevice := node.Type == "dev" || node.Type == "chardev"
+
+	action := res.SpecialFiles.Action(node.Type)
+	if action == restic.SpecialFileDefault {
+		action = restic.SpecialFileRecord
+		if isDevice && !fs.CanCreateDevices() {
+			action = restic.SpecialFileSkip
+		}
+	}
+	if node.Type == "socket" && action == restic.SpecialFileRecord {
+		// sockets are created by the programs using them
+		action = restic.SpecialFileSkip
+	}
+
+	switch action {
+	case restic.SpecialFileSkip:
+		debug.Log("skipping %v %v", node.Type, location)
+		if res.progress != nil {
+			res.progress.AddProgress(location, 0, 0)
+		}
+		return nil
+	case restic.SpecialFileError:
+		return errors.Errorf("%v is a %v, refusing to restore", location, node.Type)
+	}
+
+	err := node.CreateAt(ctx, target, res.repo)
+	if err != nil {
+		debug.Log("node.CreateAt(%s) error %v", target, err)
+		if isDevice && errors.Is(err, os.ErrPermission) {
+			err = errors.Errorf("creating device %v requires root privileges: %v", location, err)
+		}
+		return err
+	}
+
+	if res.progress != nil {
+		res.progress.AddProgress(location, 0, 0)
+	}
+
+	return res.restoreNodeMetadataTo(node, target, location)
+}
+
 func (res *Restorer) restoreNodeMetadataTo(node *restic.Node, target, location string) error {
 	debug.Log("restoreNodeMetadata %v %v %v", node.Name, target, location)
 	err := node.RestoreMetadata(target)
@@ -231,8 +284,8 @@ func (res *Restorer) RestoreTo(ctx context.Context, dst string) error {
 	}
 
 	idx := NewHardlinkIndex[string]()
-	filerestorer := newFileRestorer(dst, res.repo.Backend().Load, res.repo.Key(), res.repo.Index().Lookup,
-		res.repo.Connections(), res.sparse, res.progress)
+	filerestorer := newFileRestorer(dst, res.repo.Backend().Load, res.repo.Key(), restic.RepositoryHashAlgorithm(res.repo), res.repo.Index().Lookup,
+		res.repo.Connections(), restic.MemoryBudget(res.repo), res.sparse, res.progress)
 	filerestorer.Error = res.Error
 
 	debug.Log("first pass for %q", dst)
@@ -440,7 +493,7 @@ func (res *Restorer) verifyFile(target string, node *restic.Node, buf []byte) ([
 		if err != nil {
 			return buf, err
 		}
-		if !blobID.Equal(restic.Hash(buf)) {
+		if !blobID.Equal(restic.RepositoryHashAlgorithm(res.repo).Sum(buf)) {
 			return buf, errors.Errorf(
 				"Unexpected content in %s, starting at offset %d",
 				target, offset)
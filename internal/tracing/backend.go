@@ -0,0 +1,73 @@
+package tracing
+
+import (
+	"context"
+	"io"
+
+	"github.com/konidev20/rapi/backend"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Backend creates a span for each request sent to the wrapped backend.
+type Backend struct {
+	backend.Backend
+}
+
+// statically ensure that Backend implements backend.Backend.
+var _ backend.Backend = &Backend{}
+
+// NewBackend wraps be so that all requests are traced.
+func NewBackend(be backend.Backend) *Backend {
+	return &Backend{Backend: be}
+}
+
+func handleAttrs(h backend.Handle) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("restic.file.type", h.Type.String()),
+		attribute.String("restic.file.name", h.Name),
+	}
+}
+
+// Save adds new Data to the backend.
+func (be *Backend) Save(ctx context.Context, h backend.Handle, rd backend.RewindReader) (err error) {
+	ctx, span := Start(ctx, "backend.Save", append(handleAttrs(h), attribute.Int64("restic.file.size", rd.Length()))...)
+	defer func() { End(span, err) }()
+
+	return be.Backend.Save(ctx, h, rd)
+}
+
+// Remove deletes a file from the backend.
+func (be *Backend) Remove(ctx context.Context, h backend.Handle) (err error) {
+	ctx, span := Start(ctx, "backend.Remove", handleAttrs(h)...)
+	defer func() { End(span, err) }()
+
+	return be.Backend.Remove(ctx, h)
+}
+
+// Load runs fn with a reader that yields the contents of the file at h.
+func (be *Backend) Load(ctx context.Context, h backend.Handle, length int, offset int64, fn func(io.Reader) error) (err error) {
+	ctx, span := Start(ctx, "backend.Load", append(handleAttrs(h),
+		attribute.Int("restic.load.length", length),
+		attribute.Int64("restic.load.offset", offset))...)
+	defer func() { End(span, err) }()
+
+	return be.Backend.Load(ctx, h, length, offset, fn)
+}
+
+// Stat returns information about a file in the backend.
+func (be *Backend) Stat(ctx context.Context, h backend.Handle) (fi backend.FileInfo, err error) {
+	ctx, span := Start(ctx, "backend.Stat", handleAttrs(h)...)
+	defer func() { End(span, err) }()
+
+	return be.Backend.Stat(ctx, h)
+}
+
+// List runs fn for each file in the backend which has the type t.
+func (be *Backend) List(ctx context.Context, t backend.FileType, fn func(backend.FileInfo) error) (err error) {
+	ctx, span := Start(ctx, "backend.List", attribute.String("restic.file.type", t.String()))
+	defer func() { End(span, err) }()
+
+	return be.Backend.List(ctx, t, fn)
+}
+
+func (be *Backend) Unwrap() backend.Backend { return be.Backend }
@@ -0,0 +1,4 @@
+// Package tracing creates OpenTelemetry spans for backend, repository and
+// archiver operations. Spans are recorded by the global TracerProvider
+// configured by the embedding application, without one they are discarded.
+package tracing
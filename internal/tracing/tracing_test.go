@@ -0,0 +1,45 @@
+package tracing_test
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/backend/mem"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestBackendSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	old := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(old)
+
+	ctx, parent := tracing.Start(context.TODO(), "test")
+	be := tracing.NewBackend(mem.New())
+
+	h := backend.Handle{Type: backend.IndexFile, Name: "foo"}
+	rtest.OK(t, be.Save(ctx, h, backend.NewByteReader([]byte("foobar"), be.Hasher())))
+	err := be.Load(ctx, backend.Handle{Type: backend.IndexFile, Name: "missing"}, 0, 0, func(rd io.Reader) error {
+		return nil
+	})
+	rtest.Assert(t, err != nil, "Load of missing file did not return an error")
+	tracing.End(parent, nil)
+
+	spans := recorder.Ended()
+	rtest.Equals(t, 3, len(spans))
+
+	rtest.Equals(t, "backend.Save", spans[0].Name())
+	rtest.Equals(t, parent.SpanContext().SpanID(), spans[0].Parent().SpanID())
+	rtest.Equals(t, codes.Unset, spans[0].Status().Code)
+
+	rtest.Equals(t, "backend.Load", spans[1].Name())
+	rtest.Equals(t, codes.Error, spans[1].Status().Code)
+}
@@ -0,0 +1,51 @@
+// Package keychain stores passwords in the credential store of the operating
+// system: the Keychain on macOS, the Credential Manager on Windows and a
+// Secret Service such as GNOME Keyring or KWallet on Linux and BSD, which is
+// accessed with the secret-tool program of libsecret.
+package keychain
+
+import (
+	"context"
+
+	"github.com/konidev20/rapi/internal/errors"
+)
+
+var (
+	// ErrNotFound is returned if no password is stored for an account.
+	ErrNotFound = errors.New("password not found in keychain")
+
+	// ErrUnsupported is returned if the credential store of the operating
+	// system cannot be used.
+	ErrUnsupported = errors.New("keychain not supported")
+)
+
+// Keychain reads and stores the passwords of a service, each password is
+// identified by the name of an account, for example the location of a
+// repository. It is safe for concurrent use.
+type Keychain struct {
+	Service string
+}
+
+// New returns the keychain of service.
+func New(service string) *Keychain {
+	return &Keychain{Service: service}
+}
+
+// Password returns the password stored for account, or ErrNotFound.
+func (k *Keychain) Password(ctx context.Context, account string) (string, error) {
+	pw, err := get(ctx, k.Service, account)
+	if err != nil {
+		return "", errors.Wrapf(err, "read password of %v from keychain", account)
+	}
+	return pw, nil
+}
+
+// SetPassword stores password for account, it replaces an existing one.
+func (k *Keychain) SetPassword(ctx context.Context, account, password string) error {
+	return errors.Wrapf(set(ctx, k.Service, account, password), "store password of %v in keychain", account)
+}
+
+// DeletePassword removes the password of account, or returns ErrNotFound.
+func (k *Keychain) DeletePassword(ctx context.Context, account string) error {
+	return errors.Wrapf(remove(ctx, k.Service, account), "remove password of %v from keychain", account)
+}
@@ -0,0 +1,84 @@
+package keychain
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/konidev20/rapi/internal/errors"
+)
+
+// errSecItemNotFound is the exit code of security if the item does not exist.
+const errSecItemNotFound = 44
+
+// security runs the security program. The arguments are passed on stdin in
+// interactive mode, so that passwords do not show up in the process list.
+func security(ctx context.Context, args ...string) (string, error) {
+	var line strings.Builder
+	for i, arg := range args {
+		if i > 0 {
+			line.WriteByte(' ')
+		}
+		fmt.Fprintf(&line, "%q", arg)
+	}
+	line.WriteByte('\n')
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "security", "-i")
+	cmd.Stdin = strings.NewReader(line.String())
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == errSecItemNotFound {
+		return "", ErrNotFound
+	}
+	if errors.Is(err, exec.ErrNotFound) {
+		return "", errors.Wrap(ErrUnsupported, err.Error())
+	}
+	if err != nil {
+		return "", errors.Errorf("security: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	// security -i does not set its exit code for failed commands
+	if msg := strings.TrimSpace(stderr.String()); strings.Contains(msg, "could not be found") {
+		return "", ErrNotFound
+	} else if msg != "" {
+		return "", errors.Errorf("security: %s", msg)
+	}
+	return strings.TrimRight(stdout.String(), "\n"), nil
+}
+
+func get(ctx context.Context, service, account string) (string, error) {
+	out, err := security(ctx, "find-generic-password", "-s", service, "-a", account, "-w")
+	if err != nil {
+		return "", err
+	}
+	// passwords which are not printable ASCII are printed hex encoded
+	if buf, err := hex.DecodeString(out); err == nil && !isPrintable(buf) {
+		return string(buf), nil
+	}
+	return out, nil
+}
+
+func isPrintable(buf []byte) bool {
+	for _, b := range buf {
+		if b < 0x20 || b >= 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+func set(ctx context.Context, service, account, password string) error {
+	_, err := security(ctx, "add-generic-password", "-U", "-s", service, "-a", account, "-X", hex.EncodeToString([]byte(password)))
+	return err
+}
+
+func remove(ctx context.Context, service, account string) error {
+	_, err := security(ctx, "delete-generic-password", "-s", service, "-a", account)
+	return err
+}
@@ -0,0 +1,18 @@
+//go:build !darwin && !windows && !linux && !freebsd && !openbsd && !netbsd && !dragonfly
+// +build !darwin,!windows,!linux,!freebsd,!openbsd,!netbsd,!dragonfly
+
+package keychain
+
+import "context"
+
+func get(context.Context, string, string) (string, error) {
+	return "", ErrUnsupported
+}
+
+func set(context.Context, string, string, string) error {
+	return ErrUnsupported
+}
+
+func remove(context.Context, string, string) error {
+	return ErrUnsupported
+}
@@ -0,0 +1,63 @@
+//go:build linux || freebsd || openbsd || netbsd || dragonfly
+// +build linux freebsd openbsd netbsd dragonfly
+
+package keychain
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/konidev20/rapi/internal/errors"
+)
+
+// secretTool is the program which accesses the Secret Service.
+var secretTool = "secret-tool"
+
+// run runs secret-tool with args, stdin is passed to it.
+func run(ctx context.Context, stdin io.Reader, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, secretTool, args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+
+	if errors.Is(err, exec.ErrNotFound) {
+		return "", errors.Wrap(ErrUnsupported, err.Error())
+	}
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			// secret-tool fails without a message if nothing was found
+			return "", ErrNotFound
+		}
+		return "", errors.Errorf("%v: %v: %s", secretTool, err, msg)
+	}
+	return stdout.String(), nil
+}
+
+func attributes(service, account string) []string {
+	return []string{"service", service, "account", account}
+}
+
+func get(ctx context.Context, service, account string) (string, error) {
+	return run(ctx, nil, append([]string{"lookup"}, attributes(service, account)...)...)
+}
+
+func set(ctx context.Context, service, account, password string) error {
+	args := append([]string{"store", "--label=" + service + " " + account}, attributes(service, account)...)
+	_, err := run(ctx, strings.NewReader(password), args...)
+	return err
+}
+
+func remove(ctx context.Context, service, account string) error {
+	// clear succeeds if nothing was found, so check first
+	if _, err := get(ctx, service, account); err != nil {
+		return err
+	}
+	_, err := run(ctx, nil, append([]string{"clear"}, attributes(service, account)...)...)
+	return err
+}
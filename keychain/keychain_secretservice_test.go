@@ -0,0 +1,54 @@
+//go:build linux || freebsd || openbsd || netbsd || dragonfly
+// +build linux freebsd openbsd netbsd dragonfly
+
+package keychain_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/keychain"
+)
+
+// fakeSecretTool emulates secret-tool, the passwords are stored in files
+// named after the attributes.
+const fakeSecretTool = `#!/bin/sh
+cmd=$1
+shift
+case "$cmd" in
+store) shift ;;
+esac
+file="$SECRET_STORE/$(echo "$@" | tr ' /:' '___')"
+case "$cmd" in
+lookup) [ -f "$file" ] || exit 1; cat "$file" ;;
+store) cat > "$file" ;;
+clear) rm -f "$file" ;;
+esac
+`
+
+func TestSecretService(t *testing.T) {
+	dir := rtest.TempDir(t)
+	rtest.OK(t, os.WriteFile(filepath.Join(dir, "secret-tool"), []byte(fakeSecretTool), 0755))
+	store := filepath.Join(dir, "store")
+	rtest.OK(t, os.Mkdir(store, 0700))
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("SECRET_STORE", store)
+
+	ctx := context.Background()
+	k := keychain.New("restic")
+	_, err := k.Password(ctx, "/srv/repo")
+	rtest.Assert(t, errors.Is(err, keychain.ErrNotFound), "unexpected error %v", err)
+
+	rtest.OK(t, k.SetPassword(ctx, "/srv/repo", "secret password\n"))
+	pw, err := k.Password(ctx, "/srv/repo")
+	rtest.OK(t, err)
+	rtest.Equals(t, "secret password\n", pw)
+
+	rtest.OK(t, k.DeletePassword(ctx, "/srv/repo"))
+	err = k.DeletePassword(ctx, "/srv/repo")
+	rtest.Assert(t, errors.Is(err, keychain.ErrNotFound), "unexpected error %v", err)
+}
@@ -0,0 +1,55 @@
+package rapi
+
+import (
+	"context"
+
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/restic"
+)
+
+// lockRepository locks repo, exclusively if exclusive is set, and returns a
+// function which removes the lock again. A read-only repository cannot store
+// a lock file, so it is not locked. Concurrent modifications by other
+// processes are not detected in that case. The handles of a Pool can only be
+// locked exclusively in Pool.Maintain.
+func lockRepository(ctx context.Context, repo restic.Repository, exclusive bool) (unlock func(), err error) {
+	if s, ok := repo.(*BackupSession); ok {
+		return s.lock(ctx, exclusive)
+	}
+	if exclusive {
+		if err := checkPoolMaintenance(repo); err != nil {
+			return nil, err
+		}
+	}
+
+	if ro, ok := repo.(interface{ IsReadOnly() bool }); ok && ro.IsReadOnly() {
+		debug.Log("repository is read-only, not locking it")
+		return func() {}, nil
+	}
+
+	var lock *restic.Lock
+	if exclusive {
+		lock, err = restic.NewExclusiveLock(ctx, repo)
+	} else {
+		lock, err = restic.NewLock(ctx, repo)
+	}
+	if err != nil {
+		return nil, classifyLockError(err)
+	}
+
+	return func() {
+		if err := lock.Unlock(); err != nil {
+			debug.Log("unable to remove lock: %v", err)
+		}
+	}, nil
+}
+
+// LockRepository locks repo like the operations of this package, for
+// operations which are implemented elsewhere, such as the RPC server. It
+// returns a function which removes the lock again. A read-only repository is
+// not locked, a BackupSession locks all its repositories and the handles of
+// a Pool can only be locked exclusively in Pool.Maintain. If the repository
+// is locked by another process, the error matches ErrLocked.
+func LockRepository(ctx context.Context, repo restic.Repository, exclusive bool) (unlock func(), err error) {
+	return lockRepository(ctx, repo, exclusive)
+}
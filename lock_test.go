@@ -0,0 +1,44 @@
+package rapi_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/konidev20/rapi"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/restic"
+)
+
+func TestReadOnlyRepository(t *testing.T) {
+	ctx := context.Background()
+	be := repository.TestBackend(t)
+	repo := repository.TestRepositoryWithBackend(t, be, 2)
+	sn := restic.TestCreateSnapshot(t, repo, time.Now(), 2)
+
+	ro, err := repository.New(be, repository.Options{ReadOnly: true})
+	rtest.OK(t, err)
+	rtest.OK(t, ro.SearchKey(ctx, rtest.TestPassword, 1, ""))
+
+	res, err := rapi.Check(ctx, ro, rapi.CheckOptions{})
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, len(res.Errors))
+
+	_, err = rapi.Analyze(ctx, ro)
+	rtest.OK(t, err)
+
+	var buf bytes.Buffer
+	rtest.OK(t, rapi.Export(ctx, ro, *sn.ID(), &buf, "secret"))
+	rtest.Assert(t, buf.Len() > 0, "empty bundle")
+
+	// modifications are still rejected
+	_, err = rapi.AddTags(ctx, ro, *sn.ID(), []string{"foo"})
+	rtest.Assert(t, err != nil, "tags of read-only repository changed")
+
+	rtest.OK(t, ro.List(ctx, restic.LockFile, func(id restic.ID, _ int64) error {
+		t.Errorf("unexpected lock %v", id.Str())
+		return nil
+	}))
+}
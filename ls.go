@@ -0,0 +1,233 @@
+package rapi
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/internal/filter"
+	"github.com/konidev20/rapi/restic"
+)
+
+// LsSortOrder defines the order of the entries within a directory.
+type LsSortOrder int
+
+// Sort orders for Ls.
+const (
+	LsSortByName LsSortOrder = iota
+	LsSortBySize
+	LsSortByMtime
+	LsSortByAtime
+)
+
+// LsOptions configure which entries Ls returns.
+type LsOptions struct {
+	// Path is the directory within the snapshot which is listed, the root
+	// directory is used if it is empty.
+	Path string
+
+	// Recursive lists the contents of all subdirectories.
+	Recursive bool
+
+	// Include and Exclude are patterns matched against the path of each
+	// entry, using the same syntax as for backup excludes. If Include is
+	// not empty, only matching entries are returned. Excluded directories
+	// are not descended into.
+	Include []string
+	Exclude []string
+
+	// SortBy defines the order of the entries within each directory, Reverse
+	// reverses that order. The contents of a subdirectory always directly
+	// follow the subdirectory itself.
+	SortBy  LsSortOrder
+	Reverse bool
+}
+
+// LsEntry is a single entry returned by Ls.
+type LsEntry struct {
+	// Path is the absolute path of the entry within the snapshot.
+	Path string
+	Node *restic.Node
+}
+
+// Ls calls fn for the entries of the directory opts.Path in the snapshot id.
+// The directories are loaded one at a time, so that huge trees can be listed
+// with little memory. If fn returns an error, Ls stops and returns it.
+func Ls(ctx context.Context, repo restic.Repository, id restic.ID, opts LsOptions, fn func(LsEntry) error) error {
+	sn, err := restic.LoadSnapshot(ctx, repo, id)
+	if err != nil {
+		return err
+	}
+	return lsSnapshot(ctx, repo, sn, opts, fn)
+}
+
+func lsSnapshot(ctx context.Context, repo restic.Repository, sn *restic.Snapshot, opts LsOptions, fn func(LsEntry) error) error {
+	if err := filter.ValidatePatterns(opts.Include); err != nil {
+		return errors.Fatalf("invalid include pattern: %s", err)
+	}
+	if err := filter.ValidatePatterns(opts.Exclude); err != nil {
+		return errors.Fatalf("invalid exclude pattern: %s", err)
+	}
+
+	dir := path.Clean("/" + opts.Path)
+	treeID, err := restic.FindTreeDirectory(ctx, repo, sn.Tree, dir)
+	if err != nil {
+		return err
+	}
+
+	l := &lister{
+		repo:    repo,
+		opts:    opts,
+		include: filter.ParsePatterns(opts.Include),
+		exclude: filter.ParsePatterns(opts.Exclude),
+		fn:      fn,
+	}
+	return l.listTree(ctx, dir, *treeID)
+}
+
+type lister struct {
+	repo             restic.Repository
+	opts             LsOptions
+	include, exclude []filter.Pattern
+	fn               func(LsEntry) error
+}
+
+func (l *lister) listTree(ctx context.Context, dir string, id restic.ID) error {
+	tree, err := restic.LoadTree(ctx, l.repo, id)
+	if err != nil {
+		return err
+	}
+
+	nodes := tree.Nodes
+	sortNodes(nodes, l.opts.SortBy, l.opts.Reverse)
+
+	for _, node := range nodes {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		p := path.Join(dir, node.Name)
+		show, descend, err := l.match(p)
+		if err != nil {
+			return err
+		}
+
+		if show {
+			if err := l.fn(LsEntry{Path: p, Node: node}); err != nil {
+				return err
+			}
+		}
+
+		if descend && l.opts.Recursive && node.Type == "dir" && node.Subtree != nil {
+			if err := l.listTree(ctx, p, *node.Subtree); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// match returns whether the entry at p is returned and whether its contents
+// may contain matching entries.
+func (l *lister) match(p string) (show, descend bool, err error) {
+	excluded, err := filter.List(l.exclude, p)
+	if err != nil {
+		return false, false, err
+	}
+	if excluded {
+		return false, false, nil
+	}
+
+	if len(l.include) == 0 {
+		return true, true, nil
+	}
+
+	included, childMayMatch, err := filter.ListWithChild(l.include, p)
+	if err != nil {
+		return false, false, err
+	}
+	return included, included || childMayMatch, nil
+}
+
+func sortNodes(nodes []*restic.Node, order LsSortOrder, reverse bool) {
+	var less func(a, b *restic.Node) bool
+	switch order {
+	case LsSortBySize:
+		less = func(a, b *restic.Node) bool { return a.Size < b.Size }
+	case LsSortByMtime:
+		less = func(a, b *restic.Node) bool { return a.ModTime.Before(b.ModTime) }
+	case LsSortByAtime:
+		less = func(a, b *restic.Node) bool { return a.AccessTime.Before(b.AccessTime) }
+	default:
+		// trees are already sorted by name
+		if reverse {
+			for i, j := 0, len(nodes)-1; i < j; i, j = i+1, j-1 {
+				nodes[i], nodes[j] = nodes[j], nodes[i]
+			}
+		}
+		return
+	}
+
+	sort.SliceStable(nodes, func(i, j int) bool {
+		if reverse {
+			return less(nodes[j], nodes[i])
+		}
+		return less(nodes[i], nodes[j])
+	})
+}
+
+// LsEncoder writes entries returned by Ls as newline-delimited JSON, in the
+// format used by `restic ls --json`.
+type LsEncoder struct {
+	enc *json.Encoder
+}
+
+// NewLsEncoder returns a new encoder writing to wr.
+func NewLsEncoder(wr io.Writer) *LsEncoder {
+	return &LsEncoder{enc: json.NewEncoder(wr)}
+}
+
+type lsNode struct {
+	Name        string      `json:"name"`
+	Type        string      `json:"type"`
+	Path        string      `json:"path"`
+	UID         uint32      `json:"uid"`
+	GID         uint32      `json:"gid"`
+	Size        *uint64     `json:"size,omitempty"`
+	Mode        os.FileMode `json:"mode,omitempty"`
+	Permissions string      `json:"permissions,omitempty"`
+	ModTime     time.Time   `json:"mtime,omitempty"`
+	AccessTime  time.Time   `json:"atime,omitempty"`
+	ChangeTime  time.Time   `json:"ctime,omitempty"`
+	StructType  string      `json:"struct_type"` // "node"
+}
+
+// Encode writes the entry as a single line. It can be passed to Ls directly.
+func (e *LsEncoder) Encode(entry LsEntry) error {
+	node := entry.Node
+	n := lsNode{
+		Name:        node.Name,
+		Type:        node.Type,
+		Path:        entry.Path,
+		UID:         node.UID,
+		GID:         node.GID,
+		Mode:        node.Mode,
+		Permissions: node.Mode.String(),
+		ModTime:     node.ModTime,
+		AccessTime:  node.AccessTime,
+		ChangeTime:  node.ChangeTime,
+		StructType:  "node",
+	}
+	// only report the size of regular files
+	if node.Type == "file" {
+		n.Size = &node.Size
+	}
+
+	return e.enc.Encode(n)
+}
@@ -0,0 +1,160 @@
+package rapi_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/konidev20/rapi"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/restic"
+	"golang.org/x/sync/errgroup"
+)
+
+// testDir describes the contents of a directory in a test snapshot. Values
+// are either a string with the file content, a []string with the content of
+// each blob of a file or a nested testDir.
+type testDir map[string]interface{}
+
+// saveTestSnapshot stores the directory tree dir as a new snapshot and
+// returns its ID. The content of each file is stored in a single data blob.
+func saveTestSnapshot(t testing.TB, repo restic.Repository, dir testDir) restic.ID {
+	ctx := context.Background()
+	var wg errgroup.Group
+	repo.StartPackUploader(ctx, &wg)
+
+	treeID := saveTestTree(t, repo, dir)
+	rtest.OK(t, repo.Flush(ctx))
+	rtest.OK(t, wg.Wait())
+
+	sn, err := restic.NewSnapshot([]string{"/"}, nil, "test", time.Now())
+	rtest.OK(t, err)
+	sn.Tree = &treeID
+	id, err := restic.SaveSnapshot(ctx, repo, sn)
+	rtest.OK(t, err)
+	return id
+}
+
+func saveTestTree(t testing.TB, repo restic.Repository, dir testDir) restic.ID {
+	ctx := context.Background()
+	tree := restic.NewTree(len(dir))
+	for name, item := range dir {
+		node := &restic.Node{Name: name, Mode: 0644, ModTime: time.Now()}
+		switch item := item.(type) {
+		case string:
+			node.Type = "file"
+			node.Size = uint64(len(item))
+			if len(item) > 0 {
+				id, _, _, err := repo.SaveBlob(ctx, restic.DataBlob, []byte(item), restic.ID{}, false)
+				rtest.OK(t, err)
+				node.Content = restic.IDs{id}
+			}
+		case []string:
+			node.Type = "file"
+			for _, data := range item {
+				node.Size += uint64(len(data))
+				id, _, _, err := repo.SaveBlob(ctx, restic.DataBlob, []byte(data), restic.ID{}, false)
+				rtest.OK(t, err)
+				node.Content = append(node.Content, id)
+			}
+		case testDir:
+			node.Type = "dir"
+			node.Mode = 0755
+			id := saveTestTree(t, repo, item)
+			node.Subtree = &id
+		default:
+			t.Fatalf("unknown test item %T", item)
+		}
+		rtest.OK(t, tree.Insert(node))
+	}
+
+	id, err := restic.SaveTree(ctx, repo, tree)
+	rtest.OK(t, err)
+	return id
+}
+
+func lsPaths(t *testing.T, repo restic.Repository, id restic.ID, opts rapi.LsOptions) []string {
+	var paths []string
+	rtest.OK(t, rapi.Ls(context.TODO(), repo, id, opts, func(entry rapi.LsEntry) error {
+		paths = append(paths, entry.Path)
+		return nil
+	}))
+	return paths
+}
+
+func TestLs(t *testing.T) {
+	repo := repository.TestRepository(t)
+	id := saveTestSnapshot(t, repo, testDir{
+		"a": testDir{
+			"x.txt": "xxx",
+			"y.go":  "y",
+		},
+		"b": testDir{
+			"c": testDir{
+				"z.txt": "zzzzz",
+			},
+		},
+		"top.txt": "t",
+	})
+
+	rtest.Equals(t, []string{"/a", "/b", "/top.txt"}, lsPaths(t, repo, id, rapi.LsOptions{}))
+	rtest.Equals(t, []string{"/b/c/z.txt"}, lsPaths(t, repo, id, rapi.LsOptions{Path: "b/c"}))
+
+	rtest.Equals(t, []string{"/a", "/a/x.txt", "/a/y.go", "/b", "/b/c", "/b/c/z.txt", "/top.txt"},
+		lsPaths(t, repo, id, rapi.LsOptions{Recursive: true}))
+
+	rtest.Equals(t, []string{"/top.txt", "/b", "/b/c", "/b/c/z.txt", "/a", "/a/y.go", "/a/x.txt"},
+		lsPaths(t, repo, id, rapi.LsOptions{Recursive: true, Reverse: true}))
+
+	rtest.Equals(t, []string{"/a/x.txt", "/b/c/z.txt", "/top.txt"},
+		lsPaths(t, repo, id, rapi.LsOptions{Recursive: true, Include: []string{"*.txt"}}))
+
+	rtest.Equals(t, []string{"/a", "/a/x.txt", "/a/y.go", "/top.txt"},
+		lsPaths(t, repo, id, rapi.LsOptions{Recursive: true, Exclude: []string{"/b"}}))
+
+	rtest.Equals(t, []string{"/a/y.go", "/a/x.txt"}, lsPaths(t, repo, id, rapi.LsOptions{Path: "/a", SortBy: rapi.LsSortBySize}))
+	rtest.Equals(t, []string{"/a/x.txt", "/a/y.go"}, lsPaths(t, repo, id, rapi.LsOptions{Path: "/a", SortBy: rapi.LsSortBySize, Reverse: true}))
+
+	// returning an error stops the listing
+	errStop := errors.New("stop")
+	n := 0
+	err := rapi.Ls(context.TODO(), repo, id, rapi.LsOptions{Recursive: true}, func(rapi.LsEntry) error {
+		n++
+		if n == 2 {
+			return errStop
+		}
+		return nil
+	})
+	rtest.Equals(t, errStop, err)
+	rtest.Equals(t, 2, n)
+
+	// a cancelled context stops the listing as well
+	ctx, cancel := context.WithCancel(context.Background())
+	n = 0
+	err = rapi.Ls(ctx, repo, id, rapi.LsOptions{Recursive: true}, func(rapi.LsEntry) error {
+		n++
+		cancel()
+		return nil
+	})
+	rtest.Assert(t, errors.Is(err, context.Canceled), "unexpected error %v", err)
+	rtest.Equals(t, 1, n)
+
+	var buf bytes.Buffer
+	enc := rapi.NewLsEncoder(&buf)
+	rtest.OK(t, rapi.Ls(context.TODO(), repo, id, rapi.LsOptions{Path: "/a"}, enc.Encode))
+	dec := json.NewDecoder(&buf)
+	var names []string
+	for dec.More() {
+		var node struct {
+			Name string `json:"name"`
+			Size uint64 `json:"size"`
+		}
+		rtest.OK(t, dec.Decode(&node))
+		names = append(names, node.Name)
+	}
+	rtest.Equals(t, []string{"x.txt", "y.go"}, names)
+}
@@ -0,0 +1,332 @@
+package rapi
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/backend/limiter"
+	"github.com/konidev20/rapi/backend/local"
+	"github.com/konidev20/rapi/backend/location"
+	"github.com/konidev20/rapi/backend/quota"
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/repository"
+)
+
+// ManagerOptions configure a RepositoryManager.
+type ManagerOptions struct {
+	// Options are used to open the repository of each tenant. Repo is the
+	// location below which the repositories of all tenants are stored, the
+	// repository of a tenant is stored in the subdirectory with its name.
+	// The HTTP transport is created once and shared by all repositories.
+	Options RepositoryOptions
+
+	// Password returns the password of the repository of tenant.
+	Password func(tenant string) (string, error)
+
+	// Limits returns the rate limits of tenant. If it is nil, the limits in
+	// Options apply to each tenant separately.
+	Limits func(tenant string) limiter.Limits
+
+	// Quota returns the request and transfer quota of tenant, nil means
+	// unlimited. It is called once per tenant, the counters are retained
+	// when a repository is closed and opened again, see Usage.
+	Quota func(tenant string) *quota.Quota
+
+	// MaxOpen is the number of repositories which are kept open, the least
+	// recently used idle repositories are closed if more are open. If it is
+	// zero, 16 repositories are kept open.
+	MaxOpen int
+
+	// ListTenants returns the names of all tenants. If it is nil, Tenants
+	// only supports repositories in a local directory.
+	ListTenants func(ctx context.Context) ([]string, error)
+}
+
+// RepositoryManager opens and caches the repositories of many tenants which
+// are stored below a common location, for example one repository per
+// customer in a bucket. It is safe for concurrent use.
+type RepositoryManager struct {
+	opts ManagerOptions
+	base string
+
+	mu       sync.Mutex
+	repos    map[string]*managedRepo
+	trackers map[string]*quota.Tracker
+	closed   bool
+}
+
+type managedRepo struct {
+	tenant string
+
+	// mu is held while the repository is opened, repo is set while holding
+	// both mu and RepositoryManager.mu
+	mu   sync.Mutex
+	repo *repository.Repository
+
+	// users and lastUsed are protected by RepositoryManager.mu
+	users    int
+	lastUsed time.Time
+}
+
+// NewRepositoryManager returns a manager for the repositories below the
+// location configured in opts.
+func NewRepositoryManager(opts ManagerOptions) (*RepositoryManager, error) {
+	if opts.Password == nil {
+		return nil, errors.New("no password function specified")
+	}
+	if opts.MaxOpen == 0 {
+		opts.MaxOpen = 16
+	}
+	if opts.Options.backends == nil {
+		opts.Options.backends = DefaultOptions.backends
+	}
+
+	base, err := ReadRepo(opts.Options)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Options.transport == nil {
+		opts.Options.transport, err = backend.Transport(opts.Options.TransportOptions)
+		if err != nil {
+			return nil, errors.Fatal(err.Error())
+		}
+	}
+
+	return &RepositoryManager{
+		opts:     opts,
+		base:     strings.TrimSuffix(base, "/"),
+		repos:    make(map[string]*managedRepo),
+		trackers: make(map[string]*quota.Tracker),
+	}, nil
+}
+
+func validTenant(tenant string) error {
+	if tenant == "" || tenant == "." || tenant == ".." || strings.ContainsAny(tenant, `/\`) {
+		return errors.Errorf("invalid tenant name %q", tenant)
+	}
+	return nil
+}
+
+// Tenants returns the sorted names of all tenants. Without
+// ManagerOptions.ListTenants, the subdirectories of a local directory which
+// contain a repository are returned.
+func (m *RepositoryManager) Tenants(ctx context.Context) ([]string, error) {
+	var tenants []string
+	if m.opts.ListTenants != nil {
+		var err error
+		tenants, err = m.opts.ListTenants(ctx)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		loc, err := location.Parse(m.opts.Options.backends, m.base)
+		if err != nil {
+			return nil, errors.Fatalf("parsing repository location failed: %v", err)
+		}
+		cfg, ok := loc.Config.(*local.Config)
+		if !ok {
+			return nil, errors.Errorf("listing tenants is not supported for the %v backend", loc.Scheme)
+		}
+
+		entries, err := os.ReadDir(cfg.Path)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
+			}
+			if _, err := os.Stat(filepath.Join(cfg.Path, entry.Name(), "config")); err == nil {
+				tenants = append(tenants, entry.Name())
+			}
+		}
+	}
+
+	sort.Strings(tenants)
+	return tenants, nil
+}
+
+// Use calls fn with the repository of tenant, which is opened if necessary.
+// The repository stays open at least until fn returns, it must not be used
+// afterwards. The index is not loaded automatically.
+func (m *RepositoryManager) Use(ctx context.Context, tenant string, fn func(*repository.Repository) error) error {
+	if err := validTenant(tenant); err != nil {
+		return err
+	}
+
+	e, err := m.acquire(ctx, tenant)
+	if err != nil {
+		return err
+	}
+	defer m.release(e)
+	return fn(e.repo)
+}
+
+func (m *RepositoryManager) acquire(ctx context.Context, tenant string) (*managedRepo, error) {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil, errors.New("repository manager is closed")
+	}
+	e := m.repos[tenant]
+	if e == nil {
+		e = &managedRepo{tenant: tenant}
+		m.repos[tenant] = e
+	}
+	e.users++
+	e.lastUsed = time.Now()
+	m.mu.Unlock()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.repo != nil {
+		return e, nil
+	}
+
+	repo, err := m.open(ctx, tenant)
+	if err != nil {
+		m.release(e)
+		return nil, err
+	}
+	m.mu.Lock()
+	e.repo = repo
+	m.mu.Unlock()
+	return e, nil
+}
+
+func (m *RepositoryManager) open(ctx context.Context, tenant string) (*repository.Repository, error) {
+	password, err := m.opts.Password(tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := m.opts.Options
+	opts.Repo = m.base + "/" + tenant
+	opts.RepositoryFile = ""
+	opts.Password = password
+	if m.opts.Limits != nil {
+		opts.Limits = m.opts.Limits(tenant)
+	}
+	opts.Quota = m.tracker(tenant)
+
+	debug.Log("opening repository of tenant %v", tenant)
+	return OpenRepository(ctx, opts)
+}
+
+// release marks e as unused by the caller and closes the least recently used
+// idle repositories if too many are open.
+func (m *RepositoryManager) release(e *managedRepo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e.users--
+	e.lastUsed = time.Now()
+	if e.users == 0 && e.repo == nil {
+		// opening failed
+		delete(m.repos, e.tenant)
+	}
+
+	for len(m.repos) > m.opts.MaxOpen {
+		var oldest *managedRepo
+		for _, r := range m.repos {
+			if r.users == 0 && (oldest == nil || r.lastUsed.Before(oldest.lastUsed)) {
+				oldest = r
+			}
+		}
+		if oldest == nil {
+			return
+		}
+		m.closeRepo(oldest)
+	}
+}
+
+// closeRepo removes the idle repository e, m.mu must be held.
+func (m *RepositoryManager) closeRepo(e *managedRepo) {
+	delete(m.repos, e.tenant)
+	if e.repo == nil {
+		return
+	}
+	debug.Log("closing repository of tenant %v", e.tenant)
+	if err := e.repo.Close(); err != nil {
+		debug.Log("unable to close repository of tenant %v: %v", e.tenant, err)
+	}
+}
+
+// tracker returns the quota tracker of tenant, which is created on first use.
+func (m *RepositoryManager) tracker(tenant string) *quota.Tracker {
+	if m.opts.Quota == nil {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tr, ok := m.trackers[tenant]
+	if !ok {
+		if q := m.opts.Quota(tenant); q != nil {
+			tr = quota.NewTracker(*q)
+		}
+		m.trackers[tenant] = tr
+	}
+	return tr
+}
+
+// Usage returns the quota counters of tenant. It returns false if the
+// repository of tenant has not been opened yet or has no quota.
+func (m *RepositoryManager) Usage(tenant string) (quota.Usage, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tr := m.trackers[tenant]
+	if tr == nil {
+		return quota.Usage{}, false
+	}
+	return tr.Usage(), true
+}
+
+// SetQuota replaces the quota of tenant, the counters are retained.
+func (m *RepositoryManager) SetQuota(tenant string, q quota.Quota) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tr := m.trackers[tenant]
+	if tr == nil {
+		m.trackers[tenant] = quota.NewTracker(q)
+		return
+	}
+	tr.SetQuota(q)
+}
+
+// Open returns the tenants whose repositories are currently open.
+func (m *RepositoryManager) Open() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tenants := make([]string, 0, len(m.repos))
+	for tenant := range m.repos {
+		tenants = append(tenants, tenant)
+	}
+	sort.Strings(tenants)
+	return tenants
+}
+
+// Close closes all idle repositories. Repositories which are in use are
+// closed as soon as they are released, new ones cannot be opened anymore.
+func (m *RepositoryManager) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.closed = true
+	m.opts.MaxOpen = 0
+	for _, e := range m.repos {
+		if e.users == 0 {
+			m.closeRepo(e)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package rapi_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/konidev20/rapi"
+	"github.com/konidev20/rapi/backend/limiter"
+	"github.com/konidev20/rapi/backend/local"
+	"github.com/konidev20/rapi/backend/quota"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/restic"
+)
+
+func TestRepositoryManager(t *testing.T) {
+	ctx := context.Background()
+	base := rtest.TempDir(t)
+
+	ids := make(map[string]string)
+	for _, tenant := range []string{"alice", "bob", "carol"} {
+		be, err := local.Create(ctx, local.Config{Path: filepath.Join(base, tenant), Connections: 2})
+		rtest.OK(t, err)
+		repo := repository.TestRepositoryWithBackend(t, be, 0)
+		ids[tenant] = repo.Config().ID
+		rtest.OK(t, be.Close())
+	}
+	// not a repository
+	rtest.OK(t, os.Mkdir(filepath.Join(base, "other"), 0700))
+
+	opts := rapi.DefaultOptions
+	opts.Repo = base
+	opts.NoCache = true
+	var limited []string
+	m, err := rapi.NewRepositoryManager(rapi.ManagerOptions{
+		Options: opts,
+		Password: func(string) (string, error) {
+			return rtest.TestPassword, nil
+		},
+		Limits: func(tenant string) limiter.Limits {
+			limited = append(limited, tenant)
+			return limiter.Limits{UploadKb: 1024}
+		},
+		MaxOpen: 2,
+	})
+	rtest.OK(t, err)
+
+	tenants, err := m.Tenants(ctx)
+	rtest.OK(t, err)
+	rtest.Equals(t, []string{"alice", "bob", "carol"}, tenants)
+
+	for _, tenant := range tenants {
+		rtest.OK(t, m.Use(ctx, tenant, func(repo *repository.Repository) error {
+			rtest.Equals(t, ids[tenant], repo.Config().ID)
+			return nil
+		}))
+	}
+	// the least recently used repository was closed
+	rtest.Equals(t, []string{"bob", "carol"}, m.Open())
+	rtest.Equals(t, tenants, limited)
+
+	// open repositories are reused
+	rtest.OK(t, m.Use(ctx, "carol", func(*repository.Repository) error { return nil }))
+	rtest.Equals(t, 3, len(limited))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rtest.OK(t, m.Use(ctx, "bob", func(repo *repository.Repository) error {
+				return repo.List(ctx, restic.SnapshotFile, func(restic.ID, int64) error { return nil })
+			}))
+		}()
+	}
+	wg.Wait()
+
+	rtest.Assert(t, m.Use(ctx, "../alice", func(*repository.Repository) error { return nil }) != nil,
+		"invalid tenant name was accepted")
+	rtest.Assert(t, m.Use(ctx, "other", func(*repository.Repository) error { return nil }) != nil,
+		"directory without repository was opened")
+	rtest.Equals(t, []string{"bob", "carol"}, m.Open())
+
+	m.Close()
+	rtest.Equals(t, 0, len(m.Open()))
+	rtest.Assert(t, m.Use(ctx, "alice", func(*repository.Repository) error { return nil }) != nil,
+		"closed manager opened a repository")
+}
+
+func TestRepositoryManagerQuota(t *testing.T) {
+	ctx := context.Background()
+	base := rtest.TempDir(t)
+	for _, tenant := range []string{"alice", "bob"} {
+		be, err := local.Create(ctx, local.Config{Path: filepath.Join(base, tenant), Connections: 2})
+		rtest.OK(t, err)
+		repository.TestRepositoryWithBackend(t, be, 0)
+		rtest.OK(t, be.Close())
+	}
+
+	opts := rapi.DefaultOptions
+	opts.Repo = base
+	opts.NoCache = true
+	m, err := rapi.NewRepositoryManager(rapi.ManagerOptions{
+		Options: opts,
+		Password: func(string) (string, error) {
+			return rtest.TestPassword, nil
+		},
+		Quota: func(tenant string) *quota.Quota {
+			if tenant == "bob" {
+				return nil
+			}
+			return &quota.Quota{BytesPerDay: 1 << 30}
+		},
+		MaxOpen: 1,
+	})
+	rtest.OK(t, err)
+
+	list := func(repo *repository.Repository) error {
+		return repo.List(ctx, restic.SnapshotFile, func(restic.ID, int64) error { return nil })
+	}
+	rtest.OK(t, m.Use(ctx, "alice", list))
+	usage, ok := m.Usage("alice")
+	rtest.Assert(t, ok, "no usage for alice")
+	rtest.Assert(t, usage.Requests > 0, "no requests counted")
+	rtest.Assert(t, usage.BytesDownloaded > 0, "no downloads counted")
+
+	// the counters are retained when the repository is closed
+	rtest.OK(t, m.Use(ctx, "bob", list))
+	_, ok = m.Usage("bob")
+	rtest.Assert(t, !ok, "usage for bob without quota")
+	rtest.Equals(t, []string{"bob"}, m.Open())
+	rtest.OK(t, m.Use(ctx, "alice", list))
+	again, _ := m.Usage("alice")
+	rtest.Assert(t, again.Requests > usage.Requests, "counters were reset")
+
+	// a used up quota rejects requests
+	m.SetQuota("alice", quota.Quota{BytesPerDay: 1})
+	err = m.Use(ctx, "alice", list)
+	rtest.Assert(t, errors.Is(err, quota.ErrQuotaExceeded), "unexpected error %v", err)
+}
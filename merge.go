@@ -0,0 +1,195 @@
+package rapi
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/restic"
+	"golang.org/x/sync/errgroup"
+)
+
+// MergeConflict selects which node is kept by MergeSnapshots if a path exists
+// in several snapshots and is not a directory in all of them.
+type MergeConflict int
+
+const (
+	// MergeByPrecedence keeps the node of the snapshot listed last.
+	MergeByPrecedence MergeConflict = iota
+	// MergeByModTime keeps the node with the latest modification time, ties
+	// are resolved by precedence.
+	MergeByModTime
+)
+
+// MergeOptions configure the snapshot created by MergeSnapshots.
+type MergeOptions struct {
+	Conflict MergeConflict
+
+	// Hostname and Tags of the new snapshot. If they are empty, the values
+	// of the last snapshot are used.
+	Hostname string
+	Tags     []string
+
+	// Time of the new snapshot. If it is zero, the current time of the clock
+	// of the context is used, see restic.WithClock.
+	Time time.Time
+}
+
+// MergeSnapshots creates a new snapshot which contains the union of the
+// trees of the snapshots ids, for example to consolidate the snapshots of
+// several backup jobs for different directories into one. Directories which
+// exist in several snapshots are merged recursively, for all other paths the
+// node is selected according to opts.Conflict. The paths of the new snapshot
+// are the union of the paths of all snapshots. The merged snapshots are not
+// removed. The index must already be loaded. Returned is the ID of the new
+// snapshot.
+func MergeSnapshots(ctx context.Context, repo restic.Repository, ids restic.IDs, opts MergeOptions) (_ restic.ID, err error) {
+	if len(ids) < 2 {
+		return restic.ID{}, errors.New("at least two snapshots are required")
+	}
+
+	op, err := beginOperation(ctx, repo, "merge", false)
+	if err != nil {
+		return restic.ID{}, err
+	}
+	defer func() { op.end(ctx, err != nil) }()
+
+	var snapshots []*restic.Snapshot
+	paths := make(map[string]struct{})
+	for _, id := range ids {
+		sn, err := restic.LoadSnapshot(ctx, repo, id)
+		if err != nil {
+			return restic.ID{}, err
+		}
+		if sn.Tree == nil {
+			return restic.ID{}, errors.Errorf("snapshot %v has no tree", id.Str())
+		}
+		for _, p := range sn.Paths {
+			paths[p] = struct{}{}
+		}
+		snapshots = append(snapshots, sn)
+	}
+	last := snapshots[len(snapshots)-1]
+
+	if opts.Hostname == "" {
+		opts.Hostname = last.Hostname
+	}
+	if opts.Tags == nil {
+		opts.Tags = last.Tags
+	}
+	if opts.Time.IsZero() {
+		opts.Time = restic.Now(ctx)
+	}
+
+	sn, err := restic.NewSnapshot(nil, opts.Tags, opts.Hostname, opts.Time)
+	if err != nil {
+		return restic.ID{}, err
+	}
+	for p := range paths {
+		sn.Paths = append(sn.Paths, p)
+	}
+	sort.Strings(sn.Paths)
+
+	trees := make(restic.IDs, 0, len(snapshots))
+	for _, s := range snapshots {
+		trees = append(trees, *s.Tree)
+	}
+
+	wg, wgCtx := errgroup.WithContext(ctx)
+	repo.StartPackUploader(wgCtx, wg)
+	m := &merger{repo: repo, conflict: opts.Conflict}
+	root, err := m.mergeTrees(wgCtx, trees)
+	if err == nil {
+		err = repo.Flush(wgCtx)
+	}
+	if werr := wg.Wait(); err == nil {
+		err = werr
+	}
+	if err != nil {
+		return restic.ID{}, err
+	}
+
+	sn.Tree = &root
+	id, err := restic.SaveSnapshot(ctx, repo, sn)
+	if err != nil {
+		return restic.ID{}, err
+	}
+	debug.Log("merged %d snapshots into %v", len(ids), id.Str())
+	return id, nil
+}
+
+type merger struct {
+	repo     restic.Repository
+	conflict MergeConflict
+}
+
+// mergeTrees saves the union of the trees ids and returns the ID of the new
+// tree. The order of ids is the precedence of the trees.
+func (m *merger) mergeTrees(ctx context.Context, ids restic.IDs) (restic.ID, error) {
+	// nothing to merge if all trees are identical
+	if len(restic.NewIDSet(ids...)) == 1 {
+		return ids[0], nil
+	}
+
+	byName := make(map[string][]*restic.Node)
+	for _, id := range ids {
+		tree, err := restic.LoadTree(ctx, m.repo, id)
+		if err != nil {
+			return restic.ID{}, err
+		}
+		for _, node := range tree.Nodes {
+			byName[node.Name] = append(byName[node.Name], node)
+		}
+	}
+
+	tree := restic.NewTree(len(byName))
+	for _, nodes := range byName {
+		node, err := m.mergeNodes(ctx, nodes)
+		if err != nil {
+			return restic.ID{}, err
+		}
+		if err := tree.Insert(node); err != nil {
+			return restic.ID{}, err
+		}
+	}
+
+	return restic.SaveTree(ctx, m.repo, tree)
+}
+
+// mergeNodes returns the node which replaces nodes, which have the same name.
+func (m *merger) mergeNodes(ctx context.Context, nodes []*restic.Node) (*restic.Node, error) {
+	node := m.selectNode(nodes)
+
+	var subtrees restic.IDs
+	for _, n := range nodes {
+		if n.Type != "dir" {
+			return node, nil
+		}
+		subtrees = append(subtrees, *n.Subtree)
+	}
+	if len(subtrees) == 1 {
+		return node, nil
+	}
+
+	id, err := m.mergeTrees(ctx, subtrees)
+	if err != nil {
+		return nil, err
+	}
+	merged := *node
+	merged.Subtree = &id
+	return &merged, nil
+}
+
+// selectNode returns the node which is kept according to the conflict
+// policy.
+func (m *merger) selectNode(nodes []*restic.Node) *restic.Node {
+	selected := nodes[0]
+	for _, n := range nodes[1:] {
+		if m.conflict != MergeByModTime || !n.ModTime.Before(selected.ModTime) {
+			selected = n
+		}
+	}
+	return selected
+}
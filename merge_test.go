@@ -0,0 +1,77 @@
+package rapi_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/konidev20/rapi"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/restic"
+)
+
+func loadFileContent(t *testing.T, repo restic.Repository, id restic.ID, p string) string {
+	var content []byte
+	rtest.OK(t, rapi.Ls(context.TODO(), repo, id, rapi.LsOptions{Recursive: true}, func(entry rapi.LsEntry) error {
+		if entry.Path != p {
+			return nil
+		}
+		for _, blob := range entry.Node.Content {
+			buf, err := repo.LoadBlob(context.TODO(), restic.DataBlob, blob, nil)
+			rtest.OK(t, err)
+			content = append(content, buf...)
+		}
+		return nil
+	}))
+	return string(content)
+}
+
+func TestMergeSnapshots(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.TestRepository(t)
+
+	older := saveTestSnapshot(t, repo, testDir{
+		"home": testDir{
+			"alice":  testDir{"file": "alice"},
+			"shared": "old",
+		},
+		"etc": testDir{"hosts": "hosts"},
+	})
+	newer := saveTestSnapshot(t, repo, testDir{
+		"home": testDir{
+			"bob":    testDir{"file": "bob"},
+			"shared": "new",
+		},
+		"var": "not a directory",
+	})
+
+	id, err := rapi.MergeSnapshots(ctx, repo, restic.IDs{older, newer}, rapi.MergeOptions{Tags: []string{"merged"}})
+	rtest.OK(t, err)
+	rtest.Equals(t, []string{"/etc", "/etc/hosts", "/home", "/home/alice", "/home/alice/file",
+		"/home/bob", "/home/bob/file", "/home/shared", "/var"},
+		lsPaths(t, repo, id, rapi.LsOptions{Recursive: true}))
+	rtest.Equals(t, "new", loadFileContent(t, repo, id, "/home/shared"))
+
+	sn, err := restic.LoadSnapshot(ctx, repo, id)
+	rtest.OK(t, err)
+	rtest.Equals(t, []string{"/"}, sn.Paths)
+	rtest.Equals(t, []string{"merged"}, sn.Tags)
+	rtest.Equals(t, "test", sn.Hostname)
+	checkTreeLoadable(t, repo, *sn.Tree)
+
+	// the newer file wins regardless of the order
+	id, err = rapi.MergeSnapshots(ctx, repo, restic.IDs{newer, older}, rapi.MergeOptions{Conflict: rapi.MergeByModTime})
+	rtest.OK(t, err)
+	rtest.Equals(t, "new", loadFileContent(t, repo, id, "/home/shared"))
+
+	id, err = rapi.MergeSnapshots(ctx, repo, restic.IDs{newer, older}, rapi.MergeOptions{})
+	rtest.OK(t, err)
+	rtest.Equals(t, "old", loadFileContent(t, repo, id, "/home/shared"))
+
+	res, err := rapi.Check(ctx, repo, rapi.CheckOptions{})
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, len(res.Errors))
+
+	_, err = rapi.MergeSnapshots(ctx, repo, restic.IDs{older}, rapi.MergeOptions{})
+	rtest.Assert(t, err != nil, "merging a single snapshot did not fail")
+}
@@ -0,0 +1,14 @@
+package rapi
+
+import (
+	"github.com/konidev20/rapi/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MetricsCollector returns a prometheus.Collector for the statistics about
+// backend requests, transferred bytes, retries, HTTP connection reuse, cache
+// hits and pack uploads of all repositories opened by this process. It can be
+// registered with the Prometheus registry of the embedding application.
+func MetricsCollector() prometheus.Collector {
+	return metrics.Default
+}
@@ -0,0 +1,186 @@
+package rapi
+
+import (
+	"context"
+
+	"github.com/konidev20/rapi/internal/checker"
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/internal/migrations"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/restic"
+	"github.com/konidev20/rapi/ui/progress"
+)
+
+// Migration is a data migration which can be applied to a repository.
+type Migration = migrations.Migration
+
+// ProgressMigration is a Migration which reports its progress.
+type ProgressMigration = migrations.ProgressMigration
+
+// RegisterMigration makes a custom migration available to ListMigrations and
+// RunMigration. The name of the migration must be unique.
+func RegisterMigration(m Migration) error {
+	return migrations.Register(m)
+}
+
+// MigrationStatus describes whether a migration can be applied to a
+// repository.
+type MigrationStatus struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Applicable  bool   `json:"applicable"`
+
+	// Reason explains why the migration is not applicable, it may be empty.
+	Reason string `json:"reason,omitempty"`
+}
+
+// ListMigrations returns all registered migrations and whether they can be
+// applied to repo.
+func ListMigrations(ctx context.Context, repo restic.Repository) ([]MigrationStatus, error) {
+	var list []MigrationStatus
+	for _, m := range migrations.All {
+		ok, reason, err := m.Check(ctx, repo)
+		if err != nil {
+			return nil, errors.Wrapf(err, "checking migration %v", m.Name())
+		}
+
+		list = append(list, MigrationStatus{
+			Name:        m.Name(),
+			Description: m.Desc(),
+			Applicable:  ok,
+			Reason:      reason,
+		})
+	}
+
+	return list, nil
+}
+
+// MigrateOptions configure how a migration is run.
+type MigrateOptions struct {
+	// DryRun only checks whether the migration can be applied.
+	DryRun bool
+
+	// Force applies the migration even if it reports that it is not
+	// applicable.
+	Force bool
+
+	// Progress receives the number of processed items, if the migration
+	// supports it. It is not stopped by RunMigration.
+	Progress *progress.Counter
+}
+
+// RunMigration applies the migration with the given name to repo, like
+// `restic migrate`. The repository is locked exclusively, and if the migration
+// requires it, the integrity of the repository is checked first.
+func RunMigration(ctx context.Context, repo restic.Repository, name string, opts MigrateOptions) error {
+	m := migrations.Find(name)
+	if m == nil {
+		return errors.Fatalf("unknown migration %q", name)
+	}
+
+	ok, reason, err := m.Check(ctx, repo)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		if !opts.Force {
+			if reason == "" {
+				reason = "check failed"
+			}
+			return errors.Fatalf("migration %v cannot be applied: %v", name, reason)
+		}
+		debug.Log("migration %v cannot be applied (%v), applying anyway", name, reason)
+	}
+
+	if opts.DryRun {
+		return nil
+	}
+
+	unlock, err := lockRepository(ctx, repo, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if m.RepoCheck() {
+		if err := checkRepository(ctx, repo); err != nil {
+			return errors.Wrapf(err, "repository check failed, not applying migration %v", name)
+		}
+	}
+
+	if pm, ok := m.(ProgressMigration); ok {
+		err = pm.ApplyWithProgress(ctx, repo, opts.Progress)
+	} else {
+		err = m.Apply(ctx, repo)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "migration %v failed", name)
+	}
+
+	debug.Log("migration %v applied", name)
+	return nil
+}
+
+// EnableFeature enables the feature f for repo. Compression, the only
+// feature supported so far, is enabled by upgrading the repository to version
+// 2 using the upgrade_repo_v2 migration. Afterwards, the repository can no
+// longer be accessed by versions which do not support it. For all other
+// features an UnsupportedFeatureError is returned.
+func EnableFeature(ctx context.Context, repo *repository.Repository, f restic.Feature) error {
+	if repo.Config().HasFeature(f) {
+		return nil
+	}
+	if f != restic.FeatureCompression {
+		return &restic.UnsupportedFeatureError{Features: []restic.Feature{f}}
+	}
+
+	if err := RunMigration(ctx, repo, "upgrade_repo_v2", MigrateOptions{}); err != nil {
+		return err
+	}
+	return repo.ReloadConfig(ctx)
+}
+
+// checkRepository checks the index, the pack files and the tree structure of
+// repo and returns the first error found.
+func checkRepository(ctx context.Context, repo restic.Repository) error {
+	chkr := checker.New(repo, false)
+
+	_, errs := chkr.LoadIndex(ctx, nil)
+	if len(errs) > 0 {
+		return errs[0]
+	}
+
+	if err := chkr.LoadSnapshots(ctx); err != nil {
+		return err
+	}
+
+	var first error
+	errChan := make(chan error)
+	go chkr.Packs(ctx, errChan)
+	for err := range errChan {
+		if checker.IsOrphanedPack(err) || err == checker.ErrLegacyLayout {
+			continue
+		}
+		if first == nil {
+			first = err
+		}
+	}
+	if first != nil {
+		return first
+	}
+
+	errChan = make(chan error)
+	go chkr.Structure(ctx, nil, errChan)
+	for err := range errChan {
+		if first == nil {
+			first = err
+		}
+	}
+	if first != nil {
+		return first
+	}
+
+	return ctx.Err()
+}
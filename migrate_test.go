@@ -0,0 +1,29 @@
+package rapi_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/konidev20/rapi"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/restic"
+)
+
+func TestEnableFeature(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.TestRepositoryWithVersion(t, 1).(*repository.Repository)
+	rtest.Assert(t, !repo.Config().HasFeature(restic.FeatureCompression), "version 1 repository supports compression")
+
+	rtest.OK(t, rapi.EnableFeature(ctx, repo, restic.FeatureCompression))
+	rtest.Equals(t, uint(2), repo.Config().Version)
+	rtest.Assert(t, repo.Config().HasFeature(restic.FeatureCompression), "compression not enabled")
+
+	// enabling the feature again is a no-op
+	rtest.OK(t, rapi.EnableFeature(ctx, repo, restic.FeatureCompression))
+
+	err := rapi.EnableFeature(ctx, repo, restic.FeatureSignatures)
+	var ufe *restic.UnsupportedFeatureError
+	rtest.Assert(t, errors.As(err, &ufe), "wrong error type %T", err)
+}
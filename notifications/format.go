@@ -0,0 +1,182 @@
+package notifications
+
+import (
+	"bytes"
+	"encoding/json"
+	htmltemplate "html/template"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/ui"
+)
+
+// Message is a rendered notification.
+type Message struct {
+	Summary Summary
+
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Funcs are the functions available in the templates in addition to the
+// builtin ones: bytes formats a size, duration a time.Duration and json
+// encodes a value as JSON.
+var Funcs = map[string]interface{}{
+	"bytes":    ui.FormatBytes,
+	"duration": formatDuration,
+	"json":     formatJSON,
+}
+
+func formatDuration(d time.Duration) string {
+	return d.Round(time.Second).String()
+}
+
+func formatJSON(v interface{}) (string, error) {
+	buf, err := json.Marshal(v)
+	return string(buf), err
+}
+
+const defaultSubject = `[restic] {{.Operation}} {{.Status}}{{with .Hostname}} on {{.}}{{end}}`
+
+const defaultText = `Operation:  {{.Operation}}
+Status:     {{.Status}}
+{{- with .Repository}}
+Repository: {{.}}{{end}}
+{{- with .Hostname}}
+Host:       {{.}}{{end}}
+{{- with .SnapshotID}}
+Snapshot:   {{.}}{{end}}
+{{- if not .Start.IsZero}}
+Started:    {{.Start.Format "2006-01-02 15:04:05 MST"}}
+Duration:   {{duration .Duration}}{{end}}
+{{- with .Error}}
+
+Error: {{.}}{{end}}
+{{- with .Stats}}
+
+Files:      {{.FilesNew}} new, {{.FilesChanged}} changed, {{.FilesUnmodified}} unmodified
+Dirs:       {{.DirsNew}} new, {{.DirsChanged}} changed, {{.DirsUnmodified}} unmodified
+Added:      {{bytes .DataAdded}} ({{bytes .DataAddedPacked}} stored)
+Processed:  {{.TotalFilesProcessed}} files, {{bytes .TotalBytesProcessed}}{{end}}
+{{- if .Warnings}}
+
+Warnings:
+{{- range .Warnings}}
+  {{.}}{{end}}
+{{- with .WarningsOmitted}}
+  and {{.}} more{{end}}{{end}}
+`
+
+const defaultHTML = `<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif">
+<h2>{{.Operation}} {{.Status}}</h2>
+<table>
+{{- with .Repository}}
+<tr><th align="left">Repository</th><td>{{.}}</td></tr>{{end}}
+{{- with .Hostname}}
+<tr><th align="left">Host</th><td>{{.}}</td></tr>{{end}}
+{{- with .SnapshotID}}
+<tr><th align="left">Snapshot</th><td><code>{{.}}</code></td></tr>{{end}}
+{{- if not .Start.IsZero}}
+<tr><th align="left">Started</th><td>{{.Start.Format "2006-01-02 15:04:05 MST"}}</td></tr>
+<tr><th align="left">Duration</th><td>{{duration .Duration}}</td></tr>{{end}}
+{{- with .Stats}}
+<tr><th align="left">Files</th><td>{{.FilesNew}} new, {{.FilesChanged}} changed, {{.FilesUnmodified}} unmodified</td></tr>
+<tr><th align="left">Dirs</th><td>{{.DirsNew}} new, {{.DirsChanged}} changed, {{.DirsUnmodified}} unmodified</td></tr>
+<tr><th align="left">Added</th><td>{{bytes .DataAdded}} ({{bytes .DataAddedPacked}} stored)</td></tr>
+<tr><th align="left">Processed</th><td>{{.TotalFilesProcessed}} files, {{bytes .TotalBytesProcessed}}</td></tr>{{end}}
+</table>
+{{- with .Error}}
+<p style="color: #b00020"><b>Error:</b> {{.}}</p>{{end}}
+{{- if .Warnings}}
+<h3>Warnings</h3>
+<ul>
+{{- range .Warnings}}
+<li>{{.}}</li>{{end}}
+{{- with .WarningsOmitted}}
+<li>and {{.}} more</li>{{end}}
+</ul>{{end}}
+</body>
+</html>
+`
+
+// Formatter renders a Summary into a Message.
+type Formatter struct {
+	Subject *template.Template
+	Text    *template.Template
+	// HTML may be nil, then messages are sent as text only.
+	HTML *htmltemplate.Template
+}
+
+// NewFormatter parses the templates, which are executed with a Summary. An
+// empty template is replaced by the default one, except for html, which
+// disables the HTML message if it is "-".
+func NewFormatter(subject, text, html string) (*Formatter, error) {
+	if subject == "" {
+		subject = defaultSubject
+	}
+	if text == "" {
+		text = defaultText
+	}
+	if html == "" {
+		html = defaultHTML
+	}
+
+	f := &Formatter{}
+	var err error
+	f.Subject, err = template.New("subject").Funcs(Funcs).Parse(subject)
+	if err != nil {
+		return nil, errors.Fatalf("invalid subject template: %v", err)
+	}
+	f.Text, err = template.New("text").Funcs(Funcs).Parse(text)
+	if err != nil {
+		return nil, errors.Fatalf("invalid text template: %v", err)
+	}
+	if html != "-" {
+		f.HTML, err = htmltemplate.New("html").Funcs(Funcs).Parse(html)
+		if err != nil {
+			return nil, errors.Fatalf("invalid HTML template: %v", err)
+		}
+	}
+	return f, nil
+}
+
+// DefaultFormatter renders messages with the default templates.
+var DefaultFormatter = func() *Formatter {
+	f, err := NewFormatter("", "", "")
+	if err != nil {
+		panic(err)
+	}
+	return f
+}()
+
+// Render returns the message for s.
+func (f *Formatter) Render(s Summary) (Message, error) {
+	msg := Message{Summary: s}
+	var buf bytes.Buffer
+
+	if err := f.Subject.Execute(&buf, s); err != nil {
+		return Message{}, errors.Wrap(err, "subject template")
+	}
+	// a subject must be a single line
+	msg.Subject = strings.Join(strings.Fields(buf.String()), " ")
+
+	buf.Reset()
+	if err := f.Text.Execute(&buf, s); err != nil {
+		return Message{}, errors.Wrap(err, "text template")
+	}
+	msg.Text = buf.String()
+
+	if f.HTML != nil {
+		buf.Reset()
+		if err := f.HTML.Execute(&buf, s); err != nil {
+			return Message{}, errors.Wrap(err, "HTML template")
+		}
+		msg.HTML = buf.String()
+	}
+	return msg, nil
+}
@@ -0,0 +1,270 @@
+package notifications_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/konidev20/rapi"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/notifications"
+	"github.com/konidev20/rapi/restic"
+)
+
+func testSummary() notifications.Summary {
+	start := time.Date(2024, 3, 1, 2, 0, 0, 0, time.UTC)
+	id := restic.NewRandomID()
+	res := rapi.BackupResult{
+		Snapshot: &restic.Snapshot{
+			Time:     start,
+			Hostname: "db1",
+			Summary: &restic.SnapshotSummary{
+				BackupStart:         start,
+				BackupEnd:           start.Add(95 * time.Second),
+				FilesNew:            3,
+				DataAdded:           3 << 20,
+				TotalFilesProcessed: 10,
+			},
+		},
+		ID: id,
+		Warnings: []restic.Warning{
+			restic.NewWarning("/etc/<shadow>", errors.New("permission denied")),
+		},
+	}
+	s := notifications.FromBackup(res, errors.New("1 files could not be read"))
+	s.Repository = "s3:host/bucket"
+	return s
+}
+
+func TestFromBackup(t *testing.T) {
+	s := testSummary()
+	rtest.Equals(t, "backup", s.Operation)
+	rtest.Equals(t, "db1", s.Hostname)
+	rtest.Equals(t, 95*time.Second, s.Duration())
+	rtest.Equals(t, notifications.StatusWarning, s.Status())
+
+	failed := notifications.FromBackup(rapi.BackupResult{}, errors.New("repository is locked"))
+	rtest.Equals(t, notifications.StatusFailure, failed.Status())
+	rtest.Equals(t, notifications.StatusSuccess, notifications.FromBackup(rapi.BackupResult{ID: restic.NewRandomID()}, nil).Status())
+
+	var warnings []restic.Warning
+	for i := 0; i < notifications.MaxWarnings+5; i++ {
+		warnings = append(warnings, restic.NewWarning("file", errors.New("vanished")))
+	}
+	s = notifications.FromBackup(rapi.BackupResult{Warnings: warnings}, nil)
+	rtest.Equals(t, notifications.MaxWarnings, len(s.Warnings))
+	rtest.Equals(t, 5, s.WarningsOmitted)
+
+	buf, err := json.Marshal(testSummary())
+	rtest.OK(t, err)
+	var m map[string]interface{}
+	rtest.OK(t, json.Unmarshal(buf, &m))
+	rtest.Equals(t, "warning", m["status"])
+	rtest.Equals(t, 95.0, m["duration_seconds"])
+}
+
+func TestRender(t *testing.T) {
+	s := testSummary()
+	msg, err := notifications.DefaultFormatter.Render(s)
+	rtest.OK(t, err)
+	rtest.Equals(t, "[restic] backup warning on db1", msg.Subject)
+	for _, want := range []string{"s3:host/bucket", s.SnapshotID, "1m35s", "3 new", "3.000 MiB", "/etc/<shadow>: permission denied"} {
+		rtest.Assert(t, strings.Contains(msg.Text, want), "%q not found in text:\n%s", want, msg.Text)
+	}
+	rtest.Assert(t, strings.Contains(msg.HTML, "/etc/&lt;shadow&gt;"), "warning not escaped in HTML:\n%s", msg.HTML)
+
+	f, err := notifications.NewFormatter("{{.Operation}}\n{{.Status}}", "{{bytes .Stats.DataAdded}}", "-")
+	rtest.OK(t, err)
+	msg, err = f.Render(s)
+	rtest.OK(t, err)
+	rtest.Equals(t, "backup warning", msg.Subject)
+	rtest.Equals(t, "3.000 MiB", msg.Text)
+	rtest.Equals(t, "", msg.HTML)
+
+	_, err = notifications.NewFormatter("{{.Operation", "", "")
+	rtest.Assert(t, err != nil, "invalid template accepted")
+}
+
+func TestWebhook(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(body))
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	msg, err := notifications.DefaultFormatter.Render(testSummary())
+	rtest.OK(t, err)
+
+	w, err := notifications.NewWebhook(srv.URL, "")
+	rtest.OK(t, err)
+	rtest.OK(t, w.Send(ctx, msg))
+	w, err = notifications.NewWebhook(srv.URL, `{"text": {{json .Subject}}}`)
+	rtest.OK(t, err)
+	rtest.OK(t, w.Send(ctx, msg))
+
+	var payload struct {
+		Subject string
+		Summary map[string]interface{}
+	}
+	rtest.OK(t, json.Unmarshal([]byte(bodies[0]), &payload))
+	rtest.Equals(t, msg.Subject, payload.Subject)
+	rtest.Equals(t, msg.Summary.SnapshotID, payload.Summary["snapshot_id"])
+	rtest.Equals(t, `{"text": "[restic] backup warning on db1"}`, bodies[1])
+}
+
+// smtpServer is a minimal SMTP server which records the delivered mails.
+type smtpServer struct {
+	l net.Listener
+
+	mu    sync.Mutex
+	rcpts []string
+	data  string
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	rtest.OK(t, err)
+	s := &smtpServer{l: l}
+	t.Cleanup(func() { _ = l.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *smtpServer) serve() {
+	for {
+		conn, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *smtpServer) handle(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { _, _ = io.WriteString(conn, line+"\r\n") }
+
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			s.mu.Lock()
+			s.rcpts = append(s.rcpts, strings.Trim(strings.TrimSpace(line)[len("RCPT TO:"):], "<>"))
+			s.mu.Unlock()
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(l, "."))
+			}
+			s.mu.Lock()
+			s.data = data.String()
+			s.mu.Unlock()
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func TestSMTP(t *testing.T) {
+	srv := newSMTPServer(t)
+	msg, err := notifications.DefaultFormatter.Render(testSummary())
+	rtest.OK(t, err)
+
+	sender := &notifications.SMTP{
+		Addr: srv.l.Addr().String(),
+		From: "Backup <backup@example.com>",
+		To:   []string{"ops@example.com", "admin@example.com"},
+	}
+	rtest.OK(t, sender.Send(context.Background(), msg))
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	rtest.Equals(t, []string{"ops@example.com", "admin@example.com"}, srv.rcpts)
+
+	m, err := mail.ReadMessage(strings.NewReader(srv.data))
+	rtest.OK(t, err)
+	subject, err := new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject"))
+	rtest.OK(t, err)
+	rtest.Equals(t, msg.Subject, subject)
+
+	mediaType, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	rtest.OK(t, err)
+	rtest.Equals(t, "multipart/alternative", mediaType)
+	mr := multipart.NewReader(m.Body, params["boundary"])
+	var parts []string
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		rtest.OK(t, err)
+		body, err := io.ReadAll(p)
+		rtest.OK(t, err)
+		// the lines of mails end with CRLF
+		parts = append(parts, strings.ReplaceAll(string(body), "\r\n", "\n"))
+	}
+	rtest.Equals(t, []string{msg.Text, msg.HTML}, parts)
+
+	sender.To = []string{"not an address"}
+	rtest.Assert(t, sender.Send(context.Background(), msg) != nil, "invalid recipient accepted")
+}
+
+func TestNotifier(t *testing.T) {
+	var sent []string
+	record := func(name string, err error) notifications.Sender {
+		return notifications.SenderFunc(func(_ context.Context, msg notifications.Message) error {
+			sent = append(sent, name+": "+msg.Subject)
+			return err
+		})
+	}
+
+	n := &notifications.Notifier{
+		Senders:     []notifications.Sender{record("a", errors.New("unreachable")), record("b", nil)},
+		SkipSuccess: true,
+	}
+	ctx := context.Background()
+	rtest.OK(t, n.Notify(ctx, notifications.Summary{Operation: "prune"}))
+	rtest.Equals(t, 0, len(sent))
+
+	err := n.Notify(ctx, notifications.Summary{Operation: "prune", Error: "repository is locked"})
+	rtest.Assert(t, err != nil, "error of sender not returned")
+	rtest.Equals(t, []string{"a: [restic] prune failure", "b: [restic] prune failure"}, sent)
+}
@@ -0,0 +1,63 @@
+package notifications
+
+import (
+	"context"
+
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/errors"
+)
+
+// Sender delivers messages, e.g. SMTP or Webhook.
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// SenderFunc is a function which implements Sender.
+type SenderFunc func(ctx context.Context, msg Message) error
+
+// Send calls f.
+func (f SenderFunc) Send(ctx context.Context, msg Message) error {
+	return f(ctx, msg)
+}
+
+// Notifier renders summaries and sends them with its senders.
+type Notifier struct {
+	// Formatter renders the messages, DefaultFormatter is used if it is nil.
+	Formatter *Formatter
+
+	Senders []Sender
+
+	// SkipSuccess only sends notifications for runs with warnings or
+	// errors.
+	SkipSuccess bool
+}
+
+// Notify renders s and sends it with all senders. A sender which fails does
+// not stop the others, the first error is returned.
+func (n *Notifier) Notify(ctx context.Context, s Summary) error {
+	if n.SkipSuccess && s.Status() == StatusSuccess {
+		return nil
+	}
+
+	f := n.Formatter
+	if f == nil {
+		f = DefaultFormatter
+	}
+	msg, err := f.Render(s)
+	if err != nil {
+		return err
+	}
+
+	var firstErr error
+	for i, sender := range n.Senders {
+		err := sender.Send(ctx, msg)
+		if err == nil {
+			continue
+		}
+		debug.Log("sender %d failed: %v", i, err)
+		if firstErr == nil {
+			firstErr = errors.Wrap(err, "send notification")
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,222 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/konidev20/rapi/internal/errors"
+)
+
+// SMTP sends messages by e-mail. If the server offers STARTTLS, the
+// connection is encrypted, credentials are only sent over encrypted
+// connections or to localhost.
+type SMTP struct {
+	// Addr is the address of the server as "host:port".
+	Addr string
+
+	// Username and Password are used for PLAIN authentication if Username
+	// is set.
+	Username string
+	Password string
+
+	From string
+	To   []string
+
+	// ImplicitTLS connects with TLS right away, as required on port 465,
+	// instead of using STARTTLS.
+	ImplicitTLS bool
+
+	// TLSConfig is used for the TLS connection, may be nil.
+	TLSConfig *tls.Config
+
+	// Timeout of the delivery, it defaults to 30 seconds.
+	Timeout time.Duration
+}
+
+// Send sends msg to all recipients.
+func (s *SMTP) Send(ctx context.Context, msg Message) error {
+	if len(s.To) == 0 {
+		return errors.New("no recipients")
+	}
+	from, err := mail.ParseAddress(s.From)
+	if err != nil {
+		return errors.Errorf("invalid sender %q: %v", s.From, err)
+	}
+	to := make([]*mail.Address, 0, len(s.To))
+	for _, addr := range s.To {
+		a, err := mail.ParseAddress(addr)
+		if err != nil {
+			return errors.Errorf("invalid recipient %q: %v", addr, err)
+		}
+		to = append(to, a)
+	}
+
+	data, err := buildMail(from, to, msg, time.Now())
+	if err != nil {
+		return err
+	}
+
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	host, _, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return errors.Errorf("invalid server address %q: %v", s.Addr, err)
+	}
+	tlsConfig := &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+	if s.TLSConfig != nil {
+		tlsConfig = s.TLSConfig.Clone()
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = host
+		}
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	// net/smtp does not support contexts, the connection is closed instead
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-done:
+		}
+	}()
+
+	if s.ImplicitTLS {
+		conn = tls.Client(conn, tlsConfig)
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		_ = conn.Close()
+		return errors.Wrapf(err, "connect to %v", s.Addr)
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+
+	if err := c.Hello(localName()); err != nil {
+		return errors.Wrap(err, "HELO")
+	}
+	if ok, _ := c.Extension("STARTTLS"); ok && !s.ImplicitTLS {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return errors.Wrap(err, "STARTTLS")
+		}
+	}
+	if s.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.Username, s.Password, host)); err != nil {
+			return errors.Wrap(err, "authentication")
+		}
+	}
+
+	if err := c.Mail(from.Address); err != nil {
+		return errors.Wrap(err, "MAIL FROM")
+	}
+	for _, a := range to {
+		if err := c.Rcpt(a.Address); err != nil {
+			return errors.Wrapf(err, "RCPT TO %v", a.Address)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return errors.Wrap(err, "DATA")
+	}
+	if _, err := w.Write(data); err != nil {
+		return errors.Wrap(err, "DATA")
+	}
+	if err := w.Close(); err != nil {
+		return errors.Wrap(err, "DATA")
+	}
+	return errors.Wrap(c.Quit(), "QUIT")
+}
+
+// localName returns the name used in the HELO command.
+func localName() string {
+	name, err := os.Hostname()
+	if err != nil || name == "" || strings.ContainsAny(name, " \r\n") {
+		return "localhost"
+	}
+	return name
+}
+
+// buildMail returns the e-mail with the text and, if it is set, the HTML
+// version of msg.
+func buildMail(from *mail.Address, to []*mail.Address, msg Message, date time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	recipients := make([]string, 0, len(to))
+	for _, a := range to {
+		recipients = append(recipients, a.String())
+	}
+
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(recipients, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+
+	if msg.HTML == "" {
+		fmt.Fprintf(&buf, "Content-Type: text/plain; charset=utf-8\r\n")
+		fmt.Fprintf(&buf, "Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		if err := writeQuotedPrintable(&buf, msg.Text); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	mw := multipart.NewWriter(&buf)
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", mw.Boundary())
+	for _, part := range []struct {
+		contentType string
+		body        string
+	}{
+		{"text/plain; charset=utf-8", msg.Text},
+		{"text/html; charset=utf-8", msg.HTML},
+	} {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if err := writeQuotedPrintable(pw, part.body); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return buf.Bytes(), nil
+}
+
+func writeQuotedPrintable(w io.Writer, s string) error {
+	qw := quotedprintable.NewWriter(w)
+	if _, err := qw.Write([]byte(s)); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(qw.Close())
+}
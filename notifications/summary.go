@@ -0,0 +1,117 @@
+// Package notifications reports the outcome of operations, e.g. backups run
+// by a scheduler, by e-mail or to a webhook. A Summary of the run is rendered
+// into a text and an HTML message with templates, which can be replaced, and
+// sent by the Senders of a Notifier.
+package notifications
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/konidev20/rapi"
+	"github.com/konidev20/rapi/restic"
+)
+
+// MaxWarnings is the number of warnings included in a Summary created by
+// FromBackup, the others are only counted.
+var MaxWarnings = 100
+
+// Status is the outcome of a run.
+type Status string
+
+// The outcomes of a run.
+const (
+	StatusSuccess Status = "success"
+	// StatusWarning means that the operation completed with problems, e.g.
+	// a snapshot was saved, but some files could not be read.
+	StatusWarning Status = "warning"
+	StatusFailure Status = "failure"
+)
+
+// Summary describes a run of an operation.
+type Summary struct {
+	// Operation is the name of the operation, e.g. "backup".
+	Operation  string `json:"operation"`
+	Repository string `json:"repository,omitempty"`
+	Hostname   string `json:"hostname,omitempty"`
+
+	// SnapshotID is the ID of the saved snapshot, if any.
+	SnapshotID string `json:"snapshot_id,omitempty"`
+
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+
+	// Stats are the statistics of a backup, may be nil.
+	Stats *restic.SnapshotSummary `json:"stats,omitempty"`
+
+	// Warnings are the problems which did not stop the operation,
+	// WarningsOmitted is the number of warnings which are not listed.
+	Warnings        []string `json:"warnings,omitempty"`
+	WarningsOmitted int      `json:"warnings_omitted,omitempty"`
+
+	// Error is the error of the operation, if it failed.
+	Error string `json:"error,omitempty"`
+}
+
+// Status returns the outcome of the run. A run which failed but still saved a
+// snapshot, like a partial backup, has StatusWarning.
+func (s Summary) Status() Status {
+	switch {
+	case s.Error != "" && s.SnapshotID == "":
+		return StatusFailure
+	case s.Error != "" || len(s.Warnings) > 0 || s.WarningsOmitted > 0:
+		return StatusWarning
+	default:
+		return StatusSuccess
+	}
+}
+
+// Duration returns the time the run took.
+func (s Summary) Duration() time.Duration {
+	if s.Start.IsZero() || s.End.IsZero() {
+		return 0
+	}
+	return s.End.Sub(s.Start)
+}
+
+// MarshalJSON implements json.Marshaler, the status and the duration in
+// seconds are included.
+func (s Summary) MarshalJSON() ([]byte, error) {
+	type summary Summary
+	return json.Marshal(struct {
+		summary
+		Status   Status  `json:"status"`
+		Duration float64 `json:"duration_seconds"`
+	}{summary(s), s.Status(), s.Duration().Seconds()})
+}
+
+// FromBackup returns the summary of a backup, err is the error returned by
+// rapi.Backup. The repository is not known to the result, it can be set by
+// the caller.
+func FromBackup(res rapi.BackupResult, err error) Summary {
+	s := Summary{Operation: "backup"}
+	if res.Snapshot != nil {
+		s.Hostname = res.Snapshot.Hostname
+		s.Start = res.Snapshot.Time
+		s.End = res.Snapshot.Time
+		if sum := res.Snapshot.Summary; sum != nil {
+			s.Start, s.End = sum.BackupStart, sum.BackupEnd
+			s.Stats = sum
+		}
+	}
+	if !res.ID.IsNull() {
+		s.SnapshotID = res.ID.String()
+	}
+
+	for i, w := range res.Warnings {
+		if i >= MaxWarnings {
+			s.WarningsOmitted = len(res.Warnings) - i
+			break
+		}
+		s.Warnings = append(s.Warnings, w.String())
+	}
+	if err != nil {
+		s.Error = err.Error()
+	}
+	return s
+}
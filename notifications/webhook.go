@@ -0,0 +1,119 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/konidev20/rapi/internal/errors"
+)
+
+// defaultTimeout is the timeout of requests and connections without a
+// Timeout.
+const defaultTimeout = 30 * time.Second
+
+// Webhook sends messages to a URL with a POST request. The body is the JSON
+// object {"subject": ..., "text": ..., "summary": {...}}, unless a Body
+// template is set, which is executed with the Message. For example, a Slack
+// incoming webhook expects the body template `{"text": {{json .Text}}}`.
+type Webhook struct {
+	URL string
+
+	// Body is the template of the request body, may be nil.
+	Body *template.Template
+
+	// ContentType defaults to "application/json".
+	ContentType string
+
+	// Header is added to the request, e.g. for an Authorization header.
+	Header http.Header
+
+	// Client sends the request, it defaults to http.DefaultClient.
+	Client *http.Client
+
+	// Timeout of the request, it defaults to 30 seconds.
+	Timeout time.Duration
+}
+
+// NewWebhook returns a webhook which sends the messages to url. If body is
+// not empty, it is parsed as the template of the request body.
+func NewWebhook(url, body string) (*Webhook, error) {
+	w := &Webhook{URL: url}
+	if body != "" {
+		tmpl, err := template.New("body").Funcs(Funcs).Parse(body)
+		if err != nil {
+			return nil, errors.Fatalf("invalid body template: %v", err)
+		}
+		w.Body = tmpl
+	}
+	return w, nil
+}
+
+// Send sends msg, a response with a status other than 2xx is an error.
+func (w *Webhook) Send(ctx context.Context, msg Message) error {
+	var body bytes.Buffer
+	if w.Body != nil {
+		if err := w.Body.Execute(&body, msg); err != nil {
+			return errors.Wrap(err, "body template")
+		}
+	} else {
+		err := json.NewEncoder(&body).Encode(struct {
+			Subject string  `json:"subject"`
+			Text    string  `json:"text"`
+			Summary Summary `json:"summary"`
+		}{msg.Subject, msg.Text, msg.Summary})
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	timeout := w.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, &body)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for k, v := range w.Header {
+		req.Header[k] = v
+	}
+	contentType := w.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	// the URL may contain a token, it is not included in errors
+	url, _, _ := strings.Cut(w.URL, "?")
+	resp, err := client.Do(req)
+	if err != nil {
+		var uerr *neturl.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return errors.Wrapf(err, "POST %v", url)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	text, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf("POST %v: unexpected status %v: %s", url, resp.Status, bytes.TrimSpace(text))
+	}
+	return nil
+}
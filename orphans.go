@@ -0,0 +1,231 @@
+package rapi
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/internal/index"
+	"github.com/konidev20/rapi/restic"
+)
+
+// OrphanAction selects what CleanupOrphans does with orphaned packs.
+type OrphanAction int
+
+const (
+	// OrphanReport only reports the orphaned packs.
+	OrphanReport OrphanAction = iota
+	// OrphanRemove removes the orphaned packs.
+	OrphanRemove
+	// OrphanAdopt adds the orphaned packs to the index, so that their blobs
+	// can be used again. Packs whose header cannot be read are removed.
+	OrphanAdopt
+)
+
+// OrphanOptions configure CleanupOrphans.
+type OrphanOptions struct {
+	Action OrphanAction
+
+	// MinAge is the safety horizon: a pack is only removed or adopted once
+	// it was found without index for at least MinAge, as uploads save the
+	// index of their packs only at the end. The backends do not report the
+	// age of files, so the time a pack was first found is recorded in
+	// StatePath. Without StatePath, MinAge must be zero.
+	MinAge time.Duration
+
+	// StatePath is a local file which records when the orphaned packs of
+	// the repository were found first.
+	StatePath string
+}
+
+// OrphanResult describes the packs handled by CleanupOrphans.
+type OrphanResult struct {
+	// Orphans are all packs which are not referenced by any index.
+	Orphans restic.IDSet `json:"orphans"`
+	// Bytes is the size of the orphaned packs.
+	Bytes uint64 `json:"bytes"`
+	// Young are the orphaned packs within the safety horizon.
+	Young restic.IDSet `json:"young"`
+
+	Removed restic.IDs `json:"removed"`
+	Adopted restic.IDs `json:"adopted"`
+	// Index is the index file which references the adopted packs.
+	Index *restic.ID `json:"index,omitempty"`
+}
+
+// CleanupOrphans finds packs which are stored in the backend but not
+// referenced by any index, for example the packs of an interrupted backup,
+// and removes or adopts those beyond the safety horizon. Unlike Prune, the
+// snapshots are not read. The repository is locked exclusively unless
+// opts.Action is OrphanReport. The index of repo must be loaded again to use
+// the adopted packs.
+func CleanupOrphans(ctx context.Context, repo restic.Repository, opts OrphanOptions) (OrphanResult, error) {
+	result := OrphanResult{
+		Orphans: restic.NewIDSet(),
+		Young:   restic.NewIDSet(),
+	}
+	if opts.MinAge > 0 && opts.StatePath == "" {
+		return result, errors.Fatal("MinAge requires StatePath")
+	}
+
+	if opts.Action != OrphanReport {
+		unlock, err := lockRepository(ctx, repo, true)
+		if err != nil {
+			return result, err
+		}
+		defer unlock()
+	}
+
+	indexed := restic.NewIDSet()
+	err := index.ForAllIndexes(ctx, repo, repo, func(_ restic.ID, idx *index.Index, _ bool, err error) error {
+		if err != nil {
+			return err
+		}
+		indexed.Merge(idx.Packs())
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	sizes := make(map[restic.ID]int64)
+	err = repo.List(ctx, restic.PackFile, func(id restic.ID, size int64) error {
+		if !indexed.Has(id) {
+			result.Orphans.Insert(id)
+			result.Bytes += uint64(size)
+			sizes[id] = size
+		}
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	now := restic.Now(ctx)
+	state, err := loadOrphanState(opts.StatePath)
+	if err != nil {
+		return result, err
+	}
+	seen := make(map[restic.ID]time.Time, len(result.Orphans))
+	var expired restic.IDs
+	for id := range result.Orphans {
+		first, ok := state[id]
+		if !ok {
+			first = now
+		}
+		seen[id] = first
+		if now.Sub(first) < opts.MinAge {
+			result.Young.Insert(id)
+		} else {
+			expired = append(expired, id)
+		}
+	}
+	debug.Log("found %d orphaned packs, %d within the safety horizon", len(result.Orphans), len(result.Young))
+
+	adopt := make(map[restic.ID][]restic.Blob)
+	var remove restic.IDs
+	switch opts.Action {
+	case OrphanRemove:
+		remove = expired
+	case OrphanAdopt:
+		for _, id := range expired {
+			blobs, _, err := repo.ListPack(ctx, id, sizes[id])
+			if err != nil {
+				if ctx.Err() != nil {
+					return result, ctx.Err()
+				}
+				debug.Log("unable to read header of pack %v: %v", id.Str(), err)
+				remove = append(remove, id)
+				continue
+			}
+			adopt[id] = blobs
+		}
+	}
+
+	if len(adopt) > 0 {
+		idx := index.NewIndex()
+		for id, blobs := range adopt {
+			idx.StorePack(id, blobs)
+		}
+		idx.Finalize()
+		id, err := index.SaveIndex(ctx, repo, idx)
+		if err != nil {
+			return result, err
+		}
+		result.Index = &id
+		for id := range adopt {
+			result.Adopted = append(result.Adopted, id)
+			delete(seen, id)
+		}
+	}
+
+	for _, id := range remove {
+		h := backend.Handle{Type: restic.PackFile, Name: id.String()}
+		if err := repo.Backend().Remove(ctx, h); err != nil {
+			return result, err
+		}
+		result.Removed = append(result.Removed, id)
+		delete(seen, id)
+	}
+
+	return result, saveOrphanState(opts.StatePath, seen)
+}
+
+// loadOrphanState reads the times the orphaned packs were found first. A
+// missing file or an empty path yield an empty state.
+func loadOrphanState(path string) (map[restic.ID]time.Time, error) {
+	state := make(map[restic.ID]time.Time)
+	if path == "" {
+		return state, nil
+	}
+
+	buf, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "ReadFile")
+	}
+	var packs map[string]time.Time
+	if err := json.Unmarshal(buf, &packs); err != nil {
+		return nil, errors.Fatalf("invalid orphan state %v: %v", path, err)
+	}
+	for name, first := range packs {
+		id, err := restic.ParseID(name)
+		if err != nil {
+			return nil, errors.Fatalf("invalid orphan state %v: %v", path, err)
+		}
+		state[id] = first
+	}
+	return state, nil
+}
+
+// saveOrphanState stores the times the remaining orphaned packs were found
+// first, or removes the file if there are none. Packs which were indexed
+// meanwhile are not orphaned anymore and are dropped from the state.
+func saveOrphanState(path string, seen map[restic.ID]time.Time) error {
+	if path == "" {
+		return nil
+	}
+	if len(seen) == 0 {
+		err := os.Remove(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return errors.Wrap(err, "Remove")
+	}
+
+	packs := make(map[string]time.Time, len(seen))
+	for id, first := range seen {
+		packs[id.String()] = first
+	}
+	buf, err := json.Marshal(packs)
+	if err != nil {
+		return errors.Wrap(err, "Marshal")
+	}
+	return errors.Wrap(os.WriteFile(path, buf, 0600), "WriteFile")
+}
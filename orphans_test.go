@@ -0,0 +1,85 @@
+package rapi_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/konidev20/rapi"
+	"github.com/konidev20/rapi/backend"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/restic"
+)
+
+// saveOrphanPack stores the data blobs with the given seeds in a new pack and
+// removes the index file which references it.
+func saveOrphanPack(t *testing.T, repo restic.Repository, seeds ...int) (restic.ID, restic.IDs) {
+	before := listIndexes(t, repo)
+	id, blobs := savePack(t, repo, seeds...)
+	for idx := range listIndexes(t, repo) {
+		if !before.Has(idx) {
+			rtest.OK(t, repo.Backend().Remove(context.TODO(), backend.Handle{Type: restic.IndexFile, Name: idx.String()}))
+		}
+	}
+	return id, blobs
+}
+
+func TestCleanupOrphans(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.TestRepository(t)
+
+	indexed, _ := savePack(t, repo, 1)
+	orphan, blobs := saveOrphanPack(t, repo, 2, 3)
+	damaged := restic.NewRandomID()
+	rtest.OK(t, repo.Backend().Save(ctx, backend.Handle{Type: restic.PackFile, Name: damaged.String()},
+		backend.NewByteReader([]byte("not a pack"), repo.Backend().Hasher())))
+
+	_, err := rapi.CleanupOrphans(ctx, repo, rapi.OrphanOptions{MinAge: time.Hour})
+	rtest.Assert(t, err != nil, "MinAge without StatePath was accepted")
+
+	// the orphans are only recorded while they are within the horizon
+	state := filepath.Join(rtest.TempDir(t), "orphans.json")
+	opts := rapi.OrphanOptions{Action: rapi.OrphanAdopt, MinAge: time.Hour, StatePath: state}
+	res, err := rapi.CleanupOrphans(ctx, repo, opts)
+	rtest.OK(t, err)
+	rtest.Equals(t, restic.NewIDSet(orphan, damaged), res.Orphans)
+	rtest.Equals(t, res.Orphans, res.Young)
+	rtest.Equals(t, 0, len(res.Adopted)+len(res.Removed))
+	rtest.Equals(t, restic.NewIDSet(indexed, orphan, damaged), listPacks(t, repo))
+
+	// pretend that the orphans were found two hours ago
+	buf, err := json.Marshal(map[string]time.Time{
+		orphan.String():  time.Now().Add(-2 * time.Hour),
+		damaged.String(): time.Now().Add(-2 * time.Hour),
+	})
+	rtest.OK(t, err)
+	rtest.OK(t, os.WriteFile(state, buf, 0600))
+
+	res, err = rapi.CleanupOrphans(ctx, repo, opts)
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, len(res.Young))
+	rtest.Equals(t, restic.IDs{orphan}, res.Adopted)
+	rtest.Equals(t, restic.IDs{damaged}, res.Removed)
+	rtest.Assert(t, res.Index != nil, "no index was written")
+	rtest.Equals(t, restic.NewIDSet(indexed, orphan), listPacks(t, repo))
+	_, err = os.Stat(state)
+	rtest.Assert(t, errors.Is(err, os.ErrNotExist), "state file was not removed")
+
+	checkBlobsLoadable(t, repo, blobs)
+	chk, err := rapi.Check(ctx, repo, rapi.CheckOptions{})
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, len(chk.Errors))
+	rtest.Equals(t, 0, countLocks(t, repo))
+
+	// without a horizon, orphans are removed immediately
+	orphan, _ = saveOrphanPack(t, repo, 4)
+	res, err = rapi.CleanupOrphans(ctx, repo, rapi.OrphanOptions{Action: rapi.OrphanRemove})
+	rtest.OK(t, err)
+	rtest.Equals(t, restic.IDs{orphan}, res.Removed)
+	rtest.Equals(t, 2, len(listPacks(t, repo)))
+}
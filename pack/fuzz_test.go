@@ -0,0 +1,42 @@
+package pack_test
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/konidev20/rapi/pack"
+)
+
+func headerEntry(tpe byte, length, uncompressedLength uint32) []byte {
+	buf := []byte{tpe}
+	buf = binary.LittleEndian.AppendUint32(buf, length)
+	if tpe >= 2 {
+		buf = binary.LittleEndian.AppendUint32(buf, uncompressedLength)
+	}
+	return append(buf, make([]byte, 32)...)
+}
+
+func FuzzDecodeHeader(f *testing.F) {
+	f.Add(headerEntry(0, 100, 0))
+	f.Add(append(headerEntry(1, 50, 0), headerEntry(3, 60, 200)...))
+	f.Add(headerEntry(2, 0xffffffff, 1))
+	f.Add([]byte{4, 0, 0})
+
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		blobs, err := pack.DecodeHeader(buf)
+		if err != nil {
+			return
+		}
+
+		var end uint64
+		for _, blob := range blobs {
+			if uint64(blob.Offset) != end {
+				t.Fatalf("blob %v has offset %d, want %d", blob.ID.Str(), blob.Offset, end)
+			}
+			end += uint64(blob.Length)
+		}
+		if size := pack.CalculateHeaderSize(blobs) - pack.CalculateHeaderSize(nil); size != len(buf) {
+			t.Fatalf("header of %d blobs has size %d, decoded from %d bytes", len(blobs), size, len(buf))
+		}
+	})
+}
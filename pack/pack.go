@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
 	"sync"
 
 	"github.com/konidev20/rapi/internal/debug"
@@ -22,6 +23,9 @@ type Packer struct {
 	k     *crypto.Key
 	wr    io.Writer
 
+	// synthetic derives the nonce of the header from its contents.
+	synthetic bool
+
 	m sync.Mutex
 }
 
@@ -30,6 +34,15 @@ func NewPacker(k *crypto.Key, wr io.Writer) *Packer {
 	return &Packer{k: k, wr: wr}
 }
 
+// UseSyntheticNonce makes the packer encrypt the header with a nonce derived
+// from its contents, see crypto.Key.NewSyntheticNonce.
+func (p *Packer) UseSyntheticNonce() {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.synthetic = true
+}
+
 // Add saves the data read from rd as a new blob to the packer. Returned is the
 // number of bytes written to the pack plus the pack header entry size.
 func (p *Packer) Add(t restic.BlobType, id restic.ID, data []byte, uncompressedLength int) (int, error) {
@@ -80,7 +93,12 @@ func (p *Packer) Finalize() error {
 	}
 
 	encryptedHeader := make([]byte, 0, crypto.CiphertextLength(len(header)))
-	nonce := crypto.NewRandomNonce()
+	var nonce []byte
+	if p.synthetic {
+		nonce = p.k.NewSyntheticNonce(header)
+	} else {
+		nonce = crypto.NewRandomNonce()
+	}
 	encryptedHeader = append(encryptedHeader, nonce...)
 	encryptedHeader = p.k.Seal(encryptedHeader, nonce, header, nil)
 
@@ -294,23 +312,39 @@ func List(k *crypto.Key, rd io.ReaderAt, size int64) (entries []restic.Blob, hdr
 		return nil, 0, err
 	}
 
+	entries, err = DecodeHeader(buf)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return entries, hdrSize, nil
+}
+
+// DecodeHeader parses the decrypted header of a pack file and returns the
+// blobs listed in it, with offsets relative to the beginning of the pack. buf
+// may contain arbitrary data, an error is returned if it is not a valid
+// header.
+func DecodeHeader(buf []byte) ([]restic.Blob, error) {
 	// might over allocate a bit if all blobs have EntrySize but only by a few percent
-	entries = make([]restic.Blob, 0, uint(len(buf))/plainEntrySize)
+	entries := make([]restic.Blob, 0, uint(len(buf))/plainEntrySize)
 
-	pos := uint(0)
+	pos := uint64(0)
 	for len(buf) > 0 {
 		entry, headerSize, err := parseHeaderEntry(buf)
 		if err != nil {
-			return nil, 0, err
+			return nil, err
 		}
-		entry.Offset = pos
+		entry.Offset = uint(pos)
 
 		entries = append(entries, entry)
-		pos += entry.Length
+		pos += uint64(entry.Length)
+		if pos > math.MaxUint32 {
+			return nil, errors.New("invalid header, pack is too large")
+		}
 		buf = buf[headerSize:]
 	}
 
-	return entries, hdrSize, nil
+	return entries, nil
 }
 
 func parseHeaderEntry(p []byte) (b restic.Blob, size uint, err error) {
@@ -340,6 +374,9 @@ func parseHeaderEntry(p []byte) (b restic.Blob, size uint, err error) {
 			return b, size, err
 		}
 		b.UncompressedLength = uint(binary.LittleEndian.Uint32(p[0:4]))
+		if b.UncompressedLength == 0 {
+			return b, size, errors.New("parseHeaderEntry: compressed blob without uncompressed length")
+		}
 		p = p[4:]
 	}
 
@@ -0,0 +1,50 @@
+package rapi
+
+import (
+	"context"
+
+	"github.com/konidev20/rapi/backend/location"
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/keychain"
+)
+
+// PasswordProvider returns the password of the repository at a location,
+// passwords contained in the location are removed before.
+// keychain.Keychain implements it with the credential store of the OS.
+type PasswordProvider interface {
+	Password(ctx context.Context, repo string) (string, error)
+}
+
+// PasswordProviderFunc is a function which implements PasswordProvider.
+type PasswordProviderFunc func(ctx context.Context, repo string) (string, error)
+
+// Password returns f(ctx, repo).
+func (f PasswordProviderFunc) Password(ctx context.Context, repo string) (string, error) {
+	return f(ctx, repo)
+}
+
+// passwordProvider returns the provider selected in opts, or nil.
+func (opts RepositoryOptions) passwordProvider() PasswordProvider {
+	if opts.PasswordProvider != nil {
+		return opts.PasswordProvider
+	}
+	if opts.Keychain != "" {
+		return keychain.New(opts.Keychain)
+	}
+	return nil
+}
+
+// readPassword returns opts.Password, or the password of the repository at
+// repo from the provider selected in opts.
+func (opts RepositoryOptions) readPassword(ctx context.Context, repo string) (string, error) {
+	provider := opts.passwordProvider()
+	if opts.Password != "" || provider == nil {
+		return opts.Password, nil
+	}
+
+	password, err := provider.Password(ctx, location.StripPassword(opts.backends, repo))
+	if err != nil {
+		return "", errors.Fatalf("unable to read the password: %v", err)
+	}
+	return password, nil
+}
@@ -0,0 +1,79 @@
+package rapi_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/konidev20/rapi"
+	"github.com/konidev20/rapi/backend/local"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/ui/events"
+)
+
+func TestPasswordProvider(t *testing.T) {
+	ctx := context.Background()
+	dir := rtest.TempDir(t)
+	be, err := local.Create(ctx, local.Config{Path: dir, Connections: 2})
+	rtest.OK(t, err)
+	repository.TestRepositoryWithBackend(t, be, 0)
+	rtest.OK(t, be.Close())
+
+	var asked []string
+	opts := rapi.DefaultOptions
+	opts.Repo = dir
+	opts.NoCache = true
+	opts.PasswordProvider = rapi.PasswordProviderFunc(func(_ context.Context, repo string) (string, error) {
+		asked = append(asked, repo)
+		return rtest.TestPassword, nil
+	})
+
+	repo, err := rapi.OpenRepository(ctx, opts)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.Close())
+	rtest.Equals(t, []string{dir}, asked)
+
+	// an explicit password takes precedence
+	opts.Password = "wrong"
+	_, err = rapi.OpenRepository(ctx, opts)
+	rtest.Assert(t, err != nil, "wrong password accepted")
+	rtest.Equals(t, 1, len(asked))
+}
+
+func TestOpenRepositoryEvents(t *testing.T) {
+	ctx := context.Background()
+	dir := rtest.TempDir(t)
+	be, err := local.Create(ctx, local.Config{Path: dir, Connections: 2})
+	rtest.OK(t, err)
+	repository.TestRepositoryWithBackend(t, be, 0)
+	rtest.OK(t, be.Close())
+
+	open := func(password string) ([]events.Type, error) {
+		ch := make(chan events.Event, 10)
+		opts := rapi.DefaultOptions
+		opts.Repo = dir
+		opts.NoCache = true
+		opts.Password = password
+		opts.Events = events.NewChannel(ch)
+
+		repo, err := rapi.OpenRepository(ctx, opts)
+		if err == nil {
+			rtest.OK(t, repo.Close())
+		}
+		close(ch)
+
+		var types []events.Type
+		for ev := range ch {
+			types = append(types, ev.Type)
+		}
+		return types, err
+	}
+
+	types, err := open(rtest.TestPassword)
+	rtest.OK(t, err)
+	rtest.Equals(t, []events.Type{events.OperationStarted, events.Summary}, types)
+
+	types, err = open("wrong")
+	rtest.Assert(t, err != nil, "wrong password accepted")
+	rtest.Equals(t, []events.Type{events.OperationStarted, events.Error, events.Summary}, types)
+}
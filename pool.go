@@ -0,0 +1,221 @@
+package rapi
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/restic"
+)
+
+// ErrPoolClosed is returned by Pool.Use after the pool was closed.
+var ErrPoolClosed = errors.New("repository pool is closed")
+
+// Pool multiplexes operations over several handles of the same repository.
+// A single Repository can only run one operation which saves blobs at a
+// time, as the pack uploader is started and flushed per operation. A pool
+// gives each operation a handle of its own. It is safe for concurrent use.
+//
+// Each handle has its own index. Operations which need an exclusive lock,
+// such as Prune, modify or remove files of the repository and must run
+// through Maintain, which loads the index of all handles again afterwards.
+// Exclusive locks on the handles passed by Use and Each are rejected.
+type Pool struct {
+	repos []*repository.Repository
+	free  chan *repository.Repository
+
+	// eachMu serializes calls to Each and Maintain, which hold several
+	// handles at once
+	eachMu sync.Mutex
+
+	// maintained is the handle passed to the function of Maintain, it is
+	// the only one which may be locked exclusively
+	maintained atomic.Pointer[repository.Repository]
+
+	done chan struct{}
+	once sync.Once
+}
+
+// OpenPool opens size handles of the repository configured in opts, they
+// share the HTTP transport. If size is zero, one handle per CPU is opened.
+// The index is not loaded automatically, see Pool.Each.
+func OpenPool(ctx context.Context, opts RepositoryOptions, size int) (*Pool, error) {
+	if size < 0 {
+		return nil, errors.Fatalf("invalid pool size %d", size)
+	}
+	if size == 0 {
+		size = runtime.GOMAXPROCS(0)
+	}
+
+	if opts.transport == nil {
+		var err error
+		opts.transport, err = backend.Transport(opts.TransportOptions)
+		if err != nil {
+			return nil, errors.Fatal(err.Error())
+		}
+	}
+
+	p := &Pool{
+		free: make(chan *repository.Repository, size),
+		done: make(chan struct{}),
+	}
+	for i := 0; i < size; i++ {
+		repo, err := OpenRepository(ctx, opts)
+		if err != nil {
+			p.closeAll()
+			return nil, err
+		}
+		p.repos = append(p.repos, repo)
+		p.free <- repo
+		pools.Store(repo, p)
+	}
+
+	debug.Log("opened pool of %d repository handles", size)
+	return p, nil
+}
+
+// Size returns the number of handles in the pool.
+func (p *Pool) Size() int {
+	return len(p.repos)
+}
+
+// Use calls fn with a handle which is not used by another call at the same
+// time, it waits until one is free. The handle must not be used after fn
+// returned.
+func (p *Pool) Use(ctx context.Context, fn func(*repository.Repository) error) error {
+	select {
+	case <-p.done:
+		return ErrPoolClosed
+	default:
+	}
+
+	var repo *repository.Repository
+	select {
+	case repo = <-p.free:
+	case <-p.done:
+		return ErrPoolClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { p.free <- repo }()
+
+	return fn(repo)
+}
+
+// Each calls fn with every handle in turn, for example to load the index.
+// It waits until each handle is free and stops at the first error.
+func (p *Pool) Each(ctx context.Context, fn func(*repository.Repository) error) error {
+	p.eachMu.Lock()
+	defer p.eachMu.Unlock()
+
+	var repos []*repository.Repository
+	defer func() {
+		for _, repo := range repos {
+			p.free <- repo
+		}
+	}()
+
+	for range p.repos {
+		select {
+		case repo := <-p.free:
+			repos = append(repos, repo)
+			if err := fn(repo); err != nil {
+				return err
+			}
+		case <-p.done:
+			return ErrPoolClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Maintain calls fn with a single handle once no other handle is in use,
+// for operations which need an exclusive lock, such as Prune. Afterwards the
+// index of every handle is loaded again, also if fn failed, so that no
+// handle refers to removed packs. The first error is returned.
+func (p *Pool) Maintain(ctx context.Context, fn func(*repository.Repository) error) error {
+	p.eachMu.Lock()
+	defer p.eachMu.Unlock()
+
+	var repos []*repository.Repository
+	defer func() {
+		for _, repo := range repos {
+			p.free <- repo
+		}
+	}()
+
+	for range p.repos {
+		select {
+		case repo := <-p.free:
+			repos = append(repos, repo)
+		case <-p.done:
+			return ErrPoolClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	p.maintained.Store(repos[0])
+	err := fn(repos[0])
+	p.maintained.Store(nil)
+
+	for _, repo := range repos {
+		if lerr := repo.LoadIndex(ctx, nil); lerr != nil && err == nil {
+			err = lerr
+		}
+	}
+	return err
+}
+
+// pools maps the handles of all open pools to their pool.
+var pools sync.Map
+
+// checkPoolMaintenance returns an error if repo is a handle of a pool which
+// was not passed by Maintain. The other handles of the pool would keep
+// using an outdated index after an exclusive operation.
+func checkPoolMaintenance(repo restic.Repository) error {
+	r, ok := repo.(*repository.Repository)
+	if !ok {
+		return nil
+	}
+	p, ok := pools.Load(r)
+	if !ok || p.(*Pool).maintained.Load() == r {
+		return nil
+	}
+	return errors.Fatal("exclusive operations on a pooled repository must run through Pool.Maintain")
+}
+
+// Close waits until all handles are free and closes them. The first error
+// is returned.
+func (p *Pool) Close() error {
+	var err error
+	p.once.Do(func() {
+		close(p.done)
+		for range p.repos {
+			<-p.free
+		}
+		err = p.closeAll()
+	})
+	return err
+}
+
+func (p *Pool) closeAll() error {
+	var firstErr error
+	for _, repo := range p.repos {
+		pools.Delete(repo)
+		if err := repo.Close(); err != nil {
+			debug.Log("unable to close repository handle: %v", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,178 @@
+package rapi_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/konidev20/rapi"
+	"github.com/konidev20/rapi/backend/local"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/restic"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestPool(t *testing.T) {
+	ctx := context.Background()
+	dir := rtest.TempDir(t)
+
+	be, err := local.Create(ctx, local.Config{Path: dir, Connections: 2})
+	rtest.OK(t, err)
+	repository.TestRepositoryWithBackend(t, be, 0)
+	rtest.OK(t, be.Close())
+
+	opts := rapi.DefaultOptions
+	opts.Repo = dir
+	opts.Password = rtest.TestPassword
+	opts.NoCache = true
+
+	p, err := rapi.OpenPool(ctx, opts, 3)
+	rtest.OK(t, err)
+	rtest.Equals(t, 3, p.Size())
+
+	handles := make(map[*repository.Repository]struct{})
+	rtest.OK(t, p.Each(ctx, func(repo *repository.Repository) error {
+		handles[repo] = struct{}{}
+		return repo.LoadIndex(ctx, nil)
+	}))
+	rtest.Equals(t, 3, len(handles))
+
+	// save blobs concurrently, each operation has a handle of its own
+	var inUse, maxInUse int32
+	var wg sync.WaitGroup
+	ids := make([]restic.ID, 10)
+	for i := range ids {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rtest.OK(t, p.Use(ctx, func(repo *repository.Repository) error {
+				n := atomic.AddInt32(&inUse, 1)
+				defer atomic.AddInt32(&inUse, -1)
+				for {
+					max := atomic.LoadInt32(&maxInUse)
+					if n <= max || atomic.CompareAndSwapInt32(&maxInUse, max, n) {
+						break
+					}
+				}
+
+				var wg errgroup.Group
+				repo.StartPackUploader(ctx, &wg)
+				var err error
+				ids[i], _, _, err = repo.SaveBlob(ctx, restic.DataBlob, []byte{byte(i)}, restic.ID{}, false)
+				if err != nil {
+					return err
+				}
+				if err := repo.Flush(ctx); err != nil {
+					return err
+				}
+				return wg.Wait()
+			}))
+		}()
+	}
+	wg.Wait()
+	rtest.Assert(t, maxInUse <= 3, "%d handles used at the same time", maxInUse)
+
+	rtest.OK(t, p.Each(ctx, func(repo *repository.Repository) error {
+		return repo.LoadIndex(ctx, nil)
+	}))
+	rtest.OK(t, p.Use(ctx, func(repo *repository.Repository) error {
+		for i, id := range ids {
+			buf, err := repo.LoadBlob(ctx, restic.DataBlob, id, nil)
+			rtest.OK(t, err)
+			rtest.Equals(t, []byte{byte(i)}, buf)
+		}
+		return nil
+	}))
+
+	rtest.OK(t, p.Close())
+	err = p.Use(ctx, func(*repository.Repository) error { return nil })
+	rtest.Assert(t, errors.Is(err, rapi.ErrPoolClosed), "unexpected error %v", err)
+}
+
+func TestPoolMaintain(t *testing.T) {
+	ctx := context.Background()
+	dir := rtest.TempDir(t)
+
+	be, err := local.Create(ctx, local.Config{Path: dir, Connections: 2})
+	rtest.OK(t, err)
+	repository.TestRepositoryWithBackend(t, be, 0)
+	rtest.OK(t, be.Close())
+
+	opts := rapi.DefaultOptions
+	opts.Repo = dir
+	opts.Password = rtest.TestPassword
+	opts.NoCache = true
+
+	p, err := rapi.OpenPool(ctx, opts, 2)
+	rtest.OK(t, err)
+	defer func() {
+		rtest.OK(t, p.Close())
+	}()
+	rtest.OK(t, p.Each(ctx, func(repo *repository.Repository) error {
+		return repo.LoadIndex(ctx, nil)
+	}))
+
+	// the blob is not referenced by a snapshot and removed by prune
+	var bh restic.BlobHandle
+	rtest.OK(t, p.Use(ctx, func(repo *repository.Repository) error {
+		var wg errgroup.Group
+		repo.StartPackUploader(ctx, &wg)
+		id, _, _, err := repo.SaveBlob(ctx, restic.DataBlob, []byte("unused"), restic.ID{}, false)
+		if err != nil {
+			return err
+		}
+		bh = restic.BlobHandle{Type: restic.DataBlob, ID: id}
+		if err := repo.Flush(ctx); err != nil {
+			return err
+		}
+		return wg.Wait()
+	}))
+	rtest.OK(t, p.Each(ctx, func(repo *repository.Repository) error {
+		return repo.LoadIndex(ctx, nil)
+	}))
+
+	// exclusive operations are rejected outside of Maintain
+	for name, op := range map[string]func(*repository.Repository) error{
+		"prune": func(repo *repository.Repository) error {
+			_, err := rapi.Prune(ctx, repo, rapi.PruneOptions{})
+			return err
+		},
+		"forget": func(repo *repository.Repository) error {
+			_, err := rapi.Forget(ctx, repo, rapi.ForgetOptions{})
+			return err
+		},
+		"compact": func(repo *repository.Repository) error {
+			_, err := rapi.CompactIndex(ctx, repo, rapi.CompactIndexOptions{})
+			return err
+		},
+		"coalesce": func(repo *repository.Repository) error {
+			_, err := rapi.CoalescePacks(ctx, repo, rapi.CoalesceOptions{})
+			return err
+		},
+		"empty trash": func(repo *repository.Repository) error {
+			_, err := rapi.EmptyTrash(ctx, repo, 0)
+			return err
+		},
+		"undelete": func(repo *repository.Repository) error {
+			return rapi.Undelete(ctx, repo, restic.NewRandomID())
+		},
+	} {
+		err = p.Use(ctx, op)
+		rtest.Assert(t, err != nil && strings.Contains(err.Error(), "Pool.Maintain"),
+			"%v outside of Maintain was not rejected, error %v", name, err)
+	}
+
+	rtest.OK(t, p.Maintain(ctx, func(repo *repository.Repository) error {
+		_, err := rapi.Prune(ctx, repo, rapi.PruneOptions{})
+		return err
+	}))
+	rtest.OK(t, p.Each(ctx, func(repo *repository.Repository) error {
+		rtest.Assert(t, !repo.Index().Has(bh), "handle still has the removed blob %v in its index", bh)
+		return nil
+	}))
+}
@@ -0,0 +1,9 @@
+package rapi
+
+import "github.com/konidev20/rapi/internal/errors"
+
+// SetLowPriority is not supported on js/wasm, the process priority is
+// controlled by the browser or runtime.
+func SetLowPriority() error {
+	return errors.New("setting the process priority is not supported on js/wasm")
+}
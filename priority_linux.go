@@ -0,0 +1,45 @@
+package rapi
+
+import (
+	"os"
+	"strconv"
+
+	"github.com/konidev20/rapi/internal/errors"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	ioprioWhoProcess = 1
+	ioprioClassIdle  = 3
+	ioprioClassShift = 13
+)
+
+// SetLowPriority sets the nice value 19 and the idle IO scheduling class for
+// all threads of the process, so that backups do not compete with other
+// workloads. Both are per thread on Linux, new threads inherit them from the
+// thread which creates them, so it should be called early. The priority
+// applies to the whole process and cannot be raised again without
+// privileges, it is therefore never changed by the library itself.
+func SetLowPriority() error {
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	var firstErr error
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+
+		if err := unix.Setpriority(unix.PRIO_PROCESS, tid, 19); err != nil && firstErr == nil {
+			firstErr = errors.Wrap(err, "Setpriority")
+		}
+		_, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), ioprioClassIdle<<ioprioClassShift)
+		if errno != 0 && firstErr == nil {
+			firstErr = errors.Wrap(errno, "ioprio_set")
+		}
+	}
+	return firstErr
+}
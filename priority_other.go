@@ -0,0 +1,17 @@
+//go:build !linux && !windows && !js
+// +build !linux,!windows,!js
+
+package rapi
+
+import (
+	"os"
+
+	"github.com/konidev20/rapi/internal/errors"
+	"golang.org/x/sys/unix"
+)
+
+// SetLowPriority sets the nice value 19 for the process, IO priorities are
+// not supported. See the Linux version for details.
+func SetLowPriority() error {
+	return errors.Wrap(unix.Setpriority(unix.PRIO_PROCESS, os.Getpid(), 19), "Setpriority")
+}
@@ -0,0 +1,14 @@
+package rapi
+
+import (
+	"github.com/konidev20/rapi/internal/errors"
+	"golang.org/x/sys/windows"
+)
+
+// SetLowPriority sets the background processing mode for the process, which
+// lowers its CPU, IO and memory priority. The mode applies to the whole
+// process, it is therefore never changed by the library itself.
+func SetLowPriority() error {
+	err := windows.SetPriorityClass(windows.CurrentProcess(), windows.PROCESS_MODE_BACKGROUND_BEGIN)
+	return errors.Wrap(err, "SetPriorityClass")
+}
@@ -0,0 +1,154 @@
+package rapi
+
+import (
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/konidev20/rapi/backend/location"
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/internal/options"
+	"github.com/konidev20/rapi/internal/textfile"
+)
+
+// ProfileEnv names the environment variable which selects the profile used
+// if no repository is given, see RepositoryOptions.Profile.
+const ProfileEnv = "RESTIC_REPOSITORY_PROFILE"
+
+// profilePrefix is the prefix of the environment variables which define a
+// profile, followed by the upper-case name of the profile and "_".
+const profilePrefix = "RESTIC_PROFILE_"
+
+// Profile is a named repository target defined by environment variables. For
+// the profile "offsite" these are:
+//
+//	RESTIC_PROFILE_OFFSITE_REPOSITORY     location of the repository (required)
+//	RESTIC_PROFILE_OFFSITE_PASSWORD       password of the repository
+//	RESTIC_PROFILE_OFFSITE_PASSWORD_FILE  file containing the password
+//	RESTIC_PROFILE_OFFSITE_OPTIONS        extended options, separated by spaces
+//
+// The credentials of the backend are read from the variables the backend
+// uses, with the same prefix, e.g. RESTIC_PROFILE_OFFSITE_AWS_ACCESS_KEY_ID.
+// Variables without the prefix are used for credentials the profile does not
+// define. Plain http and https URLs are accepted as repository locations, the
+// scheme of the backend is detected with location.Detect.
+type Profile struct {
+	Name    string
+	Repo    string
+	Options []string
+
+	password string
+}
+
+// envPrefix returns the prefix of the environment variables of the profile.
+func (p Profile) envPrefix() string {
+	return profileEnvPrefix(p.Name)
+}
+
+func profileEnvPrefix(name string) string {
+	return profilePrefix + strings.ToUpper(name) + "_"
+}
+
+// ListProfiles returns the profiles defined in the environment, sorted by
+// name. Profiles which cannot be resolved are skipped.
+func ListProfiles() []Profile {
+	var profiles []Profile
+	for _, env := range os.Environ() {
+		key, _, _ := strings.Cut(env, "=")
+		if !strings.HasPrefix(key, profilePrefix) || !strings.HasSuffix(key, "_REPOSITORY") {
+			continue
+		}
+		name := strings.TrimSuffix(strings.TrimPrefix(key, profilePrefix), "_REPOSITORY")
+		if name == "" {
+			continue
+		}
+
+		p, err := ResolveProfile(strings.ToLower(name))
+		if err != nil {
+			continue
+		}
+		profiles = append(profiles, p)
+	}
+
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name < profiles[j].Name })
+	return profiles
+}
+
+// ResolveProfile reads the profile name from the environment. Names are not
+// case-sensitive.
+func ResolveProfile(name string) (Profile, error) {
+	if name == "" {
+		return Profile{}, errors.Fatal("no profile name given")
+	}
+
+	p := Profile{Name: strings.ToLower(name)}
+	prefix := p.envPrefix()
+
+	p.Repo = os.Getenv(prefix + "REPOSITORY")
+	if p.Repo == "" {
+		return Profile{}, errors.Fatalf("profile %q is not defined, %vREPOSITORY is not set", p.Name, prefix)
+	}
+	p.Repo = location.Detect(p.Repo)
+	p.Options = strings.Fields(os.Getenv(prefix + "OPTIONS"))
+	if _, err := options.Parse(p.Options); err != nil {
+		return Profile{}, errors.Fatalf("invalid options in profile %q: %v", p.Name, err)
+	}
+
+	p.password = os.Getenv(prefix + "PASSWORD")
+	if file := os.Getenv(prefix + "PASSWORD_FILE"); p.password == "" && file != "" {
+		buf, err := textfile.Read(file)
+		if err != nil {
+			return Profile{}, errors.Fatalf("unable to read the password of profile %q: %v", p.Name, err)
+		}
+		p.password = strings.TrimRight(string(buf), "\r\n")
+	}
+
+	return p, nil
+}
+
+// Apply returns opts configured for the profile. The repository location,
+// password and extended options of the profile are only used if they are
+// not set in opts already.
+func (p Profile) Apply(opts RepositoryOptions) (RepositoryOptions, error) {
+	if opts.Repo == "" && opts.RepositoryFile == "" {
+		opts.Repo = p.Repo
+	}
+	if opts.Password == "" {
+		opts.Password = p.password
+	}
+
+	extended, err := options.Parse(p.Options)
+	if err != nil {
+		return opts, errors.Fatalf("invalid options in profile %q: %v", p.Name, err)
+	}
+	merged := make(options.Options, len(opts.Extended)+len(extended))
+	for k, v := range extended {
+		merged[k] = v
+	}
+	for k, v := range opts.Extended {
+		merged[k] = v
+	}
+	opts.Extended = merged
+
+	opts.Profile = p.Name
+	opts.envPrefix = p.envPrefix()
+	return opts, nil
+}
+
+// applyProfile applies the profile named in opts, or in the environment
+// variable ProfileEnv if no repository is given.
+func (opts RepositoryOptions) applyProfile() (RepositoryOptions, error) {
+	name := opts.Profile
+	if name == "" && opts.Repo == "" && opts.RepositoryFile == "" {
+		name = os.Getenv(ProfileEnv)
+	}
+	if name == "" || opts.envPrefix != "" {
+		return opts, nil
+	}
+
+	p, err := ResolveProfile(name)
+	if err != nil {
+		return opts, err
+	}
+	return p.Apply(opts)
+}
@@ -0,0 +1,55 @@
+package rapi_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/konidev20/rapi"
+	rtest "github.com/konidev20/rapi/internal/test"
+)
+
+func TestResolveProfile(t *testing.T) {
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	rtest.OK(t, os.WriteFile(passwordFile, []byte("secret\n"), 0600))
+
+	t.Setenv("RESTIC_PROFILE_OFFSITE_REPOSITORY", "https://s3.amazonaws.com/bucket/repo")
+	t.Setenv("RESTIC_PROFILE_OFFSITE_PASSWORD_FILE", passwordFile)
+	t.Setenv("RESTIC_PROFILE_OFFSITE_OPTIONS", "s3.connections=3 s3.storage-class=STANDARD_IA")
+	t.Setenv("RESTIC_PROFILE_LOCAL_BACKUP_REPOSITORY", "/srv/restic")
+
+	p, err := rapi.ResolveProfile("Offsite")
+	rtest.OK(t, err)
+	rtest.Equals(t, "offsite", p.Name)
+	rtest.Equals(t, "s3:https://s3.amazonaws.com/bucket/repo", p.Repo)
+	rtest.Equals(t, []string{"s3.connections=3", "s3.storage-class=STANDARD_IA"}, p.Options)
+
+	opts, err := p.Apply(rapi.RepositoryOptions{})
+	rtest.OK(t, err)
+	rtest.Equals(t, p.Repo, opts.Repo)
+	rtest.Equals(t, "secret", opts.Password)
+	rtest.Equals(t, "3", opts.Extended["s3.connections"])
+
+	var names []string
+	for _, p := range rapi.ListProfiles() {
+		names = append(names, p.Name)
+	}
+	rtest.Equals(t, []string{"local_backup", "offsite"}, names)
+
+	_, err = rapi.ResolveProfile("missing")
+	rtest.Assert(t, err != nil, "missing profile resolved")
+}
+
+func TestReadRepoProfile(t *testing.T) {
+	t.Setenv("RESTIC_PROFILE_LOCAL_REPOSITORY", "/srv/restic")
+	t.Setenv(rapi.ProfileEnv, "local")
+
+	repo, err := rapi.ReadRepo(rapi.RepositoryOptions{})
+	rtest.OK(t, err)
+	rtest.Equals(t, "/srv/restic", repo)
+
+	// an explicit repository takes precedence
+	repo, err = rapi.ReadRepo(rapi.RepositoryOptions{Repo: "/other"})
+	rtest.OK(t, err)
+	rtest.Equals(t, "/other", repo)
+}
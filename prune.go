@@ -0,0 +1,538 @@
+package rapi
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/hooks"
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/internal/index"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/restic"
+)
+
+// PruneOptions configure which data is removed by Prune.
+type PruneOptions struct {
+	// MaxUnusedPercent is the amount of unused data, relative to the used
+	// data, which may remain in partly used packs. Packs are repacked until
+	// the unused data is below this limit.
+	MaxUnusedPercent float64
+
+	// MinSnapshotAge protects the data of snapshots younger than this age,
+	// packs which contain blobs of these snapshots are not repacked. Fully
+	// unused packs are still removed.
+	MinSnapshotAge time.Duration
+
+	// ProtectRecentIndexes keeps the packs referenced by index files which
+	// were written after Prune loaded the index. This protects packs of
+	// clients which upload data based on stale locks or indexes.
+	ProtectRecentIndexes bool
+
+	// MaxRepackBytes limits the total size of the packs which are repacked
+	// in one run, zero means no limit.
+	MaxRepackBytes uint64
+
+	// MaxDuration stops repacking once the run took longer, the packs are
+	// then removed and the index is updated as usual. Zero means no limit.
+	MaxDuration time.Duration
+
+	// StatePath is a local file which records the packs deferred because of
+	// MaxRepackBytes or MaxDuration. The next run repacks these packs first.
+	// Without a path, the deferred packs are chosen again by their amount
+	// of unused data.
+	StatePath string
+
+	// DryRun only computes which packs would be repacked and removed.
+	DryRun bool
+
+	// Hooks are run after the prune, may be nil: the PostPrune hooks if it
+	// succeeded and the OnError hooks otherwise. Errors of the hooks are
+	// added to the warnings of the context, see restic.WithWarnings.
+	Hooks *hooks.Hooks
+}
+
+// DefaultPruneOptions are the defaults used by `restic prune`.
+var DefaultPruneOptions = PruneOptions{
+	MaxUnusedPercent:     5,
+	ProtectRecentIndexes: true,
+}
+
+// PruneStats describes the work done by Prune.
+type PruneStats struct {
+	UsedBlobs        int    `json:"used_blobs"`
+	UsedBytes        uint64 `json:"used_bytes"`
+	UnusedBlobs      int    `json:"unused_blobs"`
+	UnusedBytes      uint64 `json:"unused_bytes"`
+	RepackPacks      int    `json:"repack_packs"`
+	RemovePacks      int    `json:"remove_packs"`
+	RemoveBytes      uint64 `json:"remove_bytes"`
+	ProtectedByAge   int    `json:"protected_by_age"`
+	ProtectedByIndex int    `json:"protected_by_index"`
+
+	// UnindexedPacks is the number of packs which are not referenced by the
+	// index. Prune leaves them to CleanupOrphans.
+	UnindexedPacks int `json:"unindexed_packs"`
+
+	// DeferredPacks is the number of packs left for the next run.
+	DeferredPacks int `json:"deferred_packs"`
+}
+
+type prunePack struct {
+	usedBytes   uint64
+	unusedBytes uint64
+	unusedBlobs int
+	young       bool
+}
+
+// Prune removes data which is not referenced by any snapshot, like `restic
+// prune`. Fully unused packs are removed, partly used packs are repacked
+// according to opts. If the repack limits in opts are reached, the remaining
+// packs are reported in PruneStats.DeferredPacks and left for the next run.
+// Packs which are not referenced by the index are left to CleanupOrphans. The
+// repository is locked exclusively. If packs were removed, the index of repo
+// is loaded again.
+//
+// If ctx is cancelled while packs are repacked, the index of the packs which
+// were already written is saved and the remaining packs are recorded in the
+// state file, but no packs are removed.
+func Prune(ctx context.Context, repo restic.Repository, opts PruneOptions) (stats PruneStats, err error) {
+	start := time.Now()
+	info := hookInfo(repo, "prune", restic.Now(ctx))
+	defer func() {
+		ev := hooks.PostPrune
+		if err != nil {
+			ev, info.Err = hooks.OnError, err
+		}
+		runHooks(ctx, opts.Hooks, info, ev, restic.WarningsFromContext(ctx))
+	}()
+
+	if opts.MaxUnusedPercent < 0 {
+		return stats, errors.Fatalf("invalid MaxUnusedPercent %v", opts.MaxUnusedPercent)
+	}
+
+	op, err := beginOperation(ctx, repo, "prune", true)
+	if err != nil {
+		return stats, err
+	}
+	defer func() { op.end(ctx, err != nil) }()
+
+	// the index files present now form the horizon of the index we use,
+	// index files written later are unknown to prune
+	knownIndexes := restic.NewIDSet()
+	err = repo.List(ctx, restic.IndexFile, func(id restic.ID, _ int64) error {
+		knownIndexes.Insert(id)
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+	if err := repo.LoadIndex(ctx, nil); err != nil {
+		return stats, err
+	}
+
+	usedBlobs, youngBlobs, err := pruneUsedBlobs(ctx, repo, opts.MinSnapshotAge)
+	if err != nil {
+		return stats, err
+	}
+
+	packs := make(map[restic.ID]*prunePack)
+	seen, err := pruneEachBlob(ctx, repo, usedBlobs, func(pb restic.PackedBlob, used bool) {
+		p, ok := packs[pb.PackID]
+		if !ok {
+			p = &prunePack{}
+			packs[pb.PackID] = p
+		}
+		size := uint64(pb.Length)
+		if used {
+			p.usedBytes += size
+			stats.UsedBlobs++
+			stats.UsedBytes += size
+		} else {
+			p.unusedBytes += size
+			p.unusedBlobs++
+			stats.UnusedBlobs++
+			stats.UnusedBytes += size
+		}
+		if youngBlobs.Has(pb.BlobHandle) {
+			p.young = true
+		}
+	})
+	if err != nil {
+		return stats, err
+	}
+	for bh := range usedBlobs {
+		if !seen.Has(bh) {
+			return stats, errors.Fatalf("blob %v referenced by a snapshot is missing from the index, run check and repair", bh)
+		}
+	}
+
+	// packs which are not referenced by the index, for example packs of
+	// an interrupted backup, may still be uploaded by a running backup and
+	// are left to CleanupOrphans with its safety horizon
+	packSizes := make(map[restic.ID]int64)
+	err = repo.List(ctx, restic.PackFile, func(id restic.ID, size int64) error {
+		packSizes[id] = size
+		if _, ok := packs[id]; !ok {
+			stats.UnindexedPacks++
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	// packs referenced by index files written meanwhile are in use again
+	protected := restic.NewIDSet()
+	if opts.ProtectRecentIndexes {
+		protected, err = pruneRecentPacks(ctx, repo, knownIndexes)
+		if err != nil {
+			return stats, err
+		}
+	}
+
+	removePacks := restic.NewIDSet()
+	var candidates restic.IDs
+	for id, p := range packs {
+		if _, ok := packSizes[id]; !ok {
+			return stats, errors.Fatalf("pack %v is referenced by the index but missing, run check and repair", id.Str())
+		}
+		switch {
+		case p.unusedBlobs == 0:
+		case protected.Has(id):
+			stats.ProtectedByIndex++
+		case p.usedBytes == 0:
+			removePacks.Insert(id)
+		case p.young:
+			stats.ProtectedByAge++
+		default:
+			candidates = append(candidates, id)
+		}
+	}
+
+	// repack the packs with the highest share of unused data first, packs
+	// which were deferred by the previous run come before all others
+	plan, err := loadPrunePlan(opts.StatePath)
+	if err != nil {
+		return stats, err
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		pi, pj := packs[candidates[i]], packs[candidates[j]]
+		if plan.has(candidates[i]) != plan.has(candidates[j]) {
+			return plan.has(candidates[i])
+		}
+		return pi.unusedBytes*(pj.usedBytes+pj.unusedBytes) > pj.unusedBytes*(pi.usedBytes+pi.unusedBytes)
+	})
+
+	// the unused data of protected packs remains, it counts towards the
+	// limit like the data of young packs
+	remaining := stats.UnusedBytes
+	for id := range removePacks {
+		remaining -= packs[id].unusedBytes
+	}
+	maxUnused := uint64(float64(stats.UsedBytes) * opts.MaxUnusedPercent / 100)
+	var repackOrder restic.IDs
+	for _, id := range candidates {
+		if remaining <= maxUnused {
+			break
+		}
+		repackOrder = append(repackOrder, id)
+		remaining -= packs[id].unusedBytes
+	}
+
+	// packs beyond the size limit are left for the next run
+	var deferred restic.IDs
+	if opts.MaxRepackBytes > 0 {
+		var total uint64
+		for i, id := range repackOrder {
+			total += uint64(packSizes[id])
+			if total > opts.MaxRepackBytes {
+				deferred = append(deferred, repackOrder[i:]...)
+				repackOrder = repackOrder[:i]
+				break
+			}
+		}
+	}
+
+	debug.Log("prune: repack %d packs, defer %d packs, remove %d packs", len(repackOrder), len(deferred), len(removePacks))
+	if opts.DryRun {
+		stats.RepackPacks = len(repackOrder)
+		stats.DeferredPacks = len(deferred)
+		stats.RemovePacks = len(removePacks) + len(repackOrder)
+		for id := range removePacks {
+			stats.RemoveBytes += uint64(packSizes[id])
+		}
+		for _, id := range repackOrder {
+			stats.RemoveBytes += uint64(packSizes[id])
+		}
+		return stats, nil
+	}
+
+	var deadline time.Time
+	if opts.MaxDuration > 0 {
+		deadline = start.Add(opts.MaxDuration)
+	}
+	for len(repackOrder) > 0 {
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			debug.Log("prune: time budget exhausted, deferring %d packs", len(repackOrder))
+			break
+		}
+		if ctx.Err() != nil {
+			debug.Log("prune: cancelled, deferring %d packs", len(repackOrder))
+			break
+		}
+
+		n := pruneRepackBatch
+		if n > len(repackOrder) {
+			n = len(repackOrder)
+		}
+		batch := restic.NewIDSet(repackOrder[:n]...)
+
+		obsolete, err := pruneRepack(ctx, repo, batch, usedBlobs, removePacks)
+		if err != nil && ctx.Err() != nil {
+			// the interrupted batch is repacked again by the next run
+			debug.Log("prune: cancelled while repacking, deferring %d packs", len(repackOrder))
+			break
+		}
+		if err != nil {
+			return stats, err
+		}
+		repackOrder = repackOrder[n:]
+		removePacks.Merge(obsolete)
+		stats.RepackPacks += len(batch)
+	}
+	deferred = append(repackOrder, deferred...)
+	stats.DeferredPacks = len(deferred)
+
+	if err := savePrunePlan(opts.StatePath, deferred); err != nil {
+		return stats, err
+	}
+	if err := ctx.Err(); err != nil {
+		return stats, err
+	}
+
+	if len(removePacks) == 0 {
+		return stats, nil
+	}
+
+	// the index of repo still references the removed packs, reload it even
+	// if removing fails, so that later backups with repo do not skip blobs
+	// which were only stored in removed packs
+	defer func() {
+		if lerr := repo.LoadIndex(ctx, nil); lerr != nil && err == nil {
+			err = lerr
+		}
+	}()
+
+	// only the index files which reference removed packs are rewritten, the
+	// index of the repacked blobs was already saved by Repack
+	obsoleteIndexes, err := index.RewriteAffected(ctx, repo, removePacks, nil)
+	if err != nil {
+		return stats, err
+	}
+	for id := range obsoleteIndexes {
+		h := backend.Handle{Type: restic.IndexFile, Name: id.String()}
+		if err := repo.Backend().Remove(ctx, h); err != nil {
+			return stats, err
+		}
+	}
+
+	for id := range removePacks {
+		h := backend.Handle{Type: restic.PackFile, Name: id.String()}
+		if err := repo.Backend().Remove(ctx, h); err != nil {
+			return stats, err
+		}
+		stats.RemovePacks++
+		stats.RemoveBytes += uint64(packSizes[id])
+	}
+
+	return stats, nil
+}
+
+// pruneRepackBatch is the number of packs which are repacked between two
+// checks of the time budget.
+const pruneRepackBatch = 32
+
+// pruneRepack repacks the used blobs of packs, unless they are also stored
+// in a pack which is neither repacked nor removed.
+func pruneRepack(ctx context.Context, repo restic.Repository, packs restic.IDSet, usedBlobs restic.BlobSet, removePacks restic.IDSet) (restic.IDSet, error) {
+	keepBlobs := restic.NewBlobSet()
+	for pbs := range repo.Index().ListPacks(ctx, packs) {
+		for _, blob := range pbs.Blobs {
+			if usedBlobs.Has(blob.BlobHandle) {
+				keepBlobs.Insert(blob.BlobHandle)
+			}
+		}
+	}
+	repo.Index().Each(ctx, func(pb restic.PackedBlob) {
+		if !packs.Has(pb.PackID) && !removePacks.Has(pb.PackID) {
+			keepBlobs.Delete(pb.BlobHandle)
+		}
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := restic.IndexErr(repo.Index()); err != nil {
+		return nil, err
+	}
+
+	obsolete, err := repository.Repack(ctx, repo, repo, packs, keepBlobs, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(keepBlobs) != 0 {
+		return nil, errors.Fatalf("%d blobs could not be repacked", len(keepBlobs))
+	}
+	return obsolete, nil
+}
+
+// prunePlan is the work deferred by a prune run which hit its limits.
+type prunePlan struct {
+	Created time.Time  `json:"created"`
+	Repack  restic.IDs `json:"repack"`
+	set     restic.IDSet
+}
+
+func (p *prunePlan) has(id restic.ID) bool {
+	return p.set.Has(id)
+}
+
+// loadPrunePlan reads the plan stored at path. A missing file or an empty
+// path yield an empty plan.
+func loadPrunePlan(path string) (*prunePlan, error) {
+	plan := &prunePlan{set: restic.NewIDSet()}
+	if path == "" {
+		return plan, nil
+	}
+
+	buf, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return plan, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "ReadFile")
+	}
+	if err := json.Unmarshal(buf, plan); err != nil {
+		return nil, errors.Fatalf("invalid prune state %v: %v", path, err)
+	}
+	plan.set = restic.NewIDSet(plan.Repack...)
+	return plan, nil
+}
+
+// savePrunePlan stores the deferred packs at path, or removes the file if no
+// work is left.
+func savePrunePlan(path string, deferred restic.IDs) error {
+	if path == "" {
+		return nil
+	}
+	if len(deferred) == 0 {
+		err := os.Remove(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return errors.Wrap(err, "Remove")
+	}
+
+	buf, err := json.Marshal(prunePlan{Created: time.Now(), Repack: deferred})
+	if err != nil {
+		return errors.Wrap(err, "Marshal")
+	}
+	return errors.Wrap(os.WriteFile(path, buf, 0600), "WriteFile")
+}
+
+// pruneEachBlob calls fn for each blob in the index of repo and reports
+// whether that copy of the blob is used. A blob in usedBlobs which is stored
+// several times is only used in the first pack, its other copies are unused
+// like blobs which are not in usedBlobs. Returned are the used blobs which
+// were found in the index.
+func pruneEachBlob(ctx context.Context, repo restic.Repository, usedBlobs restic.BlobSet, fn func(pb restic.PackedBlob, used bool)) (restic.BlobSet, error) {
+	seen := restic.NewBlobSet()
+	repo.Index().Each(ctx, func(pb restic.PackedBlob) {
+		used := usedBlobs.Has(pb.BlobHandle) && !seen.Has(pb.BlobHandle)
+		if used {
+			seen.Insert(pb.BlobHandle)
+		}
+		fn(pb, used)
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return seen, restic.IndexErr(repo.Index())
+}
+
+// pruneUsedBlobs returns the blobs referenced by all snapshots, including the
+// snapshots in the trash, and the blobs referenced by snapshots younger than
+// minAge.
+func pruneUsedBlobs(ctx context.Context, repo restic.Repository, minAge time.Duration) (used, young restic.BlobSet, err error) {
+	var trees, youngTrees restic.IDs
+	cutoff := restic.Now(ctx).Add(-minAge)
+	err = restic.ForAllSnapshots(ctx, repo, repo, nil, func(id restic.ID, sn *restic.Snapshot, err error) error {
+		if err != nil {
+			return err
+		}
+		if sn.Tree == nil {
+			return errors.Fatalf("snapshot %v has no tree", id.Str())
+		}
+		trees = append(trees, *sn.Tree)
+		if minAge > 0 && sn.Time.After(cutoff) {
+			youngTrees = append(youngTrees, *sn.Tree)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// snapshots in the trash can still be restored
+	trashed, err := trashedTrees(ctx, repo)
+	if err != nil {
+		return nil, nil, err
+	}
+	trees = append(trees, trashed...)
+
+	used = restic.NewBlobSet()
+	if err := restic.FindUsedBlobs(ctx, repo, trees, used, nil); err != nil {
+		return nil, nil, err
+	}
+	young = restic.NewBlobSet()
+	if len(youngTrees) > 0 {
+		if err := restic.FindUsedBlobs(ctx, repo, youngTrees, young, nil); err != nil {
+			return nil, nil, err
+		}
+	}
+	return used, young, nil
+}
+
+// pruneRecentPacks returns the packs referenced by index files which are not
+// in known.
+func pruneRecentPacks(ctx context.Context, repo restic.Repository, known restic.IDSet) (restic.IDSet, error) {
+	var recent restic.IDs
+	err := repo.List(ctx, restic.IndexFile, func(id restic.ID, _ int64) error {
+		if !known.Has(id) {
+			recent = append(recent, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	packs := restic.NewIDSet()
+	for _, id := range recent {
+		buf, err := repo.LoadUnpacked(ctx, restic.IndexFile, id)
+		if err != nil {
+			return nil, err
+		}
+		idx, _, err := index.DecodeIndex(buf, id)
+		if err != nil {
+			return nil, err
+		}
+		packs.Merge(idx.Packs())
+		debug.Log("index %v was written after the prune started", id.Str())
+	}
+	return packs, nil
+}
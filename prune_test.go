@@ -0,0 +1,378 @@
+package rapi_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/konidev20/rapi"
+	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/hooks"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/restic"
+	"golang.org/x/sync/errgroup"
+)
+
+// savePack stores the data blobs with the given seeds in a new pack and
+// returns the pack ID and the blob IDs.
+func savePack(t *testing.T, repo restic.Repository, seeds ...int) (restic.ID, restic.IDs) {
+	ctx := context.Background()
+	var wg errgroup.Group
+	repo.StartPackUploader(ctx, &wg)
+
+	var ids restic.IDs
+	for _, seed := range seeds {
+		id, _, _, err := repo.SaveBlob(ctx, restic.DataBlob, rtest.Random(seed, 10000), restic.ID{}, false)
+		rtest.OK(t, err)
+		ids = append(ids, id)
+	}
+	rtest.OK(t, repo.Flush(ctx))
+	rtest.OK(t, wg.Wait())
+
+	rtest.OK(t, repo.LoadIndex(ctx, nil))
+	pbs := repo.Index().Lookup(restic.BlobHandle{Type: restic.DataBlob, ID: ids[0]})
+	rtest.Equals(t, 1, len(pbs))
+	return pbs[0].PackID, ids
+}
+
+// saveSnapshotOf saves a snapshot taken at the given time which contains a
+// single file consisting of the blobs ids.
+func saveSnapshotOf(t *testing.T, repo restic.Repository, at time.Time, ids restic.IDs) restic.ID {
+	ctx := context.Background()
+	var wg errgroup.Group
+	repo.StartPackUploader(ctx, &wg)
+
+	tree := restic.NewTree(1)
+	rtest.OK(t, tree.Insert(&restic.Node{Name: "file", Type: "file", Mode: 0644, Content: ids}))
+	treeID, err := restic.SaveTree(ctx, repo, tree)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.Flush(ctx))
+	rtest.OK(t, wg.Wait())
+
+	sn, err := restic.NewSnapshot([]string{"/"}, nil, "test", at)
+	rtest.OK(t, err)
+	sn.Tree = &treeID
+	id, err := restic.SaveSnapshot(ctx, repo, sn)
+	rtest.OK(t, err)
+	return id
+}
+
+func listPacks(t *testing.T, repo restic.Repository) restic.IDSet {
+	packs := restic.NewIDSet()
+	rtest.OK(t, repo.List(context.TODO(), restic.PackFile, func(id restic.ID, _ int64) error {
+		packs.Insert(id)
+		return nil
+	}))
+	return packs
+}
+
+func checkBlobsLoadable(t *testing.T, repo restic.Repository, ids restic.IDs) {
+	t.Helper()
+	rtest.OK(t, repo.LoadIndex(context.TODO(), nil))
+	for _, id := range ids {
+		_, err := repo.LoadBlob(context.TODO(), restic.DataBlob, id, nil)
+		rtest.OK(t, err)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.TestRepository(t)
+
+	unusedPack, _ := savePack(t, repo, 1, 2)
+	partlyPack, partly := savePack(t, repo, 3, 4)
+	usedPack, used := savePack(t, repo, 5)
+	saveSnapshotOf(t, repo, time.Now().Add(-time.Hour), restic.IDs{partly[0], used[0]})
+
+	before := listPacks(t, repo)
+	opts := rapi.DefaultPruneOptions
+	opts.MaxUnusedPercent = 0
+	opts.DryRun = true
+	stats, err := rapi.Prune(ctx, repo, opts)
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, stats.RepackPacks)
+	rtest.Equals(t, 2, stats.RemovePacks)
+	rtest.Equals(t, 3, stats.UnusedBlobs)
+	rtest.Equals(t, before, listPacks(t, repo))
+
+	opts.DryRun = false
+	stats, err = rapi.Prune(ctx, repo, opts)
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, stats.RepackPacks)
+	rtest.Equals(t, 2, stats.RemovePacks)
+
+	after := listPacks(t, repo)
+	rtest.Assert(t, !after.Has(unusedPack), "unused pack was not removed")
+	rtest.Assert(t, !after.Has(partlyPack), "partly used pack was not removed")
+	rtest.Assert(t, after.Has(usedPack), "used pack was removed")
+	checkBlobsLoadable(t, repo, restic.IDs{partly[0], used[0]})
+
+	res, err := rapi.Check(ctx, repo, rapi.CheckOptions{})
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, len(res.Errors))
+
+	// nothing is left to do
+	stats, err = rapi.Prune(ctx, repo, opts)
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, stats.RepackPacks+stats.RemovePacks)
+}
+
+func TestPruneMinSnapshotAge(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.TestRepository(t)
+
+	youngPack, young := savePack(t, repo, 1, 2)
+	oldPack, old := savePack(t, repo, 3, 4)
+	saveSnapshotOf(t, repo, time.Now(), young[:1])
+	saveSnapshotOf(t, repo, time.Now().Add(-48*time.Hour), old[:1])
+
+	opts := rapi.DefaultPruneOptions
+	opts.MaxUnusedPercent = 0
+	opts.MinSnapshotAge = 24 * time.Hour
+	stats, err := rapi.Prune(ctx, repo, opts)
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, stats.ProtectedByAge)
+	rtest.Equals(t, 1, stats.RepackPacks)
+
+	after := listPacks(t, repo)
+	rtest.Assert(t, after.Has(youngPack), "pack of young snapshot was repacked")
+	rtest.Assert(t, !after.Has(oldPack), "pack of old snapshot was not repacked")
+	checkBlobsLoadable(t, repo, restic.IDs{young[0], old[0]})
+}
+
+// hideIndexBackend hides an index file from the first listing, as if it was
+// written after that listing.
+type hideIndexBackend struct {
+	backend.Backend
+	hide   restic.ID
+	listed bool
+}
+
+func (be *hideIndexBackend) List(ctx context.Context, t backend.FileType, fn func(backend.FileInfo) error) error {
+	if t != restic.IndexFile || be.listed {
+		return be.Backend.List(ctx, t, fn)
+	}
+	be.listed = true
+	return be.Backend.List(ctx, t, func(fi backend.FileInfo) error {
+		if fi.Name == be.hide.String() {
+			return nil
+		}
+		return fn(fi)
+	})
+}
+
+func TestPruneProtectRecentIndexes(t *testing.T) {
+	for _, protect := range []bool{true, false} {
+		ctx := context.Background()
+		be := &hideIndexBackend{Backend: repository.TestBackend(t), listed: true}
+		repo := repository.TestRepositoryWithBackend(t, be, restic.StableRepoVersion)
+
+		_, used := savePack(t, repo, 1)
+		saveSnapshotOf(t, repo, time.Now(), used)
+
+		indexes := restic.NewIDSet()
+		rtest.OK(t, repo.List(ctx, restic.IndexFile, func(id restic.ID, _ int64) error {
+			indexes.Insert(id)
+			return nil
+		}))
+		recentPack, _ := savePack(t, repo, 2)
+		rtest.OK(t, repo.List(ctx, restic.IndexFile, func(id restic.ID, _ int64) error {
+			if !indexes.Has(id) {
+				be.hide = id
+			}
+			return nil
+		}))
+		be.listed = false
+
+		opts := rapi.DefaultPruneOptions
+		opts.ProtectRecentIndexes = protect
+		stats, err := rapi.Prune(ctx, repo, opts)
+		rtest.OK(t, err)
+
+		if protect {
+			rtest.Equals(t, 1, stats.ProtectedByIndex)
+		} else {
+			rtest.Equals(t, 0, stats.ProtectedByIndex)
+		}
+		rtest.Equals(t, protect, listPacks(t, repo).Has(recentPack))
+	}
+}
+
+func TestPruneProtectRecentIndexesLimit(t *testing.T) {
+	ctx := context.Background()
+	be := &hideIndexBackend{Backend: repository.TestBackend(t), listed: true}
+	repo := repository.TestRepositoryWithBackend(t, be, restic.StableRepoVersion)
+
+	otherPack, other := savePack(t, repo, 1, 2)
+	indexes := restic.NewIDSet()
+	rtest.OK(t, repo.List(ctx, restic.IndexFile, func(id restic.ID, _ int64) error {
+		indexes.Insert(id)
+		return nil
+	}))
+	recentPack, recent := savePack(t, repo, 3, 4, 5)
+	rtest.OK(t, repo.List(ctx, restic.IndexFile, func(id restic.ID, _ int64) error {
+		if !indexes.Has(id) {
+			be.hide = id
+		}
+		return nil
+	}))
+	saveSnapshotOf(t, repo, time.Now(), restic.IDs{other[0], recent[0]})
+	be.listed = false
+
+	// the recent pack has the highest share of unused data, but the other
+	// pack must be repacked instead to stay within the limit
+	opts := rapi.DefaultPruneOptions
+	opts.MaxUnusedPercent = 60
+	stats, err := rapi.Prune(ctx, repo, opts)
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, stats.ProtectedByIndex)
+	rtest.Equals(t, 1, stats.RepackPacks)
+
+	after := listPacks(t, repo)
+	rtest.Assert(t, after.Has(recentPack), "pack of recent index was repacked")
+	rtest.Assert(t, !after.Has(otherPack), "other pack was not repacked")
+}
+
+func TestPruneUnindexedPacks(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.TestRepository(t)
+
+	indexes := restic.NewIDSet()
+	rtest.OK(t, repo.List(ctx, restic.IndexFile, func(id restic.ID, _ int64) error {
+		indexes.Insert(id)
+		return nil
+	}))
+	orphanPack, _ := savePack(t, repo, 1)
+	rtest.OK(t, repo.List(ctx, restic.IndexFile, func(id restic.ID, _ int64) error {
+		if !indexes.Has(id) {
+			return repo.Backend().Remove(ctx, backend.Handle{Type: restic.IndexFile, Name: id.String()})
+		}
+		return nil
+	}))
+	rtest.OK(t, repo.LoadIndex(ctx, nil))
+
+	// the pack may belong to a running backup, it is left to CleanupOrphans
+	stats, err := rapi.Prune(ctx, repo, rapi.DefaultPruneOptions)
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, stats.UnindexedPacks)
+	rtest.Equals(t, 0, stats.RemovePacks)
+	rtest.Assert(t, listPacks(t, repo).Has(orphanPack), "unindexed pack was removed")
+}
+
+func TestPruneSaveAgain(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.TestRepository(t)
+
+	savePack(t, repo, 1)
+	_, used := savePack(t, repo, 2)
+	saveSnapshotOf(t, repo, time.Now(), used)
+
+	stats, err := rapi.Prune(ctx, repo, rapi.DefaultPruneOptions)
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, stats.RemovePacks)
+
+	// the removed blob is saved again with the same repository, without
+	// loading the index first
+	var wg errgroup.Group
+	repo.StartPackUploader(ctx, &wg)
+	id, known, _, err := repo.SaveBlob(ctx, restic.DataBlob, rtest.Random(1, 10000), restic.ID{}, false)
+	rtest.OK(t, err)
+	rtest.Assert(t, !known, "blob of removed pack is still known")
+	rtest.OK(t, repo.Flush(ctx))
+	rtest.OK(t, wg.Wait())
+
+	_, err = repo.LoadBlob(ctx, restic.DataBlob, id, nil)
+	rtest.OK(t, err)
+	checkBlobsLoadable(t, repo, restic.IDs{id})
+}
+
+func TestPruneMaxRepackBytes(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.TestRepository(t)
+
+	var used restic.IDs
+	partlyPacks := restic.NewIDSet()
+	for i := 0; i < 3; i++ {
+		id, blobs := savePack(t, repo, 2*i, 2*i+1)
+		partlyPacks.Insert(id)
+		used = append(used, blobs[0])
+	}
+	saveSnapshotOf(t, repo, time.Now(), used)
+
+	statePath := filepath.Join(rtest.TempDir(t), "prune-state")
+	opts := rapi.DefaultPruneOptions
+	opts.MaxUnusedPercent = 0
+	opts.MaxRepackBytes = 25000
+	opts.StatePath = statePath
+
+	loadState := func() restic.IDSet {
+		buf, err := os.ReadFile(statePath)
+		rtest.OK(t, err)
+		var state struct {
+			Repack restic.IDs `json:"repack"`
+		}
+		rtest.OK(t, json.Unmarshal(buf, &state))
+		return restic.NewIDSet(state.Repack...)
+	}
+
+	stats, err := rapi.Prune(ctx, repo, opts)
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, stats.RepackPacks)
+	rtest.Equals(t, 2, stats.DeferredPacks)
+	deferred := loadState()
+	rtest.Equals(t, 2, len(deferred))
+	rtest.Equals(t, deferred, partlyPacks.Intersect(listPacks(t, repo)))
+
+	// the deferred packs are repacked first
+	stats, err = rapi.Prune(ctx, repo, opts)
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, stats.RepackPacks)
+	rtest.Equals(t, 1, stats.DeferredPacks)
+	left := loadState()
+	rtest.Equals(t, 1, len(left))
+	rtest.Equals(t, left, partlyPacks.Intersect(listPacks(t, repo)))
+	rtest.Assert(t, len(deferred.Sub(left)) == 1, "deferred packs were not repacked first")
+
+	// the state is removed once no work is left
+	stats, err = rapi.Prune(ctx, repo, opts)
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, stats.RepackPacks)
+	rtest.Equals(t, 0, stats.DeferredPacks)
+	_, err = os.Stat(statePath)
+	rtest.Assert(t, errors.Is(err, os.ErrNotExist), "state file was not removed: %v", err)
+	rtest.Equals(t, 0, len(partlyPacks.Intersect(listPacks(t, repo))))
+	checkBlobsLoadable(t, repo, used)
+}
+
+func TestPruneHooks(t *testing.T) {
+	repo := repository.TestRepository(t)
+	savePack(t, repo, 1)
+
+	var events []hooks.Event
+	h := hooks.New()
+	for _, ev := range hooks.Events {
+		rtest.OK(t, h.Register(ev, hooks.Func(func(_ context.Context, info hooks.Info) error {
+			events = append(events, info.Event)
+			return errors.New("unreachable")
+		})))
+	}
+
+	warnings := restic.NewWarnings(nil)
+	ctx := restic.WithWarnings(context.Background(), warnings)
+	opts := rapi.DefaultPruneOptions
+	opts.Hooks = h
+	_, err := rapi.Prune(ctx, repo, opts)
+	rtest.OK(t, err)
+	rtest.Equals(t, []hooks.Event{hooks.PostPrune}, events)
+	rtest.Equals(t, 1, warnings.Len())
+
+	opts.MaxUnusedPercent = -1
+	_, err = rapi.Prune(ctx, repo, opts)
+	rtest.Assert(t, err != nil, "invalid options accepted")
+	rtest.Equals(t, []hooks.Event{hooks.PostPrune, hooks.OnError}, events)
+}
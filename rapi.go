@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
@@ -13,10 +14,12 @@ import (
 	"github.com/konidev20/rapi/backend/azure"
 	"github.com/konidev20/rapi/backend/b2"
 	"github.com/konidev20/rapi/backend/gs"
+	"github.com/konidev20/rapi/backend/keepalive"
 	"github.com/konidev20/rapi/backend/limiter"
 	"github.com/konidev20/rapi/backend/local"
 	"github.com/konidev20/rapi/backend/location"
 	"github.com/konidev20/rapi/backend/logger"
+	"github.com/konidev20/rapi/backend/quota"
 	"github.com/konidev20/rapi/backend/rclone"
 	"github.com/konidev20/rapi/backend/rest"
 	"github.com/konidev20/rapi/backend/retry"
@@ -27,10 +30,13 @@ import (
 	"github.com/konidev20/rapi/internal/cache"
 	"github.com/konidev20/rapi/internal/debug"
 	"github.com/konidev20/rapi/internal/fs"
+	"github.com/konidev20/rapi/internal/metrics"
 	"github.com/konidev20/rapi/internal/options"
 	"github.com/konidev20/rapi/internal/textfile"
+	"github.com/konidev20/rapi/internal/tracing"
 	"github.com/konidev20/rapi/repository"
 	"github.com/konidev20/rapi/restic"
+	"github.com/konidev20/rapi/ui/events"
 
 	"github.com/konidev20/rapi/internal/errors"
 )
@@ -50,23 +56,121 @@ type RepositoryOptions struct {
 	Quiet           bool
 	Verbose         int
 	NoLock          bool
+	ReadOnly        bool
 	JSON            bool
 	CacheDir        string
 	NoCache         bool
 	CleanupCache    bool
-	Compression     repository.CompressionMode
-	PackSize        uint
+
+	// CacheMaxSize is the maximum size of the cache in MiB. Once it is
+	// exceeded, the least recently used pack and index files are removed
+	// from the cache. Zero means no limit.
+	CacheMaxSize uint
+
+	SharedCache bool
+	MemoryCache bool
+	Compression repository.CompressionMode
+	PackSize    uint
+
+	// Profile selects a repository profile defined in the environment, see
+	// Profile. If Profile, Repo and RepositoryFile are empty, the profile
+	// named by the environment variable RESTIC_REPOSITORY_PROFILE is used.
+	Profile string
+
+	// PackSizeTarget is the size in MiB up to which the pack size grows
+	// while uploads are fast, it is only used if larger than PackSize.
+	PackSizeTarget uint
+
+	// PackUploaders, PackQueueDepth and MaxInFlightBytes tune the upload
+	// pipeline, see repository.Options.
+	PackUploaders    uint
+	PackQueueDepth   uint
+	MaxInFlightBytes uint64
+
+	// MaxMemoryBytes is the memory budget for loading the index, saving
+	// blobs and restoring files, see repository.Options. Zero means no
+	// limit.
+	MaxMemoryBytes uint64
+
+	// OnDiskIndex keeps the repository index in a file in the cache
+	// directory instead of in memory, see repository.Options.
+	OnDiskIndex bool
+
+	// AutoTune selects the pack size, the compression mode, the number of
+	// backend connections and uploaders and the memory budget from the
+	// resources of the machine and the type of the backend, see package
+	// tuning. Options which are set explicitly are kept, the compression
+	// mode only if it is not auto.
+	AutoTune bool
+
+	// Deterministic makes the files written to the repository depend only
+	// on their contents, see repository.Options.
+	Deterministic bool
+
+	// ShutdownTimeout bounds the time spent to save the partial state of an
+	// operation which was cancelled, see repository.Options.
+	ShutdownTimeout time.Duration
+
+	// KeepAlive is the interval after which the connection to an idle
+	// backend is checked. If set, the backend is also opened again when an
+	// operation fails because the connection was lost, for example after
+	// the ssh process of the sftp backend exited, see package keepalive.
+	KeepAlive time.Duration
+
+	// OnReconnect is called with the error which revealed a lost connection
+	// after the backend was opened again, may be nil.
+	OnReconnect func(cause error)
 
 	backend.TransportOptions
 	limiter.Limits
 
+	// Quota limits the requests and the transferred data of the repository
+	// and counts them, may be nil. A tracker can be shared by several
+	// repositories.
+	Quota *quota.Tracker
+
 	Password string
 	Stdout   io.Writer
 	Stderr   io.Writer
 
+	// PasswordProvider returns the password if Password is empty, may be
+	// nil.
+	PasswordProvider PasswordProvider
+
+	// Keychain selects the credential store of the operating system as
+	// PasswordProvider if none is set. The passwords are stored for the
+	// service named Keychain, e.g. "restic", with the repository location as
+	// account, see package keychain.
+	Keychain string
+
+	// Events receives structured events instead of the messages printed to
+	// Stdout and Stderr, may be nil.
+	Events *events.Emitter
+
+	// Warnings collects the warnings of opening and using the repository
+	// instead of writing them to Stderr or sending them as events, may be
+	// nil.
+	Warnings *restic.Warnings
+
+	// BackendLog is called for requests to the backend, if nil they are
+	// written to the debug log. BackendLogSampleRate is the fraction of
+	// successful requests which are logged, zero logs all requests.
+	BackendLog           func(logger.Entry)
+	BackendLogSampleRate float64
+
+	// AzureSASProvider supplies refreshed SAS tokens for the azure backend.
+	AzureSASProvider azure.SASProvider
+
 	backends                              *location.Registry
 	backendTestHook, backendInnerTestHook backendWrapper
 
+	// transport is shared by several repositories, see RepositoryManager.
+	transport http.RoundTripper
+
+	// envPrefix is the prefix of the environment variables of the profile
+	// used for the backend credentials.
+	envPrefix string
+
 	// verbosity is set as follows:
 	//  0 means: don't print any messages except errors, this is used when --quiet is specified
 	//  1 is the default: print essential messages
@@ -144,7 +248,35 @@ func Warnf(format string, args ...interface{}) {
 	}
 }
 
+// warnf reports a warning, it is added to the Warnings if set, sent as an
+// Error event for the operation op if events are requested or written to the
+// configured Stderr stream.
+func (opts RepositoryOptions) warnf(op string, format string, args ...interface{}) {
+	if opts.Warnings != nil {
+		opts.Warnings.Add("", fmt.Errorf(strings.TrimSuffix(format, "\n"), args...))
+		return
+	}
+	if opts.Events == nil {
+		Warnf(format, args...)
+		return
+	}
+	opts.Events.Error(op, "", fmt.Errorf(strings.TrimSuffix(format, "\n"), args...))
+}
+
+// verbosef prints the message unless JSON output or events are requested.
+func (opts RepositoryOptions) verbosef(format string, args ...interface{}) {
+	if opts.JSON || opts.Events != nil {
+		return
+	}
+	Verbosef(format, args...)
+}
+
 func ReadRepo(opts RepositoryOptions) (string, error) {
+	opts, err := opts.applyProfile()
+	if err != nil {
+		return "", err
+	}
+
 	if opts.Repo == "" && opts.RepositoryFile == "" {
 		return "", errors.Fatal("Please specify repository location (-r or --repository-file)")
 	}
@@ -172,22 +304,63 @@ func ReadRepo(opts RepositoryOptions) (string, error) {
 const maxKeys = 20
 
 // OpenRepository reads the password and opens the repository.
-func OpenRepository(ctx context.Context, opts RepositoryOptions) (*repository.Repository, error) {
+func OpenRepository(ctx context.Context, opts RepositoryOptions) (_ *repository.Repository, err error) {
+	ctx, span := tracing.Start(ctx, "OpenRepository")
+	defer func() { tracing.End(span, err) }()
+
+	opts.Events.Started("open")
+	defer func() {
+		if err != nil {
+			opts.Events.Error("open", "", err)
+		}
+		opts.Events.Summary("open", restic.ID{}, nil)
+	}()
+
+	opts, err = opts.applyProfile()
+	if err != nil {
+		return nil, err
+	}
+
 	repo, err := ReadRepo(opts)
 	if err != nil {
 		return nil, err
 	}
 
+	opts.Password, err = opts.readPassword(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.AutoTune {
+		opts, err = opts.autoTune(repo)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	be, err := open(ctx, repo, opts, opts.Extended)
 	if err != nil {
 		return nil, err
 	}
 
+	if opts.KeepAlive > 0 {
+		dial := func(ctx context.Context) (backend.Backend, error) {
+			return open(ctx, repo, opts, opts.Extended)
+		}
+		be = keepalive.New(be, dial, keepalive.Options{
+			Interval:    opts.KeepAlive,
+			OnReconnect: opts.OnReconnect,
+		})
+	}
+
 	report := func(msg string, err error, d time.Duration) {
-		Warnf("%v returned error, retrying after %v: %v\n", msg, d, err)
+		opts.warnf("open", "%v returned error, retrying after %v: %v\n", msg, d, err)
 	}
 	success := func(msg string, retries int) {
-		Warnf("%v operation successful after %d retries\n", msg, retries)
+		// not an error, so there is no event or warning for it
+		if opts.Events == nil && opts.Warnings == nil {
+			Warnf("%v operation successful after %d retries\n", msg, retries)
+		}
 	}
 	be = retry.New(be, 10, report, success)
 
@@ -199,32 +372,75 @@ func OpenRepository(ctx context.Context, opts RepositoryOptions) (*repository.Re
 		}
 	}
 
+	caps, err := backendCapabilities(opts, repo)
+	if err != nil {
+		return nil, err
+	}
+
 	s, err := repository.New(be, repository.Options{
-		Compression: opts.Compression,
-		PackSize:    opts.PackSize * 1024 * 1024,
+		Compression:      opts.Compression,
+		PackSize:         opts.PackSize * 1024 * 1024,
+		PackSizeTarget:   opts.PackSizeTarget * 1024 * 1024,
+		PackUploaders:    opts.PackUploaders,
+		PackQueueDepth:   opts.PackQueueDepth,
+		MaxInFlightBytes: opts.MaxInFlightBytes,
+		MaxMemoryBytes:   opts.MaxMemoryBytes,
+		OnDiskIndex:      opts.OnDiskIndex,
+		Deterministic:    opts.Deterministic,
+		Events:           opts.Events,
+		Warnings:         opts.Warnings,
+		ReadOnly:         opts.ReadOnly,
+		Capabilities:     caps,
+		ShutdownTimeout:  opts.ShutdownTimeout,
 	})
 	if err != nil {
 		return nil, err
 	}
 
 	err = s.SearchKey(ctx, opts.Password, maxKeys, opts.KeyHint)
+	var versionErr *restic.UnsupportedVersionError
+	var featureErr *restic.UnsupportedFeatureError
+	if errors.As(err, &versionErr) || errors.As(err, &featureErr) {
+		// the repository cannot be used by this build
+		return nil, err
+	}
+	if errors.Is(err, repository.ErrNoKeyFound) {
+		return nil, classify(ErrWrongPassword, err)
+	}
 	if err != nil {
 		opts.Password = ""
-		Warnf("unable to search repository key: %v", err.Error())
+		opts.warnf("open", "unable to search repository key: %v", err.Error())
 	}
 
 	if opts.NoCache {
 		return s, nil
 	}
 
-	c, err := cache.New(s.Config().ID, opts.CacheDir)
+	if opts.MemoryCache {
+		s.UseCache(cache.NewMemory(s.Config().ID, int64(opts.CacheMaxSize)*1024*1024))
+		return s, nil
+	}
+
+	var c *cache.Cache
+	if opts.SharedCache {
+		c, err = cache.NewShared(s.Config().ID, opts.CacheDir)
+	} else {
+		c, err = cache.New(s.Config().ID, opts.CacheDir)
+	}
 	if err != nil {
-		Warnf("unable to open cache: %v\n", err)
+		opts.warnf("open", "unable to open cache: %v\n", err)
 		return s, nil
 	}
 
-	if c.Created && !opts.JSON {
-		Verbosef("created new cache in %v\n", c.Base)
+	if c.Created {
+		opts.verbosef("created new cache in %v\n", c.Base)
+	}
+
+	if opts.CacheMaxSize > 0 {
+		err = c.SetMaxSize(int64(opts.CacheMaxSize) * 1024 * 1024)
+		if err != nil {
+			opts.warnf("open", "unable to apply cache size limit: %v\n", err)
+		}
 	}
 
 	// start using the cache
@@ -232,7 +448,7 @@ func OpenRepository(ctx context.Context, opts RepositoryOptions) (*repository.Re
 
 	oldCacheDirs, err := cache.Old(c.Base)
 	if err != nil {
-		Warnf("unable to find old cache directories: %v", err)
+		opts.warnf("open", "unable to find old cache directories: %v", err)
 	}
 
 	// nothing more to do if no old cache dirs could be found
@@ -242,29 +458,52 @@ func OpenRepository(ctx context.Context, opts RepositoryOptions) (*repository.Re
 
 	// cleanup old cache dirs if instructed to do so
 	if opts.CleanupCache {
-		if !opts.JSON {
-			Verbosef("removing %d old cache dirs from %v\n", len(oldCacheDirs), c.Base)
-		}
+		opts.verbosef("removing %d old cache dirs from %v\n", len(oldCacheDirs), c.Base)
 		for _, item := range oldCacheDirs {
 			dir := filepath.Join(c.Base, item.Name())
 			err = fs.RemoveAll(dir)
 			if err != nil {
-				Warnf("unable to remove %v: %v\n", dir, err)
+				opts.warnf("open", "unable to remove %v: %v\n", dir, err)
 			}
 		}
 	} else {
-		if !opts.JSON {
-			Verbosef("found %d old cache directories in %v, run `restic cache --cleanup` to remove them\n",
-				len(oldCacheDirs), c.Base)
-		}
+		opts.verbosef("found %d old cache directories in %v, run `restic cache --cleanup` to remove them\n",
+			len(oldCacheDirs), c.Base)
 	}
 
 	return s, nil
 }
 
-func parseConfig(loc location.Location, opts options.Options) (interface{}, error) {
+// BackendCapabilities returns the capabilities of the type of backend used
+// for the repository configured in opts, without connecting to it.
+func BackendCapabilities(opts RepositoryOptions) (backend.Capabilities, error) {
+	repo, err := ReadRepo(opts)
+	if err != nil {
+		return backend.Capabilities{}, err
+	}
+	return backendCapabilities(opts, repo)
+}
+
+func backendCapabilities(opts RepositoryOptions, s string) (backend.Capabilities, error) {
+	loc, err := location.Parse(opts.backends, s)
+	if err != nil {
+		return backend.Capabilities{}, errors.Fatalf("parsing repository location failed: %v", err)
+	}
+
+	factory := opts.backends.Lookup(loc.Scheme)
+	if factory == nil {
+		return backend.Capabilities{}, errors.Fatalf("invalid backend: %q", loc.Scheme)
+	}
+	return factory.Capabilities(), nil
+}
+
+func parseConfig(loc location.Location, opts options.Options, envPrefix string) (interface{}, error) {
 	cfg := loc.Config
 	if cfg, ok := cfg.(backend.ApplyEnvironmenter); ok {
+		// the credentials of a profile take precedence
+		if envPrefix != "" {
+			cfg.ApplyEnvironment(envPrefix)
+		}
 		cfg.ApplyEnvironment("")
 	}
 
@@ -278,8 +517,7 @@ func parseConfig(loc location.Location, opts options.Options) (interface{}, erro
 	return cfg, nil
 }
 
-// Open the backend specified by a location config.
-func open(ctx context.Context, s string, gopts RepositoryOptions, opts options.Options) (backend.Backend, error) {
+func innerOpen(ctx context.Context, s string, gopts RepositoryOptions, opts options.Options, create bool) (backend.Backend, error) {
 	debug.Log("parsing location %v", location.StripPassword(gopts.backends, s))
 	loc, err := location.Parse(gopts.backends, s)
 	if err != nil {
@@ -288,14 +526,20 @@ func open(ctx context.Context, s string, gopts RepositoryOptions, opts options.O
 
 	var be backend.Backend
 
-	cfg, err := parseConfig(loc, opts)
+	cfg, err := parseConfig(loc, opts, gopts.envPrefix)
 	if err != nil {
 		return nil, err
 	}
+	if cfg, ok := cfg.(*azure.Config); ok && gopts.AzureSASProvider != nil {
+		cfg.SASProvider = &gopts.AzureSASProvider
+	}
 
-	rt, err := backend.Transport(gopts.TransportOptions)
-	if err != nil {
-		return nil, errors.Fatal(err.Error())
+	rt := gopts.transport
+	if rt == nil {
+		rt, err = backend.Transport(gopts.TransportOptions)
+		if err != nil {
+			return nil, errors.Fatal(err.Error())
+		}
 	}
 
 	// wrap the transport so that the throughput via HTTP is limited
@@ -307,13 +551,30 @@ func open(ctx context.Context, s string, gopts RepositoryOptions, opts options.O
 		return nil, errors.Fatalf("invalid backend: %q", loc.Scheme)
 	}
 
-	be, err = factory.Open(ctx, cfg, rt, lim)
+	if create {
+		be, err = factory.Create(ctx, cfg, rt, lim)
+	} else {
+		be, err = factory.Open(ctx, cfg, rt, lim)
+	}
 	if err != nil {
+		if create {
+			return nil, errors.Fatalf("create repository at %s failed: %v", location.StripPassword(gopts.backends, s), err)
+		}
 		return nil, errors.Fatalf("unable to open repository at %v: %v", location.StripPassword(gopts.backends, s), err)
 	}
 
-	// wrap with debug logging and connection limiting
-	be = logger.New(sema.NewBackend(be))
+	be = sema.NewBackend(be)
+	if gopts.Quota != nil {
+		be = quota.New(be, gopts.Quota)
+	}
+
+	// wrap with request logging, metrics, tracing and connection limiting
+	be = logger.NewWithOptions(be, logger.Options{
+		Log:        gopts.BackendLog,
+		SampleRate: gopts.BackendLogSampleRate,
+	})
+	be = metrics.NewBackend(be, metrics.Default)
+	be = tracing.NewBackend(be)
 
 	// wrap backend if a test specified an inner hook
 	if gopts.backendInnerTestHook != nil {
@@ -323,8 +584,21 @@ func open(ctx context.Context, s string, gopts RepositoryOptions, opts options.O
 		}
 	}
 
+	return be, nil
+}
+
+// Open the backend specified by a location config.
+func open(ctx context.Context, s string, gopts RepositoryOptions, opts options.Options) (backend.Backend, error) {
+	be, err := innerOpen(ctx, s, gopts, opts, false)
+	if err != nil {
+		return nil, err
+	}
+
 	// check if config is there
 	fi, err := be.Stat(ctx, backend.Handle{Type: restic.ConfigFile})
+	if err != nil && be.IsNotExist(err) {
+		return nil, classify(ErrRepoNotFound, errors.Fatalf("unable to open config file: %v\nIs there a repository at the following location?\n%v", err, location.StripPassword(gopts.backends, s)))
+	}
 	if err != nil {
 		return nil, errors.Fatalf("unable to open config file: %v\nIs there a repository at the following location?\n%v", err, location.StripPassword(gopts.backends, s))
 	}
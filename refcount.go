@@ -0,0 +1,180 @@
+package rapi
+
+import (
+	"context"
+
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/restic"
+)
+
+// MaxBlobRefs is the largest reference count reported by CountBlobRefs,
+// larger counts are reported as MaxBlobRefs.
+const MaxBlobRefs = 255
+
+// BlobRefs is the number of snapshots which reference a blob.
+type BlobRefs struct {
+	restic.BlobHandle
+
+	// Refs is the number of snapshots referencing the blob, at most
+	// MaxBlobRefs.
+	Refs int
+
+	// Size is the stored size of the blob, including all copies if it is
+	// stored several times. It is zero if the blob is missing in the index.
+	Size uint64
+}
+
+// CountBlobRefs counts for each blob, trees and data blobs, how many of the
+// snapshots reference it and calls fn with the counts, in no particular order.
+// If snapshots is empty, all snapshots outside of the trash are counted. The
+// snapshots are traversed one after another, so that apart from a byte per
+// blob only the blobs of a single snapshot are held in memory. The index must
+// already be loaded.
+func CountBlobRefs(ctx context.Context, repo restic.Repository, snapshots restic.IDs, fn func(BlobRefs) error) error {
+	all := len(snapshots) == 0
+	if all {
+		err := repo.List(ctx, restic.SnapshotFile, func(id restic.ID, _ int64) error {
+			snapshots = append(snapshots, id)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	counts := restic.NewCountedBlobSet()
+	for _, id := range snapshots {
+		sn, err := restic.LoadSnapshot(ctx, repo, id)
+		if err != nil {
+			return err
+		}
+		if all && sn.InTrash() {
+			continue
+		}
+		if sn.Tree == nil {
+			return errors.Fatalf("snapshot %v has no tree", id.Str())
+		}
+
+		blobs := restic.NewBlobSet()
+		if err := restic.FindUsedBlobs(ctx, repo, restic.IDs{*sn.Tree}, blobs, nil); err != nil {
+			return err
+		}
+		for h := range blobs {
+			if counts[h] < MaxBlobRefs {
+				counts[h]++
+			}
+		}
+		debug.Log("snapshot %v references %d blobs", id.Str(), len(blobs))
+	}
+
+	idx := repo.Index()
+	for h, n := range counts {
+		refs := BlobRefs{BlobHandle: h, Refs: int(n)}
+		for _, pb := range idx.Lookup(h) {
+			refs.Size += uint64(pb.Length)
+		}
+		if err := fn(refs); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// ForgetEstimate is the space freed by forgetting snapshots and a subsequent
+// prune.
+type ForgetEstimate struct {
+	Snapshots int `json:"snapshots"`
+
+	// Trees and Data count the blobs which are only referenced by the
+	// snapshots, by their stored size including duplicates.
+	Trees BlobCount `json:"trees"`
+	Data  BlobCount `json:"data"`
+
+	// Shared counts the blobs which are referenced by the snapshots and by
+	// other snapshots, they are kept.
+	Shared BlobCount `json:"shared"`
+}
+
+// Bytes returns the stored size of the blobs which are freed.
+func (e ForgetEstimate) Bytes() uint64 {
+	return e.Trees.Bytes + e.Data.Bytes
+}
+
+// EstimateForget computes the space which is freed by forgetting the
+// snapshots ids and pruning the repository afterwards. It is the size of the
+// blobs which are no longer referenced: prune may keep some of them in partly
+// used packs, see PruneOptions.MaxUnusedPercent. Snapshots in the trash are
+// treated as kept, like prune does. The repository is not modified.
+func EstimateForget(ctx context.Context, repo restic.Repository, ids restic.IDs) (ForgetEstimate, error) {
+	estimate := ForgetEstimate{Snapshots: len(restic.NewIDSet(ids...))}
+	if len(ids) == 0 {
+		return estimate, nil
+	}
+
+	unlock, err := lockRepository(ctx, repo, false)
+	if err != nil {
+		return estimate, err
+	}
+	defer unlock()
+
+	if err := repo.LoadIndex(ctx, nil); err != nil {
+		return estimate, err
+	}
+
+	forget := restic.NewIDSet(ids...)
+	var forgetTrees, keptTrees restic.IDs
+	for id := range forget {
+		sn, err := restic.LoadSnapshot(ctx, repo, id)
+		if err != nil {
+			return estimate, err
+		}
+		if sn.Tree == nil {
+			return estimate, errors.Fatalf("snapshot %v has no tree", id.Str())
+		}
+		forgetTrees = append(forgetTrees, *sn.Tree)
+	}
+	err = restic.ForAllSnapshots(ctx, repo, repo, forget, func(id restic.ID, sn *restic.Snapshot, err error) error {
+		if err != nil {
+			return err
+		}
+		if sn.Tree == nil {
+			return errors.Fatalf("snapshot %v has no tree", id.Str())
+		}
+		keptTrees = append(keptTrees, *sn.Tree)
+		return nil
+	})
+	if err != nil {
+		return estimate, err
+	}
+	trashed, err := trashedTrees(ctx, repo)
+	if err != nil {
+		return estimate, err
+	}
+	keptTrees = append(keptTrees, trashed...)
+
+	kept := restic.NewBlobSet()
+	if err := restic.FindUsedBlobs(ctx, repo, keptTrees, kept, nil); err != nil {
+		return estimate, err
+	}
+	forgotten := restic.NewBlobSet()
+	if err := restic.FindUsedBlobs(ctx, repo, forgetTrees, forgotten, nil); err != nil {
+		return estimate, err
+	}
+
+	idx := repo.Index()
+	for h := range forgotten {
+		c := &estimate.Data
+		switch {
+		case kept.Has(h):
+			c = &estimate.Shared
+		case h.Type == restic.TreeBlob:
+			c = &estimate.Trees
+		}
+		c.Blobs++
+		for _, pb := range idx.Lookup(h) {
+			c.Bytes += uint64(pb.Length)
+		}
+	}
+	return estimate, ctx.Err()
+}
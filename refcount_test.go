@@ -0,0 +1,61 @@
+package rapi_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/konidev20/rapi"
+	"github.com/konidev20/rapi/backend"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/restic"
+)
+
+func TestCountBlobRefs(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.TestRepository(t)
+
+	_, blobs := savePack(t, repo, 1, 2, 3)
+	snA := saveSnapshotOf(t, repo, time.Now().Add(-2*time.Hour), restic.IDs{blobs[0], blobs[1]})
+	snB := saveSnapshotOf(t, repo, time.Now().Add(-time.Hour), restic.IDs{blobs[1], blobs[2]})
+	rtest.OK(t, repo.LoadIndex(ctx, nil))
+
+	count := func(ids ...restic.ID) map[restic.BlobHandle]int {
+		refs := make(map[restic.BlobHandle]int)
+		rtest.OK(t, rapi.CountBlobRefs(ctx, repo, ids, func(r rapi.BlobRefs) error {
+			refs[r.BlobHandle] = r.Refs
+			rtest.Assert(t, r.Size > 0, "no size for %v", r.BlobHandle)
+			return nil
+		}))
+		return refs
+	}
+
+	data := func(id restic.ID) restic.BlobHandle {
+		return restic.BlobHandle{ID: id, Type: restic.DataBlob}
+	}
+	refs := count()
+	rtest.Equals(t, 5, len(refs)) // two trees and three data blobs
+	rtest.Equals(t, 1, refs[data(blobs[0])])
+	rtest.Equals(t, 2, refs[data(blobs[1])])
+	rtest.Equals(t, 1, refs[data(blobs[2])])
+
+	refs = count(snB)
+	rtest.Equals(t, 3, len(refs))
+	rtest.Equals(t, 1, refs[data(blobs[1])])
+
+	estimate, err := rapi.EstimateForget(ctx, repo, restic.IDs{snA})
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, estimate.Snapshots)
+	rtest.Equals(t, 1, estimate.Trees.Blobs)
+	rtest.Equals(t, 1, estimate.Data.Blobs)
+	rtest.Equals(t, 1, estimate.Shared.Blobs)
+
+	// prune finds exactly the estimated unused data
+	rtest.OK(t, repo.Backend().Remove(ctx, backend.Handle{Type: restic.SnapshotFile, Name: snA.String()}))
+	opts := rapi.DefaultPruneOptions
+	opts.DryRun = true
+	stats, err := rapi.Prune(ctx, repo, opts)
+	rtest.OK(t, err)
+	rtest.Equals(t, estimate.Bytes(), stats.UnusedBytes)
+}
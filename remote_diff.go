@@ -0,0 +1,105 @@
+package rapi
+
+import (
+	"context"
+
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/restic"
+)
+
+// RemoteDiffResult describes which blobs of snapshots in a local repository
+// are missing in a remote repository. Sizes are the sizes of the blobs as
+// stored in the local repository, that is compressed and encrypted, which
+// approximates the data to upload.
+type RemoteDiffResult struct {
+	// Trees and Data count all blobs referenced by the snapshots.
+	Trees BlobCount `json:"trees"`
+	Data  BlobCount `json:"data"`
+
+	// MissingTrees and MissingData count the blobs which the remote
+	// repository does not contain.
+	MissingTrees BlobCount `json:"missing_trees"`
+	MissingData  BlobCount `json:"missing_data"`
+
+	// Missing are the blobs which the remote repository does not contain.
+	Missing restic.BlobSet `json:"-"`
+
+	// IndexBytes is the size of the index files of the remote repository,
+	// which were loaded unless they were in the cache.
+	IndexBytes uint64 `json:"index_bytes"`
+
+	// SameChunker is set if both repositories use the same chunker
+	// parameters. Otherwise a new backup of the same files to the remote
+	// repository would be split into different data blobs and the result
+	// only applies to copying the snapshots.
+	SameChunker bool `json:"same_chunker"`
+}
+
+// RemoteDiff computes which blobs of the snapshots ids in the local
+// repository are missing in the remote repository, for example to estimate
+// the cost of copying a backup over a metered link. Only the index of the
+// remote repository is loaded, no data is downloaded from it and it is not
+// locked. The local repository is locked non-exclusively while its snapshots
+// are traversed.
+func RemoteDiff(ctx context.Context, local restic.Repository, ids restic.IDs, remote restic.Repository) (RemoteDiffResult, error) {
+	result := RemoteDiffResult{
+		Missing:     restic.NewBlobSet(),
+		SameChunker: local.Config().ChunkerPolynomial == remote.Config().ChunkerPolynomial,
+	}
+
+	unlock, err := lockRepository(ctx, local, false)
+	if err != nil {
+		return result, err
+	}
+	defer unlock()
+
+	if err := local.LoadIndex(ctx, nil); err != nil {
+		return result, err
+	}
+
+	var trees restic.IDs
+	for _, id := range ids {
+		sn, err := restic.LoadSnapshot(ctx, local, id)
+		if err != nil {
+			return result, err
+		}
+		if sn.Tree == nil {
+			return result, errors.Errorf("snapshot %v has no tree", id.Str())
+		}
+		trees = append(trees, *sn.Tree)
+	}
+	blobs := restic.NewBlobSet()
+	if err := restic.FindUsedBlobs(ctx, local, trees, blobs, nil); err != nil {
+		return result, err
+	}
+
+	err = remote.List(ctx, restic.IndexFile, func(_ restic.ID, size int64) error {
+		result.IndexBytes += uint64(size)
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+	if err := remote.LoadIndex(ctx, nil); err != nil {
+		return result, err
+	}
+
+	for bh := range blobs {
+		pbs := local.Index().Lookup(bh)
+		if len(pbs) == 0 {
+			return result, errors.Fatalf("blob %v is missing from the index, run check and repair", bh)
+		}
+		size := pbs[0].Length
+
+		total, missing := &result.Data, &result.MissingData
+		if bh.Type == restic.TreeBlob {
+			total, missing = &result.Trees, &result.MissingTrees
+		}
+		total.add(size)
+		if !remote.Index().Has(bh) {
+			missing.add(size)
+			result.Missing.Insert(bh)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,61 @@
+package rapi_test
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/konidev20/rapi"
+	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/backend/mem"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/restic"
+)
+
+// loadRecorder records the types of the files loaded from the backend.
+type loadRecorder struct {
+	backend.Backend
+	m      sync.Mutex
+	loaded map[backend.FileType]int
+}
+
+func (be *loadRecorder) Load(ctx context.Context, h backend.Handle, length int, offset int64, fn func(rd io.Reader) error) error {
+	be.m.Lock()
+	be.loaded[h.Type]++
+	be.m.Unlock()
+	return be.Backend.Load(ctx, h, length, offset, fn)
+}
+
+func TestRemoteDiff(t *testing.T) {
+	ctx := context.Background()
+	local := repository.TestRepository(t)
+	be := &loadRecorder{Backend: mem.New(), loaded: make(map[backend.FileType]int)}
+	remote := repository.TestRepositoryWithBackend(t, be, 0)
+
+	// the remote repository already contains the data of one file
+	saveTestSnapshot(t, remote, testDir{"shared": "shared data"})
+	id := saveTestSnapshot(t, local, testDir{
+		"shared": "shared data",
+		"dir":    testDir{"new": []string{"new", "data"}},
+	})
+	be.loaded = make(map[backend.FileType]int)
+
+	res, err := rapi.RemoteDiff(ctx, local, restic.IDs{id}, remote)
+	rtest.OK(t, err)
+	rtest.Assert(t, res.SameChunker, "test repositories use different chunkers")
+	rtest.Equals(t, 3, res.Data.Blobs)
+	rtest.Equals(t, 2, res.MissingData.Blobs)
+	rtest.Equals(t, 2, res.Trees.Blobs)
+	rtest.Equals(t, 2, res.MissingTrees.Blobs)
+	rtest.Equals(t, 4, len(res.Missing))
+	rtest.Assert(t, res.MissingData.Bytes < res.Data.Bytes, "missing data %d is not smaller than %d",
+		res.MissingData.Bytes, res.Data.Bytes)
+	rtest.Assert(t, res.IndexBytes > 0, "no index size reported")
+
+	// only the index was loaded from the remote repository
+	rtest.Assert(t, be.loaded[backend.IndexFile] > 0, "index was not loaded")
+	rtest.Equals(t, map[backend.FileType]int{backend.IndexFile: be.loaded[backend.IndexFile]}, be.loaded)
+	rtest.Equals(t, 0, countLocks(t, local))
+}
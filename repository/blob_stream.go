@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/internal/hashing"
+	"github.com/konidev20/rapi/restic"
+)
+
+// LoadBlobStream returns a reader for the plaintext of the blob id. In
+// contrast to LoadBlob, the plaintext of a compressed blob is never held in
+// memory completely, only the encrypted blob is buffered while it is
+// decompressed.
+//
+// The hash of a compressed blob can only be verified once all data has been
+// read. The reader therefore returns an error instead of io.EOF if the hash
+// does not match, data returned before must not be trusted until io.EOF has
+// been reached. The reader must be closed after use.
+func (r *Repository) LoadBlobStream(ctx context.Context, t restic.BlobType, id restic.ID) (io.ReadCloser, error) {
+	debug.Log("load stream for %v with id %v", t, id)
+
+	blobs := r.index().Lookup(restic.BlobHandle{ID: id, Type: t})
+	if len(blobs) == 0 {
+		debug.Log("id %v not found in index", id)
+		return nil, errors.Errorf("id %v not found in repository", id)
+	}
+
+	// try cached pack files first
+	sortCachedPacksFirst(r.Cache, blobs)
+
+	var lastError error
+	for _, blob := range blobs {
+		h := backend.Handle{Type: restic.PackFile, Name: blob.PackID.String(), IsMetadata: t.IsMetadata()}
+
+		buf := make([]byte, blob.Length)
+		n, err := backend.ReadAt(ctx, r.Backend(), h, int64(blob.Offset), buf)
+		if err != nil {
+			debug.Log("error loading blob %v: %v", blob, err)
+			lastError = err
+			continue
+		}
+
+		if uint(n) != blob.Length {
+			lastError = errors.Errorf("error loading blob %v: wrong length returned, want %d, got %d",
+				id.Str(), blob.Length, uint(n))
+			debug.Log("lastError: %v", lastError)
+			continue
+		}
+
+		nonce, ciphertext := buf[:r.key.NonceSize()], buf[r.key.NonceSize():]
+		plaintext, err := r.key.Open(ciphertext[:0], nonce, ciphertext, nil)
+		if err != nil {
+			lastError = errors.Errorf("decrypting blob %v failed: %v", id, err)
+			continue
+		}
+
+		if !blob.IsCompressed() {
+			if !r.HashAlgorithm().Sum(plaintext).Equal(id) {
+				lastError = errors.Errorf("blob %v returned invalid hash", id)
+				continue
+			}
+			return io.NopCloser(bytes.NewReader(plaintext)), nil
+		}
+
+		dec, err := zstd.NewReader(bytes.NewReader(plaintext),
+			zstd.WithDecoderConcurrency(1),
+			zstd.WithDecoderLowmem(true),
+			zstd.WithDecoderMaxMemory(16*1024*1024*1024))
+		if err != nil {
+			lastError = errors.Errorf("decompressing blob %v failed: %v", id, err)
+			continue
+		}
+
+		return &blobStream{
+			rd:   hashing.NewReader(dec, r.HashAlgorithm().New()),
+			dec:  dec,
+			id:   id,
+			size: int64(blob.DataLength()),
+		}, nil
+	}
+
+	if lastError != nil {
+		return nil, lastError
+	}
+
+	return nil, errors.Errorf("loading blob %v from %v packs failed", id.Str(), len(blobs))
+}
+
+// blobStream decompresses a blob and verifies its hash at the end.
+type blobStream struct {
+	rd   *hashing.Reader
+	dec  *zstd.Decoder
+	id   restic.ID
+	size int64
+	read int64
+}
+
+func (s *blobStream) Read(p []byte) (int, error) {
+	n, err := s.rd.Read(p)
+	s.read += int64(n)
+	if err != io.EOF {
+		if err != nil {
+			err = errors.Errorf("decompressing blob %v failed: %v", s.id, err)
+		}
+		return n, err
+	}
+
+	if s.read != s.size {
+		return n, errors.Errorf("blob %v has wrong length, want %d, got %d", s.id.Str(), s.size, s.read)
+	}
+
+	var hash restic.ID
+	s.rd.Sum(hash[:0])
+	if !hash.Equal(s.id) {
+		return n, errors.Errorf("blob %v returned invalid hash", s.id)
+	}
+
+	return n, io.EOF
+}
+
+func (s *blobStream) Close() error {
+	s.dec.Close()
+	return nil
+}
@@ -0,0 +1,181 @@
+package repository
+
+import (
+	"context"
+	"io"
+	"sort"
+
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/restic"
+)
+
+// DefaultReadAhead is the number of blobs a FileReader loads in advance if no
+// other value is given.
+const DefaultReadAhead = 4
+
+// FileReader reads the contents of a file stored as a sequence of data blobs.
+// Only the current blob and up to readAhead following blobs are held in
+// memory, so that files of arbitrary size can be read with bounded memory.
+// The following blobs are loaded in the background while the current blob is
+// read.
+//
+// A FileReader must not be used concurrently.
+type FileReader struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	repo   restic.Repository
+
+	content   restic.IDs
+	offsets   []int64
+	readAhead int
+
+	pos     int64
+	cur     []byte
+	curIdx  int
+	pending map[int]*blobLoad
+}
+
+type blobLoad struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	buf    []byte
+	err    error
+}
+
+// NewFileReader returns a reader for the file which consists of the data
+// blobs in content. The sizes of all blobs are looked up in the index of repo,
+// which must already be loaded. If readAhead is zero, DefaultReadAhead is
+// used, a negative value disables loading blobs in advance. The reader must be
+// closed after use to stop loading blobs in the background.
+func NewFileReader(ctx context.Context, repo restic.Repository, content restic.IDs, readAhead int) (*FileReader, error) {
+	offsets := make([]int64, len(content)+1)
+	for i, id := range content {
+		size, found := repo.LookupBlobSize(id, restic.DataBlob)
+		if !found {
+			return nil, errors.Errorf("id %v not found in repository", id)
+		}
+		offsets[i+1] = offsets[i] + int64(size)
+	}
+
+	switch {
+	case readAhead == 0:
+		readAhead = DefaultReadAhead
+	case readAhead < 0:
+		readAhead = 0
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	return &FileReader{
+		ctx:       ctx,
+		cancel:    cancel,
+		repo:      repo,
+		content:   content,
+		offsets:   offsets,
+		readAhead: readAhead,
+		curIdx:    -1,
+		pending:   make(map[int]*blobLoad),
+	}, nil
+}
+
+// Size returns the size of the file.
+func (f *FileReader) Size() int64 {
+	return f.offsets[len(f.offsets)-1]
+}
+
+func (f *FileReader) Read(p []byte) (int, error) {
+	if f.pos >= f.Size() {
+		return 0, io.EOF
+	}
+
+	// find the last blob which starts at or before pos
+	i := sort.Search(len(f.content), func(i int) bool {
+		return f.offsets[i+1] > f.pos
+	})
+
+	if i != f.curIdx {
+		buf, err := f.load(i)
+		if err != nil {
+			return 0, err
+		}
+		f.cur, f.curIdx = buf, i
+	}
+
+	n := copy(p, f.cur[f.pos-f.offsets[i]:])
+	f.pos += int64(n)
+	return n, nil
+}
+
+// Seek implements io.Seeker. Blobs loaded in advance which are not needed at
+// the new position are discarded.
+func (f *FileReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.Size()
+	default:
+		return f.pos, errors.Errorf("invalid whence %d", whence)
+	}
+
+	if offset < 0 {
+		return f.pos, errors.New("negative position")
+	}
+
+	f.pos = offset
+	return f.pos, nil
+}
+
+// Close stops loading blobs and releases all buffers.
+func (f *FileReader) Close() error {
+	f.cancel()
+	f.pending = make(map[int]*blobLoad)
+	f.cur = nil
+	f.curIdx = -1
+	return nil
+}
+
+// load returns blob i and starts loading the following blobs.
+func (f *FileReader) load(i int) ([]byte, error) {
+	// discard loads outside of the read-ahead window
+	for j, l := range f.pending {
+		if j < i || j > i+f.readAhead {
+			debug.Log("discarding blob %d", j)
+			l.cancel()
+			delete(f.pending, j)
+		}
+	}
+
+	for j := i; j <= i+f.readAhead && j < len(f.content); j++ {
+		if _, ok := f.pending[j]; !ok {
+			f.pending[j] = f.start(j)
+		}
+	}
+
+	l := f.pending[i]
+	delete(f.pending, i)
+
+	select {
+	case <-l.done:
+	case <-f.ctx.Done():
+		return nil, f.ctx.Err()
+	}
+	return l.buf, l.err
+}
+
+func (f *FileReader) start(i int) *blobLoad {
+	ctx, cancel := context.WithCancel(f.ctx)
+	l := &blobLoad{cancel: cancel, done: make(chan struct{})}
+
+	go func() {
+		defer close(l.done)
+		defer cancel()
+		l.buf, l.err = f.repo.LoadBlob(ctx, restic.DataBlob, f.content[i], nil)
+	}()
+
+	return l
+}
+
+// statically ensure that FileReader implements io.ReadSeekCloser.
+var _ io.ReadSeekCloser = &FileReader{}
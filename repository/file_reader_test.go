@@ -0,0 +1,94 @@
+package repository_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/restic"
+	"golang.org/x/sync/errgroup"
+)
+
+func saveFileBlobs(t *testing.T, repo restic.Repository, sizes []int) (restic.IDs, []byte) {
+	var wg errgroup.Group
+	repo.StartPackUploader(context.TODO(), &wg)
+
+	var ids restic.IDs
+	var content []byte
+	for _, size := range sizes {
+		data := make([]byte, size)
+		_, err := io.ReadFull(rnd, data)
+		rtest.OK(t, err)
+
+		id, _, _, err := repo.SaveBlob(context.TODO(), restic.DataBlob, data, restic.ID{}, false)
+		rtest.OK(t, err)
+
+		ids = append(ids, id)
+		content = append(content, data...)
+	}
+
+	rtest.OK(t, repo.Flush(context.TODO()))
+	return ids, content
+}
+
+func TestLoadBlobStream(t *testing.T) {
+	repository.TestAllVersions(t, testLoadBlobStream)
+}
+
+func testLoadBlobStream(t *testing.T, version uint) {
+	repo := repository.TestRepositoryWithVersion(t, version).(*repository.Repository)
+	ids, content := saveFileBlobs(t, repo, []int{1 << 20})
+
+	rd, err := repo.LoadBlobStream(context.TODO(), restic.DataBlob, ids[0])
+	rtest.OK(t, err)
+
+	buf, err := io.ReadAll(rd)
+	rtest.OK(t, err)
+	rtest.OK(t, rd.Close())
+	rtest.Assert(t, bytes.Equal(buf, content), "data does not match")
+
+	_, err = repo.LoadBlobStream(context.TODO(), restic.DataBlob, restic.NewRandomID())
+	rtest.Assert(t, err != nil, "expected error for unknown blob")
+}
+
+func TestFileReader(t *testing.T) {
+	repo := repository.TestRepository(t)
+	ids, content := saveFileBlobs(t, repo, []int{5, 23, 2<<18 + 23, 1 << 20, 1000})
+
+	for _, readAhead := range []int{-1, 0, 1, 10} {
+		rd, err := repository.NewFileReader(context.TODO(), repo, ids, readAhead)
+		rtest.OK(t, err)
+		rtest.Equals(t, int64(len(content)), rd.Size())
+
+		buf, err := io.ReadAll(rd)
+		rtest.OK(t, err)
+		rtest.Assert(t, bytes.Equal(buf, content), "data does not match for read-ahead %d", readAhead)
+
+		for _, offset := range []int64{int64(len(content)) - 10, 0, 17, 2 << 18} {
+			pos, err := rd.Seek(offset, io.SeekStart)
+			rtest.OK(t, err)
+			rtest.Equals(t, offset, pos)
+
+			buf := make([]byte, 10)
+			_, err = io.ReadFull(rd, buf)
+			rtest.OK(t, err)
+			rtest.Equals(t, content[offset:offset+10], buf)
+		}
+
+		pos, err := rd.Seek(-5, io.SeekEnd)
+		rtest.OK(t, err)
+		rtest.Equals(t, int64(len(content)-5), pos)
+
+		rest, err := io.ReadAll(rd)
+		rtest.OK(t, err)
+		rtest.Equals(t, content[len(content)-5:], rest)
+
+		rtest.OK(t, rd.Close())
+	}
+
+	_, err := repository.NewFileReader(context.TODO(), repo, restic.IDs{restic.NewRandomID()}, 0)
+	rtest.Assert(t, err != nil, "expected error for unknown blob")
+}
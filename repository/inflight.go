@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"context"
+	"sync"
+)
+
+// inFlightLimiter limits the total size of the blobs which are saved but
+// whose pack is not uploaded yet, see Options.MaxInFlightBytes. The weight
+// of a blob is acquired before it is compressed and released once its pack
+// was uploaded or discarded. A nil limiter does not limit anything.
+type inFlightLimiter struct {
+	max int64
+
+	mu      sync.Mutex
+	cur     int64
+	waiters int
+	// released is closed and replaced whenever weight is released
+	released chan struct{}
+}
+
+func newInFlightLimiter(max uint64) *inFlightLimiter {
+	return &inFlightLimiter{
+		max:      int64(max),
+		released: make(chan struct{}),
+	}
+}
+
+// acquire blocks until n bytes are available. The packs which are not full
+// yet would only release their weight once further blobs fill them, so
+// flush is called to upload them early before waiting. A blob larger than
+// the limit is admitted once nothing else is in flight.
+func (l *inFlightLimiter) acquire(ctx context.Context, n int64, flush func(context.Context) error) error {
+	if l == nil {
+		return nil
+	}
+
+	for {
+		l.mu.Lock()
+		if l.cur == 0 || l.cur+n <= l.max {
+			l.cur += n
+			l.mu.Unlock()
+			return nil
+		}
+		l.waiters++
+		released := l.released
+		l.mu.Unlock()
+
+		err := flush(ctx)
+		if err == nil {
+			select {
+			case <-released:
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+		}
+
+		l.mu.Lock()
+		l.waiters--
+		l.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// release returns n bytes to the limiter.
+func (l *inFlightLimiter) release(n int64) {
+	if l == nil || n == 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cur -= n
+	close(l.released)
+	l.released = make(chan struct{})
+}
+
+// waiting returns true if a call to acquire waits for weight to be released.
+// A pack which receives a blob in the meantime must then be uploaded right
+// away, as the waiting call has already flushed the packs.
+func (l *inFlightLimiter) waiting() bool {
+	if l == nil {
+		return false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.waiters > 0
+}
@@ -7,6 +7,8 @@ import (
 	"os"
 	"runtime"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/konidev20/rapi/backend"
 	"github.com/konidev20/rapi/internal/errors"
@@ -16,9 +18,8 @@ import (
 	"github.com/konidev20/rapi/crypto"
 	"github.com/konidev20/rapi/internal/debug"
 	"github.com/konidev20/rapi/internal/fs"
+	"github.com/konidev20/rapi/internal/metrics"
 	"github.com/konidev20/rapi/pack"
-
-	"github.com/minio/sha256-simd"
 )
 
 // Packer holds a pack.Packer together with a hash writer.
@@ -26,6 +27,17 @@ type Packer struct {
 	*pack.Packer
 	tmpfile *os.File
 	bufWr   *bufio.Writer
+
+	// held is the weight of the blobs in the pack which was acquired from
+	// inFlight, it is released once the pack is uploaded or discarded
+	inFlight *inFlightLimiter
+	held     int64
+}
+
+// releaseInFlight releases the weight of the blobs in the pack.
+func (p *Packer) releaseInFlight() {
+	p.inFlight.release(p.held)
+	p.held = 0
 }
 
 // packerManager keeps a list of open packs and creates new on demand.
@@ -34,19 +46,85 @@ type packerManager struct {
 	key     *crypto.Key
 	queueFn func(ctx context.Context, t restic.BlobType, p *Packer) error
 
-	pm       sync.Mutex
-	packer   *Packer
-	packSize uint
+	pm     sync.Mutex
+	packer *Packer
+
+	// inFlight limits the size of the blobs which are not uploaded yet, it
+	// is shared by the packer managers of a repository and may be nil.
+	inFlight *inFlightLimiter
+
+	// packSize is the current target size of a pack, it is only modified
+	// by adapt when the size is adaptive. It is accessed atomically, as
+	// adapt is called by the uploader while SaveBlob may hold pm.
+	packSize       atomic.Uint64
+	minPackSize    uint
+	targetPackSize uint
+
+	// synthetic makes new packers derive the nonce of the header from its
+	// contents.
+	synthetic bool
 }
 
+// Pack uploads which take less time than fastPackUpload increase the size of
+// adaptive packs, uploads slower than slowPackUpload decrease it.
+const (
+	fastPackUpload = 5 * time.Second
+	slowPackUpload = 30 * time.Second
+)
+
 // newPackerManager returns a new packer manager which writes temporary files
 // to a temporary directory
 func newPackerManager(key *crypto.Key, tpe restic.BlobType, packSize uint, queueFn func(ctx context.Context, t restic.BlobType, p *Packer) error) *packerManager {
-	return &packerManager{
-		tpe:      tpe,
-		key:      key,
-		queueFn:  queueFn,
-		packSize: packSize,
+	r := &packerManager{
+		tpe:            tpe,
+		key:            key,
+		queueFn:        queueFn,
+		minPackSize:    packSize,
+		targetPackSize: packSize,
+	}
+	r.packSize.Store(uint64(packSize))
+	return r
+}
+
+// setPackSizeTarget makes the pack size adaptive, it grows up to target.
+func (r *packerManager) setPackSizeTarget(target uint) {
+	r.targetPackSize = target
+}
+
+// PackSize returns the current target size of a pack.
+func (r *packerManager) PackSize() uint {
+	return uint(r.packSize.Load())
+}
+
+// adapt adjusts the pack size based on the time d it took to upload a pack of
+// size bytes. Packs which are much smaller than the current pack size, for
+// example those written by Flush, are ignored.
+func (r *packerManager) adapt(size uint64, d time.Duration) {
+	if r.targetPackSize <= r.minPackSize {
+		return
+	}
+
+	cur := r.packSize.Load()
+	if size < cur/2 {
+		return
+	}
+
+	next := cur
+	switch {
+	case d < fastPackUpload:
+		next = cur + cur/2
+		if next > uint64(r.targetPackSize) {
+			next = uint64(r.targetPackSize)
+		}
+	case d > slowPackUpload:
+		next = cur - cur/4
+		if next < uint64(r.minPackSize) {
+			next = uint64(r.minPackSize)
+		}
+	}
+
+	if next != cur && r.packSize.CompareAndSwap(cur, next) {
+		debug.Log("%v pack uploaded in %v, pack size changed from %d to %d", r.tpe, d, cur, next)
 	}
 }
 
@@ -65,18 +143,23 @@ func (r *packerManager) Flush(ctx context.Context) error {
 	return nil
 }
 
-func (r *packerManager) SaveBlob(ctx context.Context, t restic.BlobType, id restic.ID, ciphertext []byte, uncompressedLength int) (int, error) {
+// SaveBlob adds the blob to a pack. The weight acquired from r.inFlight for
+// the blob is released once the pack is uploaded, or immediately if the blob
+// cannot be added.
+func (r *packerManager) SaveBlob(ctx context.Context, t restic.BlobType, id restic.ID, ciphertext []byte, uncompressedLength int, weight int64) (int, error) {
 	r.pm.Lock()
 	defer r.pm.Unlock()
 
 	var err error
+	packSize := r.PackSize()
 	packer := r.packer
 	// use separate packer if compressed length is larger than the packsize
 	// this speeds up the garbage collection of oversized blobs and reduces the cache size
 	// as the oversize blobs are only downloaded if necessary
-	if len(ciphertext) >= int(r.packSize) || r.packer == nil {
+	if len(ciphertext) >= int(packSize) || r.packer == nil {
 		packer, err = r.newPacker()
 		if err != nil {
+			r.inFlight.release(weight)
 			return 0, err
 		}
 		// don't store packer for oversized blob
@@ -89,11 +172,13 @@ func (r *packerManager) SaveBlob(ctx context.Context, t restic.BlobType, id rest
 	// Add only appends bytes in memory to avoid being a scaling bottleneck
 	size, err := packer.Add(t, id, ciphertext, uncompressedLength)
 	if err != nil {
+		r.inFlight.release(weight)
 		return 0, err
 	}
+	packer.held += weight
 
 	// if the pack and header is not full enough, put back to the list
-	if packer.Size() < r.packSize && !packer.HeaderFull() {
+	if packer.Size() < packSize && !packer.HeaderFull() {
 		debug.Log("pack is not full enough (%d bytes)", packer.Size())
 		return size, nil
 	}
@@ -123,17 +208,34 @@ func (r *packerManager) newPacker() (packer *Packer, err error) {
 
 	bufWr := bufio.NewWriter(tmpfile)
 	p := pack.NewPacker(r.key, bufWr)
+	if r.synthetic {
+		p.UseSyntheticNonce()
+	}
 	packer = &Packer{
-		Packer:  p,
-		tmpfile: tmpfile,
-		bufWr:   bufWr,
+		Packer:   p,
+		tmpfile:  tmpfile,
+		bufWr:    bufWr,
+		inFlight: r.inFlight,
 	}
 
 	return packer, nil
 }
 
+// packerManager returns the packer manager for blobs of type t.
+func (r *Repository) packerManager(t restic.BlobType) *packerManager {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if t == restic.TreeBlob {
+		return r.treePM
+	}
+	return r.dataPM
+}
+
 // savePacker stores p in the backend.
 func (r *Repository) savePacker(ctx context.Context, t restic.BlobType, p *Packer) error {
+	defer p.releaseInFlight()
+
 	debug.Log("save packer for %v with %d blobs (%d bytes)\n", t, p.Packer.Count(), p.Packer.Size())
 	err := p.Packer.Finalize()
 	if err != nil {
@@ -144,20 +246,21 @@ func (r *Repository) savePacker(ctx context.Context, t restic.BlobType, p *Packe
 		return err
 	}
 
-	// calculate sha256 hash in a second pass
+	// calculate the hash in a second pass
 	var rd io.Reader
 	rd, err = backend.NewFileReader(p.tmpfile, nil)
 	if err != nil {
 		return err
 	}
-	beHasher := r.be.Hasher()
+	be := r.Backend()
+	beHasher := be.Hasher()
 	var beHr *hashing.Reader
 	if beHasher != nil {
 		beHr = hashing.NewReader(rd, beHasher)
 		rd = beHr
 	}
 
-	hr := hashing.NewReader(rd, sha256.New())
+	hr := hashing.NewReader(rd, r.HashAlgorithm().New())
 	_, err = io.Copy(io.Discard, hr)
 	if err != nil {
 		return err
@@ -174,11 +277,19 @@ func (r *Repository) savePacker(ctx context.Context, t restic.BlobType, p *Packe
 		return err
 	}
 
-	err = r.be.Save(ctx, h, rrd)
+	start := time.Now()
+	err = be.Save(ctx, h, rrd)
 	if err != nil {
 		debug.Log("Save(%v) error: %v", h, err)
 		return err
 	}
+	d := time.Since(start)
+	restic.StageTimesFromContext(ctx).Add(restic.StageUpload, d)
+	metrics.Default.PackWritten(d)
+	r.opts.Events.PackUploaded(id, t, uint64(p.Packer.Size()), d)
+	if pm := r.packerManager(t); pm != nil {
+		pm.adapt(uint64(p.Packer.Size()), d)
+	}
 
 	debug.Log("saved as %v", h)
 
@@ -197,11 +308,12 @@ func (r *Repository) savePacker(ctx context.Context, t restic.BlobType, p *Packe
 
 	// update blobs in the index
 	debug.Log("  updating blobs %v to pack %v", p.Packer.Blobs(), id)
-	r.idx.StorePack(id, p.Packer.Blobs())
+	r.index().StorePack(id, p.Packer.Blobs())
 
-	// Save index if full
-	if r.noAutoIndexUpdate {
+	// Save index if full. Whether a deterministic index is full depends on the
+	// order the uploads finish, so it is only saved by Flush.
+	if r.noAutoIndexUpdate || r.opts.Deterministic {
 		return nil
 	}
-	return r.idx.SaveFullIndex(ctx, r)
+	return r.index().SaveFullIndex(ctx, r)
 }
@@ -6,6 +6,7 @@ import (
 	"math/rand"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/konidev20/rapi/crypto"
 	"github.com/konidev20/rapi/restic"
@@ -38,7 +39,7 @@ func fillPacks(t testing.TB, rnd *rand.Rand, pm *packerManager, buf []byte) (byt
 		// Only change a few bytes so we know we're not benchmarking the RNG.
 		rnd.Read(buf[:min(l, 4)])
 
-		n, err := pm.SaveBlob(context.TODO(), restic.DataBlob, id, buf, 0)
+		n, err := pm.SaveBlob(context.TODO(), restic.DataBlob, id, buf, 0, 0)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -98,7 +99,7 @@ func TestPackerManagerWithOversizeBlob(t *testing.T) {
 	})
 
 	for _, i := range []uint{sizeLimit / 2, sizeLimit, sizeLimit / 3} {
-		_, err := pm.SaveBlob(context.TODO(), restic.DataBlob, restic.ID{}, make([]byte, i), 0)
+		_, err := pm.SaveBlob(context.TODO(), restic.DataBlob, restic.ID{}, make([]byte, i), 0, 0)
 		test.OK(t, err)
 	}
 	test.OK(t, pm.Flush(context.TODO()))
@@ -107,6 +108,32 @@ func TestPackerManagerWithOversizeBlob(t *testing.T) {
 	test.Equals(t, packFiles, 2)
 }
 
+func TestPackerManagerAdaptivePackSize(t *testing.T) {
+	pm := newPackerManager(crypto.NewRandomKey(), restic.DataBlob, MinPackSize, nil)
+	pm.adapt(MinPackSize, time.Second)
+	test.Equals(t, uint(MinPackSize), pm.PackSize())
+
+	pm.setPackSizeTarget(DefaultPackSize)
+
+	// small packs don't change the pack size
+	pm.adapt(MinPackSize/4, time.Millisecond)
+	test.Equals(t, uint(MinPackSize), pm.PackSize())
+
+	for i := 0; i < 10; i++ {
+		pm.adapt(uint64(pm.PackSize()), time.Second)
+	}
+	test.Equals(t, uint(DefaultPackSize), pm.PackSize())
+
+	// average uploads keep the current size
+	pm.adapt(DefaultPackSize, 10*time.Second)
+	test.Equals(t, uint(DefaultPackSize), pm.PackSize())
+
+	for i := 0; i < 10; i++ {
+		pm.adapt(uint64(pm.PackSize()), time.Minute)
+	}
+	test.Equals(t, uint(MinPackSize), pm.PackSize())
+}
+
 func BenchmarkPackerManager(t *testing.B) {
 	// Run testPackerManager if it hasn't run already, to set totalSize.
 	once.Do(func() {
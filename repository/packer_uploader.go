@@ -2,6 +2,8 @@ package repository
 
 import (
 	"context"
+	"sync"
+	"time"
 
 	"github.com/konidev20/rapi/restic"
 	"golang.org/x/sync/errgroup"
@@ -21,20 +23,32 @@ type packerUploader struct {
 	uploadQueue chan uploadTask
 }
 
-func newPackerUploader(ctx context.Context, wg *errgroup.Group, repo SavePacker, connections uint) *packerUploader {
+// newPackerUploader starts connections goroutines which upload packs. Up to
+// queueDepth packs can be queued without blocking QueuePacker. Once ctx is
+// cancelled, no further uploads are started, but uploads which are already
+// running may complete within grace.
+func newPackerUploader(ctx context.Context, wg *errgroup.Group, repo SavePacker, connections uint, queueDepth uint, grace time.Duration) *packerUploader {
 	pu := &packerUploader{
-		uploadQueue: make(chan uploadTask),
+		uploadQueue: make(chan uploadTask, queueDepth),
 	}
 
+	uploadCtx, cancel := graceContext(ctx, grace)
+	var workers sync.WaitGroup
 	for i := 0; i < int(connections); i++ {
+		workers.Add(1)
 		wg.Go(func() error {
+			defer workers.Done()
 			for {
 				select {
 				case t, ok := <-pu.uploadQueue:
 					if !ok {
 						return nil
 					}
-					err := repo.savePacker(ctx, t.tpe, t.packer)
+					if ctx.Err() != nil {
+						t.packer.discard()
+						return ctx.Err()
+					}
+					err := repo.savePacker(uploadCtx, t.tpe, t.packer)
 					if err != nil {
 						return err
 					}
@@ -44,6 +58,10 @@ func newPackerUploader(ctx context.Context, wg *errgroup.Group, repo SavePacker,
 			}
 		})
 	}
+	go func() {
+		workers.Wait()
+		cancel()
+	}()
 
 	return pu
 }
@@ -0,0 +1,160 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"time"
+
+	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/pack"
+	"github.com/konidev20/rapi/restic"
+)
+
+// The methods in this file transfer encrypted blobs and pack files between
+// repositories which share the same master key, without decrypting and
+// encrypting the data again. Use SameKey to check that the keys match before
+// using them, data saved with a different key cannot be decrypted.
+
+// SameKey returns true if r and other use the same master key, so that
+// encrypted blobs can be copied between them as is.
+func (r *Repository) SameKey(other *Repository) bool {
+	if r.key == nil || other.key == nil {
+		return false
+	}
+	a, b := r.key, other.key
+	return subtle.ConstantTimeCompare(a.EncryptionKey[:], b.EncryptionKey[:]) == 1 &&
+		subtle.ConstantTimeCompare(a.MACKey.K[:], b.MACKey.K[:]) == 1 &&
+		subtle.ConstantTimeCompare(a.MACKey.R[:], b.MACKey.R[:]) == 1
+}
+
+// LoadRawBlob returns the encrypted blob id as stored in a pack file, together
+// with the length of the plaintext if the blob is compressed. The blob is
+// neither decrypted nor verified.
+func (r *Repository) LoadRawBlob(ctx context.Context, t restic.BlobType, id restic.ID) (ciphertext []byte, uncompressedLength uint, err error) {
+	blobs := r.index().Lookup(restic.BlobHandle{ID: id, Type: t})
+	if len(blobs) == 0 {
+		return nil, 0, errors.Errorf("id %v not found in repository", id)
+	}
+
+	// try cached pack files first
+	sortCachedPacksFirst(r.Cache, blobs)
+
+	var lastError error
+	for _, blob := range blobs {
+		h := backend.Handle{Type: restic.PackFile, Name: blob.PackID.String(), IsMetadata: t.IsMetadata()}
+		buf := make([]byte, blob.Length)
+		n, err := backend.ReadAt(ctx, r.Backend(), h, int64(blob.Offset), buf)
+		if err != nil {
+			debug.Log("error loading blob %v: %v", blob, err)
+			lastError = err
+			continue
+		}
+		if uint(n) != blob.Length {
+			lastError = errors.Errorf("error loading blob %v: wrong length returned, want %d, got %d",
+				id.Str(), blob.Length, uint(n))
+			continue
+		}
+
+		return buf, blob.UncompressedLength, nil
+	}
+
+	return nil, 0, lastError
+}
+
+// SaveRawBlob stores a blob which was returned by LoadRawBlob of a
+// repository with the same master key. Like SaveBlob, the blob is only stored
+// if it is not yet known or storeDuplicate is set, and the pack uploader must
+// have been started. Compressed blobs can only be stored in repositories which
+// support compression.
+func (r *Repository) SaveRawBlob(ctx context.Context, t restic.BlobType, id restic.ID, ciphertext []byte, uncompressedLength uint, storeDuplicate bool) (known bool, size int, err error) {
+	if t != restic.DataBlob && t != restic.TreeBlob {
+		return false, 0, errors.Errorf("invalid blob type %v", t)
+	}
+	if version := r.Config().Version; uncompressedLength != 0 && version < 2 {
+		return false, 0, errors.Errorf("blob %v is compressed, but repository version %d does not support compression", id.Str(), version)
+	}
+
+	known = !r.index().AddPending(restic.BlobHandle{ID: id, Type: t})
+	if known && !storeDuplicate {
+		return true, 0, nil
+	}
+
+	debug.Log("save raw blob %v (%v, %d bytes)", id, t, len(ciphertext))
+	size, err = r.packerManager(t).SaveBlob(ctx, t, id, ciphertext, int(uncompressedLength), 0)
+	return known, size, err
+}
+
+// SaveRawPack stores a complete pack file id of a repository with the same
+// master key and adds the blobs it contains to the index. data must be the
+// unmodified pack file, blobs are its contents as listed by the index of the
+// source repository. The pack file is rejected if its hash does not match id,
+// or if its header cannot be decrypted or lists other blobs than blobs.
+func (r *Repository) SaveRawPack(ctx context.Context, id restic.ID, data []byte, blobs []restic.Blob) error {
+	if !r.HashAlgorithm().Sum(data).Equal(id) {
+		return errors.Errorf("pack %v has invalid hash", id.Str())
+	}
+
+	// the index of the source repository may be wrong, only the header of
+	// the pack file is authoritative
+	listed, _, err := pack.List(r.Key(), bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return errors.Wrapf(err, "pack %v", id.Str())
+	}
+	if !sameBlobs(listed, blobs) {
+		return errors.Errorf("pack %v does not contain the given blobs", id.Str())
+	}
+
+	version := r.Config().Version
+	for _, blob := range blobs {
+		if blob.IsCompressed() && version < 2 {
+			return errors.Errorf("pack %v contains compressed blobs, but repository version %d does not support compression", id.Str(), version)
+		}
+	}
+
+	isMetadata := len(blobs) > 0 && blobs[0].Type.IsMetadata()
+	h := backend.Handle{Type: restic.PackFile, Name: id.String(), IsMetadata: isMetadata}
+	start := time.Now()
+	be := r.Backend()
+	if err := be.Save(ctx, h, backend.NewByteReader(data, be.Hasher())); err != nil {
+		debug.Log("Save(%v) error: %v", h, err)
+		return err
+	}
+	d := time.Since(start)
+	debug.Log("saved raw pack %v with %d blobs (%d bytes)", id, len(blobs), len(data))
+
+	var tpe restic.BlobType
+	if len(blobs) > 0 {
+		tpe = blobs[0].Type
+	}
+	r.opts.Events.PackUploaded(id, tpe, uint64(len(data)), d)
+
+	idx := r.index()
+	idx.StorePack(id, blobs)
+	if r.noAutoIndexUpdate {
+		return nil
+	}
+	return idx.SaveFullIndex(ctx, r)
+}
+
+// sameBlobs returns true if a and b contain the same blobs at the same
+// locations, in any order.
+func sameBlobs(a, b []restic.Blob) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	set := make(map[restic.Blob]int, len(a))
+	for _, blob := range a {
+		set[blob]++
+	}
+	for _, blob := range b {
+		if set[blob] == 0 {
+			return false
+		}
+		set[blob]--
+	}
+	return true
+}
@@ -0,0 +1,99 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/konidev20/rapi/backend"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/restic"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestRawCopy(t *testing.T) {
+	TestAllVersions(t, testRawCopy)
+}
+
+func testRawCopy(t *testing.T, version uint) {
+	src := TestRepositoryWithVersion(t, version).(*Repository)
+	dst := TestRepositoryWithVersion(t, version).(*Repository)
+	rtest.Assert(t, !src.SameKey(dst), "different repositories must not share a key")
+	// simulate a repository initialized with the same master key
+	dst.key = src.key
+	rtest.Assert(t, src.SameKey(dst), "keys should match")
+
+	rnd := rand.New(rand.NewSource(42))
+	var wg errgroup.Group
+	src.StartPackUploader(context.TODO(), &wg)
+	data := make(map[restic.ID][]byte)
+	for i := 0; i < 5; i++ {
+		buf := make([]byte, 1000+rnd.Intn(100000))
+		_, _ = rnd.Read(buf)
+		id, _, _, err := src.SaveBlob(context.TODO(), restic.DataBlob, buf, restic.ID{}, false)
+		rtest.OK(t, err)
+		data[id] = buf
+	}
+	rtest.OK(t, src.Flush(context.TODO()))
+	rtest.OK(t, wg.Wait())
+
+	// copy single blobs
+	var wg2 errgroup.Group
+	dst.StartPackUploader(context.TODO(), &wg2)
+	for id := range data {
+		ciphertext, ulen, err := src.LoadRawBlob(context.TODO(), restic.DataBlob, id)
+		rtest.OK(t, err)
+		known, _, err := dst.SaveRawBlob(context.TODO(), restic.DataBlob, id, ciphertext, ulen, false)
+		rtest.OK(t, err)
+		rtest.Assert(t, !known, "blob %v should be unknown", id.Str())
+	}
+	rtest.OK(t, dst.Flush(context.TODO()))
+	rtest.OK(t, wg2.Wait())
+
+	for id, buf := range data {
+		plaintext, err := dst.LoadBlob(context.TODO(), restic.DataBlob, id, nil)
+		rtest.OK(t, err)
+		rtest.Assert(t, bytes.Equal(plaintext, buf), "data of blob %v does not match", id.Str())
+	}
+
+	// copy complete pack files
+	other := TestRepositoryWithVersion(t, version).(*Repository)
+	other.key = src.key
+	foreign := TestRepositoryWithVersion(t, version).(*Repository)
+	for pbs := range src.idx.ListPacks(context.TODO(), src.idx.Packs(restic.NewIDSet())) {
+		var packData []byte
+		h := backend.Handle{Type: restic.PackFile, Name: pbs.PackID.String()}
+		rtest.OK(t, src.Backend().Load(context.TODO(), h, 0, 0, func(rd io.Reader) (err error) {
+			packData, err = io.ReadAll(rd)
+			return err
+		}))
+
+		// the blobs must match the header of the pack file
+		wrong := append([]restic.Blob{}, pbs.Blobs...)
+		wrong[0].Offset++
+		rtest.Assert(t, other.SaveRawPack(context.TODO(), pbs.PackID, packData, wrong) != nil,
+			"pack with wrong blob offset was accepted")
+		wrong = append([]restic.Blob{}, pbs.Blobs...)
+		wrong[0].ID = restic.NewRandomID()
+		rtest.Assert(t, other.SaveRawPack(context.TODO(), pbs.PackID, packData, wrong) != nil,
+			"pack with wrong blob ID was accepted")
+		rtest.Assert(t, other.SaveRawPack(context.TODO(), pbs.PackID, packData, pbs.Blobs[1:]) != nil,
+			"pack with missing blob was accepted")
+		rtest.Assert(t, foreign.SaveRawPack(context.TODO(), pbs.PackID, packData, pbs.Blobs) != nil,
+			"pack encrypted with a different key was accepted")
+		rtest.Equals(t, 0, len(other.idx.Lookup(pbs.Blobs[0].BlobHandle)))
+
+		rtest.OK(t, other.SaveRawPack(context.TODO(), pbs.PackID, packData, pbs.Blobs))
+		packData[len(packData)-1] ^= 0xff
+		rtest.Assert(t, other.SaveRawPack(context.TODO(), pbs.PackID, packData, pbs.Blobs) != nil,
+			"modified pack was accepted")
+	}
+
+	for id, buf := range data {
+		plaintext, err := other.LoadBlob(context.TODO(), restic.DataBlob, id, nil)
+		rtest.OK(t, err)
+		rtest.Assert(t, bytes.Equal(plaintext, buf), "data of blob %v does not match", id.Str())
+	}
+}
@@ -6,22 +6,25 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"os"
 	"runtime"
 	"sort"
 	"sync"
+	"time"
 
 	"github.com/cenkalti/backoff/v4"
 	"github.com/klauspost/compress/zstd"
 	"github.com/konidev20/rapi/backend"
 	"github.com/konidev20/rapi/backend/dryrun"
+	"github.com/konidev20/rapi/backend/readonly"
 	"github.com/konidev20/rapi/crypto"
 	"github.com/konidev20/rapi/internal/cache"
 	"github.com/konidev20/rapi/internal/debug"
 	"github.com/konidev20/rapi/internal/errors"
 	"github.com/konidev20/rapi/internal/index"
+	"github.com/konidev20/rapi/internal/tracing"
 	"github.com/konidev20/rapi/pack"
 	"github.com/konidev20/rapi/restic"
+	"github.com/konidev20/rapi/ui/events"
 	"github.com/konidev20/rapi/ui/progress"
 	"github.com/restic/chunker"
 
@@ -35,7 +38,21 @@ const DefaultPackSize = 16 * 1024 * 1024
 const MaxPackSize = 128 * 1024 * 1024
 
 // Repository is used to access a repository in a backend.
+//
+// Blobs can be loaded by multiple goroutines concurrently, and saved
+// concurrently between StartPackUploader and Flush. Only one operation which
+// saves blobs can use a Repository at a time, since the pack uploader is
+// started and flushed once per operation; concurrent operations need one
+// Repository each, see rapi.OpenPool. Methods which configure the
+// repository, such as UseCache, SetDryRun and DisableAutoIndexUpdate, should
+// be called before it is shared. StartPackUploader, Flush and Shutdown must
+// not be called concurrently with SaveBlob.
 type Repository struct {
+	// mu guards the fields which are replaced while the repository is in
+	// use: the backend, the config, the index and the state of the pack
+	// uploader. The index itself is safe for concurrent use.
+	mu sync.RWMutex
+
 	be    backend.Backend
 	cfg   restic.Config
 	key   *crypto.Key
@@ -43,6 +60,10 @@ type Repository struct {
 	idx   *index.MasterIndex
 	Cache *cache.Cache
 
+	// hash computes the IDs of files and blobs, it depends on the
+	// repository version.
+	hash restic.HashAlgorithm
+
 	opts Options
 
 	noAutoIndexUpdate bool
@@ -58,9 +79,78 @@ type Repository struct {
 	dec      *zstd.Decoder
 }
 
+var _ restic.BlobStore = &Repository{}
+
 type Options struct {
 	Compression CompressionMode
 	PackSize    uint
+
+	// PackSizeTarget enables adaptive pack sizes if it is larger than
+	// PackSize. While pack files are uploaded quickly, the size of new pack
+	// files is increased step by step up to PackSizeTarget. If uploads become
+	// slow, it is decreased again, but never below PackSize.
+	PackSizeTarget uint
+
+	// PackUploaders is the number of pack files which are uploaded
+	// concurrently, it defaults to the number of backend connections.
+	PackUploaders uint
+
+	// PackQueueDepth is the number of finished pack files which can wait
+	// for an uploader before saving blobs blocks. Finished pack files are
+	// stored in temporary files, not in memory. It defaults to zero.
+	PackQueueDepth uint
+
+	// MaxInFlightBytes limits the total size of the blobs which are saved
+	// but whose pack file is not uploaded yet. If the limit is reached,
+	// SaveBlob blocks until packs are uploaded, which in turn slows down
+	// reading and chunking files during a backup. Packs which are not full
+	// are then uploaded early, so a limit below the pack size results in
+	// smaller packs. Zero means no limit. The limit is ignored by a
+	// deterministic repository, as it would make the pack contents depend
+	// on the upload speed.
+	MaxInFlightBytes uint64
+
+	// MaxMemoryBytes is the memory budget for operations on the
+	// repository, zero means no limit. Loading the index, saving blobs and
+	// restoring files use fewer workers or smaller batches to stay within
+	// the budget. If MaxInFlightBytes is zero, it defaults to a quarter of
+	// the budget.
+	MaxMemoryBytes uint64
+
+	// OnDiskIndex keeps the index loaded from the repository in a sorted
+	// table in a local file instead of in memory, for repositories whose
+	// index does not fit into the memory of small devices. Lookups are
+	// slower. The file is stored in the cache directory, or in the default
+	// directory for temporary files if there is no cache on disk.
+	OnDiskIndex bool
+
+	// Events receives an event for each pack file uploaded, may be nil.
+	Events *events.Emitter
+
+	// Warnings collects problems which do not stop an operation, for
+	// example stale cache files which could not be removed. May be nil.
+	Warnings *restic.Warnings
+
+	// ReadOnly rejects all modifications of the repository, including the
+	// creation of lock files.
+	ReadOnly bool
+
+	// Capabilities describes the backend, it is returned by Capabilities.
+	Capabilities backend.Capabilities
+
+	// Deterministic makes the files written to the repository depend only
+	// on their contents: blobs and files are encrypted with nonces derived
+	// from the plaintext, pack sizes are not adapted to the upload speed and
+	// new index entries are only saved by Flush, in a single index file
+	// sorted by pack ID. Saving the same blobs in the same order to two
+	// copies of a repository then produces byte-identical files. Synthetic
+	// nonces reveal which encrypted files and blobs have the same plaintext.
+	Deterministic bool
+
+	// ShutdownTimeout is the time granted to pack uploads which are already
+	// running when the context of the uploader is cancelled, and to saving
+	// the index in Shutdown. It defaults to DefaultShutdownTimeout.
+	ShutdownTimeout time.Duration
 }
 
 // CompressionMode configures if data should be compressed.
@@ -122,16 +212,45 @@ func New(be backend.Backend, opts Options) (*Repository, error) {
 	} else if opts.PackSize < MinPackSize {
 		return nil, fmt.Errorf("pack size smaller than minimum of %v MiB", MinPackSize/1024/1024)
 	}
+	if opts.PackSizeTarget > MaxPackSize {
+		return nil, fmt.Errorf("pack size target larger than limit of %v MiB", MaxPackSize/1024/1024)
+	}
+
+	if opts.ReadOnly {
+		be = readonly.New(be)
+	}
+	if opts.ShutdownTimeout == 0 {
+		opts.ShutdownTimeout = DefaultShutdownTimeout
+	}
+	if opts.MaxInFlightBytes == 0 && opts.MaxMemoryBytes > 0 {
+		opts.MaxInFlightBytes = opts.MaxMemoryBytes / 4
+	}
 
 	repo := &Repository{
 		be:   be,
 		opts: opts,
 		idx:  index.NewMasterIndex(),
+		hash: restic.SHA256,
 	}
-
 	return repo, nil
 }
 
+// MemoryBudget returns the memory budget configured in the options, zero means
+// no limit.
+func (r *Repository) MemoryBudget() uint64 {
+	return r.opts.MaxMemoryBytes
+}
+
+// Capabilities returns the capabilities of the backend passed in the options.
+func (r *Repository) Capabilities() backend.Capabilities {
+	return r.opts.Capabilities
+}
+
+// IsReadOnly returns true if the repository rejects all modifications.
+func (r *Repository) IsReadOnly() bool {
+	return r.opts.ReadOnly
+}
+
 // DisableAutoIndexUpdate deactives the automatic finalization and upload of new
 // indexes once these are full
 func (r *Repository) DisableAutoIndexUpdate() {
@@ -140,14 +259,54 @@ func (r *Repository) DisableAutoIndexUpdate() {
 
 // setConfig assigns the given config and updates the repository parameters accordingly
 func (r *Repository) setConfig(cfg restic.Config) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	r.cfg = cfg
 	if r.cfg.Version >= 2 {
 		r.idx.MarkCompressed()
 	}
+	if alg, err := cfg.HashAlgorithm(); err == nil {
+		r.hash = alg
+		if r.Cache != nil {
+			r.Cache.SetHashAlgorithm(alg)
+		}
+	}
+}
+
+// ReloadConfig loads the repository configuration again, for example after
+// it was changed by a migration.
+func (r *Repository) ReloadConfig(ctx context.Context) error {
+	cfg, err := restic.LoadConfig(ctx, r)
+	if err != nil {
+		return fmt.Errorf("config cannot be loaded: %w", err)
+	}
+
+	r.setConfig(cfg)
+	return nil
+}
+
+// HashAlgorithm returns the hash algorithm which computes the IDs of the
+// files and blobs in the repository.
+func (r *Repository) HashAlgorithm() restic.HashAlgorithm {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.hash
+}
+
+// fileHash returns the hash algorithm for files of type t. Key files are read
+// before the config, so their IDs are always computed with SHA-256.
+func (r *Repository) fileHash(t restic.FileType) restic.HashAlgorithm {
+	if t == restic.KeyFile {
+		return restic.SHA256
+	}
+	return r.HashAlgorithm()
 }
 
 // Config returns the repository configuration.
 func (r *Repository) Config() restic.Config {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	return r.cfg
 }
 
@@ -162,12 +321,17 @@ func (r *Repository) UseCache(c *cache.Cache) {
 		return
 	}
 	debug.Log("using cache")
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	r.Cache = c
 	r.be = c.Wrap(r.be)
+	c.SetHashAlgorithm(r.hash)
 }
 
 // SetDryRun sets the repo backend into dry-run mode.
 func (r *Repository) SetDryRun() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	r.be = dryrun.New(r.be)
 }
 
@@ -186,7 +350,7 @@ func (r *Repository) LoadUnpacked(ctx context.Context, t restic.FileType, id res
 	var dataErr error
 	wr := new(bytes.Buffer)
 
-	err := r.be.Load(ctx, h, 0, 0, func(rd io.Reader) error {
+	err := r.Backend().Load(ctx, h, 0, 0, func(rd io.Reader) error {
 		// make sure this call is idempotent, in case an error occurs
 		wr.Reset()
 		_, cerr := io.Copy(wr, rd)
@@ -195,7 +359,7 @@ func (r *Repository) LoadUnpacked(ctx context.Context, t restic.FileType, id res
 		}
 
 		buf := wr.Bytes()
-		if t != restic.ConfigFile && !restic.Hash(buf).Equal(id) {
+		if t != restic.ConfigFile && !r.fileHash(t).Sum(buf).Equal(id) {
 			debug.Log("retry loading broken blob %v", h)
 			if !retriedInvalidData {
 				retriedInvalidData = true
@@ -266,7 +430,7 @@ func (r *Repository) LoadBlob(ctx context.Context, t restic.BlobType, id restic.
 	debug.Log("load %v with id %v (buf len %v, cap %d)", t, id, len(buf), cap(buf))
 
 	// lookup packs
-	blobs := r.idx.Lookup(restic.BlobHandle{ID: id, Type: t})
+	blobs := r.index().Lookup(restic.BlobHandle{ID: id, Type: t})
 	if len(blobs) == 0 {
 		debug.Log("id %v not found in index", id)
 		return nil, errors.Errorf("id %v not found in repository", id)
@@ -293,7 +457,7 @@ func (r *Repository) LoadBlob(ctx context.Context, t restic.BlobType, id restic.
 			buf = buf[:blob.Length]
 		}
 
-		n, err := backend.ReadAt(ctx, r.be, h, int64(blob.Offset), buf)
+		n, err := backend.ReadAt(ctx, r.Backend(), h, int64(blob.Offset), buf)
 		if err != nil {
 			debug.Log("error loading blob %v: %v", blob, err)
 			lastError = err
@@ -324,7 +488,7 @@ func (r *Repository) LoadBlob(ctx context.Context, t restic.BlobType, id restic.
 		}
 
 		// check hash
-		if !restic.Hash(plaintext).Equal(id) {
+		if !r.HashAlgorithm().Sum(plaintext).Equal(id) {
 			lastError = errors.Errorf("blob %v returned invalid hash", id)
 			continue
 		}
@@ -347,7 +511,20 @@ func (r *Repository) LoadBlob(ctx context.Context, t restic.BlobType, id restic.
 
 // LookupBlobSize returns the size of blob id.
 func (r *Repository) LookupBlobSize(id restic.ID, tpe restic.BlobType) (uint, bool) {
-	return r.idx.LookupSize(restic.BlobHandle{ID: id, Type: tpe})
+	return r.index().LookupSize(restic.BlobHandle{ID: id, Type: tpe})
+}
+
+// LookupBlob returns the locations of blob id in pack files, there is more than
+// one if the blob is stored in several packs. Blobs which have been saved but
+// not yet flushed are not returned.
+func (r *Repository) LookupBlob(t restic.BlobType, id restic.ID) []restic.PackedBlob {
+	return r.index().Lookup(restic.BlobHandle{ID: id, Type: t})
+}
+
+// HasBlob returns true if the repository contains blob id, or if it is
+// currently being saved.
+func (r *Repository) HasBlob(t restic.BlobType, id restic.ID) bool {
+	return r.index().Has(restic.BlobHandle{ID: id, Type: t})
 }
 
 func (r *Repository) getZstdEncoder() *zstd.Encoder {
@@ -403,44 +580,84 @@ func (r *Repository) getZstdDecoder() *zstd.Decoder {
 func (r *Repository) saveAndEncrypt(ctx context.Context, t restic.BlobType, data []byte, id restic.ID) (size int, err error) {
 	debug.Log("save id %v (%v, %d bytes)", id, t, len(data))
 
+	if t != restic.TreeBlob && t != restic.DataBlob {
+		panic(fmt.Sprintf("invalid type: %v", t))
+	}
+	pm := r.packerManager(t)
+	if pm == nil {
+		return 0, errors.New("pack uploader not started")
+	}
+
+	weight := int64(len(data))
+	if err := pm.inFlight.acquire(ctx, weight, r.flushOpenPacks); err != nil {
+		return 0, err
+	}
+
+	stages := restic.StageTimesFromContext(ctx)
+
 	uncompressedLength := 0
-	if r.cfg.Version > 1 {
+	if r.Config().Version > 1 {
 
 		// we have a repo v2, so compression is available. if the user opts to
 		// not compress, we won't compress any data, but everything else is
 		// compressed.
 		if r.opts.Compression != CompressionOff || t != restic.DataBlob {
+			start := time.Now()
 			uncompressedLength = len(data)
 			data = r.getZstdEncoder().EncodeAll(data, nil)
+			stages.Since(restic.StageCompress, start)
 		}
 	}
 
-	nonce := crypto.NewRandomNonce()
+	start := time.Now()
+	nonce := r.newNonce(data)
 
 	ciphertext := make([]byte, 0, crypto.CiphertextLength(len(data)))
 	ciphertext = append(ciphertext, nonce...)
 
 	// encrypt blob
 	ciphertext = r.key.Seal(ciphertext, nonce, data, nil)
+	stages.Since(restic.StageEncrypt, start)
 
-	// find suitable packer and add blob
-	var pm *packerManager
+	size, err = pm.SaveBlob(ctx, t, id, ciphertext, uncompressedLength, weight)
+	if err == nil && pm.inFlight.waiting() {
+		// another blob waits for weight, which is only released once the
+		// pack is uploaded
+		err = pm.Flush(ctx)
+	}
+	return size, err
+}
 
-	switch t {
-	case restic.TreeBlob:
-		pm = r.treePM
-	case restic.DataBlob:
-		pm = r.dataPM
-	default:
-		panic(fmt.Sprintf("invalid type: %v", t))
+// flushOpenPacks queues the packs which are not full yet for upload, so that
+// the weight of their blobs is released, see inFlightLimiter.
+func (r *Repository) flushOpenPacks(ctx context.Context) error {
+	r.mu.RLock()
+	treePM, dataPM := r.treePM, r.dataPM
+	r.mu.RUnlock()
+
+	for _, pm := range []*packerManager{treePM, dataPM} {
+		if pm == nil {
+			continue
+		}
+		if err := pm.Flush(ctx); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	return pm.SaveBlob(ctx, t, id, ciphertext, uncompressedLength)
+// newNonce returns the nonce to encrypt plaintext with, it is derived from the
+// plaintext if the repository is deterministic.
+func (r *Repository) newNonce(plaintext []byte) []byte {
+	if r.opts.Deterministic {
+		return r.key.NewSyntheticNonce(plaintext)
+	}
+	return crypto.NewRandomNonce()
 }
 
 func (r *Repository) compressUnpacked(p []byte) ([]byte, error) {
 	// compression is only available starting from version 2
-	if r.cfg.Version < 2 {
+	if r.Config().Version < 2 {
 		return p, nil
 	}
 
@@ -452,7 +669,7 @@ func (r *Repository) compressUnpacked(p []byte) ([]byte, error) {
 
 func (r *Repository) decompressUnpacked(p []byte) ([]byte, error) {
 	// compression is only available starting from version 2
-	if r.cfg.Version < 2 {
+	if r.Config().Version < 2 {
 		return p, nil
 	}
 
@@ -484,7 +701,7 @@ func (r *Repository) SaveUnpacked(ctx context.Context, t restic.FileType, p []by
 
 	ciphertext := crypto.NewBlobBuffer(len(p))
 	ciphertext = ciphertext[:0]
-	nonce := crypto.NewRandomNonce()
+	nonce := r.newNonce(p)
 	ciphertext = append(ciphertext, nonce...)
 
 	ciphertext = r.key.Seal(ciphertext, nonce, p, nil)
@@ -492,11 +709,12 @@ func (r *Repository) SaveUnpacked(ctx context.Context, t restic.FileType, p []by
 	if t == restic.ConfigFile {
 		id = restic.ID{}
 	} else {
-		id = restic.Hash(ciphertext)
+		id = r.fileHash(t).Sum(ciphertext)
 	}
 	h := backend.Handle{Type: t, Name: id.String()}
 
-	err = r.be.Save(ctx, h, backend.NewByteReader(ciphertext, r.be.Hasher()))
+	be := r.Backend()
+	err = be.Save(ctx, h, backend.NewByteReader(ciphertext, be.Hasher()))
 	if err != nil {
 		debug.Log("error saving blob %v: %v", h, err)
 		return restic.ID{}, err
@@ -513,22 +731,49 @@ func (r *Repository) Flush(ctx context.Context) error {
 	}
 
 	// Save index after flushing only if noAutoIndexUpdate is not set
-	if r.noAutoIndexUpdate {
-		return nil
+	if !r.noAutoIndexUpdate {
+		if err := r.index().SaveIndex(ctx, r); err != nil {
+			return err
+		}
 	}
-	return r.idx.SaveIndex(ctx, r)
+	// the uploaded packs are indexed, but blobs may have been saved based
+	// on failed lookups
+	return r.index().Err()
 }
 
 func (r *Repository) StartPackUploader(ctx context.Context, wg *errgroup.Group) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	if r.packerWg != nil {
 		panic("uploader already started")
 	}
 
 	innerWg, ctx := errgroup.WithContext(ctx)
 	r.packerWg = innerWg
-	r.uploader = newPackerUploader(ctx, innerWg, r, r.be.Connections())
+	uploaders := r.opts.PackUploaders
+	if uploaders == 0 {
+		uploaders = r.be.Connections()
+	}
+	r.uploader = newPackerUploader(ctx, innerWg, r, uploaders, r.opts.PackQueueDepth, r.opts.ShutdownTimeout)
 	r.treePM = newPackerManager(r.key, restic.TreeBlob, r.PackSize(), r.uploader.QueuePacker)
 	r.dataPM = newPackerManager(r.key, restic.DataBlob, r.PackSize(), r.uploader.QueuePacker)
+	if r.opts.Deterministic {
+		r.treePM.synthetic = true
+		r.dataPM.synthetic = true
+	} else {
+		if r.opts.PackSizeTarget > r.opts.PackSize {
+			r.treePM.setPackSizeTarget(r.opts.PackSizeTarget)
+			r.dataPM.setPackSizeTarget(r.opts.PackSizeTarget)
+		}
+		// the weight of packs which were not uploaded by a previous
+		// uploader is lost, so each uploader has a limiter of its own
+		if r.opts.MaxInFlightBytes > 0 {
+			inFlight := newInFlightLimiter(r.opts.MaxInFlightBytes)
+			r.treePM.inFlight = inFlight
+			r.dataPM.inFlight = inFlight
+		}
+	}
 
 	wg.Go(func() error {
 		return innerWg.Wait()
@@ -537,53 +782,93 @@ func (r *Repository) StartPackUploader(ctx context.Context, wg *errgroup.Group)
 
 // FlushPacks saves all remaining packs.
 func (r *Repository) flushPacks(ctx context.Context) error {
-	if r.packerWg == nil {
+	r.mu.RLock()
+	wg, uploader, treePM, dataPM := r.packerWg, r.uploader, r.treePM, r.dataPM
+	r.mu.RUnlock()
+	if wg == nil {
 		return nil
 	}
 
-	err := r.treePM.Flush(ctx)
+	err := treePM.Flush(ctx)
 	if err != nil {
 		return err
 	}
-	err = r.dataPM.Flush(ctx)
+	err = dataPM.Flush(ctx)
 	if err != nil {
 		return err
 	}
-	r.uploader.TriggerShutdown()
-	err = r.packerWg.Wait()
+	uploader.TriggerShutdown()
+	err = wg.Wait()
+
+	r.stopPackUploader(wg)
+	return err
+}
 
+// stopPackUploader resets the state of the pack uploader which was waited
+// for with wg, unless another one was started meanwhile.
+func (r *Repository) stopPackUploader(wg *errgroup.Group) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.packerWg != wg {
+		return
+	}
 	r.treePM = nil
 	r.dataPM = nil
 	r.uploader = nil
 	r.packerWg = nil
-
-	return err
 }
 
 // Backend returns the backend for the repository.
 func (r *Repository) Backend() backend.Backend {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	return r.be
 }
 
 func (r *Repository) Connections() uint {
-	return r.be.Connections()
+	return r.Backend().Connections()
 }
 
 // Index returns the currently used MasterIndex.
 func (r *Repository) Index() restic.MasterIndex {
+	return r.index()
+}
+
+func (r *Repository) index() *index.MasterIndex {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	return r.idx
 }
 
 // SetIndex instructs the repository to use the given index.
 func (r *Repository) SetIndex(i restic.MasterIndex) error {
+	r.mu.Lock()
 	r.idx = i.(*index.MasterIndex)
+	r.mu.Unlock()
 	return r.prepareCache()
 }
 
+// clearIndex drops the index files loaded from the repository, but keeps the
+// blobs which are currently saved.
+func (r *Repository) clearIndex() {
+	if err := r.index().Reset(); err != nil {
+		debug.Log("unable to close index: %v", err)
+	}
+}
+
 // LoadIndex loads all index files from the backend in parallel and stores them
-func (r *Repository) LoadIndex(ctx context.Context, p *progress.Counter) error {
+func (r *Repository) LoadIndex(ctx context.Context, p *progress.Counter) (err error) {
+	ctx, span := tracing.Start(ctx, "repository.LoadIndex")
+	defer func() { tracing.End(span, err) }()
+
 	debug.Log("Loading index")
 
+	// reset the index first, so that index files removed since the last
+	// call are dropped, even if loading fails
+	r.clearIndex()
+	mi := r.index()
+
 	indexList, err := restic.MemorizeList(ctx, r, restic.IndexFile)
 	if err != nil {
 		return err
@@ -602,11 +887,26 @@ func (r *Repository) LoadIndex(ctx context.Context, p *progress.Counter) error {
 		defer p.Done()
 	}
 
+	var builder *index.DiskIndexBuilder
+	if r.opts.OnDiskIndex {
+		builder, err = index.NewDiskIndexBuilder(r.diskIndexDir())
+		if err != nil {
+			return err
+		}
+		defer builder.Discard()
+	}
+
 	err = index.ForAllIndexes(ctx, indexList, r, func(id restic.ID, idx *index.Index, oldFormat bool, err error) error {
 		if err != nil {
 			return err
 		}
-		r.idx.Insert(idx)
+		if builder != nil {
+			if err := builder.Add(ctx, idx); err != nil {
+				return err
+			}
+		} else {
+			mi.Insert(idx)
+		}
 		if p != nil {
 			p.Add(1)
 		}
@@ -617,7 +917,17 @@ func (r *Repository) LoadIndex(ctx context.Context, p *progress.Counter) error {
 		return err
 	}
 
-	err = r.idx.MergeFinalIndexes()
+	if builder != nil {
+		d, err := builder.Finish()
+		if err != nil {
+			return err
+		}
+		if err := mi.SetDiskIndex(d); err != nil {
+			debug.Log("unable to close previous on-disk index: %v", err)
+		}
+	}
+
+	err = mi.MergeFinalIndexes()
 	if err != nil {
 		return err
 	}
@@ -625,13 +935,13 @@ func (r *Repository) LoadIndex(ctx context.Context, p *progress.Counter) error {
 	// Trigger GC to reset garbage collection threshold
 	runtime.GC()
 
-	if r.cfg.Version < 2 {
+	if r.Config().Version < 2 {
 		// sanity check
 		ctx, cancel := context.WithCancel(ctx)
 		defer cancel()
 
 		invalidIndex := false
-		r.idx.Each(ctx, func(blob restic.PackedBlob) {
+		mi.Each(ctx, func(blob restic.PackedBlob) {
 			if blob.IsCompressed() {
 				invalidIndex = true
 			}
@@ -645,10 +955,21 @@ func (r *Repository) LoadIndex(ctx context.Context, p *progress.Counter) error {
 	return r.prepareCache()
 }
 
+// diskIndexDir returns the directory for the on-disk index.
+func (r *Repository) diskIndexDir() string {
+	if r.Cache != nil && !r.Cache.InMemory() {
+		return r.Cache.Dir()
+	}
+	return ""
+}
+
 // CreateIndexFromPacks creates a new index by reading all given pack files (with sizes).
 // The index is added to the MasterIndex but not marked as finalized.
 // Returned is the list of pack files which could not be read.
 func (r *Repository) CreateIndexFromPacks(ctx context.Context, packsize map[restic.ID]int64, p *progress.Counter) (invalid restic.IDs, err error) {
+	ctx, span := tracing.Start(ctx, "repository.CreateIndexFromPacks")
+	defer func() { tracing.End(span, err) }()
+
 	var m sync.Mutex
 
 	debug.Log("Loading index from pack files")
@@ -686,7 +1007,7 @@ func (r *Repository) CreateIndexFromPacks(ctx context.Context, packsize map[rest
 				invalid = append(invalid, fi.ID)
 				m.Unlock()
 			}
-			r.idx.StorePack(fi.ID, entries)
+			r.index().StorePack(fi.ID, entries)
 			p.Add(1)
 		}
 
@@ -715,21 +1036,27 @@ func (r *Repository) prepareCache() error {
 		return nil
 	}
 
-	indexIDs := r.idx.IDs()
+	indexIDs := r.index().IDs()
 	debug.Log("prepare cache with %d index files", len(indexIDs))
 
 	// clear old index files
 	err := r.Cache.Clear(restic.IndexFile, indexIDs)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error clearing index files in cache: %v\n", err)
+		r.opts.Warnings.Add("", errors.Wrap(err, "clearing index files in cache"))
 	}
 
-	packs := r.idx.Packs(restic.NewIDSet())
+	packs := r.index().Packs(restic.NewIDSet())
 
 	// clear old packs
 	err = r.Cache.Clear(restic.PackFile, packs)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error clearing pack files in cache: %v\n", err)
+		r.opts.Warnings.Add("", errors.Wrap(err, "clearing pack files in cache"))
+	}
+
+	// clear headers of removed packs
+	err = r.Cache.ClearPackHeaders(packs)
+	if err != nil {
+		r.opts.Warnings.Add("", errors.Wrap(err, "clearing pack headers in cache"))
 	}
 
 	return nil
@@ -767,8 +1094,9 @@ func (r *Repository) Init(ctx context.Context, version uint, password string, ch
 		return fmt.Errorf("repository version %v too low", version)
 	}
 
-	_, err := r.be.Stat(ctx, backend.Handle{Type: restic.ConfigFile})
-	if err != nil && !r.be.IsNotExist(err) {
+	be := r.Backend()
+	_, err := be.Stat(ctx, backend.Handle{Type: restic.ConfigFile})
+	if err != nil && !be.IsNotExist(err) {
 		return err
 	}
 	if err == nil {
@@ -812,7 +1140,7 @@ func (r *Repository) KeyID() restic.ID {
 
 // List runs fn for all files of type t in the repo.
 func (r *Repository) List(ctx context.Context, t restic.FileType, fn func(restic.ID, int64) error) error {
-	return r.be.List(ctx, t, func(fi backend.FileInfo) error {
+	return r.Backend().List(ctx, t, func(fi backend.FileInfo) error {
 		id, err := restic.ParseID(fi.Name)
 		if err != nil {
 			debug.Log("unable to parse %v as an ID", fi.Name)
@@ -824,21 +1152,83 @@ func (r *Repository) List(ctx context.Context, t restic.FileType, fn func(restic
 
 // ListPack returns the list of blobs saved in the pack id and the length of
 // the pack header.
+// If a cache is used, the header is taken from the cache if possible and
+// stored in the cache otherwise.
 func (r *Repository) ListPack(ctx context.Context, id restic.ID, size int64) ([]restic.Blob, uint32, error) {
+	if header, ok := r.Cache.LoadPackHeader(id, size); ok {
+		blobs, hdrSize, err := pack.List(r.Key(), &headerReaderAt{header: header, size: size}, size)
+		if err == nil {
+			return blobs, hdrSize, nil
+		}
+		debug.Log("cached header of pack %v is invalid: %v", id.Str(), err)
+	}
+
 	h := backend.Handle{Type: restic.PackFile, Name: id.String()}
+	rd := &lastReadAt{rd: backend.ReaderAt(ctx, r.Backend(), h)}
+	blobs, hdrSize, err := pack.List(r.Key(), rd, size)
+	if err != nil || r.Cache == nil {
+		return blobs, hdrSize, err
+	}
 
-	return pack.List(r.Key(), backend.ReaderAt(ctx, r.Backend(), h), size)
+	// the last read of pack.List ends at the end of the pack and contains
+	// the header
+	if int64(len(rd.last)) >= int64(hdrSize) && rd.lastOff+int64(len(rd.last)) == size {
+		if err := r.Cache.SavePackHeader(id, size, rd.last[len(rd.last)-int(hdrSize):]); err != nil {
+			debug.Log("unable to cache header of pack %v: %v", id.Str(), err)
+		}
+	}
+	return blobs, hdrSize, nil
+}
+
+// headerReaderAt provides the cached header at the end of a pack of the given
+// size. The bytes in front of the header read as zero, pack.List reads them
+// only to save a roundtrip and discards them.
+type headerReaderAt struct {
+	header []byte
+	size   int64
+}
+
+func (rd *headerReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	start := rd.size - int64(len(rd.header))
+	if off < 0 || off+int64(len(p)) > rd.size {
+		return 0, errors.New("read outside of the cached header")
+	}
+	for i := range p {
+		pos := off + int64(i)
+		if pos < start {
+			p[i] = 0
+		} else {
+			p[i] = rd.header[pos-start]
+		}
+	}
+	return len(p), nil
+}
+
+// lastReadAt records the data of the last read.
+type lastReadAt struct {
+	rd      io.ReaderAt
+	last    []byte
+	lastOff int64
+}
+
+func (rd *lastReadAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := rd.rd.ReadAt(p, off)
+	rd.last, rd.lastOff = p[:n], off
+	return n, err
 }
 
 // Delete calls backend.Delete() if implemented, and returns an error
 // otherwise.
 func (r *Repository) Delete(ctx context.Context) error {
-	return r.be.Delete(ctx)
+	return r.Backend().Delete(ctx)
 }
 
-// Close closes the repository by closing the backend.
+// Close closes the repository by closing the backend and the on-disk index.
 func (r *Repository) Close() error {
-	return r.be.Close()
+	if err := r.index().Close(); err != nil {
+		debug.Log("unable to close on-disk index: %v", err)
+	}
+	return r.Backend().Close()
 }
 
 // SaveBlob saves a blob of type t into the repository.
@@ -852,20 +1242,27 @@ func (r *Repository) SaveBlob(ctx context.Context, t restic.BlobType, buf []byte
 
 	// compute plaintext hash if not already set
 	if id.IsNull() {
+		start := time.Now()
 		// Special case the hash calculation for all zero chunks. This is especially
 		// useful for sparse files containing large all zero regions. For these we can
 		// process chunks as fast as we can read the from disk.
-		if len(buf) == chunker.MinSize && restic.ZeroPrefixLen(buf) == chunker.MinSize {
+		hash := r.HashAlgorithm()
+		if hash == restic.SHA256 && len(buf) == chunker.MinSize && restic.ZeroPrefixLen(buf) == chunker.MinSize {
 			newID = ZeroChunk()
 		} else {
-			newID = restic.Hash(buf)
+			newID = hash.Sum(buf)
 		}
+		restic.StageTimesFromContext(ctx).Since(restic.StageHash, start)
 	} else {
 		newID = id
 	}
 
 	// first try to add to pending blobs; if not successful, this blob is already known
-	known = !r.idx.AddPending(restic.BlobHandle{ID: newID, Type: t})
+	idx := r.index()
+	known = !idx.AddPending(restic.BlobHandle{ID: newID, Type: t})
+	if err := idx.Err(); err != nil {
+		return newID, known, 0, err
+	}
 
 	// only save when needed or explicitly told
 	if !known || storeDuplicate {
@@ -885,6 +1282,12 @@ const maxUnusedRange = 4 * 1024 * 1024
 // case of download errors handleBlobFn might be called multiple times for the same blob. If the
 // callback returns an error, then StreamPack will abort and not retry it.
 func StreamPack(ctx context.Context, beLoad BackendLoadFn, key *crypto.Key, packID restic.ID, blobs []restic.Blob, handleBlobFn func(blob restic.BlobHandle, buf []byte, err error) error) error {
+	return StreamPackWithHash(ctx, beLoad, key, restic.SHA256, packID, blobs, handleBlobFn)
+}
+
+// StreamPackWithHash works like StreamPack, but checks the blob hashes with
+// hash, see Repository.HashAlgorithm.
+func StreamPackWithHash(ctx context.Context, beLoad BackendLoadFn, key *crypto.Key, hash restic.HashAlgorithm, packID restic.ID, blobs []restic.Blob, handleBlobFn func(blob restic.BlobHandle, buf []byte, err error) error) error {
 	if len(blobs) == 0 {
 		// nothing to do
 		return nil
@@ -903,7 +1306,7 @@ func StreamPack(ctx context.Context, beLoad BackendLoadFn, key *crypto.Key, pack
 		}
 		if blobs[i].Offset-lastPos > maxUnusedRange {
 			// load everything up to the skipped file section
-			err := streamPackPart(ctx, beLoad, key, packID, blobs[lowerIdx:i], handleBlobFn)
+			err := streamPackPart(ctx, beLoad, key, hash, packID, blobs[lowerIdx:i], handleBlobFn)
 			if err != nil {
 				return err
 			}
@@ -912,10 +1315,10 @@ func StreamPack(ctx context.Context, beLoad BackendLoadFn, key *crypto.Key, pack
 		lastPos = blobs[i].Offset + blobs[i].Length
 	}
 	// load remainder
-	return streamPackPart(ctx, beLoad, key, packID, blobs[lowerIdx:], handleBlobFn)
+	return streamPackPart(ctx, beLoad, key, hash, packID, blobs[lowerIdx:], handleBlobFn)
 }
 
-func streamPackPart(ctx context.Context, beLoad BackendLoadFn, key *crypto.Key, packID restic.ID, blobs []restic.Blob, handleBlobFn func(blob restic.BlobHandle, buf []byte, err error) error) error {
+func streamPackPart(ctx context.Context, beLoad BackendLoadFn, key *crypto.Key, hash restic.HashAlgorithm, packID restic.ID, blobs []restic.Blob, handleBlobFn func(blob restic.BlobHandle, buf []byte, err error) error) error {
 	h := backend.Handle{Type: restic.PackFile, Name: packID.String(), IsMetadata: false}
 
 	dataStart := blobs[0].Offset
@@ -994,7 +1397,7 @@ func streamPackPart(ctx context.Context, beLoad BackendLoadFn, key *crypto.Key,
 				}
 			}
 			if err == nil {
-				id := restic.Hash(plaintext)
+				id := hash.Sum(plaintext)
 				if !id.Equal(entry.ID) {
 					debug.Log("read blob %v/%v from %v: wrong data returned, hash is %v",
 						h.Type, h.ID, packID.Str(), id)
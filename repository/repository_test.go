@@ -11,6 +11,8 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -675,3 +677,322 @@ func TestInvalidCompression(t *testing.T) {
 	_, err = repository.New(nil, repository.Options{Compression: comp})
 	rtest.Assert(t, err != nil, "missing error")
 }
+
+func TestBlobStore(t *testing.T) {
+	repo := repository.TestRepository(t).(restic.BlobStore)
+	data := rtest.Random(23, 1234)
+	id := restic.Hash(data)
+
+	rtest.Assert(t, !repo.HasBlob(restic.DataBlob, id), "blob %v found before saving", id)
+
+	var wg errgroup.Group
+	repo.StartPackUploader(context.TODO(), &wg)
+
+	_, known, _, err := repo.SaveBlob(context.TODO(), restic.DataBlob, data, id, false)
+	rtest.OK(t, err)
+	rtest.Assert(t, !known, "new blob is known")
+	rtest.Assert(t, repo.HasBlob(restic.DataBlob, id), "pending blob not found")
+
+	_, known, _, err = repo.SaveBlob(context.TODO(), restic.DataBlob, data, id, false)
+	rtest.OK(t, err)
+	rtest.Assert(t, known, "duplicate blob is not known")
+	rtest.OK(t, repo.Flush(context.Background()))
+
+	pbs := repo.LookupBlob(restic.DataBlob, id)
+	rtest.Equals(t, 1, len(pbs))
+	rtest.Equals(t, restic.BlobHandle{Type: restic.DataBlob, ID: id}, pbs[0].BlobHandle)
+	rtest.Assert(t, !repo.HasBlob(restic.TreeBlob, id), "blob found with wrong type")
+
+	buf, err := repo.LoadBlob(context.TODO(), restic.DataBlob, id, nil)
+	rtest.OK(t, err)
+	rtest.Equals(t, data, buf)
+}
+
+// concurrencyBackend records the maximum number of concurrent pack uploads.
+type concurrencyBackend struct {
+	backend.Backend
+	m        sync.Mutex
+	cur, max int
+}
+
+func (be *concurrencyBackend) Save(ctx context.Context, h backend.Handle, rd backend.RewindReader) error {
+	if h.Type == restic.PackFile {
+		be.m.Lock()
+		be.cur++
+		if be.cur > be.max {
+			be.max = be.cur
+		}
+		be.m.Unlock()
+
+		// keep the upload running long enough to overlap with others
+		time.Sleep(20 * time.Millisecond)
+
+		defer func() {
+			be.m.Lock()
+			be.cur--
+			be.m.Unlock()
+		}()
+	}
+	return be.Backend.Save(ctx, h, rd)
+}
+
+func TestRepositoryUploadLimits(t *testing.T) {
+	repository.TestUseLowSecurityKDFParameters(t)
+	be := &concurrencyBackend{Backend: repository.TestBackend(t)}
+	repo, err := repository.New(be, repository.Options{
+		PackSize:         repository.MinPackSize,
+		PackUploaders:    2,
+		PackQueueDepth:   1,
+		MaxInFlightBytes: 3 * 1024 * 1024,
+	})
+	rtest.OK(t, err)
+	rtest.OK(t, repo.Init(context.TODO(), restic.StableRepoVersion, rtest.TestPassword, nil))
+
+	var wg errgroup.Group
+	repo.StartPackUploader(context.TODO(), &wg)
+
+	rnd := rand.New(rand.NewSource(23))
+	var saveWg errgroup.Group
+	ids := make([]restic.ID, 40)
+	for i := range ids {
+		i := i
+		buf := make([]byte, 256*1024+rnd.Intn(768*1024))
+		_, _ = rnd.Read(buf)
+		saveWg.Go(func() error {
+			var err error
+			ids[i], _, _, err = repo.SaveBlob(context.TODO(), restic.DataBlob, buf, restic.ID{}, false)
+			return err
+		})
+	}
+	rtest.OK(t, saveWg.Wait())
+	rtest.OK(t, repo.Flush(context.TODO()))
+	rtest.OK(t, wg.Wait())
+
+	rtest.Equals(t, 2, be.max)
+
+	for _, id := range ids {
+		_, err := repo.LoadBlob(context.TODO(), restic.DataBlob, id, nil)
+		rtest.OK(t, err)
+	}
+}
+
+func TestRepositoryInFlightLimit(t *testing.T) {
+	repository.TestUseLowSecurityKDFParameters(t)
+	be := &blockingBackend{Backend: repository.TestBackend(t), started: make(chan struct{}), release: make(chan struct{})}
+	const limit = 1024 * 1024
+	repo, err := repository.New(be, repository.Options{
+		PackSize:         repository.MinPackSize,
+		PackUploaders:    1,
+		MaxInFlightBytes: limit,
+	})
+	rtest.OK(t, err)
+	rtest.OK(t, repo.Init(context.TODO(), restic.StableRepoVersion, rtest.TestPassword, nil))
+
+	var wg errgroup.Group
+	repo.StartPackUploader(context.TODO(), &wg)
+
+	// the blobs are saved one after another, the last one is larger than
+	// the limit
+	var saved atomic.Int64
+	ids := make(restic.IDs, 12)
+	done := make(chan error, 1)
+	go func() {
+		for i := range ids {
+			size := 256 * 1024
+			if i == len(ids)-1 {
+				size = 2 * limit
+			}
+			var err error
+			ids[i], _, _, err = repo.SaveBlob(context.TODO(), restic.DataBlob, rtest.Random(i, size), restic.ID{}, false)
+			if err != nil {
+				done <- err
+				return
+			}
+			saved.Add(int64(size))
+		}
+		done <- nil
+	}()
+
+	// the first pack is uploaded before it is full and its upload blocks,
+	// the blobs which are not uploaded must stay within the limit
+	<-be.started
+	time.Sleep(100 * time.Millisecond)
+	rtest.Assert(t, saved.Load() <= limit, "%d bytes saved while the first upload is blocked, limit %d", saved.Load(), limit)
+	close(be.release)
+
+	rtest.OK(t, <-done)
+	rtest.OK(t, repo.Flush(context.TODO()))
+	rtest.OK(t, wg.Wait())
+
+	for _, id := range ids {
+		_, err := repo.LoadBlob(context.TODO(), restic.DataBlob, id, nil)
+		rtest.OK(t, err)
+	}
+}
+
+// blockingBackend blocks the upload of the first pack until release is closed.
+type blockingBackend struct {
+	backend.Backend
+	once             sync.Once
+	started, release chan struct{}
+}
+
+func (be *blockingBackend) Save(ctx context.Context, h backend.Handle, rd backend.RewindReader) error {
+	if h.Type == restic.PackFile {
+		be.once.Do(func() {
+			close(be.started)
+			<-be.release
+		})
+	}
+	return be.Backend.Save(ctx, h, rd)
+}
+
+func TestRepositoryShutdown(t *testing.T) {
+	repository.TestUseLowSecurityKDFParameters(t)
+	be := &blockingBackend{Backend: repository.TestBackend(t), started: make(chan struct{}), release: make(chan struct{})}
+	repo, err := repository.New(be, repository.Options{PackSize: repository.MinPackSize})
+	rtest.OK(t, err)
+	rtest.OK(t, repo.Init(context.TODO(), restic.StableRepoVersion, rtest.TestPassword, nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg, wgCtx := errgroup.WithContext(ctx)
+	repo.StartPackUploader(wgCtx, wg)
+
+	// the first four blobs fill a pack which is uploaded, the last one
+	// remains in a pending pack
+	rnd := rand.New(rand.NewSource(23))
+	var ids restic.IDs
+	for len(ids) < 5 {
+		buf := make([]byte, 1024*1024)
+		_, _ = rnd.Read(buf)
+		id, _, _, err := repo.SaveBlob(wgCtx, restic.DataBlob, buf, restic.ID{}, false)
+		rtest.OK(t, err)
+		ids = append(ids, id)
+	}
+	<-be.started
+
+	// the running upload completes after the context was cancelled
+	cancel()
+	close(be.release)
+	rtest.Assert(t, wg.Wait() != nil, "cancelled uploader returned no error")
+	rtest.OK(t, repo.Shutdown(ctx))
+
+	var packs, indexes int
+	rtest.OK(t, repo.List(context.TODO(), restic.PackFile, func(restic.ID, int64) error {
+		packs++
+		return nil
+	}))
+	rtest.OK(t, repo.List(context.TODO(), restic.IndexFile, func(restic.ID, int64) error {
+		indexes++
+		return nil
+	}))
+	rtest.Equals(t, 1, packs)
+	rtest.Equals(t, 1, indexes)
+
+	rtest.OK(t, repo.LoadIndex(context.TODO(), nil))
+	last := ids[len(ids)-1]
+	for _, id := range ids[:4] {
+		rtest.Assert(t, repo.HasBlob(restic.DataBlob, id), "uploaded blob %v is not indexed", id.Str())
+	}
+	rtest.Assert(t, !repo.HasBlob(restic.DataBlob, last), "discarded blob is indexed")
+
+	// the uploader can be started again and the discarded blob is saved again
+	wg, wgCtx = errgroup.WithContext(context.Background())
+	repo.StartPackUploader(wgCtx, wg)
+	buf := make([]byte, 1024*1024)
+	rnd = rand.New(rand.NewSource(23))
+	for range ids {
+		_, _ = rnd.Read(buf)
+	}
+	_, known, _, err := repo.SaveBlob(wgCtx, restic.DataBlob, buf, last, false)
+	rtest.OK(t, err)
+	rtest.Assert(t, !known, "discarded blob is still known")
+	rtest.OK(t, repo.Flush(wgCtx))
+	rtest.OK(t, wg.Wait())
+}
+
+func TestRepositoryConcurrentUse(t *testing.T) {
+	repository.TestUseLowSecurityKDFParameters(t)
+	repo, err := repository.New(repository.TestBackend(t), repository.Options{})
+	rtest.OK(t, err)
+	ctx := context.TODO()
+	rtest.OK(t, repo.Init(ctx, restic.StableRepoVersion, rtest.TestPassword, nil))
+
+	var wg errgroup.Group
+	repo.StartPackUploader(ctx, &wg)
+
+	// save and look up blobs while the config is reloaded
+	var users errgroup.Group
+	for i := 0; i < 8; i++ {
+		i := i
+		users.Go(func() error {
+			for j := 0; j < 20; j++ {
+				buf := []byte(fmt.Sprintf("blob %d-%d", i, j))
+				id, _, _, err := repo.SaveBlob(ctx, restic.DataBlob, buf, restic.ID{}, false)
+				if err != nil {
+					return err
+				}
+				repo.LookupBlobSize(id, restic.DataBlob)
+				_ = repo.Config()
+			}
+			return nil
+		})
+	}
+	users.Go(func() error {
+		for j := 0; j < 5; j++ {
+			if err := repo.ReloadConfig(ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	rtest.OK(t, users.Wait())
+	rtest.OK(t, repo.Flush(ctx))
+	rtest.OK(t, wg.Wait())
+
+	rtest.OK(t, repo.LoadIndex(ctx, nil))
+	for i := 0; i < 8; i++ {
+		for j := 0; j < 20; j++ {
+			id := restic.Hash([]byte(fmt.Sprintf("blob %d-%d", i, j)))
+			rtest.Assert(t, repo.HasBlob(restic.DataBlob, id), "blob %d-%d is not indexed", i, j)
+		}
+	}
+}
+
+func TestRepositoryLoadIndexWhileSaving(t *testing.T) {
+	repository.TestUseLowSecurityKDFParameters(t)
+	repo, err := repository.New(repository.TestBackend(t), repository.Options{PackSize: repository.MinPackSize})
+	rtest.OK(t, err)
+	ctx := context.TODO()
+	rtest.OK(t, repo.Init(ctx, restic.StableRepoVersion, rtest.TestPassword, nil))
+
+	var wg errgroup.Group
+	repo.StartPackUploader(ctx, &wg)
+
+	// the first pack is uploaded, the last blobs remain in a pending pack
+	rnd := rand.New(rand.NewSource(42))
+	var blobs [][]byte
+	for len(blobs) < 6 {
+		buf := make([]byte, 1024*1024)
+		_, _ = rnd.Read(buf)
+		_, known, _, err := repo.SaveBlob(ctx, restic.DataBlob, buf, restic.ID{}, false)
+		rtest.OK(t, err)
+		rtest.Assert(t, !known, "new blob is known")
+		blobs = append(blobs, buf)
+	}
+
+	// reloading the index keeps the blobs which are being saved
+	rtest.OK(t, repo.LoadIndex(ctx, nil))
+	for i, buf := range blobs {
+		_, known, _, err := repo.SaveBlob(ctx, restic.DataBlob, buf, restic.ID{}, false)
+		rtest.OK(t, err)
+		rtest.Assert(t, known, "blob %d is not known after reloading the index", i)
+	}
+	rtest.OK(t, repo.Flush(ctx))
+	rtest.OK(t, wg.Wait())
+
+	rtest.OK(t, repo.LoadIndex(ctx, nil))
+	for i, buf := range blobs {
+		rtest.Assert(t, repo.HasBlob(restic.DataBlob, restic.Hash(buf)), "blob %d is not indexed", i)
+	}
+}
@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/fs"
+)
+
+// DefaultShutdownTimeout is used if Options.ShutdownTimeout is zero.
+const DefaultShutdownTimeout = 30 * time.Second
+
+// detachedContext carries the values of a context, but is never cancelled.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+func (c detachedContext) Value(key any) any {
+	return c.parent.Value(key)
+}
+
+// graceContext returns a context which is cancelled grace after ctx, or when
+// the returned function is called. It allows work which is already running
+// to complete after ctx was cancelled.
+func graceContext(ctx context.Context, grace time.Duration) (context.Context, context.CancelFunc) {
+	gctx, cancel := context.WithCancel(detachedContext{ctx})
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-gctx.Done():
+			return
+		}
+
+		t := time.NewTimer(grace)
+		defer t.Stop()
+		select {
+		case <-t.C:
+			cancel()
+		case <-gctx.Done():
+		}
+	}()
+	return gctx, cancel
+}
+
+// Shutdown stops the pack uploader after an operation failed or its context
+// was cancelled, so that the repository is left in a consistent state. Pack
+// uploads which are already running are completed, packs which are still
+// being filled or wait for an uploader are discarded. Then the index of all
+// uploaded packs is saved, otherwise they would remain in the repository
+// without being referenced by an index.
+//
+// In contrast to Flush, the uploader need not be running and ctx may already
+// be cancelled, the work is bounded by Options.ShutdownTimeout instead.
+// Shutdown must not be called concurrently with SaveBlob.
+func (r *Repository) Shutdown(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(detachedContext{ctx}, r.opts.ShutdownTimeout)
+	defer cancel()
+
+	r.mu.RLock()
+	wg, uploader, treePM, dataPM := r.packerWg, r.uploader, r.treePM, r.dataPM
+	r.mu.RUnlock()
+
+	if wg != nil {
+		debug.Log("shutting down pack uploader")
+		uploader.TriggerShutdown()
+		if err := wg.Wait(); err != nil {
+			debug.Log("pack uploader stopped: %v", err)
+		}
+		for t := range uploader.uploadQueue {
+			t.packer.discard()
+		}
+		treePM.discard()
+		dataPM.discard()
+
+		r.stopPackUploader(wg)
+	}
+
+	// the blobs of discarded packs must be saved again by later operations
+	r.index().DiscardPending()
+
+	if r.noAutoIndexUpdate {
+		return nil
+	}
+	return r.index().SaveIndex(ctx, r)
+}
+
+// discard drops the pending pack.
+func (r *packerManager) discard() {
+	r.pm.Lock()
+	defer r.pm.Unlock()
+
+	if r.packer != nil {
+		r.packer.discard()
+		r.packer = nil
+	}
+}
+
+// discard removes the temporary file of a pack which is not uploaded.
+func (p *Packer) discard() {
+	debug.Log("discarding pack with %d blobs", p.Packer.Count())
+	p.releaseInFlight()
+	_ = p.tmpfile.Close()
+	if err := fs.RemoveIfExists(p.tmpfile.Name()); err != nil {
+		debug.Log("unable to remove temporary pack file: %v", err)
+	}
+}
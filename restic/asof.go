@@ -0,0 +1,277 @@
+package restic
+
+import (
+	"bytes"
+	"context"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/errors"
+)
+
+// AsOfView is a repository with an additional, synthetic snapshot which
+// shows each backed up path in the state of the latest snapshot taken at or
+// before a point in time. The trees of the synthetic snapshot are only kept
+// in memory, nothing is written to the repository. The view can be passed to
+// everything which loads trees from a repository, for example to list,
+// restore or mount the synthetic snapshot.
+type AsOfView struct {
+	Repository
+
+	sn      *Snapshot
+	sources map[string]ID
+	trees   map[ID][]byte
+}
+
+// asOfDir is a directory of the synthetic snapshot which contains the path
+// of a snapshot.
+type asOfDir struct {
+	node    *Node
+	nodes   map[string]*Node
+	subdirs map[string]*asOfDir
+}
+
+// NewAsOfView returns a view of repo as it was at the time at. Only
+// snapshots matching f are considered. For each path of these snapshots, the
+// latest snapshot which contains the path and was taken at or before at is
+// used. If a path is contained in another path, for example /home/user and
+// /home, the one from the later snapshot wins. The index of repo must already
+// be loaded.
+func NewAsOfView(ctx context.Context, repo Repository, at time.Time, f SnapshotFilter) (*AsOfView, error) {
+	var snapshots Snapshots
+	err := f.FindAll(ctx, repo, repo, nil, func(_ string, sn *Snapshot, err error) error {
+		if err != nil {
+			return err
+		}
+		if !sn.Time.After(at) && sn.Tree != nil {
+			snapshots = append(snapshots, sn)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(snapshots) == 0 {
+		return nil, errors.Wrapf(ErrNoSnapshotFound, "as of %v", at.Format(time.RFC3339))
+	}
+
+	// use the same order as FindLatest for snapshots with the same time
+	sort.Slice(snapshots, func(i, j int) bool {
+		if !snapshots[i].Time.Equal(snapshots[j].Time) {
+			return snapshots[i].Time.Before(snapshots[j].Time)
+		}
+		return bytes.Compare(snapshots[i].ID()[:], snapshots[j].ID()[:]) > 0
+	})
+
+	latest := make(map[string]*Snapshot)
+	for _, sn := range snapshots {
+		for _, p := range sn.Paths {
+			latest[asOfPath(p)] = sn
+		}
+	}
+
+	v := &AsOfView{
+		Repository: repo,
+		sources:    make(map[string]ID),
+		trees:      make(map[ID][]byte),
+	}
+	root := &asOfDir{node: &Node{Type: "dir"}, nodes: make(map[string]*Node), subdirs: make(map[string]*asOfDir)}
+	for _, sn := range snapshots {
+		var paths []string
+		for _, p := range sn.Paths {
+			if latest[asOfPath(p)] == sn {
+				paths = append(paths, asOfPath(p))
+			}
+		}
+
+		sort.Strings(paths)
+		for _, p := range paths {
+			if p == "/" {
+				root, err = v.expand(ctx, &Node{Type: "dir", Subtree: sn.Tree})
+			} else {
+				err = v.graft(ctx, root, sn, p)
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			// paths below p from earlier snapshots were replaced
+			for other := range v.sources {
+				if p == "/" || strings.HasPrefix(other, p+"/") {
+					delete(v.sources, other)
+				}
+			}
+			v.sources[p] = *sn.ID()
+		}
+	}
+
+	// the view is as recent as the latest snapshot it consists of
+	v.sn = &Snapshot{}
+	hosts := make(map[string]struct{})
+	for p, id := range v.sources {
+		v.sn.Paths = append(v.sn.Paths, p)
+		for _, sn := range snapshots {
+			if sn.ID().Equal(id) {
+				if sn.Time.After(v.sn.Time) {
+					v.sn.Time = sn.Time
+				}
+				hosts[sn.Hostname] = struct{}{}
+			}
+		}
+	}
+	sort.Strings(v.sn.Paths)
+	if len(hosts) == 1 {
+		for host := range hosts {
+			v.sn.Hostname = host
+		}
+	}
+
+	id, err := v.save(root)
+	if err != nil {
+		return nil, err
+	}
+	v.sn.Tree = &id
+	debug.Log("view as of %v consists of %d paths, tree %v", at, len(v.sources), id.Str())
+	return v, nil
+}
+
+// asOfPath converts the path of a snapshot to the path of the corresponding
+// node in the tree of the snapshot.
+func asOfPath(p string) string {
+	p = filepath.ToSlash(p)
+	if vol := filepath.VolumeName(p); vol != "" {
+		p = strings.TrimSuffix(vol, ":") + p[len(vol):]
+	}
+	return path.Clean("/" + p)
+}
+
+// graft replaces the node at p in root with the node at p in the snapshot
+// sn. Missing directories are added with the metadata from sn.
+func (v *AsOfView) graft(ctx context.Context, root *asOfDir, sn *Snapshot, p string) error {
+	components := strings.Split(p[1:], "/")
+	dir := root
+	treeID := *sn.Tree
+	for i, name := range components {
+		tree, err := LoadTree(ctx, v, treeID)
+		if err != nil {
+			return err
+		}
+		node := tree.Find(name)
+		if node == nil {
+			return errors.Errorf("path %v of snapshot %v not found in its tree", p, sn.ID().Str())
+		}
+
+		if i == len(components)-1 {
+			dir.nodes[name] = node
+			delete(dir.subdirs, name)
+			return nil
+		}
+
+		if node.Type != "dir" || node.Subtree == nil {
+			return errors.Errorf("path %v of snapshot %v: %v is not a directory", p, sn.ID().Str(), name)
+		}
+		treeID = *node.Subtree
+
+		sub, ok := dir.subdirs[name]
+		if !ok {
+			// continue in the directory from an earlier snapshot if there is one
+			existing := dir.nodes[name]
+			if existing == nil || existing.Type != "dir" || existing.Subtree == nil {
+				existing = node
+			}
+			sub, err = v.expand(ctx, existing)
+			if err != nil {
+				return err
+			}
+			dir.subdirs[name] = sub
+			dir.nodes[name] = sub.node
+		}
+		dir = sub
+	}
+	return nil
+}
+
+// expand loads the tree of the directory node.
+func (v *AsOfView) expand(ctx context.Context, node *Node) (*asOfDir, error) {
+	tree, err := LoadTree(ctx, v, *node.Subtree)
+	if err != nil {
+		return nil, err
+	}
+	dir := &asOfDir{node: node, nodes: make(map[string]*Node, len(tree.Nodes)), subdirs: make(map[string]*asOfDir)}
+	for _, n := range tree.Nodes {
+		dir.nodes[n.Name] = n
+	}
+	return dir, nil
+}
+
+// save serializes dir and its subdirectories and returns the ID of its tree.
+func (v *AsOfView) save(dir *asOfDir) (ID, error) {
+	names := make([]string, 0, len(dir.nodes))
+	for name := range dir.nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tree := NewTreeJSONBuilder()
+	for _, name := range names {
+		node := dir.nodes[name]
+		if sub, ok := dir.subdirs[name]; ok {
+			id, err := v.save(sub)
+			if err != nil {
+				return ID{}, err
+			}
+			copied := *node
+			copied.Subtree = &id
+			node = &copied
+		}
+		if err := tree.AddNode(node); err != nil {
+			return ID{}, err
+		}
+	}
+
+	buf, err := tree.Finalize()
+	if err != nil {
+		return ID{}, err
+	}
+	id := Hash(buf)
+	v.trees[id] = buf
+	return id, nil
+}
+
+// Snapshot returns the synthetic snapshot. It has no ID and is not stored in
+// the repository.
+func (v *AsOfView) Snapshot() *Snapshot {
+	return v.sn
+}
+
+// Sources returns the ID of the snapshot each path of the synthetic snapshot
+// was taken from.
+func (v *AsOfView) Sources() map[string]ID {
+	return v.sources
+}
+
+// LoadBlob returns the trees of the synthetic snapshot from memory, all other
+// blobs are loaded from the repository.
+func (v *AsOfView) LoadBlob(ctx context.Context, t BlobType, id ID, buf []byte) ([]byte, error) {
+	if t == TreeBlob {
+		if data, ok := v.trees[id]; ok {
+			return append(buf[:0], data...), nil
+		}
+	}
+	return v.Repository.LoadBlob(ctx, t, id, buf)
+}
+
+// LookupBlobSize returns the size of the trees of the synthetic snapshot and
+// looks up all other blobs in the index.
+func (v *AsOfView) LookupBlobSize(id ID, t BlobType) (uint, bool) {
+	if t == TreeBlob {
+		if data, ok := v.trees[id]; ok {
+			return uint(len(data)), true
+		}
+	}
+	return v.Repository.LookupBlobSize(id, t)
+}
@@ -0,0 +1,57 @@
+package restic
+
+import (
+	"context"
+	"time"
+)
+
+// Clock is the source of the current time for snapshots, locks and retention
+// policies. Tests and applications with their own notion of time can pass
+// a Clock to the operations with WithClock.
+type Clock interface {
+	Now() time.Time
+}
+
+// ClockFunc is a function returning the current time, it implements Clock.
+type ClockFunc func() time.Time
+
+// Now returns f().
+func (f ClockFunc) Now() time.Time {
+	return f()
+}
+
+// SystemClock returns the time of the local system.
+var SystemClock Clock = ClockFunc(time.Now)
+
+// FixedClock returns a Clock which always returns t.
+func FixedClock(t time.Time) Clock {
+	return ClockFunc(func() time.Time { return t })
+}
+
+// ClockSkewTolerance is the difference between the clocks of the hosts
+// accessing a repository which is tolerated. Locks are only considered stale
+// once they are older than StaleLockTimeout plus the tolerance, and
+// snapshots which are less than the tolerance in the future are not ignored
+// by retention policies.
+var ClockSkewTolerance = 5 * time.Minute
+
+type clockKey struct{}
+
+// WithClock returns a context which makes the operations called with it use
+// c instead of the system clock.
+func WithClock(ctx context.Context, c Clock) context.Context {
+	return context.WithValue(ctx, clockKey{}, c)
+}
+
+// ClockFromContext returns the Clock of ctx, or SystemClock if there is none.
+func ClockFromContext(ctx context.Context) Clock {
+	if c, ok := ctx.Value(clockKey{}).(Clock); ok && c != nil {
+		return c
+	}
+	return SystemClock
+}
+
+// Now returns the current time according to the Clock of ctx.
+func Now(ctx context.Context) time.Time {
+	return ClockFromContext(ctx).Now()
+}
@@ -0,0 +1,60 @@
+package restic_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/restic"
+)
+
+func TestClockFromContext(t *testing.T) {
+	ctx := context.Background()
+	before := time.Now()
+	now := restic.Now(ctx)
+	rtest.Assert(t, !now.Before(before), "system clock returned %v, before %v", now, before)
+
+	fixed := time.Date(2020, 2, 29, 12, 0, 0, 0, time.UTC)
+	ctx = restic.WithClock(ctx, restic.FixedClock(fixed))
+	rtest.Equals(t, fixed, restic.Now(ctx))
+}
+
+func TestLockStaleAtClockSkew(t *testing.T) {
+	now := time.Date(2020, 2, 29, 12, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		age   time.Duration
+		stale bool
+	}{
+		{time.Minute, false},
+		{-time.Hour, false},
+		// within the tolerance for hosts whose clock is behind
+		{restic.StaleLockTimeout + restic.ClockSkewTolerance/2, false},
+		{restic.StaleLockTimeout + 2*restic.ClockSkewTolerance, true},
+	} {
+		lock := &restic.Lock{Time: now.Add(-test.age), Hostname: "other-host"}
+		rtest.Equals(t, test.stale, lock.StaleAt(now))
+	}
+}
+
+func TestApplyPolicyAtClockSkew(t *testing.T) {
+	now := time.Date(2020, 2, 29, 12, 0, 0, 0, time.UTC)
+	var list restic.Snapshots
+	for _, offset := range []time.Duration{
+		// taken by a host whose clock is slightly ahead
+		restic.ClockSkewTolerance / 2,
+		-time.Hour,
+		-130 * time.Minute,
+	} {
+		sn, err := restic.NewSnapshot([]string{"/data"}, nil, "host", now.Add(offset))
+		rtest.OK(t, err)
+		list = append(list, sn)
+	}
+
+	keep, remove, _ := restic.ApplyPolicyAt(list, restic.ExpirePolicy{
+		Within: restic.Duration{Hours: 2},
+	}, now)
+	rtest.Equals(t, 2, len(keep))
+	rtest.Equals(t, 1, len(remove))
+	rtest.Equals(t, now.Add(-130*time.Minute), remove[0].Time)
+}
@@ -2,6 +2,7 @@ package restic
 
 import (
 	"context"
+	"encoding/json"
 	"testing"
 
 	"github.com/konidev20/rapi/internal/errors"
@@ -16,6 +17,10 @@ type Config struct {
 	Version           uint        `json:"version"`
 	ID                string      `json:"id"`
 	ChunkerPolynomial chunker.Pol `json:"chunker_polynomial"`
+
+	// Features lists the optional features which are required to access
+	// the repository, see Feature.
+	Features []Feature `json:"features,omitempty"`
 }
 
 const MinRepoVersion = 1
@@ -77,17 +82,33 @@ func TestDisableCheckPolynomial(t testing.TB) {
 
 // LoadConfig returns loads, checks and returns the config for a repository.
 func LoadConfig(ctx context.Context, r LoaderUnpacked) (Config, error) {
-	var (
-		cfg Config
-	)
-
-	err := LoadJSONUnpacked(ctx, r, ConfigFile, ID{}, &cfg)
+	buf, err := r.LoadUnpacked(ctx, ConfigFile, ID{})
 	if err != nil {
 		return Config{}, err
 	}
 
+	return DecodeConfig(buf)
+}
+
+// DecodeConfig parses and checks the decrypted config of a repository. buf
+// may contain arbitrary data, an error is returned if it is not a valid
+// config.
+func DecodeConfig(buf []byte) (Config, error) {
+	var cfg Config
+	if err := json.Unmarshal(buf, &cfg); err != nil {
+		return Config{}, err
+	}
+
 	if cfg.Version < MinRepoVersion || cfg.Version > MaxRepoVersion {
-		return Config{}, errors.Errorf("unsupported repository version %v", cfg.Version)
+		return Config{}, &UnsupportedVersionError{Version: cfg.Version}
+	}
+
+	if err := cfg.checkFeatures(); err != nil {
+		return Config{}, err
+	}
+
+	if _, err := cfg.HashAlgorithm(); err != nil {
+		return Config{}, err
 	}
 
 	if checkPolynomial {
@@ -0,0 +1,94 @@
+package restic
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Feature is an optional repository feature. Features which are listed in
+// the config must be supported by the library, otherwise the repository
+// cannot be opened.
+type Feature string
+
+// Known repository features.
+const (
+	// FeatureCompression allows compressed blobs. It is implied by
+	// repository version 2 and never stored in the config.
+	FeatureCompression Feature = "compression"
+	// FeatureSignatures requires signed snapshots. Not supported yet.
+	FeatureSignatures Feature = "signatures"
+	// FeatureChunkerV2 uses a different content-defined chunker. Not
+	// supported yet.
+	FeatureChunkerV2 Feature = "chunker-v2"
+)
+
+// supportedFeatures contains the features which this build supports.
+var supportedFeatures = map[Feature]bool{
+	FeatureCompression: true,
+}
+
+// SupportedFeatures returns the features supported by this build, sorted by
+// name.
+func SupportedFeatures() []Feature {
+	list := make([]Feature, 0, len(supportedFeatures))
+	for f := range supportedFeatures {
+		list = append(list, f)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+	return list
+}
+
+// UnsupportedFeatureError is returned when a repository requires features
+// which this build does not support.
+type UnsupportedFeatureError struct {
+	Features []Feature
+}
+
+func (e *UnsupportedFeatureError) Error() string {
+	names := make([]string, 0, len(e.Features))
+	for _, f := range e.Features {
+		names = append(names, string(f))
+	}
+	return fmt.Sprintf("repository requires unsupported features: %v", strings.Join(names, ", "))
+}
+
+// UnsupportedVersionError is returned when the repository format version is
+// not supported.
+type UnsupportedVersionError struct {
+	Version uint
+}
+
+func (e *UnsupportedVersionError) Error() string {
+	return fmt.Sprintf("unsupported repository version %v", e.Version)
+}
+
+// HasFeature returns true if the repository uses the feature f.
+func (cfg Config) HasFeature(f Feature) bool {
+	if f == FeatureCompression {
+		return cfg.Version >= 2
+	}
+
+	for _, feature := range cfg.Features {
+		if feature == f {
+			return true
+		}
+	}
+	return false
+}
+
+// checkFeatures returns an UnsupportedFeatureError if the config lists
+// features which this build does not support.
+func (cfg Config) checkFeatures() error {
+	var missing []Feature
+	for _, f := range cfg.Features {
+		if !supportedFeatures[f] {
+			missing = append(missing, f)
+		}
+	}
+
+	if len(missing) > 0 {
+		return &UnsupportedFeatureError{Features: missing}
+	}
+	return nil
+}
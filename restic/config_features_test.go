@@ -0,0 +1,31 @@
+package restic
+
+import (
+	"errors"
+	"testing"
+
+	rtest "github.com/konidev20/rapi/internal/test"
+)
+
+func TestConfigFeatures(t *testing.T) {
+	cfg := Config{Version: 1}
+	rtest.Assert(t, !cfg.HasFeature(FeatureCompression), "version 1 repository supports compression")
+
+	cfg.Version = 2
+	rtest.Assert(t, cfg.HasFeature(FeatureCompression), "version 2 repository does not support compression")
+	rtest.Assert(t, !cfg.HasFeature(FeatureSignatures), "signatures enabled")
+
+	cfg.Features = []Feature{FeatureSignatures}
+	rtest.Assert(t, cfg.HasFeature(FeatureSignatures), "listed feature not enabled")
+}
+
+func TestConfigCheckFeatures(t *testing.T) {
+	cfg := Config{Version: 2}
+	rtest.OK(t, cfg.checkFeatures())
+
+	cfg.Features = []Feature{FeatureChunkerV2, "unknown"}
+	err := cfg.checkFeatures()
+	var ufe *UnsupportedFeatureError
+	rtest.Assert(t, errors.As(err, &ufe), "wrong error type %T", err)
+	rtest.Equals(t, 2, len(ufe.Features))
+}
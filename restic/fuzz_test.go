@@ -0,0 +1,47 @@
+package restic_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/konidev20/rapi/restic"
+)
+
+func FuzzDecodeTree(f *testing.F) {
+	f.Add([]byte(`{"nodes":[{"name":"foo","type":"file","mode":420,"size":3,"content":["3ec79977ef0cf5de7b08cd12b874cd0f62bbaf7f07f3497a5b1bbcc8cb39b1ce"]}]}`))
+	f.Add([]byte(`{"nodes":[{"name":"dir","type":"dir","subtree":"3ec79977ef0cf5de7b08cd12b874cd0f62bbaf7f07f3497a5b1bbcc8cb39b1ce"}]}`))
+	f.Add([]byte(`{"nodes":[null]}`))
+
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		tree, err := restic.DecodeTree(buf)
+		if err != nil {
+			return
+		}
+
+		for _, node := range tree.Nodes {
+			_ = node.String()
+		}
+		if _, err := json.Marshal(tree); err != nil {
+			t.Fatalf("decoded tree cannot be encoded: %v", err)
+		}
+	})
+}
+
+func FuzzDecodeConfig(f *testing.F) {
+	f.Add([]byte(`{"version":2,"id":"5a2a3a8d87e9c4b0e3e5fbc3f1e7b8a3c8e8d5b2f0c3e9a7d1b4f6e8c2a5d7b9","chunker_polynomial":"25b468838dcb75"}`))
+	f.Add([]byte(`{"version":1,"id":"x","chunker_polynomial":"0","features":["compression","unknown"]}`))
+
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		cfg, err := restic.DecodeConfig(buf)
+		if err != nil {
+			return
+		}
+
+		if cfg.Version < restic.MinRepoVersion || cfg.Version > restic.MaxRepoVersion {
+			t.Fatalf("config with invalid version %d accepted", cfg.Version)
+		}
+		if _, err := cfg.HashAlgorithm(); err != nil {
+			t.Fatalf("config without hash algorithm accepted: %v", err)
+		}
+	})
+}
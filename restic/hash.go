@@ -0,0 +1,64 @@
+package restic
+
+import (
+	"hash"
+
+	"github.com/minio/sha256-simd"
+)
+
+// HashAlgorithm computes the IDs of the files and blobs stored in a
+// repository. The algorithm is selected by the repository version.
+type HashAlgorithm interface {
+	// Name identifies the algorithm, for example "sha256".
+	Name() string
+	// New returns a hash which produces IDs, its Size() must be equal to the
+	// size of an ID.
+	New() hash.Hash
+	// Sum returns the ID for data.
+	Sum(data []byte) ID
+}
+
+// HashSHA256 is the name of SHA256.
+const HashSHA256 = "sha256"
+
+// SHA256 is the hash algorithm used by repository versions 1 and 2. It uses
+// the SHA extensions or AVX512 of the CPU if they are available.
+var SHA256 HashAlgorithm = sha256Algorithm{}
+
+type sha256Algorithm struct{}
+
+func (sha256Algorithm) Name() string       { return HashSHA256 }
+func (sha256Algorithm) New() hash.Hash     { return sha256.New() }
+func (sha256Algorithm) Sum(data []byte) ID { return sha256.Sum256(data) }
+
+// versionHashes maps the repository versions to the hash algorithm they use.
+// It must contain all versions between MinRepoVersion and MaxRepoVersion, a
+// repository version with a different algorithm adds its entry here.
+var versionHashes = map[uint]HashAlgorithm{
+	1: SHA256,
+	2: SHA256,
+}
+
+// HashAlgorithmForVersion returns the hash algorithm used by the repository
+// version.
+func HashAlgorithmForVersion(version uint) (HashAlgorithm, error) {
+	alg, ok := versionHashes[version]
+	if !ok {
+		return nil, &UnsupportedVersionError{Version: version}
+	}
+	return alg, nil
+}
+
+// HashAlgorithm returns the hash algorithm used by the repository.
+func (cfg Config) HashAlgorithm() (HashAlgorithm, error) {
+	return HashAlgorithmForVersion(cfg.Version)
+}
+
+// RepositoryHashAlgorithm returns the hash algorithm of repo. Repositories
+// which do not implement a HashAlgorithm method use SHA256.
+func RepositoryHashAlgorithm(repo Repository) HashAlgorithm {
+	if r, ok := repo.(interface{ HashAlgorithm() HashAlgorithm }); ok {
+		return r.HashAlgorithm()
+	}
+	return SHA256
+}
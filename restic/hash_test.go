@@ -0,0 +1,48 @@
+package restic
+
+import (
+	"crypto/sha256"
+	"errors"
+	"testing"
+
+	rtest "github.com/konidev20/rapi/internal/test"
+)
+
+func TestHashAlgorithmForVersion(t *testing.T) {
+	for version := uint(MinRepoVersion); version <= MaxRepoVersion; version++ {
+		alg, err := HashAlgorithmForVersion(version)
+		rtest.OK(t, err)
+		rtest.Equals(t, HashSHA256, alg.Name())
+	}
+
+	data := []byte("foobar")
+	rtest.Equals(t, ID(sha256.Sum256(data)), SHA256.Sum(data))
+	rtest.Equals(t, ID(sha256.Sum256(data)), Hash(data))
+
+	for _, version := range []uint{MaxRepoVersion + 1, 42} {
+		_, err := HashAlgorithmForVersion(version)
+		var uve *UnsupportedVersionError
+		rtest.Assert(t, errors.As(err, &uve), "wrong error type %T for version %v", err, version)
+	}
+}
+
+type testHash struct {
+	sha256Algorithm
+	name string
+}
+
+func (h testHash) Name() string { return h.name }
+
+type hashRepository struct {
+	Repository
+	alg HashAlgorithm
+}
+
+func (r hashRepository) HashAlgorithm() HashAlgorithm { return r.alg }
+
+func TestRepositoryHashAlgorithm(t *testing.T) {
+	rtest.Equals(t, SHA256, RepositoryHashAlgorithm(struct{ Repository }{}))
+
+	alg := testHash{name: "test"}
+	rtest.Equals(t, "test", RepositoryHashAlgorithm(hashRepository{alg: alg}).Name())
+}
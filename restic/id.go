@@ -9,9 +9,10 @@ import (
 	"github.com/minio/sha256-simd"
 )
 
-// Hash returns the ID for data.
+// Hash returns the SHA-256 based ID for data. Repositories select their hash
+// algorithm by version, see HashAlgorithmForVersion.
 func Hash(data []byte) ID {
-	return sha256.Sum256(data)
+	return SHA256.Sum(data)
 }
 
 // idSize contains the size of an ID, in bytes.
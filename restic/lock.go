@@ -4,11 +4,9 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"os/signal"
 	"os/user"
 	"sync"
 	"sync/atomic"
-	"syscall"
 	"testing"
 	"time"
 
@@ -108,7 +106,7 @@ func TestSetLockTimeout(t testing.TB, d time.Duration) {
 
 func newLock(ctx context.Context, repo Repository, excl bool) (*Lock, error) {
 	lock := &Lock{
-		Time:      time.Now(),
+		Time:      Now(ctx),
 		PID:       os.Getpid(),
 		Exclusive: excl,
 		repo:      repo,
@@ -208,13 +206,19 @@ func (l *Lock) createLock(ctx context.Context) (ID, error) {
 	return id, nil
 }
 
+// UnlockTimeout bounds the time spent removing a lock, so that an operation
+// which was cancelled releases its lock in time even if the backend is slow.
+var UnlockTimeout = 30 * time.Second
+
 // Unlock removes the lock from the repository.
 func (l *Lock) Unlock() error {
 	if l == nil || l.lockID == nil {
 		return nil
 	}
 
-	return l.repo.Backend().Remove(context.TODO(), backend.Handle{Type: LockFile, Name: l.lockID.String()})
+	ctx, cancel := context.WithTimeout(context.Background(), UnlockTimeout)
+	defer cancel()
+	return l.repo.Backend().Remove(ctx, backend.Handle{Type: LockFile, Name: l.lockID.String()})
 }
 
 var StaleLockTimeout = 30 * time.Minute
@@ -223,10 +227,17 @@ var StaleLockTimeout = 30 * time.Minute
 // older than 30 minutes or if it was created on the current machine and the
 // process isn't alive any more.
 func (l *Lock) Stale() bool {
+	return l.StaleAt(time.Now())
+}
+
+// StaleAt is like Stale, but compares the timestamp with now. The lock may
+// have been created by a host whose clock is behind, so it is only stale
+// once it is older than StaleLockTimeout plus ClockSkewTolerance.
+func (l *Lock) StaleAt(now time.Time) bool {
 	l.lock.Lock()
 	defer l.lock.Unlock()
 	debug.Log("testing if lock %v for process %d is stale", l.lockID, l.PID)
-	if time.Since(l.Time) > StaleLockTimeout {
+	if now.Sub(l.Time) > StaleLockTimeout+ClockSkewTolerance {
 		debug.Log("lock is stale, timestamp is too old: %v\n", l.Time)
 		return true
 	}
@@ -260,7 +271,7 @@ func (l *Lock) Stale() bool {
 func (l *Lock) Refresh(ctx context.Context) error {
 	debug.Log("refreshing lock %v", l.lockID)
 	l.lock.Lock()
-	l.Time = time.Now()
+	l.Time = Now(ctx)
 	l.lock.Unlock()
 	id, err := l.createLock(ctx)
 	if err != nil {
@@ -291,7 +302,7 @@ func (l *Lock) RefreshStaleLock(ctx context.Context) error {
 	}
 
 	l.lock.Lock()
-	l.Time = time.Now()
+	l.Time = Now(ctx)
 	l.lock.Unlock()
 	id, err := l.createLock(ctx)
 	if err != nil {
@@ -351,21 +362,6 @@ func (l *Lock) String() string {
 	return text
 }
 
-// listen for incoming SIGHUP and ignore
-var ignoreSIGHUP sync.Once
-
-func init() {
-	ignoreSIGHUP.Do(func() {
-		go func() {
-			c := make(chan os.Signal, 1)
-			signal.Notify(c, syscall.SIGHUP)
-			for s := range c {
-				debug.Log("Signal received: %v\n", s)
-			}
-		}()
-	})
-}
-
 // LoadLock loads and unserializes a lock from a repository.
 func LoadLock(ctx context.Context, repo Repository, id ID) (*Lock, error) {
 	lock := &Lock{}
@@ -387,7 +383,7 @@ func RemoveStaleLocks(ctx context.Context, repo Repository) (uint, error) {
 			return nil
 		}
 
-		if lock.Stale() {
+		if lock.StaleAt(Now(ctx)) {
 			err = repo.Backend().Remove(ctx, backend.Handle{Type: LockFile, Name: id.String()})
 			if err == nil {
 				processed++
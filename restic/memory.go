@@ -0,0 +1,76 @@
+package restic
+
+import (
+	"context"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// MemoryBudgeter is implemented by repositories which limit the memory used
+// by operations on them.
+type MemoryBudgeter interface {
+	// MemoryBudget returns the number of bytes operations should use at
+	// most, zero means no limit.
+	MemoryBudget() uint64
+}
+
+// MemoryBudget returns the memory budget of repo, it is zero if repo does not
+// implement MemoryBudgeter or has no budget.
+func MemoryBudget(repo interface{}) uint64 {
+	if b, ok := repo.(MemoryBudgeter); ok {
+		return b.MemoryBudget()
+	}
+	return 0
+}
+
+// MemoryLimiter admits work only while the memory it needs fits into a
+// budget. Work which needs more than the whole budget is admitted alone. A nil
+// MemoryLimiter admits everything.
+type MemoryLimiter struct {
+	limit int64
+	sem   *semaphore.Weighted
+}
+
+// NewMemoryLimiter returns a MemoryLimiter for limit bytes, it returns nil if
+// limit is zero.
+func NewMemoryLimiter(limit uint64) *MemoryLimiter {
+	if limit == 0 {
+		return nil
+	}
+	return &MemoryLimiter{
+		limit: int64(limit),
+		sem:   semaphore.NewWeighted(int64(limit)),
+	}
+}
+
+// Acquire blocks until n bytes are available and returns a function which
+// releases them again.
+func (l *MemoryLimiter) Acquire(ctx context.Context, n int64) (release func(), err error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	if n > l.limit {
+		n = l.limit
+	}
+	if err := l.sem.Acquire(ctx, n); err != nil {
+		return nil, err
+	}
+	return func() { l.sem.Release(n) }, nil
+}
+
+// Workers returns the number of workers which each need perWorker bytes that
+// fit into the budget, it is at most max and at least one. Without budget
+// max is returned.
+func (l *MemoryLimiter) Workers(perWorker int64, max int) int {
+	if l == nil || perWorker <= 0 {
+		return max
+	}
+	n := int(l.limit / perWorker)
+	if n > max {
+		n = max
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
@@ -0,0 +1,37 @@
+package restic_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/restic"
+)
+
+func TestMemoryLimiter(t *testing.T) {
+	var unlimited *restic.MemoryLimiter
+	release, err := unlimited.Acquire(context.TODO(), 1<<40)
+	rtest.OK(t, err)
+	release()
+	rtest.Equals(t, 8, unlimited.Workers(100, 8))
+
+	l := restic.NewMemoryLimiter(100)
+	rtest.Equals(t, 2, l.Workers(40, 8))
+	rtest.Equals(t, 1, l.Workers(1000, 8))
+	rtest.Equals(t, 8, l.Workers(1, 8))
+
+	// oversized requests are admitted alone
+	release, err = l.Acquire(context.TODO(), 1000)
+	rtest.OK(t, err)
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Millisecond)
+	defer cancel()
+	_, err = l.Acquire(ctx, 1)
+	rtest.Assert(t, err != nil, "acquire succeeded although the budget is used up")
+
+	release()
+	release, err = l.Acquire(context.TODO(), 60)
+	rtest.OK(t, err)
+	release()
+}
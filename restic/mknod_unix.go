@@ -1,5 +1,5 @@
-//go:build !freebsd && !windows
-// +build !freebsd,!windows
+//go:build !freebsd && !windows && !js
+// +build !freebsd,!windows,!js
 
 package restic
 
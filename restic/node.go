@@ -628,18 +628,24 @@ func (node *Node) fillExtra(path string, fi os.FileInfo) error {
 	return node.fillExtendedAttributes(path)
 }
 
+// fillExtendedAttributes adds the extended attributes of path to node. If
+// some of them cannot be read, an XattrError is returned and the node holds
+// the remaining ones.
 func (node *Node) fillExtendedAttributes(path string) error {
 	xattrs, err := Listxattr(path)
 	debug.Log("fillExtendedAttributes(%v) %v %v", path, xattrs, err)
 	if err != nil {
-		return err
+		return &XattrError{Path: path, Err: err}
 	}
 
 	node.ExtendedAttributes = make([]ExtendedAttribute, 0, len(xattrs))
+	var firstErr error
 	for _, attr := range xattrs {
 		attrVal, err := Getxattr(path, attr)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "can not obtain extended attribute %v for %v:\n", attr, path)
+			if firstErr == nil {
+				firstErr = &XattrError{Path: path, Err: errors.Wrapf(err, "attribute %v", attr)}
+			}
 			continue
 		}
 		attr := ExtendedAttribute{
@@ -650,7 +656,7 @@ func (node *Node) fillExtendedAttributes(path string) error {
 		node.ExtendedAttributes = append(node.ExtendedAttributes, attr)
 	}
 
-	return nil
+	return firstErr
 }
 
 func mkfifo(path string, mode uint32) (err error) {
@@ -0,0 +1,34 @@
+package restic
+
+import (
+	"syscall"
+
+	"github.com/konidev20/rapi/internal/errors"
+)
+
+func (node Node) restoreSymlinkTimestamps(path string, utimes [2]syscall.Timespec) error {
+	return nil
+}
+
+func (s statT) atim() syscall.Timespec { return syscall.NsecToTimespec(s.Atime*1e9 + s.AtimeNsec) }
+func (s statT) mtim() syscall.Timespec { return syscall.NsecToTimespec(s.Mtime*1e9 + s.MtimeNsec) }
+func (s statT) ctim() syscall.Timespec { return syscall.NsecToTimespec(s.Ctime*1e9 + s.CtimeNsec) }
+
+func mknod(path string, mode uint32, dev uint64) error {
+	return errors.New("device files are not supported on js/wasm")
+}
+
+// Getxattr is a no-op on js/wasm.
+func Getxattr(path, name string) ([]byte, error) {
+	return nil, nil
+}
+
+// Listxattr is a no-op on js/wasm.
+func Listxattr(path string) ([]string, error) {
+	return nil, nil
+}
+
+// Setxattr is a no-op on js/wasm.
+func Setxattr(path, name string, data []byte) error {
+	return nil
+}
@@ -1,9 +1,11 @@
 package restic
 
 import (
+	"strings"
 	"syscall"
 
 	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/internal/fs"
 )
 
 // mknod is not supported on Windows.
@@ -45,8 +47,12 @@ func Listxattr(path string) ([]string, error) {
 	return nil, nil
 }
 
-// Setxattr associates name and data together as an attribute of path.
+// Setxattr associates name and data together as an attribute of path. Only
+// alternate data streams are supported, other attributes are ignored.
 func Setxattr(path, name string, data []byte) error {
+	if stream := strings.TrimPrefix(name, fs.AlternateDataStreamPrefix); stream != name {
+		return fs.WriteAlternateDataStream(path, stream, data)
+	}
 	return nil
 }
 
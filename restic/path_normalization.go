@@ -0,0 +1,118 @@
+package restic
+
+import (
+	"strings"
+
+	"github.com/konidev20/rapi/internal/errors"
+	"golang.org/x/text/unicode/norm"
+)
+
+// UnicodeForm selects the Unicode normalization form for paths.
+type UnicodeForm string
+
+// Supported Unicode normalization forms. Windows and Linux usually use NFC,
+// macOS stores file names in NFD.
+const (
+	UnicodeUnchanged UnicodeForm = ""
+	UnicodeNFC       UnicodeForm = "NFC"
+	UnicodeNFD       UnicodeForm = "NFD"
+)
+
+// PathNormalization describes how the paths and the hostname of a snapshot
+// are stored, so that snapshots of the same data taken on different
+// platforms compare equal, for example when searching the parent snapshot.
+// The zero value leaves paths and hostnames unchanged. Only the metadata of
+// the snapshot is normalized, the file names in its trees are kept as is.
+type PathNormalization struct {
+	// FoldCase converts paths and the hostname to lower case.
+	FoldCase bool `json:"fold_case,omitempty"`
+
+	// Separator replaces both slashes and backslashes in paths, zero keeps
+	// the separators.
+	Separator rune `json:"separator,omitempty"`
+
+	// Unicode converts paths to the given normalization form.
+	Unicode UnicodeForm `json:"unicode,omitempty"`
+
+	// Prefix is prepended to all paths, for example to store the paths of
+	// several machines below one virtual directory.
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// IsZero returns true if n does not change anything.
+func (n PathNormalization) IsZero() bool {
+	return n == PathNormalization{}
+}
+
+// Validate returns an error if n is not a valid normalization.
+func (n PathNormalization) Validate() error {
+	switch n.Unicode {
+	case UnicodeUnchanged, UnicodeNFC, UnicodeNFD:
+	default:
+		return errors.Errorf("invalid unicode normalization form %q", n.Unicode)
+	}
+	if n.Separator != 0 && n.Separator != '/' && n.Separator != '\\' {
+		return errors.Errorf("invalid path separator %q", n.Separator)
+	}
+	return nil
+}
+
+// Path returns the normalized form of p.
+func (n PathNormalization) Path(p string) string {
+	switch n.Unicode {
+	case UnicodeNFC:
+		p = norm.NFC.String(p)
+	case UnicodeNFD:
+		p = norm.NFD.String(p)
+	}
+
+	if n.FoldCase {
+		p = strings.ToLower(p)
+	}
+
+	sep := "/"
+	if n.Separator != 0 {
+		sep = string(n.Separator)
+		p = strings.NewReplacer("/", sep, `\`, sep).Replace(p)
+	}
+
+	if n.Prefix != "" {
+		// the prefix is normalized like the paths, paths which already
+		// start with it are kept so that normalizing is idempotent
+		withoutPrefix := n
+		withoutPrefix.Prefix = ""
+		prefix := strings.TrimRight(withoutPrefix.Path(n.Prefix), sep)
+		if p != prefix && !strings.HasPrefix(p, prefix+sep) {
+			p = prefix + sep + strings.TrimLeft(p, sep)
+		}
+	}
+
+	return p
+}
+
+// Paths returns the normalized form of all paths.
+func (n PathNormalization) Paths(paths []string) []string {
+	if n.IsZero() {
+		return paths
+	}
+
+	res := make([]string, 0, len(paths))
+	for _, p := range paths {
+		res = append(res, n.Path(p))
+	}
+	return res
+}
+
+// Hostname returns the normalized form of hostname.
+func (n PathNormalization) Hostname(hostname string) string {
+	if n.FoldCase {
+		return strings.ToLower(hostname)
+	}
+	return hostname
+}
+
+// Apply normalizes the paths and the hostname of sn.
+func (n PathNormalization) Apply(sn *Snapshot) {
+	sn.Paths = n.Paths(sn.Paths)
+	sn.Hostname = n.Hostname(sn.Hostname)
+}
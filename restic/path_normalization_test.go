@@ -0,0 +1,67 @@
+package restic_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/restic"
+)
+
+func TestPathNormalization(t *testing.T) {
+	for _, test := range []struct {
+		n        restic.PathNormalization
+		path     string
+		expected string
+	}{
+		{restic.PathNormalization{}, `C:\Users\Foo`, `C:\Users\Foo`},
+		{restic.PathNormalization{Separator: '/'}, `C:\Users\Foo`, `C:/Users/Foo`},
+		{restic.PathNormalization{Separator: '\\'}, `/home/foo`, `\home\foo`},
+		{restic.PathNormalization{FoldCase: true, Separator: '/'}, `C:\Users\Foo`, `c:/users/foo`},
+		{restic.PathNormalization{Unicode: restic.UnicodeNFC}, "/home/Cafe\u0301", "/home/Caf\u00e9"},
+		{restic.PathNormalization{Unicode: restic.UnicodeNFD}, "/home/Caf\u00e9", "/home/Cafe\u0301"},
+		{restic.PathNormalization{Prefix: "/hosts/win"}, "/home/foo", "/hosts/win/home/foo"},
+		{restic.PathNormalization{Prefix: "/hosts/win", Separator: '/'}, `C:\Users`, "/hosts/win/C:/Users"},
+		// normalizing twice does not add the prefix again
+		{restic.PathNormalization{Prefix: "/hosts/win"}, "/hosts/win/home/foo", "/hosts/win/home/foo"},
+	} {
+		rtest.OK(t, test.n.Validate())
+		rtest.Equals(t, test.expected, test.n.Path(test.path))
+	}
+
+	rtest.Assert(t, restic.PathNormalization{Unicode: "NFKC"}.Validate() != nil, "invalid form was accepted")
+	rtest.Assert(t, restic.PathNormalization{Separator: ':'}.Validate() != nil, "invalid separator was accepted")
+}
+
+func TestFindLatestNormalized(t *testing.T) {
+	repo := repository.TestRepository(t)
+
+	sn := &restic.Snapshot{
+		Time:     time.Now(),
+		Paths:    []string{`C:\Users\Foo`},
+		Hostname: "WORKSTATION",
+		Tree:     &restic.ID{},
+	}
+	n := restic.PathNormalization{FoldCase: true, Separator: '/'}
+	n.Apply(sn)
+	rtest.Equals(t, []string{"c:/users/foo"}, sn.Paths)
+	rtest.Equals(t, "workstation", sn.Hostname)
+	id, err := restic.SaveSnapshot(context.TODO(), repo, sn)
+	rtest.OK(t, err)
+
+	f := restic.SnapshotFilter{
+		Hosts:         []string{"Workstation"},
+		Paths:         []string{`C:\users\FOO`},
+		Normalization: n,
+	}
+	found, _, err := f.FindLatest(context.TODO(), repo, repo, "latest")
+	rtest.OK(t, err)
+	rtest.Equals(t, id, *found.ID())
+
+	// without normalization the paths do not match
+	f.Normalization = restic.PathNormalization{}
+	_, _, err = f.FindLatest(context.TODO(), repo, repo, "latest")
+	rtest.Assert(t, err != nil, "snapshot should not be found without normalization")
+}
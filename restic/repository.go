@@ -57,6 +57,31 @@ type Repository interface {
 	SaveUnpacked(context.Context, FileType, []byte) (ID, error)
 }
 
+// BlobStore provides content-addressed access to the blobs of a repository.
+// A blob is identified by its type and the SHA-256 hash of its plaintext.
+// The index must be loaded before the blob methods are used, and
+// StartPackUploader must be called before blobs are saved.
+type BlobStore interface {
+	// LookupBlob returns the locations of a blob in pack files.
+	LookupBlob(BlobType, ID) []PackedBlob
+	// HasBlob returns true if the blob is stored or currently being saved.
+	HasBlob(BlobType, ID) bool
+	LookupBlobSize(ID, BlobType) (uint, bool)
+
+	// LoadBlob loads and decrypts a blob and verifies its hash. buf is
+	// used if it is large enough.
+	LoadBlob(context.Context, BlobType, ID, []byte) ([]byte, error)
+	// SaveBlob saves a blob unless the repository already contains it or
+	// storeDuplicate is set, and returns its ID, whether it was already
+	// known and the size of the stored data. If the ID is null, it is
+	// computed.
+	SaveBlob(ctx context.Context, t BlobType, buf []byte, id ID, storeDuplicate bool) (newID ID, known bool, size int, err error)
+
+	StartPackUploader(ctx context.Context, wg *errgroup.Group)
+	// Flush uploads pending pack files and saves the index of new blobs.
+	Flush(context.Context) error
+}
+
 type FileType = backend.FileType
 
 // These are the different data types a backend can store.
@@ -101,6 +126,17 @@ type MasterIndex interface {
 	Save(ctx context.Context, repo SaverUnpacked, packBlacklist IDSet, extraObsolete IDs, p *progress.Counter) (obsolete IDSet, err error)
 }
 
+// IndexErr returns the first error of reading idx, for example from a local
+// file. Lookups which failed report the blob as missing, so operations which
+// depend on complete answers must check it. Indexes which can fail implement
+// an Err method, all others never fail.
+func IndexErr(idx MasterIndex) error {
+	if e, ok := idx.(interface{ Err() error }); ok {
+		return e.Err()
+	}
+	return nil
+}
+
 // Lister allows listing files in a backend.
 type Lister interface {
 	List(ctx context.Context, t FileType, fn func(ID, int64) error) error
@@ -27,9 +27,42 @@ type Snapshot struct {
 
 	ProgramVersion string `json:"program_version,omitempty"`
 
+	// Summary holds the statistics of the backup which created the
+	// snapshot, it is nil for snapshots created otherwise or by older
+	// versions.
+	Summary *SnapshotSummary `json:"summary,omitempty"`
+
 	id *ID // plaintext ID, used during restore
 }
 
+// SnapshotSummary holds the statistics of a backup run.
+type SnapshotSummary struct {
+	BackupStart time.Time `json:"backup_start"`
+	BackupEnd   time.Time `json:"backup_end"`
+
+	FilesNew        uint `json:"files_new"`
+	FilesChanged    uint `json:"files_changed"`
+	FilesUnmodified uint `json:"files_unmodified"`
+	DirsNew         uint `json:"dirs_new"`
+	DirsChanged     uint `json:"dirs_changed"`
+	DirsUnmodified  uint `json:"dirs_unmodified"`
+
+	DataBlobs int `json:"data_blobs"`
+	TreeBlobs int `json:"tree_blobs"`
+	// DataAdded is the size of the new blobs, DataAddedPacked the number of
+	// bytes added to the repository after compression and encryption.
+	DataAdded       uint64 `json:"data_added"`
+	DataAddedPacked uint64 `json:"data_added_packed"`
+
+	TotalFilesProcessed uint   `json:"total_files_processed"`
+	TotalBytesProcessed uint64 `json:"total_bytes_processed"`
+}
+
+// Duration returns the time the backup took.
+func (s *SnapshotSummary) Duration() time.Duration {
+	return s.BackupEnd.Sub(s.BackupStart)
+}
+
 // NewSnapshot returns an initialized snapshot struct for the current user and
 // time.
 func NewSnapshot(paths []string, tags []string, hostname string, time time.Time) (*Snapshot, error) {
@@ -69,6 +102,16 @@ func LoadSnapshot(ctx context.Context, loader LoaderUnpacked, id ID) (*Snapshot,
 	return sn, nil
 }
 
+// TrashTag marks the snapshot files which hold a snapshot in the trash of
+// rapi.Forget. They are regular snapshot files, so that restic keeps the data
+// they reference, but they are not listed as snapshots by this package.
+const TrashTag = "rapi-trash"
+
+// InTrash returns true if the snapshot file holds a snapshot in the trash.
+func (sn *Snapshot) InTrash() bool {
+	return sn.hasTag(TrashTag)
+}
+
 // SaveSnapshot saves the snapshot sn and returns its ID.
 func SaveSnapshot(ctx context.Context, repo SaverUnpacked, sn *Snapshot) (ID, error) {
 	return SaveJSONUnpacked(ctx, repo, SnapshotFile, sn)
@@ -78,7 +121,8 @@ func SaveSnapshot(ctx context.Context, repo SaverUnpacked, sn *Snapshot) (ID, er
 // given function. It is guaranteed that the function is not run concurrently.
 // If the called function returns an error, this function is cancelled and
 // also returns this error.
-// If a snapshot ID is in excludeIDs, it will be ignored.
+// If a snapshot ID is in excludeIDs, it will be ignored. Snapshots in the
+// trash are skipped, see TrashTag.
 func ForAllSnapshots(ctx context.Context, be Lister, loader LoaderUnpacked, excludeIDs IDSet, fn func(ID, *Snapshot, error) error) error {
 	var m sync.Mutex
 
@@ -89,6 +133,9 @@ func ForAllSnapshots(ctx context.Context, be Lister, loader LoaderUnpacked, excl
 		}
 
 		sn, err := LoadSnapshot(ctx, loader, id)
+		if err == nil && sn.InTrash() {
+			return nil
+		}
 		m.Lock()
 		defer m.Unlock()
 		return fn(id, sn, err)
@@ -1,6 +1,7 @@
 package restic
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"path/filepath"
@@ -22,6 +23,9 @@ type SnapshotFilter struct {
 	Paths []string
 	// Match snapshots from before this timestamp. Zero for no limit.
 	TimestampLimit time.Time
+	// Normalization is applied to the paths and hostnames of both the
+	// filter and the snapshots before they are compared.
+	Normalization PathNormalization
 }
 
 func (f *SnapshotFilter) empty() bool {
@@ -29,9 +33,24 @@ func (f *SnapshotFilter) empty() bool {
 }
 
 func (f *SnapshotFilter) matches(sn *Snapshot) bool {
+	if !f.Normalization.IsZero() {
+		hosts := make([]string, 0, len(f.Hosts))
+		for _, host := range f.Hosts {
+			hosts = append(hosts, f.Normalization.Hostname(host))
+		}
+		normalized := &Snapshot{Paths: sn.Paths, Hostname: sn.Hostname}
+		f.Normalization.Apply(normalized)
+		return normalized.HasHostname(hosts) && sn.HasTagList(f.Tags) && normalized.HasPaths(f.Normalization.Paths(f.Paths))
+	}
 	return sn.HasHostname(f.Hosts) && sn.HasTagList(f.Tags) && sn.HasPaths(f.Paths)
 }
 
+// looksAbsolute returns true if p is an absolute path on any platform, it is
+// used for paths which may have been recorded on a different platform.
+func looksAbsolute(p string) bool {
+	return strings.HasPrefix(p, "/") || strings.HasPrefix(p, `\`) || (len(p) >= 2 && p[1] == ':')
+}
+
 // findLatest finds the latest snapshot with optional target/directory,
 // tags, hostname, and timestamp filters.
 func (f *SnapshotFilter) findLatest(ctx context.Context, be Lister, loader LoaderUnpacked) (*Snapshot, error) {
@@ -39,7 +58,7 @@ func (f *SnapshotFilter) findLatest(ctx context.Context, be Lister, loader Loade
 	var err error
 	absTargets := make([]string, 0, len(f.Paths))
 	for _, target := range f.Paths {
-		if !filepath.IsAbs(target) {
+		if !filepath.IsAbs(target) && (f.Normalization.IsZero() || !looksAbsolute(target)) {
 			target, err = filepath.Abs(target)
 			if err != nil {
 				return nil, errors.Wrap(err, "Abs")
@@ -64,6 +83,12 @@ func (f *SnapshotFilter) findLatest(ctx context.Context, be Lister, loader Loade
 			return nil
 		}
 
+		// snapshots are loaded in parallel, break ties by ID so that the
+		// result is deterministic
+		if latest != nil && snapshot.Time.Equal(latest.Time) && bytes.Compare(id[:], latest.ID()[:]) > 0 {
+			return nil
+		}
+
 		if !f.matches(snapshot) {
 			return nil
 		}
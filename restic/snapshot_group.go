@@ -103,3 +103,40 @@ func GroupSnapshots(snapshots Snapshots, groupBy SnapshotGroupByOptions) (map[st
 
 	return snapshotGroups, groupBy.Tag || groupBy.Host || groupBy.Path, nil
 }
+
+// SnapshotGroup is a list of snapshots which share the same group key.
+type SnapshotGroup struct {
+	Key       SnapshotGroupKey `json:"group_key"`
+	Snapshots Snapshots        `json:"snapshots"`
+}
+
+// GroupSnapshotList groups the snapshots like GroupSnapshots, but returns the
+// groups sorted by their key, and the snapshots within each group sorted
+// newest first. Without any grouping criteria, all snapshots are returned in
+// a single group.
+func GroupSnapshotList(snapshots Snapshots, groupBy SnapshotGroupByOptions) ([]SnapshotGroup, error) {
+	groups, _, err := GroupSnapshots(snapshots, groupBy)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(groups))
+	for k := range groups {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	list := make([]SnapshotGroup, 0, len(keys))
+	for _, k := range keys {
+		var key SnapshotGroupKey
+		if err := json.Unmarshal([]byte(k), &key); err != nil {
+			return nil, err
+		}
+
+		sns := groups[k]
+		sort.Stable(sns)
+		list = append(list, SnapshotGroup{Key: key, Snapshots: sns})
+	}
+
+	return list, nil
+}
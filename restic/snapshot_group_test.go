@@ -2,6 +2,7 @@ package restic_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/konidev20/rapi/restic"
@@ -48,3 +49,31 @@ func TestGroupByOptions(t *testing.T) {
 	test.Assert(t, err != nil, "missing error on invalid tags")
 	test.Assert(t, !opts.Host && !opts.Path && !opts.Tag, "unexpected opts %s %s %s", opts.Host, opts.Path, opts.Tag)
 }
+
+func TestGroupSnapshotList(t *testing.T) {
+	now := time.Now()
+	snapshots := restic.Snapshots{
+		{Hostname: "foo", Paths: []string{"/home"}, Time: now.Add(-time.Hour)},
+		{Hostname: "bar", Paths: []string{"/home"}, Time: now},
+		{Hostname: "foo", Paths: []string{"/home"}, Time: now},
+		{Hostname: "foo", Paths: []string{"/etc"}, Time: now},
+	}
+
+	groups, err := restic.GroupSnapshotList(snapshots, restic.SnapshotGroupByOptions{Host: true})
+	test.OK(t, err)
+	test.Equals(t, 2, len(groups))
+	test.Equals(t, "bar", groups[0].Key.Hostname)
+	test.Equals(t, 1, len(groups[0].Snapshots))
+	test.Equals(t, "foo", groups[1].Key.Hostname)
+	test.Equals(t, 3, len(groups[1].Snapshots))
+	test.Assert(t, !groups[1].Snapshots[2].Time.After(groups[1].Snapshots[0].Time), "snapshots are not sorted newest first")
+
+	groups, err = restic.GroupSnapshotList(snapshots, restic.SnapshotGroupByOptions{Host: true, Path: true})
+	test.OK(t, err)
+	test.Equals(t, 3, len(groups))
+
+	groups, err = restic.GroupSnapshotList(snapshots, restic.SnapshotGroupByOptions{})
+	test.OK(t, err)
+	test.Equals(t, 1, len(groups))
+	test.Equals(t, 4, len(groups[0].Snapshots))
+}
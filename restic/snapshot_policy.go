@@ -142,13 +142,14 @@ func always(_ time.Time, nr int) int {
 
 // findLatestTimestamp returns the time stamp for the latest (newest) snapshot,
 // for use with policies based on time relative to latest.
-func findLatestTimestamp(list Snapshots) time.Time {
+func findLatestTimestamp(list Snapshots, now time.Time) time.Time {
 	if len(list) == 0 {
 		panic("list of snapshots is empty")
 	}
 
 	var latest time.Time
-	now := time.Now()
+	// tolerate snapshots of hosts whose clocks are slightly ahead
+	now = now.Add(ClockSkewTolerance)
 	for _, sn := range list {
 		// Find the latest snapshot in the list
 		// The latest snapshot must, however, not be in the future.
@@ -183,6 +184,12 @@ type KeepReason struct {
 // according to the policy p. list is sorted in the process. reasons contains
 // the reasons to keep each snapshot, it is in the same order as keep.
 func ApplyPolicy(list Snapshots, p ExpirePolicy) (keep, remove Snapshots, reasons []KeepReason) {
+	return ApplyPolicyAt(list, p, time.Now())
+}
+
+// ApplyPolicyAt is like ApplyPolicy, but evaluates the policy at the time now.
+// Snapshots later than now are not used as the newest snapshot for Within.
+func ApplyPolicyAt(list Snapshots, p ExpirePolicy, now time.Time) (keep, remove Snapshots, reasons []KeepReason) {
 	// sort newest snapshots first
 	sort.Stable(list)
 
@@ -229,7 +236,7 @@ func ApplyPolicy(list Snapshots, p ExpirePolicy) (keep, remove Snapshots, reason
 		{p.WithinYearly, y, -1, "yearly within"},
 	}
 
-	latest := findLatestTimestamp(list)
+	latest := findLatestTimestamp(list, now)
 
 	for nr, cur := range list {
 		var keepSnap bool
@@ -0,0 +1,175 @@
+package restic
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/konidev20/rapi/internal/errors"
+)
+
+// AmbiguousSnapshotError is returned by ResolveSnapshot if an abbreviated
+// snapshot ID matches several snapshots.
+type AmbiguousSnapshotError struct {
+	Ref        string
+	Candidates IDs
+}
+
+func (e *AmbiguousSnapshotError) Error() string {
+	ids := make([]string, 0, len(e.Candidates))
+	for _, id := range e.Candidates {
+		ids = append(ids, id.Str())
+	}
+	return fmt.Sprintf("snapshot reference %q is ambiguous, it matches %s", e.Ref, strings.Join(ids, ", "))
+}
+
+// ResolveSnapshot resolves a user-facing reference to a snapshot. Supported
+// references are
+//
+//   - a full or abbreviated snapshot ID,
+//   - "latest", the latest snapshot matching the filter f,
+//   - "latest:host=web01,tag=db,path=/srv", the latest snapshot matching
+//     f and the given criteria. Several tags must all be present, several
+//     hosts or paths are alternatives respectively requirements like in
+//     SnapshotFilter.
+//
+// All forms may be followed by ":subfolder", which is returned separately.
+// If several snapshots have the same time, the one with the smallest ID is
+// considered the latest, so that the result does not depend on the order in
+// which the snapshots are listed.
+func ResolveSnapshot(ctx context.Context, be Lister, loader LoaderUnpacked, f SnapshotFilter, ref string) (*Snapshot, string, error) {
+	if ref == "" {
+		return nil, "", errors.New("empty snapshot reference")
+	}
+
+	if ref == "latest" || strings.HasPrefix(ref, "latest:") {
+		rest := strings.TrimPrefix(strings.TrimPrefix(ref, "latest"), ":")
+		criteria, subfolder := rest, ""
+		if !isSnapshotCriteria(criteria) {
+			criteria, subfolder = "", rest
+		} else if c, s, ok := strings.Cut(rest, ":"); ok {
+			criteria, subfolder = c, s
+		}
+
+		if criteria != "" {
+			if err := f.addCriteria(criteria); err != nil {
+				return nil, "", errors.Fatalf("invalid snapshot reference %q: %v", ref, err)
+			}
+		}
+
+		sn, err := f.findLatest(ctx, be, loader)
+		if err == ErrNoSnapshotFound {
+			err = fmt.Errorf("snapshot reference %q (Paths:%v Tags:%v Hosts:%v): %w",
+				ref, f.Paths, f.Tags, f.Hosts, err)
+		}
+		return sn, subfolder, err
+	}
+
+	prefix, subfolder := splitSnapshotID(ref)
+	id, err := ParseID(prefix)
+	if err != nil {
+		id, err = resolveSnapshotPrefix(ctx, be, ref, prefix)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	sn, err := LoadSnapshot(ctx, loader, id)
+	return sn, subfolder, err
+}
+
+// isSnapshotCriteria returns true if s starts with key=value criteria instead
+// of a subfolder.
+func isSnapshotCriteria(s string) bool {
+	key, _, ok := strings.Cut(s, "=")
+	if !ok {
+		return false
+	}
+	switch key {
+	case "host", "tag", "path":
+		return true
+	}
+	return false
+}
+
+// addCriteria adds criteria in the format "host=a,tag=b,path=c" to f.
+func (f *SnapshotFilter) addCriteria(criteria string) error {
+	var hosts, paths []string
+	var tags TagList
+	for _, kv := range strings.Split(criteria, ",") {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok || value == "" {
+			return errors.Errorf("expected key=value, got %q", kv)
+		}
+		switch key {
+		case "host":
+			hosts = append(hosts, value)
+		case "tag":
+			tags = append(tags, value)
+		case "path":
+			paths = append(paths, value)
+		default:
+			return errors.Errorf("unknown criterion %q, must be host, tag or path", key)
+		}
+	}
+
+	if len(hosts) > 0 {
+		f.Hosts = hosts
+	}
+	if len(tags) > 0 {
+		f.Tags = TagLists{tags}
+	}
+	if len(paths) > 0 {
+		f.Paths = paths
+	}
+	return nil
+}
+
+// resolveSnapshotPrefix returns the ID of the only snapshot starting with
+// prefix.
+func resolveSnapshotPrefix(ctx context.Context, be Lister, ref, prefix string) (ID, error) {
+	var candidates IDs
+	err := be.List(ctx, SnapshotFile, func(id ID, _ int64) error {
+		if strings.HasPrefix(id.String(), prefix) {
+			candidates = append(candidates, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return ID{}, err
+	}
+
+	switch len(candidates) {
+	case 0:
+		return ID{}, &NoIDByPrefixError{prefix}
+	case 1:
+		return candidates[0], nil
+	default:
+		sort.Sort(candidates)
+		return ID{}, &AmbiguousSnapshotError{Ref: ref, Candidates: candidates}
+	}
+}
+
+// ResolveSnapshots resolves several references with ResolveSnapshot and
+// returns the snapshots in the order of refs, without duplicates. Subfolders
+// are not allowed.
+func ResolveSnapshots(ctx context.Context, be Lister, loader LoaderUnpacked, f SnapshotFilter, refs []string) (Snapshots, error) {
+	seen := NewIDSet()
+	result := make(Snapshots, 0, len(refs))
+	for _, ref := range refs {
+		sn, subfolder, err := ResolveSnapshot(ctx, be, loader, f, ref)
+		if err != nil {
+			return nil, err
+		}
+		if subfolder != "" {
+			return nil, ErrInvalidSnapshotSyntax
+		}
+		if seen.Has(*sn.ID()) {
+			continue
+		}
+		seen.Insert(*sn.ID())
+		result = append(result, sn)
+	}
+	return result, nil
+}
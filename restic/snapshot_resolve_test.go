@@ -0,0 +1,102 @@
+package restic_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/restic"
+)
+
+func saveTestSnapshot(t *testing.T, repo restic.Repository, at time.Time, host string, tags ...string) restic.ID {
+	sn := &restic.Snapshot{Time: at, Hostname: host, Tags: tags, Paths: []string{"/srv"}, Tree: &restic.ID{}}
+	id, err := restic.SaveSnapshot(context.TODO(), repo, sn)
+	rtest.OK(t, err)
+	return id
+}
+
+func TestResolveSnapshot(t *testing.T) {
+	repo := repository.TestRepository(t)
+	web := saveTestSnapshot(t, repo, parseTimeUTC("2020-01-01 00:00:00"), "web01", "db")
+	latest := saveTestSnapshot(t, repo, parseTimeUTC("2021-01-01 00:00:00"), "web02")
+
+	for _, test := range []struct {
+		ref       string
+		id        restic.ID
+		subfolder string
+	}{
+		{"latest", latest, ""},
+		{"latest:sub/folder", latest, "sub/folder"},
+		{"latest:host=web01", web, ""},
+		{"latest:tag=db", web, ""},
+		{"latest:host=web01,tag=db:sub", web, "sub"},
+		{"latest:path=/srv", latest, ""},
+		{web.String(), web, ""},
+		{web.String()[:10] + ":sub", web, "sub"},
+	} {
+		sn, subfolder, err := restic.ResolveSnapshot(context.TODO(), repo, repo, restic.SnapshotFilter{}, test.ref)
+		rtest.OK(t, err)
+		rtest.Equals(t, test.id, *sn.ID())
+		rtest.Equals(t, test.subfolder, subfolder)
+	}
+
+	for _, ref := range []string{"", "latest:host=web03", "latest:host=", "latest:host=web01,color=red"} {
+		_, _, err := restic.ResolveSnapshot(context.TODO(), repo, repo, restic.SnapshotFilter{}, ref)
+		rtest.Assert(t, err != nil, "expected error for %q", ref)
+	}
+
+	sns, err := restic.ResolveSnapshots(context.TODO(), repo, repo, restic.SnapshotFilter{}, []string{"latest", latest.Str(), web.Str()})
+	rtest.OK(t, err)
+	rtest.Equals(t, 2, len(sns))
+	rtest.Equals(t, latest, *sns[0].ID())
+	rtest.Equals(t, web, *sns[1].ID())
+}
+
+func TestResolveSnapshotAmbiguous(t *testing.T) {
+	repo := repository.TestRepository(t)
+
+	// with 17 snapshots at least two share the first hex digit
+	byPrefix := make(map[string]restic.IDs)
+	at := parseTimeUTC("2020-01-01 00:00:00")
+	for i := 0; i < 17; i++ {
+		id := saveTestSnapshot(t, repo, at.Add(time.Duration(i)*time.Hour), "host")
+		byPrefix[id.String()[:1]] = append(byPrefix[id.String()[:1]], id)
+	}
+
+	for prefix, ids := range byPrefix {
+		if len(ids) < 2 {
+			continue
+		}
+		_, _, err := restic.ResolveSnapshot(context.TODO(), repo, repo, restic.SnapshotFilter{}, prefix)
+		var ambiguous *restic.AmbiguousSnapshotError
+		rtest.Assert(t, errors.As(err, &ambiguous), "expected ambiguity error for %q, got %v", prefix, err)
+		rtest.Equals(t, len(ids), len(ambiguous.Candidates))
+		return
+	}
+	t.Fatal("no shared prefix found")
+}
+
+func TestResolveSnapshotTieBreak(t *testing.T) {
+	repo := repository.TestRepository(t)
+	at := parseTimeUTC("2020-01-01 00:00:00")
+	ids := restic.IDs{
+		saveTestSnapshot(t, repo, at, "a"),
+		saveTestSnapshot(t, repo, at, "b"),
+		saveTestSnapshot(t, repo, at, "c"),
+	}
+	smallest := ids[0]
+	for _, id := range ids[1:] {
+		if id.String() < smallest.String() {
+			smallest = id
+		}
+	}
+
+	for i := 0; i < 5; i++ {
+		sn, _, err := restic.ResolveSnapshot(context.TODO(), repo, repo, restic.SnapshotFilter{}, "latest")
+		rtest.OK(t, err)
+		rtest.Equals(t, smallest, *sn.ID())
+	}
+}
@@ -0,0 +1,78 @@
+package restic
+
+import "os"
+
+// SpecialFileAction describes how special files (devices, FIFOs and sockets)
+// are handled during backup and restore.
+type SpecialFileAction int
+
+const (
+	// SpecialFileDefault uses the default action for the type of file, see
+	// SpecialFilePolicy.
+	SpecialFileDefault SpecialFileAction = iota
+	// SpecialFileRecord saves the file during backup and creates it during
+	// restore.
+	SpecialFileRecord
+	// SpecialFileSkip ignores the file.
+	SpecialFileSkip
+	// SpecialFileError reports an error for the file.
+	SpecialFileError
+)
+
+func (a SpecialFileAction) String() string {
+	switch a {
+	case SpecialFileDefault:
+		return "default"
+	case SpecialFileRecord:
+		return "record"
+	case SpecialFileSkip:
+		return "skip"
+	case SpecialFileError:
+		return "error"
+	}
+	return "unknown"
+}
+
+// SpecialFilePolicy configures the action for each type of special file. By
+// default, devices and FIFOs are saved and sockets are skipped. Device nodes
+// are restored only if the process is allowed to create them, FIFOs are
+// always restored and sockets cannot be restored.
+type SpecialFilePolicy struct {
+	// Devices applies to block and character devices.
+	Devices SpecialFileAction
+	FIFOs   SpecialFileAction
+	Sockets SpecialFileAction
+}
+
+// Action returns the configured action for the node type, it is
+// SpecialFileDefault for types which are no special files or if no action
+// is configured.
+func (p SpecialFilePolicy) Action(nodeType string) SpecialFileAction {
+	switch nodeType {
+	case "dev", "chardev":
+		return p.Devices
+	case "fifo":
+		return p.FIFOs
+	case "socket":
+		return p.Sockets
+	}
+	return SpecialFileDefault
+}
+
+// IsSpecialFile returns true if the node type is a device, FIFO or socket.
+func IsSpecialFile(nodeType string) bool {
+	switch nodeType {
+	case "dev", "chardev", "fifo", "socket":
+		return true
+	}
+	return false
+}
+
+// SpecialFileType returns the node type for the file described by fi, it is
+// empty if fi is no special file.
+func SpecialFileType(fi os.FileInfo) string {
+	if t := nodeTypeFromFileInfo(fi); IsSpecialFile(t) {
+		return t
+	}
+	return ""
+}
@@ -0,0 +1,27 @@
+package restic_test
+
+import (
+	"testing"
+
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/restic"
+)
+
+func TestSpecialFilePolicy(t *testing.T) {
+	p := restic.SpecialFilePolicy{
+		Devices: restic.SpecialFileError,
+		FIFOs:   restic.SpecialFileSkip,
+	}
+
+	for nodeType, want := range map[string]restic.SpecialFileAction{
+		"dev":     restic.SpecialFileError,
+		"chardev": restic.SpecialFileError,
+		"fifo":    restic.SpecialFileSkip,
+		"socket":  restic.SpecialFileDefault,
+		"file":    restic.SpecialFileDefault,
+		"dir":     restic.SpecialFileDefault,
+	} {
+		rtest.Equals(t, want, p.Action(nodeType))
+		rtest.Equals(t, want != restic.SpecialFileDefault || nodeType == "socket", restic.IsSpecialFile(nodeType))
+	}
+}
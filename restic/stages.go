@@ -0,0 +1,142 @@
+package restic
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// Stage is a step of saving data to a repository during a backup.
+type Stage int
+
+// Stages of a backup, in the order data passes through them.
+const (
+	StageScan Stage = iota
+	StageRead
+	StageChunk
+	StageHash
+	StageCompress
+	StageEncrypt
+	StageUpload
+	numStages
+)
+
+var stageNames = [numStages]string{"scan", "read", "chunk", "hash", "compress", "encrypt", "upload"}
+
+func (s Stage) String() string {
+	if s < 0 || s >= numStages {
+		return "invalid"
+	}
+	return stageNames[s]
+}
+
+// StageTimes accumulates the time spent in each stage. Stages run in several
+// goroutines at once, so the sum of the times is usually larger than the
+// duration of the backup. It is safe for concurrent use, the methods of a nil
+// StageTimes do nothing.
+type StageTimes struct {
+	d [numStages]atomic.Int64
+}
+
+// Add records that stage s took d.
+func (st *StageTimes) Add(s Stage, d time.Duration) {
+	if st == nil {
+		return
+	}
+	st.d[s].Add(int64(d))
+}
+
+// Since records the time since start for stage s.
+func (st *StageTimes) Since(s Stage, start time.Time) {
+	if st == nil {
+		return
+	}
+	st.Add(s, time.Since(start))
+}
+
+// Get returns the time spent in stage s.
+func (st *StageTimes) Get(s Stage) time.Duration {
+	if st == nil {
+		return 0
+	}
+	return time.Duration(st.d[s].Load())
+}
+
+type stageTimesKey struct{}
+
+// WithStageTimes returns a context which makes the operations called with it
+// record the time spent in each stage in st.
+func WithStageTimes(ctx context.Context, st *StageTimes) context.Context {
+	return context.WithValue(ctx, stageTimesKey{}, st)
+}
+
+// StageTimesFromContext returns the StageTimes of ctx, or nil if there are
+// none.
+func StageTimesFromContext(ctx context.Context) *StageTimes {
+	st, _ := ctx.Value(stageTimesKey{}).(*StageTimes)
+	return st
+}
+
+// Resources which can limit the throughput of a backup.
+const (
+	BottleneckDisk    = "disk"
+	BottleneckCPU     = "cpu"
+	BottleneckNetwork = "network"
+)
+
+// Parallelism is the number of goroutines working on the stages which use
+// each resource.
+type Parallelism struct {
+	// Disk is the number of goroutines listing directories and reading
+	// files.
+	Disk uint
+	// CPU is the number of goroutines chunking, hashing, compressing and
+	// encrypting data.
+	CPU uint
+	// Network is the number of concurrent uploads.
+	Network uint
+}
+
+// StageReport is the time spent in each stage of a backup and the resource
+// which limited it.
+type StageReport struct {
+	// Durations maps the names of the stages to the time spent in them.
+	Durations map[string]time.Duration
+	// Bottleneck is the resource with the highest load, one of the
+	// Bottleneck constants, or empty if nothing was recorded.
+	Bottleneck string
+}
+
+// Report returns the times recorded in st. The load of a resource is the time
+// spent in its stages divided by the number of goroutines using it, the
+// resource with the highest load is reported as the bottleneck.
+func (st *StageTimes) Report(p Parallelism) StageReport {
+	r := StageReport{Durations: make(map[string]time.Duration, numStages)}
+	for s := Stage(0); s < numStages; s++ {
+		r.Durations[s.String()] = st.Get(s)
+	}
+
+	load := func(d time.Duration, n uint) float64 {
+		if n == 0 {
+			n = 1
+		}
+		return float64(d) / float64(n)
+	}
+	loads := []struct {
+		name string
+		load float64
+	}{
+		{BottleneckDisk, load(st.Get(StageScan)+st.Get(StageRead), p.Disk)},
+		{BottleneckCPU, load(st.Get(StageChunk)+st.Get(StageHash)+st.Get(StageCompress)+st.Get(StageEncrypt), p.CPU)},
+		{BottleneckNetwork, load(st.Get(StageUpload), p.Network)},
+	}
+
+	var max float64
+	for _, l := range loads {
+		if l.load > max {
+			max = l.load
+			r.Bottleneck = l.name
+		}
+	}
+	return r
+}
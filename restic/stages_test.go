@@ -0,0 +1,94 @@
+package restic_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/restic"
+)
+
+func TestStageTimesNil(t *testing.T) {
+	var st *restic.StageTimes
+	st.Add(restic.StageRead, time.Second)
+	st.Since(restic.StageUpload, time.Now())
+	rtest.Equals(t, time.Duration(0), st.Get(restic.StageRead))
+
+	r := st.Report(restic.Parallelism{})
+	rtest.Equals(t, "", r.Bottleneck)
+	rtest.Equals(t, time.Duration(0), r.Durations["read"])
+}
+
+func TestStageTimesContext(t *testing.T) {
+	rtest.Assert(t, restic.StageTimesFromContext(context.Background()) == nil, "expected no stage times")
+
+	st := &restic.StageTimes{}
+	ctx := restic.WithStageTimes(context.Background(), st)
+	restic.StageTimesFromContext(ctx).Add(restic.StageHash, time.Millisecond)
+	rtest.Equals(t, time.Millisecond, st.Get(restic.StageHash))
+}
+
+func TestStageTimesReport(t *testing.T) {
+	var tests = []struct {
+		times      map[restic.Stage]time.Duration
+		p          restic.Parallelism
+		bottleneck string
+	}{
+		{
+			times:      map[restic.Stage]time.Duration{},
+			bottleneck: "",
+		},
+		{
+			times: map[restic.Stage]time.Duration{
+				restic.StageRead:   2 * time.Second,
+				restic.StageHash:   time.Second,
+				restic.StageUpload: 10 * time.Second,
+			},
+			p:          restic.Parallelism{Disk: 2, CPU: 4, Network: 5},
+			bottleneck: restic.BottleneckNetwork,
+		},
+		{
+			// the upload takes longest, but runs in many more goroutines
+			times: map[restic.Stage]time.Duration{
+				restic.StageScan:    time.Second,
+				restic.StageRead:    3 * time.Second,
+				restic.StageChunk:   time.Second,
+				restic.StageEncrypt: time.Second,
+				restic.StageUpload:  10 * time.Second,
+			},
+			p:          restic.Parallelism{Disk: 2, CPU: 8, Network: 10},
+			bottleneck: restic.BottleneckDisk,
+		},
+		{
+			times: map[restic.Stage]time.Duration{
+				restic.StageRead:     time.Second,
+				restic.StageChunk:    2 * time.Second,
+				restic.StageHash:     2 * time.Second,
+				restic.StageCompress: 4 * time.Second,
+			},
+			p:          restic.Parallelism{Disk: 2, CPU: 2, Network: 2},
+			bottleneck: restic.BottleneckCPU,
+		},
+	}
+
+	for _, test := range tests {
+		st := &restic.StageTimes{}
+		for s, d := range test.times {
+			st.Add(s, d)
+		}
+
+		r := st.Report(test.p)
+		rtest.Equals(t, test.bottleneck, r.Bottleneck)
+		rtest.Equals(t, 7, len(r.Durations))
+		for s, d := range test.times {
+			rtest.Equals(t, d, r.Durations[s.String()])
+		}
+	}
+}
+
+func TestStageString(t *testing.T) {
+	rtest.Equals(t, "scan", restic.StageScan.String())
+	rtest.Equals(t, "upload", restic.StageUpload.String())
+	rtest.Equals(t, "invalid", restic.Stage(100).String())
+}
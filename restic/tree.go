@@ -117,12 +117,24 @@ func LoadTree(ctx context.Context, r BlobLoader, id ID) (*Tree, error) {
 		return nil, err
 	}
 
+	return DecodeTree(buf)
+}
+
+// DecodeTree parses the JSON representation of a tree. buf may contain
+// arbitrary data, an error is returned if it is not a valid tree.
+func DecodeTree(buf []byte) (*Tree, error) {
 	t := &Tree{}
-	err = json.Unmarshal(buf, t)
+	err := json.Unmarshal(buf, t)
 	if err != nil {
 		return nil, err
 	}
 
+	for i, node := range t.Nodes {
+		if node == nil {
+			return nil, errors.Errorf("invalid tree, node %d is null", i)
+		}
+	}
+
 	return t, nil
 }
 
@@ -0,0 +1,147 @@
+package restic
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/errors"
+)
+
+// WarningKind classifies a warning.
+type WarningKind string
+
+// Kinds of warnings.
+const (
+	// WarningVanished means that a file was removed while it was processed.
+	WarningVanished WarningKind = "vanished"
+	// WarningPermission means that a file could not be accessed.
+	WarningPermission WarningKind = "permission"
+	// WarningXattr means that the extended attributes of a file could not
+	// be read.
+	WarningXattr WarningKind = "xattr"
+	// WarningOther is used for all other problems.
+	WarningOther WarningKind = "other"
+)
+
+// Warning is a problem which did not stop an operation, for example a file
+// which could not be read during a backup.
+type Warning struct {
+	// Item is the file or object concerned, it may be empty.
+	Item string
+	Kind WarningKind
+	Err  error
+}
+
+func (w Warning) String() string {
+	if w.Item == "" {
+		return w.Err.Error()
+	}
+	return fmt.Sprintf("%v: %v", w.Item, w.Err)
+}
+
+// NewWarning returns a warning for err, the kind is derived from err.
+func NewWarning(item string, err error) Warning {
+	var xattrErr *XattrError
+	kind := WarningOther
+	switch {
+	case errors.As(err, &xattrErr):
+		kind = WarningXattr
+	case errors.Is(err, os.ErrNotExist):
+		kind = WarningVanished
+	case errors.Is(err, os.ErrPermission):
+		kind = WarningPermission
+	}
+	return Warning{Item: item, Kind: kind, Err: err}
+}
+
+// XattrError is returned when the extended attributes of a file could not be
+// read. The node of the file is complete otherwise.
+type XattrError struct {
+	Path string
+	Err  error
+}
+
+func (e *XattrError) Error() string {
+	return fmt.Sprintf("unable to read extended attributes of %v: %v", e.Path, e.Err)
+}
+
+func (e *XattrError) Unwrap() error {
+	return e.Err
+}
+
+// Warnings collects the warnings of operations, so that callers can decide
+// about their severity instead of having them written to Stderr. It is safe
+// for concurrent use, warnings added to a nil Warnings are only logged.
+type Warnings struct {
+	m    sync.Mutex
+	list []Warning
+	c    chan<- Warning
+}
+
+// NewWarnings returns a collector which also sends each warning to c, if c is
+// not nil. Sending blocks the operation until c is read.
+func NewWarnings(c chan<- Warning) *Warnings {
+	return &Warnings{c: c}
+}
+
+// Add records a warning for err, it does nothing if err is nil.
+func (w *Warnings) Add(item string, err error) {
+	if err == nil {
+		return
+	}
+	debug.Log("warning for %q: %v", item, err)
+	if w == nil {
+		return
+	}
+
+	warning := NewWarning(item, err)
+	w.m.Lock()
+	w.list = append(w.list, warning)
+	w.m.Unlock()
+
+	if w.c != nil {
+		w.c <- warning
+	}
+}
+
+// List returns the warnings collected so far.
+func (w *Warnings) List() []Warning {
+	if w == nil {
+		return nil
+	}
+	w.m.Lock()
+	defer w.m.Unlock()
+	return append([]Warning(nil), w.list...)
+}
+
+// Len returns the number of warnings collected so far.
+func (w *Warnings) Len() int {
+	if w == nil {
+		return 0
+	}
+	w.m.Lock()
+	defer w.m.Unlock()
+	return len(w.list)
+}
+
+type warningsKey struct{}
+
+// WithWarnings returns a context which makes the operations called with it
+// add their warnings to w.
+func WithWarnings(ctx context.Context, w *Warnings) context.Context {
+	return context.WithValue(ctx, warningsKey{}, w)
+}
+
+// WarningsFromContext returns the Warnings of ctx, or nil if there are none.
+func WarningsFromContext(ctx context.Context) *Warnings {
+	w, _ := ctx.Value(warningsKey{}).(*Warnings)
+	return w
+}
+
+// Warn adds a warning for err to the Warnings of ctx.
+func Warn(ctx context.Context, item string, err error) {
+	WarningsFromContext(ctx).Add(item, err)
+}
@@ -0,0 +1,48 @@
+package restic_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/restic"
+)
+
+func TestNewWarning(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		kind restic.WarningKind
+	}{
+		{&os.PathError{Op: "open", Path: "foo", Err: os.ErrNotExist}, restic.WarningVanished},
+		{fmt.Errorf("lstat: %w", os.ErrPermission), restic.WarningPermission},
+		{&restic.XattrError{Path: "foo", Err: os.ErrPermission}, restic.WarningXattr},
+		{errors.New("other"), restic.WarningOther},
+	} {
+		w := restic.NewWarning("foo", test.err)
+		rtest.Equals(t, test.kind, w.Kind)
+		rtest.Equals(t, "foo", w.Item)
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	var nilWarnings *restic.Warnings
+	nilWarnings.Add("foo", errors.New("ignored"))
+	rtest.Equals(t, 0, nilWarnings.Len())
+
+	c := make(chan restic.Warning, 1)
+	w := restic.NewWarnings(c)
+	ctx := restic.WithWarnings(context.Background(), w)
+
+	restic.Warn(ctx, "foo", nil)
+	restic.Warn(ctx, "foo", os.ErrNotExist)
+	rtest.Equals(t, 1, w.Len())
+	rtest.Equals(t, restic.WarningVanished, (<-c).Kind)
+	rtest.Equals(t, "foo", w.List()[0].Item)
+
+	// without collector the warning is dropped
+	restic.Warn(context.Background(), "bar", os.ErrNotExist)
+	rtest.Equals(t, 1, w.Len())
+}
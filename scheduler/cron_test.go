@@ -0,0 +1,103 @@
+package scheduler_test
+
+import (
+	"testing"
+	"time"
+
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/scheduler"
+)
+
+func TestParse(t *testing.T) {
+	base := time.Date(2024, 2, 28, 10, 17, 30, 0, time.UTC) // a Wednesday
+	for _, test := range []struct {
+		expr string
+		next []time.Time
+	}{
+		{"*/5 * * * *", []time.Time{
+			time.Date(2024, 2, 28, 10, 20, 0, 0, time.UTC),
+			time.Date(2024, 2, 28, 10, 25, 0, 0, time.UTC),
+		}},
+		{"30 2 * * *", []time.Time{
+			time.Date(2024, 2, 29, 2, 30, 0, 0, time.UTC),
+			time.Date(2024, 3, 1, 2, 30, 0, 0, time.UTC),
+		}},
+		{"0 9-17/4 * * mon-fri", []time.Time{
+			time.Date(2024, 2, 28, 13, 0, 0, 0, time.UTC),
+			time.Date(2024, 2, 28, 17, 0, 0, 0, time.UTC),
+			time.Date(2024, 2, 29, 9, 0, 0, 0, time.UTC),
+			time.Date(2024, 2, 29, 13, 0, 0, 0, time.UTC),
+			time.Date(2024, 2, 29, 17, 0, 0, 0, time.UTC),
+			time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC),
+			time.Date(2024, 3, 1, 13, 0, 0, 0, time.UTC),
+			time.Date(2024, 3, 1, 17, 0, 0, 0, time.UTC),
+			time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC),
+		}},
+		// day of the month or day of the week
+		{"0 0 1 * 7", []time.Time{
+			time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, 3, 3, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC),
+		}},
+		{"0 0 29 feb *", []time.Time{
+			time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC),
+			time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC),
+		}},
+		{"@monthly", []time.Time{
+			time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+		}},
+		{"@every 90m", []time.Time{
+			base.Add(90 * time.Minute),
+			base.Add(180 * time.Minute),
+		}},
+		{"0 0 31 2 *", []time.Time{{}}},
+	} {
+		t.Run(test.expr, func(t *testing.T) {
+			s, err := scheduler.Parse(test.expr)
+			rtest.OK(t, err)
+			last := base
+			for _, want := range test.next {
+				last = s.Next(last)
+				rtest.Equals(t, want, last)
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"* * * * funday",
+		"@every -1h",
+		"@every soon",
+	} {
+		_, err := scheduler.Parse(expr)
+		rtest.Assert(t, err != nil, "invalid expression %q accepted", expr)
+	}
+}
+
+func TestNextDaylightSaving(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("time zone not available: %v", err)
+	}
+
+	s := scheduler.MustParse("30 2 * * *")
+	// 02:30 does not exist on the day the clock is set forward
+	next := s.Next(time.Date(2024, 3, 30, 12, 0, 0, 0, loc))
+	rtest.Equals(t, time.Date(2024, 4, 1, 2, 30, 0, 0, loc), next)
+
+	// and occurs twice on the day it is set back, but the job runs once
+	s = scheduler.MustParse("*/30 * * * *")
+	next = s.Next(time.Date(2024, 10, 27, 2, 45, 0, 0, loc).Add(-time.Hour))
+	rtest.Equals(t, time.Date(2024, 10, 27, 2, 0, 0, 0, loc), next)
+}
@@ -0,0 +1,344 @@
+// Package scheduler runs jobs such as backups, forget and prune at the times
+// given by cron expressions. A random delay can be added to spread the load
+// of many hosts, runs which were missed while the host was asleep or the
+// program was not running can be caught up, and jobs are not started while
+// a previous run is still running or the repository is locked by a
+// conflicting operation.
+package scheduler
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/restic"
+)
+
+var (
+	// ErrRunning is reported for runs which were skipped because the
+	// previous run of the job was still running.
+	ErrRunning = errors.New("previous run is still running")
+
+	// ErrLocked is reported for runs which were skipped because the
+	// repository was locked by a conflicting operation.
+	ErrLocked = errors.New("repository is locked")
+
+	// ErrMissed is reported for runs which were missed, for example while
+	// the host was asleep, and are not caught up.
+	ErrMissed = errors.New("run was missed")
+)
+
+// Job is run by the scheduler.
+type Job struct {
+	// Name identifies the job, it must be unique.
+	Name string
+
+	Schedule Schedule
+
+	// Run is called for each run of the job, e.g. with a closure calling
+	// rapi.Backup, Forget or Prune. The context is cancelled when the
+	// scheduler is stopped.
+	Run func(ctx context.Context) error
+
+	// Jitter is the maximum random delay which is added to each run.
+	Jitter time.Duration
+
+	// CatchUp runs the job once if runs were missed, instead of waiting for
+	// the next scheduled run. Runs which were skipped because the repository
+	// was locked are retried until they can be started.
+	CatchUp bool
+
+	// LastRun is the time of the last run of the job, e.g. from a previous
+	// execution of the program. If it is set, the first run is scheduled
+	// after it, so that runs missed in between are detected.
+	LastRun time.Time
+
+	// Repository is checked for locks before each run, may be nil. A job is
+	// not started while the repository is locked exclusively, e.g. by a
+	// prune of another host. If Exclusive is set, the job is not started
+	// while the repository is locked at all, e.g. by a running backup.
+	Repository restic.Repository
+	Exclusive  bool
+}
+
+// Run describes a run of a job.
+type Run struct {
+	Job string
+
+	// Scheduled is the time at which the job was scheduled to run, without
+	// the jitter.
+	Scheduled time.Time
+
+	// Start and End are the times the run took, they are zero if the run
+	// was skipped.
+	Start time.Time
+	End   time.Time
+
+	// Skipped is set if the run was not started, the reason is in Err.
+	Skipped bool
+	Err     error
+}
+
+// Options configure a Scheduler.
+type Options struct {
+	// OnRun is called after each run of a job and for each skipped run, may
+	// be nil. It may be called concurrently.
+	OnRun func(Run)
+
+	// Interval is the time between checks for due jobs, it defaults to 30
+	// seconds. Runs are late by up to the interval.
+	Interval time.Duration
+
+	// MissedAfter is the time after which a run which has not started is
+	// considered missed, it defaults to twice the interval plus a minute.
+	MissedAfter time.Duration
+
+	// Clock returns the current time, it defaults to restic.SystemClock.
+	// The wall clock is used, so that time spent asleep is noticed.
+	Clock restic.Clock
+}
+
+// Scheduler runs jobs. It is safe for concurrent use.
+type Scheduler struct {
+	opts Options
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	wg      sync.WaitGroup
+}
+
+type entry struct {
+	job Job
+
+	// scheduled is the next scheduled time, due includes the jitter
+	scheduled time.Time
+	due       time.Time
+	running   bool
+}
+
+// New returns a scheduler without jobs.
+func New(opts Options) *Scheduler {
+	if opts.Interval <= 0 {
+		opts.Interval = 30 * time.Second
+	}
+	if opts.MissedAfter <= 0 {
+		opts.MissedAfter = 2*opts.Interval + time.Minute
+	}
+	if opts.Clock == nil {
+		opts.Clock = restic.SystemClock
+	}
+
+	return &Scheduler{
+		opts:    opts,
+		entries: make(map[string]*entry),
+	}
+}
+
+func (s *Scheduler) now() time.Time {
+	// strip the monotonic clock reading, which stops while the host sleeps
+	return s.opts.Clock.Now().Round(0)
+}
+
+// Add adds job to the scheduler.
+func (s *Scheduler) Add(job Job) error {
+	if job.Name == "" {
+		return errors.New("job has no name")
+	}
+	if job.Schedule == nil || job.Run == nil {
+		return errors.Errorf("job %v has no schedule or function", job.Name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[job.Name]; ok {
+		return errors.Errorf("job %v already exists", job.Name)
+	}
+
+	e := &entry{job: job}
+	from := job.LastRun
+	if from.IsZero() {
+		from = s.now()
+	}
+	s.schedule(e, from)
+	if e.scheduled.IsZero() {
+		return errors.Errorf("job %v never runs", job.Name)
+	}
+	s.entries[job.Name] = e
+	return nil
+}
+
+// Remove removes the job name, a running job is not cancelled.
+func (s *Scheduler) Remove(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, name)
+}
+
+// Entry is a job and the time of its next run.
+type Entry struct {
+	Job     string
+	Next    time.Time
+	Running bool
+}
+
+// Entries returns the jobs ordered by the time of their next run.
+func (s *Scheduler) Entries() []Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := make([]Entry, 0, len(s.entries))
+	for _, e := range s.entries {
+		entries = append(entries, Entry{Job: e.job.Name, Next: e.due, Running: e.running})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Next.Equal(entries[j].Next) {
+			return entries[i].Job < entries[j].Job
+		}
+		return entries[i].Next.Before(entries[j].Next)
+	})
+	return entries
+}
+
+// schedule sets the next run of e after t, s.mu must be held.
+func (s *Scheduler) schedule(e *entry, t time.Time) {
+	e.scheduled = e.job.Schedule.Next(t)
+	e.due = e.scheduled
+	if e.job.Jitter > 0 && !e.scheduled.IsZero() {
+		e.due = e.due.Add(time.Duration(rand.Int63n(int64(e.job.Jitter))))
+	}
+}
+
+// Run runs the jobs until ctx is cancelled, then it waits for the running
+// jobs to return and returns ctx.Err().
+func (s *Scheduler) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.opts.Interval)
+	defer ticker.Stop()
+	defer s.wg.Wait()
+
+	for {
+		s.check(ctx)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// check starts the jobs which are due.
+func (s *Scheduler) check(ctx context.Context) {
+	now := s.now()
+	var skipped []Run
+	defer func() {
+		for _, run := range skipped {
+			s.report(run)
+		}
+	}()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range s.entries {
+		if e.due.IsZero() || e.due.After(now) {
+			continue
+		}
+
+		run := Run{Job: e.job.Name, Scheduled: e.scheduled}
+		missed := now.Sub(e.due) > s.opts.MissedAfter
+		if missed && !e.job.CatchUp {
+			debug.Log("job %v missed its run at %v", e.job.Name, e.scheduled)
+			skipped = append(skipped, s.skip(e, run, ErrMissed, now))
+			continue
+		}
+
+		if e.running {
+			if !e.job.CatchUp {
+				skipped = append(skipped, s.skip(e, run, ErrRunning, now))
+			}
+			// otherwise the job is started once the previous run returned
+			continue
+		}
+
+		// the next run is scheduled after the current time, so that runs
+		// missed in between are caught up only once
+		s.schedule(e, now)
+		e.running = true
+		s.wg.Add(1)
+		go s.run(ctx, e, run)
+	}
+}
+
+// skip schedules the next run of e and returns run marked as skipped, s.mu
+// must be held.
+func (s *Scheduler) skip(e *entry, run Run, err error, now time.Time) Run {
+	s.schedule(e, now)
+	run.Skipped = true
+	run.Err = err
+	return run
+}
+
+func (s *Scheduler) report(run Run) {
+	if s.opts.OnRun != nil {
+		s.opts.OnRun(run)
+	}
+}
+
+// run runs the job of e.
+func (s *Scheduler) run(ctx context.Context, e *entry, run Run) {
+	defer s.wg.Done()
+
+	locked, err := s.locked(ctx, e.job)
+	if err == nil && locked {
+		err = ErrLocked
+	}
+
+	if err != nil {
+		debug.Log("job %v not started: %v", e.job.Name, err)
+		run.Skipped = true
+		run.Err = err
+	} else {
+		debug.Log("starting job %v", e.job.Name)
+		run.Start = s.now()
+		run.Err = e.job.Run(ctx)
+		run.End = s.now()
+		debug.Log("job %v returned %v", e.job.Name, run.Err)
+	}
+
+	s.mu.Lock()
+	e.running = false
+	if run.Skipped && e.job.CatchUp && ctx.Err() == nil {
+		// try again with the next check
+		e.scheduled = run.Scheduled
+		e.due = s.now()
+	}
+	s.mu.Unlock()
+
+	s.report(run)
+}
+
+// locked returns true if the repository of job is locked by a conflicting
+// operation.
+func (s *Scheduler) locked(ctx context.Context, job Job) (bool, error) {
+	if job.Repository == nil {
+		return false, nil
+	}
+
+	now := s.now()
+	locked := false
+	err := restic.ForAllLocks(ctx, job.Repository, nil, func(_ restic.ID, lock *restic.Lock, err error) error {
+		if err != nil {
+			// invalid locks are ignored like by the locking code
+			return nil
+		}
+		if !lock.StaleAt(now) && (job.Exclusive || lock.Exclusive) {
+			locked = true
+		}
+		return nil
+	})
+	return locked, err
+}
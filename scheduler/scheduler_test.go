@@ -0,0 +1,223 @@
+package scheduler_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/restic"
+	"github.com/konidev20/rapi/scheduler"
+)
+
+// testClock is a clock which is advanced by the test.
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// startScheduler runs a scheduler with the clock and returns the channel
+// of the reported runs.
+func startScheduler(t *testing.T, clock *testClock, jobs ...scheduler.Job) (*scheduler.Scheduler, <-chan scheduler.Run) {
+	runs := make(chan scheduler.Run, 10)
+	s := scheduler.New(scheduler.Options{
+		OnRun:       func(run scheduler.Run) { runs <- run },
+		Interval:    time.Millisecond,
+		MissedAfter: 10 * time.Minute,
+		Clock:       clock,
+	})
+	for _, job := range jobs {
+		rtest.OK(t, s.Add(job))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- s.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		rtest.Equals(t, context.Canceled, <-done)
+	})
+	return s, runs
+}
+
+func nextRun(t *testing.T, runs <-chan scheduler.Run) scheduler.Run {
+	select {
+	case run := <-runs:
+		return run
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for a run")
+		return scheduler.Run{}
+	}
+}
+
+func noRun(t *testing.T, runs <-chan scheduler.Run) {
+	select {
+	case run := <-runs:
+		t.Fatalf("unexpected run %+v", run)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	clock := &testClock{now: time.Date(2024, 3, 1, 10, 2, 30, 0, time.UTC)}
+	var calls int
+	s, runs := startScheduler(t, clock, scheduler.Job{
+		Name:     "backup",
+		Schedule: scheduler.MustParse("*/5 * * * *"),
+		Run: func(context.Context) error {
+			calls++
+			return nil
+		},
+	})
+
+	entries := s.Entries()
+	rtest.Equals(t, 1, len(entries))
+	rtest.Equals(t, time.Date(2024, 3, 1, 10, 5, 0, 0, time.UTC), entries[0].Next)
+	noRun(t, runs)
+
+	clock.Advance(3 * time.Minute)
+	run := nextRun(t, runs)
+	rtest.Equals(t, "backup", run.Job)
+	rtest.Equals(t, time.Date(2024, 3, 1, 10, 5, 0, 0, time.UTC), run.Scheduled)
+	rtest.Assert(t, !run.Skipped && run.Err == nil, "unexpected run %+v", run)
+	rtest.Equals(t, clock.Now(), run.Start)
+	rtest.Equals(t, 1, calls)
+	noRun(t, runs)
+	rtest.Equals(t, time.Date(2024, 3, 1, 10, 10, 0, 0, time.UTC), s.Entries()[0].Next)
+
+	s.Remove("backup")
+	rtest.Equals(t, 0, len(s.Entries()))
+	clock.Advance(time.Hour)
+	noRun(t, runs)
+}
+
+func TestSchedulerMissed(t *testing.T) {
+	clock := &testClock{now: time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)}
+	last := time.Date(2024, 2, 27, 23, 0, 0, 0, time.UTC)
+	called := make(chan string, 10)
+	job := func(name string, catchUp bool) scheduler.Job {
+		return scheduler.Job{
+			Name:     name,
+			Schedule: scheduler.MustParse("@daily"),
+			Run: func(context.Context) error {
+				called <- name
+				return nil
+			},
+			CatchUp: catchUp,
+			LastRun: last,
+		}
+	}
+	s, runs := startScheduler(t, clock, job("skip", false), job("catch-up", true))
+
+	// both jobs missed two runs, only one is caught up and only once
+	results := make(map[string]scheduler.Run)
+	for i := 0; i < 2; i++ {
+		run := nextRun(t, runs)
+		results[run.Job] = run
+	}
+	noRun(t, runs)
+	rtest.Equals(t, "catch-up", <-called)
+	rtest.Equals(t, 0, len(called))
+
+	run := results["skip"]
+	rtest.Assert(t, run.Skipped && errors.Is(run.Err, scheduler.ErrMissed), "unexpected run %+v", run)
+	run = results["catch-up"]
+	rtest.Assert(t, !run.Skipped && run.Err == nil, "unexpected run %+v", run)
+	rtest.Equals(t, time.Date(2024, 2, 28, 0, 0, 0, 0, time.UTC), run.Scheduled)
+
+	for _, e := range s.Entries() {
+		rtest.Equals(t, time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC), e.Next)
+	}
+}
+
+func TestSchedulerOverlap(t *testing.T) {
+	clock := &testClock{now: time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s, runs := startScheduler(t, clock, scheduler.Job{
+		Name:     "prune",
+		Schedule: scheduler.Every(time.Minute),
+		Run: func(context.Context) error {
+			started <- struct{}{}
+			<-release
+			return nil
+		},
+	})
+
+	clock.Advance(time.Minute)
+	<-started
+	rtest.Assert(t, s.Entries()[0].Running, "job is not running")
+
+	clock.Advance(time.Minute)
+	run := nextRun(t, runs)
+	rtest.Assert(t, run.Skipped && errors.Is(run.Err, scheduler.ErrRunning), "unexpected run %+v", run)
+
+	close(release)
+	run = nextRun(t, runs)
+	rtest.Assert(t, !run.Skipped && run.Err == nil, "unexpected run %+v", run)
+}
+
+func TestSchedulerLocked(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.TestRepository(t)
+	lock, err := restic.NewExclusiveLock(ctx, repo)
+	rtest.OK(t, err)
+
+	// the lock must not be stale at the time of the test clock
+	clock := &testClock{now: time.Now()}
+	var calls int
+	_, runs := startScheduler(t, clock, scheduler.Job{
+		Name:     "backup",
+		Schedule: scheduler.Every(time.Minute),
+		Run: func(context.Context) error {
+			calls++
+			return nil
+		},
+		CatchUp:    true,
+		Repository: repo,
+	})
+
+	clock.Advance(time.Minute)
+	run := nextRun(t, runs)
+	rtest.Assert(t, run.Skipped && errors.Is(run.Err, scheduler.ErrLocked), "unexpected run %+v", run)
+	rtest.Equals(t, 0, calls)
+
+	// the run is retried once the lock is released
+	rtest.OK(t, lock.Unlock())
+	for {
+		run = nextRun(t, runs)
+		if !run.Skipped {
+			break
+		}
+	}
+	rtest.Assert(t, run.Err == nil, "unexpected run %+v", run)
+	rtest.Equals(t, 1, calls)
+}
+
+func TestSchedulerAdd(t *testing.T) {
+	s := scheduler.New(scheduler.Options{})
+	run := func(context.Context) error { return nil }
+
+	rtest.OK(t, s.Add(scheduler.Job{Name: "a", Schedule: scheduler.Every(time.Hour), Run: run}))
+	rtest.Assert(t, s.Add(scheduler.Job{Name: "a", Schedule: scheduler.Every(time.Hour), Run: run}) != nil, "duplicate job accepted")
+	rtest.Assert(t, s.Add(scheduler.Job{Schedule: scheduler.Every(time.Hour), Run: run}) != nil, "job without name accepted")
+	rtest.Assert(t, s.Add(scheduler.Job{Name: "b", Run: run}) != nil, "job without schedule accepted")
+	rtest.Assert(t, s.Add(scheduler.Job{Name: "c", Schedule: scheduler.MustParse("0 0 30 2 *"), Run: run}) != nil, "job which never runs accepted")
+}
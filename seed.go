@@ -0,0 +1,193 @@
+package rapi
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/backend/local"
+	"github.com/konidev20/rapi/backend/sema"
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/repository"
+)
+
+// A seed is a repository in a local directory which receives the initial
+// backup of a large data set. The directory is then transferred to the
+// location of the final repository by other means, for example by shipping a
+// disk to the storage provider, and RebaseSeed completes the transfer. The
+// local directory uses the default layout, which is also used by all remote
+// backends, so the files can be copied as is.
+
+// InitSeed creates a new repository with the given version in the local
+// directory dir, using opts.Password and the pack size and compression from
+// opts. The returned repository can be used for backups like any other.
+func InitSeed(ctx context.Context, dir string, version uint, opts RepositoryOptions) (*repository.Repository, error) {
+	cfg := local.NewConfig()
+	cfg.Path = dir
+
+	be, err := local.Create(ctx, cfg)
+	if err != nil {
+		return nil, errors.Fatalf("create seed repository at %s failed: %v", dir, err)
+	}
+
+	repo, err := repository.New(sema.NewBackend(be), repository.Options{
+		Compression: opts.Compression,
+		PackSize:    opts.PackSize * 1024 * 1024,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := repo.Init(ctx, version, opts.Password, nil); err != nil {
+		return nil, errors.Fatalf("create seed repository at %s failed: %v", dir, err)
+	}
+
+	debug.Log("created seed repository %v at %v", repo.Config().ID, dir)
+	return repo, nil
+}
+
+// RebaseSeedResult reports what RebaseSeed transferred.
+type RebaseSeedResult struct {
+	// Files is the number of files in the seed repository.
+	Files int `json:"files"`
+	// Uploaded is the number of files which were missing at the target or
+	// had the wrong size, and were uploaded.
+	Uploaded      int    `json:"uploaded"`
+	UploadedBytes uint64 `json:"uploaded_bytes"`
+}
+
+// rebaseSeedTypes is the order in which RebaseSeed transfers files. The config
+// file is written last, so that the target can only be opened once all other
+// files are present.
+var rebaseSeedTypes = []backend.FileType{
+	backend.KeyFile, backend.PackFile, backend.IndexFile, backend.SnapshotFile, backend.ConfigFile,
+}
+
+// RebaseSeed makes the repository at opts.Repo a full copy of the seed
+// repository in dir, so that the backups can continue there. Files from the
+// seed which were already transferred to the target by other means are
+// checked by their size, missing files are uploaded. The target must either
+// be empty or already contain the config of the seed, otherwise
+// ErrSeedMismatch is returned. Afterwards the target can be opened using
+// OpenRepository and has the same ID as the seed.
+func RebaseSeed(ctx context.Context, dir string, opts RepositoryOptions) (RebaseSeedResult, error) {
+	var result RebaseSeedResult
+
+	cfg := local.NewConfig()
+	cfg.Path = dir
+	lbe, err := local.Open(ctx, cfg)
+	if err != nil {
+		return result, err
+	}
+	seed := sema.NewBackend(lbe)
+
+	seedConfig, err := loadRawFile(ctx, seed, backend.Handle{Type: backend.ConfigFile})
+	if err != nil {
+		return result, errors.Fatalf("unable to read config of seed repository at %v: %v", dir, err)
+	}
+
+	opts, err = opts.applyProfile()
+	if err != nil {
+		return result, err
+	}
+	s, err := ReadRepo(opts)
+	if err != nil {
+		return result, err
+	}
+
+	target, err := innerOpen(ctx, s, opts, opts.Extended, false)
+	if err != nil {
+		return result, err
+	}
+
+	targetConfig, err := loadRawFile(ctx, target, backend.Handle{Type: backend.ConfigFile})
+	switch {
+	case err != nil && target.IsNotExist(err):
+		// the target does not exist yet or is empty
+		_ = target.Close()
+		target, err = innerOpen(ctx, s, opts, opts.Extended, true)
+		if err != nil {
+			return result, err
+		}
+	case err != nil:
+		_ = target.Close()
+		return result, err
+	case !bytes.Equal(seedConfig, targetConfig):
+		_ = target.Close()
+		return result, ErrSeedMismatch
+	}
+	defer func() {
+		_ = target.Close()
+	}()
+
+	transfer := func(h backend.Handle, size int64) error {
+		result.Files++
+
+		tfi, err := target.Stat(ctx, h)
+		if err == nil && tfi.Size == size {
+			return nil
+		}
+		if err != nil && !target.IsNotExist(err) {
+			return err
+		}
+
+		debug.Log("uploading %v (%d bytes)", h, size)
+		buf, err := loadRawFile(ctx, seed, h)
+		if err != nil {
+			return err
+		}
+		if tfi.Size != 0 {
+			// remove the incomplete copy first, not all backends replace files
+			if err := target.Remove(ctx, h); err != nil {
+				return err
+			}
+		}
+		if err := target.Save(ctx, h, backend.NewByteReader(buf, target.Hasher())); err != nil {
+			return err
+		}
+
+		result.Uploaded++
+		result.UploadedBytes += uint64(len(buf))
+		return nil
+	}
+
+	for _, t := range rebaseSeedTypes {
+		if t == backend.ConfigFile {
+			// the config is a single file, listing it is not supported by
+			// all backends
+			h := backend.Handle{Type: t}
+			fi, err := seed.Stat(ctx, h)
+			if err != nil {
+				return result, err
+			}
+			if err := transfer(h, fi.Size); err != nil {
+				return result, err
+			}
+			continue
+		}
+
+		err := seed.List(ctx, t, func(fi backend.FileInfo) error {
+			return transfer(backend.Handle{Type: t, Name: fi.Name}, fi.Size)
+		})
+		if err != nil {
+			return result, err
+		}
+	}
+
+	debug.Log("rebased seed %v: %d files, %d uploaded", dir, result.Files, result.Uploaded)
+	return result, nil
+}
+
+// ErrSeedMismatch is returned by RebaseSeed if the target contains a different
+// repository.
+var ErrSeedMismatch = errors.New("target repository was not created from this seed")
+
+func loadRawFile(ctx context.Context, be backend.Backend, h backend.Handle) (buf []byte, err error) {
+	err = be.Load(ctx, h, 0, 0, func(rd io.Reader) error {
+		buf, err = io.ReadAll(rd)
+		return err
+	})
+	return buf, err
+}
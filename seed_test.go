@@ -0,0 +1,84 @@
+package rapi_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/konidev20/rapi"
+	"github.com/konidev20/rapi/internal/archiver"
+	"github.com/konidev20/rapi/internal/fs"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/restic"
+)
+
+func testBackup(t *testing.T, repo restic.Repository, dir string, parent *restic.Snapshot) *restic.Snapshot {
+	arch := archiver.New(repo, fs.Track{FS: fs.Local{}}, archiver.Options{})
+	sn, _, err := arch.Snapshot(context.TODO(), []string{dir}, archiver.SnapshotOptions{
+		Hostname:       "test",
+		Time:           time.Now(),
+		ParentSnapshot: parent,
+	})
+	rtest.OK(t, err)
+	return sn
+}
+
+func TestSeed(t *testing.T) {
+	ctx := context.Background()
+	repository.TestUseLowSecurityKDFParameters(t)
+	restic.TestDisableCheckPolynomial(t)
+
+	tempdir := rtest.TempDir(t)
+	src := filepath.Join(tempdir, "src")
+	rtest.OK(t, os.MkdirAll(src, 0700))
+	rtest.OK(t, os.WriteFile(filepath.Join(src, "a"), rtest.Random(1, 100000), 0600))
+	rtest.OK(t, os.WriteFile(filepath.Join(src, "b"), rtest.Random(2, 1000), 0600))
+
+	opts := rapi.DefaultOptions
+	opts.Repo = filepath.Join(tempdir, "target")
+	opts.Password = rtest.TestPassword
+	opts.NoCache = true
+
+	seedDir := filepath.Join(tempdir, "seed")
+	seed, err := rapi.InitSeed(ctx, seedDir, restic.StableRepoVersion, opts)
+	rtest.OK(t, err)
+	first := testBackup(t, seed, src, nil)
+
+	res, err := rapi.RebaseSeed(ctx, seedDir, opts)
+	rtest.OK(t, err)
+	rtest.Assert(t, res.Files > 0, "no files in seed")
+	rtest.Equals(t, res.Files, res.Uploaded)
+
+	// a second run finds all files at the target
+	res, err = rapi.RebaseSeed(ctx, seedDir, opts)
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, res.Uploaded)
+
+	repo, err := rapi.OpenRepository(ctx, opts)
+	rtest.OK(t, err)
+	rtest.Equals(t, seed.Config().ID, repo.Config().ID)
+	rtest.OK(t, repo.LoadIndex(ctx, nil))
+
+	// an incremental backup reuses the blobs from the seed
+	rtest.OK(t, os.WriteFile(filepath.Join(src, "c"), rtest.Random(3, 1000), 0600))
+	second := testBackup(t, repo, src, first)
+	rtest.Equals(t, first.ID(), second.Parent)
+
+	snapshots, err := restic.TestLoadAllSnapshots(ctx, repo, nil)
+	rtest.OK(t, err)
+	rtest.Equals(t, 2, len(snapshots))
+
+	res2, err := rapi.Check(ctx, repo, rapi.CheckOptions{})
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, len(res2.Errors))
+
+	// a different repository at the target is rejected
+	other, err := rapi.InitSeed(ctx, filepath.Join(tempdir, "other"), restic.StableRepoVersion, opts)
+	rtest.OK(t, err)
+	_, err = rapi.RebaseSeed(ctx, filepath.Join(tempdir, "other"), opts)
+	rtest.Equals(t, rapi.ErrSeedMismatch, err)
+	rtest.OK(t, other.Close())
+}
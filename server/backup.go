@@ -0,0 +1,230 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/konidev20/rapi"
+	"github.com/konidev20/rapi/internal/archiver"
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/internal/fs"
+	"github.com/konidev20/rapi/restic"
+	"github.com/konidev20/rapi/tuning"
+	"github.com/konidev20/rapi/ui/backup"
+	"github.com/konidev20/rapi/ui/jsonout"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Backup saves the paths of the local filesystem in a new snapshot and
+// sends progress messages while the backup is running. Errors reading
+// single files are sent as messages, the backup continues.
+func (s *Server) Backup(req *BackupRequest, stream Stream[BackupMessage]) error {
+	ctx := stream.Context()
+	repo, err := s.lookup(req.Handle)
+	if err != nil {
+		return err
+	}
+	if len(req.Paths) == 0 {
+		return status.Error(codes.InvalidArgument, "no paths given")
+	}
+
+	unlock, err := rapi.LockRepository(ctx, repo, false)
+	if err != nil {
+		return statusError(err)
+	}
+	defer unlock()
+
+	if err := repo.LoadIndex(ctx, nil); err != nil {
+		return statusError(err)
+	}
+
+	hostname := req.Hostname
+	if hostname == "" {
+		hostname, err = os.Hostname()
+		if err != nil {
+			return statusError(errors.Wrap(err, "Hostname"))
+		}
+	}
+	parent, err := findParent(ctx, repo, req, hostname)
+	if err != nil {
+		return statusError(err)
+	}
+
+	// the statistics of earlier backups of the same paths give an estimate
+	// of the remaining time before the scanner is done
+	historyKey, err := backupHistoryKey(hostname, req.Paths)
+	if err != nil {
+		return statusError(err)
+	}
+	history := backup.LoadHistory(repo.Cache, historyKey)
+
+	printer := &backupPrinter{stream: stream}
+	progress := backup.NewProgress(printer, s.opts.ProgressInterval)
+	progress.UseHistory(history)
+
+	arch := archiver.New(repo, fs.Local{}, s.archiverOptions())
+	arch.Error = progress.Error
+	arch.CompleteItem = progress.CompleteItem
+	arch.StartFile = progress.StartFile
+	arch.CompleteBlob = progress.CompleteBlob
+	arch.Stages = &restic.StageTimes{}
+
+	scanner := archiver.NewScanner(fs.Local{})
+	scanner.Result = progress.ReportTotal
+	scanner.Error = printer.ScannerError
+
+	// the scanner only computes the totals for the progress, it is
+	// cancelled once the backup is complete
+	scanCtx, cancelScan := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := scanner.Scan(scanCtx, req.Paths); err != nil {
+			debug.Log("scan failed: %v", err)
+		}
+	}()
+
+	_, id, err := arch.Snapshot(ctx, req.Paths, archiver.SnapshotOptions{
+		Tags:           req.Tags,
+		Hostname:       hostname,
+		Time:           restic.Now(ctx),
+		ParentSnapshot: parent,
+	})
+	cancelScan()
+	wg.Wait()
+	if err != nil {
+		progress.Done()
+		return statusError(err)
+	}
+
+	progress.SetStageReport(arch.StageReport())
+	progress.Finish(id, false)
+
+	if repo.Cache != nil {
+		history.Add(progress.Run())
+		if err := history.Save(repo.Cache, historyKey); err != nil {
+			debug.Log("unable to save backup history: %v", err)
+		}
+	}
+	return printer.err()
+}
+
+// backupHistoryKey returns the key of the backup history for paths on
+// hostname.
+func backupHistoryKey(hostname string, paths []string) (string, error) {
+	abs := make([]string, 0, len(paths))
+	for _, p := range paths {
+		a, err := filepath.Abs(p)
+		if err != nil {
+			return "", errors.Wrap(err, "Abs")
+		}
+		abs = append(abs, a)
+	}
+	return backup.HistoryKey(hostname, abs), nil
+}
+
+// archiverOptions returns the worker counts of the archiver, they are tuned
+// for this machine if AutoTune is set in the repository options.
+func (s *Server) archiverOptions() archiver.Options {
+	if !s.opts.Repository.AutoTune {
+		return archiver.Options{}
+	}
+
+	// the worker counts of the archiver do not depend on the backend
+	p := tuning.Select(tuning.Detect(), tuning.BackendLocal)
+	return archiver.Options{
+		ReadConcurrency:     p.ReadConcurrency,
+		SaveBlobConcurrency: p.SaveBlobConcurrency,
+		SaveTreeConcurrency: p.SaveTreeConcurrency,
+		ScanConcurrency:     p.ScanConcurrency,
+	}
+}
+
+// findParent returns the parent snapshot requested by req, or the latest
+// snapshot of hostname with the same paths. It returns nil if there is no
+// such snapshot.
+func findParent(ctx context.Context, repo restic.Repository, req *BackupRequest, hostname string) (*restic.Snapshot, error) {
+	if req.Parent != "" {
+		sn, _, err := restic.FindSnapshot(ctx, repo, repo, req.Parent)
+		return sn, err
+	}
+
+	filter := restic.SnapshotFilter{Hosts: []string{hostname}}
+	for _, p := range req.Paths {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return nil, errors.Wrap(err, "Abs")
+		}
+		filter.Paths = append(filter.Paths, abs)
+	}
+	sn, _, err := filter.FindLatest(ctx, repo, repo, "latest")
+	if errors.Is(err, restic.ErrNoSnapshotFound) {
+		return nil, nil
+	}
+	return sn, err
+}
+
+// backupPrinter sends the progress of a backup to a stream. The first error
+// sending a message is kept, later messages are dropped.
+type backupPrinter struct {
+	m       sync.Mutex
+	stream  Stream[BackupMessage]
+	sendErr error
+}
+
+var _ backup.ProgressPrinter = &backupPrinter{}
+
+func (p *backupPrinter) send(msg *BackupMessage) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	if p.sendErr != nil {
+		return
+	}
+	p.sendErr = p.stream.Send(msg)
+}
+
+func (p *backupPrinter) err() error {
+	p.m.Lock()
+	defer p.m.Unlock()
+	return p.sendErr
+}
+
+func (p *backupPrinter) Update(total, processed backup.Counter, errors uint, currentFiles map[string]struct{}, start time.Time, secs uint64) {
+	status := backup.NewJSONStatus(total, processed, errors, currentFiles, start, secs)
+	p.send(&BackupMessage{Status: &status})
+}
+
+func (p *backupPrinter) error(during, item string, err error) error {
+	p.send(&BackupMessage{Error: &jsonout.ErrorUpdate{
+		MessageType: jsonout.MessageError,
+		Error:       jsonout.NewErrorObject(err),
+		During:      during,
+		Item:        item,
+	}})
+	return nil
+}
+
+func (p *backupPrinter) Error(item string, err error) error {
+	return p.error("archival", item, err)
+}
+
+func (p *backupPrinter) ScannerError(item string, err error) error {
+	return p.error("scan", item, err)
+}
+
+func (p *backupPrinter) Finish(snapshotID restic.ID, start time.Time, summary *backup.Summary, dryRun bool) {
+	s := backup.NewJSONSummary(snapshotID, start, summary, dryRun)
+	p.send(&BackupMessage{Summary: &s})
+}
+
+func (p *backupPrinter) CompleteItem(string, string, archiver.ItemStats, time.Duration) {}
+func (p *backupPrinter) ReportTotal(time.Time, archiver.ScanStats)                      {}
+func (p *backupPrinter) Reset()                                                         {}
+func (p *backupPrinter) P(string, ...interface{})                                       {}
+func (p *backupPrinter) V(string, ...interface{})                                       {}
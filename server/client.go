@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"io"
+
+	"google.golang.org/grpc"
+)
+
+// Client calls the service on a gRPC connection. The requests are encoded
+// with Codec, using the content subtype json.
+type Client struct {
+	cc grpc.ClientConnInterface
+}
+
+// NewClient returns a client which uses the connection cc.
+func NewClient(cc grpc.ClientConnInterface) *Client {
+	return &Client{cc: cc}
+}
+
+func invoke[Resp any](ctx context.Context, c *Client, method string, req interface{}) (*Resp, error) {
+	resp := new(Resp)
+	err := c.cc.Invoke(ctx, "/"+ServiceName+"/"+method, req, resp, grpc.CallContentSubtype(Codec{}.Name()))
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// receive calls the streaming method and calls fn for each message.
+func receive[Msg any](ctx context.Context, c *Client, desc *grpc.StreamDesc, req interface{}, fn func(*Msg) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := c.cc.NewStream(ctx, desc, "/"+ServiceName+"/"+desc.StreamName, grpc.CallContentSubtype(Codec{}.Name()))
+	if err != nil {
+		return err
+	}
+	if err := stream.SendMsg(req); err != nil {
+		return err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+
+	for {
+		msg := new(Msg)
+		err := stream.RecvMsg(msg)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(msg); err != nil {
+			return err
+		}
+	}
+}
+
+// Open opens a repository.
+func (c *Client) Open(ctx context.Context, req *OpenRequest) (*OpenResponse, error) {
+	return invoke[OpenResponse](ctx, c, "Open", req)
+}
+
+// Close closes a repository.
+func (c *Client) Close(ctx context.Context, req *CloseRequest) (*CloseResponse, error) {
+	return invoke[CloseResponse](ctx, c, "Close", req)
+}
+
+// Snapshots lists the snapshots of a repository.
+func (c *Client) Snapshots(ctx context.Context, req *SnapshotsRequest) (*SnapshotsResponse, error) {
+	return invoke[SnapshotsResponse](ctx, c, "Snapshots", req)
+}
+
+// Forget removes the snapshots which are not kept by the policy.
+func (c *Client) Forget(ctx context.Context, req *ForgetRequest) (*ForgetResponse, error) {
+	return invoke[ForgetResponse](ctx, c, "Forget", req)
+}
+
+// Prune removes unused data from a repository.
+func (c *Client) Prune(ctx context.Context, req *PruneRequest) (*PruneResponse, error) {
+	return invoke[PruneResponse](ctx, c, "Prune", req)
+}
+
+// Backup runs a backup and calls fn for each progress message. If fn
+// returns an error, the backup is cancelled.
+func (c *Client) Backup(ctx context.Context, req *BackupRequest, fn func(*BackupMessage) error) error {
+	return receive(ctx, c, &ServiceDesc.Streams[0], req, fn)
+}
+
+// Restore restores a snapshot and calls fn for each progress message. If fn
+// returns an error, the restore is cancelled.
+func (c *Client) Restore(ctx context.Context, req *RestoreRequest, fn func(*RestoreMessage) error) error {
+	return receive(ctx, c, &ServiceDesc.Streams[1], req, fn)
+}
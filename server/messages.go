@@ -0,0 +1,202 @@
+package server
+
+import (
+	"strings"
+	"time"
+
+	"github.com/konidev20/rapi"
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/restic"
+	"github.com/konidev20/rapi/ui/jsonout"
+)
+
+// OpenRequest opens the repository at Repo. Options are extended options
+// like "s3.connections=10".
+type OpenRequest struct {
+	Repo     string   `json:"repo"`
+	Password string   `json:"password"`
+	Options  []string `json:"options,omitempty"`
+	ReadOnly bool     `json:"read_only,omitempty"`
+}
+
+// OpenResponse returns the handle which identifies the opened repository in
+// later requests.
+type OpenResponse struct {
+	Handle string `json:"handle"`
+	ID     string `json:"id"`
+}
+
+// CloseRequest closes the repository opened with Handle.
+type CloseRequest struct {
+	Handle string `json:"handle"`
+}
+
+// CloseResponse is empty.
+type CloseResponse struct{}
+
+// SnapshotFilter selects snapshots like the --host, --tag and --path
+// options of restic. Each entry of Tags is a comma-separated list of tags
+// which must all be present.
+type SnapshotFilter struct {
+	Hosts []string `json:"hosts,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+	Paths []string `json:"paths,omitempty"`
+}
+
+func (f SnapshotFilter) filter() restic.SnapshotFilter {
+	filter := restic.SnapshotFilter{
+		Hosts: f.Hosts,
+		Paths: f.Paths,
+	}
+	for _, tags := range f.Tags {
+		filter.Tags = append(filter.Tags, restic.TagList(strings.Split(tags, ",")))
+	}
+	return filter
+}
+
+// SnapshotsRequest lists the snapshots of a repository. GroupBy is a
+// comma-separated list of "host", "paths" and "tags".
+type SnapshotsRequest struct {
+	Handle  string         `json:"handle"`
+	Filter  SnapshotFilter `json:"filter"`
+	GroupBy string         `json:"group_by,omitempty"`
+}
+
+// SnapshotsResponse contains the snapshots, grouped as requested.
+type SnapshotsResponse struct {
+	Groups []jsonout.SnapshotGroup `json:"groups"`
+}
+
+// Policy is the policy applied by Forget. The durations use the format of
+// restic, for example "1y2m3d".
+type Policy struct {
+	Last          int      `json:"last,omitempty"`
+	Hourly        int      `json:"hourly,omitempty"`
+	Daily         int      `json:"daily,omitempty"`
+	Weekly        int      `json:"weekly,omitempty"`
+	Monthly       int      `json:"monthly,omitempty"`
+	Yearly        int      `json:"yearly,omitempty"`
+	Within        string   `json:"within,omitempty"`
+	WithinHourly  string   `json:"within_hourly,omitempty"`
+	WithinDaily   string   `json:"within_daily,omitempty"`
+	WithinWeekly  string   `json:"within_weekly,omitempty"`
+	WithinMonthly string   `json:"within_monthly,omitempty"`
+	WithinYearly  string   `json:"within_yearly,omitempty"`
+	Tags          []string `json:"tags,omitempty"`
+}
+
+func (p Policy) policy() (restic.ExpirePolicy, error) {
+	policy := restic.ExpirePolicy{
+		Last:    p.Last,
+		Hourly:  p.Hourly,
+		Daily:   p.Daily,
+		Weekly:  p.Weekly,
+		Monthly: p.Monthly,
+		Yearly:  p.Yearly,
+	}
+	for _, d := range []struct {
+		s   string
+		dst *restic.Duration
+	}{
+		{p.Within, &policy.Within},
+		{p.WithinHourly, &policy.WithinHourly},
+		{p.WithinDaily, &policy.WithinDaily},
+		{p.WithinWeekly, &policy.WithinWeekly},
+		{p.WithinMonthly, &policy.WithinMonthly},
+		{p.WithinYearly, &policy.WithinYearly},
+	} {
+		if d.s == "" {
+			continue
+		}
+		var err error
+		*d.dst, err = restic.ParseDuration(d.s)
+		if err != nil {
+			return policy, errors.Fatalf("invalid duration %q: %v", d.s, err)
+		}
+	}
+	for _, tags := range p.Tags {
+		policy.Tags = append(policy.Tags, restic.TagList(strings.Split(tags, ",")))
+	}
+	return policy, nil
+}
+
+// ForgetRequest removes the snapshots which are not kept by Policy.
+type ForgetRequest struct {
+	Handle  string         `json:"handle"`
+	Filter  SnapshotFilter `json:"filter"`
+	GroupBy string         `json:"group_by,omitempty"`
+	Policy  Policy         `json:"policy"`
+	DryRun  bool           `json:"dry_run,omitempty"`
+}
+
+// ForgetResponse contains the kept and removed snapshots of each group.
+type ForgetResponse struct {
+	Groups []jsonout.ForgetGroup `json:"groups"`
+}
+
+// PruneRequest removes unused data from the repository. Unset fields use
+// the values of rapi.DefaultPruneOptions, MaxDuration uses the format of
+// time.ParseDuration.
+type PruneRequest struct {
+	Handle           string   `json:"handle"`
+	MaxUnusedPercent *float64 `json:"max_unused_percent,omitempty"`
+	MaxRepackBytes   uint64   `json:"max_repack_bytes,omitempty"`
+	MaxDuration      string   `json:"max_duration,omitempty"`
+	DryRun           bool     `json:"dry_run,omitempty"`
+}
+
+func (r *PruneRequest) options() (rapi.PruneOptions, error) {
+	opts := rapi.DefaultPruneOptions
+	if r.MaxUnusedPercent != nil {
+		opts.MaxUnusedPercent = *r.MaxUnusedPercent
+	}
+	opts.MaxRepackBytes = r.MaxRepackBytes
+	opts.DryRun = r.DryRun
+	if r.MaxDuration != "" {
+		d, err := time.ParseDuration(r.MaxDuration)
+		if err != nil {
+			return opts, errors.Fatalf("invalid duration %q: %v", r.MaxDuration, err)
+		}
+		opts.MaxDuration = d
+	}
+	return opts, nil
+}
+
+// PruneResponse describes the work done by Prune.
+type PruneResponse struct {
+	Stats rapi.PruneStats `json:"stats"`
+}
+
+// BackupRequest saves Paths of the local filesystem in a new snapshot.
+// Parent is the ID of the parent snapshot, by default the latest snapshot
+// of the host with the same paths is used.
+type BackupRequest struct {
+	Handle   string   `json:"handle"`
+	Paths    []string `json:"paths"`
+	Tags     []string `json:"tags,omitempty"`
+	Hostname string   `json:"hostname,omitempty"`
+	Parent   string   `json:"parent,omitempty"`
+}
+
+// BackupMessage is sent while a backup is running, exactly one field is set.
+// The last message contains the summary.
+type BackupMessage struct {
+	Status  *jsonout.StatusUpdate  `json:"status,omitempty"`
+	Error   *jsonout.ErrorUpdate   `json:"error,omitempty"`
+	Summary *jsonout.BackupSummary `json:"summary,omitempty"`
+}
+
+// RestoreRequest restores Snapshot, an ID or "latest", to the local
+// directory Target.
+type RestoreRequest struct {
+	Handle   string `json:"handle"`
+	Snapshot string `json:"snapshot"`
+	Target   string `json:"target"`
+}
+
+// RestoreMessage is sent while a restore is running, exactly one field is
+// set. The last message contains the summary.
+type RestoreMessage struct {
+	Status  *jsonout.RestoreStatus  `json:"status,omitempty"`
+	Summary *jsonout.RestoreSummary `json:"summary,omitempty"`
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/konidev20/rapi"
+	"github.com/konidev20/rapi/internal/restorer"
+	"github.com/konidev20/rapi/restic"
+	restoreui "github.com/konidev20/rapi/ui/restore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Restore restores a snapshot to a local directory and sends progress
+// messages while the restore is running. The snapshot may be given as
+// "<snapshot>:<subfolder>" to restore only a directory of it.
+func (s *Server) Restore(req *RestoreRequest, stream Stream[RestoreMessage]) error {
+	ctx := stream.Context()
+	repo, err := s.lookup(req.Handle)
+	if err != nil {
+		return err
+	}
+	if req.Target == "" {
+		return status.Error(codes.InvalidArgument, "no target directory given")
+	}
+
+	unlock, err := rapi.LockRepository(ctx, repo, false)
+	if err != nil {
+		return statusError(err)
+	}
+	defer unlock()
+
+	if err := repo.LoadIndex(ctx, nil); err != nil {
+		return statusError(err)
+	}
+
+	filter := restic.SnapshotFilter{}
+	sn, subfolder, err := filter.FindLatest(ctx, repo, repo, req.Snapshot)
+	if err != nil {
+		return statusError(err)
+	}
+	if subfolder != "" {
+		sn.Tree, err = restic.FindTreeDirectory(ctx, repo, sn.Tree, subfolder)
+		if err != nil {
+			return statusError(err)
+		}
+	}
+
+	printer := &restorePrinter{stream: stream}
+	progress := restoreui.NewProgress(printer, s.opts.ProgressInterval)
+	res := restorer.NewRestorer(repo, sn, false, progress)
+
+	err = res.RestoreTo(ctx, req.Target)
+	printer.complete(err == nil)
+	progress.Finish()
+	if err != nil {
+		return statusError(err)
+	}
+	return printer.err()
+}
+
+// restorePrinter sends the progress of a restore to a stream. The summary is
+// only sent if the restore was successful.
+type restorePrinter struct {
+	m         sync.Mutex
+	stream    Stream[RestoreMessage]
+	sendErr   error
+	succeeded bool
+}
+
+var _ restoreui.ProgressPrinter = &restorePrinter{}
+
+func (p *restorePrinter) send(msg *RestoreMessage) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	if p.sendErr != nil {
+		return
+	}
+	p.sendErr = p.stream.Send(msg)
+}
+
+func (p *restorePrinter) complete(succeeded bool) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.succeeded = succeeded
+}
+
+func (p *restorePrinter) err() error {
+	p.m.Lock()
+	defer p.m.Unlock()
+	return p.sendErr
+}
+
+func (p *restorePrinter) Update(filesFinished, filesTotal, allBytesWritten, allBytesTotal uint64, duration time.Duration) {
+	status := restoreui.NewJSONStatus(filesFinished, filesTotal, allBytesWritten, allBytesTotal, duration)
+	p.send(&RestoreMessage{Status: &status})
+}
+
+func (p *restorePrinter) Finish(filesFinished, filesTotal, allBytesWritten, allBytesTotal uint64, duration time.Duration) {
+	p.m.Lock()
+	succeeded := p.succeeded
+	p.m.Unlock()
+	if !succeeded {
+		return
+	}
+	summary := restoreui.NewJSONSummary(filesFinished, filesTotal, allBytesWritten, allBytesTotal, duration)
+	p.send(&RestoreMessage{Summary: &summary})
+}
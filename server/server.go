@@ -0,0 +1,246 @@
+// Package server exposes the core operations of the library over gRPC, so
+// that applications written in other languages can drive repositories
+// through a sidecar process built from this library.
+//
+// The service is described by ServiceDesc. There is no .proto file, the
+// messages are encoded as JSON instead of protocol buffers.
+//
+// The wire format is plain gRPC over HTTP/2 with the content type
+// application/grpc+json:
+//   - The methods are called as /rapi.Repository/<Method>. Open, Close,
+//     Snapshots, Forget and Prune are unary. Backup and Restore are server
+//     streaming methods which send progress messages until the operation is
+//     complete.
+//   - Each gRPC message is a UTF-8 JSON object. Requests and responses are
+//     the types of this package, for example OpenRequest and OpenResponse,
+//     with the field names given by their json tags.
+//   - The progress messages of backup and restore use the schemas of
+//     restic's JSON output from package jsonout.
+//
+// Clients in other languages register a codec named "json" which passes the
+// encoded JSON through, and select it as the content subtype. Requests with
+// another content subtype, including the default proto, are rejected. Client
+// implements the service for Go programs.
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/konidev20/rapi"
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/internal/options"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/restic"
+	"github.com/konidev20/rapi/ui/jsonout"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Options configure a Server.
+type Options struct {
+	// Repository are the options used to open repositories, usually a copy
+	// of rapi.DefaultOptions. The location, password and extended options
+	// are taken from each OpenRequest.
+	Repository rapi.RepositoryOptions
+
+	// ProgressInterval is the time between two progress messages of backup
+	// and restore. If it is zero, progress is sent every second.
+	ProgressInterval time.Duration
+}
+
+// Server implements the gRPC service. It keeps the repositories opened by
+// clients until they are closed, a handle can be used by several clients
+// at once. It is safe for concurrent use.
+type Server struct {
+	opts Options
+
+	mu    sync.Mutex
+	repos map[string]*repository.Repository
+}
+
+// New returns a server with the options opts.
+func New(opts Options) *Server {
+	if opts.ProgressInterval == 0 {
+		opts.ProgressInterval = time.Second
+	}
+	return &Server{
+		opts:  opts,
+		repos: make(map[string]*repository.Repository),
+	}
+}
+
+// NewGRPCServer returns a gRPC server with the service of s registered. The
+// service expects the content subtype json, see Codec. The options opt are
+// passed to grpc.NewServer.
+func NewGRPCServer(s *Server, opt ...grpc.ServerOption) *grpc.Server {
+	gs := grpc.NewServer(opt...)
+	gs.RegisterService(&ServiceDesc, s)
+	return gs
+}
+
+// Shutdown closes all repositories which are still open.
+func (s *Server) Shutdown() error {
+	s.mu.Lock()
+	repos := s.repos
+	s.repos = make(map[string]*repository.Repository)
+	s.mu.Unlock()
+
+	var firstErr error
+	for _, repo := range repos {
+		if err := repo.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// lookup returns the repository opened with handle.
+func (s *Server) lookup(handle string) (*repository.Repository, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	repo, ok := s.repos[handle]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "unknown repository handle %q", handle)
+	}
+	return repo, nil
+}
+
+// statusError converts err to a gRPC status error. Fatal errors are caused
+// by the request, context errors keep their meaning.
+func statusError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	case errors.Is(err, rapi.ErrRepoNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, rapi.ErrWrongPassword):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, rapi.ErrLocked), restic.IsAlreadyLocked(err):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.IsFatal(err):
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	return status.Error(codes.Unknown, err.Error())
+}
+
+// Open opens a repository and returns its handle.
+func (s *Server) Open(ctx context.Context, req *OpenRequest) (*OpenResponse, error) {
+	opts := s.opts.Repository
+	opts.Repo = req.Repo
+	opts.RepositoryFile = ""
+	opts.Password = req.Password
+	opts.PasswordFile = ""
+	opts.PasswordCommand = ""
+	opts.PasswordProvider = nil
+	opts.Keychain = ""
+	opts.ReadOnly = opts.ReadOnly || req.ReadOnly
+	if len(req.Options) > 0 {
+		extended, err := options.Parse(req.Options)
+		if err != nil {
+			return nil, statusError(err)
+		}
+		opts.Extended = extended
+	}
+
+	repo, err := rapi.OpenRepository(ctx, opts)
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	handle := restic.NewRandomID().String()
+	s.mu.Lock()
+	s.repos[handle] = repo
+	s.mu.Unlock()
+	debug.Log("opened repository %v as %v", repo.Config().ID, handle)
+
+	return &OpenResponse{Handle: handle, ID: repo.Config().ID}, nil
+}
+
+// Close closes the repository with the handle. Operations which are still
+// running on it fail.
+func (s *Server) Close(_ context.Context, req *CloseRequest) (*CloseResponse, error) {
+	repo, err := s.lookup(req.Handle)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	delete(s.repos, req.Handle)
+	s.mu.Unlock()
+
+	return &CloseResponse{}, statusError(repo.Close())
+}
+
+// Snapshots lists the snapshots of a repository.
+func (s *Server) Snapshots(ctx context.Context, req *SnapshotsRequest) (*SnapshotsResponse, error) {
+	repo, err := s.lookup(req.Handle)
+	if err != nil {
+		return nil, err
+	}
+	var groupBy restic.SnapshotGroupByOptions
+	if err := groupBy.Set(req.GroupBy); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	groups, err := rapi.Snapshots(ctx, repo, req.Filter.filter(), groupBy)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &SnapshotsResponse{Groups: jsonout.NewSnapshotGroups(groups)}, nil
+}
+
+// Forget removes the snapshots which are not kept by the policy.
+func (s *Server) Forget(ctx context.Context, req *ForgetRequest) (*ForgetResponse, error) {
+	repo, err := s.lookup(req.Handle)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := req.Policy.policy()
+	if err != nil {
+		return nil, statusError(err)
+	}
+	opts := rapi.ForgetOptions{
+		Policy: policy,
+		Filter: req.Filter.filter(),
+		DryRun: req.DryRun,
+	}
+	if err := opts.GroupBy.Set(req.GroupBy); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	groups, err := rapi.Forget(ctx, repo, opts)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	resp := &ForgetResponse{Groups: make([]jsonout.ForgetGroup, 0, len(groups))}
+	for _, g := range groups {
+		resp.Groups = append(resp.Groups, jsonout.NewForgetGroup(g.Key, g.Keep, g.Remove, g.Reasons))
+	}
+	return resp, nil
+}
+
+// Prune removes unused data from a repository.
+func (s *Server) Prune(ctx context.Context, req *PruneRequest) (*PruneResponse, error) {
+	repo, err := s.lookup(req.Handle)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := req.options()
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	stats, err := rapi.Prune(ctx, repo, opts)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &PruneResponse{Stats: stats}, nil
+}
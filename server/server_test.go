@@ -0,0 +1,142 @@
+package server_test
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/konidev20/rapi"
+	"github.com/konidev20/rapi/backend/local"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/server"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func startServer(t *testing.T) *grpc.ClientConn {
+	opts := rapi.DefaultOptions
+	opts.NoCache = true
+	srv := server.New(server.Options{Repository: opts})
+	gs := server.NewGRPCServer(srv)
+
+	lis := bufconn.Listen(1 << 20)
+	go func() {
+		_ = gs.Serve(lis)
+	}()
+	t.Cleanup(func() {
+		gs.Stop()
+		rtest.OK(t, srv.Shutdown())
+	})
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	rtest.OK(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+// rawCodec passes JSON through, like a client in another language which
+// encodes the messages itself.
+type rawCodec struct{ name string }
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error)      { return v.([]byte), nil }
+func (rawCodec) Unmarshal(data []byte, v interface{}) error { *v.(*[]byte) = data; return nil }
+func (c rawCodec) Name() string                             { return c.name }
+
+func TestServer(t *testing.T) {
+	ctx := context.Background()
+	dir := filepath.Join(rtest.TempDir(t), "repo")
+	be, err := local.Create(ctx, local.Config{Path: dir, Connections: 2})
+	rtest.OK(t, err)
+	repository.TestRepositoryWithBackend(t, be, 0)
+	rtest.OK(t, be.Close())
+
+	src := rtest.TempDir(t)
+	rtest.OK(t, os.WriteFile(filepath.Join(src, "foo"), []byte("foo data"), 0600))
+	rtest.OK(t, os.Mkdir(filepath.Join(src, "sub"), 0700))
+	rtest.OK(t, os.WriteFile(filepath.Join(src, "sub", "bar"), []byte("bar data"), 0600))
+
+	conn := startServer(t)
+	client := server.NewClient(conn)
+
+	_, err = client.Snapshots(ctx, &server.SnapshotsRequest{Handle: "unknown"})
+	rtest.Equals(t, codes.NotFound, status.Code(err))
+
+	open, err := client.Open(ctx, &server.OpenRequest{Repo: dir, Password: rtest.TestPassword})
+	rtest.OK(t, err)
+	rtest.Assert(t, open.Handle != "", "no handle returned")
+
+	var ids []string
+	for i := 0; i < 2; i++ {
+		var summary *server.BackupMessage
+		err = client.Backup(ctx, &server.BackupRequest{Handle: open.Handle, Paths: []string{src}, Hostname: "test"},
+			func(msg *server.BackupMessage) error {
+				rtest.Assert(t, msg.Error == nil, "unexpected error %v", msg.Error)
+				summary = msg
+				return nil
+			})
+		rtest.OK(t, err)
+		rtest.Assert(t, summary != nil && summary.Summary != nil, "last message is not the summary")
+		rtest.Equals(t, uint(2), summary.Summary.TotalFilesProcessed)
+		if i == 0 {
+			rtest.Equals(t, uint(2), summary.Summary.FilesNew)
+		} else {
+			rtest.Equals(t, uint(2), summary.Summary.FilesUnmodified)
+		}
+		ids = append(ids, summary.Summary.SnapshotID)
+	}
+
+	snapshots, err := client.Snapshots(ctx, &server.SnapshotsRequest{Handle: open.Handle, GroupBy: "host"})
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, len(snapshots.Groups))
+	rtest.Equals(t, "test", snapshots.Groups[0].GroupKey.Hostname)
+	rtest.Equals(t, 2, len(snapshots.Groups[0].Snapshots))
+	rtest.Equals(t, ids[1], snapshots.Groups[0].Snapshots[0].ID.String())
+
+	target := rtest.TempDir(t)
+	var restored *server.RestoreMessage
+	err = client.Restore(ctx, &server.RestoreRequest{Handle: open.Handle, Snapshot: "latest", Target: target},
+		func(msg *server.RestoreMessage) error {
+			restored = msg
+			return nil
+		})
+	rtest.OK(t, err)
+	rtest.Assert(t, restored != nil && restored.Summary != nil, "last message is not the summary")
+	buf, err := os.ReadFile(filepath.Join(target, src, "sub", "bar"))
+	rtest.OK(t, err)
+	rtest.Equals(t, "bar data", string(buf))
+
+	forget, err := client.Forget(ctx, &server.ForgetRequest{Handle: open.Handle, Policy: server.Policy{Last: 1}})
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, len(forget.Groups))
+	rtest.Equals(t, ids[0], forget.Groups[0].Remove[0].ID.String())
+
+	_, err = client.Forget(ctx, &server.ForgetRequest{Handle: open.Handle, Policy: server.Policy{Within: "x"}})
+	rtest.Equals(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.Prune(ctx, &server.PruneRequest{Handle: open.Handle})
+	rtest.OK(t, err)
+
+	// other clients send JSON with the content subtype json
+	var resp []byte
+	req := []byte(`{"handle": "` + open.Handle + `"}`)
+	rtest.OK(t, conn.Invoke(ctx, "/rapi.Repository/Snapshots", req, &resp, grpc.ForceCodec(rawCodec{"json"})))
+	rtest.Assert(t, len(resp) > 0 && resp[0] == '{', "invalid response %q", resp)
+
+	err = conn.Invoke(ctx, "/rapi.Repository/Snapshots", req, &resp, grpc.ForceCodec(rawCodec{"proto"}))
+	rtest.Assert(t, err != nil, "request with content subtype proto accepted")
+
+	_, err = client.Close(ctx, &server.CloseRequest{Handle: open.Handle})
+	rtest.OK(t, err)
+	_, err = client.Snapshots(ctx, &server.SnapshotsRequest{Handle: open.Handle})
+	rtest.Equals(t, codes.NotFound, status.Code(err))
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+)
+
+// ServiceName is the name of the gRPC service.
+const ServiceName = "rapi.Repository"
+
+// Codec encodes the messages of the service as JSON. Its name is "json",
+// which clients use as the content subtype application/grpc+json. It is
+// registered with encoding.RegisterCodec when the package is imported.
+type Codec struct{}
+
+func init() {
+	encoding.RegisterCodec(Codec{})
+}
+
+// Marshal implements encoding.Codec.
+func (Codec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal implements encoding.Codec.
+func (Codec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// Name implements encoding.Codec.
+func (Codec) Name() string {
+	return "json"
+}
+
+// ServiceDesc describes the service implemented by Server, for use with
+// grpc.Server.RegisterService. The unary methods Open, Close, Snapshots,
+// Forget and Prune return a single response, the server streaming methods
+// Backup and Restore send progress messages until the operation is
+// complete.
+var ServiceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		unaryMethod("Open", (*Server).Open),
+		unaryMethod("Close", (*Server).Close),
+		unaryMethod("Snapshots", (*Server).Snapshots),
+		unaryMethod("Forget", (*Server).Forget),
+		unaryMethod("Prune", (*Server).Prune),
+	},
+	Streams: []grpc.StreamDesc{
+		streamMethod("Backup", (*Server).Backup),
+		streamMethod("Restore", (*Server).Restore),
+	},
+}
+
+func unaryMethod[Req, Resp any](name string, fn func(*Server, context.Context, *Req) (*Resp, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			req := new(Req)
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			if interceptor == nil {
+				return fn(srv.(*Server), ctx, req)
+			}
+			info := &grpc.UnaryServerInfo{
+				Server:     srv,
+				FullMethod: "/" + ServiceName + "/" + name,
+			}
+			return interceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return fn(srv.(*Server), ctx, req.(*Req))
+			})
+		},
+	}
+}
+
+// Stream sends the messages of a server streaming method.
+type Stream[T any] interface {
+	Context() context.Context
+	Send(*T) error
+}
+
+type serverStream[T any] struct {
+	grpc.ServerStream
+}
+
+func (s serverStream[T]) Send(msg *T) error {
+	return s.SendMsg(msg)
+}
+
+func streamMethod[Req, Msg any](name string, fn func(*Server, *Req, Stream[Msg]) error) grpc.StreamDesc {
+	return grpc.StreamDesc{
+		StreamName:    name,
+		ServerStreams: true,
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			req := new(Req)
+			if err := stream.RecvMsg(req); err != nil {
+				return err
+			}
+			return fn(srv.(*Server), req, serverStream[Msg]{stream})
+		},
+	}
+}
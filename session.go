@@ -0,0 +1,319 @@
+package rapi
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/restic"
+	"github.com/konidev20/rapi/ui/progress"
+	"golang.org/x/sync/errgroup"
+)
+
+// BackupSession is a restic.Repository which writes to several repositories
+// at once. It is passed to the archiver instead of a single repository, so
+// that the source data is read and chunked only once and the resulting blobs
+// are stored in all repositories, for example to keep copies in different
+// locations.
+//
+// All reads are served by the first repository. A blob is only considered
+// known if all repositories contain it, so that unchanged files are only
+// skipped if their contents are present everywhere.
+type BackupSession struct {
+	restic.Repository
+
+	repos []restic.Repository
+	idx   *sessionIndex
+
+	m         sync.Mutex
+	snapshots restic.IDs
+}
+
+// statically ensure that BackupSession implements restic.Repository.
+var _ restic.Repository = &BackupSession{}
+
+// NewBackupSession returns a session which writes to all repos. The
+// repositories must use the same chunker polynomial and hash algorithm,
+// otherwise the same file would be split into different blobs with
+// different IDs.
+func NewBackupSession(repos ...restic.Repository) (*BackupSession, error) {
+	if len(repos) == 0 {
+		return nil, errors.New("no repositories given")
+	}
+
+	pol := repos[0].Config().ChunkerPolynomial
+	hash := restic.RepositoryHashAlgorithm(repos[0]).Name()
+	for _, repo := range repos[1:] {
+		if repo.Config().ChunkerPolynomial != pol {
+			return nil, errors.Fatalf("repository %v uses different chunker parameters than %v",
+				repo.Config().ID, repos[0].Config().ID)
+		}
+		if restic.RepositoryHashAlgorithm(repo).Name() != hash {
+			return nil, errors.Fatalf("repository %v uses a different hash algorithm than %v",
+				repo.Config().ID, repos[0].Config().ID)
+		}
+	}
+
+	return &BackupSession{
+		Repository: repos[0],
+		repos:      repos,
+		idx:        &sessionIndex{MasterIndex: repos[0].Index(), repos: repos},
+	}, nil
+}
+
+// Repositories returns the repositories written to by s.
+func (s *BackupSession) Repositories() []restic.Repository {
+	return s.repos
+}
+
+// SnapshotIDs returns the IDs of the last snapshot saved in each repository,
+// in the order of Repositories. The IDs differ as each repository uses its
+// own key. It returns nil if no snapshot was saved yet.
+func (s *BackupSession) SnapshotIDs() restic.IDs {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.snapshots
+}
+
+// Index returns an index which reports blobs as present only if all
+// repositories contain them.
+func (s *BackupSession) Index() restic.MasterIndex {
+	return s.idx
+}
+
+// LoadIndex loads the index of all repositories.
+func (s *BackupSession) LoadIndex(ctx context.Context, p *progress.Counter) error {
+	for _, repo := range s.repos {
+		if err := repo.LoadIndex(ctx, p); err != nil {
+			return err
+		}
+	}
+	s.idx.MasterIndex = s.repos[0].Index()
+	return nil
+}
+
+// HashAlgorithm returns the hash algorithm shared by all repositories.
+func (s *BackupSession) HashAlgorithm() restic.HashAlgorithm {
+	return restic.RepositoryHashAlgorithm(s.Repository)
+}
+
+// SetIndex is not supported, each repository has its own index.
+func (s *BackupSession) SetIndex(restic.MasterIndex) error {
+	return errors.New("SetIndex is not supported for a backup session")
+}
+
+// SaveBlob stores the blob in all repositories. The returned size is the one
+// of the first repository, the blob is only reported as known if all
+// repositories already contained it.
+func (s *BackupSession) SaveBlob(ctx context.Context, t restic.BlobType, buf []byte, id restic.ID, storeDuplicate bool) (restic.ID, bool, int, error) {
+	if id.IsNull() {
+		id = s.HashAlgorithm().Sum(buf)
+	}
+
+	allKnown := true
+	var size int
+	for i, repo := range s.repos {
+		_, known, n, err := repo.SaveBlob(ctx, t, buf, id, storeDuplicate)
+		if err != nil {
+			return restic.ID{}, false, 0, err
+		}
+		allKnown = allKnown && known
+		if i == 0 {
+			size = n
+		}
+	}
+
+	return id, allKnown, size, nil
+}
+
+// StartPackUploader starts the pack uploaders of all repositories.
+func (s *BackupSession) StartPackUploader(ctx context.Context, wg *errgroup.Group) {
+	for _, repo := range s.repos {
+		repo.StartPackUploader(ctx, wg)
+	}
+}
+
+// Flush flushes all repositories.
+func (s *BackupSession) Flush(ctx context.Context) error {
+	wg, ctx := errgroup.WithContext(ctx)
+	for _, repo := range s.repos {
+		repo := repo
+		wg.Go(func() error {
+			return repo.Flush(ctx)
+		})
+	}
+	return wg.Wait()
+}
+
+// Shutdown saves the partial state of all repositories after a backup failed
+// or its context was cancelled, see repository.Repository.Shutdown. The
+// OnShutdown hooks are called if ctx was cancelled.
+func (s *BackupSession) Shutdown(ctx context.Context) error {
+	var firstErr error
+	for _, repo := range s.repos {
+		if err := shutdownRepository(ctx, repo); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if ctx.Err() != nil {
+		runShutdownHooks(ShutdownEvent{Operation: "backup", Cause: ctx.Err(), Err: firstErr})
+	}
+	return firstErr
+}
+
+// SaveUnpacked stores the file in all repositories and returns the ID in the
+// first one. The IDs of saved snapshots are available from SnapshotIDs. Lock
+// files are rejected, as they could only be removed from the first
+// repository.
+func (s *BackupSession) SaveUnpacked(ctx context.Context, t restic.FileType, buf []byte) (restic.ID, error) {
+	if t == restic.LockFile {
+		return restic.ID{}, errors.New("locks cannot be saved through a backup session, lock each repository instead")
+	}
+
+	if t == restic.SnapshotFile {
+		return s.saveSnapshot(ctx, buf)
+	}
+
+	var first restic.ID
+	for i, repo := range s.repos {
+		id, err := repo.SaveUnpacked(ctx, t, buf)
+		if err != nil {
+			return restic.ID{}, err
+		}
+		if i == 0 {
+			first = id
+		}
+	}
+	return first, nil
+}
+
+// saveSnapshot stores the snapshot in buf in all repositories. Its parent
+// refers to a snapshot in the first repository, the snapshots in the other
+// repositories refer to the matching parent there, see findParent.
+func (s *BackupSession) saveSnapshot(ctx context.Context, buf []byte) (restic.ID, error) {
+	var sn restic.Snapshot
+	if err := json.Unmarshal(buf, &sn); err != nil {
+		return restic.ID{}, errors.Wrap(err, "Unmarshal")
+	}
+
+	var parent *restic.Snapshot
+	if sn.Parent != nil {
+		var err error
+		parent, err = restic.LoadSnapshot(ctx, s.repos[0], *sn.Parent)
+		if err != nil {
+			return restic.ID{}, err
+		}
+	}
+
+	ids := make(restic.IDs, 0, len(s.repos))
+	for i, repo := range s.repos {
+		data := buf
+		if i > 0 && parent != nil {
+			id, err := findParent(ctx, repo, parent)
+			if err != nil {
+				return restic.ID{}, err
+			}
+			sn.Parent = id
+			data, err = json.Marshal(&sn)
+			if err != nil {
+				return restic.ID{}, errors.Wrap(err, "Marshal")
+			}
+		}
+
+		id, err := repo.SaveUnpacked(ctx, restic.SnapshotFile, data)
+		if err != nil {
+			return restic.ID{}, err
+		}
+		ids = append(ids, id)
+	}
+
+	s.m.Lock()
+	s.snapshots = ids
+	s.m.Unlock()
+
+	return ids[0], nil
+}
+
+// findParent returns the ID of the snapshot in repo which corresponds to
+// parent. This is the copy of parent saved by an earlier session, with the
+// same tree, time and hostname. Without such a copy, it is the latest
+// snapshot of the same host and paths, or nil if there is none.
+func findParent(ctx context.Context, repo restic.Repository, parent *restic.Snapshot) (*restic.ID, error) {
+	var match, latest *restic.Snapshot
+	err := restic.ForAllSnapshots(ctx, repo, repo, nil, func(id restic.ID, sn *restic.Snapshot, err error) error {
+		if err != nil {
+			return err
+		}
+		if sn.Hostname != parent.Hostname || !sn.HasPaths(parent.Paths) || len(sn.Paths) != len(parent.Paths) {
+			return nil
+		}
+		if sn.Tree != nil && parent.Tree != nil && *sn.Tree == *parent.Tree && sn.Time.Equal(parent.Time) {
+			match = sn
+		}
+		if latest == nil || sn.Time.After(latest.Time) {
+			latest = sn
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case match != nil:
+		return match.ID(), nil
+	case latest != nil:
+		return latest.ID(), nil
+	default:
+		return nil, nil
+	}
+}
+
+// lock locks all repositories of the session, see lockRepository. A lock
+// created through the session would only be removed from the first
+// repository.
+func (s *BackupSession) lock(ctx context.Context, exclusive bool) (unlock func(), err error) {
+	unlocks := make([]func(), 0, len(s.repos))
+	unlockAll := func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
+		}
+	}
+
+	for _, repo := range s.repos {
+		unlock, err := lockRepository(ctx, repo, exclusive)
+		if err != nil {
+			unlockAll()
+			return nil, err
+		}
+		unlocks = append(unlocks, unlock)
+	}
+	return unlockAll, nil
+}
+
+// sessionIndex reports a blob as present only if all repositories contain it.
+// All other methods use the index of the first repository.
+type sessionIndex struct {
+	restic.MasterIndex
+	repos []restic.Repository
+}
+
+// Err returns the first error of reading the index of any repository.
+func (idx *sessionIndex) Err() error {
+	for _, repo := range idx.repos {
+		if err := restic.IndexErr(repo.Index()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (idx *sessionIndex) Has(bh restic.BlobHandle) bool {
+	for _, repo := range idx.repos {
+		if !repo.Index().Has(bh) {
+			return false
+		}
+	}
+	return true
+}
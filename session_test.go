@@ -0,0 +1,141 @@
+package rapi_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/konidev20/rapi"
+	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/internal/archiver"
+	"github.com/konidev20/rapi/internal/fs"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/restic"
+)
+
+func TestBackupSession(t *testing.T) {
+	ctx := context.Background()
+	repoA := repository.TestRepository(t)
+	repoB := repository.TestRepository(t)
+
+	src := rtest.TempDir(t)
+	rtest.OK(t, os.WriteFile(filepath.Join(src, "a"), rtest.Random(1, 100000), 0600))
+
+	// the contents of a are only present in repoB
+	testBackup(t, repoB, src, nil)
+	rtest.OK(t, os.WriteFile(filepath.Join(src, "b"), rtest.Random(2, 100000), 0600))
+
+	session, err := rapi.NewBackupSession(repoA, repoB)
+	rtest.OK(t, err)
+	rtest.OK(t, session.LoadIndex(ctx, nil))
+	testBackup(t, session, src, nil)
+
+	ids := session.SnapshotIDs()
+	rtest.Equals(t, 2, len(ids))
+
+	var treeIDs restic.IDs
+	for i, repo := range session.Repositories() {
+		sn, err := restic.LoadSnapshot(ctx, repo, ids[i])
+		rtest.OK(t, err)
+		treeIDs = append(treeIDs, *sn.Tree)
+
+		rtest.OK(t, repo.LoadIndex(ctx, nil))
+		blobs := restic.NewBlobSet()
+		rtest.OK(t, restic.FindUsedBlobs(ctx, repo, restic.IDs{*sn.Tree}, blobs, nil))
+		for bh := range blobs {
+			_, err := repo.LoadBlob(ctx, bh.Type, bh.ID, nil)
+			rtest.OK(t, err)
+		}
+
+		res, err := rapi.Check(ctx, repo, rapi.CheckOptions{})
+		rtest.OK(t, err)
+		rtest.Equals(t, 0, len(res.Errors))
+	}
+	rtest.Equals(t, treeIDs[0], treeIDs[1])
+
+	snapshots, err := restic.TestLoadAllSnapshots(ctx, repoB, nil)
+	rtest.OK(t, err)
+	rtest.Equals(t, 2, len(snapshots))
+
+	// the backup locks and unlocks each repository
+	_, err = rapi.Backup(ctx, session, []string{src}, rapi.BackupOptions{Hostname: "test"})
+	rtest.OK(t, err)
+	for _, repo := range session.Repositories() {
+		var locks int
+		rtest.OK(t, repo.Backend().List(ctx, restic.LockFile, func(backend.FileInfo) error {
+			locks++
+			return nil
+		}))
+		rtest.Equals(t, 0, locks)
+	}
+
+	_, err = restic.NewLock(ctx, session)
+	rtest.Assert(t, err != nil, "lock through the session was not rejected")
+}
+
+// backupLoaded works like testBackup, but returns the snapshot as loaded from
+// the repository, so that it can be used as a parent.
+func backupLoaded(t *testing.T, repo restic.Repository, dir string, parent *restic.Snapshot) *restic.Snapshot {
+	arch := archiver.New(repo, fs.Track{FS: fs.Local{}}, archiver.Options{})
+	// files of the parent may be missing in some repositories of a session
+	arch.Error = func(item string, err error) error {
+		t.Logf("%v: %v", item, err)
+		return nil
+	}
+	_, id, err := arch.Snapshot(context.TODO(), []string{dir}, archiver.SnapshotOptions{
+		Hostname:       "test",
+		Time:           time.Now(),
+		ParentSnapshot: parent,
+	})
+	rtest.OK(t, err)
+
+	sn, err := restic.LoadSnapshot(context.TODO(), repo, id)
+	rtest.OK(t, err)
+	return sn
+}
+
+func TestBackupSessionParents(t *testing.T) {
+	ctx := context.Background()
+	repoA := repository.TestRepository(t)
+	repoB := repository.TestRepository(t)
+
+	src := rtest.TempDir(t)
+	rtest.OK(t, os.WriteFile(filepath.Join(src, "a"), rtest.Random(1, 1000), 0600))
+	onlyB := backupLoaded(t, repoB, src, nil)
+
+	rtest.OK(t, os.WriteFile(filepath.Join(src, "b"), rtest.Random(2, 1000), 0600))
+	onlyA := backupLoaded(t, repoA, src, nil)
+
+	loadParents := func(session *rapi.BackupSession) restic.IDs {
+		var parents restic.IDs
+		for i, repo := range session.Repositories() {
+			sn, err := restic.LoadSnapshot(ctx, repo, session.SnapshotIDs()[i])
+			rtest.OK(t, err)
+			rtest.Assert(t, sn.Parent != nil, "snapshot in repository %d has no parent", i)
+			parents = append(parents, *sn.Parent)
+		}
+		return parents
+	}
+
+	// the parent in repoB is the latest snapshot of the same paths there
+	session, err := rapi.NewBackupSession(repoA, repoB)
+	rtest.OK(t, err)
+	rtest.OK(t, session.LoadIndex(ctx, nil))
+	first := backupLoaded(t, session, src, onlyA)
+	rtest.Equals(t, restic.IDs{*onlyA.ID(), *onlyB.ID()}, loadParents(session))
+	firstIDs := session.SnapshotIDs()
+
+	// the parent in repoB is the copy of the parent in repoA, even if repoB
+	// contains a newer snapshot
+	rtest.OK(t, os.WriteFile(filepath.Join(src, "c"), rtest.Random(3, 1000), 0600))
+	backupLoaded(t, repoB, src, nil)
+
+	session, err = rapi.NewBackupSession(repoA, repoB)
+	rtest.OK(t, err)
+	rtest.OK(t, session.LoadIndex(ctx, nil))
+	backupLoaded(t, session, src, first)
+	rtest.Equals(t, firstIDs, loadParents(session))
+}
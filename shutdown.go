@@ -0,0 +1,109 @@
+package rapi
+
+import (
+	"context"
+	"sync"
+
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/restic"
+)
+
+// ShutdownEvent describes an operation which was cancelled.
+type ShutdownEvent struct {
+	// Operation is the name of the operation, for example "prune".
+	Operation string
+
+	// Cause is the error of the cancelled context.
+	Cause error
+
+	// Err is the error which occurred while saving the partial state, nil
+	// if the index of all uploaded packs was saved.
+	Err error
+}
+
+var shutdownHooks struct {
+	sync.Mutex
+	next  int
+	hooks map[int]func(ShutdownEvent)
+}
+
+// OnShutdown registers fn to be called when the context of an operation which
+// modifies a repository is cancelled. It is called after the operation
+// completed the pack uploads which were already running, saved the index of
+// the uploaded packs and released its lock, that is once the repository is in
+// a consistent state again. Operations are Prune, Import, MergeSnapshots and
+// the SnapshotBuilder, the state of a BackupSession is saved by its Shutdown
+// method. The returned function removes fn again.
+func OnShutdown(fn func(ShutdownEvent)) (remove func()) {
+	shutdownHooks.Lock()
+	defer shutdownHooks.Unlock()
+
+	if shutdownHooks.hooks == nil {
+		shutdownHooks.hooks = make(map[int]func(ShutdownEvent))
+	}
+	id := shutdownHooks.next
+	shutdownHooks.next++
+	shutdownHooks.hooks[id] = fn
+
+	return func() {
+		shutdownHooks.Lock()
+		defer shutdownHooks.Unlock()
+		delete(shutdownHooks.hooks, id)
+	}
+}
+
+func runShutdownHooks(ev ShutdownEvent) {
+	shutdownHooks.Lock()
+	hooks := make([]func(ShutdownEvent), 0, len(shutdownHooks.hooks))
+	for _, fn := range shutdownHooks.hooks {
+		hooks = append(hooks, fn)
+	}
+	shutdownHooks.Unlock()
+
+	for _, fn := range hooks {
+		fn(ev)
+	}
+}
+
+// shutdownRepository saves the partial state of repo after an operation
+// failed, see repository.Repository.Shutdown.
+func shutdownRepository(ctx context.Context, repo restic.Repository) error {
+	if s, ok := repo.(interface{ Shutdown(context.Context) error }); ok {
+		return s.Shutdown(ctx)
+	}
+	return nil
+}
+
+// operation is a modification of a repository which holds a lock.
+type operation struct {
+	name   string
+	repo   restic.Repository
+	unlock func()
+}
+
+// beginOperation locks repo for the operation name.
+func beginOperation(ctx context.Context, repo restic.Repository, name string, exclusive bool) (*operation, error) {
+	unlock, err := lockRepository(ctx, repo, exclusive)
+	if err != nil {
+		return nil, err
+	}
+	return &operation{name: name, repo: repo, unlock: unlock}, nil
+}
+
+// end releases the lock. If the operation failed, the partial state is saved
+// first. If ctx was cancelled, the OnShutdown hooks are called afterwards.
+func (op *operation) end(ctx context.Context, failed bool) {
+	var err error
+	if failed {
+		err = shutdownRepository(ctx, op.repo)
+		if err != nil {
+			debug.Log("unable to save state of %v: %v", op.name, err)
+		}
+	}
+	op.unlock()
+
+	if ctx.Err() != nil {
+		debug.Log("%v was cancelled: %v", op.name, ctx.Err())
+		runShutdownHooks(ShutdownEvent{Operation: op.name, Cause: ctx.Err(), Err: err})
+	}
+}
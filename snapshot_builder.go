@@ -0,0 +1,309 @@
+package rapi
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/restic"
+	"github.com/restic/chunker"
+	"golang.org/x/sync/errgroup"
+)
+
+// SnapshotBuilder creates a snapshot from nodes passed by the caller instead
+// of reading a filesystem, for example to store a database export or a
+// listing of objects without a staging area.
+//
+// Nodes are added by their path in the snapshot. The entries must be added in
+// depth-first order with the names in each directory sorted bytewise, that is
+// all entries below a directory are added before the next entry of the
+// directory itself. Missing parent directories are created automatically. The
+// tree of a directory is saved as soon as the next entry is outside of it, so
+// only the directories on the path to the last entry are kept in memory.
+type SnapshotBuilder struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	repo   restic.Repository
+	op     *operation
+
+	// parent is the context passed to NewSnapshotBuilder
+	parent context.Context
+
+	// wg tracks the pack uploader, wgCtx is cancelled if an upload fails
+	wg    *errgroup.Group
+	wgCtx context.Context
+
+	chunker *chunker.Chunker
+	buf     []byte
+
+	// dirs are the open directories, dirs[0] is the root of the snapshot
+	dirs []*builderDir
+	now  time.Time
+}
+
+type builderDir struct {
+	node *restic.Node
+	tree *restic.TreeJSONBuilder
+	last string
+}
+
+// NewSnapshotBuilder locks repo and returns a SnapshotBuilder which saves
+// blobs to repo. The index of repo must be loaded so that blobs which already
+// exist are not saved again. Finish or Abort must be called to release the
+// lock, also if ctx was cancelled.
+func NewSnapshotBuilder(ctx context.Context, repo restic.Repository) (*SnapshotBuilder, error) {
+	op, err := beginOperation(ctx, repo, "snapshot builder", false)
+	if err != nil {
+		return nil, err
+	}
+
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	wg, wgCtx := errgroup.WithContext(ctx)
+	repo.StartPackUploader(wgCtx, wg)
+
+	return &SnapshotBuilder{
+		ctx:     ctx,
+		cancel:  cancel,
+		repo:    repo,
+		op:      op,
+		parent:  parent,
+		wg:      wg,
+		wgCtx:   wgCtx,
+		chunker: chunker.New(nil, repo.Config().ChunkerPolynomial),
+		buf:     make([]byte, chunker.MaxSize),
+		dirs:    []*builderDir{{tree: restic.NewTreeJSONBuilder()}},
+		now:     restic.Now(ctx),
+	}, nil
+}
+
+// AddDir adds a directory. node contains the metadata of the directory, it
+// may be nil. Its name, type and subtree are set by the builder. A directory
+// must be added before the entries in it, otherwise it is created with
+// default metadata.
+func (b *SnapshotBuilder) AddDir(p string, node *restic.Node) error {
+	parent, name, err := b.enter(p)
+	if err != nil {
+		return err
+	}
+	if node == nil {
+		node = b.defaultNode("dir", os.ModeDir|0755)
+	}
+	node.Name = name
+	node.Type = "dir"
+	if err := parent.add(name); err != nil {
+		return err
+	}
+
+	b.dirs = append(b.dirs, &builderDir{node: node, tree: restic.NewTreeJSONBuilder()})
+	return nil
+}
+
+// AddFile adds a regular file with the contents read from rd. node contains
+// the metadata of the file, it may be nil. Its name, type, size and content
+// are set by the builder.
+func (b *SnapshotBuilder) AddFile(p string, node *restic.Node, rd io.Reader) error {
+	parent, name, err := b.enter(p)
+	if err != nil {
+		return err
+	}
+	if node == nil {
+		node = b.defaultNode("file", 0644)
+	}
+	node.Name = name
+	node.Type = "file"
+	if err := parent.add(name); err != nil {
+		return err
+	}
+
+	node.Content = restic.IDs{}
+	node.Size = 0
+	b.chunker.Reset(rd, b.repo.Config().ChunkerPolynomial)
+	for {
+		chunk, err := b.chunker.Next(b.buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "chunker.Next")
+		}
+
+		id, _, _, err := b.repo.SaveBlob(b.wgCtx, restic.DataBlob, chunk.Data, restic.ID{}, false)
+		if err != nil {
+			return err
+		}
+		node.Content = append(node.Content, id)
+		node.Size += uint64(chunk.Length)
+	}
+
+	return parent.tree.AddNode(node)
+}
+
+// AddNode adds a node which has no contents, for example a symlink. node must
+// not be nil, its name is set by the builder.
+func (b *SnapshotBuilder) AddNode(p string, node *restic.Node) error {
+	switch node.Type {
+	case "dir":
+		return b.AddDir(p, node)
+	case "file":
+		if len(node.Content) > 0 {
+			return errors.Errorf("file %v: contents must be added with AddFile", p)
+		}
+	}
+
+	parent, name, err := b.enter(p)
+	if err != nil {
+		return err
+	}
+	node.Name = name
+	if err := parent.add(name); err != nil {
+		return err
+	}
+	return parent.tree.AddNode(node)
+}
+
+// Finish saves the remaining trees and the snapshot sn, which must not be
+// nil. The tree of sn is set to the root of the added entries, and its paths
+// default to "/". The lock is released, the builder must not be used
+// afterwards. Returned is the ID of the new snapshot.
+func (b *SnapshotBuilder) Finish(sn *restic.Snapshot) (_ restic.ID, err error) {
+	defer func() { b.end(err != nil) }()
+	defer b.cancel()
+
+	err = b.closeDirs(0)
+	if err == nil {
+		var root restic.ID
+		root, err = b.saveTree(b.dirs[0].tree)
+		sn.Tree = &root
+	}
+	if err == nil {
+		// shuts down the pack uploader
+		err = b.repo.Flush(b.wgCtx)
+	}
+	if err != nil {
+		b.cancel()
+		_ = b.wg.Wait()
+		return restic.ID{}, err
+	}
+	if err := b.wg.Wait(); err != nil {
+		return restic.ID{}, err
+	}
+
+	if len(sn.Paths) == 0 {
+		sn.Paths = []string{"/"}
+	}
+	id, err := restic.SaveSnapshot(b.ctx, b.repo, sn)
+	if err != nil {
+		return restic.ID{}, err
+	}
+	debug.Log("saved snapshot %v with tree %v", id.Str(), sn.Tree.Str())
+	return id, nil
+}
+
+// Abort stops the builder and releases the lock. The index of the blobs which
+// have already been uploaded is saved, they are removed by the next prune.
+func (b *SnapshotBuilder) Abort() {
+	b.cancel()
+	_ = b.wg.Wait()
+	b.end(true)
+}
+
+func (b *SnapshotBuilder) end(failed bool) {
+	if b.op == nil {
+		return
+	}
+	b.op.end(b.parent, failed)
+	b.op = nil
+}
+
+func (b *SnapshotBuilder) defaultNode(tpe string, mode os.FileMode) *restic.Node {
+	return &restic.Node{
+		Type:       tpe,
+		Mode:       mode,
+		ModTime:    b.now,
+		AccessTime: b.now,
+		ChangeTime: b.now,
+		UID:        uint32(os.Getuid()),
+		GID:        uint32(os.Getgid()),
+	}
+}
+
+// enter closes all directories which do not contain p, opens the missing
+// parent directories of p and returns the parent directory and the name of
+// p.
+func (b *SnapshotBuilder) enter(p string) (*builderDir, string, error) {
+	if err := b.wgCtx.Err(); err != nil {
+		return nil, "", err
+	}
+
+	p = path.Clean("/" + p)
+	if p == "/" {
+		return nil, "", errors.New("path of the root directory cannot be added")
+	}
+	components := strings.Split(p[1:], "/")
+	parents, name := components[:len(components)-1], components[len(components)-1]
+
+	// find the open directories on the path to p
+	depth := 0
+	for depth < len(parents) && depth+1 < len(b.dirs) && b.dirs[depth+1].node.Name == parents[depth] {
+		depth++
+	}
+	if err := b.closeDirs(depth); err != nil {
+		return nil, "", err
+	}
+
+	for _, dir := range parents[depth:] {
+		parent := b.dirs[len(b.dirs)-1]
+		if err := parent.add(dir); err != nil {
+			return nil, "", err
+		}
+		node := b.defaultNode("dir", os.ModeDir|0755)
+		node.Name = dir
+		b.dirs = append(b.dirs, &builderDir{node: node, tree: restic.NewTreeJSONBuilder()})
+	}
+
+	return b.dirs[len(b.dirs)-1], name, nil
+}
+
+// closeDirs saves the trees of all open directories below depth and adds them
+// to their parents.
+func (b *SnapshotBuilder) closeDirs(depth int) error {
+	for len(b.dirs)-1 > depth {
+		dir := b.dirs[len(b.dirs)-1]
+		b.dirs = b.dirs[:len(b.dirs)-1]
+
+		id, err := b.saveTree(dir.tree)
+		if err != nil {
+			return err
+		}
+		dir.node.Subtree = &id
+		if err := b.dirs[len(b.dirs)-1].tree.AddNode(dir.node); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *SnapshotBuilder) saveTree(tree *restic.TreeJSONBuilder) (restic.ID, error) {
+	buf, err := tree.Finalize()
+	if err != nil {
+		return restic.ID{}, err
+	}
+	id, _, _, err := b.repo.SaveBlob(b.wgCtx, restic.TreeBlob, buf, restic.ID{}, false)
+	return id, err
+}
+
+// add checks that name is sorted after the previous entry of the directory.
+func (d *builderDir) add(name string) error {
+	if name <= d.last {
+		return fmt.Errorf("node %q, last %q: %w", name, d.last, restic.ErrTreeNotOrdered)
+	}
+	d.last = name
+	return nil
+}
@@ -0,0 +1,133 @@
+package rapi_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/konidev20/rapi"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/restic"
+)
+
+func countLocks(t *testing.T, repo restic.Repository) int {
+	n := 0
+	rtest.OK(t, repo.List(context.TODO(), restic.LockFile, func(restic.ID, int64) error {
+		n++
+		return nil
+	}))
+	return n
+}
+
+func TestSnapshotBuilder(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.TestRepository(t)
+
+	b, err := rapi.NewSnapshotBuilder(ctx, repo)
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, countLocks(t, repo))
+
+	big := rtest.Random(23, 3*1024*1024)
+	rtest.OK(t, b.AddDir("/a", &restic.Node{Mode: 0700}))
+	rtest.OK(t, b.AddFile("/a/big", nil, bytes.NewReader(big)))
+	rtest.OK(t, b.AddFile("/a/small", nil, bytes.NewReader([]byte("small"))))
+	// the parent directories are created automatically
+	rtest.OK(t, b.AddNode("/b/c/link", &restic.Node{Type: "symlink", LinkTarget: "../../a/small"}))
+	rtest.OK(t, b.AddFile("/d", nil, bytes.NewReader(nil)))
+
+	sn, err := restic.NewSnapshot(nil, nil, "host", time.Now())
+	rtest.OK(t, err)
+	id, err := b.Finish(sn)
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, countLocks(t, repo))
+	rtest.Equals(t, []string{"/"}, sn.Paths)
+
+	rtest.OK(t, repo.LoadIndex(ctx, nil))
+	rtest.Equals(t, []string{"/a", "/a/big", "/a/small", "/b", "/b/c", "/b/c/link", "/d"},
+		lsPaths(t, repo, id, rapi.LsOptions{Recursive: true}))
+	checkTreeLoadable(t, repo, *sn.Tree)
+
+	nodes := make(map[string]*restic.Node)
+	rtest.OK(t, rapi.Ls(ctx, repo, id, rapi.LsOptions{Recursive: true}, func(entry rapi.LsEntry) error {
+		nodes[entry.Path] = entry.Node
+		return nil
+	}))
+	rtest.Equals(t, "dir", nodes["/a"].Type)
+	rtest.Equals(t, "symlink", nodes["/b/c/link"].Type)
+	rtest.Equals(t, uint64(len(big)), nodes["/a/big"].Size)
+	rtest.Assert(t, len(nodes["/a/big"].Content) > 1, "large file was not split into chunks")
+	rtest.Equals(t, 0, len(nodes["/d"].Content))
+
+	var data []byte
+	for _, blob := range nodes["/a/big"].Content {
+		buf, err := repo.LoadBlob(ctx, restic.DataBlob, blob, nil)
+		rtest.OK(t, err)
+		data = append(data, buf...)
+	}
+	rtest.Assert(t, bytes.Equal(big, data), "contents of the large file differ")
+
+	res, err := rapi.Check(ctx, repo, rapi.CheckOptions{})
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, len(res.Errors))
+}
+
+func TestSnapshotBuilderOrder(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.TestRepository(t)
+
+	b, err := rapi.NewSnapshotBuilder(ctx, repo)
+	rtest.OK(t, err)
+
+	rtest.OK(t, b.AddFile("/a/x", nil, bytes.NewReader([]byte("x"))))
+	rtest.OK(t, b.AddFile("/b", nil, bytes.NewReader([]byte("b"))))
+	// the directory a has already been saved
+	err = b.AddFile("/a/y", nil, bytes.NewReader([]byte("y")))
+	rtest.Assert(t, errors.Is(err, restic.ErrTreeNotOrdered), "unexpected error %v", err)
+	err = b.AddNode("/b", &restic.Node{Type: "symlink"})
+	rtest.Assert(t, errors.Is(err, restic.ErrTreeNotOrdered), "unexpected error %v", err)
+
+	b.Abort()
+	rtest.Equals(t, 0, countLocks(t, repo))
+}
+
+func TestSnapshotBuilderCancel(t *testing.T) {
+	repo := repository.TestRepository(t)
+
+	var events []rapi.ShutdownEvent
+	remove := rapi.OnShutdown(func(ev rapi.ShutdownEvent) {
+		// the lock is released before the hooks are called
+		rtest.Equals(t, 0, countLocks(t, repo))
+		events = append(events, ev)
+	})
+	defer remove()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b, err := rapi.NewSnapshotBuilder(ctx, repo)
+	rtest.OK(t, err)
+	data := rtest.Random(42, 1024*1024)
+	rtest.OK(t, b.AddFile("/a", nil, bytes.NewReader(data)))
+	cancel()
+	b.Abort()
+
+	rtest.Equals(t, 0, countLocks(t, repo))
+	rtest.Equals(t, 1, len(events))
+	rtest.Equals(t, "snapshot builder", events[0].Operation)
+	rtest.Assert(t, errors.Is(events[0].Cause, context.Canceled), "unexpected cause %v", events[0].Cause)
+	rtest.OK(t, events[0].Err)
+
+	// the blobs of the discarded pack are saved again by the next builder
+	b, err = rapi.NewSnapshotBuilder(context.Background(), repo)
+	rtest.OK(t, err)
+	rtest.OK(t, b.AddFile("/a", nil, bytes.NewReader(data)))
+	sn, err := restic.NewSnapshot(nil, nil, "host", time.Now())
+	rtest.OK(t, err)
+	id, err := b.Finish(sn)
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, len(events))
+
+	rtest.OK(t, repo.LoadIndex(context.Background(), nil))
+	rtest.Equals(t, string(data), loadFileContent(t, repo, id, "/a"))
+}
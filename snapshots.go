@@ -0,0 +1,110 @@
+package rapi
+
+import (
+	"context"
+	"time"
+
+	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/restic"
+)
+
+// Snapshots returns all snapshots matching filter, grouped by the criteria
+// in groupBy. Without grouping criteria, a single group is returned.
+func Snapshots(ctx context.Context, repo restic.Repository, filter restic.SnapshotFilter, groupBy restic.SnapshotGroupByOptions) ([]restic.SnapshotGroup, error) {
+	var snapshots restic.Snapshots
+	err := filter.FindAll(ctx, repo, repo, nil, func(_ string, sn *restic.Snapshot, err error) error {
+		if err != nil {
+			return err
+		}
+		snapshots = append(snapshots, sn)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return restic.GroupSnapshotList(snapshots, groupBy)
+}
+
+// ForgetOptions configure which snapshots are removed by Forget.
+type ForgetOptions struct {
+	// Policy is applied to each group separately.
+	Policy  restic.ExpirePolicy
+	GroupBy restic.SnapshotGroupByOptions
+	Filter  restic.SnapshotFilter
+
+	// DryRun only reports which snapshots would be removed.
+	DryRun bool
+
+	// TrashRetention moves the removed snapshots to the trash instead of
+	// deleting them, see Undelete. Entries older than TrashRetention are
+	// removed from the trash afterwards. Zero deletes snapshots immediately.
+	TrashRetention time.Duration
+}
+
+// ForgetGroup is the result of applying the policy to a group of snapshots.
+type ForgetGroup struct {
+	Key     restic.SnapshotGroupKey `json:"group_key"`
+	Keep    restic.Snapshots        `json:"keep"`
+	Remove  restic.Snapshots        `json:"remove"`
+	Reasons []restic.KeepReason     `json:"reasons"`
+}
+
+// Forget applies the policy in opts to each group of snapshots and removes
+// the snapshots which are not kept, like `restic forget`. The data referenced
+// by the removed snapshots stays in the repository until it is pruned.
+func Forget(ctx context.Context, repo restic.Repository, opts ForgetOptions) ([]ForgetGroup, error) {
+	if !opts.DryRun {
+		unlock, err := lockRepository(ctx, repo, true)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+	}
+
+	groups, err := Snapshots(ctx, repo, opts.Filter, opts.GroupBy)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]ForgetGroup, 0, len(groups))
+	for _, group := range groups {
+		keep, remove, reasons := restic.ApplyPolicyAt(group.Snapshots, opts.Policy, restic.Now(ctx))
+		result = append(result, ForgetGroup{
+			Key:     group.Key,
+			Keep:    keep,
+			Remove:  remove,
+			Reasons: reasons,
+		})
+	}
+
+	if opts.DryRun {
+		return result, nil
+	}
+
+	if opts.TrashRetention > 0 {
+		now := restic.Now(ctx)
+		for _, group := range result {
+			for _, sn := range group.Remove {
+				if err := moveToTrash(ctx, repo, *sn.ID(), now); err != nil {
+					return result, err
+				}
+			}
+		}
+		_, err := emptyTrash(ctx, repo, now.Add(-opts.TrashRetention))
+		return result, err
+	}
+
+	for _, group := range result {
+		for _, sn := range group.Remove {
+			h := backend.Handle{Type: restic.SnapshotFile, Name: sn.ID().String()}
+			if err := repo.Backend().Remove(ctx, h); err != nil {
+				return result, err
+			}
+			debug.Log("removed snapshot %v", sn.ID().Str())
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,76 @@
+package rapi_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/konidev20/rapi"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/restic"
+)
+
+func saveTestSnapshots(t *testing.T, repo restic.Repository, hosts []string, n int) restic.IDSet {
+	ids := restic.NewIDSet()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, host := range hosts {
+		for i := 0; i < n; i++ {
+			sn, err := restic.NewSnapshot([]string{"/data"}, nil, host, start.Add(time.Duration(i)*time.Hour))
+			rtest.OK(t, err)
+			sn.Tree = &restic.ID{}
+			id, err := restic.SaveSnapshot(context.TODO(), repo, sn)
+			rtest.OK(t, err)
+			ids.Insert(id)
+		}
+	}
+	return ids
+}
+
+func TestForget(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.TestRepository(t)
+	saveTestSnapshots(t, repo, []string{"a", "b"}, 3)
+
+	opts := rapi.ForgetOptions{
+		Policy:  restic.ExpirePolicy{Last: 1},
+		GroupBy: restic.SnapshotGroupByOptions{Host: true},
+		DryRun:  true,
+	}
+
+	groups, err := rapi.Forget(ctx, repo, opts)
+	rtest.OK(t, err)
+	rtest.Equals(t, 2, len(groups))
+	for _, group := range groups {
+		rtest.Equals(t, 1, len(group.Keep))
+		rtest.Equals(t, 2, len(group.Remove))
+		rtest.Equals(t, group.Key.Hostname, group.Keep[0].Hostname)
+		for _, sn := range group.Remove {
+			rtest.Assert(t, sn.Time.Before(group.Keep[0].Time), "newer snapshot %v removed", sn.ID().Str())
+		}
+	}
+
+	snapshots, err := restic.TestLoadAllSnapshots(ctx, repo, nil)
+	rtest.OK(t, err)
+	rtest.Equals(t, 6, len(snapshots))
+
+	opts.DryRun = false
+	groups, err = rapi.Forget(ctx, repo, opts)
+	rtest.OK(t, err)
+
+	kept := restic.NewIDSet()
+	for _, group := range groups {
+		for _, sn := range group.Keep {
+			kept.Insert(*sn.ID())
+		}
+	}
+	rtest.Equals(t, 2, len(kept))
+
+	snapshots, err = restic.TestLoadAllSnapshots(ctx, repo, nil)
+	rtest.OK(t, err)
+	remaining := restic.NewIDSet()
+	for _, sn := range snapshots {
+		remaining.Insert(*sn.ID())
+	}
+	rtest.Equals(t, kept, remaining)
+}
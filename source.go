@@ -0,0 +1,144 @@
+package rapi
+
+import (
+	"context"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/internal/fs"
+	"github.com/minio/minio-go/v7"
+)
+
+// Source is a tree of files to back up which is not necessarily stored in a
+// local file system. Applications can implement it to back up virtual trees
+// directly, no files have to be written to disk first.
+type Source = fs.Source
+
+// LocalSource is a Source for the local file system.
+type LocalSource = fs.LocalSource
+
+// NewIOFSSource returns a Source for a file system from the io/fs package,
+// for example fstest.MapFS.
+func NewIOFSSource(fsys iofs.FS) Source {
+	return fs.IOFSSource{FS: fsys}
+}
+
+// S3Source is a Source for the objects in an S3 bucket below a prefix. The
+// slashes in the object keys form the directories, directories have no
+// metadata of their own.
+type S3Source struct {
+	ctx    context.Context
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// statically ensure that S3Source implements Source.
+var _ Source = &S3Source{}
+
+// NewS3Source returns a Source for the objects in bucket below prefix. The
+// context is used for all requests.
+func NewS3Source(ctx context.Context, client *minio.Client, bucket, prefix string) *S3Source {
+	return &S3Source{
+		ctx:    ctx,
+		client: client,
+		bucket: bucket,
+		prefix: strings.Trim(prefix, "/"),
+	}
+}
+
+func (s *S3Source) key(name string) string {
+	return strings.TrimPrefix(path.Join(s.prefix, path.Clean("/"+name)), "/")
+}
+
+// Open returns the contents of the object name.
+func (s *S3Source) Open(name string) (io.ReadCloser, error) {
+	obj, err := s.client.GetObject(s.ctx, s.bucket, s.key(name), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "GetObject")
+	}
+	return obj, nil
+}
+
+// Readdir returns the objects and common prefixes directly below name.
+func (s *S3Source) Readdir(name string) ([]os.FileInfo, error) {
+	prefix := s.key(name)
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	var list []os.FileInfo
+	for obj := range s.client.ListObjects(s.ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix}) {
+		if obj.Err != nil {
+			return nil, errors.Wrap(obj.Err, "ListObjects")
+		}
+		entry := strings.TrimPrefix(obj.Key, prefix)
+		if strings.HasSuffix(entry, "/") {
+			list = append(list, s3FileInfo{name: strings.TrimSuffix(entry, "/"), mode: os.ModeDir | 0755})
+			continue
+		}
+		if entry == "" {
+			continue
+		}
+		list = append(list, s3FileInfo{name: entry, size: obj.Size, mode: 0644, modTime: obj.LastModified})
+	}
+	return list, nil
+}
+
+// Lstat describes the object or directory name.
+func (s *S3Source) Lstat(name string) (os.FileInfo, error) {
+	key := s.key(name)
+	if key != "" {
+		obj, err := s.client.StatObject(s.ctx, s.bucket, key, minio.StatObjectOptions{})
+		if err == nil {
+			return s3FileInfo{name: path.Base(key), size: obj.Size, mode: 0644, modTime: obj.LastModified}, nil
+		}
+		if minio.ToErrorResponse(err).Code != "NoSuchKey" {
+			return nil, errors.Wrap(err, "StatObject")
+		}
+	}
+
+	// a directory exists if there is at least one object below it
+	prefix := key
+	if prefix != "" {
+		prefix += "/"
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+	for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix, MaxKeys: 1}) {
+		if obj.Err != nil {
+			return nil, errors.Wrap(obj.Err, "ListObjects")
+		}
+		return s3FileInfo{name: path.Base("/" + key), mode: os.ModeDir | 0755}, nil
+	}
+	if key == "" {
+		// the root of an empty bucket
+		return s3FileInfo{name: "/", mode: os.ModeDir | 0755}, nil
+	}
+	return nil, &os.PathError{Op: "lstat", Path: name, Err: os.ErrNotExist}
+}
+
+// Readlink is not supported, S3 has no symlinks.
+func (s *S3Source) Readlink(name string) (string, error) {
+	return "", &os.PathError{Op: "readlink", Path: name, Err: errors.New("not supported")}
+}
+
+// s3FileInfo describes an object or a directory in a bucket.
+type s3FileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (fi s3FileInfo) Name() string       { return fi.name }
+func (fi s3FileInfo) Size() int64        { return fi.size }
+func (fi s3FileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi s3FileInfo) ModTime() time.Time { return fi.modTime }
+func (fi s3FileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi s3FileInfo) Sys() interface{}   { return nil }
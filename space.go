@@ -0,0 +1,158 @@
+package rapi
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/restic"
+)
+
+// SnapshotSpace is the space used by a snapshot, by the stored size of the
+// blobs including duplicates.
+type SnapshotSpace struct {
+	ID       restic.ID `json:"id"`
+	Time     time.Time `json:"time"`
+	Hostname string    `json:"hostname"`
+	Paths    []string  `json:"paths"`
+
+	// Referenced counts all blobs of the snapshot.
+	Referenced BlobCount `json:"referenced"`
+
+	// Introduced counts the blobs which the snapshot is the oldest one to
+	// reference, that is the data it added to the repository.
+	Introduced BlobCount `json:"introduced"`
+
+	// Unique counts the blobs which no other snapshot references, that is
+	// the data freed by forgetting only this snapshot and pruning.
+	Unique BlobCount `json:"unique"`
+}
+
+// SpaceReport attributes the space used by the blobs to the snapshots.
+type SpaceReport struct {
+	// Snapshots are sorted by time, the oldest first.
+	Snapshots []SnapshotSpace `json:"snapshots"`
+
+	// Referenced counts the blobs referenced by any snapshot, Shared those
+	// referenced by several snapshots, including snapshots in the trash.
+	Referenced BlobCount `json:"referenced"`
+	Shared     BlobCount `json:"shared"`
+}
+
+// blobOwner records the snapshots referencing a blob, the indexes refer to
+// the sorted snapshots.
+type blobOwner struct {
+	// first is the oldest snapshot referencing the blob, only is the only
+	// snapshot referencing it or -1 if there are several.
+	first, only int32
+}
+
+// ownerTrash marks blobs referenced by snapshots in the trash.
+const ownerTrash = -2
+
+// AttributeSpace reports for each snapshot which blobs it introduced and which
+// blobs only it references, like `restic stats --mode raw-data` per snapshot.
+// Snapshots in the trash are not reported, but their blobs are shared with
+// the snapshots referencing them as well, as prune keeps them. The snapshots
+// are traversed one after another. Apart from a non-exclusive lock, the
+// repository is not modified.
+func AttributeSpace(ctx context.Context, repo restic.Repository) (SpaceReport, error) {
+	var report SpaceReport
+
+	unlock, err := lockRepository(ctx, repo, false)
+	if err != nil {
+		return report, err
+	}
+	defer unlock()
+
+	if err := repo.LoadIndex(ctx, nil); err != nil {
+		return report, err
+	}
+
+	var snapshots restic.Snapshots
+	err = restic.ForAllSnapshots(ctx, repo, repo, nil, func(id restic.ID, sn *restic.Snapshot, err error) error {
+		if err != nil {
+			return err
+		}
+		if sn.Tree == nil {
+			return errors.Fatalf("snapshot %v has no tree", id.Str())
+		}
+		snapshots = append(snapshots, sn)
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+	// snapshots with the same time are ordered by ID, so that the report
+	// does not depend on the order of listing
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		if snapshots[i].Time.Equal(snapshots[j].Time) {
+			return snapshots[i].ID().String() < snapshots[j].ID().String()
+		}
+		return snapshots[i].Time.Before(snapshots[j].Time)
+	})
+
+	idx := repo.Index()
+	size := func(h restic.BlobHandle) uint {
+		var size uint
+		for _, pb := range idx.Lookup(h) {
+			size += pb.Length
+		}
+		return size
+	}
+
+	owners := make(map[restic.BlobHandle]blobOwner)
+	report.Snapshots = make([]SnapshotSpace, len(snapshots))
+	for i, sn := range snapshots {
+		blobs := restic.NewBlobSet()
+		if err := restic.FindUsedBlobs(ctx, repo, restic.IDs{*sn.Tree}, blobs, nil); err != nil {
+			return report, err
+		}
+
+		space := &report.Snapshots[i]
+		space.ID, space.Time, space.Hostname, space.Paths = *sn.ID(), sn.Time, sn.Hostname, sn.Paths
+		for h := range blobs {
+			space.Referenced.add(size(h))
+
+			o, ok := owners[h]
+			if !ok {
+				owners[h] = blobOwner{first: int32(i), only: int32(i)}
+				continue
+			}
+			o.only = -1
+			owners[h] = o
+		}
+		debug.Log("snapshot %v references %d blobs", sn.ID().Str(), len(blobs))
+	}
+
+	trashed, err := trashedTrees(ctx, repo)
+	if err != nil {
+		return report, err
+	}
+	if len(trashed) > 0 {
+		blobs := restic.NewBlobSet()
+		if err := restic.FindUsedBlobs(ctx, repo, trashed, blobs, nil); err != nil {
+			return report, err
+		}
+		for h := range blobs {
+			if o, ok := owners[h]; ok {
+				o.only = ownerTrash
+				owners[h] = o
+			}
+		}
+	}
+
+	for h, o := range owners {
+		s := size(h)
+		report.Referenced.add(s)
+		report.Snapshots[o.first].Introduced.add(s)
+		if o.only >= 0 {
+			report.Snapshots[o.only].Unique.add(s)
+		} else {
+			report.Shared.add(s)
+		}
+	}
+	return report, ctx.Err()
+}
@@ -0,0 +1,47 @@
+package rapi_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/konidev20/rapi"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/restic"
+)
+
+func TestAttributeSpace(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.TestRepository(t)
+
+	_, blobs := savePack(t, repo, 1, 2, 3)
+	snB := saveSnapshotOf(t, repo, time.Now().Add(-time.Hour), restic.IDs{blobs[1], blobs[2]})
+	snA := saveSnapshotOf(t, repo, time.Now().Add(-2*time.Hour), restic.IDs{blobs[0], blobs[1]})
+
+	report, err := rapi.AttributeSpace(ctx, repo)
+	rtest.OK(t, err)
+	rtest.Equals(t, 2, len(report.Snapshots))
+	a, b := report.Snapshots[0], report.Snapshots[1]
+	rtest.Equals(t, snA, a.ID)
+	rtest.Equals(t, snB, b.ID)
+
+	// each snapshot references its tree and two data blobs, the older one
+	// introduced all of them
+	rtest.Equals(t, 3, a.Referenced.Blobs)
+	rtest.Equals(t, 3, b.Referenced.Blobs)
+	rtest.Equals(t, a.Referenced, a.Introduced)
+	rtest.Equals(t, 2, b.Introduced.Blobs)
+	rtest.Equals(t, 2, a.Unique.Blobs)
+	rtest.Equals(t, 2, b.Unique.Blobs)
+
+	rtest.Equals(t, 5, report.Referenced.Blobs)
+	rtest.Equals(t, 1, report.Shared.Blobs)
+	rtest.Equals(t, report.Referenced.Bytes, a.Introduced.Bytes+b.Introduced.Bytes)
+	rtest.Equals(t, report.Referenced.Bytes, a.Unique.Bytes+b.Unique.Bytes+report.Shared.Bytes)
+
+	// the unique data is exactly what forgetting the snapshot frees
+	estimate, err := rapi.EstimateForget(ctx, repo, restic.IDs{snA})
+	rtest.OK(t, err)
+	rtest.Equals(t, estimate.Bytes(), a.Unique.Bytes)
+}
@@ -0,0 +1,117 @@
+package rapi
+
+import (
+	"context"
+	"sync"
+
+	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/restic"
+)
+
+// tagMu serializes tag changes within this process. Changes by other
+// processes are detected on a best-effort basis before the old snapshot is
+// removed.
+var tagMu sync.Mutex
+
+// AddTags adds tags to the snapshot id. As the ID of a snapshot is the hash
+// of its contents, the snapshot is rewritten and the ID of the new snapshot is
+// returned. If the snapshot already has all tags, it is left unchanged and id
+// is returned.
+func AddTags(ctx context.Context, repo restic.Repository, id restic.ID, tags []string) (restic.ID, error) {
+	return changeTags(ctx, repo, id, func(sn *restic.Snapshot) bool {
+		return sn.AddTags(tags)
+	})
+}
+
+// RemoveTags removes tags from the snapshot id, see AddTags.
+func RemoveTags(ctx context.Context, repo restic.Repository, id restic.ID, tags []string) (restic.ID, error) {
+	return changeTags(ctx, repo, id, func(sn *restic.Snapshot) bool {
+		return sn.RemoveTags(tags)
+	})
+}
+
+// SetTags replaces all tags of the snapshot id with tags, see AddTags.
+func SetTags(ctx context.Context, repo restic.Repository, id restic.ID, tags []string) (restic.ID, error) {
+	return changeTags(ctx, repo, id, func(sn *restic.Snapshot) bool {
+		if equalTags(sn.Tags, tags) {
+			return false
+		}
+		sn.Tags = append([]string(nil), tags...)
+		return true
+	})
+}
+
+// changeTags applies change to the snapshot id and, if it returns true,
+// replaces the snapshot. The new snapshot is saved before the old one is
+// removed, so the snapshot is never lost if the operation is interrupted.
+func changeTags(ctx context.Context, repo restic.Repository, id restic.ID, change func(sn *restic.Snapshot) bool) (restic.ID, error) {
+	tagMu.Lock()
+	defer tagMu.Unlock()
+
+	unlock, err := lockRepository(ctx, repo, false)
+	if err != nil {
+		return restic.ID{}, err
+	}
+	defer unlock()
+
+	sn, err := restic.LoadSnapshot(ctx, repo, id)
+	if err != nil {
+		return restic.ID{}, err
+	}
+
+	if !change(sn) {
+		debug.Log("tags of snapshot %v are unchanged", id.Str())
+		return id, nil
+	}
+
+	// retain the original snapshot id over all tag changes
+	if sn.Original == nil {
+		sn.Original = &id
+	}
+
+	newID, err := restic.SaveSnapshot(ctx, repo, sn)
+	if err != nil {
+		return restic.ID{}, err
+	}
+
+	// if the old snapshot was removed in the meantime, another process
+	// changed or forgot it, so discard our copy. This check is best-effort
+	// only: the backends offer no atomic replace, so a concurrent change
+	// between the check and the removal below still goes unnoticed.
+	// If the check itself fails, the old snapshot most likely still exists,
+	// but our copy is discarded as well.
+	h := backend.Handle{Type: restic.SnapshotFile, Name: id.String()}
+	_, err = repo.Backend().Stat(ctx, h)
+	if err != nil {
+		rmErr := repo.Backend().Remove(ctx, backend.Handle{Type: restic.SnapshotFile, Name: newID.String()})
+		if rmErr != nil {
+			debug.Log("unable to remove snapshot %v: %v", newID.Str(), rmErr)
+		}
+		if repo.Backend().IsNotExist(err) {
+			return restic.ID{}, errors.Wrapf(err, "snapshot %v was modified concurrently", id.Str())
+		}
+		return restic.ID{}, err
+	}
+
+	// removing through the repository backend also drops the cached file
+	if err = repo.Backend().Remove(ctx, h); err != nil {
+		return restic.ID{}, err
+	}
+
+	debug.Log("new snapshot %v replaces %v", newID.Str(), id.Str())
+	return newID, nil
+}
+
+func equalTags(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,89 @@
+package rapi_test
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/konidev20/rapi"
+	"github.com/konidev20/rapi/backend"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/restic"
+)
+
+func TestTags(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.TestRepository(t)
+	sn := restic.TestCreateSnapshot(t, repo, time.Now(), 1)
+	origID := *sn.ID()
+
+	checkTags := func(id restic.ID, tags []string) {
+		t.Helper()
+		snapshots, err := restic.TestLoadAllSnapshots(ctx, repo, nil)
+		rtest.OK(t, err)
+		rtest.Equals(t, 1, len(snapshots))
+		rtest.Equals(t, id, *snapshots[0].ID())
+		got := append([]string(nil), snapshots[0].Tags...)
+		sort.Strings(got)
+		sort.Strings(tags)
+		rtest.Equals(t, tags, got)
+		if id != origID {
+			rtest.Equals(t, origID, *snapshots[0].Original)
+		}
+	}
+
+	id, err := rapi.AddTags(ctx, repo, origID, []string{"foo", "bar"})
+	rtest.OK(t, err)
+	rtest.Assert(t, id != origID, "snapshot was not replaced")
+	checkTags(id, []string{"test", "foo", "bar"})
+
+	// adding existing tags leaves the snapshot unchanged
+	unchanged, err := rapi.AddTags(ctx, repo, id, []string{"foo"})
+	rtest.OK(t, err)
+	rtest.Equals(t, id, unchanged)
+
+	id, err = rapi.RemoveTags(ctx, repo, id, []string{"test"})
+	rtest.OK(t, err)
+	checkTags(id, []string{"foo", "bar"})
+
+	id, err = rapi.SetTags(ctx, repo, id, []string{"baz"})
+	rtest.OK(t, err)
+	checkTags(id, []string{"baz"})
+
+	unchanged, err = rapi.SetTags(ctx, repo, id, []string{"baz"})
+	rtest.OK(t, err)
+	rtest.Equals(t, id, unchanged)
+
+	_, err = rapi.AddTags(ctx, repo, origID, []string{"foo"})
+	rtest.Assert(t, err != nil, "changing a removed snapshot did not fail")
+}
+
+// statErrorBackend fails to stat snapshot files.
+type statErrorBackend struct {
+	backend.Backend
+}
+
+func (be statErrorBackend) Stat(ctx context.Context, h backend.Handle) (backend.FileInfo, error) {
+	if h.Type == restic.SnapshotFile {
+		return backend.FileInfo{}, errors.New("stat failed")
+	}
+	return be.Backend.Stat(ctx, h)
+}
+
+func TestTagsStatError(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.TestRepositoryWithBackend(t, statErrorBackend{repository.TestBackend(t)}, 0)
+	sn := restic.TestCreateSnapshot(t, repo, time.Now(), 1)
+
+	_, err := rapi.AddTags(ctx, repo, *sn.ID(), []string{"foo"})
+	rtest.Assert(t, err != nil, "tags were changed although stat failed")
+
+	// the original snapshot is kept, the new one is removed
+	snapshots, err := restic.TestLoadAllSnapshots(ctx, repo, nil)
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, len(snapshots))
+	rtest.Equals(t, *sn.ID(), *snapshots[0].ID())
+}
@@ -0,0 +1,100 @@
+package testrepo
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+
+	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/internal/errors"
+)
+
+// Corruption damages a randomly selected file of the repository.
+type Corruption int
+
+// Supported corruptions.
+const (
+	// FlipPackByte flips a byte in a pack file, so that a blob cannot be
+	// decrypted.
+	FlipPackByte Corruption = iota
+	// RemovePack removes a pack file.
+	RemovePack
+	// TruncatePack removes the second half of a pack file, including its
+	// header.
+	TruncatePack
+	// FlipIndexByte flips a byte in an index file.
+	FlipIndexByte
+	// RemoveSnapshot removes a snapshot file.
+	RemoveSnapshot
+)
+
+func (c Corruption) String() string {
+	switch c {
+	case FlipPackByte:
+		return "flip-pack-byte"
+	case RemovePack:
+		return "remove-pack"
+	case TruncatePack:
+		return "truncate-pack"
+	case FlipIndexByte:
+		return "flip-index-byte"
+	case RemoveSnapshot:
+		return "remove-snapshot"
+	}
+	return "unknown"
+}
+
+func (c Corruption) fileType() backend.FileType {
+	switch c {
+	case FlipIndexByte:
+		return backend.IndexFile
+	case RemoveSnapshot:
+		return backend.SnapshotFile
+	}
+	return backend.PackFile
+}
+
+// apply damages a file selected by rnd and returns it.
+func (c Corruption) apply(ctx context.Context, be backend.Backend, rnd *rand.Rand) (backend.Handle, error) {
+	t := c.fileType()
+
+	var names []string
+	err := be.List(ctx, t, func(fi backend.FileInfo) error {
+		names = append(names, fi.Name)
+		return nil
+	})
+	if err != nil {
+		return backend.Handle{}, err
+	}
+	if len(names) == 0 {
+		return backend.Handle{}, errors.Errorf("no %v files", t)
+	}
+
+	// sort for deterministic results, List returns the files in any order
+	sort.Strings(names)
+	h := backend.Handle{Type: t, Name: names[rnd.Intn(len(names))]}
+
+	switch c {
+	case RemovePack, RemoveSnapshot:
+		return h, be.Remove(ctx, h)
+	}
+
+	buf, err := backend.LoadAll(ctx, nil, be, h)
+	if err != nil {
+		return backend.Handle{}, err
+	}
+
+	switch c {
+	case FlipPackByte, FlipIndexByte:
+		buf[rnd.Intn(len(buf))] ^= 0xff
+	case TruncatePack:
+		buf = buf[:len(buf)/2]
+	default:
+		return backend.Handle{}, errors.Errorf("unknown corruption %d", int(c))
+	}
+
+	if err := be.Remove(ctx, h); err != nil {
+		return backend.Handle{}, err
+	}
+	return h, be.Save(ctx, h, backend.NewByteReader(buf, be.Hasher()))
+}
@@ -0,0 +1,34 @@
+package testrepo
+
+import (
+	"math"
+	"math/rand"
+)
+
+// SizeDistribution returns the size of a new file.
+type SizeDistribution func(rnd *rand.Rand) int64
+
+// FixedSize returns a distribution where all files have the given size.
+func FixedSize(size int64) SizeDistribution {
+	return func(_ *rand.Rand) int64 {
+		return size
+	}
+}
+
+// UniformSize returns a distribution of sizes between min and max, inclusive.
+func UniformSize(min, max int64) SizeDistribution {
+	return func(rnd *rand.Rand) int64 {
+		return min + rnd.Int63n(max-min+1)
+	}
+}
+
+// LogNormalSize returns a log-normal distribution of sizes, which resembles
+// the sizes of files in typical directory trees: most files are small, but a
+// few are much larger. Half of the files are smaller than median, sigma
+// controls the spread.
+func LogNormalSize(median int64, sigma float64) SizeDistribution {
+	mu := math.Log(float64(median))
+	return func(rnd *rand.Rand) int64 {
+		return int64(math.Exp(mu + sigma*rnd.NormFloat64()))
+	}
+}
@@ -0,0 +1,195 @@
+// Package testrepo generates synthetic repositories for tests and benchmarks.
+// The contents are derived from a seed, so the same options always produce
+// the same files and snapshots. Snapshots can share a configurable fraction
+// of their files to simulate deduplication, and files of the repository can
+// be damaged deliberately to test error handling.
+package testrepo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/konidev20/rapi"
+	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/restic"
+)
+
+// Options describe the generated repository.
+type Options struct {
+	// Seed selects the random contents.
+	Seed int64
+
+	// Snapshots is the number of snapshots, the default is one.
+	Snapshots int
+	// Files is the number of files in each snapshot, the default is 10.
+	Files int
+	// FilesPerDir is the number of files in each directory, the default
+	// is 100.
+	FilesPerDir int
+	// FileSize returns the size of new files, the default is
+	// LogNormalSize(64KiB, 1.5).
+	FileSize SizeDistribution
+
+	// DedupRatio is the fraction of files which are unchanged from the
+	// previous snapshot, between 0 and 1.
+	DedupRatio float64
+
+	// Hostname and Start are used for the snapshots, which are taken one
+	// day apart. Start defaults to 2020-01-01 UTC.
+	Hostname string
+	Start    time.Time
+
+	// Corruptions are applied after all snapshots were saved.
+	Corruptions []Corruption
+}
+
+func (opts Options) withDefaults() Options {
+	if opts.Snapshots == 0 {
+		opts.Snapshots = 1
+	}
+	if opts.Files == 0 {
+		opts.Files = 10
+	}
+	if opts.FilesPerDir == 0 {
+		opts.FilesPerDir = 100
+	}
+	if opts.FileSize == nil {
+		opts.FileSize = LogNormalSize(64*1024, 1.5)
+	}
+	if opts.Hostname == "" {
+		opts.Hostname = "testrepo"
+	}
+	if opts.Start.IsZero() {
+		opts.Start = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return opts
+}
+
+// Result describes the generated repository.
+type Result struct {
+	// Snapshots are the IDs of the snapshots, oldest first.
+	Snapshots restic.IDs
+	// Bytes is the total size of the files in all snapshots.
+	Bytes uint64
+	// Corrupted lists the files damaged by the corruptions.
+	Corrupted []backend.Handle
+}
+
+// Create initializes a new repository with the given password in be and
+// fills it as described by opts.
+func Create(ctx context.Context, be backend.Backend, password string, opts Options) (*repository.Repository, Result, error) {
+	repo, err := repository.New(be, repository.Options{})
+	if err != nil {
+		return nil, Result{}, err
+	}
+
+	if err := repo.Init(ctx, restic.StableRepoVersion, password, nil); err != nil {
+		return nil, Result{}, err
+	}
+
+	res, err := Generate(ctx, repo, opts)
+	if err != nil {
+		return nil, Result{}, err
+	}
+	return repo, res, nil
+}
+
+// file is a generated file, its contents are derived from seed.
+type file struct {
+	seed int64
+	size int64
+}
+
+// Generate adds the snapshots described by opts to repo, whose index must be
+// loaded, and applies the corruptions afterwards.
+func Generate(ctx context.Context, repo restic.Repository, opts Options) (Result, error) {
+	opts = opts.withDefaults()
+	if opts.DedupRatio < 0 || opts.DedupRatio > 1 {
+		return Result{}, errors.Errorf("invalid dedup ratio %v", opts.DedupRatio)
+	}
+
+	rnd := rand.New(rand.NewSource(opts.Seed))
+	var res Result
+
+	files := make([]file, opts.Files)
+	for i := 0; i < opts.Snapshots; i++ {
+		for j := range files {
+			if i > 0 && rnd.Float64() < opts.DedupRatio {
+				continue
+			}
+			files[j] = file{seed: rnd.Int63(), size: opts.FileSize(rnd)}
+		}
+
+		ts := opts.Start.Add(time.Duration(i) * 24 * time.Hour)
+		id, size, err := saveSnapshot(ctx, repo, files, opts, ts)
+		if err != nil {
+			return Result{}, err
+		}
+		res.Snapshots = append(res.Snapshots, id)
+		res.Bytes += size
+	}
+
+	for _, c := range opts.Corruptions {
+		h, err := c.apply(ctx, repo.Backend(), rnd)
+		if err != nil {
+			return Result{}, fmt.Errorf("corruption %v failed: %w", c, err)
+		}
+		res.Corrupted = append(res.Corrupted, h)
+	}
+
+	debug.Log("generated %d snapshots with %d bytes, %d corruptions", len(res.Snapshots), res.Bytes, len(res.Corrupted))
+	return res, nil
+}
+
+func saveSnapshot(ctx context.Context, repo restic.Repository, files []file, opts Options, ts time.Time) (restic.ID, uint64, error) {
+	b, err := rapi.NewSnapshotBuilder(ctx, repo)
+	if err != nil {
+		return restic.ID{}, 0, err
+	}
+
+	var size uint64
+	for i, f := range files {
+		dir := fmt.Sprintf("/dir-%06d", i/opts.FilesPerDir)
+		if i%opts.FilesPerDir == 0 {
+			if err := b.AddDir(dir, newNode(os.ModeDir|0755, ts)); err != nil {
+				b.Abort()
+				return restic.ID{}, 0, err
+			}
+		}
+
+		p := fmt.Sprintf("%s/file-%06d", dir, i)
+		rd := io.LimitReader(rand.New(rand.NewSource(f.seed)), f.size)
+		if err := b.AddFile(p, newNode(0644, ts), rd); err != nil {
+			b.Abort()
+			return restic.ID{}, 0, err
+		}
+		size += uint64(f.size)
+	}
+
+	sn, err := restic.NewSnapshot([]string{"/"}, nil, opts.Hostname, ts)
+	if err != nil {
+		b.Abort()
+		return restic.ID{}, 0, err
+	}
+
+	id, err := b.Finish(sn)
+	return id, size, err
+}
+
+func newNode(mode os.FileMode, ts time.Time) *restic.Node {
+	return &restic.Node{
+		Mode:       mode,
+		ModTime:    ts,
+		AccessTime: ts,
+		ChangeTime: ts,
+		UID:        uint32(os.Getuid()),
+		GID:        uint32(os.Getgid()),
+	}
+}
@@ -0,0 +1,99 @@
+package testrepo_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/konidev20/rapi"
+	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/backend/mem"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/restic"
+	"github.com/konidev20/rapi/testrepo"
+)
+
+func create(t *testing.T, opts testrepo.Options) (restic.Repository, testrepo.Result) {
+	repository.TestUseLowSecurityKDFParameters(t)
+
+	repo, res, err := testrepo.Create(context.TODO(), mem.New(), rtest.TestPassword, opts)
+	rtest.OK(t, err)
+	return repo, res
+}
+
+func countFiles(t *testing.T, repo restic.Repository, tpe backend.FileType) int {
+	n := 0
+	rtest.OK(t, repo.Backend().List(context.TODO(), tpe, func(backend.FileInfo) error {
+		n++
+		return nil
+	}))
+	return n
+}
+
+func TestGenerate(t *testing.T) {
+	opts := testrepo.Options{
+		Seed:        42,
+		Snapshots:   3,
+		Files:       25,
+		FilesPerDir: 10,
+		FileSize:    testrepo.UniformSize(0, 10000),
+		DedupRatio:  0.5,
+	}
+
+	repo, res := create(t, opts)
+	rtest.Equals(t, 3, len(res.Snapshots))
+	rtest.Equals(t, 3, countFiles(t, repo, backend.SnapshotFile))
+
+	sn, err := restic.LoadSnapshot(context.TODO(), repo, res.Snapshots[2])
+	rtest.OK(t, err)
+	rtest.Equals(t, "testrepo", sn.Hostname)
+
+	result, err := rapi.Check(context.TODO(), repo, rapi.CheckOptions{})
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, len(result.Errors))
+
+	// the same seed generates the same files
+	_, res2 := create(t, opts)
+	rtest.Equals(t, res.Bytes, res2.Bytes)
+}
+
+func TestDedupRatio(t *testing.T) {
+	opts := testrepo.Options{
+		Snapshots:  4,
+		Files:      10,
+		FileSize:   testrepo.FixedSize(1000),
+		DedupRatio: 1,
+	}
+
+	repo, res := create(t, opts)
+	rtest.Equals(t, uint64(4*10*1000), res.Bytes)
+	rtest.Equals(t, 10, countDataBlobs(repo))
+}
+
+func countDataBlobs(repo restic.Repository) int {
+	n := 0
+	repo.Index().Each(context.TODO(), func(pb restic.PackedBlob) {
+		if pb.Type == restic.DataBlob {
+			n++
+		}
+	})
+	return n
+}
+
+func TestCorruptions(t *testing.T) {
+	opts := testrepo.Options{
+		Snapshots:   2,
+		Files:       5,
+		FileSize:    testrepo.FixedSize(100),
+		Corruptions: []testrepo.Corruption{testrepo.RemovePack, testrepo.RemoveSnapshot},
+	}
+
+	repo, res := create(t, opts)
+	rtest.Equals(t, 2, len(res.Corrupted))
+	rtest.Equals(t, backend.PackFile, res.Corrupted[0].Type)
+	rtest.Equals(t, 1, countFiles(t, repo, backend.SnapshotFile))
+
+	result, err := rapi.Check(context.TODO(), repo, rapi.CheckOptions{})
+	rtest.OK(t, err)
+	rtest.Assert(t, len(result.Errors) > 0, "removed pack was not detected")
+}
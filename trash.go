@@ -0,0 +1,210 @@
+package rapi
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/restic"
+)
+
+// Snapshots removed by Forget with a TrashRetention are moved to the trash
+// instead of being deleted. A trash entry is a snapshot file with the fields
+// of the removed snapshot, the tag restic.TrashTag and the encrypted snapshot
+// file as is. While a snapshot is in the trash it can be restored with
+// Undelete, EmptyTrash removes the entries for good.
+//
+// As the entries are regular snapshot files, every backend can store them
+// and prune, including the one of restic, keeps the data they reference.
+// This library does not list them as snapshots. restic lists them with the
+// tag restic.TrashTag and may remove them with forget, the snapshot is then
+// no longer in the trash.
+
+// TrashEntry is a snapshot in the trash.
+type TrashEntry struct {
+	SnapshotID restic.ID `json:"snapshot_id"`
+	DeletedAt  time.Time `json:"deleted_at"`
+	// Name is the ID of the snapshot file holding the entry.
+	Name string `json:"-"`
+}
+
+// trashFile is the content of the snapshot file of a trash entry.
+type trashFile struct {
+	restic.Snapshot
+	Trash *trashInfo `json:"rapi_trash,omitempty"`
+}
+
+type trashInfo struct {
+	SnapshotID restic.ID `json:"snapshot_id"`
+	DeletedAt  time.Time `json:"deleted_at"`
+	// File is the encrypted snapshot file.
+	File []byte `json:"file"`
+}
+
+// moveToTrash moves the snapshot id to the trash. The caller must hold an
+// exclusive lock.
+func moveToTrash(ctx context.Context, repo restic.Repository, id restic.ID, now time.Time) error {
+	sn, err := restic.LoadSnapshot(ctx, repo, id)
+	if err != nil {
+		return err
+	}
+	be := repo.Backend()
+	h := backend.Handle{Type: restic.SnapshotFile, Name: id.String()}
+	buf, err := loadRawFile(ctx, be, h)
+	if err != nil {
+		return err
+	}
+
+	entry := trashFile{Snapshot: *sn, Trash: &trashInfo{SnapshotID: id, DeletedAt: now, File: buf}}
+	entry.AddTags([]string{restic.TrashTag})
+	if _, err := restic.SaveJSONUnpacked(ctx, repo, restic.SnapshotFile, &entry); err != nil {
+		return err
+	}
+	if err := be.Remove(ctx, h); err != nil {
+		return err
+	}
+	debug.Log("moved snapshot %v to the trash", id.Str())
+	return nil
+}
+
+// forAllTrash calls fn for each trash entry. It is guaranteed that fn is not
+// run concurrently.
+func forAllTrash(ctx context.Context, repo restic.Repository, fn func(restic.ID, *trashFile) error) error {
+	var m sync.Mutex
+	return restic.ParallelList(ctx, repo, restic.SnapshotFile, repo.Connections(), func(ctx context.Context, id restic.ID, _ int64) error {
+		var entry trashFile
+		if err := restic.LoadJSONUnpacked(ctx, repo, restic.SnapshotFile, id, &entry); err != nil {
+			return errors.Wrapf(err, "loading snapshot %v", id.Str())
+		}
+		if !entry.InTrash() || entry.Trash == nil {
+			return nil
+		}
+		m.Lock()
+		defer m.Unlock()
+		return fn(id, &entry)
+	})
+}
+
+// trashedTrees returns the trees of all snapshots in the trash.
+func trashedTrees(ctx context.Context, repo restic.Repository) (restic.IDs, error) {
+	var trees restic.IDs
+	err := forAllTrash(ctx, repo, func(id restic.ID, entry *trashFile) error {
+		if entry.Tree == nil {
+			return errors.Fatalf("snapshot %v in the trash has no tree", entry.Trash.SnapshotID.Str())
+		}
+		trees = append(trees, *entry.Tree)
+		return nil
+	})
+	return trees, err
+}
+
+// ListTrash returns the snapshots in the trash, oldest deletion first.
+func ListTrash(ctx context.Context, repo restic.Repository) ([]TrashEntry, error) {
+	var entries []TrashEntry
+	err := forAllTrash(ctx, repo, func(id restic.ID, entry *trashFile) error {
+		entries = append(entries, TrashEntry{
+			SnapshotID: entry.Trash.SnapshotID,
+			DeletedAt:  entry.Trash.DeletedAt,
+			Name:       id.String(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].DeletedAt.Equal(entries[j].DeletedAt) {
+			return entries[i].Name < entries[j].Name
+		}
+		return entries[i].DeletedAt.Before(entries[j].DeletedAt)
+	})
+	return entries, nil
+}
+
+// Undelete restores the snapshot id from the trash. If the snapshot was
+// trashed several times, the most recent entry is used and all entries are
+// removed.
+func Undelete(ctx context.Context, repo restic.Repository, id restic.ID) error {
+	unlock, err := lockRepository(ctx, repo, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	var found []string
+	var latest *trashInfo
+	err = forAllTrash(ctx, repo, func(entryID restic.ID, entry *trashFile) error {
+		if entry.Trash.SnapshotID != id {
+			return nil
+		}
+		found = append(found, entryID.String())
+		if latest == nil || entry.Trash.DeletedAt.After(latest.DeletedAt) {
+			latest = entry.Trash
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if latest == nil {
+		return errors.Fatalf("snapshot %v is not in the trash", id.Str())
+	}
+	if restic.RepositoryHashAlgorithm(repo).Sum(latest.File) != id {
+		return errors.Fatalf("trash entry of snapshot %v is damaged", id.Str())
+	}
+
+	be := repo.Backend()
+	h := backend.Handle{Type: restic.SnapshotFile, Name: id.String()}
+	if err := be.Save(ctx, h, backend.NewByteReader(latest.File, be.Hasher())); err != nil {
+		return err
+	}
+
+	for _, name := range found {
+		if err := be.Remove(ctx, backend.Handle{Type: restic.SnapshotFile, Name: name}); err != nil {
+			return err
+		}
+	}
+	debug.Log("restored snapshot %v from the trash", id.Str())
+	return nil
+}
+
+// EmptyTrash permanently removes the trash entries which were deleted more
+// than olderThan ago, zero removes all entries. It returns the number of
+// removed entries.
+func EmptyTrash(ctx context.Context, repo restic.Repository, olderThan time.Duration) (int, error) {
+	unlock, err := lockRepository(ctx, repo, true)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	return emptyTrash(ctx, repo, restic.Now(ctx).Add(-olderThan))
+}
+
+// emptyTrash removes the trash entries deleted before cutoff. The caller must
+// hold an exclusive lock.
+func emptyTrash(ctx context.Context, repo restic.Repository, cutoff time.Time) (int, error) {
+	entries, err := ListTrash(ctx, repo)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		if entry.DeletedAt.After(cutoff) {
+			continue
+		}
+		h := backend.Handle{Type: restic.SnapshotFile, Name: entry.Name}
+		if err := repo.Backend().Remove(ctx, h); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	debug.Log("removed %d trash entries", removed)
+	return removed, nil
+}
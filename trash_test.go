@@ -0,0 +1,196 @@
+package rapi_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/konidev20/rapi"
+	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/internal/index"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/restic"
+)
+
+// checkTreeLoadable verifies that all blobs referenced by tree can be loaded.
+func checkTreeLoadable(t *testing.T, repo restic.Repository, tree restic.ID) {
+	t.Helper()
+	ctx := context.Background()
+	rtest.OK(t, repo.LoadIndex(ctx, nil))
+	blobs := restic.NewBlobSet()
+	rtest.OK(t, restic.FindUsedBlobs(ctx, repo, restic.IDs{tree}, blobs, nil))
+	for bh := range blobs {
+		_, err := repo.LoadBlob(ctx, bh.Type, bh.ID, nil)
+		rtest.OK(t, err)
+	}
+}
+
+func TestTrash(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.TestRepository(t)
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var snapshots []*restic.Snapshot
+	for i := 0; i < 3; i++ {
+		snapshots = append(snapshots, restic.TestCreateSnapshot(t, repo, start.Add(time.Duration(i)*time.Hour), 2))
+	}
+
+	_, err := rapi.Forget(ctx, repo, rapi.ForgetOptions{
+		Policy:         restic.ExpirePolicy{Last: 1},
+		TrashRetention: time.Hour,
+	})
+	rtest.OK(t, err)
+
+	entries, err := rapi.ListTrash(ctx, repo)
+	rtest.OK(t, err)
+	rtest.Equals(t, 2, len(entries))
+	trashed := restic.NewIDSet(entries[0].SnapshotID, entries[1].SnapshotID)
+	rtest.Equals(t, restic.NewIDSet(*snapshots[0].ID(), *snapshots[1].ID()), trashed)
+
+	remaining, err := restic.TestLoadAllSnapshots(ctx, repo, nil)
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, len(remaining))
+
+	// prune keeps the data of trashed snapshots
+	opts := rapi.DefaultPruneOptions
+	opts.MaxUnusedPercent = 0
+	_, err = rapi.Prune(ctx, repo, opts)
+	rtest.OK(t, err)
+	for _, sn := range snapshots {
+		checkTreeLoadable(t, repo, *sn.Tree)
+	}
+
+	rtest.OK(t, rapi.Undelete(ctx, repo, *snapshots[0].ID()))
+	_, err = restic.LoadSnapshot(ctx, repo, *snapshots[0].ID())
+	rtest.OK(t, err)
+	rtest.Assert(t, rapi.Undelete(ctx, repo, *snapshots[0].ID()) != nil, "snapshot restored twice")
+
+	entries, err = rapi.ListTrash(ctx, repo)
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, len(entries))
+	rtest.Equals(t, *snapshots[1].ID(), entries[0].SnapshotID)
+
+	// recently trashed entries are kept
+	n, err := rapi.EmptyTrash(ctx, repo, time.Hour)
+	rtest.OK(t, err)
+	rtest.Equals(t, 0, n)
+
+	n, err = rapi.EmptyTrash(ctx, repo, 0)
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, n)
+
+	stats, err := rapi.Prune(ctx, repo, opts)
+	rtest.OK(t, err)
+	rtest.Assert(t, stats.RemovePacks+stats.RepackPacks > 0, "no data removed after emptying the trash: %+v", stats)
+	checkTreeLoadable(t, repo, *snapshots[0].Tree)
+	checkTreeLoadable(t, repo, *snapshots[2].Tree)
+}
+
+func TestForgetTrashRetention(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.TestRepository(t)
+	saveTestSnapshots(t, repo, []string{"host"}, 3)
+
+	forget := func() {
+		_, err := rapi.Forget(ctx, repo, rapi.ForgetOptions{
+			Policy:         restic.ExpirePolicy{Last: 2},
+			TrashRetention: time.Hour,
+		})
+		rtest.OK(t, err)
+	}
+
+	forget()
+	entries, err := rapi.ListTrash(ctx, repo)
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, len(entries))
+	old := entries[0]
+
+	// backdate the entry beyond the retention
+	oldID, err := restic.ParseID(old.Name)
+	rtest.OK(t, err)
+	var entry map[string]interface{}
+	rtest.OK(t, restic.LoadJSONUnpacked(ctx, repo, restic.SnapshotFile, oldID, &entry))
+	entry["rapi_trash"].(map[string]interface{})["deleted_at"] = time.Now().Add(-2 * time.Hour)
+	_, err = restic.SaveJSONUnpacked(ctx, repo, restic.SnapshotFile, entry)
+	rtest.OK(t, err)
+	rtest.OK(t, repo.Backend().Remove(ctx, backend.Handle{Type: restic.SnapshotFile, Name: old.Name}))
+
+	saveTestSnapshots(t, repo, []string{"other"}, 1)
+	forget()
+
+	// the expired entry was removed, the new one is kept
+	entries, err = rapi.ListTrash(ctx, repo)
+	rtest.OK(t, err)
+	rtest.Equals(t, 1, len(entries))
+	rtest.Assert(t, entries[0].SnapshotID != old.SnapshotID, "expired entry was not removed")
+}
+
+// pruneSnapshotFiles removes all blobs which are not referenced by any
+// snapshot file, like the prune of a client which knows nothing about the
+// trash. It returns the number of removed blobs.
+func pruneSnapshotFiles(t *testing.T, repo restic.Repository) int {
+	t.Helper()
+	ctx := context.Background()
+	rtest.OK(t, repo.LoadIndex(ctx, nil))
+
+	var trees restic.IDs
+	rtest.OK(t, repo.List(ctx, restic.SnapshotFile, func(id restic.ID, _ int64) error {
+		sn, err := restic.LoadSnapshot(ctx, repo, id)
+		if err != nil {
+			return err
+		}
+		trees = append(trees, *sn.Tree)
+		return nil
+	}))
+	used := restic.NewBlobSet()
+	rtest.OK(t, restic.FindUsedBlobs(ctx, repo, trees, used, nil))
+
+	packs := restic.NewIDSet()
+	removed := 0
+	repo.Index().Each(ctx, func(pb restic.PackedBlob) {
+		packs.Insert(pb.PackID)
+		if !used.Has(pb.BlobHandle) {
+			removed++
+		}
+	})
+
+	obsolete, err := repository.Repack(ctx, repo, repo, packs, used, nil)
+	rtest.OK(t, err)
+	obsoleteIndexes, err := index.RewriteAffected(ctx, repo, obsolete, nil)
+	rtest.OK(t, err)
+	for id := range obsoleteIndexes {
+		rtest.OK(t, repo.Backend().Remove(ctx, backend.Handle{Type: restic.IndexFile, Name: id.String()}))
+	}
+	for id := range obsolete {
+		rtest.OK(t, repo.Backend().Remove(ctx, backend.Handle{Type: restic.PackFile, Name: id.String()}))
+	}
+	return removed
+}
+
+func TestTrashForeignPrune(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.TestRepository(t)
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var snapshots []*restic.Snapshot
+	for i := 0; i < 3; i++ {
+		snapshots = append(snapshots, restic.TestCreateSnapshot(t, repo, start.Add(time.Duration(i)*time.Hour), 2))
+	}
+
+	// trash the oldest snapshot and delete the second one
+	_, err := rapi.Forget(ctx, repo, rapi.ForgetOptions{
+		Policy:         restic.ExpirePolicy{Last: 2},
+		TrashRetention: time.Hour,
+	})
+	rtest.OK(t, err)
+	rtest.OK(t, repo.Backend().Remove(ctx, backend.Handle{Type: restic.SnapshotFile, Name: snapshots[1].ID().String()}))
+
+	rtest.Assert(t, pruneSnapshotFiles(t, repo) > 0, "no blobs removed")
+
+	rtest.OK(t, rapi.Undelete(ctx, repo, *snapshots[0].ID()))
+	sn, err := restic.LoadSnapshot(ctx, repo, *snapshots[0].ID())
+	rtest.OK(t, err)
+	checkTreeLoadable(t, repo, *sn.Tree)
+	checkTreeLoadable(t, repo, *snapshots[2].Tree)
+}
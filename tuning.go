@@ -0,0 +1,53 @@
+package rapi
+
+import (
+	"fmt"
+
+	"github.com/konidev20/rapi/backend/location"
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/internal/options"
+	"github.com/konidev20/rapi/tuning"
+)
+
+// autoTune returns a copy of opts with the options which are not set taken
+// from the tuning profile for this machine and the backend at repo.
+func (opts RepositoryOptions) autoTune(repo string) (RepositoryOptions, error) {
+	loc, err := location.Parse(opts.backends, repo)
+	if err != nil {
+		return opts, errors.Fatalf("parsing repository location failed: %v", err)
+	}
+
+	factory := opts.backends.Lookup(loc.Scheme)
+	if factory == nil {
+		return opts, errors.Fatalf("invalid backend: %q", loc.Scheme)
+	}
+
+	key := loc.Scheme + ".connections"
+	overrides := tuning.Profile{
+		PackUploaders:  opts.PackUploaders,
+		PackSize:       opts.PackSize,
+		PackSizeTarget: opts.PackSizeTarget,
+		Compression:    opts.Compression,
+		MaxMemoryBytes: opts.MaxMemoryBytes,
+	}
+	p := tuning.Select(tuning.Detect(), tuning.Classify(factory.Capabilities())).Override(overrides)
+	debug.Log("tuning profile for %v: %+v", loc.Scheme, p)
+
+	opts.PackUploaders = p.PackUploaders
+	opts.PackSize = p.PackSize
+	opts.PackSizeTarget = p.PackSizeTarget
+	opts.Compression = p.Compression
+	opts.MaxMemoryBytes = p.MaxMemoryBytes
+
+	if _, ok := opts.Extended[key]; !ok && p.Connections > 0 {
+		extended := make(options.Options, len(opts.Extended)+1)
+		for k, v := range opts.Extended {
+			extended[k] = v
+		}
+		extended[key] = fmt.Sprint(p.Connections)
+		opts.Extended = extended
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,75 @@
+package tuning
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// numaNodes returns the number of memory nodes listed in sysfs.
+func numaNodes() int {
+	nodes, err := filepath.Glob("/sys/devices/system/node/node[0-9]*")
+	if err != nil {
+		return 0
+	}
+	return len(nodes)
+}
+
+// memory returns the physical memory, or the limit of the control group of
+// the process if it is lower.
+func memory() uint64 {
+	mem := memTotal("/proc/meminfo")
+	for _, fn := range []string{
+		"/sys/fs/cgroup/memory.max",                   // cgroup v2
+		"/sys/fs/cgroup/memory/memory.limit_in_bytes", // cgroup v1
+	} {
+		limit := readLimit(fn)
+		if limit > 0 && (mem == 0 || limit < mem) {
+			mem = limit
+		}
+	}
+	return mem
+}
+
+// memTotal returns the value of MemTotal in a meminfo file.
+func memTotal(fn string) uint64 {
+	f, err := os.Open(fn)
+	if err != nil {
+		return 0
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 2 || fields[0] != "MemTotal:" {
+			continue
+		}
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0
+		}
+		return kb * 1024
+	}
+	return 0
+}
+
+// readLimit returns the memory limit in a cgroup file, or zero if there is
+// none.
+func readLimit(fn string) uint64 {
+	buf, err := os.ReadFile(fn)
+	if err != nil {
+		return 0
+	}
+	// "max" means no limit for cgroup v2, an unlimited cgroup v1 reports a
+	// huge value which is larger than the physical memory
+	limit, err := strconv.ParseUint(strings.TrimSpace(string(buf)), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return limit
+}
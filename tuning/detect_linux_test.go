@@ -0,0 +1,29 @@
+package tuning
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	rtest "github.com/konidev20/rapi/internal/test"
+)
+
+func TestMemTotal(t *testing.T) {
+	fn := filepath.Join(rtest.TempDir(t), "meminfo")
+	rtest.OK(t, os.WriteFile(fn, []byte("MemFree:  1000 kB\nMemTotal:  2048 kB\n"), 0600))
+	rtest.Equals(t, uint64(2048*1024), memTotal(fn))
+	rtest.Equals(t, uint64(0), memTotal(fn+".missing"))
+}
+
+func TestReadLimit(t *testing.T) {
+	dir := rtest.TempDir(t)
+	for content, want := range map[string]uint64{
+		"max\n":        0,
+		"1073741824\n": 1 << 30,
+		"":             0,
+	} {
+		fn := filepath.Join(dir, "limit")
+		rtest.OK(t, os.WriteFile(fn, []byte(content), 0600))
+		rtest.Equals(t, want, readLimit(fn))
+	}
+}
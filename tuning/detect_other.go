@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package tuning
+
+// numaNodes is not detected on this platform.
+func numaNodes() int {
+	return 0
+}
+
+// memory is not detected on this platform.
+func memory() uint64 {
+	return 0
+}
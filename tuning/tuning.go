@@ -0,0 +1,217 @@
+// Package tuning selects worker counts, pack sizes and the compression mode
+// from the resources of the machine and the type of the backend. The built-in
+// defaults are chosen for an average desktop, they underuse large servers and
+// can overwhelm small devices. A Profile returned by Select can be adjusted
+// with explicit overrides before it is applied.
+package tuning
+
+import (
+	"runtime"
+
+	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/repository"
+)
+
+// System describes the resources available to the process. Zero values mean
+// that the resource could not be detected.
+type System struct {
+	// CPUs is the number of CPUs the process may use.
+	CPUs int
+	// NUMANodes is the number of memory nodes of the machine.
+	NUMANodes int
+	// Memory is the memory available to the process in bytes, taking
+	// limits of the control group into account.
+	Memory uint64
+}
+
+// Detect returns the resources available to the process.
+func Detect() System {
+	sys := System{
+		CPUs:      runtime.GOMAXPROCS(0),
+		NUMANodes: numaNodes(),
+		Memory:    memory(),
+	}
+	if sys.NUMANodes == 0 {
+		sys.NUMANodes = 1
+	}
+	return sys
+}
+
+// BackendClass groups backends with similar latency and throughput.
+type BackendClass int
+
+// Classes of backends.
+const (
+	// BackendLocal is a directory on a local or mounted file system.
+	BackendLocal BackendClass = iota
+	// BackendServer is a single server, for example via SFTP or REST.
+	BackendServer
+	// BackendObjectStore is a cloud storage service which scales with the
+	// number of concurrent requests.
+	BackendObjectStore
+)
+
+// Classify returns the class of a backend with the capabilities caps, as
+// reported by its factory. Backends which are neither stored locally nor
+// scale with the number of connections are treated like a single server.
+func Classify(caps backend.Capabilities) BackendClass {
+	switch {
+	case caps.LocalStorage:
+		return BackendLocal
+	case caps.ScalesWithConnections:
+		return BackendObjectStore
+	default:
+		return BackendServer
+	}
+}
+
+// Profile holds the tuned options. Zero values keep the built-in defaults.
+type Profile struct {
+	// ReadConcurrency, SaveBlobConcurrency, SaveTreeConcurrency and
+	// ScanConcurrency are the worker counts of the archiver.
+	ReadConcurrency     uint
+	SaveBlobConcurrency uint
+	SaveTreeConcurrency uint
+	ScanConcurrency     uint
+
+	// Connections is the number of concurrent backend requests.
+	Connections uint
+	// PackUploaders is the number of pack files uploaded concurrently.
+	PackUploaders uint
+	// PackSize and PackSizeTarget are in MiB, see repository.Options.
+	PackSize       uint
+	PackSizeTarget uint
+
+	Compression repository.CompressionMode
+
+	// MaxMemoryBytes is the memory budget of the repository.
+	MaxMemoryBytes uint64
+}
+
+const (
+	mib = 1024 * 1024
+	gib = 1024 * mib
+
+	// blobWorkerMemory is the memory a blob worker uses at most, it holds a
+	// chunk and its compressed copy.
+	blobWorkerMemory = 16 * mib
+)
+
+// Select returns the profile for the system sys and a backend of class.
+func Select(sys System, class BackendClass) Profile {
+	cpus := sys.CPUs
+	if cpus < 1 {
+		cpus = 1
+	}
+	nodes := sys.NUMANodes
+	if nodes < 1 {
+		nodes = 1
+	}
+
+	var p Profile
+
+	// hashing, compression and encryption are CPU bound. On small machines
+	// the buffers of the workers must not use more than an eighth of the
+	// memory.
+	p.SaveBlobConcurrency = uint(cpus)
+	if sys.Memory > 0 {
+		limit := uint(sys.Memory / 8 / blobWorkerMemory)
+		if limit < 1 {
+			limit = 1
+		}
+		if p.SaveBlobConcurrency > limit {
+			p.SaveBlobConcurrency = limit
+		}
+	}
+
+	// reading two files at a time saturates a single disk. Machines with
+	// several memory nodes usually have several disks or a storage array,
+	// read two files per node.
+	p.ReadConcurrency = uint(2 * nodes)
+	if p.ReadConcurrency > p.SaveBlobConcurrency+1 {
+		p.ReadConcurrency = p.SaveBlobConcurrency + 1
+	}
+	p.SaveTreeConcurrency = p.SaveBlobConcurrency + p.ReadConcurrency
+
+	p.ScanConcurrency = uint(cpus / 2)
+	switch {
+	case p.ScanConcurrency < 4:
+		p.ScanConcurrency = 4
+	case p.ScanConcurrency > 16:
+		p.ScanConcurrency = 16
+	}
+
+	switch class {
+	case BackendLocal:
+		p.Connections = 2
+		p.PackSize = 16
+	case BackendServer:
+		p.Connections = 5
+		p.PackSize = 16
+		p.PackSizeTarget = 32
+	case BackendObjectStore:
+		// object stores scale with the number of requests, but each
+		// upload holds a pack file in a temporary file and a buffer
+		p.Connections = uint(cpus)
+		switch {
+		case p.Connections < 5:
+			p.Connections = 5
+		case p.Connections > 32:
+			p.Connections = 32
+		}
+		p.PackSize = 16
+		p.PackSizeTarget = 64
+		if sys.Memory >= 16*gib {
+			p.PackSizeTarget = 128
+		}
+	}
+
+	if sys.Memory > 0 && sys.Memory < 2*gib {
+		// small devices: minimal packs and a memory budget
+		p.PackSize = uint(repository.MinPackSize / mib)
+		p.PackSizeTarget = 0
+		p.MaxMemoryBytes = sys.Memory / 2
+		if p.Connections > 2 {
+			p.Connections = 2
+		}
+	}
+	p.PackUploaders = p.Connections
+
+	switch {
+	case cpus <= 1:
+		// compressing would make the backup CPU bound
+		p.Compression = repository.CompressionOff
+	case cpus >= 16 && class != BackendLocal:
+		// plenty of CPU, the upload is the bottleneck
+		p.Compression = repository.CompressionMax
+	default:
+		p.Compression = repository.CompressionAuto
+	}
+
+	return p
+}
+
+// Override returns a copy of p with all fields replaced which are set in o.
+// Compression is only replaced if it is not CompressionAuto.
+func (p Profile) Override(o Profile) Profile {
+	set := func(dst *uint, v uint) {
+		if v != 0 {
+			*dst = v
+		}
+	}
+	set(&p.ReadConcurrency, o.ReadConcurrency)
+	set(&p.SaveBlobConcurrency, o.SaveBlobConcurrency)
+	set(&p.SaveTreeConcurrency, o.SaveTreeConcurrency)
+	set(&p.ScanConcurrency, o.ScanConcurrency)
+	set(&p.Connections, o.Connections)
+	set(&p.PackUploaders, o.PackUploaders)
+	set(&p.PackSize, o.PackSize)
+	set(&p.PackSizeTarget, o.PackSizeTarget)
+	if o.Compression != repository.CompressionAuto {
+		p.Compression = o.Compression
+	}
+	if o.MaxMemoryBytes != 0 {
+		p.MaxMemoryBytes = o.MaxMemoryBytes
+	}
+	return p
+}
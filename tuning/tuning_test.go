@@ -0,0 +1,114 @@
+package tuning_test
+
+import (
+	"testing"
+
+	"github.com/konidev20/rapi/backend"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/tuning"
+)
+
+const gib = 1024 * 1024 * 1024
+
+func TestClassify(t *testing.T) {
+	for _, test := range []struct {
+		caps backend.Capabilities
+		want tuning.BackendClass
+	}{
+		{backend.Capabilities{RangedReads: true, AtomicWrites: true, LocalStorage: true}, tuning.BackendLocal},
+		{backend.Capabilities{RangedReads: true}, tuning.BackendServer},
+		{backend.Capabilities{RangedReads: true, AtomicWrites: true, ScalesWithConnections: true}, tuning.BackendObjectStore},
+		{backend.Capabilities{}, tuning.BackendServer},
+	} {
+		rtest.Equals(t, test.want, tuning.Classify(test.caps))
+	}
+}
+
+func TestSelect(t *testing.T) {
+	var tests = []struct {
+		name  string
+		sys   tuning.System
+		class tuning.BackendClass
+		check func(t *testing.T, p tuning.Profile)
+	}{
+		{
+			name:  "small-device",
+			sys:   tuning.System{CPUs: 1, NUMANodes: 1, Memory: gib / 2},
+			class: tuning.BackendObjectStore,
+			check: func(t *testing.T, p tuning.Profile) {
+				rtest.Equals(t, uint(1), p.SaveBlobConcurrency)
+				rtest.Equals(t, uint(2), p.ReadConcurrency)
+				rtest.Equals(t, uint(2), p.Connections)
+				rtest.Equals(t, uint(repository.MinPackSize/1024/1024), p.PackSize)
+				rtest.Equals(t, uint(0), p.PackSizeTarget)
+				rtest.Equals(t, uint64(gib/4), p.MaxMemoryBytes)
+				rtest.Equals(t, repository.CompressionOff, p.Compression)
+			},
+		},
+		{
+			name:  "desktop-local",
+			sys:   tuning.System{CPUs: 8, NUMANodes: 1, Memory: 16 * gib},
+			class: tuning.BackendLocal,
+			check: func(t *testing.T, p tuning.Profile) {
+				rtest.Equals(t, uint(8), p.SaveBlobConcurrency)
+				rtest.Equals(t, uint(2), p.ReadConcurrency)
+				rtest.Equals(t, uint(2), p.Connections)
+				rtest.Equals(t, uint(16), p.PackSize)
+				rtest.Equals(t, uint64(0), p.MaxMemoryBytes)
+				rtest.Equals(t, repository.CompressionAuto, p.Compression)
+			},
+		},
+		{
+			name:  "numa-server",
+			sys:   tuning.System{CPUs: 128, NUMANodes: 4, Memory: 512 * gib},
+			class: tuning.BackendObjectStore,
+			check: func(t *testing.T, p tuning.Profile) {
+				rtest.Equals(t, uint(128), p.SaveBlobConcurrency)
+				rtest.Equals(t, uint(8), p.ReadConcurrency)
+				rtest.Equals(t, uint(16), p.ScanConcurrency)
+				rtest.Equals(t, uint(32), p.Connections)
+				rtest.Equals(t, p.Connections, p.PackUploaders)
+				rtest.Equals(t, uint(128), p.PackSizeTarget)
+				rtest.Equals(t, repository.CompressionMax, p.Compression)
+			},
+		},
+		{
+			name:  "unknown-memory",
+			sys:   tuning.System{CPUs: 4},
+			class: tuning.BackendServer,
+			check: func(t *testing.T, p tuning.Profile) {
+				rtest.Equals(t, uint(4), p.SaveBlobConcurrency)
+				rtest.Equals(t, uint(5), p.Connections)
+				rtest.Equals(t, uint64(0), p.MaxMemoryBytes)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.check(t, tuning.Select(test.sys, test.class))
+		})
+	}
+}
+
+func TestOverride(t *testing.T) {
+	p := tuning.Select(tuning.System{CPUs: 8, Memory: 16 * gib}, tuning.BackendObjectStore)
+	o := p.Override(tuning.Profile{
+		Connections: 3,
+		PackSize:    32,
+		Compression: repository.CompressionOff,
+	})
+
+	rtest.Equals(t, uint(3), o.Connections)
+	rtest.Equals(t, uint(32), o.PackSize)
+	rtest.Equals(t, repository.CompressionOff, o.Compression)
+	rtest.Equals(t, p.SaveBlobConcurrency, o.SaveBlobConcurrency)
+	rtest.Equals(t, p.PackSizeTarget, o.PackSizeTarget)
+}
+
+func TestDetect(t *testing.T) {
+	sys := tuning.Detect()
+	rtest.Assert(t, sys.CPUs > 0, "no CPUs detected")
+	rtest.Assert(t, sys.NUMANodes > 0, "no memory nodes detected")
+}
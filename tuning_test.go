@@ -0,0 +1,40 @@
+package rapi_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/konidev20/rapi"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/restic"
+)
+
+func TestOpenRepositoryAutoTune(t *testing.T) {
+	ctx := context.Background()
+	repository.TestUseLowSecurityKDFParameters(t)
+	restic.TestDisableCheckPolynomial(t)
+
+	opts := rapi.DefaultOptions
+	opts.Repo = filepath.Join(rtest.TempDir(t), "repo")
+	opts.Password = rtest.TestPassword
+	opts.NoCache = true
+	opts.AutoTune = true
+
+	_, err := rapi.InitSeed(ctx, opts.Repo, restic.StableRepoVersion, opts)
+	rtest.OK(t, err)
+
+	repo, err := rapi.OpenRepository(ctx, opts)
+	rtest.OK(t, err)
+	rtest.Assert(t, repo.PackSize() >= repository.MinPackSize, "pack size %d too small", repo.PackSize())
+	rtest.Assert(t, repo.Connections() > 0, "no connections")
+
+	// explicit options are kept
+	opts.PackSize = 8
+	opts.Extended = map[string]string{"local.connections": "1"}
+	repo, err = rapi.OpenRepository(ctx, opts)
+	rtest.OK(t, err)
+	rtest.Equals(t, uint(8*1024*1024), repo.PackSize())
+	rtest.Equals(t, uint(1), repo.Connections())
+}
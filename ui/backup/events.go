@@ -0,0 +1,77 @@
+package backup
+
+import (
+	"strings"
+	"time"
+
+	"github.com/konidev20/rapi/internal/archiver"
+	"github.com/konidev20/rapi/restic"
+	"github.com/konidev20/rapi/ui/events"
+)
+
+// EventProgress reports progress for the `backup` command as structured
+// events. Status updates and free-form messages are not reported.
+type EventProgress struct {
+	e *events.Emitter
+}
+
+// assert that EventProgress implements the ProgressPrinter interface
+var _ ProgressPrinter = &EventProgress{}
+
+// NewEventProgress returns a new backup progress reporter which delivers
+// events to e, starting with an OperationStarted event.
+func NewEventProgress(e *events.Emitter) *EventProgress {
+	e.Started("backup")
+	return &EventProgress{e: e}
+}
+
+// Update does nothing, progress is reported per file.
+func (b *EventProgress) Update(_, _ Counter, _ uint, _ map[string]struct{}, _ time.Time, _ uint64) {
+}
+
+// ScannerError is the error callback function for the scanner, it reports
+// the error and returns nil.
+func (b *EventProgress) ScannerError(item string, err error) error {
+	b.e.Error("scan", item, err)
+	return nil
+}
+
+// Error is the error callback function for the archiver, it reports the error
+// and returns nil.
+func (b *EventProgress) Error(item string, err error) error {
+	b.e.Error("backup", item, err)
+	return nil
+}
+
+// CompleteItem is the status callback function for the archiver when a
+// file/dir has been saved successfully.
+func (b *EventProgress) CompleteItem(messageType, item string, s archiver.ItemStats, d time.Duration) {
+	// messageType is "file new", "dir unchanged" etc.
+	kind, action, ok := strings.Cut(messageType, " ")
+	if !ok || kind != "file" {
+		return
+	}
+
+	b.e.FileDone("backup", item, action, s.DataSize, d)
+}
+
+// ReportTotal does nothing.
+func (b *EventProgress) ReportTotal(_ time.Time, _ archiver.ScanStats) {
+}
+
+// Finish reports the summary.
+func (b *EventProgress) Finish(snapshotID restic.ID, start time.Time, summary *Summary, dryRun bool) {
+	b.e.Summary("backup", snapshotID, NewJSONSummary(snapshotID, start, summary, dryRun))
+}
+
+// Reset no-op
+func (b *EventProgress) Reset() {
+}
+
+// P does nothing.
+func (b *EventProgress) P(_ string, _ ...interface{}) {
+}
+
+// V does nothing.
+func (b *EventProgress) V(_ string, _ ...interface{}) {
+}
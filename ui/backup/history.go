@@ -0,0 +1,129 @@
+package backup
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/konidev20/rapi/internal/cache"
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/errors"
+)
+
+// Run holds the statistics of a finished backup.
+type Run struct {
+	Time     time.Time     `json:"time"`
+	Duration time.Duration `json:"duration"`
+	// TotalBytes is the size of all files of the backup.
+	TotalBytes uint64 `json:"total_bytes"`
+	// AddedBytes is the number of bytes added to the repository.
+	AddedBytes uint64 `json:"added_bytes"`
+}
+
+// History holds the statistics of the latest backups of the same paths to a
+// repository. It is kept in the cache and used to predict the duration and
+// the upload size of a backup before the scanner and the rate estimator have
+// enough data.
+type History struct {
+	Runs []Run `json:"runs"`
+}
+
+const (
+	// maxHistoryRuns is the number of runs kept in the history.
+	maxHistoryRuns = 10
+	// historyWeight is the weight of the latest run in the averages, the
+	// weight of older runs decreases exponentially.
+	historyWeight = 0.5
+)
+
+// Prediction is the expected outcome of a backup.
+type Prediction struct {
+	// Rate is the number of bytes processed per second, including the
+	// bytes of unchanged files.
+	Rate float64
+	// Duration is the expected duration of the whole backup.
+	Duration time.Duration
+	// UploadBytes is the expected number of bytes added to the repository.
+	UploadBytes uint64
+}
+
+// HistoryKey returns the key of the history of backups of paths on host.
+func HistoryKey(host string, paths []string) string {
+	paths = append([]string(nil), paths...)
+	sort.Strings(paths)
+	return host + "\x00" + strings.Join(paths, "\x00")
+}
+
+// LoadHistory returns the history stored for key in c. A missing or damaged
+// history is treated as empty.
+func LoadHistory(c *cache.Cache, key string) *History {
+	h := &History{}
+	buf, ok := c.LoadHistory(key)
+	if !ok {
+		return h
+	}
+	if err := json.Unmarshal(buf, h); err != nil {
+		debug.Log("ignoring damaged backup history: %v", err)
+		return &History{}
+	}
+	return h
+}
+
+// Save stores h for key in c.
+func (h *History) Save(c *cache.Cache, key string) error {
+	buf, err := json.Marshal(h)
+	if err != nil {
+		return errors.Wrap(err, "Marshal")
+	}
+	return c.SaveHistory(key, buf)
+}
+
+// Add appends r to the history, the oldest runs are dropped.
+func (h *History) Add(r Run) {
+	h.Runs = append(h.Runs, r)
+	if len(h.Runs) > maxHistoryRuns {
+		h.Runs = append([]Run(nil), h.Runs[len(h.Runs)-maxHistoryRuns:]...)
+	}
+}
+
+// Predict returns the expected outcome of a backup of totalBytes. If
+// totalBytes is zero, the size of the latest run is used. It returns false if
+// there are no usable runs.
+func (h *History) Predict(totalBytes uint64) (Prediction, bool) {
+	if h == nil {
+		return Prediction{}, false
+	}
+
+	var rate, added, weights float64
+	var last uint64
+	w := 1.0
+	for i := len(h.Runs) - 1; i >= 0; i-- {
+		r := h.Runs[i]
+		if r.Duration <= 0 || r.TotalBytes == 0 {
+			continue
+		}
+		if last == 0 {
+			last = r.TotalBytes
+		}
+
+		rate += w * float64(r.TotalBytes) / r.Duration.Seconds()
+		added += w * float64(r.AddedBytes) / float64(r.TotalBytes)
+		weights += w
+		w *= 1 - historyWeight
+	}
+	if weights == 0 {
+		return Prediction{}, false
+	}
+	rate /= weights
+	added /= weights
+
+	if totalBytes == 0 {
+		totalBytes = last
+	}
+	return Prediction{
+		Rate:        rate,
+		Duration:    time.Duration(float64(totalBytes) / rate * float64(time.Second)),
+		UploadBytes: uint64(float64(totalBytes) * added),
+	}, true
+}
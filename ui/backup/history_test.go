@@ -0,0 +1,103 @@
+package backup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/konidev20/rapi/internal/archiver"
+	"github.com/konidev20/rapi/internal/cache"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/restic"
+)
+
+func TestHistoryPredict(t *testing.T) {
+	var h *History
+	_, ok := h.Predict(1000)
+	rtest.Assert(t, !ok, "prediction from nil history")
+
+	h = &History{}
+	_, ok = h.Predict(1000)
+	rtest.Assert(t, !ok, "prediction from empty history")
+
+	// runs without data are ignored
+	h.Add(Run{Duration: time.Second})
+	_, ok = h.Predict(1000)
+	rtest.Assert(t, !ok, "prediction from unusable history")
+
+	h.Add(Run{Duration: 10 * time.Second, TotalBytes: 1000, AddedBytes: 100})
+	p, ok := h.Predict(0)
+	rtest.Assert(t, ok, "no prediction")
+	rtest.Assert(t, almostEqual(p.Rate, 100), "wrong rate %v", p.Rate)
+	rtest.Equals(t, 10*time.Second, p.Duration)
+	rtest.Equals(t, uint64(100), p.UploadBytes)
+
+	// the latest run has the highest weight
+	h.Add(Run{Duration: 10 * time.Second, TotalBytes: 4000, AddedBytes: 0})
+	p, ok = h.Predict(3000)
+	rtest.Assert(t, ok, "no prediction")
+	rtest.Assert(t, almostEqual(p.Rate, 300), "wrong rate %v", p.Rate)
+	rtest.Equals(t, 10*time.Second, p.Duration)
+	rtest.Equals(t, uint64(100), p.UploadBytes)
+}
+
+func TestHistoryAdd(t *testing.T) {
+	h := &History{}
+	for i := 0; i < maxHistoryRuns+5; i++ {
+		h.Add(Run{TotalBytes: uint64(i)})
+	}
+	rtest.Equals(t, maxHistoryRuns, len(h.Runs))
+	rtest.Equals(t, uint64(5), h.Runs[0].TotalBytes)
+	rtest.Equals(t, uint64(maxHistoryRuns+4), h.Runs[maxHistoryRuns-1].TotalBytes)
+}
+
+func TestHistoryCache(t *testing.T) {
+	c := cache.TestNewCache(t)
+	key := HistoryKey("host", []string{"/srv", "/home"})
+	rtest.Equals(t, key, HistoryKey("host", []string{"/home", "/srv"}))
+
+	h := LoadHistory(c, key)
+	rtest.Equals(t, 0, len(h.Runs))
+
+	run := Run{Time: time.Unix(1700000000, 0).UTC(), Duration: time.Minute, TotalBytes: 23, AddedBytes: 5}
+	h.Add(run)
+	rtest.OK(t, h.Save(c, key))
+
+	h = LoadHistory(c, key)
+	rtest.Equals(t, []Run{run}, h.Runs)
+
+	// a damaged history is ignored
+	rtest.OK(t, c.SaveHistory(key, []byte("{")))
+	h = LoadHistory(c, key)
+	rtest.Equals(t, 0, len(h.Runs))
+}
+
+func TestProgressPrediction(t *testing.T) {
+	prog := NewProgress(&mockPrinter{}, time.Hour)
+
+	_, ok := prog.Prediction()
+	rtest.Assert(t, !ok, "prediction without history")
+
+	h := &History{}
+	h.Add(Run{Duration: 10 * time.Second, TotalBytes: 1000, AddedBytes: 200})
+	prog.UseHistory(h)
+
+	// the size of the latest run is used until the scanner is done
+	prog.ReportTotal("foo", archiver.ScanStats{Bytes: 500})
+	p, ok := prog.Prediction()
+	rtest.Assert(t, ok, "no prediction")
+	rtest.Equals(t, uint64(200), p.UploadBytes)
+
+	prog.ReportTotal("", archiver.ScanStats{Bytes: 500})
+	p, ok = prog.Prediction()
+	rtest.Assert(t, ok, "no prediction")
+	rtest.Equals(t, 5*time.Second, p.Duration)
+	rtest.Equals(t, uint64(100), p.UploadBytes)
+
+	prog.CompleteItem("foo", nil, &restic.Node{Type: "file", Size: 500}, archiver.ItemStats{DataSizeInRepo: 80, TreeSizeInRepo: 20}, 0)
+	prog.Finish(restic.NewRandomID(), false)
+
+	run := prog.Run()
+	rtest.Equals(t, uint64(500), run.TotalBytes)
+	rtest.Equals(t, uint64(100), run.AddedBytes)
+	rtest.Assert(t, run.Duration > 0, "duration not recorded")
+}
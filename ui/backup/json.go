@@ -7,6 +7,7 @@ import (
 	"github.com/konidev20/rapi/internal/archiver"
 	"github.com/konidev20/rapi/restic"
 	"github.com/konidev20/rapi/ui"
+	"github.com/konidev20/rapi/ui/jsonout"
 	"github.com/konidev20/rapi/ui/termstatus"
 )
 
@@ -40,48 +41,26 @@ func (b *JSONProgress) error(status interface{}) {
 
 // Update updates the status lines.
 func (b *JSONProgress) Update(total, processed Counter, errors uint, currentFiles map[string]struct{}, start time.Time, secs uint64) {
-	status := statusUpdate{
-		MessageType:      "status",
-		SecondsElapsed:   uint64(time.Since(start) / time.Second),
-		SecondsRemaining: secs,
-		TotalFiles:       total.Files,
-		FilesDone:        processed.Files,
-		TotalBytes:       total.Bytes,
-		BytesDone:        processed.Bytes,
-		ErrorCount:       errors,
-	}
-
-	if total.Bytes > 0 {
-		status.PercentDone = float64(processed.Bytes) / float64(total.Bytes)
-	}
-
-	for filename := range currentFiles {
-		status.CurrentFiles = append(status.CurrentFiles, filename)
-	}
-	sort.Strings(status.CurrentFiles)
-
-	b.print(status)
+	b.print(NewJSONStatus(total, processed, errors, currentFiles, start, secs))
 }
 
 // ScannerError is the error callback function for the scanner, it prints the
 // error in verbose mode and returns nil.
 func (b *JSONProgress) ScannerError(item string, err error) error {
-	b.error(errorUpdate{
-		MessageType: "error",
-		Error:       err,
-		During:      "scan",
-		Item:        item,
+	b.error(jsonout.ErrorUpdate{
+		Error:  jsonout.NewErrorObject(err),
+		During: "scan",
+		Item:   item,
 	})
 	return nil
 }
 
 // Error is the error callback function for the archiver, it prints the error and returns nil.
 func (b *JSONProgress) Error(item string, err error) error {
-	b.error(errorUpdate{
-		MessageType: "error",
-		Error:       err,
-		During:      "archival",
-		Item:        item,
+	b.error(jsonout.ErrorUpdate{
+		Error:  jsonout.NewErrorObject(err),
+		During: "archival",
+		Item:   item,
 	})
 	return nil
 }
@@ -95,8 +74,7 @@ func (b *JSONProgress) CompleteItem(messageType, item string, s archiver.ItemSta
 
 	switch messageType {
 	case "dir new":
-		b.print(verboseUpdate{
-			MessageType:        "verbose_status",
+		b.print(jsonout.VerboseUpdate{
 			Action:             "new",
 			Item:               item,
 			Duration:           d.Seconds(),
@@ -106,14 +84,12 @@ func (b *JSONProgress) CompleteItem(messageType, item string, s archiver.ItemSta
 			MetadataSizeInRepo: s.TreeSizeInRepo,
 		})
 	case "dir unchanged":
-		b.print(verboseUpdate{
-			MessageType: "verbose_status",
-			Action:      "unchanged",
-			Item:        item,
+		b.print(jsonout.VerboseUpdate{
+			Action: "unchanged",
+			Item:   item,
 		})
 	case "dir modified":
-		b.print(verboseUpdate{
-			MessageType:        "verbose_status",
+		b.print(jsonout.VerboseUpdate{
 			Action:             "modified",
 			Item:               item,
 			Duration:           d.Seconds(),
@@ -123,8 +99,7 @@ func (b *JSONProgress) CompleteItem(messageType, item string, s archiver.ItemSta
 			MetadataSizeInRepo: s.TreeSizeInRepo,
 		})
 	case "file new":
-		b.print(verboseUpdate{
-			MessageType:    "verbose_status",
+		b.print(jsonout.VerboseUpdate{
 			Action:         "new",
 			Item:           item,
 			Duration:       d.Seconds(),
@@ -132,14 +107,12 @@ func (b *JSONProgress) CompleteItem(messageType, item string, s archiver.ItemSta
 			DataSizeInRepo: s.DataSizeInRepo,
 		})
 	case "file unchanged":
-		b.print(verboseUpdate{
-			MessageType: "verbose_status",
-			Action:      "unchanged",
-			Item:        item,
+		b.print(jsonout.VerboseUpdate{
+			Action: "unchanged",
+			Item:   item,
 		})
 	case "file modified":
-		b.print(verboseUpdate{
-			MessageType:    "verbose_status",
+		b.print(jsonout.VerboseUpdate{
 			Action:         "modified",
 			Item:           item,
 			Duration:       d.Seconds(),
@@ -152,20 +125,36 @@ func (b *JSONProgress) CompleteItem(messageType, item string, s archiver.ItemSta
 // ReportTotal sets the total stats up to now
 func (b *JSONProgress) ReportTotal(start time.Time, s archiver.ScanStats) {
 	if b.v >= 2 {
-		b.print(verboseUpdate{
-			MessageType: "verbose_status",
-			Action:      "scan_finished",
-			Duration:    time.Since(start).Seconds(),
-			DataSize:    s.Bytes,
-			TotalFiles:  s.Files,
+		b.print(jsonout.VerboseUpdate{
+			Action:     "scan_finished",
+			Duration:   time.Since(start).Seconds(),
+			DataSize:   s.Bytes,
+			TotalFiles: s.Files,
 		})
 	}
 }
 
 // Finish prints the finishing messages.
 func (b *JSONProgress) Finish(snapshotID restic.ID, start time.Time, summary *Summary, dryRun bool) {
-	b.print(summaryOutput{
-		MessageType:         "summary",
+	b.print(NewJSONSummary(snapshotID, start, summary, dryRun))
+}
+
+// Reset no-op
+func (b *JSONProgress) Reset() {
+}
+
+// NewJSONSummary returns the summary printed by `restic backup --json`.
+func NewJSONSummary(snapshotID restic.ID, start time.Time, summary *Summary, dryRun bool) jsonout.BackupSummary {
+	var stages map[string]float64
+	if summary.Stages.Durations != nil {
+		stages = make(map[string]float64, len(summary.Stages.Durations))
+		for name, d := range summary.Stages.Durations {
+			stages[name] = d.Seconds()
+		}
+	}
+
+	return jsonout.BackupSummary{
+		MessageType:         jsonout.MessageSummary,
 		FilesNew:            summary.Files.New,
 		FilesChanged:        summary.Files.Changed,
 		FilesUnmodified:     summary.Files.Unchanged,
@@ -180,59 +169,32 @@ func (b *JSONProgress) Finish(snapshotID restic.ID, start time.Time, summary *Su
 		TotalDuration:       time.Since(start).Seconds(),
 		SnapshotID:          snapshotID.String(),
 		DryRun:              dryRun,
-	})
-}
-
-// Reset no-op
-func (b *JSONProgress) Reset() {
+		StageDurations:      stages,
+		Bottleneck:          summary.Stages.Bottleneck,
+	}
 }
 
-type statusUpdate struct {
-	MessageType      string   `json:"message_type"` // "status"
-	SecondsElapsed   uint64   `json:"seconds_elapsed,omitempty"`
-	SecondsRemaining uint64   `json:"seconds_remaining,omitempty"`
-	PercentDone      float64  `json:"percent_done"`
-	TotalFiles       uint64   `json:"total_files,omitempty"`
-	FilesDone        uint64   `json:"files_done,omitempty"`
-	TotalBytes       uint64   `json:"total_bytes,omitempty"`
-	BytesDone        uint64   `json:"bytes_done,omitempty"`
-	ErrorCount       uint     `json:"error_count,omitempty"`
-	CurrentFiles     []string `json:"current_files,omitempty"`
-}
+// NewJSONStatus returns the status printed by `restic backup --json`.
+func NewJSONStatus(total, processed Counter, errors uint, currentFiles map[string]struct{}, start time.Time, secs uint64) jsonout.StatusUpdate {
+	status := jsonout.StatusUpdate{
+		MessageType:      jsonout.MessageStatus,
+		SecondsElapsed:   uint64(time.Since(start) / time.Second),
+		SecondsRemaining: secs,
+		TotalFiles:       total.Files,
+		FilesDone:        processed.Files,
+		TotalBytes:       total.Bytes,
+		BytesDone:        processed.Bytes,
+		ErrorCount:       errors,
+	}
 
-type errorUpdate struct {
-	MessageType string `json:"message_type"` // "error"
-	Error       error  `json:"error"`
-	During      string `json:"during"`
-	Item        string `json:"item"`
-}
+	if total.Bytes > 0 {
+		status.PercentDone = float64(processed.Bytes) / float64(total.Bytes)
+	}
 
-type verboseUpdate struct {
-	MessageType        string  `json:"message_type"` // "verbose_status"
-	Action             string  `json:"action"`
-	Item               string  `json:"item"`
-	Duration           float64 `json:"duration"` // in seconds
-	DataSize           uint64  `json:"data_size"`
-	DataSizeInRepo     uint64  `json:"data_size_in_repo"`
-	MetadataSize       uint64  `json:"metadata_size"`
-	MetadataSizeInRepo uint64  `json:"metadata_size_in_repo"`
-	TotalFiles         uint    `json:"total_files"`
-}
+	for filename := range currentFiles {
+		status.CurrentFiles = append(status.CurrentFiles, filename)
+	}
+	sort.Strings(status.CurrentFiles)
 
-type summaryOutput struct {
-	MessageType         string  `json:"message_type"` // "summary"
-	FilesNew            uint    `json:"files_new"`
-	FilesChanged        uint    `json:"files_changed"`
-	FilesUnmodified     uint    `json:"files_unmodified"`
-	DirsNew             uint    `json:"dirs_new"`
-	DirsChanged         uint    `json:"dirs_changed"`
-	DirsUnmodified      uint    `json:"dirs_unmodified"`
-	DataBlobs           int     `json:"data_blobs"`
-	TreeBlobs           int     `json:"tree_blobs"`
-	DataAdded           uint64  `json:"data_added"`
-	TotalFilesProcessed uint    `json:"total_files_processed"`
-	TotalBytesProcessed uint64  `json:"total_bytes_processed"`
-	TotalDuration       float64 `json:"total_duration"` // in seconds
-	SnapshotID          string  `json:"snapshot_id"`
-	DryRun              bool    `json:"dry_run,omitempty"`
+	return status
 }
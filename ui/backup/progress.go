@@ -36,6 +36,10 @@ type Summary struct {
 	}
 	ProcessedBytes uint64
 	archiver.ItemStats
+
+	// Stages is the time spent in each stage and the bottleneck, it is only
+	// set if the stages were timed, see SetStageReport.
+	Stages restic.StageReport
 }
 
 // Progress reports progress for the `backup` command.
@@ -43,8 +47,9 @@ type Progress struct {
 	progress.Updater
 	mu sync.Mutex
 
-	start     time.Time
-	estimator rateEstimator
+	start, end time.Time
+	estimator  rateEstimator
+	history    *History
 
 	scanStarted, scanFinished bool
 
@@ -74,14 +79,16 @@ func NewProgress(printer ProgressPrinter, interval time.Duration) *Progress {
 			}
 
 			var secondsRemaining uint64
-			if p.scanFinished {
-				rate := p.estimator.rate(time.Now())
-				tooSlowCutoff := 1024.
-				if rate <= tooSlowCutoff {
-					secondsRemaining = 0
-				} else {
-					todo := float64(p.total.Bytes - p.processed.Bytes)
-					secondsRemaining = uint64(todo / rate)
+			rate := p.estimator.rate(time.Now())
+			tooSlowCutoff := 1024.
+			if p.scanFinished && rate > tooSlowCutoff {
+				todo := float64(p.total.Bytes - p.processed.Bytes)
+				secondsRemaining = uint64(todo / rate)
+			} else if pred, ok := p.predict(); ok {
+				// too little data yet, use the rate of earlier backups
+				total := p.expectedTotal()
+				if total > p.processed.Bytes {
+					secondsRemaining = uint64(float64(total-p.processed.Bytes) / pred.Rate)
 				}
 			}
 
@@ -91,6 +98,53 @@ func NewProgress(printer ProgressPrinter, interval time.Duration) *Progress {
 	return p
 }
 
+// UseHistory makes p predict the remaining time from the earlier backups in
+// h until there is enough data about the current backup.
+func (p *Progress) UseHistory(h *History) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.history = h
+}
+
+// Prediction returns the expected duration and upload size of the backup,
+// based on the history and the size found by the scanner so far. It returns
+// false if no history is used or it has no usable runs.
+func (p *Progress) Prediction() (Prediction, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.predict()
+}
+
+// expectedTotal returns the expected size of the backup. Until the scanner is
+// done, it is at least the size of the latest run. p.mu must be held.
+func (p *Progress) expectedTotal() uint64 {
+	total := p.total.Bytes
+	if !p.scanFinished && p.history != nil && len(p.history.Runs) > 0 {
+		if last := p.history.Runs[len(p.history.Runs)-1].TotalBytes; last > total {
+			total = last
+		}
+	}
+	return total
+}
+
+// predict returns the prediction for the expected size. p.mu must be held.
+func (p *Progress) predict() (Prediction, bool) {
+	return p.history.Predict(p.expectedTotal())
+}
+
+// Run returns the statistics of the backup for the history, it must be
+// called after Finish.
+func (p *Progress) Run() Run {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return Run{
+		Time:       p.start,
+		Duration:   p.end.Sub(p.start),
+		TotalBytes: p.summary.ProcessedBytes,
+		AddedBytes: p.summary.DataSizeInRepo + p.summary.TreeSizeInRepo,
+	}
+}
+
 // Error is the error callback function for the archiver, it prints the error and returns nil.
 func (p *Progress) Error(item string, err error) error {
 	p.mu.Lock()
@@ -212,9 +266,20 @@ func (p *Progress) ReportTotal(item string, s archiver.ScanStats) {
 	}
 }
 
+// SetStageReport adds the time spent in each stage of the backup to the
+// summary, it must be called before Finish.
+func (p *Progress) SetStageReport(r restic.StageReport) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.summary.Stages = r
+}
+
 // Finish prints the finishing messages.
 func (p *Progress) Finish(snapshotID restic.ID, dryrun bool) {
 	// wait for the status update goroutine to shut down
 	p.Updater.Done()
+	p.mu.Lock()
+	p.end = time.Now()
+	p.mu.Unlock()
 	p.printer.Finish(snapshotID, p.start, &p.summary, dryrun)
 }
@@ -3,6 +3,7 @@ package backup
 import (
 	"fmt"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/konidev20/rapi/internal/archiver"
@@ -139,6 +140,10 @@ func (b *TextProgress) Finish(_ restic.ID, start time.Time, summary *Summary, dr
 	b.P("%s to the repository: %-5s (%-5s stored)\n", verb,
 		ui.FormatBytes(summary.ItemStats.DataSize+summary.ItemStats.TreeSize),
 		ui.FormatBytes(summary.ItemStats.DataSizeInRepo+summary.ItemStats.TreeSizeInRepo))
+	if summary.Stages.Bottleneck != "" {
+		b.V("Stages:      %s\n", formatStages(summary.Stages))
+		b.P("Bottleneck:  %s\n", summary.Stages.Bottleneck)
+	}
 	b.P("\n")
 	b.P("processed %v files, %v in %s",
 		summary.Files.New+summary.Files.Changed+summary.Files.Unchanged,
@@ -146,3 +151,13 @@ func (b *TextProgress) Finish(_ restic.ID, start time.Time, summary *Summary, dr
 		ui.FormatDuration(time.Since(start)),
 	)
 }
+
+// formatStages returns the time spent in each stage, in the order the data
+// passes through them.
+func formatStages(r restic.StageReport) string {
+	var parts []string
+	for s := restic.StageScan; s <= restic.StageUpload; s++ {
+		parts = append(parts, fmt.Sprintf("%v %v", s, ui.FormatDuration(r.Durations[s.String()])))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,190 @@
+// Package events emits machine-readable events about running operations,
+// either as Go values sent to a channel or as JSON lines written to an
+// io.Writer. Integrations should consume these events instead of parsing the
+// human-readable output.
+package events
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/restic"
+)
+
+// Type describes the kind of an event.
+type Type string
+
+// Event types.
+const (
+	OperationStarted Type = "operation_started"
+	FileDone         Type = "file_done"
+	PackUploaded     Type = "pack_uploaded"
+	Error            Type = "error"
+	Summary          Type = "summary"
+)
+
+// Event is a single event, encoded as one JSON object per line.
+type Event struct {
+	Type      Type      `json:"type"`
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation,omitempty"`
+
+	// Item is the path of the file for FileDone events, and the item which
+	// caused the error for Error events.
+	Item   string `json:"item,omitempty"`
+	Action string `json:"action,omitempty"`
+
+	// ID is the ID of the pack for PackUploaded events, and of the snapshot
+	// for Summary events.
+	ID       string  `json:"id,omitempty"`
+	BlobType string  `json:"blob_type,omitempty"`
+	Size     uint64  `json:"size,omitempty"`
+	Duration float64 `json:"duration,omitempty"` // in seconds
+
+	Error string `json:"error,omitempty"`
+
+	// Summary holds the operation specific statistics of Summary events.
+	Summary interface{} `json:"summary,omitempty"`
+}
+
+// Emitter delivers events to a channel or writer. All methods are safe for
+// concurrent use, and calling them on a nil *Emitter does nothing, so that
+// callers don't have to check whether events were requested.
+type Emitter struct {
+	// dropped is accessed atomically and must be 64-bit aligned
+	dropped uint64
+	ch      chan<- Event
+
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+// NewChannel returns an Emitter which sends all events to ch. Events are
+// emitted by the goroutines doing the actual work, for example uploading
+// packs, so sending progress events never blocks: if ch is full, the event is
+// dropped and counted, see Dropped. Error and Summary events are never
+// dropped, sending them waits until there is room in ch, so ch must be read
+// until the operation has returned. Use a buffered channel which is large
+// enough for the rate at which events are consumed.
+func NewChannel(ch chan<- Event) *Emitter {
+	return &Emitter{ch: ch}
+}
+
+// NewWriter returns an Emitter which writes all events to wr as JSON, one
+// event per line.
+func NewWriter(wr io.Writer) *Emitter {
+	return &Emitter{enc: json.NewEncoder(wr)}
+}
+
+// Emit delivers ev. If ev.Time is not set, the current time is used.
+func (e *Emitter) Emit(ev Event) {
+	if e == nil {
+		return
+	}
+
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+
+	if e.ch != nil {
+		if ev.Type == Error || ev.Type == Summary {
+			e.ch <- ev
+			return
+		}
+
+		select {
+		case e.ch <- ev:
+		default:
+			atomic.AddUint64(&e.dropped, 1)
+		}
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// stop writing after the first error, the output is unusable anyway
+	if e.err != nil {
+		return
+	}
+
+	e.err = e.enc.Encode(ev)
+	if e.err != nil {
+		debug.Log("unable to write event: %v", e.err)
+	}
+}
+
+// Dropped returns the number of progress events which were dropped because
+// the channel was full.
+func (e *Emitter) Dropped() uint64 {
+	if e == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&e.dropped)
+}
+
+// Err returns the first error encountered while writing events.
+func (e *Emitter) Err() error {
+	if e == nil {
+		return nil
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.err
+}
+
+// Started reports that the operation op has started.
+func (e *Emitter) Started(op string) {
+	e.Emit(Event{Type: OperationStarted, Operation: op})
+}
+
+// FileDone reports that item has been processed. Action describes what has
+// been done, for example "new", "modified" or "unchanged".
+func (e *Emitter) FileDone(op, item, action string, size uint64, d time.Duration) {
+	e.Emit(Event{
+		Type:      FileDone,
+		Operation: op,
+		Item:      item,
+		Action:    action,
+		Size:      size,
+		Duration:  d.Seconds(),
+	})
+}
+
+// PackUploaded reports that the pack file id containing blobs of type t and
+// with the given size has been stored in the backend.
+func (e *Emitter) PackUploaded(id restic.ID, t restic.BlobType, size uint64, d time.Duration) {
+	e.Emit(Event{
+		Type:     PackUploaded,
+		ID:       id.String(),
+		BlobType: t.String(),
+		Size:     size,
+		Duration: d.Seconds(),
+	})
+}
+
+// Error reports the error err for item which occurred during the operation
+// op.
+func (e *Emitter) Error(op, item string, err error) {
+	ev := Event{Type: Error, Operation: op, Item: item}
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	e.Emit(ev)
+}
+
+// Summary reports that the operation op has finished. The id is optional and
+// identifies the result of the operation, for example the new snapshot.
+func (e *Emitter) Summary(op string, id restic.ID, summary interface{}) {
+	ev := Event{Type: Summary, Operation: op, Summary: summary}
+	if !id.IsNull() {
+		ev.ID = id.String()
+	}
+	e.Emit(ev)
+}
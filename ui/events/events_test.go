@@ -0,0 +1,91 @@
+package events_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/restic"
+	"github.com/konidev20/rapi/ui/events"
+)
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	e := events.NewWriter(&buf)
+
+	id := restic.NewRandomID()
+	e.Started("backup")
+	e.FileDone("backup", "/foo", "new", 23, time.Second)
+	e.PackUploaded(id, restic.DataBlob, 4096, time.Second)
+	e.Error("backup", "/bar", errors.New("permission denied"))
+	e.Summary("backup", id, map[string]int{"files_new": 1})
+	rtest.OK(t, e.Err())
+
+	var evs []events.Event
+	sc := bufio.NewScanner(&buf)
+	for sc.Scan() {
+		var ev events.Event
+		rtest.OK(t, json.Unmarshal(sc.Bytes(), &ev))
+		rtest.Assert(t, !ev.Time.IsZero(), "event %v has no timestamp", ev.Type)
+		evs = append(evs, ev)
+	}
+	rtest.OK(t, sc.Err())
+
+	rtest.Equals(t, 5, len(evs))
+	rtest.Equals(t, events.OperationStarted, evs[0].Type)
+	rtest.Equals(t, "backup", evs[0].Operation)
+	rtest.Equals(t, events.FileDone, evs[1].Type)
+	rtest.Equals(t, "/foo", evs[1].Item)
+	rtest.Equals(t, uint64(23), evs[1].Size)
+	rtest.Equals(t, events.PackUploaded, evs[2].Type)
+	rtest.Equals(t, id.String(), evs[2].ID)
+	rtest.Equals(t, "data", evs[2].BlobType)
+	rtest.Equals(t, events.Error, evs[3].Type)
+	rtest.Equals(t, "permission denied", evs[3].Error)
+	rtest.Equals(t, events.Summary, evs[4].Type)
+	rtest.Equals(t, id.String(), evs[4].ID)
+}
+
+func TestChannel(t *testing.T) {
+	ch := make(chan events.Event, 1)
+	e := events.NewChannel(ch)
+
+	e.Started("restore")
+	ev := <-ch
+	rtest.Equals(t, events.OperationStarted, ev.Type)
+	rtest.Equals(t, "restore", ev.Operation)
+	rtest.Equals(t, uint64(0), e.Dropped())
+
+	// a full channel must not block the caller
+	e.Started("backup")
+	e.Started("check")
+	rtest.Equals(t, uint64(1), e.Dropped())
+	ev = <-ch
+	rtest.Equals(t, "backup", ev.Operation)
+
+	// errors and summaries wait for room in the channel
+	e.Started("prune")
+	done := make(chan struct{})
+	go func() {
+		e.Error("prune", "", errors.New("failed"))
+		e.Summary("prune", restic.ID{}, nil)
+		close(done)
+	}()
+	for _, tpe := range []events.Type{events.OperationStarted, events.Error, events.Summary} {
+		ev = <-ch
+		rtest.Equals(t, tpe, ev.Type)
+	}
+	<-done
+	rtest.Equals(t, uint64(1), e.Dropped())
+}
+
+func TestNilEmitter(t *testing.T) {
+	var e *events.Emitter
+	e.Started("backup")
+	e.Error("backup", "", errors.New("ignored"))
+	rtest.OK(t, e.Err())
+}
@@ -0,0 +1,105 @@
+package jsonout
+
+import "encoding/json"
+
+// StatusUpdate is printed periodically by `restic backup --json`.
+type StatusUpdate struct {
+	MessageType      string   `json:"message_type"` // "status"
+	SecondsElapsed   uint64   `json:"seconds_elapsed,omitempty"`
+	SecondsRemaining uint64   `json:"seconds_remaining,omitempty"`
+	PercentDone      float64  `json:"percent_done"`
+	TotalFiles       uint64   `json:"total_files,omitempty"`
+	FilesDone        uint64   `json:"files_done,omitempty"`
+	TotalBytes       uint64   `json:"total_bytes,omitempty"`
+	BytesDone        uint64   `json:"bytes_done,omitempty"`
+	ErrorCount       uint     `json:"error_count,omitempty"`
+	CurrentFiles     []string `json:"current_files,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (s StatusUpdate) MarshalJSON() ([]byte, error) {
+	type status StatusUpdate
+	s.MessageType = MessageStatus
+	return json.Marshal(status(s))
+}
+
+// ErrorUpdate is printed by `restic backup --json` for each error. During is
+// either "scan" or "archival".
+type ErrorUpdate struct {
+	MessageType string      `json:"message_type"` // "error"
+	Error       ErrorObject `json:"error"`
+	During      string      `json:"during"`
+	Item        string      `json:"item"`
+}
+
+// ErrorObject is the error of an ErrorUpdate.
+type ErrorObject struct {
+	Message string `json:"message"`
+}
+
+// NewErrorObject returns the ErrorObject for err.
+func NewErrorObject(err error) ErrorObject {
+	return ErrorObject{Message: err.Error()}
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e ErrorUpdate) MarshalJSON() ([]byte, error) {
+	type errorUpdate ErrorUpdate
+	e.MessageType = MessageError
+	return json.Marshal(errorUpdate(e))
+}
+
+// VerboseUpdate is printed by `restic backup --json --verbose=2` for each
+// file and directory. Action is one of "new", "unchanged", "modified" or
+// "scan_finished".
+type VerboseUpdate struct {
+	MessageType        string  `json:"message_type"` // "verbose_status"
+	Action             string  `json:"action"`
+	Item               string  `json:"item"`
+	Duration           float64 `json:"duration"` // in seconds
+	DataSize           uint64  `json:"data_size"`
+	DataSizeInRepo     uint64  `json:"data_size_in_repo"`
+	MetadataSize       uint64  `json:"metadata_size"`
+	MetadataSizeInRepo uint64  `json:"metadata_size_in_repo"`
+	TotalFiles         uint    `json:"total_files"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (v VerboseUpdate) MarshalJSON() ([]byte, error) {
+	type verboseUpdate VerboseUpdate
+	v.MessageType = MessageVerbose
+	return json.Marshal(verboseUpdate(v))
+}
+
+// BackupSummary is printed by `restic backup --json` when the backup is
+// complete.
+type BackupSummary struct {
+	MessageType         string  `json:"message_type"` // "summary"
+	FilesNew            uint    `json:"files_new"`
+	FilesChanged        uint    `json:"files_changed"`
+	FilesUnmodified     uint    `json:"files_unmodified"`
+	DirsNew             uint    `json:"dirs_new"`
+	DirsChanged         uint    `json:"dirs_changed"`
+	DirsUnmodified      uint    `json:"dirs_unmodified"`
+	DataBlobs           int     `json:"data_blobs"`
+	TreeBlobs           int     `json:"tree_blobs"`
+	DataAdded           uint64  `json:"data_added"`
+	TotalFilesProcessed uint    `json:"total_files_processed"`
+	TotalBytesProcessed uint64  `json:"total_bytes_processed"`
+	TotalDuration       float64 `json:"total_duration"` // in seconds
+	SnapshotID          string  `json:"snapshot_id"`
+	DryRun              bool    `json:"dry_run,omitempty"`
+
+	// StageDurations is the time in seconds spent in each stage of the
+	// backup, Bottleneck is the resource which limited it ("disk", "cpu"
+	// or "network"). Both are only set if the stages were timed.
+	StageDurations map[string]float64 `json:"stage_durations,omitempty"`
+	Bottleneck     string             `json:"bottleneck,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (s BackupSummary) MarshalJSON() ([]byte, error) {
+	type summary BackupSummary
+	s.MessageType = MessageSummary
+	return json.Marshal(summary(s))
+}
@@ -0,0 +1,46 @@
+package jsonout
+
+import "encoding/json"
+
+// Change is printed by `restic diff --json` for each changed path. Modifier
+// is one of "+", "-", "M", "U", "T" or "?", or a combination of "M", "U"
+// and "T" for modified files.
+type Change struct {
+	MessageType string `json:"message_type"` // "change"
+	Path        string `json:"path"`
+	Modifier    string `json:"modifier"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (c Change) MarshalJSON() ([]byte, error) {
+	type change Change
+	c.MessageType = MessageChange
+	return json.Marshal(change(c))
+}
+
+// DiffStat counts the items added to or removed from a snapshot.
+type DiffStat struct {
+	Files     int    `json:"files"`
+	Dirs      int    `json:"dirs"`
+	Others    int    `json:"others"`
+	DataBlobs int    `json:"data_blobs"`
+	TreeBlobs int    `json:"tree_blobs"`
+	Bytes     uint64 `json:"bytes"`
+}
+
+// DiffStatistics is printed by `restic diff --json` after all changes.
+type DiffStatistics struct {
+	MessageType    string   `json:"message_type"` // "statistics"
+	SourceSnapshot string   `json:"source_snapshot"`
+	TargetSnapshot string   `json:"target_snapshot"`
+	ChangedFiles   int      `json:"changed_files"`
+	Added          DiffStat `json:"added"`
+	Removed        DiffStat `json:"removed"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (s DiffStatistics) MarshalJSON() ([]byte, error) {
+	type statistics DiffStatistics
+	s.MessageType = MessageStatistics
+	return json.Marshal(statistics(s))
+}
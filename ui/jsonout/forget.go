@@ -0,0 +1,39 @@
+package jsonout
+
+import "github.com/konidev20/rapi/restic"
+
+// ForgetGroup is a group of snapshots as printed by `restic forget --json`.
+type ForgetGroup struct {
+	Tags    []string     `json:"tags"`
+	Host    string       `json:"host"`
+	Paths   []string     `json:"paths"`
+	Keep    []Snapshot   `json:"keep"`
+	Remove  []Snapshot   `json:"remove"`
+	Reasons []KeepReason `json:"reasons"`
+}
+
+// KeepReason describes why a snapshot was kept.
+type KeepReason struct {
+	Snapshot Snapshot `json:"snapshot"`
+	Matches  []string `json:"matches"`
+}
+
+// NewForgetGroup returns the JSON representation of the result of applying
+// a policy to the group of snapshots with the given key.
+func NewForgetGroup(key restic.SnapshotGroupKey, keep, remove restic.Snapshots, reasons []restic.KeepReason) ForgetGroup {
+	group := ForgetGroup{
+		Tags:    key.Tags,
+		Host:    key.Hostname,
+		Paths:   key.Paths,
+		Keep:    NewSnapshots(keep),
+		Remove:  NewSnapshots(remove),
+		Reasons: make([]KeepReason, 0, len(reasons)),
+	}
+	for _, r := range reasons {
+		group.Reasons = append(group.Reasons, KeepReason{
+			Snapshot: NewSnapshot(r.Snapshot),
+			Matches:  r.Matches,
+		})
+	}
+	return group
+}
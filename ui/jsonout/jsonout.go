@@ -0,0 +1,15 @@
+// Package jsonout contains the JSON messages printed by the restic command
+// line client, so that programs built on this library produce output which
+// existing scripts parsing restic's output understand. The marshalers set
+// the message_type of each message, it does not need to be filled in.
+package jsonout
+
+// Message types of the messages printed by restic.
+const (
+	MessageStatus     = "status"
+	MessageError      = "error"
+	MessageVerbose    = "verbose_status"
+	MessageSummary    = "summary"
+	MessageChange     = "change"
+	MessageStatistics = "statistics"
+)
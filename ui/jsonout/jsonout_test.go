@@ -0,0 +1,88 @@
+package jsonout_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/restic"
+	"github.com/konidev20/rapi/ui/jsonout"
+)
+
+func marshal(t *testing.T, v interface{}) map[string]interface{} {
+	buf, err := json.Marshal(v)
+	rtest.OK(t, err)
+	var m map[string]interface{}
+	rtest.OK(t, json.Unmarshal(buf, &m))
+	return m
+}
+
+func TestMessageType(t *testing.T) {
+	for _, test := range []struct {
+		v           interface{}
+		messageType string
+	}{
+		{jsonout.StatusUpdate{}, "status"},
+		{jsonout.ErrorUpdate{}, "error"},
+		{jsonout.VerboseUpdate{}, "verbose_status"},
+		{jsonout.BackupSummary{}, "summary"},
+		{&jsonout.BackupSummary{}, "summary"},
+		{jsonout.Change{}, "change"},
+		{jsonout.DiffStatistics{}, "statistics"},
+	} {
+		rtest.Equals(t, test.messageType, marshal(t, test.v)["message_type"])
+	}
+}
+
+func TestBackupSummary(t *testing.T) {
+	m := marshal(t, jsonout.BackupSummary{FilesNew: 2, DataAdded: 23, SnapshotID: "abc"})
+	rtest.Equals(t, 2.0, m["files_new"])
+	rtest.Equals(t, 23.0, m["data_added"])
+	rtest.Equals(t, "abc", m["snapshot_id"])
+	_, ok := m["dry_run"]
+	rtest.Assert(t, !ok, "dry_run is printed for a regular backup")
+
+	var s jsonout.BackupSummary
+	buf, err := json.Marshal(jsonout.BackupSummary{FilesChanged: 3})
+	rtest.OK(t, err)
+	rtest.OK(t, json.Unmarshal(buf, &s))
+	rtest.Equals(t, jsonout.BackupSummary{MessageType: "summary", FilesChanged: 3}, s)
+}
+
+func TestSnapshot(t *testing.T) {
+	sn, err := restic.NewSnapshot([]string{"/home"}, nil, "host", time.Unix(1700000000, 0).UTC())
+	rtest.OK(t, err)
+	repo := repository.TestRepository(t)
+	id, err := restic.SaveSnapshot(context.TODO(), repo, sn)
+	rtest.OK(t, err)
+	sn, err = restic.LoadSnapshot(context.TODO(), repo, id)
+	rtest.OK(t, err)
+
+	m := marshal(t, jsonout.NewSnapshot(sn))
+	rtest.Equals(t, id.String(), m["id"])
+	rtest.Equals(t, id.Str(), m["short_id"])
+	rtest.Equals(t, "host", m["hostname"])
+	rtest.Equals(t, []interface{}{"/home"}, m["paths"])
+
+	groups := jsonout.NewSnapshotGroups([]restic.SnapshotGroup{{
+		Key:       restic.SnapshotGroupKey{Hostname: "host"},
+		Snapshots: restic.Snapshots{sn},
+	}})
+	m = marshal(t, groups[0])
+	rtest.Equals(t, "host", m["group_key"].(map[string]interface{})["hostname"])
+	rtest.Equals(t, 1, len(m["snapshots"].([]interface{})))
+}
+
+func TestStatsCompression(t *testing.T) {
+	s := jsonout.Stats{TotalSize: 60, TotalUncompressedSize: 160, SnapshotsCount: 1}
+	s.SetCompression(40, 80)
+	rtest.Equals(t, 2.0, s.CompressionRatio)
+	rtest.Equals(t, 50.0, s.CompressionProgress)
+	rtest.Equals(t, 62.5, s.CompressionSpaceSaving)
+
+	m := marshal(t, jsonout.Stats{SnapshotsCount: 2})
+	rtest.Equals(t, map[string]interface{}{"total_size": 0.0, "snapshots_count": 2.0}, m)
+}
@@ -0,0 +1,39 @@
+package jsonout
+
+import "encoding/json"
+
+// RestoreStatus is printed periodically by `restic restore --json`.
+type RestoreStatus struct {
+	MessageType    string  `json:"message_type"` // "status"
+	SecondsElapsed uint64  `json:"seconds_elapsed,omitempty"`
+	PercentDone    float64 `json:"percent_done"`
+	TotalFiles     uint64  `json:"total_files,omitempty"`
+	FilesRestored  uint64  `json:"files_restored,omitempty"`
+	TotalBytes     uint64  `json:"total_bytes,omitempty"`
+	BytesRestored  uint64  `json:"bytes_restored,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (s RestoreStatus) MarshalJSON() ([]byte, error) {
+	type status RestoreStatus
+	s.MessageType = MessageStatus
+	return json.Marshal(status(s))
+}
+
+// RestoreSummary is printed by `restic restore --json` when the restore is
+// complete.
+type RestoreSummary struct {
+	MessageType    string `json:"message_type"` // "summary"
+	SecondsElapsed uint64 `json:"seconds_elapsed,omitempty"`
+	TotalFiles     uint64 `json:"total_files,omitempty"`
+	FilesRestored  uint64 `json:"files_restored,omitempty"`
+	TotalBytes     uint64 `json:"total_bytes,omitempty"`
+	BytesRestored  uint64 `json:"bytes_restored,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (s RestoreSummary) MarshalJSON() ([]byte, error) {
+	type summary RestoreSummary
+	s.MessageType = MessageSummary
+	return json.Marshal(summary(s))
+}
@@ -0,0 +1,50 @@
+package jsonout
+
+import "github.com/konidev20/rapi/restic"
+
+// Snapshot is a snapshot as printed by `restic snapshots --json`, which
+// adds the ID of the snapshot to its fields.
+type Snapshot struct {
+	*restic.Snapshot
+
+	ID      *restic.ID `json:"id"`
+	ShortID string     `json:"short_id"`
+}
+
+// NewSnapshot returns the JSON representation of sn. The ID of sn must be
+// set, which is the case for snapshots loaded from a repository.
+func NewSnapshot(sn *restic.Snapshot) Snapshot {
+	return Snapshot{
+		Snapshot: sn,
+		ID:       sn.ID(),
+		ShortID:  sn.ID().Str(),
+	}
+}
+
+// NewSnapshots returns the JSON representation of the snapshots.
+func NewSnapshots(list restic.Snapshots) []Snapshot {
+	snapshots := make([]Snapshot, 0, len(list))
+	for _, sn := range list {
+		snapshots = append(snapshots, NewSnapshot(sn))
+	}
+	return snapshots
+}
+
+// SnapshotGroup is a group of snapshots as printed by
+// `restic snapshots --json --group-by`.
+type SnapshotGroup struct {
+	GroupKey  restic.SnapshotGroupKey `json:"group_key"`
+	Snapshots []Snapshot              `json:"snapshots"`
+}
+
+// NewSnapshotGroups returns the JSON representation of the groups.
+func NewSnapshotGroups(groups []restic.SnapshotGroup) []SnapshotGroup {
+	list := make([]SnapshotGroup, 0, len(groups))
+	for _, g := range groups {
+		list = append(list, SnapshotGroup{
+			GroupKey:  g.Key,
+			Snapshots: NewSnapshots(g.Snapshots),
+		})
+	}
+	return list
+}
@@ -0,0 +1,28 @@
+package jsonout
+
+// Stats is printed by `restic stats --json`. Which fields are set depends on
+// the mode: the compression fields are only set in the raw-data mode, the
+// blob count only in the raw-data and blobs-per-file modes.
+type Stats struct {
+	TotalSize              uint64  `json:"total_size"`
+	TotalUncompressedSize  uint64  `json:"total_uncompressed_size,omitempty"`
+	CompressionRatio       float64 `json:"compression_ratio,omitempty"`
+	CompressionProgress    float64 `json:"compression_progress,omitempty"`
+	CompressionSpaceSaving float64 `json:"compression_space_saving,omitempty"`
+	TotalFileCount         uint64  `json:"total_file_count,omitempty"`
+	TotalBlobCount         uint64  `json:"total_blob_count,omitempty"`
+	SnapshotsCount         int     `json:"snapshots_count"`
+}
+
+// SetCompression computes the compression fields from the size of the
+// compressed blobs, stored and uncompressed. TotalSize and
+// TotalUncompressedSize must be set before.
+func (s *Stats) SetCompression(compressedSize, compressedUncompressedSize uint64) {
+	if compressedSize > 0 {
+		s.CompressionRatio = float64(compressedUncompressedSize) / float64(compressedSize)
+	}
+	if s.TotalUncompressedSize > 0 {
+		s.CompressionProgress = float64(compressedUncompressedSize) / float64(s.TotalUncompressedSize) * 100
+		s.CompressionSpaceSaving = (1 - float64(s.TotalSize)/float64(s.TotalUncompressedSize)) * 100
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/konidev20/rapi/ui"
+	"github.com/konidev20/rapi/ui/jsonout"
 )
 
 type jsonPrinter struct {
@@ -21,8 +22,17 @@ func (t *jsonPrinter) print(status interface{}) {
 }
 
 func (t *jsonPrinter) Update(filesFinished, filesTotal, allBytesWritten, allBytesTotal uint64, duration time.Duration) {
-	status := statusUpdate{
-		MessageType:    "status",
+	t.print(NewJSONStatus(filesFinished, filesTotal, allBytesWritten, allBytesTotal, duration))
+}
+
+func (t *jsonPrinter) Finish(filesFinished, filesTotal, allBytesWritten, allBytesTotal uint64, duration time.Duration) {
+	t.print(NewJSONSummary(filesFinished, filesTotal, allBytesWritten, allBytesTotal, duration))
+}
+
+// NewJSONStatus returns the status printed by `restic restore --json`.
+func NewJSONStatus(filesFinished, filesTotal, allBytesWritten, allBytesTotal uint64, duration time.Duration) jsonout.RestoreStatus {
+	status := jsonout.RestoreStatus{
+		MessageType:    jsonout.MessageStatus,
 		SecondsElapsed: uint64(duration / time.Second),
 		TotalFiles:     filesTotal,
 		FilesRestored:  filesFinished,
@@ -33,37 +43,17 @@ func (t *jsonPrinter) Update(filesFinished, filesTotal, allBytesWritten, allByte
 	if allBytesTotal > 0 {
 		status.PercentDone = float64(allBytesWritten) / float64(allBytesTotal)
 	}
-
-	t.print(status)
+	return status
 }
 
-func (t *jsonPrinter) Finish(filesFinished, filesTotal, allBytesWritten, allBytesTotal uint64, duration time.Duration) {
-	status := summaryOutput{
-		MessageType:    "summary",
+// NewJSONSummary returns the summary printed by `restic restore --json`.
+func NewJSONSummary(filesFinished, filesTotal, allBytesWritten, allBytesTotal uint64, duration time.Duration) jsonout.RestoreSummary {
+	return jsonout.RestoreSummary{
+		MessageType:    jsonout.MessageSummary,
 		SecondsElapsed: uint64(duration / time.Second),
 		TotalFiles:     filesTotal,
 		FilesRestored:  filesFinished,
 		TotalBytes:     allBytesTotal,
 		BytesRestored:  allBytesWritten,
 	}
-	t.print(status)
-}
-
-type statusUpdate struct {
-	MessageType    string  `json:"message_type"` // "status"
-	SecondsElapsed uint64  `json:"seconds_elapsed,omitempty"`
-	PercentDone    float64 `json:"percent_done"`
-	TotalFiles     uint64  `json:"total_files,omitempty"`
-	FilesRestored  uint64  `json:"files_restored,omitempty"`
-	TotalBytes     uint64  `json:"total_bytes,omitempty"`
-	BytesRestored  uint64  `json:"bytes_restored,omitempty"`
-}
-
-type summaryOutput struct {
-	MessageType    string `json:"message_type"` // "summary"
-	SecondsElapsed uint64 `json:"seconds_elapsed,omitempty"`
-	TotalFiles     uint64 `json:"total_files,omitempty"`
-	FilesRestored  uint64 `json:"files_restored,omitempty"`
-	TotalBytes     uint64 `json:"total_bytes,omitempty"`
-	BytesRestored  uint64 `json:"bytes_restored,omitempty"`
 }
@@ -0,0 +1,377 @@
+package rapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/konidev20/rapi/backend"
+	"github.com/konidev20/rapi/internal/checker"
+	"github.com/konidev20/rapi/internal/debug"
+	"github.com/konidev20/rapi/internal/errors"
+	"github.com/konidev20/rapi/pack"
+	"github.com/konidev20/rapi/restic"
+)
+
+// verifierBatchSize is the number of packs whose blobs are looked up in the
+// index at once.
+const verifierBatchSize = 64
+
+// VerifierOptions configure a Verifier.
+type VerifierOptions struct {
+	// PercentPerDay is the share of the pack data which is read and
+	// verified per day, e.g. 1 verifies all data within 100 days.
+	PercentPerDay float64
+
+	// MaxBytesPerSecond caps the average read rate, zero means no cap. If
+	// the cap is lower than the rate required by PercentPerDay, less data
+	// is verified per day.
+	MaxBytesPerSecond int64
+
+	// CursorPath is a local file which records the progress, so that a
+	// restarted Verifier continues with the next pack. Without a path, each
+	// Verifier starts at the first pack.
+	CursorPath string
+
+	// ReloadInterval is the time after which the index is loaded again to
+	// learn about new packs, it defaults to a day. The index is also loaded
+	// at the start of each pass.
+	ReloadInterval time.Duration
+
+	// OnPack is called after each verified pack, may be nil.
+	OnPack func(VerifiedPack)
+}
+
+// VerifiedPack is the outcome of the verification of a pack.
+type VerifiedPack struct {
+	ID   restic.ID
+	Size int64
+	// Pass is the number of the pass over all packs, starting with 1.
+	Pass int
+	// Err is set if the pack is damaged or could not be read.
+	Err error
+}
+
+// VerifierStatus describes the progress of a Verifier.
+type VerifierStatus struct {
+	// Pass is the number of the current pass over all packs, PassStart the
+	// time it started.
+	Pass      int
+	PassStart time.Time
+
+	// Cursor is the last pack verified in the current pass.
+	Cursor restic.ID
+
+	// VerifiedPacks and VerifiedBytes count the packs of the current pass,
+	// TotalBytes is the size of all packs in the index.
+	VerifiedPacks int
+	VerifiedBytes int64
+	TotalBytes    int64
+
+	// Errors is the number of damaged packs found by this Verifier.
+	Errors int
+
+	// BytesPerSecond is the current average read rate.
+	BytesPerSecond float64
+}
+
+// verifierCursor is the format of the cursor file.
+type verifierCursor struct {
+	Repository    string    `json:"repository"`
+	Pass          int       `json:"pass"`
+	PassStart     time.Time `json:"pass_start"`
+	Last          restic.ID `json:"last"`
+	VerifiedPacks int       `json:"verified_packs"`
+	VerifiedBytes int64     `json:"verified_bytes"`
+}
+
+type verifierPack struct {
+	id   restic.ID
+	size int64
+}
+
+// Verifier continuously reads and verifies the packs of a repository at a
+// limited rate, as an alternative to checking all data at once. The packs
+// are verified in the order of their IDs, once all packs were verified the
+// next pass starts. It does not lock the repository, packs removed by a
+// concurrent prune are skipped.
+type Verifier struct {
+	repo restic.Repository
+	opts VerifierOptions
+
+	m      sync.Mutex
+	cursor verifierCursor
+	status VerifierStatus
+}
+
+// NewVerifier returns a verifier for repo, the progress is read from the
+// cursor file.
+func NewVerifier(repo restic.Repository, opts VerifierOptions) (*Verifier, error) {
+	if opts.PercentPerDay <= 0 {
+		return nil, errors.Fatalf("invalid PercentPerDay %v", opts.PercentPerDay)
+	}
+	if opts.MaxBytesPerSecond < 0 {
+		return nil, errors.Fatalf("invalid MaxBytesPerSecond %v", opts.MaxBytesPerSecond)
+	}
+	if opts.ReloadInterval <= 0 {
+		opts.ReloadInterval = 24 * time.Hour
+	}
+
+	v := &Verifier{repo: repo, opts: opts}
+	cursor, err := v.loadCursor()
+	if err != nil {
+		return nil, err
+	}
+	v.cursor = cursor
+	v.updateStatus(nil)
+	return v, nil
+}
+
+func (v *Verifier) loadCursor() (verifierCursor, error) {
+	cursor := verifierCursor{Repository: v.repo.Config().ID}
+	if v.opts.CursorPath == "" {
+		return cursor, nil
+	}
+
+	buf, err := os.ReadFile(v.opts.CursorPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return cursor, nil
+	}
+	if err != nil {
+		return cursor, errors.Wrap(err, "ReadFile")
+	}
+	var saved verifierCursor
+	if err := json.Unmarshal(buf, &saved); err != nil {
+		return cursor, errors.Fatalf("invalid verifier cursor %v: %v", v.opts.CursorPath, err)
+	}
+	if saved.Repository != cursor.Repository {
+		debug.Log("cursor %v belongs to repository %v, starting over", v.opts.CursorPath, saved.Repository)
+		return cursor, nil
+	}
+	return saved, nil
+}
+
+// saveCursor writes the cursor to the file, it is replaced atomically so that
+// an interrupted Verifier does not lose its progress.
+func (v *Verifier) saveCursor(cursor verifierCursor) error {
+	if v.opts.CursorPath == "" {
+		return nil
+	}
+
+	buf, err := json.Marshal(cursor)
+	if err != nil {
+		return errors.Wrap(err, "Marshal")
+	}
+	tmp := filepath.Join(filepath.Dir(v.opts.CursorPath), "."+filepath.Base(v.opts.CursorPath)+".tmp")
+	if err := os.WriteFile(tmp, buf, 0600); err != nil {
+		return errors.Wrap(err, "WriteFile")
+	}
+	return errors.Wrap(os.Rename(tmp, v.opts.CursorPath), "Rename")
+}
+
+// Status returns the current progress.
+func (v *Verifier) Status() VerifierStatus {
+	v.m.Lock()
+	defer v.m.Unlock()
+	return v.status
+}
+
+// updateStatus copies the cursor to the status, packs is the current list of
+// packs if it is known.
+func (v *Verifier) updateStatus(packs []verifierPack) {
+	v.m.Lock()
+	defer v.m.Unlock()
+
+	v.status.Pass = v.cursor.Pass
+	v.status.PassStart = v.cursor.PassStart
+	v.status.Cursor = v.cursor.Last
+	v.status.VerifiedPacks = v.cursor.VerifiedPacks
+	v.status.VerifiedBytes = v.cursor.VerifiedBytes
+	if packs != nil {
+		v.status.TotalBytes = sumPackSizes(packs)
+		v.status.BytesPerSecond = v.rate(v.status.TotalBytes)
+	}
+}
+
+// rate returns the read rate in bytes per second for a repository with total
+// bytes of pack data.
+func (v *Verifier) rate(total int64) float64 {
+	rate := float64(total) * v.opts.PercentPerDay / 100 / (24 * 60 * 60)
+	if v.opts.MaxBytesPerSecond > 0 && rate > float64(v.opts.MaxBytesPerSecond) {
+		rate = float64(v.opts.MaxBytesPerSecond)
+	}
+	return rate
+}
+
+// loadPacks loads the index and returns the packs sorted by ID.
+func (v *Verifier) loadPacks(ctx context.Context) ([]verifierPack, error) {
+	if err := v.repo.LoadIndex(ctx, nil); err != nil {
+		return nil, err
+	}
+
+	sizes := pack.Size(ctx, v.repo.Index(), false)
+	packs := make([]verifierPack, 0, len(sizes))
+	for id, size := range sizes {
+		packs = append(packs, verifierPack{id: id, size: size})
+	}
+	sort.Slice(packs, func(i, j int) bool {
+		return bytes.Compare(packs[i].id[:], packs[j].id[:]) < 0
+	})
+	return packs, ctx.Err()
+}
+
+// Run verifies packs until ctx is cancelled, then it returns ctx.Err(). It
+// returns early if the index cannot be loaded or the cursor cannot be saved.
+// Damaged packs are reported to OnPack and counted in the status.
+func (v *Verifier) Run(ctx context.Context) error {
+	var packs []verifierPack
+	var loaded time.Time
+	var rate float64
+	next := time.Now()
+
+	for {
+		if packs == nil || time.Since(loaded) > v.opts.ReloadInterval {
+			var err error
+			packs, err = v.loadPacks(ctx)
+			if err != nil {
+				return err
+			}
+			loaded = time.Now()
+			rate = v.rate(sumPackSizes(packs))
+			v.updateStatus(packs)
+			debug.Log("verifying %d packs at %.0f bytes/s", len(packs), rate)
+		}
+
+		if len(packs) == 0 {
+			// nothing to verify, wait for new packs
+			if err := sleepUntil(ctx, time.Now().Add(v.opts.ReloadInterval)); err != nil {
+				return err
+			}
+			packs = nil
+			continue
+		}
+
+		if v.cursor.Pass == 0 {
+			v.startPass()
+		}
+
+		// the packs after the cursor
+		i := sort.Search(len(packs), func(i int) bool {
+			return bytes.Compare(packs[i].id[:], v.cursor.Last[:]) > 0
+		})
+		if i == len(packs) {
+			debug.Log("pass %d complete", v.cursor.Pass)
+			v.startPass()
+			packs = nil
+			continue
+		}
+
+		batch := packs[i:]
+		if len(batch) > verifierBatchSize {
+			batch = batch[:verifierBatchSize]
+		}
+		if err := v.verifyBatch(ctx, batch, rate, &next); err != nil {
+			return err
+		}
+	}
+}
+
+func sumPackSizes(packs []verifierPack) int64 {
+	var total int64
+	for _, p := range packs {
+		total += p.size
+	}
+	return total
+}
+
+// startPass resets the cursor for the next pass.
+func (v *Verifier) startPass() {
+	v.cursor = verifierCursor{
+		Repository: v.cursor.Repository,
+		Pass:       v.cursor.Pass + 1,
+		PassStart:  time.Now(),
+	}
+	v.updateStatus(nil)
+}
+
+// verifyBatch verifies the packs, the start of each read is delayed until
+// next so that the average rate is kept.
+func (v *Verifier) verifyBatch(ctx context.Context, batch []verifierPack, rate float64, next *time.Time) error {
+	ids := restic.NewIDSet()
+	for _, p := range batch {
+		ids.Insert(p.id)
+	}
+	blobs := make(map[restic.ID][]restic.Blob, len(batch))
+	for pbs := range v.repo.Index().ListPacks(ctx, ids) {
+		blobs[pbs.PackID] = pbs.Blobs
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	for _, p := range batch {
+		if err := sleepUntil(ctx, *next); err != nil {
+			return err
+		}
+		start := time.Now()
+		if rate > 0 {
+			*next = start.Add(time.Duration(float64(p.size) / rate * float64(time.Second)))
+		}
+
+		err := checker.CheckPack(ctx, v.repo, p.id, blobs[p.id], p.size)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil && v.removed(ctx, p.id) {
+			debug.Log("pack %v was removed", p.id)
+			err = nil
+		}
+
+		v.cursor.Last = p.id
+		v.cursor.VerifiedPacks++
+		v.cursor.VerifiedBytes += p.size
+		if err := v.saveCursor(v.cursor); err != nil {
+			return err
+		}
+		v.updateStatus(nil)
+		if err != nil {
+			debug.Log("pack %v is damaged: %v", p.id, err)
+			v.m.Lock()
+			v.status.Errors++
+			v.m.Unlock()
+		}
+
+		if v.opts.OnPack != nil {
+			v.opts.OnPack(VerifiedPack{ID: p.id, Size: p.size, Pass: v.cursor.Pass, Err: err})
+		}
+	}
+	return nil
+}
+
+// removed returns true if the pack id no longer exists, e.g. because it was
+// removed by prune since the index was loaded.
+func (v *Verifier) removed(ctx context.Context, id restic.ID) bool {
+	_, err := v.repo.Backend().Stat(ctx, backend.Handle{Type: restic.PackFile, Name: id.String()})
+	return err != nil && v.repo.Backend().IsNotExist(err)
+}
+
+// sleepUntil waits until t or until ctx is cancelled.
+func sleepUntil(ctx context.Context, t time.Time) error {
+	d := time.Until(t)
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,101 @@
+package rapi_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/konidev20/rapi"
+	"github.com/konidev20/rapi/backend"
+	rtest "github.com/konidev20/rapi/internal/test"
+	"github.com/konidev20/rapi/repository"
+	"github.com/konidev20/rapi/restic"
+)
+
+// runVerifier runs v until n packs were verified and returns them.
+func runVerifier(t *testing.T, repo restic.Repository, opts rapi.VerifierOptions, n int) []rapi.VerifiedPack {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var verified []rapi.VerifiedPack
+	opts.OnPack = func(p rapi.VerifiedPack) {
+		verified = append(verified, p)
+		if len(verified) == n {
+			cancel()
+		}
+	}
+	v, err := rapi.NewVerifier(repo, opts)
+	rtest.OK(t, err)
+	err = v.Run(ctx)
+	rtest.Assert(t, errors.Is(err, context.Canceled), "unexpected error %v", err)
+	return verified
+}
+
+func TestVerifier(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.TestRepository(t)
+	packs := restic.NewIDSet()
+	for i := 0; i < 4; i++ {
+		id, _ := savePack(t, repo, i)
+		packs.Insert(id)
+	}
+
+	_, err := rapi.NewVerifier(repo, rapi.VerifierOptions{})
+	rtest.Assert(t, err != nil, "missing PercentPerDay accepted")
+
+	// the rate is high enough to not wait between the packs
+	opts := rapi.VerifierOptions{
+		PercentPerDay: 1e9,
+		CursorPath:    filepath.Join(rtest.TempDir(t), "cursor"),
+	}
+	verified := runVerifier(t, repo, opts, 3)
+	seen := restic.NewIDSet()
+	for i, p := range verified {
+		rtest.OK(t, p.Err)
+		rtest.Equals(t, 1, p.Pass)
+		rtest.Assert(t, packs.Has(p.ID), "unknown pack %v verified", p.ID)
+		if i > 0 {
+			rtest.Assert(t, verified[i-1].ID.String() < p.ID.String(), "packs not verified in order")
+		}
+		seen.Insert(p.ID)
+	}
+
+	// a new verifier continues after the cursor and starts the next pass
+	// once all packs were verified
+	verified = runVerifier(t, repo, opts, 2)
+	rtest.Assert(t, !seen.Has(verified[0].ID), "pack %v verified twice in a pass", verified[0].ID)
+	rtest.Equals(t, 1, verified[0].Pass)
+	rtest.Equals(t, 2, verified[1].Pass)
+
+	v, err := rapi.NewVerifier(repo, opts)
+	rtest.OK(t, err)
+	status := v.Status()
+	rtest.Equals(t, 2, status.Pass)
+	rtest.Equals(t, 1, status.VerifiedPacks)
+	rtest.Equals(t, verified[1].ID, status.Cursor)
+
+	// a damaged pack is reported
+	damaged := verified[1].ID
+	h := backend.Handle{Type: restic.PackFile, Name: damaged.String()}
+	be := repo.Backend()
+	var buf []byte
+	rtest.OK(t, be.Load(ctx, h, 0, 0, func(rd io.Reader) error {
+		buf, err = io.ReadAll(rd)
+		return err
+	}))
+	buf[0] ^= 0xff
+	rtest.OK(t, be.Remove(ctx, h))
+	rtest.OK(t, be.Save(ctx, h, backend.NewByteReader(buf, be.Hasher())))
+
+	opts.CursorPath = ""
+	var errs int
+	for _, p := range runVerifier(t, repo, opts, 4) {
+		if p.Err != nil {
+			rtest.Equals(t, damaged, p.ID)
+			errs++
+		}
+	}
+	rtest.Equals(t, 1, errs)
+}